@@ -0,0 +1,161 @@
+package main
+
+import (
+	"os"
+	"fmt"
+	"flag"
+	"time"
+	"errors"
+	"strings"
+
+	"github.com/fallowu/big-ssdb/internal/link"
+)
+
+const defaultTimeout = 5 * time.Second
+
+func call(addr string, ps ...string) ([]string, error) {
+	c, err := link.Dial(addr, defaultTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+	return c.Call(ps...)
+}
+
+// id, raft addr and priority of the node serving clients at addr
+func nodeInfo(addr string) (id string, raftAddr string, priority string, err error) {
+	rs, err := call(addr, "info")
+	if err != nil {
+		return "", "", "", err
+	}
+	if len(rs) == 0 {
+		return "", "", "", errors.New("empty info from " + addr)
+	}
+	for _, line := range strings.Split(rs[0], "\n") {
+		ps := strings.SplitN(line, ": ", 2)
+		if len(ps) != 2 {
+			continue
+		}
+		switch ps[0] {
+		case "id":
+			id = ps[1]
+		case "addr":
+			raftAddr = ps[1]
+		case "priority":
+			priority = ps[1]
+		}
+	}
+	if id == "" || raftAddr == "" {
+		return "", "", "", errors.New("bad info from " + addr)
+	}
+	return id, raftAddr, priority, nil
+}
+
+//	big-ssdb join -leader 127.0.0.1:9001 127.0.0.1:9002
+//
+// Addresses are client addresses, raft ids and addresses are asked from the
+// nodes.
+func runJoin(args []string) error {
+	fs := flag.NewFlagSet("join", flag.ExitOnError)
+	leader := fs.String("leader", "127.0.0.1:9001", "client address of the leader")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return errors.New("usage: big-ssdb join -leader host:port host:port")
+	}
+	addr := fs.Arg(0)
+
+	leaderId, leaderRaft, _, err := nodeInfo(*leader)
+	if err != nil {
+		return err
+	}
+	// as configured by -priority of the node
+	id, raftAddr, priority, err := nodeInfo(addr)
+	if err != nil {
+		return err
+	}
+	rs, err := call(*leader, "addmember", id, raftAddr)
+	if err != nil {
+		return fmt.Errorf("addmember: %s", err)
+	}
+	fmt.Println("addmember", id, raftAddr, strings.Join(rs, " "))
+
+	// not replied
+	c, err := link.Dial(addr, defaultTimeout)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	if err := c.Send("joingroup", leaderId, leaderRaft); err != nil {
+		return err
+	}
+	fmt.Println("joingroup", leaderId, leaderRaft)
+
+	if priority == "" || priority == "0" {
+		return nil
+	}
+	// after AddMember is committed, which needs the node joined
+	for deadline := time.Now().Add(10 * defaultTimeout); ; time.Sleep(100 * time.Millisecond) {
+		rs, err = call(*leader, "setpriority", id, priority)
+		if err == nil || err.Error() != "config change in progress" || time.Now().After(deadline) {
+			break
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("setpriority: %s", err)
+	}
+	fmt.Println("setpriority", id, priority, strings.Join(rs, " "))
+	return nil
+}
+
+func fetchSnapshot(addr string) (string, error) {
+	rs, err := call(addr, "makesnapshot")
+	if err != nil {
+		return "", err
+	}
+	if len(rs) == 0 {
+		return "", nil
+	}
+	return rs[0], nil
+}
+
+//	big-ssdb snapshot -addr 127.0.0.1:9001
+//
+// The snapshot is written to the node's data directory.
+func runSnapshot(args []string) error {
+	fs := flag.NewFlagSet("snapshot", flag.ExitOnError)
+	addr := fs.String("addr", "127.0.0.1:9001", "client address of the node")
+	fs.Parse(args)
+
+	data, err := fetchSnapshot(*addr)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("snapshot made, %d bytes\n", len(data))
+	return nil
+}
+
+//	big-ssdb backup -addr 127.0.0.1:9001 -o backup.db
+func runBackup(args []string) error {
+	fs := flag.NewFlagSet("backup", flag.ExitOnError)
+	addr := fs.String("addr", "127.0.0.1:9001", "client address of the node")
+	out := fs.String("o", "", "output file, defaults to backup-<time>.db")
+	fs.Parse(args)
+
+	if *out == "" {
+		*out = fmt.Sprintf("backup-%s.db", time.Now().Format("20060102-150405"))
+	}
+	data, err := fetchSnapshot(*addr)
+	if err != nil {
+		return err
+	}
+	// not an incomplete file under the final name
+	tmp := *out + ".tmp"
+	if err := os.WriteFile(tmp, []byte(data), 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, *out); err != nil {
+		return err
+	}
+	fmt.Printf("%s, %d bytes\n", *out, len(data))
+	return nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"flag"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/fallowu/big-ssdb/internal/link"
+)
+
+//	big-ssdb bench -addr 127.0.0.1:9001 -n 1000 -c 1
+func runBench(args []string) error {
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	addr := fs.String("addr", "127.0.0.1:9001", "client address of the leader")
+	num := fs.Int("n", 1000, "requests per connection")
+	conns := fs.Int("c", 1, "connections")
+	fs.Parse(args)
+
+	clients := make([]*link.Client, *conns)
+	for i := range clients {
+		c, err := link.Dial(*addr, defaultTimeout)
+		if err != nil {
+			return err
+		}
+		defer c.Close()
+		clients[i] = c
+	}
+
+	log.Println("start")
+	var wg sync.WaitGroup
+	errs := make(chan error, *conns)
+	start := time.Now()
+	for i, c := range clients {
+		wg.Add(1)
+		go func(i int, c *link.Client) {
+			defer wg.Done()
+			for j := 0; j < *num; j++ {
+				key := fmt.Sprintf("k%d-%04d", i, j)
+				if _, err := c.Call("set", key, fmt.Sprintf("%d", j)); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}(i, c)
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+	close(errs)
+	if err := <-errs; err != nil {
+		return err
+	}
+	total := *num * *conns
+	qps := int(float64(total) / elapsed.Seconds())
+	log.Printf("time: %f, num: %d, qps: %d", elapsed.Seconds(), total, qps)
+	return nil
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/fallowu/big-ssdb/internal/app"
+)
+
+//	big-ssdb server -config node.conf -port 8001 -peers 8002=127.0.0.1:8002
+//
+// Lines of the config file are `flag = value`, flags on the command line win.
+func runServer(args []string) error {
+	fs := flag.NewFlagSet("server", flag.ExitOnError)
+	conf := app.DefaultConfig()
+	conf.RegisterFlags(fs)
+	path := fs.String("config", "", "config file")
+	fs.Parse(args)
+	if *path != "" {
+		if err := app.LoadConfigFile(fs, *path); err != nil {
+			return err
+		}
+	}
+
+	stop := make(chan struct{})
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sig
+		close(stop)
+	}()
+	return app.Run(conf, stop)
+}
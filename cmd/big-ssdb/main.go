@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+)
+
+type command struct{
+	name string
+	usage string
+	run func(args []string) error
+}
+
+var commands = []command{
+	{"server", "run a node", runServer},
+	{"join", "add a node to the group of a leader", runJoin},
+	{"snapshot", "make a snapshot on a node", runSnapshot},
+	{"backup", "copy a snapshot of a node's data to a local file", runBackup},
+	{"bench", "sequential writes against a node", runBench},
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: big-ssdb <command> [flags]\n\ncommands:\n")
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", c.name, c.usage)
+	}
+	fmt.Fprintf(os.Stderr, "\nbig-ssdb <command> -h for flags of a command\n")
+}
+
+func main(){
+	log.SetFlags(log.LstdFlags | log.Lshortfile | log.Lmicroseconds)
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	name := os.Args[1]
+	for _, c := range commands {
+		if c.name == name {
+			if err := c.run(os.Args[2:]); err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
+	}
+	if name != "-h" && name != "help" {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", name)
+	}
+	usage()
+	os.Exit(2)
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"log"
+	"os"
+	"strings"
+
+	"github.com/fallowu/big-ssdb/internal/jepsen"
+)
+
+// Client side of Jepsen-style tests, see docker/jepsen/README.md
+//
+//	jepsen-adapter n1=10.5.0.11:9001,n2=10.5.0.12:9001 < ops.jsonl > history.jsonl
+func main(){
+	log.SetFlags(log.LstdFlags | log.Lshortfile | log.Lmicroseconds)
+	if len(os.Args) != 2 {
+		log.Fatal("usage: jepsen-adapter id=host:port,...")
+	}
+	nodes := make(map[string]string)
+	for _, p := range strings.Split(os.Args[1], ",") {
+		ps := strings.SplitN(p, "=", 2)
+		if len(ps) != 2 {
+			log.Fatal("bad node: ", p)
+		}
+		nodes[ps[0]] = ps[1]
+	}
+	if err := jepsen.NewAdapter(nodes).Run(os.Stdin, os.Stdout); err != nil {
+		log.Fatal(err)
+	}
+}
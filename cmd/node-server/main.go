@@ -0,0 +1,33 @@
+package main
+
+import (
+	"log"
+	"os"
+	"strconv"
+
+	"github.com/fallowu/big-ssdb/internal/app"
+)
+
+// node-server [port], kept for docker/jepsen and scripts, see cmd/big-ssdb
+// for the flag driven server. NODE_ID, HOST and PEERS override the defaults,
+// other tunables are listed at app.Run().
+func main(){
+	log.SetFlags(log.LstdFlags | log.Lshortfile | log.Lmicroseconds)
+
+	conf := app.DefaultConfig()
+	if len(os.Args) > 1 {
+		conf.Port, _ = strconv.Atoi(os.Args[1])
+	}
+	conf.Id = os.Getenv("NODE_ID")
+	if h := os.Getenv("HOST"); h != "" {
+		conf.Host = h
+	}
+	conf.Peers = os.Getenv("PEERS")
+	if conf.Peers == "" {
+		// testing
+		conf.Peers = "8001=127.0.0.1:8001,8002=127.0.0.1:8002"
+	}
+	if err := app.Run(conf, nil); err != nil {
+		log.Fatal(err)
+	}
+}
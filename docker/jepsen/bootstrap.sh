@@ -0,0 +1,15 @@
+#!/bin/sh
+# Form the group of n1..n5 with n1 as the first leader, run in control:
+#   docker compose exec control sh /jepsen/bootstrap.sh
+set -e
+
+send() {
+	printf '%s\n' "$2" | nc -q 1 "$1" 9001
+	sleep 1
+}
+
+send 10.5.0.11 "AddMember n1 10.5.0.11:8001"
+for i in 2 3 4 5; do
+	send 10.5.0.11 "AddMember n$i 10.5.0.1$i:8001"
+	send 10.5.0.1$i "JoinGroup n1 10.5.0.11:8001"
+done
@@ -0,0 +1,44 @@
+#!/bin/sh
+# Faults for the test driver, run on the docker host:
+#   nemesis.sh partition n1 n2    cut n1 and n2 from the other nodes
+#   nemesis.sh heal               remove all partitions
+#   nemesis.sh kill n3            SIGKILL the node, data is kept
+#   nemesis.sh start n3
+#   nemesis.sh pause n4 | resume n4
+set -e
+cd "$(dirname "$0")"
+
+ip() {
+	echo "10.5.0.1${1#n}"
+}
+
+case "$1" in
+partition)
+	shift
+	for a in "$@"; do
+		for b in n1 n2 n3 n4 n5; do
+			case " $* " in *" $b "*) continue;; esac
+			docker compose exec -T "$a" iptables -A INPUT -s "$(ip "$b")" -j DROP
+			docker compose exec -T "$b" iptables -A INPUT -s "$(ip "$a")" -j DROP
+		done
+	done
+	;;
+heal)
+	for n in n1 n2 n3 n4 n5; do
+		docker compose exec -T "$n" iptables -F INPUT
+	done
+	;;
+kill)
+	docker compose kill "$2"
+	;;
+start)
+	docker compose start "$2"
+	;;
+pause|resume)
+	docker compose "$(echo "$1" | sed 's/resume/unpause/')" "$2"
+	;;
+*)
+	echo "unknown fault: $1" >&2
+	exit 1
+	;;
+esac
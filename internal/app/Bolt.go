@@ -0,0 +1,6 @@
+//go:build bolt
+
+package app
+
+// DB=bolt, see store/boltdb
+import _ "github.com/fallowu/big-ssdb/store/boltdb"
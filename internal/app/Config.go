@@ -0,0 +1,132 @@
+package app
+
+import (
+	"os"
+	"fmt"
+	"flag"
+	"bufio"
+	"strings"
+)
+
+// Addresses and layout of one node, tunables not listed here are still read
+// from the environment, see Run()
+type Config struct{
+	Id string
+	// ip to listen on and be reached at by peers and clients
+	Host string
+	// raft(udp), client(tcp) and admin(http) ports, 0 derives the latter two
+	// from Port: Port+1000 and Port+2000
+	Port int
+	ClientPort int
+	AdminPort int
+	// defaults to ./tmp/<Id>
+	DataDir string
+	// e.g. n1=10.5.0.11:8001,n2=10.5.0.12:8001
+	Peers string
+	// make this node a one-member group if it is not in a group yet
+	Bootstrap bool
+	// election priority, higher campaigns first, used when the node is added
+	// to a group, see raft/Priority.go
+	Priority int
+	// vote and ack entries without keeping data, never leader, see
+	// raft/Witness.go
+	Witness bool
+}
+
+func DefaultConfig() *Config {
+	conf := new(Config)
+	conf.Host = "127.0.0.1"
+	conf.Port = 8001
+	return conf
+}
+
+// Registers conf's fields as flags of fs, current values are the defaults
+func (conf *Config)RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&conf.Id, "id", conf.Id, "node id, defaults to the raft port")
+	fs.StringVar(&conf.Host, "host", conf.Host, "ip to listen on and be reached at")
+	fs.IntVar(&conf.Port, "port", conf.Port, "raft port")
+	fs.IntVar(&conf.ClientPort, "client-port", conf.ClientPort, "client port, defaults to port+1000")
+	fs.IntVar(&conf.AdminPort, "admin-port", conf.AdminPort, "admin port, defaults to port+2000")
+	fs.StringVar(&conf.DataDir, "data", conf.DataDir, "data directory, defaults to ./tmp/<id>")
+	fs.StringVar(&conf.Peers, "peers", conf.Peers, "addresses of peers, id=host:port,...")
+	fs.BoolVar(&conf.Bootstrap, "bootstrap", conf.Bootstrap, "start a new group if not in one")
+	fs.IntVar(&conf.Priority, "priority", conf.Priority, "election priority, higher campaigns first")
+	fs.BoolVar(&conf.Witness, "witness", conf.Witness, "vote without keeping data, never leader")
+}
+
+// Fills in derived values
+func (conf *Config)Normalize() {
+	if conf.Id == "" {
+		conf.Id = fmt.Sprintf("%d", conf.Port)
+	}
+	if conf.ClientPort == 0 {
+		conf.ClientPort = conf.Port + 1000
+	}
+	if conf.AdminPort == 0 {
+		conf.AdminPort = conf.Port + 2000
+	}
+	if conf.DataDir == "" {
+		conf.DataDir = "./tmp/" + conf.Id
+	}
+}
+
+// id -> addr
+func (conf *Config)PeerMap() (map[string]string, error) {
+	ret := make(map[string]string)
+	if conf.Peers == "" {
+		return ret, nil
+	}
+	for _, p := range strings.Split(conf.Peers, ",") {
+		ps := strings.SplitN(p, "=", 2)
+		if len(ps) != 2 || ps[0] == "" || ps[1] == "" {
+			return nil, fmt.Errorf("bad peer: %q", p)
+		}
+		ret[ps[0]] = ps[1]
+	}
+	return ret, nil
+}
+
+// Sets flags of fs from a file of `name = value` lines, # starts a comment.
+// Flags already given on the command line are kept.
+func LoadConfigFile(fs *flag.FlagSet, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag){
+		set[f.Name] = true
+	})
+
+	scanner := bufio.NewScanner(f)
+	lineno := 0
+	for scanner.Scan() {
+		lineno ++
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[0 : i]
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		ps := strings.SplitN(line, "=", 2)
+		if len(ps) != 2 {
+			return fmt.Errorf("%s:%d: expect name = value", path, lineno)
+		}
+		name := strings.TrimSpace(ps[0])
+		val := strings.TrimSpace(ps[1])
+		if fs.Lookup(name) == nil {
+			return fmt.Errorf("%s:%d: unknown option %q", path, lineno, name)
+		}
+		if set[name] {
+			continue
+		}
+		if err := fs.Set(name, val); err != nil {
+			return fmt.Errorf("%s:%d: %s", path, lineno, err)
+		}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,33 @@
+package app
+
+import (
+	"os"
+	"flag"
+	"testing"
+)
+
+func TestLoadConfigFile(t *testing.T){
+	path := t.TempDir() + "/node.conf"
+	os.WriteFile(path, []byte("# comment\nid = n1\nport = 8101 # raft\npeers = n2=127.0.0.1:8102\n"), 0644)
+
+	fs := flag.NewFlagSet("server", flag.ContinueOnError)
+	conf := DefaultConfig()
+	conf.RegisterFlags(fs)
+	fs.Parse([]string{"-id", "cli"})
+	if err := LoadConfigFile(fs, path); err != nil {
+		t.Fatal(err)
+	}
+	conf.Normalize()
+	if conf.Id != "cli" || conf.Port != 8101 || conf.ClientPort != 9101 || conf.AdminPort != 10101 {
+		t.Fatal("bad config", conf)
+	}
+	peers, err := conf.PeerMap()
+	if err != nil || peers["n2"] != "127.0.0.1:8102" {
+		t.Fatal("bad peers", peers, err)
+	}
+
+	os.WriteFile(path, []byte("nosuch = 1\n"), 0644)
+	if err := LoadConfigFile(fs, path); err == nil {
+		t.Fatal("unknown option accepted")
+	}
+}
@@ -0,0 +1,6 @@
+//go:build grpc
+
+package app
+
+// TRANSPORT=grpc, see raft/grpctransport
+import _ "github.com/fallowu/big-ssdb/raft/grpctransport"
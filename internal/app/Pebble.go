@@ -0,0 +1,6 @@
+//go:build pebble
+
+package app
+
+// DB=pebble, see store/pebbledb
+import _ "github.com/fallowu/big-ssdb/store/pebbledb"
@@ -0,0 +1,309 @@
+package app
+
+import (
+	"crypto/tls"
+	"fmt"
+	"errors"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"path/filepath"
+
+	"github.com/fallowu/big-ssdb/raft"
+	"github.com/fallowu/big-ssdb/store"
+	"github.com/fallowu/big-ssdb/internal/link"
+	"github.com/fallowu/big-ssdb/internal/server"
+	"github.com/fallowu/big-ssdb/logger"
+	"github.com/fallowu/big-ssdb/trace"
+)
+
+// Wires raft transport, store and service of one node and serves until stop
+// is closed. Tunables come from the environment:
+//
+//	LOG_LEVEL=info,raft=debug,transport=warn
+//	LOG_PACKETS=sample=100,maxlen=256,redact
+//	TRANSPORT=udp|tcp, UDP_READERS, UDP_QUEUE, TLS_CERT, TLS_KEY, TLS_CA, TLS_MUTUAL=1
+//	FAULT_RULES="drop peer=8002 p=0.1;delay delay=200ms"
+//	RECORD_FILE, EVENT_WEBHOOK, MIN_FREE_MB, INVARIANTS=alert|panic, APPLY_CHECK=1
+//	RECV_QUEUE, SEND_QUEUE, QUEUE_OVERFLOW=drop|block, PROPOSE_WINDOW=1ms
+//	MAX_INFLIGHT, PROPOSE_RATE, MAX_APPLY_BACKLOG, ADMISSION_OVERFLOW=drop|block
+//	DB=kv|mem|pebble|bolt, FSYNC=always|everysec|never
+//	SNAPSHOT_PORT, SNAPSHOT_INTERVAL=10m, SNAPSHOT_RETAIN
+//	OTLP_ENDPOINT, OTLP_SAMPLE_RATIO, ADMIN_DEBUG, ADMIN_TOKEN
+func Run(conf *Config, stop <-chan struct{}) error {
+	conf.Normalize()
+	if !logger.Configure(os.Getenv("LOG_LEVEL")) {
+		return fmt.Errorf("bad LOG_LEVEL: %s", os.Getenv("LOG_LEVEL"))
+	}
+	if !logger.ConfigurePackets(os.Getenv("LOG_PACKETS")) {
+		return fmt.Errorf("bad LOG_PACKETS: %s", os.Getenv("LOG_PACKETS"))
+	}
+	log := logger.New("main").With("node", conf.Id)
+	peers, err := conf.PeerMap()
+	if err != nil {
+		return err
+	}
+	base_dir, err := filepath.Abs(conf.DataDir)
+	if err != nil {
+		return err
+	}
+
+	/////////////////////////////////////
+
+	log.Info("Raft server started", "port", conf.Port)
+	// DB=kv|mem|pebble|bolt or one registered by store.RegisterDb(), each in its own
+	// directory
+	backend := os.Getenv("DB")
+	db_dir := base_dir + "/raft"
+	if backend == "" {
+		backend = "kv"
+	} else if backend != "kv" {
+		db_dir += "-" + backend
+	}
+	db, err := store.OpenDb(backend, db_dir)
+	if err != nil {
+		return err
+	}
+	// entries, moved from db on the first start
+	logdb, err := store.OpenLogStore(base_dir + "/log", 0)
+	if err != nil {
+		return err
+	}
+	// TRANSPORT=udp|tcp or one registered by raft.RegisterTransport(),
+	// configured by env, e.g. UDP_READERS, BATCH_WINDOW=1ms, TLS_CERT
+	transport := os.Getenv("TRANSPORT")
+	if transport == "" {
+		transport = "udp"
+		if os.Getenv("TLS_CERT") != "" {
+			transport = "tcp"
+		}
+	}
+	raft_xport, err := raft.NewTransport(transport, raft.TransportOptions{
+		Host: conf.Host,
+		Port: conf.Port,
+		Param: os.Getenv,
+	})
+	if err != nil {
+		return err
+	}
+	defer raft_xport.Close()
+	// testing
+	if rules := os.Getenv("FAULT_RULES"); rules != "" {
+		faulty := raft.NewFaultTransport(raft_xport, time.Now().UnixNano())
+		for _, spec := range strings.Split(rules, ";") {
+			r, err := raft.ParseFaultRule(spec)
+			if err != nil {
+				return err
+			}
+			faulty.AddRule(r)
+		}
+		raft_xport = faulty
+	}
+	// messages sent and received, for raft.ReplayRecords()
+	if path := os.Getenv("RECORD_FILE"); path != "" {
+		rec, err := raft.NewRecordTransport(raft_xport, path)
+		if err != nil {
+			return err
+		}
+		defer rec.Close()
+		raft_xport = rec
+	}
+	// FSYNC=always|everysec|never
+	fsync, err := raft.ParseFsyncPolicy(os.Getenv("FSYNC"))
+	if err != nil {
+		return err
+	}
+	opts := []raft.Option{raft.WithLogDb(logdb), raft.WithFsyncPolicy(fsync), raft.WithPriority(conf.Priority)}
+	if conf.Witness {
+		opts = append(opts, raft.WithWitness())
+	}
+	// snapshots pulled from leader's SNAPSHOT_PORT are written to <data>/stream,
+	// over TLS with TLS_CERT, the server requiring members' certificates
+	var stream_tls *tls.Config
+	if cert := os.Getenv("TLS_CERT"); cert != "" {
+		stream_tls, err = raft.LoadTLSConfig(cert, os.Getenv("TLS_KEY"), os.Getenv("TLS_CA"), true)
+		if err != nil {
+			return err
+		}
+	}
+	stream_dir := base_dir + "/stream"
+	opts = append(opts, raft.WithSnapshotPull(stream_dir, stream_tls))
+	node := raft.NewNode(conf.Id, raft_xport.Addr(), db, opts...)
+	// large snapshots are pulled by followers from SNAPSHOT_PORT
+	if port, _ := strconv.Atoi(os.Getenv("SNAPSHOT_PORT")); port > 0 {
+		snapshots, err := raft.NewSnapshotServer(net.JoinHostPort(conf.Host, strconv.Itoa(port)), stream_dir, stream_tls)
+		if err != nil {
+			return err
+		}
+		defer snapshots.Close()
+		node.SetSnapshotServer(snapshots)
+	}
+	// snap-<term>-<index>.snap files in <data>/snapshot, the latest
+	// SNAPSHOT_RETAIN of them are kept
+	if v := os.Getenv("SNAPSHOT_INTERVAL"); v != "" {
+		interval, err := time.ParseDuration(v)
+		if err != nil || interval <= 0 {
+			return fmt.Errorf("bad SNAPSHOT_INTERVAL: %s", v)
+		}
+		retain, _ := strconv.Atoi(os.Getenv("SNAPSHOT_RETAIN"))
+		snapdir, err := raft.OpenSnapshotDir(base_dir + "/snapshot", retain)
+		if err != nil {
+			return err
+		}
+		go saveSnapshots(node, snapdir, interval, stop)
+	}
+	audit, err := raft.OpenFileAuditLog(base_dir + "/audit.log")
+	if err != nil {
+		return err
+	}
+	node.SetAuditLog(audit)
+	// POST raft events(elections, lagging members...) as JSON
+	if url := os.Getenv("EVENT_WEBHOOK"); url != "" {
+		node.AddEventSink(server.NewWebhookSink(url))
+	}
+	// enter read-only mode when free space below MIN_FREE_MB
+	minFree, _ := strconv.ParseUint(os.Getenv("MIN_FREE_MB"), 10, 64)
+	node.SetDataDir(base_dir, minFree * 1024 * 1024)
+	mode, err := raft.ParseInvariantMode(os.Getenv("INVARIANTS"))
+	if err != nil {
+		return err
+	}
+	node.SetInvariantMode(mode)
+	// compares apply results of followers with leader's
+	node.SetApplyCheck(os.Getenv("APPLY_CHECK") != "")
+	queues := raft.DefaultQueueConfig()
+	queues.RecvSize, _ = strconv.Atoi(os.Getenv("RECV_QUEUE"))
+	queues.SendSize, _ = strconv.Atoi(os.Getenv("SEND_QUEUE"))
+	queues.Overflow, err = raft.ParseOverflowPolicy(os.Getenv("QUEUE_OVERFLOW"))
+	if err != nil {
+		return err
+	}
+	node.SetQueueConfig(queues)
+	// MAX_INFLIGHT=entries, PROPOSE_RATE=bytes/s, MAX_APPLY_BACKLOG=entries,
+	// ADMISSION_OVERFLOW=drop|block
+	admission := raft.AdmissionConfig{}
+	admission.MaxInflight, _ = strconv.ParseInt(os.Getenv("MAX_INFLIGHT"), 10, 64)
+	admission.MaxApplyBacklog, _ = strconv.ParseInt(os.Getenv("MAX_APPLY_BACKLOG"), 10, 64)
+	admission.BytesPerSecond, _ = strconv.ParseInt(os.Getenv("PROPOSE_RATE"), 10, 64)
+	admission.Overflow, err = raft.ParseOverflowPolicy(os.Getenv("ADMISSION_OVERFLOW"))
+	if err != nil {
+		return err
+	}
+	node.SetAdmission(admission)
+	// batches concurrent proposals
+	if w := os.Getenv("PROPOSE_WINDOW"); w != "" {
+		window, err := time.ParseDuration(w)
+		if err != nil {
+			return fmt.Errorf("bad PROPOSE_WINDOW: %s", w)
+		}
+		node.SetProposeWindow(window)
+	}
+	// one sending goroutine per member
+	node.StartReplicators(raft_xport.Send)
+
+	log.Info("Service server started", "port", conf.ClientPort)
+	svc_xport := link.NewTcpServer(conf.Host, conf.ClientPort)
+	svc := server.NewService(base_dir, node, svc_xport)
+	defer svc.Close()
+	svc.ClientAddr = server.PortOffsetMapper(conf.ClientPort - conf.Port)
+
+	if endpoint := os.Getenv("OTLP_ENDPOINT"); endpoint != "" {
+		tracer := trace.NewOtlpExporter(endpoint, "big-ssdb")
+		if ratio, err := strconv.ParseFloat(os.Getenv("OTLP_SAMPLE_RATIO"), 64); err == nil {
+			tracer.SampleRatio = ratio
+		}
+		defer tracer.Close()
+		node.SetTracer(tracer)
+		svc.SetTracer(tracer)
+	}
+
+	log.Info("Admin server started", "port", conf.AdminPort)
+	admin := server.NewAdminServer(conf.Host, conf.AdminPort, node, svc)
+	if admin == nil {
+		return errors.New("failed to start admin server")
+	}
+	defer admin.Close()
+	// profiling endpoints, optionally protected by ADMIN_TOKEN
+	if os.Getenv("ADMIN_DEBUG") != "" {
+		admin.EnableDebug(os.Getenv("ADMIN_TOKEN"))
+	}
+
+	for id, addr := range peers {
+		raft_xport.Connect(id, addr)
+	}
+	if conf.Bootstrap && conf.Witness {
+		return errors.New("a witness can not bootstrap a group")
+	}
+	if conf.Priority != 0 && conf.Witness {
+		return errors.New("a witness has no priority")
+	}
+	if conf.Bootstrap && len(node.Status().Members) == 0 {
+		index, err := node.AddMember(conf.Id, raft_xport.Addr())
+		if err != nil {
+			return err
+		}
+		if conf.Priority != 0 {
+			go setPriority(node, index, conf.Priority, stop)
+		}
+	}
+
+	for{
+		select{
+		case msg := <-svc_xport.C:
+			svc.HandleClientMessage(msg)
+		case msg := <-raft_xport.C():
+			node.Deliver(msg)
+		case <-stop:
+			log.Info("shutting down")
+			return nil
+		}
+	}
+}
+
+// SetPriority of this node once AddMember at index is committed, only one
+// config change is in progress at a time
+func setPriority(node *raft.Node, index int64, priority int, stop <-chan struct{}) {
+	log := logger.New("main")
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if node.Status().CommitIndex < index {
+				continue
+			}
+			if _, err := node.SetPriority(node.Id, priority); err != nil {
+				log.Error("set priority", "err", err)
+			}
+			return
+		case <-stop:
+			return
+		}
+	}
+}
+
+func saveSnapshots(node *raft.Node, d *raft.SnapshotDir, interval time.Duration, stop <-chan struct{}) {
+	log := logger.New("main")
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	saved := int64(-1)
+	for {
+		select {
+		case <-ticker.C:
+			// nothing new since the last one
+			if node.Status().CommitIndex == saved {
+				continue
+			}
+			info, err := node.SaveSnapshot(d)
+			if err != nil {
+				log.Error("save snapshot", "err", err)
+				continue
+			}
+			saved = info.Index
+		case <-stop:
+			return
+		}
+	}
+}
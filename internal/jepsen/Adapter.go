@@ -0,0 +1,98 @@
+package jepsen
+
+import (
+	"io"
+	"fmt"
+	"sort"
+	"sync"
+	"bufio"
+	"encoding/json"
+)
+
+// Bridge between a Jepsen test and the cluster: reads invocations, one JSON
+// Op per line, and writes their completions in the same format. Processes
+// run concurrently, each one's operations in order on its own connection.
+// Thread safe.
+type Adapter struct{
+	// node => service address
+	nodes map[string]string
+	ids []string
+	procs map[int]chan *Op
+	out *json.Encoder
+	wg sync.WaitGroup
+	mux sync.Mutex
+}
+
+func NewAdapter(nodes map[string]string) *Adapter {
+	a := new(Adapter)
+	a.nodes = nodes
+	for id := range nodes {
+		a.ids = append(a.ids, id)
+	}
+	sort.Strings(a.ids)
+	a.procs = make(map[int]chan *Op)
+	return a
+}
+
+// Until r is closed, then waits for pending operations
+func (a *Adapter)Run(r io.Reader, w io.Writer) error {
+	a.out = json.NewEncoder(w)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		op := new(Op)
+		if err := json.Unmarshal(scanner.Bytes(), op); err != nil {
+			return fmt.Errorf("bad op: %s", err)
+		}
+		if op.Type != OpInvoke {
+			return fmt.Errorf("not an invocation: %s", scanner.Text())
+		}
+		a.invoke(op)
+	}
+	for _, c := range a.procs {
+		close(c)
+	}
+	a.wg.Wait()
+	return scanner.Err()
+}
+
+func (a *Adapter)invoke(op *Op) {
+	c := a.procs[op.Process]
+	if c == nil {
+		c = make(chan *Op, 16)
+		a.procs[op.Process] = c
+		a.wg.Add(1)
+		go a.process(op.Process, c)
+	}
+	c <- op
+}
+
+// a process sends to op.Node, or the same node in turn if not given
+func (a *Adapter)process(proc int, c chan *Op) {
+	defer a.wg.Done()
+	clients := make(map[string]*Client)
+	defer func() {
+		for _, client := range clients {
+			client.Close()
+		}
+	}()
+	for op := range c {
+		node := op.Node
+		if node == "" && len(a.ids) > 0 {
+			node = a.ids[proc % len(a.ids)]
+		}
+		var ret *Op
+		if addr, ok := a.nodes[node]; !ok {
+			ret = op.Failed(ErrConnect, "unknown node " + node)
+		} else {
+			if clients[node] == nil {
+				clients[node] = NewClient(addr)
+			}
+			ret = clients[node].Do(op)
+		}
+		ret.Node = node
+		a.mux.Lock()
+		a.out.Encode(ret)
+		a.mux.Unlock()
+	}
+}
@@ -0,0 +1,142 @@
+package jepsen
+
+import (
+	"io"
+	"fmt"
+	"net"
+	"time"
+	"bufio"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+const DefaultTimeout = 5 * time.Second
+
+// Connection of one Jepsen process to one node's service port, operations
+// are done one at a time. The connection is dropped after an indefinite
+// result, so a late response is never taken for the next operation's.
+type Client struct{
+	Addr string
+	Timeout time.Duration
+	conn net.Conn
+	r *bufio.Reader
+}
+
+func NewClient(addr string) *Client {
+	c := new(Client)
+	c.Addr = addr
+	c.Timeout = DefaultTimeout
+	return c
+}
+
+func (c *Client)Close() {
+	if c.conn != nil {
+		c.conn.Close()
+		c.conn = nil
+	}
+}
+
+// Completion of op
+func (c *Client)Do(op *Op) *Op {
+	var req []string
+	switch op.F {
+	case FnRead:
+		req = []string{"get", op.Key}
+	case FnWrite:
+		if op.Value == nil {
+			return op.Failed(ErrUnknown, "no value")
+		}
+		req = []string{"set", op.Key, *op.Value}
+	case FnDelete:
+		req = []string{"del", op.Key}
+	case FnIncr:
+		req = []string{"incr", op.Key, "1"}
+		if op.Value != nil {
+			req[2] = *op.Value
+		}
+	default:
+		// not sent
+		return op.Failed(ErrConnect, "unknown f " + op.F)
+	}
+
+	if c.conn == nil {
+		conn, err := net.DialTimeout("tcp", c.Addr, c.Timeout)
+		if err != nil {
+			return op.Failed(ErrConnect, err.Error())
+		}
+		c.conn = conn
+		c.r = bufio.NewReader(conn)
+	}
+	c.conn.SetDeadline(time.Now().Add(c.Timeout))
+	if _, err := c.conn.Write(encodeRequest(req)); err != nil {
+		c.Close()
+		return op.Failed(ErrConnLost, err.Error())
+	}
+	val, err := c.readResponse()
+	if err != nil {
+		kind := ErrorKind(ErrConnLost)
+		var ne net.Error
+		if errors.As(err, &ne) && ne.Timeout() {
+			kind = ErrTimeout
+		}
+		c.Close()
+		return op.Failed(kind, err.Error())
+	}
+	if val.err != "" {
+		kind := ClassifyError(val.err)
+		if !kind.Definite() {
+			c.Close()
+		}
+		return op.Failed(kind, val.err)
+	}
+	if op.F == FnRead && val.s == "" {
+		// the server doesn't tell empty values from missing keys
+		return op.Ok(nil)
+	}
+	return op.Ok(&val.s)
+}
+
+// ssdb format, binary safe
+func encodeRequest(ps []string) []byte {
+	var b strings.Builder
+	for _, p := range ps {
+		b.WriteString(strconv.Itoa(len(p)))
+		b.WriteString("\n")
+		b.WriteString(p)
+		b.WriteString("\n")
+	}
+	b.WriteString("\n")
+	return []byte(b.String())
+}
+
+type response struct{
+	s string
+	err string
+}
+
+// +OK, -ERR msg, or one $bulk string
+func (c *Client)readResponse() (*response, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimRight(line, "\r\n")
+	switch {
+	case line == "+OK":
+		return &response{}, nil
+	case strings.HasPrefix(line, "-ERR"):
+		return &response{err: strings.TrimSpace(strings.TrimPrefix(line, "-ERR"))}, nil
+	case strings.HasPrefix(line, "$"):
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("bad response: %q", line)
+		}
+		buf := make([]byte, n + 2)
+		if _, err := io.ReadFull(c.r, buf); err != nil {
+			return nil, err
+		}
+		return &response{s: string(buf[:n])}, nil
+	}
+	return nil, fmt.Errorf("bad response: %q", line)
+}
@@ -0,0 +1,133 @@
+package jepsen
+
+import (
+	"net"
+	"time"
+	"bufio"
+	"bytes"
+	"strings"
+	"testing"
+	"encoding/json"
+)
+
+// answers requests with canned responses by command, "" never answers
+func fakeServer(t *testing.T, responses map[string]string) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				for {
+					// size, cmd, then the rest up to the empty line
+					var ps []string
+					for {
+						line, err := r.ReadString('\n')
+						if err != nil {
+							return
+						}
+						if line == "\n" {
+							break
+						}
+						ps = append(ps, strings.TrimSpace(line))
+					}
+					if resp := responses[ps[1]]; resp != "" {
+						conn.Write([]byte(resp))
+					}
+				}
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func str(s string) *string {
+	return &s
+}
+
+func TestClient(t *testing.T){
+	addr := fakeServer(t, map[string]string{
+		"get": "$1\r\n3\r\n",
+		"set": "-ERR not leader\r\n",
+		"incr": "$2\r\n12\r\n",
+		"del": "-ERR entry was overwritten by new leader\r\n",
+	})
+	c := NewClient(addr)
+	defer c.Close()
+
+	tests := []struct{
+		op Op
+		typ OpType
+		value *string
+		err ErrorKind
+	}{
+		{Op{F: FnRead, Key: "k"}, OpOk, str("3"), ""},
+		{Op{F: FnWrite, Key: "k", Value: str("4")}, OpFail, str("4"), ErrNotLeader},
+		{Op{F: FnIncr, Key: "k"}, OpOk, str("12"), ""},
+		{Op{F: FnDelete, Key: "k"}, OpFail, nil, ErrOverwritten},
+	}
+	for _, tt := range tests {
+		ret := c.Do(&tt.op)
+		if ret.Type != tt.typ || ret.Error != tt.err {
+			t.Fatalf("%s: %+v", tt.op.F, ret)
+		}
+		if (ret.Value == nil) != (tt.value == nil) || (ret.Value != nil && *ret.Value != *tt.value) {
+			t.Fatalf("%s: value %v", tt.op.F, ret.Value)
+		}
+	}
+}
+
+func TestClientTimeout(t *testing.T){
+	addr := fakeServer(t, map[string]string{"get": "+OK\r\n"})
+	c := NewClient(addr)
+	c.Timeout = 100 * time.Millisecond
+	defer c.Close()
+
+	// maybe applied later
+	if ret := c.Do(&Op{F: FnWrite, Key: "k", Value: str("1")}); ret.Type != OpInfo || ret.Error != ErrTimeout {
+		t.Fatal("write", ret)
+	}
+	// reconnected, missing key
+	if ret := c.Do(&Op{F: FnRead, Key: "k"}); ret.Type != OpOk || ret.Value != nil {
+		t.Fatal("read", ret)
+	}
+
+	c = NewClient("127.0.0.1:1")
+	if ret := c.Do(&Op{F: FnWrite, Key: "k", Value: str("1")}); ret.Type != OpFail || ret.Error != ErrConnect {
+		t.Fatal("connect", ret)
+	}
+}
+
+func TestAdapter(t *testing.T){
+	addr := fakeServer(t, map[string]string{"set": "+OK\r\n"})
+	a := NewAdapter(map[string]string{"n1": addr})
+	in := `{"process":0,"type":"invoke","f":"write","key":"k","value":"1"}
+{"process":1,"type":"invoke","f":"write","key":"k","value":"2","node":"n2"}
+`
+	var out bytes.Buffer
+	if err := a.Run(strings.NewReader(in), &out); err != nil {
+		t.Fatal(err)
+	}
+	rets := make(map[int]Op)
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		var op Op
+		if err := json.Unmarshal([]byte(line), &op); err != nil {
+			t.Fatal(err)
+		}
+		rets[op.Process] = op
+	}
+	if rets[0].Type != OpOk || rets[0].Node != "n1" || *rets[0].Value != "1" {
+		t.Fatal("process 0", out.String())
+	}
+	if rets[1].Type != OpFail || rets[1].Error != ErrConnect {
+		t.Fatal("process 1", out.String())
+	}
+}
@@ -0,0 +1,107 @@
+package jepsen
+
+import (
+	"strings"
+)
+
+// Jepsen's operation types. An operation is invoked, and completes as ok,
+// fail(definitely did not take effect) or info(may or may not take effect,
+// now or later).
+type OpType string
+
+const(
+	OpInvoke = "invoke"
+	OpOk     = "ok"
+	OpFail   = "fail"
+	OpInfo   = "info"
+)
+
+// Functions on a key, values are strings as stored by the server
+const(
+	FnRead   = "read"
+	FnWrite  = "write"
+	FnDelete = "delete"
+	FnIncr   = "incr"
+)
+
+// Why an operation did not complete ok
+type ErrorKind string
+
+const(
+	// rejected before being proposed, definite
+	ErrNotLeader   = "not-leader"
+	ErrUnavailable = "unavailable" // Service installed a raft snapshot, serves nothing
+	ErrReadOnly    = "read-only"   // low disk space
+	ErrBusy        = "busy"        // admission control
+	ErrConnect     = "connect"     // request not sent
+	// proposed, but its log entry was replaced by another leader's, definite
+	ErrOverwritten = "overwritten"
+	// request or response may be lost, indefinite
+	ErrTimeout     = "timeout"
+	ErrConnLost    = "conn-lost"
+	// server error not in this list, indefinite
+	ErrUnknown     = "unknown"
+)
+
+// Definite errors mean the operation did not take effect
+func (e ErrorKind)Definite() bool {
+	switch e {
+	case ErrNotLeader, ErrUnavailable, ErrReadOnly, ErrBusy, ErrConnect, ErrOverwritten:
+		return true
+	}
+	return false
+}
+
+// One line of the adapter's input(Type invoke) and output(completion), in
+// JSON. Missing keys read as Value nil.
+type Op struct{
+	Process int `json:"process"`
+	Type OpType `json:"type"`
+	F string `json:"f"`
+	Key string `json:"key"`
+	Value *string `json:"value"`
+	// node to send the request to, see Adapter
+	Node string `json:"node,omitempty"`
+	Error ErrorKind `json:"error,omitempty"`
+	// server's error message
+	Message string `json:"message,omitempty"`
+}
+
+// Completion of op, failed with kind. Reads have no side effects, so they
+// never complete as info.
+func (op *Op)Failed(kind ErrorKind, msg string) *Op {
+	ret := *op
+	ret.Type = OpFail
+	if !kind.Definite() && op.F != FnRead {
+		ret.Type = OpInfo
+	}
+	ret.Error = kind
+	ret.Message = msg
+	return &ret
+}
+
+func (op *Op)Ok(value *string) *Op {
+	ret := *op
+	ret.Type = OpOk
+	if op.F == FnRead || op.F == FnIncr {
+		ret.Value = value
+	}
+	return &ret
+}
+
+// Kind of an error response of the server
+func ClassifyError(msg string) ErrorKind {
+	switch {
+	case msg == "not leader":
+		return ErrNotLeader
+	case msg == "Service unavailable":
+		return ErrUnavailable
+	case strings.HasPrefix(msg, "read-only"):
+		return ErrReadOnly
+	case strings.HasPrefix(msg, "busy"):
+		return ErrBusy
+	case strings.HasPrefix(msg, "entry was overwritten"):
+		return ErrOverwritten
+	}
+	return ErrUnknown
+}
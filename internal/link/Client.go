@@ -0,0 +1,123 @@
+package link
+
+import (
+	"io"
+	"fmt"
+	"net"
+	"time"
+	"bufio"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// Blocking client of a TcpServer, one request at a time
+type Client struct{
+	Timeout time.Duration
+	conn net.Conn
+	r *bufio.Reader
+}
+
+func Dial(addr string, timeout time.Duration) (*Client, error) {
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return nil, err
+	}
+	c := new(Client)
+	c.Timeout = timeout
+	c.conn = conn
+	c.r = bufio.NewReader(conn)
+	return c, nil
+}
+
+func (c *Client)Close() {
+	c.conn.Close()
+}
+
+// Sends a request without waiting for the response, for commands the server
+// doesn't reply to
+func (c *Client)Send(ps ...string) error {
+	if c.Timeout > 0 {
+		c.conn.SetDeadline(time.Now().Add(c.Timeout))
+	}
+	_, err := c.conn.Write(EncodeRequest(ps))
+	return err
+}
+
+// Values of the response, an -ERR response is returned as error
+func (c *Client)Call(ps ...string) ([]string, error) {
+	if err := c.Send(ps...); err != nil {
+		return nil, err
+	}
+	line, err := c.readLine()
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case line == "+OK":
+		return []string{}, nil
+	case strings.HasPrefix(line, "-ERR"):
+		return nil, errors.New(strings.TrimSpace(strings.TrimPrefix(line, "-ERR")))
+	case strings.HasPrefix(line, "$"):
+		s, err := c.readBulk(line)
+		if err != nil {
+			return nil, err
+		}
+		return []string{s}, nil
+	case strings.HasPrefix(line, "*"):
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("bad response: %q", line)
+		}
+		ret := make([]string, 0, n)
+		for i := 0; i < n; i++ {
+			line, err := c.readLine()
+			if err != nil {
+				return nil, err
+			}
+			s, err := c.readBulk(line)
+			if err != nil {
+				return nil, err
+			}
+			ret = append(ret, s)
+		}
+		return ret, nil
+	}
+	return nil, fmt.Errorf("bad response: %q", line)
+}
+
+func (c *Client)readLine() (string, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func (c *Client)readBulk(line string) (string, error) {
+	if !strings.HasPrefix(line, "$") {
+		return "", fmt.Errorf("bad response: %q", line)
+	}
+	n, err := strconv.Atoi(line[1:])
+	if err != nil || n < 0 {
+		return "", fmt.Errorf("bad response: %q", line)
+	}
+	buf := make([]byte, n + 2)
+	if _, err := io.ReadFull(c.r, buf); err != nil {
+		return "", err
+	}
+	return string(buf[:n]), nil
+}
+
+// ssdb format, binary safe
+func EncodeRequest(ps []string) []byte {
+	var b strings.Builder
+	for _, p := range ps {
+		b.WriteString(strconv.Itoa(len(p)))
+		b.WriteString("\n")
+		b.WriteString(p)
+		b.WriteString("\n")
+	}
+	b.WriteString("\n")
+	return []byte(b.String())
+}
@@ -1,8 +1,8 @@
 package link
 
 import (
-	// "bytes"
-	// "strings"
+	"fmt"
+	"strings"
 )
 
 type Message struct {
@@ -45,6 +45,15 @@ func (m *Message)Code() string {
 	return m.Cmd()
 }
 
+// command(or response code) followed by sizes of arguments, for logging
+func (m *Message)Redacted() string {
+	ps := []string{m.Cmd()}
+	for _, p := range m.Args() {
+		ps = append(ps, fmt.Sprintf("<%d bytes>", len(p)))
+	}
+	return strings.Join(ps, " ")
+}
+
 func (m *Message)Args() []string {
 	if len(m.ps) > 0 {
 		return m.ps[1 : ]
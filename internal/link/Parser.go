@@ -1,13 +1,16 @@
 package link
 
 import (
-	"log"
 	"bytes"
 	"errors"
 	"strings"
 	"strconv"
+
+	"github.com/fallowu/big-ssdb/logger"
 )
 
+var parserLog = logger.New("link")
+
 // 同时支持 SSDB, Redis, 空格分隔 3 种报文格式
 type Parser struct {
 	buf bytes.Buffer
@@ -156,7 +159,7 @@ func (p *Parser)parseRedisMessage(bs []byte) (*Message, int) {
 		}
 		size, err := strconv.Atoi(string(p))
 		if err != nil || size < 0 {
-			log.Println(err)
+			parserLog.Debug("bad size", "err", err)
 			return nil, -1
 		}
 		s += idx + 1
@@ -183,7 +186,7 @@ func (p *Parser)parseRedisMessage(bs []byte) (*Message, int) {
 			}
 		}
 		if bs[end] != '\n' {
-			log.Println("")
+			parserLog.Debug("bad bulk end")
 			return nil, -1
 		} else {
 			p := string(bs[s : s + size])
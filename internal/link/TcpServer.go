@@ -1,14 +1,15 @@
 package link
 
 import (
+	"errors"
 	"net"
-	"log"
 	"fmt"
 	"sync"
 	"strings"
 	"bytes"
 	"strconv"
-	"util"
+	"github.com/fallowu/big-ssdb/internal/util"
+	"github.com/fallowu/big-ssdb/logger"
 )
 
 /*
@@ -21,6 +22,8 @@ type TcpServer struct {
 	lastClientId int
 	conn *net.TCPListener
 	clients map[int]net.Conn
+	log *logger.Logger
+	plog *logger.PacketLog
 	mux sync.Mutex
 }
 
@@ -33,11 +36,23 @@ func NewTcpServer(ip string, port int) *TcpServer {
 	tcp.lastClientId = 0
 	tcp.conn = conn
 	tcp.clients = make(map[int]net.Conn)
+	tcp.log = logger.New("link").With("port", port)
+	tcp.plog = logger.NewPacketLog(tcp.log)
 
 	tcp.start()
 	return tcp
 }
 
+// listening address, with the port chosen if 0 is passed
+func (tcp *TcpServer)Addr() string {
+	return tcp.conn.Addr().String()
+}
+
+func (tcp *TcpServer)SetLogger(l *logger.Logger){
+	tcp.log = l
+	tcp.plog = logger.NewPacketLog(l)
+}
+
 func (tcp *TcpServer)Close(){
 	tcp.conn.Close()
 	close(tcp.C)
@@ -47,11 +62,14 @@ func (tcp *TcpServer)start() {
 	go func(){
 		for {
 			conn, err := tcp.conn.Accept()
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
 			if err != nil {
-				log.Fatal(err)
+				tcp.log.Fatalf("accept error: %s", err)
 			}
 			tcp.lastClientId ++
-			log.Println("Accept connection", tcp.lastClientId, conn.RemoteAddr().String())
+			tcp.log.Info("accept connection", "client", tcp.lastClientId, "remote", conn.RemoteAddr().String())
 			go tcp.handleClient(tcp.lastClientId, conn)
 		}
 	}()
@@ -63,7 +81,7 @@ func (tcp *TcpServer)handleClient(clientId int, conn net.Conn) {
 	tcp.mux.Unlock()
 
 	defer func() {
-		log.Println("Close connection", clientId, conn.RemoteAddr().String())
+		tcp.log.Info("close connection", "client", clientId, "remote", conn.RemoteAddr().String())
 		tcp.mux.Lock()
 		delete(tcp.clients, clientId)
 		tcp.mux.Unlock()
@@ -77,13 +95,16 @@ func (tcp *TcpServer)handleClient(clientId int, conn net.Conn) {
 		for {
 			msg, err := parser.Parse()
 			if err != nil {
-				log.Println("Parse error")
+				tcp.log.Warn("parse error", "client", clientId)
 				return
 			}
 			if msg == nil {
 				break
 			}
 			msg.Src = clientId
+			if tcp.plog.Sample() {
+				tcp.plog.Log(fmt.Sprintf("    receive > %d", clientId), strings.Join(msg.Data(), " "), msg.Redacted)
+			}
 			tcp.C <- msg
 		}
 		
@@ -92,22 +113,35 @@ func (tcp *TcpServer)handleClient(clientId int, conn net.Conn) {
 			break
 		}
 		parser.Append(tmp[0:n])
-		log.Printf("    receive > %d %s\n", clientId, util.ReplaceBytes(string(tmp[0:n]), []string{"\r", "\n"}, []string{"\\r", "\\n"}))
 	}
 }
 
+// "" if connection not found
+func (tcp *TcpServer)RemoteAddr(clientId int) string {
+	tcp.mux.Lock()
+	conn := tcp.clients[clientId]
+	tcp.mux.Unlock()
+
+	if conn == nil {
+		return ""
+	}
+	return conn.RemoteAddr().String()
+}
+
 func (tcp *TcpServer)Send(msg *Message) {
 	tcp.mux.Lock()
 	conn := tcp.clients[msg.Src]
 	tcp.mux.Unlock()
 
 	if conn == nil {
-		log.Println("connection not found:", msg.Src)
+		tcp.log.Warn("connection not found", "client", msg.Src)
 		return
 	}
 	
 	s := tcp.encodeResponse(msg)
-	log.Printf("    send > %d %s\n", msg.Src, util.ReplaceBytes(s, []string{"\r", "\n"}, []string{"\\r", "\\n"}))
+	if tcp.plog.Sample() {
+		tcp.plog.Log(fmt.Sprintf("    send > %d", msg.Src), util.ReplaceBytes(s, []string{"\r", "\n"}, []string{"\\r", "\\n"}), msg.Redacted)
+	}
 	conn.Write([]byte(s))
 }
 
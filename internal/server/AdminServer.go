@@ -0,0 +1,336 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"time"
+	"strings"
+	"strconv"
+	"runtime"
+	"crypto/subtle"
+	"encoding/json"
+	"expvar"
+	"net/http/pprof"
+
+	"github.com/fallowu/big-ssdb/raft"
+	"github.com/fallowu/big-ssdb/metrics"
+	"github.com/fallowu/big-ssdb/logger"
+)
+
+const DefaultReadyMaxLag = 100
+
+// HTTP server for operations: metrics, status, etc.
+type AdminServer struct{
+	// /readyz fails if service lags behind commit index more than this
+	ReadyMaxLag int64
+
+	node *raft.Node
+	svc *Service
+	log *logger.Logger
+
+	mux *http.ServeMux
+	conn net.Listener
+}
+
+func (s *AdminServer)SetLogger(l *logger.Logger){
+	s.log = l
+}
+
+func NewAdminServer(ip string, port int, node *raft.Node, svc *Service) *AdminServer {
+	l := logger.New("admin").With("port", port)
+	conn, err := net.Listen("tcp", fmt.Sprintf("%s:%d", ip, port))
+	if err != nil {
+		l.Error("listen error", "err", err)
+		return nil
+	}
+
+	s := new(AdminServer)
+	s.ReadyMaxLag = DefaultReadyMaxLag
+	s.log = l
+	s.node = node
+	s.svc = svc
+	s.conn = conn
+	s.mux = http.NewServeMux()
+	s.mux.HandleFunc("/metrics", s.handleMetrics)
+	s.mux.HandleFunc("/events", s.handleEvents)
+	s.mux.HandleFunc("/audit", s.handleAudit)
+	s.mux.HandleFunc("/log", s.handleLog)
+	s.mux.HandleFunc("/healthz", s.handleHealthz)
+	s.mux.HandleFunc("/readyz", s.handleReadyz)
+	s.mux.HandleFunc("/leaderz", s.handleLeaderz)
+	s.mux.HandleFunc("/selfcheck/snapshot", s.handleSelfcheckSnapshot)
+	s.mux.HandleFunc("/selfcheck/log", s.handleSelfcheckLog)
+	s.mux.HandleFunc("/", s.handleDashboard)
+
+	go func() {
+		err := http.Serve(s.conn, s.mux)
+		s.log.Info("admin server stopped", "err", err)
+	}()
+	return s
+}
+
+func (s *AdminServer)Close() {
+	s.conn.Close()
+}
+
+func (s *AdminServer)handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	mw := metrics.NewWriter(w)
+
+	rm := s.node.Metrics()
+	mw.Gauge("raft_term", "Current term.", float64(rm.Term))
+	for _, role := range []raft.RoleType{raft.RoleLeader, raft.RoleFollower, raft.RoleCandidate} {
+		v := 0.0
+		if rm.Role == role {
+			v = 1
+		}
+		mw.Gauge("raft_role", "Current role of this node.", v, "role", string(role))
+	}
+	mw.Gauge("raft_first_index", "First index of log.", float64(rm.FirstIndex))
+	mw.Gauge("raft_last_index", "Last index of log.", float64(rm.LastIndex))
+	mw.Gauge("raft_commit_index", "Commit index.", float64(rm.CommitIndex))
+	mw.Gauge("raft_applied_index", "Last index applied to Raft.", float64(rm.LastApplied))
+	mw.Gauge("raft_service_applied_index", "Last index applied to Service.", float64(rm.ServiceLastApplied))
+	mw.Counter("raft_elections_total", "Elections started by this node.", float64(rm.Elections))
+	mw.Counter("raft_votes_denied_total", "PreVote and RequestVote denied while leader is active.", float64(rm.VotesDenied))
+	mw.Counter("raft_snapshots_sent_total", "Snapshots sent to followers.", float64(rm.SnapshotsSent))
+	mw.Counter("raft_snapshots_installed_total", "Snapshots installed.", float64(rm.SnapshotsInstalled))
+	mw.Counter("raft_messages_corrupted_total", "Messages dropped for checksum mismatch.", float64(rm.MessagesCorrupted))
+	mw.Counter("raft_messages_unverified_total", "Messages without checksum, of old versions.", float64(rm.MessagesUnverified))
+
+	for _, m := range rm.Members {
+		mw.Gauge("raft_member_match_index", "Match index of member.", float64(m.MatchIndex), "member", m.Id)
+	}
+	for _, m := range rm.Members {
+		mw.Gauge("raft_member_next_index", "Next index of member.", float64(m.NextIndex), "member", m.Id)
+	}
+	for _, m := range rm.Members {
+		mw.Gauge("raft_member_snapshot_acked_bytes", "Bytes of the snapshot being sent acked by member.", float64(m.SnapshotAcked), "member", m.Id)
+	}
+	for _, m := range rm.Members {
+		mw.Gauge("raft_member_send_window", "Entries which may be sent to member without ack.", float64(m.SendWindow), "member", m.Id)
+	}
+	for _, m := range rm.Members {
+		mw.Gauge("raft_member_lag_entries", "Entries member is behind leader.", float64(m.Lag), "member", m.Id)
+	}
+	for _, m := range rm.Members {
+		mw.Gauge("raft_member_lag_seconds", "How long member has been behind leader.", float64(m.LagTime)/1000, "member", m.Id)
+	}
+	for _, m := range rm.Members {
+		mw.Gauge("raft_member_last_ack_seconds", "Time since last message from member.", float64(m.ReceiveTimeout)/1000, "member", m.Id)
+	}
+	for _, m := range rm.Members {
+		v := 0.0
+		if m.InstallingSnapshot {
+			v = 1
+		}
+		mw.Gauge("raft_member_installing_snapshot", "Snapshot sent to member, waiting for ack.", v, "member", m.Id)
+		mw.Gauge("raft_member_send_queue", "Messages waiting in member's replicator.", float64(m.SendQueue), "member", m.Id)
+		mw.Counter("raft_member_send_dropped_total", "Messages dropped as member's queue is full.", float64(m.SendDropped), "member", m.Id)
+	}
+
+	mw.Gauge("raft_disk_total_bytes", "Size of the filesystem holding data dir.", float64(rm.DiskTotal))
+	mw.Gauge("raft_disk_free_bytes", "Free space of the filesystem holding data dir.", float64(rm.DiskFree))
+	readOnly := 0.0
+	if rm.ReadOnly {
+		readOnly = 1
+	}
+	mw.Gauge("raft_read_only", "Proposals rejected due to low disk space.", readOnly)
+	mw.Gauge("raft_log_entries", "Entries in log.", float64(rm.LogEntries))
+	mw.Gauge("raft_log_bytes", "Bytes of encoded log entries.", float64(rm.LogBytes))
+	mw.Gauge("raft_entry_cache_bytes", "Bytes of log entries cached in memory.", float64(rm.EntryCacheBytes))
+	mw.Counter("raft_entry_cache_misses_total", "Log entries loaded from db as not cached.", float64(rm.EntryCacheMisses))
+	mw.Histogram("raft_fsync_seconds", "Latency of fsync.", rm.Fsync)
+	mw.Histogram("raft_service_apply_seconds", "Latency of Service.ApplyEntry.", rm.Apply)
+	mw.Counter("raft_service_slow_applies_total", "Service applies slower than threshold.", float64(rm.SlowApplies))
+	mw.Counter("raft_invariant_violations_total", "Raft invariant violations detected in alert mode.", float64(rm.InvariantViolations))
+	mw.Counter("raft_apply_divergences_total", "Follower apply results differing from leader's, in apply check mode.", float64(rm.ApplyDivergences))
+	mw.Counter("raft_proposals_busy_total", "Proposals rejected by admission control.", float64(rm.ProposalsBusy))
+	mw.Gauge("raft_service_apply_backlog", "Committed entries not yet applied to Service.",
+		float64(rm.CommitIndex - rm.ServiceLastApplied))
+	mw.Gauge("raft_recv_queue", "Messages waiting to be processed by raft.", float64(rm.RecvQueue))
+	mw.Gauge("raft_recv_queue_size", "Capacity of raft's receive queue.", float64(rm.RecvQueueSize))
+	mw.Counter("raft_recv_dropped_total", "Messages dropped as receive queue is full.", float64(rm.RecvDropped))
+	mw.Gauge("raft_send_queue", "Messages waiting to be sent.", float64(rm.SendQueue))
+	mw.Gauge("raft_send_queue_size", "Capacity of raft's send queue.", float64(rm.SendQueueSize))
+	mw.Counter("raft_send_dropped_total", "Messages dropped as send queue is full.", float64(rm.SendDropped))
+	mw.Gauge("raft_service_apply_queue", "Entries queued to the apply goroutine.", float64(rm.ApplyQueue))
+
+	cmds := s.svc.stats.Commands()
+	names := make([]string, 0, len(cmds))
+	for cmd, _ := range cmds {
+		names = append(names, cmd)
+	}
+	sort.Strings(names)
+	for _, cmd := range names {
+		mw.Counter("service_commands_total", "Commands received.", float64(cmds[cmd].Calls), "cmd", cmd)
+	}
+	for _, cmd := range names {
+		mw.Counter("service_command_errors_total", "Commands replied with error.", float64(cmds[cmd].Errors), "cmd", cmd)
+	}
+	for _, cmd := range names {
+		mw.Histogram("service_command_seconds", "Latency of commands.", cmds[cmd].Latency, "cmd", cmd)
+	}
+
+	conns := s.svc.stats.Conns()
+	ids := make([]int, 0, len(conns))
+	for src, _ := range conns {
+		ids = append(ids, src)
+	}
+	sort.Ints(ids)
+	mw.Gauge("service_clients", "Client connections being tracked.", float64(len(ids)))
+	for _, src := range ids {
+		mw.Counter("service_client_commands_total", "Commands received from client connection.",
+				float64(conns[src].Calls), "client", strconv.Itoa(src), "remote", conns[src].Remote)
+	}
+	for _, src := range ids {
+		mw.Counter("service_client_errors_total", "Commands from client connection replied with error.",
+				float64(conns[src].Errors), "client", strconv.Itoa(src), "remote", conns[src].Remote)
+	}
+	for _, src := range ids {
+		mw.Counter("service_client_seconds_total", "Total latency of commands from client connection.",
+				conns[src].Latency.Sum, "client", strconv.Itoa(src), "remote", conns[src].Remote)
+	}
+}
+
+// GET /events?n=100, latest n raft events in JSON
+func (s *AdminServer)handleEvents(w http.ResponseWriter, r *http.Request) {
+	n, _ := strconv.Atoi(r.FormValue("n"))
+	events := s.node.Events(n)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(events)
+}
+
+// GET /audit?n=100, latest n audit records in JSON
+func (s *AdminServer)handleAudit(w http.ResponseWriter, r *http.Request) {
+	n, _ := strconv.Atoi(r.FormValue("n"))
+	records, err := s.node.AuditRecords(n)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(records)
+}
+
+// at most this many entries by one GET /log
+const MaxLogEntries = 1000
+
+// GET /log?lo=1&hi=100, entries of raft log in JSON. lo defaults to the
+// first index, hi to lo+99, limited to MaxLogEntries.
+func (s *AdminServer)handleLog(w http.ResponseWriter, r *http.Request) {
+	rm := s.node.Metrics()
+	lo, _ := strconv.ParseInt(r.FormValue("lo"), 10, 64)
+	lo = max(lo, rm.FirstIndex)
+	hi, err := strconv.ParseInt(r.FormValue("hi"), 10, 64)
+	if err != nil {
+		hi = lo + 99
+	}
+	hi = min(hi, lo + MaxLogEntries - 1)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.node.GetEntries(lo, hi))
+}
+
+// GET /healthz, process is up
+func (s *AdminServer)handleHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte("ok\n"))
+}
+
+// GET /readyz?max_lag=100, node knows a live leader, and service has applied
+// entries within max_lag of commit index
+func (s *AdminServer)handleReadyz(w http.ResponseWriter, r *http.Request) {
+	maxLag := s.ReadyMaxLag
+	if v := r.FormValue("max_lag"); v != "" {
+		maxLag, _ = strconv.ParseInt(v, 10, 64)
+	}
+
+	rm := s.node.Metrics()
+	reason := ""
+	if rm.Leader == "" {
+		reason = "no leader"
+	} else if !rm.LeaderActive {
+		reason = "leader lost"
+	} else if lag := rm.CommitIndex - rm.ServiceLastApplied; lag > maxLag {
+		reason = fmt.Sprintf("apply lag %d > %d", lag, maxLag)
+	}
+	if reason != "" {
+		http.Error(w, reason, http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintf(w, "ok %s leader=%s\n", rm.Role, rm.Leader)
+}
+
+// GET /leaderz, 200 if this node is leader, 503 otherwise
+func (s *AdminServer)handleLeaderz(w http.ResponseWriter, r *http.Request) {
+	if !s.node.IsLeader() {
+		http.Error(w, "not leader, leader=" + s.node.LeaderId() + " addr=" + s.svc.LeaderClientAddr(), http.StatusServiceUnavailable)
+		return
+	}
+	w.Write([]byte("leader\n"))
+}
+
+// Snapshot current state, install it into a fresh Storage and compare
+func (s *AdminServer)handleSelfcheckSnapshot(w http.ResponseWriter, r *http.Request) {
+	hash, err := s.node.VerifySnapshot()
+	if err != nil {
+		s.log.Error("snapshot self-check failed", "err", err)
+		http.Error(w, "snapshot self-check failed: " + err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write([]byte("ok hash=" + hash + "\n"))
+}
+
+// Leader compares sampled log positions of all members, ?samples=n
+func (s *AdminServer)handleSelfcheckLog(w http.ResponseWriter, r *http.Request) {
+	n, _ := strconv.Atoi(r.URL.Query().Get("samples"))
+	report := <-s.node.CheckLog(n)
+	if report == nil {
+		http.Error(w, "not leader or check in progress", http.StatusServiceUnavailable)
+		return
+	}
+	if report.Unsafe() {
+		s.log.Error("log self-check failed", "report", report.Encode())
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+	w.Write([]byte(report.Encode()))
+}
+
+// Mount net/http/pprof and expvar under /debug/, token is required
+// as "Authorization: Bearer <token>" or "?token=<token>" if not empty.
+// Not enabled by default, profiling endpoints expose process internals.
+func (s *AdminServer)EnableDebug(token string) {
+	runtime.SetMutexProfileFraction(10)
+	runtime.SetBlockProfileRate(int(time.Millisecond))
+
+	if expvar.Get("raft") == nil {
+		node := s.node
+		expvar.Publish("raft", expvar.Func(func() interface{} {
+			return node.Metrics()
+		}))
+	}
+
+	guard := func(h http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if token != "" {
+				t := r.FormValue("token")
+				if auth := r.Header.Get("Authorization"); auth != "" {
+					t = strings.TrimPrefix(auth, "Bearer ")
+				}
+				if subtle.ConstantTimeCompare([]byte(t), []byte(token)) != 1 {
+					http.Error(w, "unauthorized", http.StatusUnauthorized)
+					return
+				}
+			}
+			h(w, r)
+		}
+	}
+	s.mux.HandleFunc("/debug/pprof/", guard(pprof.Index))
+	s.mux.HandleFunc("/debug/pprof/cmdline", guard(pprof.Cmdline))
+	s.mux.HandleFunc("/debug/pprof/profile", guard(pprof.Profile))
+	s.mux.HandleFunc("/debug/pprof/symbol", guard(pprof.Symbol))
+	s.mux.HandleFunc("/debug/pprof/trace", guard(pprof.Trace))
+	s.mux.HandleFunc("/debug/vars", guard(expvar.Handler().ServeHTTP))
+	s.log.Info("debug endpoints enabled", "auth", token != "")
+}
@@ -0,0 +1,146 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fallowu/big-ssdb/raft"
+)
+
+func newTestAdmin(t *testing.T, svc *Service) *AdminServer {
+	t.Helper()
+	s := NewAdminServer("127.0.0.1", 0, svc.node, svc)
+	if s == nil {
+		t.Fatal("admin server not started")
+	}
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *AdminServer)get(path string) (int, string) {
+	w := httptest.NewRecorder()
+	s.mux.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+	return w.Code, w.Body.String()
+}
+
+// value of the sample, e.g. `raft_member_lag_entries{member="n2"}`, -1 if
+// not found
+func metricValue(body string, sample string) float64 {
+	for _, line := range strings.Split(body, "\n") {
+		if v, ok := strings.CutPrefix(line, sample + " "); ok {
+			f, _ := strconv.ParseFloat(v, 64)
+			return f
+		}
+	}
+	return -1
+}
+
+func TestAdminProbes(t *testing.T){
+	timeouts := raft.Timeouts{Election: 500, Heartbeat: 100, Replication: 100, Receive: 300}
+	g := newTestGroup(t, []raft.Option{raft.WithTimeouts(timeouts)}, "n1", "n2")
+	a1, a2 := newTestAdmin(t, g.svcs["n1"]), newTestAdmin(t, g.svcs["n2"])
+
+	for _, a := range []*AdminServer{a1, a2} {
+		if code, body := a.get("/healthz"); code != http.StatusOK || body != "ok\n" {
+			t.Fatal("healthz", code, body)
+		}
+	}
+	if code, body := a1.get("/leaderz"); code != http.StatusOK {
+		t.Fatal("leaderz", code, body)
+	}
+	if code, body := a2.get("/leaderz"); code != http.StatusServiceUnavailable || !strings.Contains(body, "leader=n1") {
+		t.Fatal("leaderz", code, body)
+	}
+	for _, a := range []*AdminServer{a1, a2} {
+		waitFor(t, 5 * time.Second, "ready", func() bool {
+			code, _ := a.get("/readyz")
+			return code == http.StatusOK
+		})
+	}
+	if code, body := a2.get("/readyz?max_lag=-1"); code != http.StatusServiceUnavailable || !strings.Contains(body, "apply lag") {
+		t.Fatal("readyz", code, body)
+	}
+
+	// n2 no longer hears from the leader
+	g.Cut("n1", "n2")
+	waitFor(t, 10 * time.Second, "not ready", func() bool {
+		code, _ := a2.get("/readyz")
+		return code == http.StatusServiceUnavailable
+	})
+	g.Heal()
+	waitFor(t, 10 * time.Second, "ready again", func() bool {
+		code, _ := a2.get("/readyz")
+		return code == http.StatusOK
+	})
+}
+
+func TestAdminSlowApply(t *testing.T){
+	svc, c := newTestService(t)
+	a := newTestAdmin(t, svc)
+	if _, err := c.Call("set", "k", "v"); err != nil {
+		t.Fatal(err)
+	}
+	_, body := a.get("/metrics")
+	if metricValue(body, "raft_service_slow_applies_total") != 0 || metricValue(body, "raft_service_apply_seconds_count") < 1 {
+		t.Fatal(body)
+	}
+
+	// every apply is slow
+	svc.node.SetSlowApply(time.Nanosecond, 0)
+	for i := 0; i < 3; i ++ {
+		if _, err := c.Call("set", "k", strconv.Itoa(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, body = a.get("/metrics")
+	if metricValue(body, "raft_service_slow_applies_total") < 3 || metricValue(body, "raft_service_apply_backlog") != 0 {
+		t.Fatal(body)
+	}
+	if n := svc.node.Metrics().SlowApplies; n < 3 {
+		t.Fatal("slow applies", n)
+	}
+}
+
+func TestAdminMemberLag(t *testing.T){
+	timeouts := raft.Timeouts{Election: 500, Heartbeat: 100, Replication: 100, Receive: 300}
+	g := newTestGroup(t, []raft.Option{raft.WithTimeouts(timeouts)}, "n1", "n2", "n3")
+	a := newTestAdmin(t, g.svcs["n1"])
+	node := g.svcs["n1"].node
+	lag := func(id string) raft.MemberMetrics {
+		for _, m := range node.Metrics().Members {
+			if m.Id == id {
+				return m
+			}
+		}
+		t.Fatal("no member", id)
+		return raft.MemberMetrics{}
+	}
+
+	g.Cut("n1", "n3")
+	for i := 0; i < 5; i ++ {
+		if _, err := g.clients["n1"].Call("set", "k", strconv.Itoa(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitFor(t, 5 * time.Second, "behind", func() bool {
+		return lag("n3").Lag >= 5 && lag("n3").LagTime > 0 && lag("n2").Lag == 0
+	})
+	_, body := a.get("/metrics")
+	if metricValue(body, `raft_member_lag_entries{member="n3"}`) < 5 || metricValue(body, `raft_member_lag_seconds{member="n3"}`) <= 0 ||
+		metricValue(body, `raft_member_lag_entries{member="n2"}`) != 0 || metricValue(body, `raft_member_installing_snapshot{member="n3"}`) != 0 {
+		t.Fatal(body)
+	}
+
+	g.Heal()
+	waitFor(t, 5 * time.Second, "caught up", func() bool {
+		return lag("n3").Lag == 0 && lag("n3").LagTime == 0
+	})
+	_, body = a.get("/metrics")
+	if metricValue(body, `raft_member_lag_entries{member="n3"}`) != 0 || metricValue(body, `raft_member_lag_seconds{member="n3"}`) != 0 {
+		t.Fatal(body)
+	}
+}
@@ -0,0 +1,34 @@
+package server
+
+import (
+	"net"
+	"strconv"
+)
+
+// Maps a raft node to the address its Service listens on for clients, ""
+// if unknown
+type ClientAddrMapper func(nodeId string, raftAddr string) string
+
+// Client port is raft port + offset on the same host, see app.Config
+func PortOffsetMapper(offset int) ClientAddrMapper {
+	return func(nodeId string, raftAddr string) string {
+		host, port, err := net.SplitHostPort(raftAddr)
+		if err != nil {
+			return ""
+		}
+		p, err := strconv.Atoi(port)
+		if err != nil {
+			return ""
+		}
+		return net.JoinHostPort(host, strconv.Itoa(p + offset))
+	}
+}
+
+// Client address of the current leader, "" if unknown, for redirects
+func (svc *Service)LeaderClientAddr() string {
+	s := svc.node.Status()
+	if s.Leader == "" || s.LeaderAddr == "" || svc.ClientAddr == nil {
+		return ""
+	}
+	return svc.ClientAddr(s.Leader, s.LeaderAddr)
+}
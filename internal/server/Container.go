@@ -1,12 +1,15 @@
 package server
 
 import (
-	"log"
+	"io"
+
+	"github.com/fallowu/big-ssdb/logger"
+	"github.com/fallowu/big-ssdb/raft"
 )
 
 type Container struct {
 	lastApplied int64
-	db Db
+	db raft.Db
 	admin *raft.Node
 	nodes map[string]*raft.Node
 }
@@ -15,7 +18,7 @@ func NewContainer(nodeId string, addr string, db raft.Db) *Container {
 	ret := new(Container)
 	ret.db = db
 	ret.admin = raft.NewNode(nodeId, addr, db)
-	ret.nodes = make(map[string]*Node)
+	ret.nodes = make(map[string]*raft.Node)
 	return ret
 }
 
@@ -34,16 +37,25 @@ func (c *Container)Close() {
 // func (c *Container)AddMember(nodeId string, nodeAddr string) (int32, int64) {
 // }
 
-/* #################### raft.Service interface ######################### */
+/* #################### raft.StateMachine interface ######################### */
 
 func (m *Container)LastApplied() int64{
 	return m.lastApplied
 }
 
-func (m *Container)ApplyEntry(ent *raft.Entry){
+func (m *Container)Apply(ent *raft.Entry) raft.Result {
 	m.lastApplied = ent.Index
+	return nil
+}
+
+func (m *Container)SaveSnapshot(w io.Writer) error {
+	return raft.ErrSnapshotUnsupported
+}
+
+func (m *Container)RestoreSnapshot(r io.Reader) error {
+	return raft.ErrSnapshotUnsupported
 }
 
-func (m *Container)InstallSnapshot() {
-	log.Println("not implemented")
+func (m *Container)RaftApplyBroken() {
+	logger.New("server").Warn("RaftApplyBroken not implemented")
 }
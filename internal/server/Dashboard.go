@@ -0,0 +1,93 @@
+package server
+
+import (
+	"net/http"
+	"html/template"
+
+	"github.com/fallowu/big-ssdb/raft"
+)
+
+const dashboardHtml = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="5">
+<title>big-ssdb {{.Raft.Id}}</title>
+<style>
+body { font-family: monospace; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 1.5em; }
+th, td { border: 1px solid #ccc; padding: 2px 8px; text-align: left; }
+th { background: #eee; }
+.leader { color: #080; font-weight: bold; }
+.lag { color: #c00; }
+</style>
+</head>
+<body>
+<h2>node {{.Raft.Id}} <span class="{{.Raft.Role}}">{{.Raft.Role}}</span> term {{.Raft.Term}}</h2>
+
+<h3>Log</h3>
+<table>
+<tr><th>firstIndex</th><th>lastIndex</th><th>commitIndex</th><th>lastApplied</th><th>service lastApplied</th><th>entries</th><th>bytes</th><th>fsyncs</th></tr>
+<tr><td>{{.Raft.FirstIndex}}</td><td>{{.Raft.LastIndex}}</td><td>{{.Raft.CommitIndex}}</td><td>{{.Raft.LastApplied}}</td>
+<td>{{.Raft.ServiceLastApplied}}</td><td>{{.Raft.LogEntries}}</td><td>{{.Raft.LogBytes}}</td><td>{{.Raft.Fsync.Count}}</td></tr>
+</table>
+
+<h3>Counters</h3>
+<table>
+<tr><th>elections</th><th>snapshots sent</th><th>snapshots installed</th><th>disk free</th></tr>
+<tr><td>{{.Raft.Elections}}</td><td>{{.Raft.SnapshotsSent}}</td><td>{{.Raft.SnapshotsInstalled}}</td>
+<td {{if .Raft.ReadOnly}}class="lag"{{end}}>{{.Raft.DiskFree}}{{if .Raft.ReadOnly}} (read-only){{end}}</td></tr>
+</table>
+
+<h3>Members</h3>
+<table>
+<tr><th>id</th><th>role</th><th>nextIndex</th><th>matchIndex</th><th>lag</th><th>behind (ms)</th><th>last heard (ms)</th><th>snapshot</th></tr>
+{{range .Raft.Members}}
+<tr><td>{{.Id}}</td><td class="{{.Role}}">{{.Role}}</td><td>{{.NextIndex}}</td><td>{{.MatchIndex}}</td>
+<td {{if gt .Lag 0}}class="lag"{{end}}>{{.Lag}}</td><td>{{.LagTime}}</td><td>{{.ReceiveTimeout}}</td>
+<td>{{if .InstallingSnapshot}}installing{{end}}</td></tr>
+{{end}}
+</table>
+
+<h3>Recent events</h3>
+<table>
+<tr><th>time</th><th>type</th><th>term</th><th>peer</th><th>index</th><th>detail</th></tr>
+{{range .Events}}
+<tr><td>{{.Time.Format "15:04:05.000"}}</td><td>{{.Type}}</td><td>{{.Term}}</td><td>{{.Peer}}</td><td>{{.Index}}</td><td>{{.Detail}}</td></tr>
+{{end}}
+</table>
+</body>
+</html>
+`
+
+var dashboardTemplate = template.Must(template.New("dashboard").Parse(dashboardHtml))
+
+// number of events shown on dashboard
+const DashboardEvents = 50
+
+type dashboardData struct{
+	Raft *raft.Metrics
+	Events []raft.Event
+}
+
+// GET /, /status
+func (s *AdminServer)handleDashboard(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" && r.URL.Path != "/status" {
+		http.NotFound(w, r)
+		return
+	}
+
+	data := new(dashboardData)
+	data.Raft = s.node.Metrics()
+	// newest first
+	events := s.node.Events(DashboardEvents)
+	for i := len(events) - 1; i >= 0; i -- {
+		data.Events = append(data.Events, events[i])
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err := dashboardTemplate.Execute(w, data)
+	if err != nil {
+		s.log.Warn("render dashboard error", "err", err)
+	}
+}
@@ -1,54 +1,55 @@
 package server
 
 import (
-	"fmt"
+	"time"
 	"strings"
-	"link"
+	"github.com/fallowu/big-ssdb/raft"
+	"github.com/fallowu/big-ssdb/internal/link"
+	"github.com/fallowu/big-ssdb/trace"
 )
 
 type Request struct{
 	Src int
 	Term int32
+	// when request is received
+	Time time.Time
+	// from receiving request to sending response
+	span trace.Span
 
-	ps []string
 	msg *link.Message
 }
 
 func NewRequest(m *link.Message) *Request {
 	ret := new(Request)
 	ret.msg = m
+	ret.Time = time.Now()
 	return ret
 }
 
-func (req *Request)Decode(buf string) bool {
-	req.ps = strings.SplitN(buf, " ", 3)
-	return true
-}
-
+// Entry data of a write, "" if the command is not a write
 func (req *Request)Encode() string {
 	cmd := req.Cmd()
 	key := req.Key()
 	val := req.Val()
 	
-	var s string
+	var p *raft.Payload
 	switch cmd {
 	case "set":
-		s = fmt.Sprintf("%s %s %s", cmd, key, val)
+		p = raft.NewPayload(cmd, key, val)
 	case "del":
-		s = fmt.Sprintf("%s %s", cmd, key)
+		p = raft.NewPayload(cmd, key)
 	case "incr":
 		if val == "" {
 			val = "1"
 		}
-		s = fmt.Sprintf("%s %s %s", cmd, key, val)
+		p = raft.NewPayload(cmd, key, val)
+	default:
+		return ""
 	}
-	return s
+	return p.Encode()
 }
 
 func (req *Request)Cmd() string {
-	if len(req.ps) > 0 {
-		return strings.ToLower(req.ps[0])
-	}
 	return strings.ToLower(req.msg.Cmd())
 }
 
@@ -61,12 +62,7 @@ func (req *Request)Val() string {
 }
 
 func (req *Request)Arg(idx int) string {
-	var args []string
-	if len(req.ps) > 0 {
-		args = req.ps[1 : ]
-	} else {
-		args = req.msg.Args()
-	}
+	args := req.msg.Args()
 	if len(args) <= idx {
 		return ""
 	}
@@ -0,0 +1,429 @@
+package server
+
+import (
+	"io"
+	"os"
+	"errors"
+	"context"
+	"sync"
+	"sync/atomic"
+	"strings"
+	"time"
+	"io/ioutil"
+
+	"github.com/fallowu/big-ssdb/raft"
+	"github.com/fallowu/big-ssdb/internal/ssdb"
+	"github.com/fallowu/big-ssdb/internal/link"
+	"github.com/fallowu/big-ssdb/internal/util"
+	"github.com/fallowu/big-ssdb/logger"
+	"github.com/fallowu/big-ssdb/trace"
+)
+
+// a write not committed in time is replied with an error, the client can't
+// tell whether it will be applied
+const DefaultRequestTimeout = 5 * time.Second
+
+type ServiceStatus int
+
+const(
+	ServiceStatusLogger = 0
+	ServiceStatusActive = 1
+)
+
+type Service struct{
+	// ServiceStatus, set by raft's goroutines
+	status int32
+	
+	lastApplied int64
+	// response to the last applied entry, see LastEffect()
+	lastEffect string
+	dir string
+	
+	db *ssdb.Db
+
+	node *raft.Node
+	xport *link.TcpServer
+	
+	// see DefaultRequestTimeout, 0 waits forever
+	RequestTimeout time.Duration
+	// see LeaderClientAddr()
+	ClientAddr ClientAddrMapper
+	stats *Stats
+	tracer trace.Tracer
+	log *logger.Logger
+	mux sync.Mutex
+	// serializes writers of the snapshot file, never held with mux
+	snapshotMux sync.Mutex
+}
+
+func NewService(dir string, node *raft.Node, xport *link.TcpServer) *Service {
+	svc := new(Service)
+	svc.db = ssdb.OpenDb(dir + "/data")
+	svc.log = logger.New("server").With("node", node.Id)
+	
+	svc.dir = dir
+	atomic.StoreInt32(&svc.status, ServiceStatusActive)
+	svc.lastApplied = svc.db.CommitIndex()
+
+	svc.node = node	
+	svc.xport = xport
+	svc.RequestTimeout = DefaultRequestTimeout
+	svc.ClientAddr = PortOffsetMapper(1000)
+	svc.stats = NewStats()
+	svc.tracer = trace.NoopTracer{}
+
+	svc.log.Info("init service", "lastApplied", svc.lastApplied)
+
+	node.SetService(svc)
+	node.Start()
+
+	return svc
+}
+
+// Ssdb and the client transport keep their own loggers
+func (svc *Service)SetLogger(l *logger.Logger){
+	svc.log = l
+}
+
+func (svc *Service)SetTracer(t trace.Tracer) {
+	svc.mux.Lock()
+	defer svc.mux.Unlock()
+	svc.tracer = t
+}
+
+func (svc *Service)Close() {
+	svc.xport.Close()
+	svc.node.Close()
+	svc.db.Close()
+}
+
+// Only copying the data set holds svc.mux, writes and reads go on while the
+// snapshot file is written
+func (svc *Service)MakeSnapshotToData() string {
+	svc.mux.Lock()
+	view := svc.db.View()
+	svc.mux.Unlock()
+	return svc.writeSnapshot(view)
+}
+
+// without svc.mux held
+func (svc *Service)writeSnapshot(view *ssdb.DbView) string {
+	svc.snapshotMux.Lock()
+	defer svc.snapshotMux.Unlock()
+
+	start := time.Now()
+	fn := svc.dir + "/snapshot.db"
+	view.MakeFileSnapshot(fn)
+	data, _ := ioutil.ReadFile(fn)
+	svc.log.Info("make snapshot", "commitIndex", view.CommitIndex(), "bytes", len(data), "elapsed", time.Since(start))
+	return string(data)
+}
+
+func (svc *Service)InstallSnapshotFromData(data string) {
+	if err := svc.RestoreSnapshot(strings.NewReader(data)); err != nil {
+		svc.log.Error("install snapshot", "err", err)
+	}
+}
+
+func (svc *Service)HandleClientMessage(msg *link.Message) {
+	svc.mux.Lock()
+	defer svc.mux.Unlock()
+
+	req := NewRequest(msg)
+	req.Src = msg.Src
+	
+	cmd := req.Cmd()
+	svc.stats.Call(cmd, req.Src, svc.xport.RemoteAddr(req.Src))
+	req.span = svc.tracer.Start(trace.SpanContext{}, "command " + cmd)
+	req.span.SetAttr("client", req.Src)
+
+	if cmd == "command" { // redis
+		resp := link.NewResponse(req.Src, []string{"ok"})
+		svc.reply(req, resp)
+		return
+	}
+	if cmd == "joingroup" {
+		svc.node.JoinGroup(req.Arg(0), req.Arg(1))
+		return
+	}
+	if cmd == "addmember" {
+		idx, err := svc.node.AddMember(req.Arg(0), req.Arg(1))
+		svc.replyIndex(req, idx, err)
+		return
+	}
+	if cmd == "setpriority" {
+		idx, err := svc.node.SetPriority(req.Arg(0), int(util.Atoi64(req.Arg(1))))
+		svc.replyIndex(req, idx, err)
+		return
+	}
+	if cmd == "delmember" {
+		idx, err := svc.node.DelMember(req.Arg(0))
+		svc.replyIndex(req, idx, err)
+		return
+	}
+	if cmd == "makesnapshot" {
+		// consistent view as of now, written and replied asynchronously
+		view := svc.db.View()
+		go func() {
+			data := svc.writeSnapshot(view)
+			svc.reply(req, link.NewResponse(req.Src, []string{"ok", data}))
+		}()
+		return
+	}
+	if cmd == "installsnapshot" {
+		// TODO:
+		return
+	}
+
+	if cmd == "checkconsistency" {
+		// reply asynchronously, members answer through raft messages
+		done := svc.node.CheckConsistency(util.Atoi64(req.Arg(0)))
+		go func() {
+			r := <-done
+			if r == nil {
+				svc.reply(req, link.NewErrorResponse(req.Src, "not leader or check in progress"))
+				return
+			}
+			svc.reply(req, link.NewResponse(req.Src, []string{"ok", r.Encode()}))
+		}()
+		return
+	}
+
+	if cmd == "checklog" {
+		done := svc.node.CheckLog(int(util.Atoi64(req.Arg(0))))
+		go func() {
+			r := <-done
+			if r == nil {
+				svc.reply(req, link.NewErrorResponse(req.Src, "not leader or check in progress"))
+				return
+			}
+			svc.reply(req, link.NewResponse(req.Src, []string{"ok", r.Encode()}))
+		}()
+		return
+	}
+
+	if cmd == "stats" {
+		resp := link.NewResponse(req.Src, []string{"ok", svc.stats.Encode()})
+		svc.reply(req, resp)
+		return
+	}
+
+	// client address of the leader
+	if cmd == "leader" {
+		addr := svc.LeaderClientAddr()
+		if addr == "" {
+			svc.reply(req, link.NewErrorResponse(req.Src, "leader unknown"))
+		} else {
+			svc.reply(req, link.NewResponse(req.Src, []string{"ok", addr}))
+		}
+		return
+	}
+
+	if cmd == "info" {
+		s := svc.node.Info()
+		resp := link.NewResponse(req.Src, []string{"ok", s})
+		svc.reply(req, resp)
+		return
+	}
+	
+	if atomic.LoadInt32(&svc.status) != ServiceStatusActive {
+		svc.log.Warn("Service unavailable")
+		resp := link.NewErrorResponse(req.Src, "Service unavailable")
+		svc.reply(req, resp)
+		return
+	}
+
+	if cmd == "get" {
+		s := svc.db.Get(req.Key())
+		if svc.log.DebugEnabled() {
+			svc.log.Debug("get", "key", req.Key(), "val", s)
+		}
+		resp := link.NewResponse(req.Src, []string{"ok", s})
+		svc.reply(req, resp)
+		return
+	}
+
+	if !svc.node.IsLeader() {
+		svc.log.Warn("not leader")
+		resp := link.NewErrorResponse(req.Src, "not leader")
+		svc.reply(req, resp)
+		return
+	}
+	
+	if err := svc.node.ReadOnly(); err != nil {
+		svc.log.Warn("reject write", "err", err)
+		resp := link.NewErrorResponse(req.Src, err.Error())
+		svc.reply(req, resp)
+		return
+	}
+	
+	s := req.Encode()
+	if s == "" {
+		svc.reply(req, link.NewErrorResponse(req.Src, "unkown cmd " + req.Cmd()))
+		return
+	}
+	go svc.proposeAndReply(req, s)
+}
+
+// without svc.mux held, ApplyEntry() takes it
+func (svc *Service)proposeAndReply(req *Request, data string) {
+	ctx := context.Background()
+	if svc.RequestTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, svc.RequestTimeout)
+		defer cancel()
+	}
+	res, err := svc.node.ProposeAndWaitWithTrace(ctx, data, req.span.Context())
+	if err != nil {
+		svc.log.Warn("write failed", "cmd", req.Cmd(), "err", err)
+		msg := err.Error()
+		if err == context.DeadlineExceeded {
+			msg = "timeout: " + msg
+		} else if err == raft.ErrProposalDropped {
+			// clients tell it from other errors, see jepsen.ClassifyError()
+			msg = "entry was overwritten by new leader"
+		}
+		svc.reply(req, link.NewErrorResponse(req.Src, msg))
+		return
+	}
+	resp, ok := res.([]string)
+	if !ok {
+		svc.reply(req, link.NewErrorResponse(req.Src, "bad entry"))
+		return
+	}
+	svc.reply(req, link.NewResponse(req.Src, resp))
+}
+
+// code and data replied to the client who proposed ent, nil if ent is not a
+// request
+func (svc *Service)handleRaftEntry(ent *raft.Entry) []string {
+	svc.mux.Lock()
+	defer svc.mux.Unlock()
+
+	code := "ok"
+	data := ""
+	svc.lastEffect = ""
+
+	if ent.Type != raft.EntryTypeData{
+		svc.lastEffect = code + " " + data
+		return nil
+	}
+	if svc.log.DebugEnabled() {
+		svc.log.Debug("apply", "index", ent.Index, "data", ent.Data)
+	}
+
+	p, err := raft.DecodePayload(ent.Data)
+	if err != nil {
+		svc.log.Warn("unknown entry", "index", ent.Index, "data", ent.Data)
+		return nil
+	}
+
+	cmd := strings.ToLower(p.Op)
+	key := p.Arg(0)
+	val := p.Arg(1)
+	
+	switch cmd {
+	case "set":
+		svc.db.Set(ent.Index, key, val)
+	case "del":
+		svc.db.Del(ent.Index, key)
+	case "incr":
+		data = svc.db.Incr(ent.Index, key, val)
+	default:
+		svc.log.Warn("unknown cmd", "index", ent.Index, "cmd", p.Op)
+		code = "error"
+		data = "unkown cmd " + p.Op
+	}
+
+	svc.lastEffect = code + " " + data
+	return []string{code, data}
+}
+
+// index of the proposed entry, or why it is rejected
+func (svc *Service)replyIndex(req *Request, idx int64, err error) {
+	if err != nil {
+		svc.reply(req, link.NewErrorResponse(req.Src, err.Error()))
+		return
+	}
+	svc.reply(req, link.NewResponse(req.Src, []string{"ok", util.I64toa(idx)}))
+}
+
+func (svc *Service)reply(req *Request, resp *link.Message) {
+	isError := strings.ToLower(resp.Code()) == "error"
+	svc.stats.Done(req.Cmd(), req.Src, time.Since(req.Time), isError)
+	if req.span != nil {
+		if isError {
+			req.span.SetAttr("error", resp.Args())
+		}
+		req.span.End()
+	}
+	svc.xport.Send(resp)
+}
+
+/* #################### raft.StateMachine interface ######################### */
+
+func (svc *Service)LastApplied() int64{
+	return svc.lastApplied
+}
+
+func (svc *Service)Apply(ent *raft.Entry) raft.Result {
+	// 不需要持久化, 从 Redolog 中获取
+	svc.lastApplied = ent.Index
+	return svc.handleRaftEntry(ent)
+}
+
+// Response code and data of the last applied entry, as replied to its client
+func (svc *Service)LastEffect() string {
+	return svc.lastEffect
+}
+
+func (svc *Service)StateHash() string {
+	return svc.db.StateHash()
+}
+
+// ssdb snapshot file, its first record is the commit index
+func (svc *Service)SaveSnapshot(w io.Writer) error {
+	_, err := io.WriteString(w, svc.MakeSnapshotToData())
+	return err
+}
+
+func (svc *Service)RestoreSnapshot(r io.Reader) error {
+	svc.snapshotMux.Lock()
+	defer svc.snapshotMux.Unlock()
+
+	fn := svc.dir + "/restore.db"
+	f, err := os.Create(fn)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, r)
+	f.Close()
+	if err != nil {
+		return err
+	}
+	defer os.Remove(fn)
+
+	svc.mux.Lock()
+	defer svc.mux.Unlock()
+	if !svc.db.RestoreFileSnapshot(fn) {
+		return errors.New("bad snapshot")
+	}
+	svc.lastApplied = svc.db.CommitIndex()
+	atomic.StoreInt32(&svc.status, ServiceStatusActive)
+	svc.log.Info("restore snapshot", "lastApplied", svc.lastApplied)
+	return nil
+}
+
+func (svc *Service)RaftApplyBroken() {
+	atomic.StoreInt32(&svc.status, ServiceStatusLogger)
+	svc.log.Warn("Service become unavailable")
+}
+
+// no leader-only jobs yet
+func (svc *Service)OnBecomeLeader(term int32, commitIndex int64) {
+	svc.log.Info("raft became leader", "term", term, "commitIndex", commitIndex)
+}
+
+func (svc *Service)OnLoseLeadership(term int32, commitIndex int64) {
+	svc.log.Info("raft lost leadership", "term", term, "commitIndex", commitIndex)
+}
@@ -0,0 +1,144 @@
+package server
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fallowu/big-ssdb/raft"
+	"github.com/fallowu/big-ssdb/internal/link"
+	"github.com/fallowu/big-ssdb/logger"
+)
+
+// Services of a group linked in memory, the first one bootstraps the group
+// and the others join it
+type testGroup struct{
+	svcs map[string]*Service
+	// clients of svcs
+	clients map[string]*link.Client
+	// "src dst" => true if messages are dropped
+	cuts sync.Map
+}
+
+func newTestGroup(t *testing.T, opts []raft.Option, ids ...string) *testGroup {
+	t.Helper()
+	logger.SetDefaultLevel(logger.LevelError)
+	g := &testGroup{svcs: make(map[string]*Service), clients: make(map[string]*link.Client)}
+	nodes := make(map[string]*raft.Node)
+	for _, id := range ids {
+		nodes[id] = raft.NewNode(id, "addr-" + id, raft.NewMemDb(), opts...)
+	}
+	for _, id := range ids {
+		nodes[id].SetOutbox(func(msg *raft.Message) {
+			if _, cut := g.cuts.Load(msg.Src + " " + msg.Dst); !cut && nodes[msg.Dst] != nil {
+				nodes[msg.Dst].Deliver(msg)
+			}
+		})
+		xport := link.NewTcpServer("127.0.0.1", 0)
+		svc := NewService(t.TempDir(), nodes[id], xport)
+		go func() {
+			for msg := range xport.C {
+				svc.HandleClientMessage(msg)
+			}
+		}()
+		c, err := link.Dial(xport.Addr(), time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		g.svcs[id] = svc
+		g.clients[id] = c
+		t.Cleanup(func() {
+			c.Close()
+			svc.Close()
+		})
+	}
+
+	leader := nodes[ids[0]]
+	index, err := leader.AddMember(leader.Id, leader.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, 10 * time.Second, "bootstrap", func() bool {
+		return leader.Status().CommitIndex >= index
+	})
+	for _, id := range ids[1:] {
+		index, err := leader.AddMember(id, nodes[id].Addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		nodes[id].JoinGroup(leader.Id, leader.Addr)
+		waitFor(t, 10 * time.Second, "join " + id, func() bool {
+			return nodes[id].Status().CommitIndex >= index
+		})
+	}
+	return g
+}
+
+// messages from src to dst are dropped until Heal()
+func (g *testGroup)Cut(src, dst string) {
+	g.cuts.Store(src + " " + dst, true)
+}
+
+func (g *testGroup)Heal() {
+	g.cuts.Range(func(key, value interface{}) bool {
+		g.cuts.Delete(key)
+		return true
+	})
+}
+
+// leader of a group of one, serving clients on a random port
+func newTestService(t *testing.T, opts ...raft.Option) (*Service, *link.Client) {
+	g := newTestGroup(t, opts, "n1")
+	return g.svcs["n1"], g.clients["n1"]
+}
+
+// waits for cond, checked every 10ms
+func waitFor(t *testing.T, timeout time.Duration, what string, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(timeout); !cond(); time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal(what, "timeout")
+		}
+	}
+}
+
+func TestServiceReadOnly(t *testing.T){
+	var free uint64 = 100
+	usage := func(dir string) (raft.DiskUsage, error) {
+		return raft.DiskUsage{Total: 1000, Free: atomic.LoadUint64(&free)}, nil
+	}
+	svc, c := newTestService(t, raft.WithDiskUsage(usage))
+	if _, err := c.Call("set", "k", "v1"); err != nil {
+		t.Fatal(err)
+	}
+
+	svc.node.SetDataDir(t.TempDir(), 200)
+	if _, err := c.Call("set", "k", "v2"); err == nil || !strings.HasPrefix(err.Error(), "read-only") {
+		t.Fatal("write accepted", err)
+	}
+	if rs, err := c.Call("get", "k"); err != nil || len(rs) == 0 || rs[0] != "v1" {
+		t.Fatal("read", rs, err)
+	}
+	if m := svc.node.Metrics(); !m.ReadOnly || m.DiskFree != 100 {
+		t.Fatal("metrics", m.ReadOnly, m.DiskFree)
+	}
+
+	// above MinFreeBytes, within DiskFreeHysteresis
+	atomic.StoreUint64(&free, 210)
+	time.Sleep(raft.DiskCheckInterval * 3 / 2 * time.Millisecond)
+	if svc.node.ReadOnly() == nil {
+		t.Fatal("left read-only mode below hysteresis")
+	}
+	atomic.StoreUint64(&free, 500)
+	waitFor(t, 5 * time.Second, "writable", func() bool {
+		return svc.node.ReadOnly() == nil
+	})
+	if _, err := c.Call("set", "k", "v3"); err != nil {
+		t.Fatal(err)
+	}
+	if rs, err := c.Call("get", "k"); err != nil || len(rs) == 0 || rs[0] != "v3" {
+		t.Fatal("read", rs, err)
+	}
+}
@@ -0,0 +1,189 @@
+package server
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/fallowu/big-ssdb/metrics"
+)
+
+const(
+	// commands beyond this are counted as StatsOtherCommand
+	MaxStatsCommands = 128
+	StatsOtherCommand = "other"
+	// least recently active connections are forgotten beyond this
+	MaxStatsConns = 1024
+)
+
+type CommandStats struct{
+	Calls int64
+	Errors int64
+	Latency *metrics.Histogram
+}
+
+func newCommandStats() *CommandStats {
+	ret := new(CommandStats)
+	ret.Latency = metrics.NewLatencyHistogram()
+	return ret
+}
+
+type ConnStats struct{
+	CommandStats
+	Remote string
+	lastActive time.Time
+}
+
+// thread safe
+type Stats struct{
+	cmds map[string]*CommandStats
+	conns map[int]*ConnStats
+	mux sync.Mutex
+}
+
+func NewStats() *Stats {
+	ret := new(Stats)
+	ret.cmds = make(map[string]*CommandStats)
+	ret.conns = make(map[int]*ConnStats)
+	return ret
+}
+
+func (s *Stats)command(cmd string) *CommandStats {
+	cs := s.cmds[cmd]
+	if cs == nil {
+		if len(s.cmds) >= MaxStatsCommands {
+			cmd = StatsOtherCommand
+			if cs = s.cmds[cmd]; cs != nil {
+				return cs
+			}
+		}
+		cs = newCommandStats()
+		s.cmds[cmd] = cs
+	}
+	return cs
+}
+
+func (s *Stats)conn(src int) *ConnStats {
+	cs := s.conns[src]
+	if cs == nil {
+		if len(s.conns) >= MaxStatsConns {
+			s.evictConn()
+		}
+		cs = new(ConnStats)
+		cs.Latency = metrics.NewLatencyHistogram()
+		s.conns[src] = cs
+	}
+	cs.lastActive = time.Now()
+	return cs
+}
+
+func (s *Stats)evictConn() {
+	oldest := -1
+	for src, cs := range s.conns {
+		if oldest == -1 || cs.lastActive.Before(s.conns[oldest].lastActive) {
+			oldest = src
+		}
+	}
+	delete(s.conns, oldest)
+}
+
+// remote is the address of client connection src
+func (s *Stats)Call(cmd string, src int, remote string) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.command(cmd).Calls ++
+	conn := s.conn(src)
+	conn.Calls ++
+	conn.Remote = remote
+}
+
+// latency from receiving request to sending response
+func (s *Stats)Done(cmd string, src int, latency time.Duration, isError bool) {
+	s.mux.Lock()
+	cs := s.command(cmd)
+	conn := s.conn(src)
+	if isError {
+		cs.Errors ++
+		conn.Errors ++
+	}
+	s.mux.Unlock()
+	cs.Latency.ObserveDuration(latency)
+	conn.Latency.ObserveDuration(latency)
+}
+
+type CommandStatsSnapshot struct{
+	Calls int64
+	Errors int64
+	Latency *metrics.HistogramSnapshot
+}
+
+// average latency in ms
+func (ss *CommandStatsSnapshot)AvgMs() float64 {
+	if ss.Latency.Count == 0 {
+		return 0
+	}
+	return ss.Latency.Sum / float64(ss.Latency.Count) * 1000
+}
+
+type ConnStatsSnapshot struct{
+	CommandStatsSnapshot
+	Remote string
+}
+
+func (cs *CommandStats)snapshot() CommandStatsSnapshot {
+	return CommandStatsSnapshot{cs.Calls, cs.Errors, cs.Latency.Snapshot()}
+}
+
+func (s *Stats)Commands() map[string]*CommandStatsSnapshot {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	ret := make(map[string]*CommandStatsSnapshot)
+	for cmd, cs := range s.cmds {
+		ss := cs.snapshot()
+		ret[cmd] = &ss
+	}
+	return ret
+}
+
+// client id => stats
+func (s *Stats)Conns() map[int]*ConnStatsSnapshot {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	ret := make(map[int]*ConnStatsSnapshot)
+	for src, cs := range s.conns {
+		ret[src] = &ConnStatsSnapshot{cs.snapshot(), cs.Remote}
+	}
+	return ret
+}
+
+// reply of `stats` command
+func (s *Stats)Encode() string {
+	var ret string
+
+	cmds := s.Commands()
+	names := make([]string, 0, len(cmds))
+	for cmd, _ := range cmds {
+		names = append(names, cmd)
+	}
+	sort.Strings(names)
+	for _, cmd := range names {
+		cs := cmds[cmd]
+		ret += fmt.Sprintf("cmd.%s: calls=%d errors=%d avg_ms=%.3f\n", cmd, cs.Calls, cs.Errors, cs.AvgMs())
+	}
+
+	conns := s.Conns()
+	ids := make([]int, 0, len(conns))
+	for src, _ := range conns {
+		ids = append(ids, src)
+	}
+	sort.Ints(ids)
+	for _, src := range ids {
+		cs := conns[src]
+		ret += fmt.Sprintf("conn.%d: remote=%s calls=%d errors=%d avg_ms=%.3f\n",
+				src, cs.Remote, cs.Calls, cs.Errors, cs.AvgMs())
+	}
+	return ret
+}
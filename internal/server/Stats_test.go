@@ -0,0 +1,40 @@
+package server
+
+import (
+	"testing"
+	"time"
+	"strings"
+)
+
+func TestStats(t *testing.T){
+	s := NewStats()
+	s.Call("get", 1, "127.0.0.1:1000")
+	s.Done("get", 1, time.Millisecond, false)
+	s.Call("set", 2, "127.0.0.1:2000")
+	s.Done("set", 2, time.Millisecond, true)
+
+	cmds := s.Commands()
+	if cmds["get"].Calls != 1 || cmds["get"].Errors != 0 || cmds["set"].Errors != 1 {
+		t.Fatal("bad command stats")
+	}
+	conns := s.Conns()
+	if conns[2].Remote != "127.0.0.1:2000" || conns[2].Errors != 1 {
+		t.Fatal("bad conn stats")
+	}
+	if !strings.Contains(s.Encode(), "cmd.set: calls=1 errors=1") {
+		t.Fatal(s.Encode())
+	}
+
+	for i := 0; i < MaxStatsCommands + 10; i ++ {
+		s.Call(string(rune('a' + i)), 1, "")
+	}
+	if len(s.Commands()) > MaxStatsCommands + 1 {
+		t.Fatal("commands not bounded")
+	}
+	for i := 0; i < MaxStatsConns + 10; i ++ {
+		s.Call("get", 100 + i, "")
+	}
+	if len(s.Conns()) > MaxStatsConns {
+		t.Fatal("conns not bounded")
+	}
+}
@@ -0,0 +1,39 @@
+package server
+
+import (
+	"bytes"
+	"time"
+	"net/http"
+	"encoding/json"
+
+	"github.com/fallowu/big-ssdb/raft"
+	"github.com/fallowu/big-ssdb/logger"
+)
+
+// raft.EventSink posting each event as JSON to url
+type WebhookSink struct{
+	url string
+	client *http.Client
+	log *logger.Logger
+}
+
+func NewWebhookSink(url string) *WebhookSink {
+	s := new(WebhookSink)
+	s.url = url
+	s.client = &http.Client{Timeout: 5 * time.Second}
+	s.log = logger.New("server")
+	return s
+}
+
+func (s *WebhookSink)HandleEvent(ev *raft.SinkEvent) {
+	bs, _ := json.Marshal(ev)
+	resp, err := s.client.Post(s.url, "application/json", bytes.NewReader(bs))
+	if err != nil {
+		s.log.Warn("post event error", "url", s.url, "err", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode / 100 != 2 {
+		s.log.Warn("post event error", "url", s.url, "status", resp.StatusCode)
+	}
+}
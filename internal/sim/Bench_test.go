@@ -0,0 +1,91 @@
+package sim
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fallowu/big-ssdb/logger"
+)
+
+// go test -run XXX -bench . sim
+//
+// ns/op is wall clock time per proposal, raft's CPU cost plus FsyncCost.
+// sim-ms/commit is virtual time from proposal to leader's commit, latency
+// on a network of DefaultMinLatency ~ DefaultMaxLatency.
+func benchPropose(b *testing.B, nodes int, batch int, size int, fsync time.Duration) {
+	logger.SetDefaultLevel(logger.LevelError)
+	ids := make([]string, nodes)
+	for i := range ids {
+		ids[i] = fmt.Sprintf("n%d", i + 1)
+	}
+	s := New(1)
+	if !s.Bootstrap(ids...) {
+		b.Fatal("bootstrap failed\n" + s.Status())
+	}
+	for _, db := range s.dbs {
+		db.FsyncCost = fsync
+	}
+	leader := s.Leader()
+	data := strings.Repeat("x", size)
+
+	var latency time.Duration
+	commits := 0
+	b.ResetTimer()
+	for n := 0; n < b.N; n += batch {
+		var idx int64
+		for i := 0; i < batch; i ++ {
+			_, idx, _ = leader.Propose(data)
+		}
+		if idx <= 0 {
+			b.Fatal("not leader\n" + s.Status())
+		}
+		leader.StepTick(0)
+		start := s.Clock.Now()
+		ok := s.RunUntil(func() bool {
+			return leader.LastApplied() >= idx
+		}, 30 * time.Second)
+		if !ok {
+			b.Fatal("not committed\n" + s.Status())
+		}
+		latency += s.Clock.Now().Sub(start)
+		commits ++
+	}
+	b.StopTimer()
+	b.ReportMetric(float64(latency.Milliseconds()) / float64(commits), "sim-ms/commit")
+	b.SetBytes(int64(size))
+}
+
+func BenchmarkProposeClusterSize(b *testing.B){
+	for _, n := range []int{1, 3, 5} {
+		b.Run(fmt.Sprintf("nodes=%d", n), func(b *testing.B) {
+			benchPropose(b, n, 1, 100, 0)
+		})
+	}
+}
+
+func BenchmarkProposeBatch(b *testing.B){
+	for _, batch := range []int{1, 10, 100} {
+		b.Run(fmt.Sprintf("batch=%d", batch), func(b *testing.B) {
+			benchPropose(b, 3, batch, 100, 0)
+		})
+	}
+}
+
+func BenchmarkProposeEntrySize(b *testing.B){
+	for _, size := range []int{16, 1024, 16 * 1024} {
+		b.Run(fmt.Sprintf("size=%d", size), func(b *testing.B) {
+			benchPropose(b, 3, 10, size, 0)
+		})
+	}
+}
+
+// FsyncCost emulates disks, there is no fsync policy to choose yet
+func BenchmarkProposeFsync(b *testing.B){
+	for _, cost := range []time.Duration{0, 100 * time.Microsecond, time.Millisecond} {
+		b.Run(fmt.Sprintf("fsync=%s", cost), func(b *testing.B) {
+			benchPropose(b, 3, 10, 100, cost)
+		})
+	}
+}
@@ -0,0 +1,108 @@
+package sim
+
+import (
+	"fmt"
+	"testing"
+	"time"
+	"math/rand"
+)
+
+// Wait for a leader to commit its last entry and every running node to apply it
+func waitRecovered(w *Workload, s *Sim, max time.Duration) bool {
+	return s.RunUntil(func() bool {
+		leader := s.Leader()
+		if leader == nil {
+			return false
+		}
+		// leader's noop committed, so are entries of previous terms
+		m := leader.Metrics()
+		if m.CommitIndex < m.LastIndex {
+			return false
+		}
+		last := m.CommitIndex
+		for _, id := range s.Ids() {
+			if s.Node(id) == nil {
+				continue
+			}
+			if svc := w.Service(id); !svc.Broken && svc.LastApplied() < last {
+				return false
+			}
+		}
+		return true
+	}, max)
+}
+
+// Kill a node(the leader half of the time if killLeader) while clients are
+// writing, with or without losing its unsynced writes, restart it, and wait
+// for the cluster to recover. No acknowledged write may be lost.
+func testChaos(t *testing.T, seed int64, n int, rounds int, killLeader bool) {
+	s := New(seed)
+	var ids []string
+	for i := 1; i <= n; i ++ {
+		ids = append(ids, fmt.Sprintf("n%d", i))
+	}
+	if !s.Bootstrap(ids...) {
+		t.Fatal("bootstrap failed\n" + s.Status())
+	}
+	w := NewWorkload(s, seed, 5, 3)
+	r := rand.New(rand.NewSource(seed))
+	for i := 0; i < rounds; i ++ {
+		w.Run(time.Duration(200 + r.Intn(800)) * time.Millisecond)
+		id := ids[r.Intn(n)]
+		if leader := s.Leader(); leader != nil {
+			if killLeader && r.Intn(2) == 0 {
+				id = leader.Id
+			} else if !killLeader && id == leader.Id {
+				id = ids[(r.Intn(n - 1) + 1 + indexOf(ids, id)) % n]
+			}
+		}
+		if r.Intn(2) == 0 {
+			s.Crash(id)
+		} else {
+			s.PowerOff(id)
+		}
+		w.Run(time.Duration(r.Intn(5000)) * time.Millisecond)
+		s.Restart(id)
+
+		if !waitRecovered(w, s, 30 * time.Second) {
+			t.Fatalf("round %d: not recovered after restart of %s\n%s", i, id, s.Status())
+		}
+		if err := w.CheckAcked(); err != nil {
+			t.Fatalf("round %d: %s\n%s", i, err, s.Status())
+		}
+	}
+	w.Stop()
+	if !waitRecovered(w, s, 30 * time.Second) {
+		t.Fatal("not recovered\n" + s.Status())
+	}
+	if err := w.CheckAcked(); err != nil {
+		t.Fatal(err)
+	}
+	if ok, key := CheckLinearizable(w.History()); !ok {
+		t.Fatal("history not linearizable, key", key)
+	}
+	t.Logf("seed=%d nodes=%d completed=%d timedout=%d", seed, n, w.Completed, w.TimedOut)
+}
+
+func indexOf(ids []string, id string) int {
+	for i, s := range ids {
+		if s == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func TestChaosFollowers(t *testing.T){
+	for seed := int64(1); seed <= 3; seed ++ {
+		testChaos(t, seed, 3, 20, false)
+	}
+	testChaos(t, 1, 5, 20, false)
+}
+
+func TestChaos(t *testing.T){
+	for seed := int64(1); seed <= 3; seed ++ {
+		testChaos(t, seed, 3, 20, true)
+	}
+	testChaos(t, 1, 5, 20, true)
+}
@@ -0,0 +1,115 @@
+package sim
+
+import (
+	"io"
+	"strings"
+	"encoding/json"
+
+	"github.com/fallowu/big-ssdb/raft"
+)
+
+// Entry data: "put key value" or "get key"
+func EncodeOp(kind OpKind, key string, value string) string {
+	if kind == OpGet {
+		return "get " + key
+	}
+	return "put " + key + " " + value
+}
+
+// raft.StateMachine of a key-value store in memory, it survives restart of its node.
+// Reads go through the log too, so that they are linearizable.
+type KVService struct{
+	Id string
+	data map[string]string
+	lastApplied int64
+	// data of applied entries, by index
+	applied map[int64]string
+	// output of the last applied entry
+	lastEffect string
+	// RaftApplyBroken() was called, the service stops applying
+	Broken bool
+	// called after each entry is applied, output is the value read or written
+	OnApply func(id string, ent *raft.Entry, output string)
+}
+
+func NewKVService(id string, lastApplied int64) *KVService {
+	svc := new(KVService)
+	svc.Id = id
+	svc.data = make(map[string]string)
+	svc.applied = make(map[int64]string)
+	svc.lastApplied = lastApplied
+	return svc
+}
+
+func (svc *KVService)Get(key string) string {
+	return svc.data[key]
+}
+
+// Data of the entry applied at index
+func (svc *KVService)Applied(index int64) (string, bool) {
+	data, ok := svc.applied[index]
+	return data, ok
+}
+
+func (svc *KVService)LastApplied() int64 {
+	return svc.lastApplied
+}
+
+// raft.EffectReporter
+func (svc *KVService)LastEffect() string {
+	return svc.lastEffect
+}
+
+// returns the output of the op, see LastEffect()
+func (svc *KVService)Apply(ent *raft.Entry) raft.Result {
+	svc.lastApplied = ent.Index
+	svc.lastEffect = ""
+	if ent.Type != raft.EntryTypeData {
+		return nil
+	}
+	svc.applied[ent.Index] = ent.Data
+	var output string
+	ps := strings.SplitN(ent.Data, " ", 3)
+	if len(ps) == 3 && ps[0] == string(OpPut) {
+		svc.data[ps[1]] = ps[2]
+		output = ps[2]
+	} else if len(ps) == 2 && ps[0] == string(OpGet) {
+		output = svc.data[ps[1]]
+	}
+	svc.lastEffect = output
+	if svc.OnApply != nil {
+		svc.OnApply(svc.Id, ent, output)
+	}
+	return output
+}
+
+// Entries before the raft snapshot are lost and the snapshot carries no
+// state. Applying stops(by Storage) until Broken is cleared.
+func (svc *KVService)RaftApplyBroken() {
+	svc.Broken = true
+}
+
+type kvSnapshot struct{
+	Version int
+	LastApplied int64
+	Data map[string]string
+}
+
+func (svc *KVService)SaveSnapshot(w io.Writer) error {
+	return json.NewEncoder(w).Encode(&kvSnapshot{1, svc.lastApplied, svc.data})
+}
+
+// history of applied entries is not restored
+func (svc *KVService)RestoreSnapshot(r io.Reader) error {
+	var sn kvSnapshot
+	if err := json.NewDecoder(r).Decode(&sn); err != nil {
+		return err
+	}
+	svc.data = sn.Data
+	if svc.data == nil {
+		svc.data = make(map[string]string)
+	}
+	svc.lastApplied = sn.LastApplied
+	svc.Broken = false
+	return nil
+}
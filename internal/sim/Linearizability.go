@@ -0,0 +1,169 @@
+package sim
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"math"
+)
+
+type OpKind string
+
+const(
+	OpGet OpKind = "get"
+	OpPut OpKind = "put"
+)
+
+// Returned at ReturnNever if the client never got the response,
+// the operation may or may not have taken effect.
+const ReturnNever = math.MaxInt64
+
+// One client operation on a key-value store
+type Operation struct{
+	Client int
+	Kind OpKind
+	Key string
+	Value string  // put: value written, get: value read
+	Call int64    // virtual time, ns
+	Return int64
+}
+
+func (op Operation)String() string {
+	ret := "never"
+	if op.Return != ReturnNever {
+		ret = fmt.Sprintf("%d", op.Return)
+	}
+	return fmt.Sprintf("c%d %s %s=%q [%d, %s]", op.Client, op.Kind, op.Key, op.Value, op.Call, ret)
+}
+
+// Checks whether history is linearizable with respect to a key-value store
+// whose keys are initially "". Keys are independent, so each key is checked
+// on its own, using the Wing & Gong algorithm with Lowe's memoization(as
+// Knossos and Porcupine do). Gets without response must be removed by the
+// caller, they have no observable effect. Returns the key failed the check, or "".
+func CheckLinearizable(history []Operation) (bool, string) {
+	byKey := make(map[string][]Operation)
+	for _, op := range history {
+		byKey[op.Key] = append(byKey[op.Key], op)
+	}
+	keys := make([]string, 0, len(byKey))
+	for k, _ := range byKey {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if !checkRegister(byKey[k]) {
+			return false, k
+		}
+	}
+	return true, ""
+}
+
+type lEntry struct{
+	id int // index in ops
+	isCall bool
+	time int64
+	match *lEntry // return entry of a call
+	prev, next *lEntry
+}
+
+func (e *lEntry)lift() {
+	e.prev.next = e.next
+	e.next.prev = e.prev
+	m := e.match
+	m.prev.next = m.next
+	if m.next != nil {
+		m.next.prev = m.prev
+	}
+}
+
+func (e *lEntry)unlift() {
+	m := e.match
+	m.prev.next = m
+	if m.next != nil {
+		m.next.prev = m
+	}
+	e.prev.next = e
+	e.next.prev = e
+}
+
+type lFrame struct{
+	entry *lEntry
+	state string
+}
+
+func checkRegister(ops []Operation) bool {
+	var events []*lEntry
+	for i, op := range ops {
+		call := &lEntry{id: i, isCall: true, time: op.Call}
+		ret := &lEntry{id: i, time: op.Return}
+		call.match = ret
+		events = append(events, call, ret)
+	}
+	// calls before returns at the same time, as if concurrent
+	sort.SliceStable(events, func(i, j int) bool {
+		if events[i].time != events[j].time {
+			return events[i].time < events[j].time
+		}
+		return events[i].isCall && !events[j].isCall
+	})
+	head := new(lEntry)
+	prev := head
+	for _, e := range events {
+		prev.next = e
+		e.prev = prev
+		prev = e
+	}
+
+	linearized := make([]uint64, (len(ops) + 63) / 64)
+	cache := make(map[string]bool)
+	var stack []lFrame
+	state := ""
+	entry := head.next
+	for head.next != nil {
+		if entry.isCall {
+			op := ops[entry.id]
+			newState, ok := state, true
+			if op.Kind == OpPut {
+				newState = op.Value
+			} else {
+				ok = op.Value == state
+			}
+			if ok {
+				linearized[entry.id / 64] |= 1 << uint(entry.id % 64)
+				key := cacheKey(linearized, newState)
+				if !cache[key] {
+					cache[key] = true
+					stack = append(stack, lFrame{entry, state})
+					state = newState
+					entry.lift()
+					entry = head.next
+					continue
+				}
+				linearized[entry.id / 64] &^= 1 << uint(entry.id % 64)
+			}
+			entry = entry.next
+		} else {
+			// an operation returned before being linearized, backtrack
+			if len(stack) == 0 {
+				return false
+			}
+			top := stack[len(stack) - 1]
+			stack = stack[:len(stack) - 1]
+			linearized[top.entry.id / 64] &^= 1 << uint(top.entry.id % 64)
+			state = top.state
+			top.entry.unlift()
+			entry = top.entry.next
+		}
+	}
+	return true
+}
+
+func cacheKey(bits []uint64, state string) string {
+	var sb strings.Builder
+	for _, b := range bits {
+		fmt.Fprintf(&sb, "%x,", b)
+	}
+	sb.WriteString(state)
+	return sb.String()
+}
@@ -0,0 +1,123 @@
+package sim
+
+import (
+	"fmt"
+	"testing"
+	"time"
+	"math/rand"
+)
+
+func TestCheckLinearizable(t *testing.T){
+	ok := []Operation{
+		{Client: 0, Kind: OpPut, Key: "a", Value: "1", Call: 0, Return: 10},
+		{Client: 1, Kind: OpGet, Key: "a", Value: "", Call: 1, Return: 5},
+		{Client: 1, Kind: OpGet, Key: "a", Value: "1", Call: 6, Return: 12},
+		{Client: 2, Kind: OpPut, Key: "a", Value: "2", Call: 11, Return: ReturnNever},
+		{Client: 0, Kind: OpGet, Key: "a", Value: "1", Call: 13, Return: 14},
+	}
+	if r, key := CheckLinearizable(ok); !r {
+		t.Fatal("expected linearizable, failed key", key)
+	}
+
+	// stale read after a newer value was read
+	bad := []Operation{
+		{Client: 0, Kind: OpPut, Key: "a", Value: "1", Call: 0, Return: 2},
+		{Client: 0, Kind: OpPut, Key: "a", Value: "2", Call: 3, Return: 10},
+		{Client: 1, Kind: OpGet, Key: "a", Value: "2", Call: 4, Return: 5},
+		{Client: 2, Kind: OpGet, Key: "a", Value: "1", Call: 6, Return: 7},
+	}
+	if r, key := CheckLinearizable(bad); r || key != "a" {
+		t.Fatal("expected not linearizable")
+	}
+
+	// lost write
+	bad = []Operation{
+		{Client: 0, Kind: OpPut, Key: "b", Value: "1", Call: 0, Return: 2},
+		{Client: 1, Kind: OpGet, Key: "b", Value: "", Call: 3, Return: 4},
+	}
+	if r, _ := CheckLinearizable(bad); r {
+		t.Fatal("expected not linearizable")
+	}
+}
+
+// Randomly partition, crash and restart a minority of nodes
+func runNemesis(w *Workload, s *Sim, seed int64, d time.Duration) {
+	r := rand.New(rand.NewSource(seed))
+	ids := s.Ids()
+	down := make(map[string]bool)
+	end := s.Clock.Now().Add(d)
+	for s.Clock.Now().Before(end) {
+		w.Run(time.Duration(500 + r.Intn(1500)) * time.Millisecond)
+		id := ids[r.Intn(len(ids))]
+		// leader is the interesting victim
+		if leader := s.Leader(); leader != nil && r.Intn(2) == 0 {
+			id = leader.Id
+		}
+		switch r.Intn(4) {
+		case 0:
+			if len(down) < (len(ids) - 1) / 2 && !down[id] {
+				down[id] = true
+				s.Isolate(id)
+			}
+		case 1:
+			if len(down) < (len(ids) - 1) / 2 && !down[id] {
+				down[id] = true
+				s.Crash(id)
+			}
+		default:
+			s.Heal()
+			for id, _ := range down {
+				if s.Node(id) == nil {
+					s.Restart(id)
+				}
+			}
+			down = make(map[string]bool)
+		}
+	}
+	s.Heal()
+	for id, _ := range down {
+		if s.Node(id) == nil {
+			s.Restart(id)
+		}
+	}
+}
+
+func testLinearizable(t *testing.T, seed int64, n int, lossRate float64) {
+	s := New(seed)
+	var ids []string
+	for i := 1; i <= n; i ++ {
+		ids = append(ids, fmt.Sprintf("n%d", i))
+	}
+	if !s.Bootstrap(ids...) {
+		t.Fatal("bootstrap failed\n" + s.Status())
+	}
+	s.Net.LossRate = lossRate
+	w := NewWorkload(s, seed, 5, 3)
+	runNemesis(w, s, seed, 30 * time.Second)
+	w.Stop()
+
+	t.Logf("seed=%d nodes=%d completed=%d failed=%d timedout=%d", seed, n, w.Completed, w.Failed, w.TimedOut)
+	if w.Completed == 0 {
+		t.Fatal("no operation completed\n" + s.Status())
+	}
+	if ok, key := CheckLinearizable(w.History()); !ok {
+		for _, op := range w.History() {
+			if op.Key == key {
+				t.Log(op)
+			}
+		}
+		t.Fatal("history not linearizable, key", key)
+	}
+}
+
+func TestLinearizable3(t *testing.T){
+	for seed := int64(1); seed <= 3; seed ++ {
+		testLinearizable(t, seed, 3, 0)
+	}
+}
+
+func TestLinearizable5Lossy(t *testing.T){
+	for seed := int64(1); seed <= 3; seed ++ {
+		testLinearizable(t, seed, 5, 0.05)
+	}
+}
@@ -0,0 +1,128 @@
+package sim
+
+import (
+	"time"
+
+	"github.com/fallowu/big-ssdb/raft"
+	"github.com/fallowu/big-ssdb/internal/util"
+)
+
+type memWrite struct{
+	key string
+	val string
+	del bool
+	clean bool // CleanAll()
+}
+
+// raft.Db in memory, survives restart of the node using it. Writes are
+// volatile until Fsync(), LoseUnsynced() drops them as a power failure would.
+type MemDb struct{
+	mm map[string]string
+	unsynced []memWrite
+	// wall clock time spent in Fsync(), to emulate disks in benchmarks
+	FsyncCost time.Duration
+}
+
+func NewMemDb() *MemDb {
+	db := new(MemDb)
+	db.mm = make(map[string]string)
+	return db
+}
+
+func (db *MemDb)Close(){
+}
+
+func (db *MemDb)Fsync() error {
+	// spin, sleeps shorter than the timer resolution take far longer
+	for start := time.Now(); time.Since(start) < db.FsyncCost; {
+	}
+	for _, w := range db.unsynced {
+		db.apply(w)
+	}
+	db.unsynced = nil
+	return nil
+}
+
+func (db *MemDb)apply(w memWrite) {
+	if w.clean {
+		db.mm = make(map[string]string)
+	} else if w.del {
+		delete(db.mm, w.key)
+	} else {
+		db.mm[w.key] = w.val
+	}
+}
+
+func (db *MemDb)Get(key string) string {
+	for i := len(db.unsynced) - 1; i >= 0; i -- {
+		w := db.unsynced[i]
+		if w.clean {
+			return ""
+		}
+		if w.key == key {
+			if w.del {
+				return ""
+			}
+			return w.val
+		}
+	}
+	return db.mm[key]
+}
+
+func (db *MemDb)Set(key string, val string) {
+	db.unsynced = append(db.unsynced, memWrite{key: key, val: val})
+}
+
+func (db *MemDb)Delete(key string) {
+	db.unsynced = append(db.unsynced, memWrite{key: key, del: true})
+}
+
+func (db *MemDb)WriteBatch(b *raft.Batch) {
+	for _, op := range b.Ops {
+		db.unsynced = append(db.unsynced, memWrite{key: op.Key, val: op.Val, del: op.Delete})
+	}
+}
+
+// unsynced writes included
+func (db *MemDb)All() map[string]string {
+	ret := make(map[string]string, len(db.mm))
+	for k, v := range db.mm {
+		ret[k] = v
+	}
+	for _, w := range db.unsynced {
+		if w.clean {
+			ret = make(map[string]string)
+		} else if w.del {
+			delete(ret, w.key)
+		} else {
+			ret[w.key] = w.val
+		}
+	}
+	return ret
+}
+
+func (db *MemDb)Iterate(start string, end string, fn func(key string, val string) bool) {
+	util.IterateMap(db.All(), start, end, fn)
+}
+
+func (db *MemDb)ApproximateSize(start string, end string) int64 {
+	return util.MapRangeSize(db.All(), start, end)
+}
+
+func (db *MemDb)CleanAll(){
+	db.unsynced = append(db.unsynced, memWrite{clean: true})
+}
+
+// Writes since last Fsync()
+func (db *MemDb)Unsynced() int {
+	return len(db.unsynced)
+}
+
+// Keep the first n unsynced writes and drop the rest, as if the machine
+// lost power in the middle of writing them out.
+func (db *MemDb)LoseUnsynced(n int) {
+	if n < len(db.unsynced) {
+		db.unsynced = db.unsynced[:n]
+	}
+	db.Fsync()
+}
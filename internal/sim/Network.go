@@ -0,0 +1,133 @@
+package sim
+
+import (
+	"sort"
+	"time"
+	"hash/fnv"
+	"encoding/binary"
+
+	"github.com/fallowu/big-ssdb/raft"
+)
+
+const(
+	DefaultMinLatency = 1 * time.Millisecond
+	DefaultMaxLatency = 10 * time.Millisecond
+)
+
+type packet struct{
+	at time.Time
+	seq int64 // per (src, dst) pair
+	msg *raft.Message
+}
+
+// In-memory network between simulated nodes. Delivery order depends only
+// on seed and on each node's own sending order, not on Go's map iteration
+// order or goroutine scheduling.
+type Network struct{
+	MinLatency time.Duration
+	MaxLatency time.Duration
+	// fraction of messages lost, 0 ~ 1
+	LossRate float64
+
+	seed int64
+	clock *raft.ManualClock
+	pending []*packet
+	seqs map[[2]string]int64
+	cut map[[2]string]bool
+	// messages sent, dropped
+	Sent int64
+	Dropped int64
+}
+
+func NewNetwork(seed int64, clock *raft.ManualClock) *Network {
+	n := new(Network)
+	n.MinLatency = DefaultMinLatency
+	n.MaxLatency = DefaultMaxLatency
+	n.seed = seed
+	n.clock = clock
+	n.seqs = make(map[[2]string]int64)
+	n.cut = make(map[[2]string]bool)
+	return n
+}
+
+func (n *Network)Send(msg *raft.Message) {
+	n.Sent ++
+	if n.cut[[2]string{msg.Src, msg.Dst}] {
+		n.Dropped ++
+		return
+	}
+	pair := [2]string{msg.Src, msg.Dst}
+	seq := n.seqs[pair]
+	n.seqs[pair] = seq + 1
+	if n.LossRate > 0 && float64(n.hash(msg.Dst, msg.Src, seq) % 10000) < n.LossRate * 10000 {
+		n.Dropped ++
+		return
+	}
+
+	latency := n.MinLatency
+	if n.MaxLatency > n.MinLatency {
+		latency += time.Duration(n.hash(msg.Src, msg.Dst, seq) % uint64(n.MaxLatency - n.MinLatency))
+	}
+	// copy, sender may reuse msg
+	cp := *msg
+	n.pending = append(n.pending, &packet{n.clock.Now().Add(latency), seq, &cp})
+}
+
+func (n *Network)hash(src string, dst string, seq int64) uint64 {
+	h := fnv.New64a()
+	var buf [16]byte
+	binary.BigEndian.PutUint64(buf[:8], uint64(n.seed))
+	binary.BigEndian.PutUint64(buf[8:], uint64(seq))
+	h.Write(buf[:])
+	h.Write([]byte(src + "\x00" + dst))
+	return h.Sum64()
+}
+
+// Remove and return messages due by now, in delivery order
+func (n *Network)Due() []*raft.Message {
+	now := n.clock.Now()
+	var due []*packet
+	rest := n.pending[:0]
+	for _, p := range n.pending {
+		if p.at.After(now) {
+			rest = append(rest, p)
+		} else {
+			due = append(due, p)
+		}
+	}
+	n.pending = rest
+
+	sort.Slice(due, func(i, j int) bool {
+		a, b := due[i], due[j]
+		if !a.at.Equal(b.at) {
+			return a.at.Before(b.at)
+		}
+		if a.msg.Dst != b.msg.Dst {
+			return a.msg.Dst < b.msg.Dst
+		}
+		if a.msg.Src != b.msg.Src {
+			return a.msg.Src < b.msg.Src
+		}
+		return a.seq < b.seq
+	})
+
+	ret := make([]*raft.Message, 0, len(due))
+	for _, p := range due {
+		// partitions also drop messages in flight
+		if n.cut[[2]string{p.msg.Src, p.msg.Dst}] {
+			n.Dropped ++
+			continue
+		}
+		ret = append(ret, p.msg)
+	}
+	return ret
+}
+
+// Drop messages from src to dst
+func (n *Network)Cut(src string, dst string) {
+	n.cut[[2]string{src, dst}] = true
+}
+
+func (n *Network)HealAll() {
+	n.cut = make(map[[2]string]bool)
+}
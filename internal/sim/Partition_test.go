@@ -0,0 +1,129 @@
+package sim
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fallowu/big-ssdb/raft"
+)
+
+func bootstrap5(t *testing.T, seed int64) *Sim {
+	s := New(seed)
+	if !s.Bootstrap("n1", "n2", "n3", "n4", "n5") {
+		t.Fatal("bootstrap failed\n" + s.Status())
+	}
+	return s
+}
+
+// no two nodes applied different entries at the same index
+func checkNoDoubleCommit(t *testing.T, w *Workload, s *Sim) {
+	seen := make(map[int64]string)
+	for _, id := range s.Ids() {
+		svc := w.Service(id)
+		for idx := int64(1); idx <= svc.LastApplied(); idx ++ {
+			data, ok := svc.Applied(idx)
+			if !ok {
+				continue
+			}
+			if prev, ok := seen[idx]; ok && prev != data {
+				t.Fatalf("entry#%d committed twice: %q and %q(%s)\n%s", idx, prev, data, id, s.Status())
+			}
+			seen[idx] = data
+		}
+	}
+}
+
+func TestPartitionMinorityLeaderStepsDown(t *testing.T){
+	s := bootstrap5(t, 1)
+	s.Partition([]string{"n1", "n2"}, []string{"n3", "n4", "n5"})
+	ok := s.RunUntil(func() bool {
+		l := s.Leader()
+		return l != nil && l.Id != "n1" && s.Node("n1").Metrics().Role != raft.RoleLeader
+	}, 60 * time.Second)
+	if !ok {
+		t.Fatal("minority leader not stepped down\n" + s.Status())
+	}
+	if id := s.Leader().Id; id == "n2" {
+		t.Fatal("leader elected in minority\n" + s.Status())
+	}
+}
+
+func TestPartitionSplitBrainNoDoubleCommit(t *testing.T){
+	for seed := int64(1); seed <= 3; seed ++ {
+		s := bootstrap5(t, seed)
+		w := NewWorkload(s, seed, 5, 3)
+		w.Run(time.Second)
+		// old leader n1 in minority, clients keep writing to both sides
+		s.Partition([]string{"n1", "n2"}, []string{"n3", "n4", "n5"})
+		w.Run(20 * time.Second)
+		s.Heal()
+		w.Run(10 * time.Second)
+		s.Partition([]string{"n1", "n3", "n5"}, []string{"n2", "n4"})
+		w.Run(20 * time.Second)
+		s.Heal()
+		w.Stop()
+		s.Run(10 * time.Second)
+
+		checkNoDoubleCommit(t, w, s)
+		if ok, key := CheckLinearizable(w.History()); !ok {
+			t.Fatal("history not linearizable, key", key)
+		}
+	}
+}
+
+func testPartitionReconcile(t *testing.T, writeMinority bool) {
+	s := bootstrap5(t, 1)
+	w := NewWorkload(s, 1, 0, 1)
+	s.Propose("before")
+	s.Run(time.Second)
+
+	old := s.Leader()
+	s.Partition([]string{old.Id, "n2"}, []string{"n3", "n4", "n5"})
+	if writeMinority {
+		// not committed, to be replaced by the majority's entries
+		old.Propose("minority")
+		old.StepTick(0)
+	}
+	ok := s.RunUntil(func() bool {
+		l := s.Leader()
+		return l != nil && l != old
+	}, 60 * time.Second)
+	if !ok {
+		t.Fatal("no leader in majority\n" + s.Status())
+	}
+	for i := 0; i < 3; i ++ {
+		s.Propose("majority")
+	}
+	s.Run(time.Second)
+
+	s.Heal()
+	ok = s.RunUntil(func() bool {
+		m := s.Leader().Metrics()
+		for _, id := range s.Ids() {
+			nm := s.Node(id).Metrics()
+			if nm.LastIndex != m.LastIndex || nm.CommitIndex != m.LastIndex {
+				return false
+			}
+		}
+		return true
+	}, 60 * time.Second)
+	if !ok {
+		t.Fatal("logs not reconciled\n" + s.Status())
+	}
+	checkNoDoubleCommit(t, w, s)
+	for _, id := range s.Ids() {
+		for idx := int64(1); idx <= w.Service(id).LastApplied(); idx ++ {
+			if data, _ := w.Service(id).Applied(idx); data == "minority" {
+				t.Fatalf("%s applied uncommitted entry#%d", id, idx)
+			}
+		}
+	}
+}
+
+func TestPartitionHealReconcile(t *testing.T){
+	testPartitionReconcile(t, false)
+}
+
+func TestPartitionHealReconcileDivergent(t *testing.T){
+	testPartitionReconcile(t, true)
+}
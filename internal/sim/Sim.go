@@ -0,0 +1,258 @@
+package sim
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/fallowu/big-ssdb/raft"
+)
+
+const(
+	// virtual time advanced by Step()
+	DefaultResolution = 1 * time.Millisecond
+	// interval of Node.Tick, same as Node.StartTicker
+	TickInterval = 100 * time.Millisecond
+)
+
+// Deterministic simulation of a raft cluster in a single goroutine: nodes
+// are driven by StepTick/StepMessage, time is virtual and messages travel
+// through an in-memory Network. Same seed, same scenario, same result.
+type Sim struct{
+	Clock *raft.ManualClock
+	Net *Network
+	Resolution time.Duration
+
+	seed int64
+	nodes map[string]*raft.Node
+	dbs map[string]*MemDb
+	services map[string]raft.Service
+	ids []string // sorted
+	restarts map[string]int64
+	nextTick time.Time
+}
+
+func New(seed int64) *Sim {
+	s := new(Sim)
+	s.seed = seed
+	s.Clock = raft.NewManualClock()
+	s.Net = NewNetwork(seed, s.Clock)
+	s.Resolution = DefaultResolution
+	s.nodes = make(map[string]*raft.Node)
+	s.dbs = make(map[string]*MemDb)
+	s.services = make(map[string]raft.Service)
+	s.restarts = make(map[string]int64)
+	s.nextTick = s.Clock.Now().Add(TickInterval)
+	return s
+}
+
+func Addr(id string) string {
+	return "sim://" + id
+}
+
+// Create a node with empty storage
+func (s *Sim)AddNode(id string) *raft.Node {
+	if s.dbs[id] != nil {
+		panic("duplicated node " + id)
+	}
+	s.dbs[id] = NewMemDb()
+	s.ids = append(s.ids, id)
+	sort.Strings(s.ids)
+	return s.startNode(id)
+}
+
+func (s *Sim)startNode(id string) *raft.Node {
+	node := raft.NewNode(id, Addr(id), s.dbs[id])
+	node.SetClock(s.Clock)
+	node.SetRandSeed(int64(s.Net.hash(id, "", s.restarts[id])))
+	node.SetOutbox(s.Net.Send)
+	node.SetInvariantMode(raft.InvariantPanic)
+	if svc := s.services[id]; svc != nil {
+		node.SetService(svc)
+	}
+	node.StepStart()
+	s.nodes[id] = node
+	return node
+}
+
+// Service of node id, kept across restarts like its storage
+func (s *Sim)SetService(id string, svc raft.Service) {
+	s.services[id] = svc
+	if node := s.nodes[id]; node != nil {
+		node.SetService(svc)
+		node.StepStart()
+	}
+}
+
+// nil if node is crashed or unknown
+func (s *Sim)Node(id string) *raft.Node {
+	return s.nodes[id]
+}
+
+// all nodes ever added, sorted
+func (s *Sim)Ids() []string {
+	return s.ids
+}
+
+// Create nodes and form a group of them, the first node becomes leader.
+// Returns false if the group is not formed in time.
+func (s *Sim)Bootstrap(ids ...string) bool {
+	for _, id := range ids {
+		s.AddNode(id)
+	}
+	leader := s.nodes[ids[0]]
+	leader.AddMember(ids[0], Addr(ids[0]))
+	leader.StepTick(0)
+	for _, id := range ids[1:] {
+		s.nodes[id].JoinGroup(ids[0], Addr(ids[0]))
+		idx, _ := leader.AddMember(id, Addr(id))
+		leader.StepTick(0)
+		// new node knows the group after installing leader's snapshot
+		node := s.nodes[id]
+		ok := s.RunUntil(func() bool {
+			return leader.Metrics().CommitIndex >= idx && node.Metrics().CommitIndex >= idx
+		}, 30 * time.Second)
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// Stop node, keeping its storage. Messages to it are dropped.
+func (s *Sim)Crash(id string) {
+	delete(s.nodes, id)
+}
+
+// Crash node and lose part of the writes its Db has not fsynced
+func (s *Sim)PowerOff(id string) {
+	s.Crash(id)
+	db := s.dbs[id]
+	if n := db.Unsynced(); n > 0 {
+		db.LoseUnsynced(int(s.Net.hash(id, "power", s.restarts[id]) % uint64(n + 1)))
+	}
+}
+
+// Start a crashed node from its storage
+func (s *Sim)Restart(id string) *raft.Node {
+	if s.nodes[id] != nil {
+		s.Crash(id)
+	}
+	s.restarts[id] ++
+	return s.startNode(id)
+}
+
+// Cut id off from every other node, in both directions
+func (s *Sim)Isolate(id string) {
+	for _, other := range s.ids {
+		if other != id {
+			s.Net.Cut(id, other)
+			s.Net.Cut(other, id)
+		}
+	}
+}
+
+// Split nodes into groups which can not talk to each other, nodes not
+// listed are cut off from all groups, e.g. Partition([]string{"n1", "n2"}, []string{"n3", "n4", "n5"})
+func (s *Sim)Partition(groups ...[]string) {
+	group := make(map[string]int)
+	for i, g := range groups {
+		for _, id := range g {
+			group[id] = i + 1
+		}
+	}
+	for _, a := range s.ids {
+		for _, b := range s.ids {
+			if a != b && (group[a] == 0 || group[a] != group[b]) {
+				s.Net.Cut(a, b)
+			}
+		}
+	}
+}
+
+func (s *Sim)Heal() {
+	s.Net.HealAll()
+}
+
+// Advance virtual time by Resolution, deliver due messages and tick nodes
+func (s *Sim)Step() {
+	s.Clock.Advance(s.Resolution)
+	now := s.Clock.Now()
+
+	for _, msg := range s.Net.Due() {
+		if node := s.nodes[msg.Dst]; node != nil {
+			node.StepMessage(msg)
+		}
+	}
+	for !s.nextTick.After(now) {
+		s.nextTick = s.nextTick.Add(TickInterval)
+		for _, id := range s.ids {
+			if node := s.nodes[id]; node != nil {
+				node.StepTick(int(TickInterval / time.Millisecond))
+			}
+		}
+	}
+}
+
+func (s *Sim)Run(d time.Duration) {
+	end := s.Clock.Now().Add(d)
+	for s.Clock.Now().Before(end) {
+		s.Step()
+	}
+}
+
+// Step until cond is true, returns false if max virtual time elapsed
+func (s *Sim)RunUntil(cond func() bool, max time.Duration) bool {
+	end := s.Clock.Now().Add(max)
+	for !cond() {
+		if !s.Clock.Now().Before(end) {
+			return false
+		}
+		s.Step()
+	}
+	return true
+}
+
+// The leader of the highest term among running nodes, nil if none
+func (s *Sim)Leader() *raft.Node {
+	var leader *raft.Node
+	var term int32 = -1
+	for _, id := range s.ids {
+		node := s.nodes[id]
+		if node == nil {
+			continue
+		}
+		m := node.Metrics()
+		if m.Role == raft.RoleLeader && m.Term > term {
+			leader = node
+			term = m.Term
+		}
+	}
+	return leader
+}
+
+// Propose data on the current leader, returns index, -1 if no leader
+func (s *Sim)Propose(data string) int64 {
+	leader := s.Leader()
+	if leader == nil {
+		return -1
+	}
+	_, idx, _ := leader.Propose(data)
+	leader.StepTick(0)
+	return idx
+}
+
+// One line per running node, for comparing runs
+func (s *Sim)Status() string {
+	var ret string
+	for _, id := range s.ids {
+		node := s.nodes[id]
+		if node == nil {
+			ret += fmt.Sprintf("%s: crashed\n", id)
+			continue
+		}
+		m := node.Metrics()
+		ret += fmt.Sprintf("%s: role=%s term=%d last=%d commit=%d\n", id, m.Role, m.Term, m.LastIndex, m.CommitIndex)
+	}
+	return ret
+}
@@ -0,0 +1,107 @@
+package sim
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/fallowu/big-ssdb/logger"
+)
+
+func init() {
+	logger.SetDefaultLevel(logger.LevelError)
+}
+
+func TestBootstrap(t *testing.T){
+	s := New(1)
+	if !s.Bootstrap("n1", "n2", "n3") {
+		t.Fatal("bootstrap failed\n" + s.Status())
+	}
+	if s.Leader() != s.Node("n1") {
+		t.Fatal("n1 is not leader\n" + s.Status())
+	}
+
+	idx := s.Propose("hello")
+	ok := s.RunUntil(func() bool {
+		for _, id := range s.Ids() {
+			if s.Node(id).Metrics().CommitIndex < idx {
+				return false
+			}
+		}
+		return true
+	}, 10 * time.Second)
+	if !ok {
+		t.Fatal("entry not committed on all nodes\n" + s.Status())
+	}
+}
+
+func TestLeaderIsolated(t *testing.T){
+	s := New(2)
+	if !s.Bootstrap("n1", "n2", "n3") {
+		t.Fatal("bootstrap failed\n" + s.Status())
+	}
+	s.Isolate("n1")
+	ok := s.RunUntil(func() bool {
+		l := s.Leader()
+		return l != nil && l.Id != "n1"
+	}, 60 * time.Second)
+	if !ok {
+		t.Fatal("no new leader\n" + s.Status())
+	}
+
+	s.Heal()
+	idx := s.Propose("after partition")
+	ok = s.RunUntil(func() bool {
+		return s.Node("n1").Metrics().CommitIndex >= idx && s.Node("n1").Metrics().Role != "leader"
+	}, 60 * time.Second)
+	if !ok {
+		t.Fatal("old leader not caught up\n" + s.Status())
+	}
+}
+
+func TestRestart(t *testing.T){
+	s := New(3)
+	if !s.Bootstrap("n1", "n2", "n3") {
+		t.Fatal("bootstrap failed\n" + s.Status())
+	}
+	s.Crash("n3")
+	idx := s.Propose("while n3 down")
+	s.Run(5 * time.Second)
+
+	s.Restart("n3")
+	ok := s.RunUntil(func() bool {
+		return s.Node("n3").Metrics().CommitIndex >= idx
+	}, 60 * time.Second)
+	if !ok {
+		t.Fatal("restarted node not caught up\n" + s.Status())
+	}
+}
+
+func scenario(seed int64) string {
+	s := New(seed)
+	s.Bootstrap("n1", "n2", "n3")
+	for i := 0; i < 5; i ++ {
+		s.Propose(fmt.Sprintf("v%d", i))
+		s.Run(200 * time.Millisecond)
+	}
+	s.Isolate("n1")
+	s.Run(20 * time.Second)
+	s.Heal()
+	s.Run(20 * time.Second)
+
+	ret := s.Status()
+	for _, id := range s.Ids() {
+		for _, ev := range s.Node(id).Events(0) {
+			ret += fmt.Sprintf("%s %v %s %d %s %d %s\n", id, ev.Time.UnixNano(), ev.Type, ev.Term, ev.Peer, ev.Index, ev.Detail)
+		}
+	}
+	return ret
+}
+
+func TestDeterministic(t *testing.T){
+	a := scenario(42)
+	b := scenario(42)
+	if a != b {
+		t.Fatal("same seed, different runs")
+	}
+}
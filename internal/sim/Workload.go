@@ -0,0 +1,226 @@
+package sim
+
+import (
+	"fmt"
+	"time"
+	"math/rand"
+
+	"github.com/fallowu/big-ssdb/raft"
+)
+
+const(
+	DefaultOpTimeout = 3 * time.Second
+	DefaultThinkTime = 50 * time.Millisecond
+)
+
+type client struct{
+	id int
+	op *Operation // in flight, nil if idle
+	node string
+	term int32
+	index int64
+	deadline time.Time
+	nextAt time.Time
+}
+
+// Concurrent clients doing gets and puts on a simulated cluster, through
+// whatever node believes it is leader, so stale leaders are exercised too.
+// Completed operations are recorded as a history for CheckLinearizable.
+type Workload struct{
+	OpTimeout time.Duration
+	// max virtual time between a client's operations
+	ThinkTime time.Duration
+	// fraction of gets, 0 ~ 1
+	ReadRatio float64
+
+	s *Sim
+	rand *rand.Rand
+	keys int
+	clients []*client
+	services map[string]*KVService
+	history []Operation
+	// index => data of completed puts
+	acked map[int64]string
+	seq int
+	stopped bool
+	// operations: completed, failed(definitely not applied), timed out
+	Completed int
+	Failed int
+	TimedOut int
+}
+
+// Attach a KVService to every node of s, call after Bootstrap()
+func NewWorkload(s *Sim, seed int64, clients int, keys int) *Workload {
+	w := new(Workload)
+	w.OpTimeout = DefaultOpTimeout
+	w.ThinkTime = DefaultThinkTime
+	w.ReadRatio = 0.5
+	w.s = s
+	w.rand = rand.New(rand.NewSource(seed))
+	w.keys = keys
+	w.services = make(map[string]*KVService)
+	w.acked = make(map[int64]string)
+	for i := 0; i < clients; i ++ {
+		w.clients = append(w.clients, &client{id: i})
+	}
+	for _, id := range s.Ids() {
+		var lastApplied int64
+		if node := s.Node(id); node != nil {
+			lastApplied = node.Metrics().LastApplied
+		}
+		svc := NewKVService(id, lastApplied)
+		svc.OnApply = w.onApply
+		w.services[id] = svc
+		s.SetService(id, svc)
+	}
+	return w
+}
+
+func (w *Workload)Service(id string) *KVService {
+	return w.services[id]
+}
+
+func (w *Workload)now() int64 {
+	return w.s.Clock.Now().UnixNano()
+}
+
+func (w *Workload)onApply(id string, ent *raft.Entry, output string) {
+	for _, c := range w.clients {
+		if c.op == nil || c.node != id || c.index != ent.Index {
+			continue
+		}
+		if ent.Term != c.term {
+			// overwritten by another leader, never committed
+			w.Failed ++
+		} else {
+			c.op.Return = w.now()
+			if c.op.Kind == OpGet {
+				c.op.Value = output
+			}
+			w.history = append(w.history, *c.op)
+			if c.op.Kind == OpPut {
+				w.acked[ent.Index] = ent.Data
+			}
+			w.Completed ++
+		}
+		w.finish(c)
+	}
+}
+
+func (w *Workload)finish(c *client) {
+	c.op = nil
+	c.nextAt = w.s.Clock.Now()
+	if w.ThinkTime > 0 {
+		c.nextAt = c.nextAt.Add(time.Duration(w.rand.Int63n(int64(w.ThinkTime))))
+	}
+}
+
+func (w *Workload)leaders() []*raft.Node {
+	var ret []*raft.Node
+	for _, id := range w.s.Ids() {
+		node := w.s.Node(id)
+		if node != nil && node.Metrics().Role == raft.RoleLeader {
+			ret = append(ret, node)
+		}
+	}
+	return ret
+}
+
+func (w *Workload)issue(c *client) {
+	leaders := w.leaders()
+	if len(leaders) == 0 {
+		c.nextAt = w.s.Clock.Now().Add(TickInterval)
+		return
+	}
+	node := leaders[w.rand.Intn(len(leaders))]
+
+	op := &Operation{Client: c.id, Kind: OpPut, Key: fmt.Sprintf("k%d", w.rand.Intn(w.keys))}
+	if w.rand.Float64() < w.ReadRatio {
+		op.Kind = OpGet
+	} else {
+		w.seq ++
+		op.Value = fmt.Sprintf("v%d", w.seq)
+	}
+	op.Call = w.now()
+	term, idx, _ := node.Propose(EncodeOp(op.Kind, op.Key, op.Value))
+	if idx == -1 {
+		c.nextAt = w.s.Clock.Now().Add(TickInterval)
+		return
+	}
+	c.op = op
+	c.node = node.Id
+	c.term = term
+	c.index = idx
+	c.deadline = w.s.Clock.Now().Add(w.OpTimeout)
+	node.StepTick(0)
+}
+
+// Issue and time out operations, then step the simulation
+func (w *Workload)Step() {
+	now := w.s.Clock.Now()
+	for _, c := range w.clients {
+		if c.op != nil {
+			if !now.Before(c.deadline) {
+				// indeterminate: a put may take effect any time later
+				if c.op.Kind == OpPut {
+					c.op.Return = ReturnNever
+					w.history = append(w.history, *c.op)
+				}
+				w.TimedOut ++
+				w.finish(c)
+			}
+		} else if !w.stopped && !now.Before(c.nextAt) {
+			w.issue(c)
+		}
+	}
+	w.s.Step()
+}
+
+func (w *Workload)Run(d time.Duration) {
+	end := w.s.Clock.Now().Add(d)
+	for w.s.Clock.Now().Before(end) {
+		w.Step()
+	}
+}
+
+// Stop issuing operations, wait for in-flight ones to finish or time out
+func (w *Workload)Stop() {
+	w.stopped = true
+	for {
+		busy := false
+		for _, c := range w.clients {
+			if c.op != nil {
+				busy = true
+			}
+		}
+		if !busy {
+			break
+		}
+		w.Step()
+	}
+}
+
+// Returns error if a completed put is not applied at its index on any running
+// node, call when the cluster has caught up.
+func (w *Workload)CheckAcked() error {
+	for _, id := range w.s.Ids() {
+		node := w.s.Node(id)
+		svc := w.services[id]
+		if node == nil || svc.Broken {
+			continue
+		}
+		for idx, data := range w.acked {
+			if idx > svc.LastApplied() {
+				return fmt.Errorf("%s: acked entry#%d not applied, lastApplied=%d", id, idx, svc.LastApplied())
+			}
+			if got, _ := svc.Applied(idx); got != data {
+				return fmt.Errorf("%s: acked entry#%d lost, want %q, applied %q", id, idx, data, got)
+			}
+		}
+	}
+	return nil
+}
+
+func (w *Workload)History() []Operation {
+	return w.history
+}
@@ -0,0 +1,197 @@
+package ssdb
+
+import (
+	"fmt"
+	"hash/fnv"
+	"path/filepath"
+	"github.com/fallowu/big-ssdb/logger"
+	"github.com/fallowu/big-ssdb/store"
+	"github.com/fallowu/big-ssdb/internal/util"
+)
+
+var defaultLog = logger.New("ssdb")
+
+// 非线程安全
+type Db struct {
+	dir string
+	kv *store.KVStore
+	redo *RedoManager
+	// order independent hash of all key-value pairs, XOR of pair hashes
+	hash uint64
+}
+
+func OpenDb(dir string) *Db {
+	dir, _ = filepath.Abs(dir)
+	
+	db := new(Db)
+	db.dir = dir
+	db.kv = store.OpenKVStore(dir)
+	db.redo = OpenRedoManager(dir + "/redo.log")
+	
+	if db.kv == nil || db.redo == nil {
+		return nil
+	}
+	if !db.recover() {
+		return nil
+	}
+	for k, v := range db.kv.All() {
+		db.hash ^= hashPair(k, v)
+	}
+	
+	defaultLog.Info("open Db", "dir", db.dir, "commitIndex", db.CommitIndex())
+	
+	return db
+}
+
+func (db *Db)Close() {
+	db.kv.Close()
+	db.redo.Close()
+}
+
+func (db *Db)recover() bool {
+	ents := db.redo.GetAllUncheckedEntries()
+
+	count := 0
+	for _, ent := range ents {
+		switch ent.Type {
+		case RedoTypeSet:
+			db.kv.Set(ent.Key, ent.Val)
+		case RedoTypeDel:
+			db.kv.Delete(ent.Key)
+		}
+	}
+	
+	if count > 0 {
+		db.redo.Check()
+	} else {
+		defaultLog.Info("nothing to redo")
+	}
+	return true
+}
+
+func (db *Db)CommitIndex() int64 {
+	return db.redo.CommitIndex()
+}
+
+/////////////////////////////////////////////////////////////////////
+
+func (db *Db)Get(key string) string {
+	return db.kv.Get(key)
+}
+
+func (db *Db)Set(idx int64, key string, val string) {
+	db.redo.Set(idx, key, val)
+	db.unhashKey(key)
+	db.kv.Set(key, val)
+	db.hash ^= hashPair(key, val)
+}
+
+func (db *Db)Del(idx int64, key string) {
+	db.redo.Del(idx, key)
+	db.unhashKey(key)
+	db.kv.Delete(key)
+}
+
+func (db *Db)Incr(idx int64, key string, delta string) string {
+	old := db.kv.Get(key)
+	num := util.Atoi64(old) + util.Atoi64(delta)
+	val := util.I64toa(num)
+	
+	db.redo.Set(idx, key, val)
+	db.unhashKey(key)
+	db.kv.Set(key, val)
+	db.hash ^= hashPair(key, val)
+
+	return val
+}
+
+// Hash of the whole data set, identical for identical data sets
+func (db *Db)StateHash() string {
+	return fmt.Sprintf("%016x", db.hash)
+}
+
+func (db *Db)unhashKey(key string) {
+	if old, ok := db.kv.All()[key]; ok {
+		db.hash ^= hashPair(key, old)
+	}
+}
+
+func hashPair(key string, val string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	h.Write([]byte{0})
+	h.Write([]byte(val))
+	return h.Sum64()
+}
+
+//////////////////////////////////////////////////////////////////////
+
+func (db *Db)CleanAll() {
+	defaultLog.Info("clean Db", "dir", db.dir)
+	db.redo.CleanAll()
+	db.kv.CleanAll()
+	db.hash = 0
+}
+
+func (db *Db)MakeFileSnapshot(path string) bool {
+	return db.View().MakeFileSnapshot(path)
+}
+
+// Replaces the data set with a file written by MakeFileSnapshot()
+func (db *Db)RestoreFileSnapshot(path string) bool {
+	sn := NewSnapshotReader(path)
+	if sn == nil {
+		return false
+	}
+	defer sn.Close()
+
+	db.CleanAll()
+	ent := new(store.KVEntry)
+	for sn.Next() {
+		if !ent.Decode(sn.Item()) {
+			defaultLog.Warn("bad snapshot record", "record", sn.Item())
+			return false
+		}
+		db.kv.Set(ent.Key, ent.Val)
+		db.hash ^= hashPair(ent.Key, ent.Val)
+	}
+	db.redo.Checkpoint(sn.CommitIndex())
+	defaultLog.Info("restore Db", "dir", db.dir, "commitIndex", db.CommitIndex())
+	return true
+}
+
+// Point-in-time copy of the data set, not affected by later writes. Taken
+// in memory with the caller's lock held, written to disk without it.
+type DbView struct {
+	commitIndex int64
+	kvs map[string]string
+}
+
+func (db *Db)View() *DbView {
+	all := db.kv.All()
+	v := &DbView{db.CommitIndex(), make(map[string]string, len(all))}
+	for k, val := range all {
+		v.kvs[k] = val
+	}
+	return v
+}
+
+func (v *DbView)CommitIndex() int64 {
+	return v.commitIndex
+}
+
+// Thread safe, but concurrent writers of the same path are not
+func (v *DbView)MakeFileSnapshot(path string) bool {
+	sn := NewSnapshotWriter(v.commitIndex, path)
+	if sn == nil {
+		return false
+	}
+	defer sn.Close()
+	
+	for k, val := range v.kvs {
+		ent := &store.KVEntry{Cmd: "set", Key: k, Val: val}
+		sn.Append(ent.Encode())
+	}
+
+	return true
+}
@@ -0,0 +1,94 @@
+package ssdb
+
+import (
+	"io/ioutil"
+	"log"
+	// "fmt"
+	"os"
+	"testing"
+)
+
+func TestDb(t *testing.T){
+	log.SetFlags(log.LstdFlags | log.Lshortfile | log.Lmicroseconds)
+
+	db := OpenDb("./tmp/db")
+	defer db.Close()
+	
+	idx := db.CommitIndex()
+	log.Println("commit index", idx)
+
+	// idx ++
+	// db.Set(idx, "a", "1")
+	// idx ++
+	// db.Set(idx, "b", "2")
+	// idx ++
+	// db.Set(idx, "c", "3")
+	// idx ++
+	// db.Set(idx, "d", "4")
+
+	// log.Println(db.Get("b"))
+	// idx ++
+	// db.Del(idx, "b")
+	// log.Println(db.Get("b"))
+	// idx ++
+	// db.Del(idx, "x")
+
+	
+	db.MakeFileSnapshot("./tmp/snapshot.db")
+}
+
+func TestDbView(t *testing.T){
+	dir, _ := ioutil.TempDir("", "dbview")
+	defer os.RemoveAll(dir)
+	db := OpenDb(dir + "/db")
+	defer db.Close()
+
+	db.Set(1, "a", "1")
+	view := db.View()
+	// writes after the view is taken are not in the snapshot
+	db.Set(2, "a", "2")
+	db.Set(3, "b", "3")
+	if !view.MakeFileSnapshot(dir + "/snapshot.db") {
+		t.Fatal("make snapshot failed")
+	}
+	sn := NewSnapshotReader(dir + "/snapshot.db")
+	defer sn.Close()
+	if sn.CommitIndex() != 1 {
+		t.Fatal("commit index", sn.CommitIndex())
+	}
+	n := 0
+	for sn.Next() {
+		n ++
+	}
+	if n != 1 || view.CommitIndex() != 1 {
+		t.Fatal("entries", n)
+	}
+}
+
+func TestRestoreFileSnapshot(t *testing.T){
+	dir, _ := ioutil.TempDir("", "dbrestore")
+	defer os.RemoveAll(dir)
+	src := OpenDb(dir + "/src")
+	src.Set(1, "a", "1")
+	src.Set(2, "b", "2")
+	src.MakeFileSnapshot(dir + "/snapshot.db")
+	hash := src.StateHash()
+	src.Close()
+
+	db := OpenDb(dir + "/db")
+	db.Set(5, "x", "old")
+	if !db.RestoreFileSnapshot(dir + "/snapshot.db") {
+		t.Fatal("restore failed")
+	}
+	if db.CommitIndex() != 2 || db.Get("x") != "" || db.StateHash() != hash {
+		t.Fatal("restored", db.CommitIndex(), db.Get("x"), db.StateHash())
+	}
+	db.Close()
+
+	// and survives reopen
+	db = OpenDb(dir + "/db")
+	defer db.Close()
+	if db.CommitIndex() != 2 || db.Get("b") != "2" || db.StateHash() != hash {
+		t.Fatal("reopened", db.CommitIndex(), db.Get("b"))
+	}
+}
@@ -3,7 +3,7 @@ package ssdb
 import (
 	"fmt"
 	"strings"
-	"util"
+	"github.com/fallowu/big-ssdb/internal/util"
 )
 
 type RedoType string
@@ -1,10 +1,9 @@
 package ssdb
 
 import (
-	"log"
 	"os"
-	"store"
-	"util"
+	"github.com/fallowu/big-ssdb/store"
+	"github.com/fallowu/big-ssdb/internal/util"
 )
 
 type RedoManager struct{
@@ -35,7 +34,7 @@ func (rd *RedoManager)Close() {
 func (rd *RedoManager)recover() bool {
 	rd.wal = store.OpenWalFile(rd.path)
 	if rd.wal == nil {
-		log.Println("could not open", rd.path)
+		defaultLog.Warn("could not open", "path", rd.path)
 		return false
 	}
 	
@@ -47,20 +46,20 @@ func (rd *RedoManager)recover() bool {
 		r := rd.wal.Item()
 		var ent RedoEntry
 		if ent.Decode(r) == false {
-			log.Fatalf("invalid entry: %s", r)
+			defaultLog.Fatalf("invalid entry: %s", r)
 			return false
 		}
 
 		switch ent.Type {
 		case RedoTypeCheck:
 			if begin > 0 {
-				log.Fatalf("invalid '%s' after 'begin': %s", ent.Type, r)
+				defaultLog.Fatalf("invalid '%s' after 'begin': %s", ent.Type, r)
 				return false
 			}
 			rd.checkIndex = ent.Index
 		case RedoTypeBegin:
 			if begin > 0 {
-				log.Fatalf("invalid '%s' after 'begin': %s", ent.Type, r)
+				defaultLog.Fatalf("invalid '%s' after 'begin': %s", ent.Type, r)
 				return false
 			}
 			begin = ent.Index
@@ -71,7 +70,7 @@ func (rd *RedoManager)recover() bool {
 			begin = 0
 		default:
 			if begin == 0 {
-				log.Fatalf("invalid '%s' before 'begin': %s", ent.Type, r)
+				defaultLog.Fatalf("invalid '%s' before 'begin': %s", ent.Type, r)
 				return false
 			}
 		}
@@ -90,7 +89,7 @@ func (rd *RedoManager)SeekAfterLastCheckpoint() {
 		r := rd.wal.Item()
 		var ent RedoEntry
 		if ent.Decode(r) == false {
-			log.Fatalf("error")
+			defaultLog.Fatalf("error")
 		}
 		if ent.Type == RedoTypeCheck {
 			lineno = num + 1
@@ -107,7 +106,7 @@ func (rd *RedoManager)GetAllUncheckedEntries() []*RedoEntry {
 		r := rd.wal.Item()
 		var ent RedoEntry
 		if ent.Decode(r) == false {
-			log.Fatalf("bad entry: %s", r)
+			defaultLog.Fatalf("bad entry: %s", r)
 			break
 		}
 		switch ent.Type {
@@ -135,6 +134,14 @@ func (rd *RedoManager)Check() {
 	rd.checkIndex = rd.commitIndex
 }
 
+// the data set is replaced as of idx, e.g. by a snapshot, after CleanAll()
+func (rd *RedoManager)Checkpoint(idx int64) {
+	rd.wal.Append(NewRedoBeginEntry(idx).Encode())
+	rd.wal.Append(NewRedoCommitEntry(idx).Encode())
+	rd.commitIndex = idx
+	rd.Check()
+}
+
 func (rd *RedoManager)WriteBatch(ents []*RedoEntry) {
 	var min int64 = 0
 	var max int64 = 0
@@ -146,7 +153,7 @@ func (rd *RedoManager)WriteBatch(ents []*RedoEntry) {
 		max = util.MaxInt64(max, ent.Index)
 	}
 	if min == 0 || max == 0 {
-		log.Fatal("error")
+		defaultLog.Fatalf("error")
 	}
 	
 	rd.wal.Append(NewRedoBeginEntry(min).Encode())
@@ -177,11 +184,11 @@ func (rd *RedoManager)CleanAll() {
 	rd.wal.Close()
 	err := os.Remove(rd.path)
 	if err != nil {
-		log.Fatalf("Remove %s error: %s", rd.path, err.Error())
+		defaultLog.Fatalf("Remove %s error: %s", rd.path, err.Error())
 	}
 	
 	rd.wal = store.OpenWalFile(rd.path)
 	if rd.wal == nil {
-		log.Fatalf("Open %s error: %s", rd.path, err.Error())
+		defaultLog.Fatalf("Open %s error: %s", rd.path, err.Error())
 	}
 }
@@ -4,7 +4,7 @@ import (
 	"log"
 	"testing"
 	"fmt"
-	"util"
+	"github.com/fallowu/big-ssdb/internal/util"
 	"os"
 )
 
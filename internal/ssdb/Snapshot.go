@@ -3,9 +3,8 @@ package ssdb
 import (
 	"fmt"
 	"os"
-	"log"
-	"store"
-	"util"
+	"github.com/fallowu/big-ssdb/store"
+	"github.com/fallowu/big-ssdb/internal/util"
 )
 
 type Snapshot struct {
@@ -17,7 +16,7 @@ func NewSnapshotWriter(commitIndex int64, path string) *Snapshot {
 	if util.FileExists(path) {
 		err := os.Remove(path)
 		if err != nil {
-			log.Fatal(err)
+			defaultLog.Fatalf("%s", err)
 		}
 	}
 
@@ -32,12 +31,14 @@ func NewSnapshotWriter(commitIndex int64, path string) *Snapshot {
 
 func NewSnapshotReader(path string) *Snapshot {
 	if !util.FileExists(path) {
-		log.Println("snapshot file not found:", path)
+		defaultLog.Warn("snapshot file not found", "path", path)
 		return nil
 	}
 	
 	sn := new(Snapshot)
 	sn.wal = store.OpenWalFile(path)
+	// opened at the end
+	sn.wal.SeekTo(0)
 	if sn.Next() {
 		sn.commitIndex = util.Atoi64(sn.wal.Item())
 	} else {
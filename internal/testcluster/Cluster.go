@@ -0,0 +1,332 @@
+package testcluster
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+	"testing"
+
+	"github.com/fallowu/big-ssdb/raft"
+	"github.com/fallowu/big-ssdb/internal/sim"
+)
+
+const(
+	// real time between ticks, each tick is raft's 100ms, so the cluster
+	// runs 10x faster than a deployed one
+	DefaultTickInterval = 10 * time.Millisecond
+	// messages queued for a node, more are dropped as a full UDP buffer would
+	InboxSize = 1024
+)
+
+// In-process cluster for integration tests. Unlike sim.Sim every node runs
+// in its own goroutines and time is real, so it exercises raft's locking
+// the way a deployment does. Messages travel through in-memory channels,
+// storage is sim.MemDb, and survives Stop()/Restart(). Thread safe.
+type Cluster struct{
+	t testing.TB
+	TickInterval time.Duration
+
+	ids []string // sorted
+	dbs map[string]*sim.MemDb
+	nodes map[string]*member // running
+	services map[string]raft.Service
+	cut map[[2]string]bool
+	mux sync.Mutex
+}
+
+type member struct{
+	node *raft.Node
+	inbox chan *raft.Message
+	stop chan struct{}
+	done chan struct{}
+}
+
+func Id(i int) string {
+	return fmt.Sprintf("n%d", i)
+}
+
+func Addr(id string) string {
+	return "mem://" + id
+}
+
+// Start n nodes n1...nN and form a group of them, n1 being the first
+// leader. The cluster is closed when the test finishes.
+func New(t testing.TB, n int) *Cluster {
+	c := new(Cluster)
+	c.t = t
+	c.TickInterval = DefaultTickInterval
+	c.dbs = make(map[string]*sim.MemDb)
+	c.nodes = make(map[string]*member)
+	c.services = make(map[string]raft.Service)
+	c.cut = make(map[[2]string]bool)
+	t.Cleanup(c.Close)
+
+	for i := 1; i <= n; i ++ {
+		id := Id(i)
+		c.ids = append(c.ids, id)
+		c.dbs[id] = sim.NewMemDb()
+	}
+	sort.Strings(c.ids)
+	for _, id := range c.ids {
+		c.start(id)
+	}
+
+	leader := c.Node(Id(1))
+	leader.AddMember(Id(1), Addr(Id(1)))
+	leader.StepTick(0)
+	for _, id := range c.ids[1:] {
+		c.Node(id).JoinGroup(Id(1), Addr(Id(1)))
+		idx, _ := leader.AddMember(id, Addr(id))
+		leader.StepTick(0)
+		// new node knows the group after installing leader's snapshot
+		c.WaitFor(func() bool {
+			return leader.Metrics().CommitIndex >= idx && c.Node(id).Metrics().CommitIndex >= idx
+		}, 10 * time.Second, "add member " + id)
+	}
+	return c
+}
+
+func (c *Cluster)start(id string) *raft.Node {
+	c.mux.Lock()
+	db := c.dbs[id]
+	svc := c.services[id]
+	c.mux.Unlock()
+
+	node := raft.NewNode(id, Addr(id), db)
+	node.SetInvariantMode(raft.InvariantPanic)
+	node.SetOutbox(func(msg *raft.Message) {
+		c.route(msg)
+	})
+	if svc != nil {
+		node.SetService(svc)
+	}
+	// not under c.mux, node may send messages
+	node.StepStart()
+
+	m := &member{node, make(chan *raft.Message, InboxSize), make(chan struct{}), make(chan struct{})}
+	c.mux.Lock()
+	c.nodes[id] = m
+	c.mux.Unlock()
+	go c.run(m)
+	return node
+}
+
+// as Node.Start() would, but stoppable
+func (c *Cluster)run(m *member) {
+	defer close(m.done)
+	ticker := time.NewTicker(c.TickInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			m.node.StepTick(100)
+		case msg := <-m.inbox:
+			m.node.StepMessage(msg)
+		case <-m.stop:
+			return
+		}
+	}
+}
+
+// called with sender's lock held, must not block
+func (c *Cluster)route(msg *raft.Message) {
+	cp := *msg
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	dst := c.nodes[cp.Dst]
+	if dst == nil || c.cut[[2]string{cp.Src, cp.Dst}] {
+		return
+	}
+	select {
+	case dst.inbox <- &cp:
+	default:
+	}
+}
+
+func (c *Cluster)Ids() []string {
+	return append([]string{}, c.ids...)
+}
+
+// nil if the node is stopped
+func (c *Cluster)Node(id string) *raft.Node {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if m := c.nodes[id]; m != nil {
+		return m.node
+	}
+	return nil
+}
+
+// Used by the node from now on, and after restarts
+func (c *Cluster)SetService(id string, svc raft.Service) {
+	c.mux.Lock()
+	c.services[id] = svc
+	m := c.nodes[id]
+	c.mux.Unlock()
+	if m != nil {
+		m.node.SetService(svc)
+	}
+}
+
+// Stop the node, keeping its storage. Messages to it are dropped.
+func (c *Cluster)Stop(id string) {
+	c.mux.Lock()
+	m := c.nodes[id]
+	delete(c.nodes, id)
+	c.mux.Unlock()
+	if m != nil {
+		close(m.stop)
+		<-m.done
+	}
+}
+
+// Start a stopped node from its storage
+func (c *Cluster)Restart(id string) *raft.Node {
+	c.Stop(id)
+	return c.start(id)
+}
+
+func (c *Cluster)Close() {
+	for _, id := range c.ids {
+		c.Stop(id)
+	}
+}
+
+// Cut all links between groups, nodes not listed are cut from all
+func (c *Cluster)Partition(groups ...[]string) {
+	group := make(map[string]int)
+	for i, g := range groups {
+		for _, id := range g {
+			group[id] = i + 1
+		}
+	}
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	for _, a := range c.ids {
+		for _, b := range c.ids {
+			if a != b && (group[a] == 0 || group[a] != group[b]) {
+				c.cut[[2]string{a, b}] = true
+			}
+		}
+	}
+}
+
+// Cut node id from all others
+func (c *Cluster)Isolate(id string) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	for _, other := range c.ids {
+		if other != id {
+			c.cut[[2]string{id, other}] = true
+			c.cut[[2]string{other, id}] = true
+		}
+	}
+}
+
+// Restore links of node id, cut by Isolate() or Partition()
+func (c *Cluster)Reconnect(id string) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	for link := range c.cut {
+		if link[0] == id || link[1] == id {
+			delete(c.cut, link)
+		}
+	}
+}
+
+func (c *Cluster)Heal() {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.cut = make(map[[2]string]bool)
+}
+
+// Poll cond until true, fails the test after timeout
+func (c *Cluster)WaitFor(cond func() bool, timeout time.Duration, what string) {
+	c.t.Helper()
+	end := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(end) {
+			c.t.Fatalf("timeout waiting for %s\n%s", what, c.Status())
+		}
+		time.Sleep(c.TickInterval)
+	}
+}
+
+// The leader of the highest term among running nodes, nil if none
+func (c *Cluster)Leader() *raft.Node {
+	var leader *raft.Node
+	var term int32 = -1
+	for _, id := range c.ids {
+		node := c.Node(id)
+		if node == nil {
+			continue
+		}
+		m := node.Metrics()
+		if m.Role == raft.RoleLeader && m.Term > term {
+			leader = node
+			term = m.Term
+		}
+	}
+	return leader
+}
+
+// A running node other than except may be the leader, e.g. the old
+// leader partitioned away
+func (c *Cluster)WaitForLeader(timeout time.Duration, except ...string) *raft.Node {
+	c.t.Helper()
+	var leader *raft.Node
+	c.WaitFor(func() bool {
+		leader = c.Leader()
+		if leader == nil {
+			return false
+		}
+		for _, id := range except {
+			if leader.Id == id {
+				return false
+			}
+		}
+		return true
+	}, timeout, "leader")
+	return leader
+}
+
+// Propose data on the current leader, returns index, -1 if no leader
+func (c *Cluster)Propose(data string) int64 {
+	leader := c.Leader()
+	if leader == nil {
+		return -1
+	}
+	_, idx, _ := leader.Propose(data)
+	// replicate now, not on next tick
+	leader.StepTick(0)
+	return idx
+}
+
+// All running nodes have committed index
+func (c *Cluster)WaitForCommit(index int64, timeout time.Duration) {
+	c.t.Helper()
+	c.WaitFor(func() bool {
+		for _, id := range c.ids {
+			if node := c.Node(id); node != nil && node.Metrics().CommitIndex < index {
+				return false
+			}
+		}
+		return true
+	}, timeout, fmt.Sprintf("commit %d", index))
+}
+
+// One line per node
+func (c *Cluster)Status() string {
+	var ret string
+	for _, id := range c.ids {
+		node := c.Node(id)
+		if node == nil {
+			ret += fmt.Sprintf("%s: stopped\n", id)
+			continue
+		}
+		m := node.Metrics()
+		ret += fmt.Sprintf("%s: %s term=%d commit=%d last=%d\n", id, m.Role, m.Term, m.CommitIndex, m.LastIndex)
+	}
+	return ret
+}
@@ -0,0 +1,64 @@
+package testcluster
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fallowu/big-ssdb/logger"
+)
+
+func TestReplication(t *testing.T){
+	logger.SetDefaultLevel(logger.LevelError)
+	c := New(t, 3)
+	leader := c.WaitForLeader(5 * time.Second)
+	if leader.Id != "n1" {
+		t.Fatal("bad leader", leader.Id)
+	}
+	var idx int64
+	for i := 0; i < 10; i ++ {
+		idx = c.Propose("data")
+	}
+	c.WaitForCommit(idx, 5 * time.Second)
+}
+
+// a stopped follower catches up after restart
+func TestRestartFollower(t *testing.T){
+	logger.SetDefaultLevel(logger.LevelError)
+	c := New(t, 3)
+	c.Stop("n3")
+	var idx int64
+	for i := 0; i < 5; i ++ {
+		idx = c.Propose("data")
+	}
+	c.WaitForCommit(idx, 5 * time.Second)
+	c.Restart("n3")
+	c.WaitForCommit(idx, 10 * time.Second)
+}
+
+func TestLeaderFailover(t *testing.T){
+	logger.SetDefaultLevel(logger.LevelError)
+	c := New(t, 3)
+	idx := c.Propose("a")
+	c.WaitForCommit(idx, 5 * time.Second)
+
+	c.Stop("n1")
+	c.WaitForLeader(20 * time.Second)
+	idx = c.Propose("b")
+	c.WaitForCommit(idx, 5 * time.Second)
+
+	c.Restart("n1")
+	c.WaitForCommit(idx, 10 * time.Second)
+}
+
+func TestPartitionMinorityLeader(t *testing.T){
+	logger.SetDefaultLevel(logger.LevelError)
+	c := New(t, 5)
+	c.Partition([]string{"n1", "n2"}, []string{"n3", "n4", "n5"})
+	leader := c.WaitForLeader(30 * time.Second, "n1", "n2")
+	idx := c.Propose("a")
+	c.Heal()
+	c.WaitForCommit(idx, 10 * time.Second)
+	if c.Leader().Id != leader.Id {
+		t.Fatal("leader changed after heal\n" + c.Status())
+	}
+}
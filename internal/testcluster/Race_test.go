@@ -0,0 +1,62 @@
+package testcluster
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fallowu/big-ssdb/logger"
+	"github.com/fallowu/big-ssdb/internal/sim"
+)
+
+// go test -race testcluster
+//
+// readers call every read-only API of the nodes while proposals, restarts
+// and snapshot installs mutate them
+func TestConcurrentLoad(t *testing.T){
+	logger.SetDefaultLevel(logger.LevelError)
+	c := New(t, 3)
+	for _, id := range c.Ids() {
+		c.SetService(id, sim.NewKVService(id, 0))
+	}
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for _, id := range c.Ids() {
+		id := id
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				if node := c.Node(id); node != nil {
+					node.InfoMap()
+					node.Info()
+					node.Metrics()
+					node.LastApplied()
+					node.Events(10)
+					node.CreateSnapshot()
+				}
+				time.Sleep(time.Millisecond)
+			}
+		}()
+	}
+
+	var idx int64
+	for i := 0; i < 200; i ++ {
+		if n := c.Propose(sim.EncodeOp(sim.OpPut, "k", "v")); n > 0 {
+			idx = n
+		}
+		if i == 100 {
+			c.Restart("n3")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	c.WaitForCommit(idx, 10 * time.Second)
+	close(stop)
+	wg.Wait()
+
+}
@@ -0,0 +1,356 @@
+package testcluster
+
+import (
+	"io"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+	"testing"
+
+	"github.com/fallowu/big-ssdb/raft"
+	"github.com/fallowu/big-ssdb/internal/sim"
+)
+
+const DefaultWriteTimeout = 5 * time.Second
+
+// A repeatable failure drill, actions at points of time since start, built
+// by chaining:
+//
+//	NewScenario("leader crash").
+//		Write(0, 1000).
+//		KillLeader(2 * time.Second).
+//		Isolate(3 * time.Second, "n3", 5 * time.Second).
+//		Run(t, c)
+//
+// Times are raft's time, the cluster ticks 100ms of it every TickInterval,
+// so they compare to raft's timeouts but not to the wall clock. After the
+// last action, the network is healed, stopped nodes are restarted, and every
+// acknowledged write must be applied on every node.
+type Scenario struct{
+	Name string
+	// raft time to wait for a write to be applied, before it's retried
+	WriteTimeout time.Duration
+	steps []scenarioStep
+}
+
+type scenarioStep struct{
+	at time.Duration
+	what string
+	do func(r *scenarioRun)
+}
+
+type ScenarioResult struct{
+	// writes applied at their index by the proposing leader
+	Acked int
+	// timed out or overwritten by another leader, retried
+	Retried int
+}
+
+type scenarioRun struct{
+	sc *Scenario
+	t testing.TB
+	c *Cluster
+	services map[string]*scenarioService
+	writers sync.WaitGroup
+	mux sync.Mutex
+	// index => data
+	acked map[int64]string
+	retried int
+}
+
+func NewScenario(name string) *Scenario {
+	sc := new(Scenario)
+	sc.Name = name
+	sc.WriteTimeout = DefaultWriteTimeout
+	return sc
+}
+
+// Run f at at
+func (sc *Scenario)Do(at time.Duration, what string, f func(c *Cluster)) *Scenario {
+	sc.steps = append(sc.steps, scenarioStep{at, what, func(r *scenarioRun) {
+		f(r.c)
+	}})
+	return sc
+}
+
+func (sc *Scenario)Kill(at time.Duration, id string) *Scenario {
+	return sc.Do(at, "kill " + id, func(c *Cluster) {
+		c.Stop(id)
+	})
+}
+
+// Kill whichever node is leader at at, nothing if there is none
+func (sc *Scenario)KillLeader(at time.Duration) *Scenario {
+	sc.steps = append(sc.steps, scenarioStep{at, "kill leader", func(r *scenarioRun) {
+		if leader := r.c.Leader(); leader != nil {
+			r.t.Logf("%s: kill leader %s", sc.Name, leader.Id)
+			r.c.Stop(leader.Id)
+		}
+	}})
+	return sc
+}
+
+func (sc *Scenario)Restart(at time.Duration, id string) *Scenario {
+	return sc.Do(at, "restart " + id, func(c *Cluster) {
+		c.Restart(id)
+	})
+}
+
+// Restart all stopped nodes
+func (sc *Scenario)RestartAll(at time.Duration) *Scenario {
+	return sc.Do(at, "restart all", func(c *Cluster) {
+		c.restartStopped()
+	})
+}
+
+// Cut node id from the others for d
+func (sc *Scenario)Isolate(at time.Duration, id string, d time.Duration) *Scenario {
+	sc.Do(at, "isolate " + id, func(c *Cluster) {
+		c.Isolate(id)
+	})
+	return sc.Do(at + d, "reconnect " + id, func(c *Cluster) {
+		c.Reconnect(id)
+	})
+}
+
+// Cut whichever node is leader at at from the others for d
+func (sc *Scenario)IsolateLeader(at time.Duration, d time.Duration) *Scenario {
+	var id string
+	sc.steps = append(sc.steps, scenarioStep{at, "isolate leader", func(r *scenarioRun) {
+		id = ""
+		if leader := r.c.Leader(); leader != nil {
+			id = leader.Id
+			r.t.Logf("%s: isolate leader %s", sc.Name, id)
+			r.c.Isolate(id)
+		}
+	}})
+	return sc.Do(at + d, "reconnect old leader", func(c *Cluster) {
+		if id != "" {
+			c.Reconnect(id)
+		}
+	})
+}
+
+// See Cluster.Partition(), healed after d
+func (sc *Scenario)Partition(at time.Duration, d time.Duration, groups ...[]string) *Scenario {
+	sc.Do(at, fmt.Sprintf("partition %v", groups), func(c *Cluster) {
+		c.Partition(groups...)
+	})
+	return sc.Heal(at + d)
+}
+
+func (sc *Scenario)Heal(at time.Duration) *Scenario {
+	return sc.Do(at, "heal", func(c *Cluster) {
+		c.Heal()
+	})
+}
+
+// Write n keys one after another through the leader, in background. Keys
+// are unique to the step, a write is retried until acknowledged.
+func (sc *Scenario)Write(at time.Duration, n int) *Scenario {
+	step := len(sc.steps)
+	sc.steps = append(sc.steps, scenarioStep{at, fmt.Sprintf("write %d keys", n), func(r *scenarioRun) {
+		r.writers.Add(1)
+		go func() {
+			defer r.writers.Done()
+			for i := 0; i < n; i ++ {
+				r.write(fmt.Sprintf("s%d-k%d", step, i), fmt.Sprintf("v%d", i))
+			}
+		}()
+	}})
+	return sc
+}
+
+// Execute on c, fails t if an acknowledged write is lost, or nodes applied
+// different entries at the same index.
+func (sc *Scenario)Run(t testing.TB, c *Cluster) *ScenarioResult {
+	t.Helper()
+	r := &scenarioRun{sc: sc, t: t, c: c}
+	r.services = make(map[string]*scenarioService)
+	r.acked = make(map[int64]string)
+	for _, id := range c.Ids() {
+		var lastApplied int64
+		if node := c.Node(id); node != nil {
+			lastApplied = node.LastApplied()
+		}
+		svc := &scenarioService{lastApplied: lastApplied, applied: make(map[int64]raft.Entry)}
+		r.services[id] = svc
+		c.SetService(id, svc)
+	}
+
+	steps := append([]scenarioStep{}, sc.steps...)
+	sort.SliceStable(steps, func(i, j int) bool {
+		return steps[i].at < steps[j].at
+	})
+	start := time.Now()
+	for _, s := range steps {
+		time.Sleep(time.Until(start.Add(c.realTime(s.at))))
+		t.Logf("%s: t=%s %s", sc.Name, s.at, s.what)
+		s.do(r)
+	}
+
+	// let writers finish on a healthy cluster
+	c.Heal()
+	c.restartStopped()
+	done := make(chan struct{})
+	go func() {
+		r.writers.Wait()
+		close(done)
+	}()
+	// fails when no write is acked for a while
+	for acked, waiting := -1, true; waiting; {
+		select {
+		case <-done:
+			waiting = false
+		case <-time.After(c.realTime(3 * sc.WriteTimeout)):
+			if r.ackedCount() == acked {
+				t.Fatalf("%s: writers stuck\n%s", sc.Name, c.Status())
+			}
+			acked = r.ackedCount()
+		}
+	}
+	leader := c.WaitForLeader(c.realTime(time.Minute))
+	c.WaitForCommit(leader.Metrics().LastIndex, c.realTime(time.Minute))
+
+	if err := r.check(); err != nil {
+		t.Fatalf("%s: %s\n%s", sc.Name, err, c.Status())
+	}
+	return &ScenarioResult{len(r.acked), r.retried}
+}
+
+func (c *Cluster)realTime(d time.Duration) time.Duration {
+	return d * c.TickInterval / (100 * time.Millisecond)
+}
+
+func (c *Cluster)restartStopped() {
+	for _, id := range c.ids {
+		if c.Node(id) == nil {
+			c.Restart(id)
+		}
+	}
+}
+
+// Propose on the leader until the entry is applied there with the term it
+// was proposed in
+func (r *scenarioRun)write(key string, value string) {
+	data := sim.EncodeOp(sim.OpPut, key, value)
+	for {
+		leader := r.c.Leader()
+		if leader == nil {
+			time.Sleep(r.c.TickInterval)
+			continue
+		}
+		term, idx, _ := leader.Propose(data)
+		if idx == -1 {
+			time.Sleep(r.c.TickInterval)
+			continue
+		}
+		leader.StepTick(0)
+
+		svc := r.services[leader.Id]
+		deadline := time.Now().Add(r.c.realTime(r.sc.WriteTimeout))
+		for time.Now().Before(deadline) {
+			if ent, ok := svc.entry(idx); ok {
+				if ent.Term == term {
+					r.mux.Lock()
+					r.acked[idx] = data
+					r.mux.Unlock()
+					return
+				}
+				break
+			}
+			time.Sleep(r.c.TickInterval)
+		}
+		r.mux.Lock()
+		r.retried ++
+		r.mux.Unlock()
+	}
+}
+
+func (r *scenarioRun)ackedCount() int {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	return len(r.acked)
+}
+
+// Commit of an entry is not checked, it differs between nodes
+func (r *scenarioRun)check() error {
+	// index => entry applied by the first node
+	first := make(map[int64]raft.Entry)
+	for _, id := range r.c.Ids() {
+		svc := r.services[id]
+		svc.mux.Lock()
+		defer svc.mux.Unlock()
+		if svc.broken {
+			r.t.Logf("%s: %s installed snapshot, not checked", r.sc.Name, id)
+			continue
+		}
+		for idx, data := range r.acked {
+			if ent, ok := svc.applied[idx]; !ok || ent.Data != data {
+				return fmt.Errorf("%s: acked entry#%d lost, want %q, applied %q", id, idx, data, ent.Data)
+			}
+		}
+		for idx, ent := range svc.applied {
+			if f, ok := first[idx]; !ok {
+				first[idx] = ent
+			} else if f.Term != ent.Term || f.Type != ent.Type || f.Data != ent.Data {
+				return fmt.Errorf("%s: entry#%d applied %q, another node applied %q", id, idx, ent.Encode(), f.Encode())
+			}
+		}
+	}
+	return nil
+}
+
+// raft.StateMachine recording applied entries, thread safe
+type scenarioService struct{
+	lastApplied int64
+	applied map[int64]raft.Entry
+	broken bool
+	mux sync.Mutex
+}
+
+func (svc *scenarioService)LastApplied() int64 {
+	svc.mux.Lock()
+	defer svc.mux.Unlock()
+	return svc.lastApplied
+}
+
+func (svc *scenarioService)Apply(ent *raft.Entry) raft.Result {
+	svc.mux.Lock()
+	defer svc.mux.Unlock()
+	svc.lastApplied = ent.Index
+	svc.applied[ent.Index] = *ent
+	return nil
+}
+
+// entries applied so far are kept, the snapshot carries lastApplied only
+func (svc *scenarioService)SaveSnapshot(w io.Writer) error {
+	_, err := fmt.Fprintf(w, "%d", svc.LastApplied())
+	return err
+}
+
+func (svc *scenarioService)RestoreSnapshot(r io.Reader) error {
+	var idx int64
+	if _, err := fmt.Fscanf(r, "%d", &idx); err != nil {
+		return err
+	}
+	svc.mux.Lock()
+	defer svc.mux.Unlock()
+	svc.lastApplied = idx
+	svc.broken = false
+	return nil
+}
+
+func (svc *scenarioService)RaftApplyBroken() {
+	svc.mux.Lock()
+	defer svc.mux.Unlock()
+	svc.broken = true
+}
+
+func (svc *scenarioService)entry(idx int64) (raft.Entry, bool) {
+	svc.mux.Lock()
+	defer svc.mux.Unlock()
+	ent, ok := svc.applied[idx]
+	return ent, ok
+}
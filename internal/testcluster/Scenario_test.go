@@ -0,0 +1,34 @@
+package testcluster
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fallowu/big-ssdb/logger"
+)
+
+func TestScenarioLeaderCrash(t *testing.T){
+	logger.SetDefaultLevel(logger.LevelError)
+	c := New(t, 3)
+	r := NewScenario("leader crash").
+		Write(0, 1000).
+		KillLeader(2 * time.Second).
+		Isolate(3 * time.Second, "n3", 5 * time.Second).
+		RestartAll(10 * time.Second).
+		Write(12 * time.Second, 100).
+		Run(t, c)
+	if r.Acked != 1100 {
+		t.Fatal("acked", r.Acked)
+	}
+}
+
+func TestScenarioPartition(t *testing.T){
+	logger.SetDefaultLevel(logger.LevelError)
+	c := New(t, 5)
+	NewScenario("partition").
+		Write(0, 300).
+		IsolateLeader(time.Second, 10 * time.Second).
+		Partition(15 * time.Second, 10 * time.Second, []string{"n1", "n2", "n3"}, []string{"n4", "n5"}).
+		Kill(18 * time.Second, "n2").
+		Run(t, c)
+}
@@ -0,0 +1,32 @@
+package util
+
+import (
+	"sort"
+)
+
+// Keys of mm in [start, end) in order, for Db implementations on maps.
+// "" end means no upper bound.
+func IterateMap(mm map[string]string, start string, end string, fn func(key string, val string) bool) {
+	keys := make([]string, 0)
+	for k := range mm {
+		if k >= start && (end == "" || k < end) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if !fn(k, mm[k]) {
+			return
+		}
+	}
+}
+
+func MapRangeSize(mm map[string]string, start string, end string) int64 {
+	var size int64
+	for k, v := range mm {
+		if k >= start && (end == "" || k < end) {
+			size += int64(len(k) + len(v))
+		}
+	}
+	return size
+}
@@ -10,6 +10,10 @@ func Atoi(s string) int{
 	return n
 }
 
+func Itoa(u int) string{
+	return strconv.Itoa(u)
+}
+
 func Atoi32(s string) int32{
 	n, _ := strconv.ParseInt(s, 10, 32)
 	return int32(n)
@@ -36,6 +40,14 @@ func MinInt(a, b int) int{
 	}
 }
 
+func MaxInt(a, b int) int{
+	if a > b {
+		return a
+	} else {
+		return b
+	}
+}
+
 func MaxInt32(a, b int32) int32{
 	if a > b {
 		return a
@@ -0,0 +1,255 @@
+package logger
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+type Level int32
+
+const(
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+	LevelFatal
+)
+
+var levelNames = []string{"DEBUG", "INFO", "WARN", "ERROR", "FATAL"}
+
+func (l Level)String() string {
+	if l < LevelDebug || l > LevelFatal {
+		return fmt.Sprintf("Level(%d)", int32(l))
+	}
+	return levelNames[l]
+}
+
+func ParseLevel(s string) (Level, bool) {
+	for i, name := range levelNames {
+		if strings.EqualFold(s, name) {
+			return Level(i), true
+		}
+	}
+	return LevelInfo, false
+}
+
+/* ################ per-subsystem level ################ */
+
+var(
+	levelsMux sync.Mutex
+	// subsystem => level, shared by all loggers of a subsystem
+	levels = make(map[string]*int32)
+	defaultLevel = LevelInfo
+)
+
+func subsystemLevel(subsystem string) *int32 {
+	levelsMux.Lock()
+	defer levelsMux.Unlock()
+
+	p := levels[subsystem]
+	if p == nil {
+		p = new(int32)
+		*p = int32(defaultLevel)
+		levels[subsystem] = p
+	}
+	return p
+}
+
+func SetLevel(subsystem string, level Level) {
+	atomic.StoreInt32(subsystemLevel(subsystem), int32(level))
+}
+
+func GetLevel(subsystem string) Level {
+	return Level(atomic.LoadInt32(subsystemLevel(subsystem)))
+}
+
+// Set level of all subsystems, including those created later
+func SetDefaultLevel(level Level) {
+	levelsMux.Lock()
+	defer levelsMux.Unlock()
+
+	defaultLevel = level
+	for _, p := range levels {
+		atomic.StoreInt32(p, int32(level))
+	}
+}
+
+// spec: "info" or "info,raft=debug,transport=warn"
+func Configure(spec string) bool {
+	for _, p := range strings.Split(spec, ",") {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		kv := strings.SplitN(p, "=", 2)
+		if len(kv) == 1 {
+			level, ok := ParseLevel(kv[0])
+			if !ok {
+				return false
+			}
+			SetDefaultLevel(level)
+		} else {
+			level, ok := ParseLevel(kv[1])
+			if !ok {
+				return false
+			}
+			SetLevel(kv[0], level)
+		}
+	}
+	return true
+}
+
+/* ################ Logger ################ */
+
+// Leveled logger writing "LEVEL [subsystem] k=v ... message k=v ..." lines
+// through the standard log package, or to a Sink. Thread safe.
+type Logger struct{
+	subsystem string
+	level *int32
+	fields []interface{}
+	sink Sink
+}
+
+func New(subsystem string) *Logger {
+	l := new(Logger)
+	l.subsystem = subsystem
+	l.level = subsystemLevel(subsystem)
+	return l
+}
+
+func (l *Logger)Subsystem() string {
+	return l.subsystem
+}
+
+// Returns a logger with fields appended, kvs are key, value pairs
+func (l *Logger)With(kvs ...interface{}) *Logger {
+	ret := new(Logger)
+	*ret = *l
+	ret.fields = append(l.fields[:len(l.fields):len(l.fields)], kvs...)
+	return ret
+}
+
+// Returns a logger of another subsystem with the same fields and sink
+func (l *Logger)Sub(subsystem string) *Logger {
+	ret := New(subsystem)
+	ret.fields = l.fields
+	ret.sink = l.sink
+	return ret
+}
+
+// Returns a logger writing to s instead of the default sink, see SetSink()
+func (l *Logger)WithSink(s Sink) *Logger {
+	ret := new(Logger)
+	*ret = *l
+	ret.sink = s
+	return ret
+}
+
+func (l *Logger)Enabled(level Level) bool {
+	return Level(atomic.LoadInt32(l.level)) <= level
+}
+
+func (l *Logger)DebugEnabled() bool {
+	return l.Enabled(LevelDebug)
+}
+
+// Structured logging, kvs are key, value pairs appended after msg
+func (l *Logger)Debug(msg string, kvs ...interface{}) {
+	if l.Enabled(LevelDebug) {
+		l.output(LevelDebug, msg, kvs)
+	}
+}
+
+func (l *Logger)Info(msg string, kvs ...interface{}) {
+	if l.Enabled(LevelInfo) {
+		l.output(LevelInfo, msg, kvs)
+	}
+}
+
+func (l *Logger)Warn(msg string, kvs ...interface{}) {
+	if l.Enabled(LevelWarn) {
+		l.output(LevelWarn, msg, kvs)
+	}
+}
+
+func (l *Logger)Error(msg string, kvs ...interface{}) {
+	if l.Enabled(LevelError) {
+		l.output(LevelError, msg, kvs)
+	}
+}
+
+func (l *Logger)Debugf(format string, args ...interface{}) {
+	if l.Enabled(LevelDebug) {
+		l.output(LevelDebug, fmt.Sprintf(format, args...), nil)
+	}
+}
+
+func (l *Logger)Infof(format string, args ...interface{}) {
+	if l.Enabled(LevelInfo) {
+		l.output(LevelInfo, fmt.Sprintf(format, args...), nil)
+	}
+}
+
+func (l *Logger)Warnf(format string, args ...interface{}) {
+	if l.Enabled(LevelWarn) {
+		l.output(LevelWarn, fmt.Sprintf(format, args...), nil)
+	}
+}
+
+func (l *Logger)Errorf(format string, args ...interface{}) {
+	if l.Enabled(LevelError) {
+		l.output(LevelError, fmt.Sprintf(format, args...), nil)
+	}
+}
+
+// Logs and exits, regardless of level
+func (l *Logger)Fatalf(format string, args ...interface{}) {
+	l.output(LevelFatal, fmt.Sprintf(format, args...), nil)
+	os.Exit(1)
+}
+
+func (l *Logger)output(level Level, msg string, kvs []interface{}) {
+	sink := l.sink
+	if sink == nil {
+		sink = getSink()
+	}
+	if sink != nil {
+		if len(l.fields) > 0 {
+			kvs = append(l.fields[:len(l.fields):len(l.fields)], kvs...)
+		}
+		sink.Log(level, l.subsystem, msg, kvs)
+		return
+	}
+	var s string
+	if len(l.fields) == 0 {
+		s = fmt.Sprintf("%s [%s] %s%s", level, l.subsystem, msg, formatFields(kvs))
+	} else {
+		s = fmt.Sprintf("%s [%s]%s %s%s", level, l.subsystem, formatFields(l.fields), msg, formatFields(kvs))
+	}
+	// skip output() and the exported method
+	log.Output(3, s)
+}
+
+func formatFields(kvs []interface{}) string {
+	if len(kvs) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	for i := 0; i < len(kvs); i += 2 {
+		b.WriteString(" ")
+		b.WriteString(fmt.Sprint(kvs[i]))
+		b.WriteString("=")
+		if i + 1 < len(kvs) {
+			v := fmt.Sprint(kvs[i+1])
+			if v == "" || strings.ContainsAny(v, " \t\r\n\"=") {
+				v = fmt.Sprintf("%q", v)
+			}
+			b.WriteString(v)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,62 @@
+package logger
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"strings"
+	"testing"
+)
+
+func TestLogger(t *testing.T){
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	log.SetFlags(0)
+
+	if !Configure("warn,test=debug") {
+		t.Fatal("Configure failed")
+	}
+	if Configure("test=bad") {
+		t.Fatal("bad level accepted")
+	}
+
+	l := New("test").With("node", "n1")
+	l.Debug("hello", "peer", "n2", "data", "a b")
+	if buf.String() != "DEBUG [test] node=n1 hello peer=n2 data=\"a b\"\n" {
+		t.Fatal(buf.String())
+	}
+
+	buf.Reset()
+	l.Sub("other").Info("hidden")
+	if buf.Len() != 0 {
+		t.Fatal(buf.String())
+	}
+	l.Sub("other").Warnf("shown %d", 1)
+	if !strings.HasPrefix(buf.String(), "WARN [other] node=n1 shown 1") {
+		t.Fatal(buf.String())
+	}
+	SetDefaultLevel(LevelInfo)
+}
+
+func TestSink(t *testing.T){
+	var got []string
+	sink := SinkFunc(func(level Level, subsystem string, msg string, kvs []interface{}) {
+		got = append(got, fmt.Sprintf("%s %s %s %v", level, subsystem, msg, kvs))
+	})
+	l := New("sink").With("node", "n1").WithSink(sink)
+	l.Sub("sink2").Info("hello", "k", 1)
+	l.Debug("hidden")
+	if len(got) != 1 || got[0] != "INFO sink2 hello [node n1 k 1]" {
+		t.Fatal(got)
+	}
+
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	SetSink(sink)
+	New("sink").Warnf("w %d", 1)
+	SetSink(nil)
+	New("sink").Warn("std")
+	if len(got) != 2 || got[1] != "WARN sink w 1 []" || !strings.Contains(buf.String(), "std") {
+		t.Fatal(got, buf.String())
+	}
+}
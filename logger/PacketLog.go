@@ -0,0 +1,100 @@
+package logger
+
+import (
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// Options for logging high volume content, e.g. every packet of a transport
+type PacketLogOptions struct{
+	SampleN int64 // log 1 of every SampleN packets, <= 1 logs all
+	MaxLen int    // truncate content longer than MaxLen bytes, <= 0 means no limit
+	Redact bool   // log sizes instead of values
+}
+
+var packetLogOptions atomic.Pointer[PacketLogOptions]
+
+func init() {
+	packetLogOptions.Store(&PacketLogOptions{})
+}
+
+// Applies to all PacketLogs, including those created later
+func SetPacketLogOptions(opts PacketLogOptions) {
+	packetLogOptions.Store(&opts)
+}
+
+func GetPacketLogOptions() PacketLogOptions {
+	return *packetLogOptions.Load()
+}
+
+// spec: "sample=100,maxlen=256,redact", "" means log everything
+func ConfigurePackets(spec string) bool {
+	var opts PacketLogOptions
+	for _, p := range strings.Split(spec, ",") {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		kv := strings.SplitN(p, "=", 2)
+		var err error
+		switch {
+		case kv[0] == "redact" && len(kv) == 1:
+			opts.Redact = true
+		case kv[0] == "sample" && len(kv) == 2:
+			opts.SampleN, err = strconv.ParseInt(kv[1], 10, 64)
+		case kv[0] == "maxlen" && len(kv) == 2:
+			opts.MaxLen, err = strconv.Atoi(kv[1])
+		default:
+			return false
+		}
+		if err != nil {
+			return false
+		}
+	}
+	SetPacketLogOptions(opts)
+	return true
+}
+
+// Debug logging of packets with sampling, truncation and redaction. Usage:
+//
+//	if plog.Sample() {
+//		plog.Log("send >", msg.Encode(), msg.Redacted)
+//	}
+//
+// Thread safe.
+type PacketLog struct{
+	log *Logger
+	count int64
+}
+
+func NewPacketLog(l *Logger) *PacketLog {
+	p := new(PacketLog)
+	p.log = l
+	return p
+}
+
+// Cheap check whether the current packet should be logged
+func (p *PacketLog)Sample() bool {
+	if !p.log.DebugEnabled() {
+		return false
+	}
+	n := packetLogOptions.Load().SampleN
+	if n <= 1 {
+		return true
+	}
+	return (atomic.AddInt64(&p.count, 1) - 1) % n == 0
+}
+
+// redacted is called instead of using content when redaction is on
+func (p *PacketLog)Log(prefix string, content string, redacted func() string) {
+	opts := packetLogOptions.Load()
+	if opts.Redact && redacted != nil {
+		content = redacted()
+	}
+	content = strings.Trim(content, "\r\n")
+	if opts.MaxLen > 0 && len(content) > opts.MaxLen {
+		content = content[:opts.MaxLen] + "...(" + strconv.Itoa(len(content)) + " bytes)"
+	}
+	p.log.output(LevelDebug, prefix + " " + content, nil)
+}
@@ -0,0 +1,53 @@
+package logger
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"testing"
+)
+
+func TestPacketLog(t *testing.T){
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	log.SetFlags(0)
+	defer SetPacketLogOptions(PacketLogOptions{})
+
+	SetLevel("packet", LevelDebug)
+	p := NewPacketLog(New("packet"))
+
+	if !ConfigurePackets("sample=3,maxlen=5,redact") {
+		t.Fatal("ConfigurePackets failed")
+	}
+	if ConfigurePackets("sample=x") || ConfigurePackets("unknown") {
+		t.Fatal("bad spec accepted")
+	}
+	ConfigurePackets("sample=3,maxlen=5,redact")
+
+	n := 0
+	for i := 0; i < 9; i ++ {
+		if p.Sample() {
+			n ++
+		}
+	}
+	if n != 3 {
+		t.Fatal("sampled", n)
+	}
+
+	p.Log("send >", "secret", func() string { return "<6 bytes>" })
+	if buf.String() != "DEBUG [packet] send > <6 by...(9 bytes)\n" {
+		t.Fatal(buf.String())
+	}
+
+	buf.Reset()
+	ConfigurePackets("")
+	p.Log("send >", "hello world\r\n", nil)
+	if strings.TrimSpace(buf.String()) != "DEBUG [packet] send > hello world" {
+		t.Fatal(buf.String())
+	}
+
+	SetLevel("packet", LevelInfo)
+	if p.Sample() {
+		t.Fatal("sampled when debug disabled")
+	}
+}
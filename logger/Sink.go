@@ -0,0 +1,40 @@
+package logger
+
+import (
+	"sync/atomic"
+)
+
+// Minimal backend interface, implement it to route logs into zap, zerolog
+// etc. kvs are the logger's fields followed by the call's key, value pairs.
+// Called only for enabled levels, so subsystems are still silenced by
+// SetLevel()/Configure(). Must be thread safe.
+type Sink interface{
+	Log(level Level, subsystem string, msg string, kvs []interface{})
+}
+
+// Adapts a function to Sink
+type SinkFunc func(level Level, subsystem string, msg string, kvs []interface{})
+
+func (f SinkFunc)Log(level Level, subsystem string, msg string, kvs []interface{}) {
+	f(level, subsystem, msg, kvs)
+}
+
+type sinkHolder struct{
+	sink Sink
+}
+
+// nil means the standard log package
+var defaultSink atomic.Pointer[sinkHolder]
+
+// Sink of loggers without their own, including those created earlier. nil
+// restores the standard log package.
+func SetSink(s Sink) {
+	defaultSink.Store(&sinkHolder{s})
+}
+
+func getSink() Sink {
+	if h := defaultSink.Load(); h != nil {
+		return h.sink
+	}
+	return nil
+}
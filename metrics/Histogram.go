@@ -0,0 +1,71 @@
+package metrics
+
+import (
+	"sync"
+	"time"
+)
+
+// default buckets for latency, in seconds
+var LatencyBuckets = []float64{0.0001, 0.0005, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5}
+
+// thread safe
+type Histogram struct{
+	buckets []float64
+	counts []int64 // not cumulative
+	count int64
+	sum float64
+	mux sync.Mutex
+}
+
+type HistogramSnapshot struct{
+	Buckets []float64 // upper bounds
+	Counts []int64    // cumulative count of each bucket
+	Count int64
+	Sum float64
+}
+
+func NewHistogram(buckets []float64) *Histogram {
+	h := new(Histogram)
+	h.buckets = buckets
+	h.counts = make([]int64, len(buckets))
+	return h
+}
+
+func NewLatencyHistogram() *Histogram {
+	return NewHistogram(LatencyBuckets)
+}
+
+func (h *Histogram)Observe(v float64) {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+
+	h.count ++
+	h.sum += v
+	for i, b := range h.buckets {
+		if v <= b {
+			h.counts[i] ++
+			break
+		}
+	}
+}
+
+func (h *Histogram)ObserveDuration(d time.Duration) {
+	h.Observe(d.Seconds())
+}
+
+func (h *Histogram)Snapshot() *HistogramSnapshot {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+
+	sn := new(HistogramSnapshot)
+	sn.Buckets = h.buckets
+	sn.Counts = make([]int64, len(h.counts))
+	var n int64 = 0
+	for i, c := range h.counts {
+		n += c
+		sn.Counts[i] = n
+	}
+	sn.Count = h.count
+	sn.Sum = h.sum
+	return sn
+}
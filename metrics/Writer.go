@@ -0,0 +1,69 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Writes metrics in Prometheus text exposition format.
+// Samples of the same metric name must be written together.
+type Writer struct{
+	w io.Writer
+	last string
+}
+
+func NewWriter(w io.Writer) *Writer {
+	ret := new(Writer)
+	ret.w = w
+	return ret
+}
+
+// labels are key, value pairs
+func (w *Writer)Gauge(name string, help string, value float64, labels ...string) {
+	w.header(name, help, "gauge")
+	w.sample(name, labels, value)
+}
+
+func (w *Writer)Counter(name string, help string, value float64, labels ...string) {
+	w.header(name, help, "counter")
+	w.sample(name, labels, value)
+}
+
+func (w *Writer)Histogram(name string, help string, h *HistogramSnapshot, labels ...string) {
+	w.header(name, help, "histogram")
+	for i, b := range h.Buckets {
+		ls := append(append([]string{}, labels...), "le", formatFloat(b))
+		w.sample(name + "_bucket", ls, float64(h.Counts[i]))
+	}
+	ls := append(append([]string{}, labels...), "le", "+Inf")
+	w.sample(name + "_bucket", ls, float64(h.Count))
+	w.sample(name + "_sum", labels, h.Sum)
+	w.sample(name + "_count", labels, float64(h.Count))
+}
+
+func (w *Writer)header(name string, help string, type_ string) {
+	if w.last == name {
+		return
+	}
+	w.last = name
+	fmt.Fprintf(w.w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w.w, "# TYPE %s %s\n", name, type_)
+}
+
+func (w *Writer)sample(name string, labels []string, value float64) {
+	if len(labels) == 0 {
+		fmt.Fprintf(w.w, "%s %s\n", name, formatFloat(value))
+		return
+	}
+	ps := make([]string, 0, len(labels)/2)
+	for i := 0; i + 1 < len(labels); i += 2 {
+		ps = append(ps, fmt.Sprintf("%s=%s", labels[i], strconv.Quote(labels[i+1])))
+	}
+	fmt.Fprintf(w.w, "%s{%s} %s\n", name, strings.Join(ps, ","), formatFloat(value))
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
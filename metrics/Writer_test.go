@@ -0,0 +1,35 @@
+package metrics
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestWriter(t *testing.T){
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.Gauge("a", "A.", 1, "k", "x")
+	w.Gauge("a", "A.", 2, "k", "y")
+
+	h := NewHistogram([]float64{1, 2})
+	h.Observe(0.5)
+	h.Observe(1.5)
+	h.Observe(3)
+	w.Histogram("h", "H.", h.Snapshot())
+
+	expect := `# HELP a A.
+# TYPE a gauge
+a{k="x"} 1
+a{k="y"} 2
+# HELP h H.
+# TYPE h histogram
+h_bucket{le="1"} 1
+h_bucket{le="2"} 2
+h_bucket{le="+Inf"} 3
+h_sum 5
+h_count 3
+`
+	if buf.String() != expect {
+		t.Fatal(buf.String())
+	}
+}
@@ -0,0 +1,64 @@
+package raft
+
+import (
+	"github.com/fallowu/big-ssdb/internal/util"
+)
+
+// max entries acknowledged by one AppendEntryAck
+const DefaultAckBatchSize = 16
+
+// Follower acks written entries with PrevIndex = the last matching index(see
+// matchedIndex()), so one ack acknowledges all entries before it. Acks are
+// delayed until AckBatchSize entries are written, no more messages are
+// waiting in recv_c, or the next tick, whichever comes first.
+func (node *Node)delayAck(leader string, written int64){
+	if node.ackLeader != leader {
+		node.flushAck()
+	}
+	node.ackLeader = leader
+	node.ackIndex = util.MaxInt64(node.ackIndex, node.matchedIndex(written))
+	node.ackPending ++
+	if node.ackPending >= node.AckBatchSize {
+		node.flushAck()
+	}
+}
+
+func (node *Node)flushAck(){
+	if node.ackPending == 0 {
+		return
+	}
+	leader := node.ackLeader
+	index := node.ackIndex
+	node.clearAck()
+	node.store.Sync()
+	node.send(node.withEffectsHash(node.newMatchAck(leader, index)))
+}
+
+// a newer ack is being sent, it covers delayed entries, returns the index
+// they acknowledge
+func (node *Node)clearAck() int64 {
+	index := node.ackIndex
+	node.ackLeader = ""
+	node.ackPending = 0
+	node.ackIndex = 0
+	return index
+}
+
+// The log matches the leader's up to written, the entry just written after a
+// matching prev. Entries after it may be left by an old leader, not replaced
+// yet, unless the last entry is of the current term, i.e. from the leader.
+func (node *Node)matchedIndex(written int64) int64 {
+	if node.store.LastTerm == node.Term {
+		return node.store.LastIndex
+	}
+	return util.MinInt64(written, node.store.LastIndex)
+}
+
+func (node *Node)newMatchAck(leader string, index int64) *Message {
+	ack := NewAppendEntryAck(leader, true)
+	ack.PrevIndex = index
+	if ent := node.store.GetEntry(index); ent != nil {
+		ack.PrevTerm = ent.Term
+	}
+	return ack
+}
@@ -0,0 +1,75 @@
+package raft
+
+import (
+	"testing"
+
+	"github.com/fallowu/big-ssdb/logger"
+)
+
+func TestAckBatch(t *testing.T){
+	logger.SetDefaultLevel(logger.LevelError)
+	var queue []*Message
+	outbox := func(msg *Message) {
+		cp := *msg
+		queue = append(queue, &cp)
+	}
+	n1 := NewNode("n1", "addr1", NewMemDb())
+	n2 := NewNode("n2", "addr2", NewMemDb())
+	nodes := map[string]*Node{"n1": n1, "n2": n2}
+	pump := func() {
+		for len(queue) > 0 {
+			msg := queue[0]
+			queue = queue[1:]
+			nodes[msg.Dst].StepMessage(msg)
+		}
+	}
+	for _, n := range nodes {
+		n.SetOutbox(outbox)
+	}
+	n1.AddMember("n1", "addr1")
+	n1.StepTick(0)
+	n1.AddMember("n2", "addr2")
+	n2.JoinGroup("n1", "addr1")
+	for i := 0; i < 60; i ++ {
+		n1.StepTick(100)
+		n2.StepTick(100)
+		pump()
+	}
+
+	n2.AckBatchSize = 2
+	// shrunk by rejects while n2 joined, see SendWindow.go
+	n1.Members["n2"].SendWindow = 3
+	for i := 0; i < 3; i ++ {
+		n1.Propose("data")
+	}
+	n1.StepTick(0)
+	countAcks := func() int {
+		acks := 0
+		for _, msg := range queue {
+			if msg.Src == "n2" && msg.Type == MessageTypeAppendEntryAck {
+				acks ++
+			}
+		}
+		return acks
+	}
+	entries := queue
+	queue = nil
+	// handled back to back, as StartCommunication() does while recv_c is not empty
+	n2.mux.Lock()
+	for _, msg := range entries {
+		n2.handleRaftMessage(msg)
+	}
+	n2.mux.Unlock()
+	if len(entries) != 3 || countAcks() != 1 {
+		t.Fatal("entries", len(entries), "acks", countAcks())
+	}
+	// the rest is acked on next tick
+	n2.StepTick(0)
+	if countAcks() != 2 {
+		t.Fatal("acks", countAcks())
+	}
+	pump()
+	if m := n1.Metrics(); m.CommitIndex != n2.Metrics().LastIndex {
+		t.Fatal("commit", m.CommitIndex, "follower", n2.Metrics().LastIndex)
+	}
+}
@@ -0,0 +1,100 @@
+package raft
+
+import (
+	"time"
+)
+
+// Limits on data proposals accepted by the leader, protecting commit latency
+// from unbounded client bursts. Excess proposals fail with a BusyError, or
+// with OverflowBlock wait in ProposeContext()/ProposeAndWait() until
+// admitted or ctx is done. Propose() never waits. Config changes are not
+// limited.
+type AdmissionConfig struct{
+	// entries proposed but not committed yet, 0 unlimited
+	MaxInflight int64
+	// entries committed but not applied by Service yet, 0 unlimited. A slow
+	// Service pushes back on clients instead of queueing without bound
+	MaxApplyBacklog int64
+	// bytes of proposed data per second, 0 unlimited
+	BytesPerSecond int64
+	// bytes which may be proposed at once, defaults to BytesPerSecond
+	Burst int64
+	Overflow OverflowPolicy
+}
+
+// Proposal rejected by admission control, Is(ErrBusy)
+type BusyError struct{
+	// "inflight", "apply" or "rate"
+	Reason string
+	// when the rate limit admits it, 0 if unknown
+	RetryAfter time.Duration
+}
+
+func (e *BusyError)Error() string {
+	return ErrBusy.Error() + ": " + e.Reason
+}
+
+func (e *BusyError)Is(target error) bool {
+	return target == ErrBusy
+}
+
+// Replaces the limits, the byte bucket starts full
+func (node *Node)SetAdmission(conf AdmissionConfig){
+	node.proposeMux.Lock()
+	defer node.proposeMux.Unlock()
+	node.setAdmission(conf)
+}
+
+func (node *Node)setAdmission(conf AdmissionConfig){
+	if conf.Burst <= 0 {
+		conf.Burst = conf.BytesPerSecond
+	}
+	node.admission = conf
+	node.admitTokens = float64(conf.Burst)
+	node.admitAt = node.clock.Now()
+	node.notifyAdmitWaiters()
+}
+
+// with proposeMux held, takes bytes from the bucket if admitted
+func (node *Node)admit(bytes int) error {
+	conf := &node.admission
+	g := &node.gate
+	if conf.MaxInflight > 0 && g.nextIndex - 1 - g.commitIndex >= conf.MaxInflight {
+		node.proposalsBusy ++
+		return &BusyError{Reason: "inflight"}
+	}
+	if conf.MaxApplyBacklog > 0 && g.hasService && g.commitIndex - g.serviceApplied >= conf.MaxApplyBacklog {
+		node.proposalsBusy ++
+		return &BusyError{Reason: "apply"}
+	}
+	if conf.BytesPerSecond <= 0 {
+		return nil
+	}
+	now := node.clock.Now()
+	node.admitTokens += now.Sub(node.admitAt).Seconds() * float64(conf.BytesPerSecond)
+	if node.admitTokens > float64(conf.Burst) {
+		node.admitTokens = float64(conf.Burst)
+	}
+	node.admitAt = now
+	// a proposal larger than Burst is admitted when the bucket is full
+	need := float64(bytes)
+	if need > float64(conf.Burst) {
+		need = float64(conf.Burst)
+	}
+	if node.admitTokens < need {
+		node.proposalsBusy ++
+		wait := (need - node.admitTokens) / float64(conf.BytesPerSecond)
+		return &BusyError{Reason: "rate", RetryAfter: time.Duration(wait * float64(time.Second))}
+	}
+	node.admitTokens -= float64(bytes)
+	return nil
+}
+
+// with proposeMux held, commits, limit changes and role changes may admit
+// waiting proposals, see refreshGate()
+func (node *Node)notifyAdmitWaiters(){
+	for _, c := range node.admitWaiters {
+		close(c)
+	}
+	node.admitWaiters = nil
+}
@@ -0,0 +1,76 @@
+package raft
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/fallowu/big-ssdb/logger"
+)
+
+func TestAdmission(t *testing.T){
+	logger.SetDefaultLevel(logger.LevelError)
+	clock := NewManualClock()
+	n1 := NewNode("n1", "addr1", NewMemDb(), WithClock(clock))
+	n1.SetOutbox(func(msg *Message) {})
+	n1.AddMember("n1", "addr1")
+	n1.StepTick(0)
+
+	// committed by ticks only
+	n1.SetAdmission(AdmissionConfig{MaxInflight: 1})
+	if _, _, err := n1.Propose("data"); err != nil {
+		t.Fatal("err", err)
+	}
+	var busy *BusyError
+	if _, _, err := n1.Propose("data"); !errors.Is(err, ErrBusy) || !errors.As(err, &busy) || busy.Reason != "inflight" {
+		t.Fatal("err", err)
+	}
+	n1.StepTick(0)
+	if _, _, err := n1.Propose("data"); err != nil {
+		t.Fatal("err", err)
+	}
+	n1.StepTick(0)
+
+	n1.SetAdmission(AdmissionConfig{BytesPerSecond: 10})
+	if _, _, err := n1.Propose("0123456789"); err != nil {
+		t.Fatal("err", err)
+	}
+	if _, _, err := n1.Propose("01234"); !errors.As(err, &busy) || busy.RetryAfter != 500 * time.Millisecond {
+		t.Fatal("err", err)
+	}
+	clock.Advance(500 * time.Millisecond)
+	if _, _, err := n1.Propose("01234"); err != nil {
+		t.Fatal("err", err)
+	}
+	if n1.Metrics().ProposalsBusy != 2 {
+		t.Fatal("busy", n1.Metrics().ProposalsBusy)
+	}
+
+	// blocks until the bucket refills
+	n1.SetAdmission(AdmissionConfig{BytesPerSecond: 10, Overflow: OverflowBlock})
+	n1.Propose("0123456789")
+	done := make(chan error, 1)
+	go func() {
+		_, _, err := n1.ProposeContext(context.Background(), "01234")
+		done <- err
+	}()
+	for n1.Metrics().ProposalsBusy != 3 {
+		time.Sleep(time.Millisecond)
+	}
+	for i := 0; ; i++ {
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatal("err", err)
+			}
+			return
+		case <-time.After(time.Millisecond):
+		}
+		if i > 1000 {
+			t.Fatal("not admitted")
+		}
+		clock.Advance(100 * time.Millisecond)
+		n1.StepTick(0)
+	}
+}
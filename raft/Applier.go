@@ -0,0 +1,219 @@
+package raft
+
+import (
+	"strings"
+	"time"
+
+	"github.com/fallowu/big-ssdb/trace"
+)
+
+// capacity of the queue between raft and the apply goroutine
+const DefaultApplyQueueSize = 256
+
+// Committed entries are applied to Service by a dedicated goroutine once
+// StartApplier() is called(Start() does), so that a slow Service never
+// blocks raft. Raft queues entries without waiting, a full queue is
+// refilled when the goroutine reports applied indexes back. Without the
+// goroutine, as in simulation, entries are applied synchronously in
+// ApplyEntries(), with node's lock held.
+type applyTask struct{
+	svc Service
+	// incremented by SetService(), tasks of an old Service are ignored
+	gen int64
+	// nil: Service lost entries, install snapshot
+	ent *Entry
+	// restore Service's state from the raft snapshot, see ServiceSnapshot.go
+	restore bool
+	state string
+	span trace.Span
+	applyCheck bool
+}
+
+// produced by the apply goroutine, consumed with node's lock held
+type applyResult struct{
+	task *applyTask
+	elapsed time.Duration
+	// returned by ApplyEntry()
+	result interface{}
+	hash string
+	hashed bool
+	// of a restore task
+	err error
+	applied int64
+	effect string
+	reported bool
+}
+
+// Start the goroutine applying committed entries to Service
+func (node *Node)StartApplier(){
+	node.mux.Lock()
+	if node.applyC != nil {
+		node.mux.Unlock()
+		return
+	}
+	node.applyC = make(chan *applyTask, DefaultApplyQueueSize)
+	c := node.applyC
+	stop := node.stopChan()
+	node.goroutines.Add(1)
+	node.mux.Unlock()
+
+	go func() {
+		defer node.goroutines.Done()
+		node.log.Info("setup applier", "queue", cap(c))
+		for {
+			var task *applyTask
+			select {
+			case task = <-c:
+			case <-stop:
+				return
+			}
+			res := node.runApplyTask(task)
+			node.mux.Lock()
+			node.onApplied(res)
+			node.dispatchApply()
+			node.unlock()
+		}
+	}()
+}
+
+// Called by SetService(), svc's own LastApplied() is where to resume
+func (node *Node)resetApplier(svc Service){
+	node.applyGen ++
+	node.applyLost = false
+	node.applyRestore = nil
+	node.applyRestoring = false
+	node.serviceApplied = 0
+	if svc != nil {
+		node.serviceApplied = svc.LastApplied()
+	}
+	node.applyQueued = node.serviceApplied
+	// entries up to svc.LastApplied() are not applied again
+	node.failApplyWaiters(ErrApplyResultLost)
+}
+
+// queue committed entries not yet queued, stop when the queue is full
+func (node *Node)dispatchApply(){
+	st := node.store
+	if st.Service == nil {
+		return
+	}
+	if task := node.applyRestore; task != nil {
+		if node.applyC != nil && len(node.applyC) == cap(node.applyC) {
+			return
+		}
+		node.applyRestore = nil
+		node.applyRestoring = true
+		node.queueApply(task)
+	}
+	if node.applyRestoring {
+		// resumed by onApplied() from Service's restored LastApplied()
+		return
+	}
+	for idx := node.applyQueued + 1; idx <= st.CommitIndex; idx ++ {
+		if node.applyC != nil && len(node.applyC) == cap(node.applyC) {
+			// resumed by onApplied()
+			return
+		}
+		task := &applyTask{svc: st.Service, gen: node.applyGen, applyCheck: node.applyCheck}
+		ent := st.GetEntry(idx)
+		if ent == nil {
+			if node.applyLost {
+				return
+			}
+			node.applyLost = true
+			st.log.Warn("lost entry, notify Service to install snapshot",
+					"index", idx, "serviceLastApplied", node.serviceApplied)
+			node.queueApply(task)
+			return
+		}
+		cp := *ent
+		task.ent = &cp
+		task.span = node.startApplySpan(ent)
+		node.applyQueued = idx
+		node.queueApply(task)
+	}
+}
+
+func (node *Node)queueApply(task *applyTask){
+	if node.applyC != nil {
+		// never blocks, only raft sends and the queue is not full
+		node.applyC <- task
+		return
+	}
+	node.onApplied(node.runApplyTask(task))
+}
+
+// calls into Service, without node's lock unless applied synchronously
+func (node *Node)runApplyTask(task *applyTask) *applyResult {
+	res := &applyResult{task: task}
+	if task.restore {
+		res.err = task.svc.RestoreSnapshot(strings.NewReader(task.state))
+		res.applied = task.svc.LastApplied()
+		return res
+	}
+	if task.ent == nil {
+		if h, ok := task.svc.(ApplyBrokenHandler); ok {
+			h.RaftApplyBroken()
+		}
+		return res
+	}
+	start := node.clock.Now()
+	res.result = task.svc.Apply(task.ent)
+	res.elapsed = node.clock.Now().Sub(start)
+	// must be taken before the next entry is applied
+	if hasher, ok := task.svc.(StateHasher); ok {
+		res.hash = hasher.StateHash()
+		res.hashed = true
+	}
+	if reporter, ok := task.svc.(EffectReporter); ok && task.applyCheck {
+		res.effect = reporter.LastEffect()
+		res.reported = true
+	}
+	return res
+}
+
+func (node *Node)onApplied(res *applyResult){
+	task := res.task
+	if task.span != nil {
+		task.span.End()
+	}
+	if task.gen != node.applyGen {
+		return
+	}
+	if task.restore {
+		node.onServiceRestored(res)
+		return
+	}
+	if task.ent == nil {
+		node.failApplyWaiters(ErrApplyResultLost)
+		return
+	}
+	node.serviceApplied = task.ent.Index
+	node.store.observeApply(task.ent, res.elapsed)
+	if res.hashed {
+		node.recordStateHash(task.ent.Index, res.hash)
+	}
+	if res.reported {
+		node.recordEffect(task.ent.Index, res.effect)
+	}
+	node.notifyApplyWaiter(task.ent, res.result)
+}
+
+// number of entries queued but not yet applied to Service
+func (node *Node)applyQueueLen() int64 {
+	return node.applyQueued - node.serviceApplied
+}
+
+// Service applies slower than threshold are logged and counted by
+// Metrics.SlowApplies, and a backlog of committed entries above limit is
+// logged. 0 keeps the current one.
+func (node *Node)SetSlowApply(threshold time.Duration, backlogLimit int64){
+	node.mux.Lock()
+	defer node.mux.Unlock()
+	if threshold > 0 {
+		node.store.SlowApplyThreshold = threshold
+	}
+	if backlogLimit > 0 {
+		node.store.ApplyBacklogLimit = backlogLimit
+	}
+}
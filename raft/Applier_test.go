@@ -0,0 +1,95 @@
+package raft
+
+import (
+	"errors"
+	"io"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fallowu/big-ssdb/logger"
+)
+
+type blockingService struct{
+	lastApplied int64
+	release chan struct{}
+}
+
+func (svc *blockingService)LastApplied() int64 { return atomic.LoadInt64(&svc.lastApplied) }
+func (svc *blockingService)SaveSnapshot(w io.Writer) error { return ErrSnapshotUnsupported }
+func (svc *blockingService)RestoreSnapshot(r io.Reader) error { return ErrSnapshotUnsupported }
+
+func (svc *blockingService)Apply(ent *Entry) Result {
+	<-svc.release
+	atomic.StoreInt64(&svc.lastApplied, ent.Index)
+	return nil
+}
+
+func TestAsyncApply(t *testing.T){
+	logger.SetDefaultLevel(logger.LevelError)
+	n1 := NewNode("n1", "addr1", NewMemDb())
+	n1.SetOutbox(func(msg *Message) {})
+	n1.AddMember("n1", "addr1")
+	n1.StepTick(0)
+
+	svc := &blockingService{lastApplied: n1.LastApplied(), release: make(chan struct{})}
+	n1.SetService(svc)
+	n1.StartApplier()
+	// a blocked Service must not block proposals and commits
+	for i := 0; i < DefaultApplyQueueSize * 2; i ++ {
+		n1.Propose("data")
+		n1.StepTick(0)
+	}
+	m := n1.Metrics()
+	if m.CommitIndex != m.LastIndex || m.ServiceLastApplied != svc.LastApplied() {
+		t.Fatal("commit", m.CommitIndex, "last", m.LastIndex, "service", m.ServiceLastApplied)
+	}
+	// plus the one being applied
+	if m.ApplyQueue < DefaultApplyQueueSize || m.ApplyQueue > DefaultApplyQueueSize + 1 {
+		t.Fatal("queue", m.ApplyQueue)
+	}
+
+	close(svc.release)
+	deadline := time.Now().Add(5 * time.Second)
+	for n1.Metrics().ServiceLastApplied != m.CommitIndex {
+		if time.Now().After(deadline) {
+			t.Fatal("not applied", n1.Metrics().ServiceLastApplied)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if svc.LastApplied() != m.CommitIndex || n1.Metrics().ApplyQueue != 0 {
+		t.Fatal("service", svc.LastApplied(), "queue", n1.Metrics().ApplyQueue)
+	}
+}
+
+func TestApplyBacklogBusy(t *testing.T){
+	logger.SetDefaultLevel(logger.LevelError)
+	n1 := NewNode("n1", "addr1", NewMemDb(), WithAdmission(AdmissionConfig{MaxApplyBacklog: 4}))
+	n1.SetOutbox(func(msg *Message) {})
+	n1.AddMember("n1", "addr1")
+	n1.StepTick(0)
+
+	svc := &blockingService{lastApplied: n1.LastApplied(), release: make(chan struct{})}
+	n1.SetService(svc)
+	n1.StartApplier()
+	var err error
+	for i := 0; i < 10 && err == nil; i ++ {
+		_, _, err = n1.Propose("data")
+		n1.StepTick(0)
+	}
+	if !errors.Is(err, ErrBusy) || n1.Metrics().ProposalsBusy != 1 {
+		t.Fatal(err, n1.Metrics().ProposalsBusy)
+	}
+
+	close(svc.release)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, _, err = n1.Propose("data"); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("still busy", err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
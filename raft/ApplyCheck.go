@@ -0,0 +1,94 @@
+package raft
+
+import (
+	"fmt"
+	"strings"
+	"hash/fnv"
+	"encoding/binary"
+
+	"github.com/fallowu/big-ssdb/internal/util"
+)
+
+// number of applied indexes whose effect hash is remembered
+const MaxApplyEffects = 1024
+
+// Compare results of applying entries while replicating: followers hash the
+// effects(see EffectReporter) of entries they applied since last ack, and
+// piggyback it on AppendEntryAck, as "true <from> <to> <hash>". The leader
+// hashes its own effects of the same entries, a difference means Service's
+// apply is nondeterministic. It is logged, counted and emitted as
+// SinkApplyDiverged right away. Enable on every node, does nothing unless
+// Service is an EffectReporter.
+func (node *Node)SetApplyCheck(enabled bool){
+	node.mux.Lock()
+	defer node.mux.Unlock()
+	node.applyCheck = enabled
+}
+
+func (node *Node)recordEffect(index int64, effect string){
+	if !node.applyCheck {
+		return
+	}
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%d %s", index, effect)
+	node.effects[index] = h.Sum64()
+	node.effectIndexes = append(node.effectIndexes, index)
+	for len(node.effectIndexes) > MaxApplyEffects {
+		delete(node.effects, node.effectIndexes[0])
+		node.effectIndexes = node.effectIndexes[1:]
+	}
+}
+
+// "" if effect of any entry in from...to is not recorded
+func (node *Node)effectsHash(from int64, to int64) string {
+	h := fnv.New64a()
+	var buf [8]byte
+	for idx := from; idx <= to; idx ++ {
+		e, ok := node.effects[idx]
+		if !ok {
+			return ""
+		}
+		binary.BigEndian.PutUint64(buf[:], e)
+		h.Write(buf[:])
+	}
+	return fmt.Sprintf("%016x", h.Sum64())
+}
+
+// follower, append hash of effects not yet reported to a successful ack
+func (node *Node)withEffectsHash(ack *Message) *Message {
+	if !node.applyCheck || len(node.effectIndexes) == 0 {
+		return ack
+	}
+	from := util.MaxInt64(node.effectReported + 1, node.effectIndexes[0])
+	to := node.effectIndexes[len(node.effectIndexes) - 1]
+	if from > to {
+		return ack
+	}
+	if hash := node.effectsHash(from, to); hash != "" {
+		ack.Data = fmt.Sprintf("%s %d %d %s", ack.Data, from, to, hash)
+		node.effectReported = to
+	}
+	return ack
+}
+
+// leader, data of a successful ack
+func (node *Node)checkEffectsHash(m *Member, data string){
+	ps := strings.Split(data, " ")
+	if !node.applyCheck || len(ps) != 4 {
+		return
+	}
+	from, ok1 := parseIndex(ps[1])
+	to, ok2 := parseIndex(ps[2])
+	if !ok1 || !ok2 || from > to {
+		return
+	}
+	// not applied by leader yet, or forgotten
+	hash := node.effectsHash(from, to)
+	if hash == "" || hash == ps[3] {
+		return
+	}
+	node.applyDivergences ++
+	detail := fmt.Sprintf("entries %d~%d applied differently, hash %s, leader's %s", from, to, ps[3], hash)
+	node.log.Error("apply result diverged", "peer", m.Id, "from", from, "to", to, "hash", ps[3], "leaderHash", hash)
+	node.emitEvent(SinkApplyDiverged, m.Id, to, detail)
+}
@@ -0,0 +1,80 @@
+package raft
+
+import (
+	"io"
+	"testing"
+
+	"github.com/fallowu/big-ssdb/logger"
+)
+
+type effectService struct{
+	lastApplied int64
+	effect string
+	apply func(ent *Entry) string
+}
+
+func (svc *effectService)LastApplied() int64 { return svc.lastApplied }
+func (svc *effectService)SaveSnapshot(w io.Writer) error { return ErrSnapshotUnsupported }
+func (svc *effectService)RestoreSnapshot(r io.Reader) error { return ErrSnapshotUnsupported }
+func (svc *effectService)LastEffect() string { return svc.effect }
+
+func (svc *effectService)Apply(ent *Entry) Result {
+	svc.lastApplied = ent.Index
+	svc.effect = svc.apply(ent)
+	return nil
+}
+
+func TestApplyCheck(t *testing.T){
+	logger.SetDefaultLevel(logger.LevelError)
+	var queue []*Message
+	outbox := func(msg *Message) {
+		cp := *msg
+		queue = append(queue, &cp)
+	}
+	n1 := NewNode("n1", "addr1", NewMemDb())
+	n2 := NewNode("n2", "addr2", NewMemDb())
+	nodes := map[string]*Node{"n1": n1, "n2": n2}
+	run := func(ticks int) {
+		for i := 0; i < ticks; i ++ {
+			n1.StepTick(100)
+			n2.StepTick(100)
+			for len(queue) > 0 {
+				msg := queue[0]
+				queue = queue[1:]
+				nodes[msg.Dst].StepMessage(msg)
+			}
+		}
+	}
+	for _, n := range nodes {
+		n.SetOutbox(outbox)
+		n.SetApplyCheck(true)
+	}
+	n1.AddMember("n1", "addr1")
+	n1.StepTick(0)
+	n1.AddMember("n2", "addr2")
+	n2.JoinGroup("n1", "addr1")
+	run(60)
+
+	deterministic := func(ent *Entry) string {
+		return ent.Data
+	}
+	n1.SetService(&effectService{lastApplied: n1.LastApplied(), apply: deterministic})
+	n2.SetService(&effectService{lastApplied: n2.LastApplied(), apply: deterministic})
+	for i := 0; i < 5; i ++ {
+		n1.Propose("data")
+		run(5)
+	}
+	if n1.Metrics().ApplyDivergences != 0 || n2.effectReported < n1.LastApplied() - 1 {
+		t.Fatal("divergences", n1.Metrics().ApplyDivergences, "reported", n2.effectReported)
+	}
+
+	n2.SetService(&effectService{lastApplied: n2.LastApplied(), apply: func(ent *Entry) string {
+		return ent.Data + "?"
+	}})
+	n1.Propose("data")
+	// reported on the ack of next heartbeat
+	run(50)
+	if n1.Metrics().ApplyDivergences == 0 {
+		t.Fatal("divergence not found")
+	}
+}
@@ -0,0 +1,142 @@
+package raft
+
+import (
+	"os"
+	"bufio"
+	"sync"
+	"time"
+	"encoding/json"
+)
+
+const(
+	AuditElection        = "election"        // this node started an election
+	AuditBecomeLeader    = "become_leader"
+	AuditLeaderChange    = "leader_change"   // follower sees a new leader
+	AuditAddMember       = "add_member"      // applied
+	AuditDelMember       = "del_member"      // applied
+	AuditSetPriority     = "set_priority"    // applied
+	AuditJoinGroup       = "join_group"      // Raft database cleaned
+	AuditQuitGroup       = "quit_group"
+	AuditInstallSnapshot = "install_snapshot"
+)
+
+type AuditRecord struct{
+	Time time.Time
+	Node string // node which recorded
+	Term int32
+	Action string
+	Detail string
+}
+
+// Append-only record of elections and membership changes
+type AuditLog interface{
+	Append(r *AuditRecord) error
+	// latest n records in time order, n <= 0 means all
+	Records(n int) ([]*AuditRecord, error)
+}
+
+// JSON line per record, fsync on every record. Thread safe.
+type FileAuditLog struct{
+	path string
+	fp *os.File
+	mux sync.Mutex
+}
+
+func OpenFileAuditLog(path string) (*FileAuditLog, error) {
+	fp, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	// terminate torn last record, so it won't corrupt the next one
+	if st, _ := fp.Stat(); st != nil && st.Size() > 0 {
+		last := make([]byte, 1)
+		rf, err := os.Open(path)
+		if err == nil {
+			rf.ReadAt(last, st.Size() - 1)
+			rf.Close()
+		}
+		if last[0] != '\n' {
+			fp.Write([]byte{'\n'})
+		}
+	}
+
+	a := new(FileAuditLog)
+	a.path = path
+	a.fp = fp
+	return a, nil
+}
+
+func (a *FileAuditLog)Close() {
+	a.fp.Close()
+}
+
+func (a *FileAuditLog)Append(r *AuditRecord) error {
+	bs, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	bs = append(bs, '\n')
+
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	if _, err := a.fp.Write(bs); err != nil {
+		return err
+	}
+	return a.fp.Sync()
+}
+
+func (a *FileAuditLog)Records(n int) ([]*AuditRecord, error) {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+
+	fp, err := os.Open(a.path)
+	if err != nil {
+		return nil, err
+	}
+	defer fp.Close()
+
+	ret := make([]*AuditRecord, 0)
+	scanner := bufio.NewScanner(fp)
+	for scanner.Scan() {
+		r := new(AuditRecord)
+		if json.Unmarshal(scanner.Bytes(), r) != nil {
+			// torn write
+			continue
+		}
+		ret = append(ret, r)
+		if n > 0 && len(ret) > n {
+			ret = ret[1:]
+		}
+	}
+	return ret, scanner.Err()
+}
+
+/* ############################################# */
+
+func (node *Node)SetAuditLog(a AuditLog){
+	node.mux.Lock()
+	defer node.mux.Unlock()
+	node.audit = a
+}
+
+func (node *Node)AuditRecords(n int) ([]*AuditRecord, error) {
+	node.mux.Lock()
+	a := node.audit
+	node.mux.Unlock()
+
+	if a == nil {
+		return []*AuditRecord{}, nil
+	}
+	return a.Records(n)
+}
+
+func (node *Node)recordAudit(action string, detail string){
+	node.log.Info("audit", "action", action, "term", node.Term, "detail", detail)
+	if node.audit == nil {
+		return
+	}
+	r := &AuditRecord{node.clock.Now(), node.Id, node.Term, action, detail}
+	if err := node.audit.Append(r); err != nil {
+		node.log.Error("append audit record error", "err", err)
+	}
+}
@@ -0,0 +1,38 @@
+package raft
+
+import (
+	"os"
+	"testing"
+)
+
+func TestFileAuditLog(t *testing.T){
+	path := t.TempDir() + "/audit.log"
+	a, err := OpenFileAuditLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.Append(&AuditRecord{Node: "n1", Term: 1, Action: AuditElection})
+	a.Append(&AuditRecord{Node: "n1", Term: 1, Action: AuditBecomeLeader})
+	a.Close()
+
+	// torn write is skipped, records survive reopen
+	fp, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	fp.WriteString("{\"Node\":")
+	fp.Close()
+
+	a, _ = OpenFileAuditLog(path)
+	defer a.Close()
+	rs, err := a.Records(0)
+	if err != nil || len(rs) != 2 || rs[1].Action != AuditBecomeLeader {
+		t.Fatal(rs, err)
+	}
+	a.Append(&AuditRecord{Node: "n1", Term: 2, Action: AuditElection})
+	rs, _ = a.Records(0)
+	if len(rs) != 3 || rs[2].Term != 2 {
+		t.Fatal(rs)
+	}
+	rs, _ = a.Records(1)
+	if len(rs) != 1 || rs[0].Term != 2 {
+		t.Fatal(rs)
+	}
+}
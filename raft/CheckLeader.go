@@ -0,0 +1,40 @@
+package raft
+
+// A member which heard from a live leader within the election timeout
+// denies PreVote and RequestVote, whatever the candidate's term, so a member
+// partitioned away and back, or flapping, does not disrupt a healthy group
+// by its greater term. Leader denies them while a majority is reachable.
+// Paired with PreVote: a member raises its term only by an election after a
+// majority granted PreVote, and PreVote does not raise the receiver's term,
+// so an isolated member keeps its term and follows leader when back. A
+// member which still got a greater term, e.g. a candidate cut off after
+// RequestVote was sent, rejects leader's AppendEntry with its term when
+// back, leader steps down and a new leader is elected.
+
+// with node's lock held, id of the live leader, self if leader, "" if none
+func (node *Node)activeLeader() string {
+	if node.Role == RoleLeader {
+		if node.quorumReceiveTimeout() < node.timeouts.Receive {
+			return node.Id
+		}
+		return ""
+	}
+	for _, m := range node.Members {
+		if m.Role == RoleLeader && m.ReceiveTimeout < node.timeouts.Election {
+			return m.Id
+		}
+	}
+	return ""
+}
+
+// with node's lock held, true if msg, a PreVote or RequestVote, is denied
+func (node *Node)checkLeader(msg *Message) bool {
+	leader := node.activeLeader()
+	if leader == "" || leader == msg.Src {
+		return false
+	}
+	node.votesDenied ++
+	node.log.Info("leader is still active, deny vote", "type", msg.Type, "leader", leader, "peer", msg.Src,
+		"msgTerm", msg.Term, "term", node.Term)
+	return true
+}
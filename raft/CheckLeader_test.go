@@ -0,0 +1,100 @@
+package raft
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fallowu/big-ssdb/logger"
+)
+
+// a member cut off from the leader, with a greater term, is not elected
+// while the leader is active
+func TestCheckLeader(t *testing.T){
+	logger.SetDefaultLevel(logger.LevelError)
+	s := newMemSim(t, 1, "n1", "n2", "n3")
+	s.net.SetDefault(MemLinkConfig{Delay: time.Millisecond})
+	n1, n2, n3 := s.nodes["n1"], s.nodes["n2"], s.nodes["n3"]
+	n1.AddMember("n1", "addr-n1")
+	n1.StepTick(0)
+	for _, id := range []string{"n2", "n3"} {
+		index, err := n1.AddMember(id, "addr-" + id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		node := s.nodes[id]
+		node.JoinGroup("n1", "addr-n1")
+		s.until(10 * time.Second, "join " + id, func() bool {
+			return node.Metrics().CommitIndex >= index
+		})
+	}
+	term := n1.Metrics().Term
+
+	s.net.Isolate("n3", "n1")
+	n3.mux.Lock()
+	n3.Term += 5
+	n3.startElection()
+	n3.mux.Unlock()
+	for i := 0; i < 300; i ++ {
+		s.step()
+	}
+	if s.leader("n1", "n2", "n3") != "n1" || n1.Metrics().Term != term || n2.Metrics().Term != term {
+		t.Fatal("disrupted", s.dump())
+	}
+	if n2.Metrics().VotesDenied == 0 || n3.Metrics().Role == RoleLeader {
+		t.Fatal("not denied", n2.Metrics().VotesDenied, s.dump())
+	}
+
+	// votes are granted once the leader is gone
+	s.net.Heal()
+	s.net.Isolate("n1", "n2", "n3")
+	s.until(30 * time.Second, "elect", func() bool {
+		return s.leader("n2", "n3") != ""
+	})
+}
+
+// an isolated member does not raise its term, leader stays when it is back
+func TestCheckLeaderRejoin(t *testing.T){
+	logger.SetDefaultLevel(logger.LevelError)
+	s := newMemSim(t, 1, "n1", "n2", "n3")
+	s.net.SetDefault(MemLinkConfig{Delay: time.Millisecond})
+	n1, n3 := s.nodes["n1"], s.nodes["n3"]
+	n1.AddMember("n1", "addr-n1")
+	n1.StepTick(0)
+	for _, id := range []string{"n2", "n3"} {
+		index, _ := n1.AddMember(id, "addr-" + id)
+		node := s.nodes[id]
+		node.JoinGroup("n1", "addr-n1")
+		s.until(10 * time.Second, "join " + id, func() bool {
+			return node.Metrics().CommitIndex >= index
+		})
+	}
+	term := n1.Metrics().Term
+
+	s.net.Isolate("n3", "n1", "n2")
+	// PreVote times out again and again
+	for i := 0; i < 1000; i ++ {
+		s.step()
+	}
+	if n3.Metrics().Term != term {
+		t.Fatal("isolated member raised term", s.dump())
+	}
+	_, index, err := n1.Propose("set k v")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.net.Heal()
+	for i := 0; i < 300; i ++ {
+		s.step()
+		if s.leader("n1", "n2", "n3") != "n1" {
+			t.Fatal("leader changed", s.dump())
+		}
+	}
+	if !s.converged(index) {
+		t.Fatal("not caught up", s.dump())
+	}
+	for _, node := range s.nodes {
+		if node.Metrics().Term != term {
+			t.Fatal("term raised", s.dump())
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package raft
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Source of time for Node and transports, replaced by ManualClock in
+// tests and simulation
+type Clock interface{
+	Now() time.Time
+	NewTicker(d time.Duration) Ticker
+	// f is called in its own goroutine, or by ManualClock.Advance()
+	AfterFunc(d time.Duration, f func()) Timer
+	Sleep(d time.Duration)
+}
+
+type Ticker interface{
+	C() <-chan time.Time
+	Stop()
+}
+
+type Timer interface{
+	// false if the timer already fired or was stopped
+	Stop() bool
+}
+
+type systemClock struct{}
+
+// Clock backed by package time
+var SystemClock Clock = systemClock{}
+
+func (systemClock)Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock)NewTicker(d time.Duration) Ticker {
+	return systemTicker{time.NewTicker(d)}
+}
+
+func (systemClock)AfterFunc(d time.Duration, f func()) Timer {
+	return time.AfterFunc(d, f)
+}
+
+func (systemClock)Sleep(d time.Duration) {
+	time.Sleep(d)
+}
+
+type systemTicker struct{
+	t *time.Ticker
+}
+
+func (t systemTicker)C() <-chan time.Time {
+	return t.t.C
+}
+
+func (t systemTicker)Stop() {
+	t.t.Stop()
+}
+
+/* ############################################# */
+
+// Clock whose time only moves on Advance(), or Sleep() which advances it
+// instantly. Thread safe.
+type ManualClock struct{
+	now time.Time
+	tickers []*manualTicker
+	timers []*manualTimer
+	mux sync.Mutex
+}
+
+func NewManualClock() *ManualClock {
+	c := new(ManualClock)
+	// fixed epoch, so that runs are reproducible
+	c.now = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	return c
+}
+
+func (c *ManualClock)Now() time.Time {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return c.now
+}
+
+// Move time forward, firing tickers and timers which are due. Like
+// time.Ticker, ticks are dropped when the receiver is not keeping up.
+// Timer funcs are called in caller's goroutine, in time order.
+func (c *ManualClock)Advance(d time.Duration) {
+	c.mux.Lock()
+	c.now = c.now.Add(d)
+	for _, t := range c.tickers {
+		if t.stopped {
+			continue
+		}
+		for !t.next.After(c.now) {
+			select {
+			case t.c <- t.next:
+			default:
+			}
+			t.next = t.next.Add(t.d)
+		}
+	}
+	var due []*manualTimer
+	var rest []*manualTimer
+	for _, t := range c.timers {
+		if t.stopped {
+			continue
+		}
+		if t.at.After(c.now) {
+			rest = append(rest, t)
+		} else {
+			t.stopped = true
+			due = append(due, t)
+		}
+	}
+	c.timers = rest
+	c.mux.Unlock()
+
+	sort.SliceStable(due, func(i, j int) bool {
+		return due[i].at.Before(due[j].at)
+	})
+	for _, t := range due {
+		t.f()
+	}
+}
+
+func (c *ManualClock)Sleep(d time.Duration) {
+	c.Advance(d)
+}
+
+func (c *ManualClock)NewTicker(d time.Duration) Ticker {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	t := &manualTicker{clock: c, d: d, next: c.now.Add(d), c: make(chan time.Time, 1)}
+	c.tickers = append(c.tickers, t)
+	return t
+}
+
+func (c *ManualClock)AfterFunc(d time.Duration, f func()) Timer {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	t := &manualTimer{clock: c, at: c.now.Add(d), f: f}
+	c.timers = append(c.timers, t)
+	return t
+}
+
+type manualTicker struct{
+	clock *ManualClock
+	d time.Duration
+	next time.Time
+	c chan time.Time
+	stopped bool
+}
+
+func (t *manualTicker)C() <-chan time.Time {
+	return t.c
+}
+
+func (t *manualTicker)Stop() {
+	t.clock.mux.Lock()
+	defer t.clock.mux.Unlock()
+	t.stopped = true
+}
+
+type manualTimer struct{
+	clock *ManualClock
+	at time.Time
+	f func()
+	stopped bool
+}
+
+func (t *manualTimer)Stop() bool {
+	t.clock.mux.Lock()
+	defer t.clock.mux.Unlock()
+	ret := !t.stopped
+	t.stopped = true
+	return ret
+}
@@ -0,0 +1,221 @@
+package raft
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/fallowu/big-ssdb/internal/util"
+)
+
+const(
+	ConsistencyCheckTimeout = 3 * 1000
+	// number of applied indexes whose state hash is remembered
+	MaxStateHashes = 1024
+)
+
+const(
+	// replied by a node whose Service is not a StateHasher
+	hashUnsupported = "unsupported"
+	// replied by a node which no longer remembers the hash at index
+	hashUnknown = "unknown"
+)
+
+// hash of a node is empty if the node did not reply before timeout. Nodes
+// in Unknown and Unsupported are not compared, all are in Unknown if leader's
+// hash is unknown or unsupported.
+type ConsistencyReport struct{
+	Index int64
+	Hashes map[string]string // nodeId => hash
+	Divergent []string       // nodes whose hash differs from leader's
+	Missing []string         // nodes not replying
+	Unknown []string         // nodes without the hash at index
+	Unsupported []string     // nodes whose Service is not a StateHasher
+}
+
+// Unsupported nodes are not counted, unknown ones are
+func (r *ConsistencyReport)Ok() bool {
+	return len(r.Divergent) == 0 && len(r.Missing) == 0 && len(r.Unknown) == 0
+}
+
+func (r *ConsistencyReport)Encode() string {
+	ids := make([]string, 0, len(r.Hashes))
+	for id, _ := range r.Hashes {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	var ret string
+	ret += fmt.Sprintf("index: %d\n", r.Index)
+	for _, id := range ids {
+		hash := r.Hashes[id]
+		if hash == "" {
+			hash = "(no reply)"
+		}
+		ret += fmt.Sprintf("%s: %s\n", id, hash)
+	}
+	ret += fmt.Sprintf("divergent: %s\n", strings.Join(r.Divergent, " "))
+	ret += fmt.Sprintf("missing: %s\n", strings.Join(r.Missing, " "))
+	ret += fmt.Sprintf("unknown: %s\n", strings.Join(r.Unknown, " "))
+	ret += fmt.Sprintf("unsupported: %s\n", strings.Join(r.Unsupported, " "))
+	return ret
+}
+
+type consistencyCheck struct{
+	index int64
+	timer int
+	hashes map[string]string
+	done chan *ConsistencyReport
+}
+
+// 记录 Service 在每一个 applied index 之后的状态 hash
+func (node *Node)recordStateHash(index int64, hash string){
+	node.stateHashes[index] = hash
+	node.stateHashIndexes = append(node.stateHashIndexes, index)
+	for len(node.stateHashIndexes) > MaxStateHashes {
+		delete(node.stateHashes, node.stateHashIndexes[0])
+		node.stateHashIndexes = node.stateHashIndexes[1:]
+	}
+
+	// reply delayed queries
+	for nodeId, idx := range node.hashQueries {
+		if idx == index {
+			delete(node.hashQueries, nodeId)
+			node.send(NewStateHashAck(nodeId, index, hash))
+		}
+	}
+	if node.check != nil && node.check.index == index {
+		node.check.hashes[node.Id] = hash
+		node.checkConsistencyResult()
+	}
+}
+
+// returns "" if hash of index not recorded
+func (node *Node)stateHashAt(index int64) string {
+	return node.stateHashes[index]
+}
+
+func (node *Node)serviceLastApplied() int64 {
+	return node.serviceApplied
+}
+
+func (node *Node)handleStateHash(msg *Message){
+	index := util.Atoi64(msg.Data)
+	if node.witness {
+		node.send(NewStateHashAck(msg.Src, index, witnessHash))
+		return
+	}
+	if _, ok := node.store.Service.(StateHasher); !ok {
+		node.send(NewStateHashAck(msg.Src, index, hashUnsupported))
+		return
+	}
+	if index > node.serviceLastApplied() {
+		// reply when index is applied
+		node.hashQueries[msg.Src] = index
+		return
+	}
+	hash := node.stateHashAt(index)
+	if hash == "" {
+		hash = hashUnknown
+	}
+	node.send(NewStateHashAck(msg.Src, index, hash))
+}
+
+func (node *Node)handleStateHashAck(msg *Message){
+	ps := strings.SplitN(msg.Data, " ", 2)
+	if len(ps) != 2 || node.check == nil || node.check.index != util.Atoi64(ps[0]) {
+		return
+	}
+	node.check.hashes[msg.Src] = ps[1]
+	node.checkConsistencyResult()
+}
+
+func (node *Node)checkConsistencyResult(){
+	if len(node.check.hashes) == len(node.Members) + 1 {
+		node.finishConsistencyCheck()
+	}
+}
+
+func (node *Node)finishConsistencyCheck(){
+	check := node.check
+	node.check = nil
+
+	report := new(ConsistencyReport)
+	report.Index = check.index
+	report.Hashes = make(map[string]string)
+	report.Divergent = make([]string, 0)
+	report.Missing = make([]string, 0)
+	report.Unknown = make([]string, 0)
+	report.Unsupported = make([]string, 0)
+
+	ids := []string{node.Id}
+	for _, m := range node.Members {
+		ids = append(ids, m.Id)
+	}
+	sort.Strings(ids)
+
+	leaderHash := check.hashes[node.Id]
+	for _, id := range ids {
+		hash := check.hashes[id]
+		report.Hashes[id] = hash
+		if hash == "" {
+			report.Missing = append(report.Missing, id)
+		} else if hash == hashUnsupported {
+			report.Unsupported = append(report.Unsupported, id)
+		} else if hash == hashUnknown || leaderHash == hashUnknown || leaderHash == hashUnsupported {
+			report.Unknown = append(report.Unknown, id)
+		} else if hash != leaderHash && hash != witnessHash {
+			report.Divergent = append(report.Divergent, id)
+		}
+	}
+	node.log.Info("consistency check finished", "index", report.Index,
+			"divergent", report.Divergent, "missing", report.Missing,
+			"unknown", report.Unknown, "unsupported", report.Unsupported)
+
+	check.done <- report
+}
+
+/* ###################### Operations ####################### */
+
+// Leader asks every member for the hash of its service state at index,
+// index <= 0 means the leader's current service lastApplied.
+// The report is delivered through the returned channel once all members
+// have replied, or on timeout, or when this node is no longer leader.
+func (node *Node)CheckConsistency(index int64) <-chan *ConsistencyReport {
+	node.mux.Lock()
+	defer node.mux.Unlock()
+
+	done := make(chan *ConsistencyReport, 1)
+	if node.Role != RoleLeader {
+		node.log.Warn("not leader")
+		close(done)
+		return done
+	}
+	if node.check != nil {
+		node.log.Warn("consistency check in progress")
+		close(done)
+		return done
+	}
+	if index <= 0 {
+		index = node.serviceLastApplied()
+	}
+
+	node.check = new(consistencyCheck)
+	node.check.index = index
+	node.check.hashes = make(map[string]string)
+	node.check.done = done
+
+	if _, ok := node.store.Service.(StateHasher); !ok {
+		node.check.hashes[node.Id] = hashUnsupported
+	} else if index <= node.serviceLastApplied() {
+		node.check.hashes[node.Id] = node.stateHashAt(index)
+		if node.check.hashes[node.Id] == "" {
+			node.check.hashes[node.Id] = hashUnknown
+		}
+	}
+	for _, m := range node.Members {
+		node.send(NewStateHashMsg(m.Id, index))
+	}
+	node.checkConsistencyResult()
+	return done
+}
@@ -0,0 +1,53 @@
+package raft
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/fallowu/big-ssdb/logger"
+)
+
+func TestCheckConsistency(t *testing.T){
+	logger.SetDefaultLevel(logger.LevelError)
+	node := NewNode("n1", "addr1", NewMemDb())
+	node.SetOutbox(func(msg *Message) {})
+	node.AddMember("n1", "addr1")
+	node.StepTick(0)
+	node.mux.Lock()
+	for _, id := range []string{"n2", "n3", "n4", "n5"} {
+		node.addMember(id, "addr-" + id)
+	}
+	node.mux.Unlock()
+
+	check := func(leaderHash string, hashes map[string]string) *ConsistencyReport {
+		done := node.CheckConsistency(1)
+		// as if the Service were a StateHasher
+		node.mux.Lock()
+		node.check.hashes[node.Id] = leaderHash
+		index := node.check.index
+		node.mux.Unlock()
+		for id, hash := range hashes {
+			ack := NewStateHashAck("n1", index, hash)
+			ack.Src = id
+			ack.Term = node.Term
+			node.StepMessage(ack)
+		}
+		return <-done
+	}
+
+	r := check("h1", map[string]string{"n2": "h1", "n3": "h2", "n4": "unsupported", "n5": "unknown"})
+	if r.Ok() || !reflect.DeepEqual(r.Divergent, []string{"n3"}) || len(r.Missing) != 0 ||
+		!reflect.DeepEqual(r.Unsupported, []string{"n4"}) || !reflect.DeepEqual(r.Unknown, []string{"n5"}) {
+		t.Fatal(r.Encode())
+	}
+	r = check("h1", map[string]string{"n2": "h1", "n3": "h1", "n4": "unsupported", "n5": witnessHash})
+	if !r.Ok() || !reflect.DeepEqual(r.Unsupported, []string{"n4"}) {
+		t.Fatal(r.Encode())
+	}
+	// nothing to compare with
+	r = check("unsupported", map[string]string{"n2": "h1", "n3": "h2", "n4": "unsupported", "n5": "h1"})
+	if r.Ok() || len(r.Divergent) != 0 || !reflect.DeepEqual(r.Unsupported, []string{"n1", "n4"}) ||
+		!reflect.DeepEqual(r.Unknown, []string{"n2", "n3", "n5"}) {
+		t.Fatal(r.Encode())
+	}
+}
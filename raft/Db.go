@@ -0,0 +1,112 @@
+package raft
+
+// Where Storage keeps state and, without LogDb, entries. store.KVStore, or
+// one opened by store.OpenDb().
+type Db interface {
+	Close()
+	// An error halts the node, see Fault.go
+	Fsync() error
+	// "" if key is absent
+	Get(key string) string
+	Set(key string, val string)
+	Delete(key string)
+	// Keys in [start, end) in ascending order, "" end means no upper bound.
+	// fn returns false to stop.
+	Iterate(start string, end string, fn func(key string, val string) bool)
+	// Writes of b in order, as one call
+	WriteBatch(b *Batch)
+	// Bytes of keys and values in [start, end), may be estimated
+	ApproximateSize(start string, end string) int64
+	// errors are returned by the next Fsync()
+	CleanAll()
+}
+
+// Cursor over keys in [start, end) in ascending order, see NewIterator()
+type Iterator interface{
+	// moves to the first, then the next key, false at the end
+	Next() bool
+	Key() string
+	Val() string
+	// error of the scan, if any
+	Close() error
+}
+
+// Optional, implemented by Db whose data set is not in memory
+type IteratorDb interface{
+	NewIterator(start string, end string) Iterator
+}
+
+// An iterator of db, over keys read by Iterate() if db is not an IteratorDb
+func NewIterator(db Db, start string, end string) Iterator {
+	if idb, ok := db.(IteratorDb); ok {
+		return idb.NewIterator(start, end)
+	}
+	it := &sliceIterator{pos: -1}
+	db.Iterate(start, end, func(key string, val string) bool {
+		it.kvs = append(it.kvs, [2]string{key, val})
+		return true
+	})
+	return it
+}
+
+type sliceIterator struct{
+	kvs [][2]string
+	pos int
+}
+
+func (it *sliceIterator)Next() bool {
+	if it.pos < len(it.kvs) {
+		it.pos ++
+	}
+	return it.pos < len(it.kvs)
+}
+
+func (it *sliceIterator)Key() string {
+	return it.kvs[it.pos][0]
+}
+
+func (it *sliceIterator)Val() string {
+	return it.kvs[it.pos][1]
+}
+
+func (it *sliceIterator)Close() error {
+	return nil
+}
+
+type BatchOp struct{
+	Key string
+	Val string
+	Delete bool
+}
+
+type Batch struct{
+	Ops []BatchOp
+}
+
+func NewBatch() *Batch {
+	return new(Batch)
+}
+
+func (b *Batch)Set(key string, val string) {
+	b.Ops = append(b.Ops, BatchOp{Key: key, Val: val})
+}
+
+func (b *Batch)Delete(key string) {
+	b.Ops = append(b.Ops, BatchOp{Key: key, Delete: true})
+}
+
+func (b *Batch)Len() int {
+	return len(b.Ops)
+}
+
+// End of Iterate() range of keys with prefix
+func PrefixEnd(prefix string) string {
+	bs := []byte(prefix)
+	for i := len(bs) - 1; i >= 0; i -- {
+		if bs[i] < 0xff {
+			bs[i] ++
+			return string(bs[0 : i+1])
+		}
+	}
+	return ""
+}
@@ -0,0 +1,89 @@
+package raft
+
+import (
+	"fmt"
+)
+
+const(
+	// ms between two disk usage checks
+	DiskCheckInterval = 1000
+	// leave read-only mode when free space is above MinFreeBytes by this percent
+	DiskFreeHysteresis = 10
+)
+
+type DiskUsage struct{
+	Total uint64
+	Free uint64 // available to unprivileged user
+}
+
+// Returned(by ReadOnly()) when proposals are rejected because the data
+// directory is low on free space.
+type ReadOnlyError struct{
+	Dir string
+	Free uint64
+	MinFree uint64
+}
+
+func (e *ReadOnlyError)Error() string {
+	return fmt.Sprintf("read-only: %s has %d bytes free, below %d", e.Dir, e.Free, e.MinFree)
+}
+
+// Usage of the data directory is read by f instead of GetDiskUsage(), e.g.
+// a fake one in tests
+func WithDiskUsage(f func(dir string) (DiskUsage, error)) Option {
+	return func(opts *options) {
+		opts.diskUsage = f
+	}
+}
+
+// Watch free space of dir, reject proposals when it falls below minFree bytes,
+// minFree == 0 only exposes usage.
+func (node *Node)SetDataDir(dir string, minFree uint64){
+	node.mux.Lock()
+	defer node.unlock()
+	node.dataDir = dir
+	node.MinFreeBytes = minFree
+	node.checkDisk()
+}
+
+// nil if proposals are accepted
+func (node *Node)ReadOnly() error {
+	node.mux.Lock()
+	defer node.mux.Unlock()
+	if node.readOnly == nil {
+		return nil
+	}
+	return node.readOnly
+}
+
+func (node *Node)checkDisk(){
+	node.diskTimer = 0
+	if node.dataDir == "" {
+		return
+	}
+	usage, err := node.getDiskUsage(node.dataDir)
+	if err != nil {
+		node.log.Warn("get disk usage error", "dir", node.dataDir, "err", err)
+		return
+	}
+	node.diskUsage = usage
+
+	if node.MinFreeBytes == 0 {
+		node.readOnly = nil
+		return
+	}
+	if node.readOnly == nil {
+		if usage.Free < node.MinFreeBytes {
+			node.readOnly = &ReadOnlyError{node.dataDir, usage.Free, node.MinFreeBytes}
+			node.log.Error("low disk space, enter read-only mode", "free", usage.Free, "min", node.MinFreeBytes)
+			node.emitEvent(SinkReadOnly, "", 0, node.readOnly.Error())
+		}
+	} else {
+		node.readOnly.Free = usage.Free
+		if usage.Free >= node.MinFreeBytes + node.MinFreeBytes / 100 * DiskFreeHysteresis {
+			node.readOnly = nil
+			node.log.Info("disk space recovered, leave read-only mode", "free", usage.Free)
+			node.emitEvent(SinkReadOnly, "", 0, "writable")
+		}
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !unix
+
+package raft
+
+import (
+	"errors"
+)
+
+func GetDiskUsage(dir string) (DiskUsage, error) {
+	return DiskUsage{}, errors.New("disk usage not supported")
+}
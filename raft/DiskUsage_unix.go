@@ -0,0 +1,15 @@
+//go:build unix
+
+package raft
+
+import (
+	"syscall"
+)
+
+func GetDiskUsage(dir string) (DiskUsage, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return DiskUsage{}, err
+	}
+	return DiskUsage{uint64(st.Blocks) * uint64(st.Bsize), uint64(st.Bavail) * uint64(st.Bsize)}, nil
+}
@@ -0,0 +1,217 @@
+package raft
+
+import (
+	"encoding/base64"
+	"errors"
+	"hash/crc32"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// Data, the last field, may hold spaces. Data with bytes which the line and
+// JSON based formats can't carry(CR, LF, other control bytes but tab,
+// invalid UTF-8) is base64 encoded, marked by a '!' after the type. Data
+// encoded before is never marked and decoded as is.
+const dataEncodedMark = '!'
+
+var errBadFormat = errors.New("bad format")
+
+func dataSafe(s string) bool {
+	for i := 0; i < len(s); i ++ {
+		if c := s[i]; (c < 0x20 && c != '\t') || c == 0x7f {
+			return false
+		} else if c >= utf8.RuneSelf {
+			return utf8.ValidString(s[i:])
+		}
+	}
+	return true
+}
+
+func appendData(buf []byte, data string, safe bool) []byte {
+	if safe {
+		return append(buf, data...)
+	}
+	n := len(buf)
+	buf = append(buf, make([]byte, base64.StdEncoding.EncodedLen(len(data)))...)
+	base64.StdEncoding.Encode(buf[n:], []byte(data))
+	return buf
+}
+
+// Encoded messages and entries end their type field with "#<crc>", CRC-32C
+// in 8 hex digits of the encoding without it, e.g. "3 4 3 Data#e3069283 v".
+// Those of old versions have no checksum and are not verified: such entries
+// are accepted only before Storage's checksum boundary(see LogChecksum.go),
+// such messages are counted by Metrics.MessagesUnverified.
+const (
+	checksumMark = '#'
+	checksumLen = 9
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// crc32.Update() of a string, without converting it to []byte
+func crcString(crc uint32, s string) uint32 {
+	crc = ^crc
+	for i := 0; i < len(s); i ++ {
+		crc = crcTable[byte(crc) ^ s[i]] ^ (crc >> 8)
+	}
+	return ^crc
+}
+
+// reserves the checksum at the end of buf, see putChecksum()
+func appendChecksum(buf []byte) ([]byte, int) {
+	return append(buf, "#00000000"...), len(buf)
+}
+
+// writes the checksum of buf[start:] reserved at pos
+func putChecksum(buf []byte, start int, pos int) {
+	crc := crc32.Update(0, crcTable, buf[start:pos])
+	crc = crc32.Update(crc, crcTable, buf[pos + checksumLen:])
+	const digits = "0123456789abcdef"
+	for i := checksumLen - 1; i > 0; i -- {
+		buf[pos + i] = digits[crc & 0xf]
+		crc >>= 4
+	}
+}
+
+// The field buf[start:end] without the checksum it ends with, the checksum is
+// verified against the rest of buf. ErrCorrupted on mismatch, verified is
+// false if there is no checksum.
+func stripChecksum(buf string, start int, end int) (field string, verified bool, err error) {
+	pos := end - checksumLen
+	if pos < start || buf[pos] != checksumMark {
+		return buf[start:end], false, nil
+	}
+	want, err := strconv.ParseUint(buf[pos+1:end], 16, 32)
+	if err != nil {
+		return "", false, errBadFormat
+	}
+	crc := crcString(crcString(0, buf[:pos]), buf[end:])
+	if crc != uint32(want) {
+		return "", false, ErrCorrupted
+	}
+	return buf[start:pos], true, nil
+}
+
+// type and data of a decoded message or entry
+func decodeData(type_ string, data string) (string, string, bool) {
+	if !strings.HasSuffix(type_, string(dataEncodedMark)) {
+		return type_, data, true
+	}
+	bs, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return "", "", false
+	}
+	return type_[:len(type_) - 1], string(bs), true
+}
+
+// buffers larger than this are not returned to the pool
+const maxPooledEncodeBuf = 64 * 1024
+
+var encodeBufs = sync.Pool{
+	New: func() interface{} {
+		b := make([]byte, 0, 512)
+		return &b
+	},
+}
+
+func getEncodeBuf() *[]byte {
+	b := encodeBufs.Get().(*[]byte)
+	*b = (*b)[:0]
+	return b
+}
+
+func putEncodeBuf(b *[]byte){
+	if cap(*b) <= maxPooledEncodeBuf {
+		encodeBufs.Put(b)
+	}
+}
+
+// Same as Encode(), appended to buf, no allocation if buf is large enough
+func (m *Message)AppendEncode(buf []byte) []byte {
+	safe := dataSafe(m.Data)
+	start := len(buf)
+	buf = append(buf, m.Type...)
+	if !safe {
+		buf = append(buf, dataEncodedMark)
+	}
+	buf, pos := appendChecksum(buf)
+	if m.TraceId != "" {
+		buf = append(buf, '@')
+		buf = append(buf, m.TraceId...)
+	}
+	buf = append(buf, ' ')
+	buf = append(buf, m.Src...)
+	buf = append(buf, ' ')
+	buf = append(buf, m.Dst...)
+	buf = append(buf, ' ')
+	buf = strconv.AppendInt(buf, int64(m.Term), 10)
+	buf = append(buf, ' ')
+	buf = strconv.AppendInt(buf, int64(m.PrevTerm), 10)
+	buf = append(buf, ' ')
+	buf = strconv.AppendInt(buf, m.PrevIndex, 10)
+	buf = append(buf, ' ')
+	buf = appendData(buf, m.Data, safe)
+	putChecksum(buf, start, pos)
+	return buf
+}
+
+// Same as Encode(), appended to buf, no allocation if buf is large enough
+func (e *Entry)AppendEncode(buf []byte) []byte {
+	safe := dataSafe(e.Data)
+	start := len(buf)
+	buf = strconv.AppendInt(buf, int64(e.Term), 10)
+	buf = append(buf, ' ')
+	buf = strconv.AppendInt(buf, e.Index, 10)
+	buf = append(buf, ' ')
+	buf = strconv.AppendInt(buf, e.Commit, 10)
+	buf = append(buf, ' ')
+	buf = append(buf, e.Type...)
+	if !safe {
+		buf = append(buf, dataEncodedMark)
+	}
+	buf, pos := appendChecksum(buf)
+	buf = append(buf, ' ')
+	buf = appendData(buf, e.Data, safe)
+	putChecksum(buf, start, pos)
+	return buf
+}
+
+// strings.SplitN(s, " ", len(ps)) into ps without allocating, false if
+// there are fewer fields
+func splitFields(s string, ps []string) bool {
+	for i := 0; i < len(ps) - 1; i ++ {
+		j := strings.IndexByte(s, ' ')
+		if j < 0 {
+			return false
+		}
+		ps[i] = s[:j]
+		s = s[j+1:]
+	}
+	ps[len(ps) - 1] = s
+	return true
+}
+
+var entryPool = sync.Pool{
+	New: func() interface{} {
+		return new(Entry)
+	},
+}
+
+// Entry decoded from buf, to be returned with putEntry() when it is not
+// referenced any more. nil if buf is bad
+func decodePooledEntry(buf string) *Entry {
+	ent := entryPool.Get().(*Entry)
+	if !ent.Decode(buf) {
+		putEntry(ent)
+		return nil
+	}
+	return ent
+}
+
+func putEntry(ent *Entry){
+	*ent = Entry{}
+	entryPool.Put(ent)
+}
@@ -0,0 +1,105 @@
+package raft
+
+import (
+	"strings"
+	"strconv"
+)
+
+type EntryType string
+
+const(
+	EntryTypePing      = "Ping"
+	EntryTypeNoop      = "Noop"
+	EntryTypeData      = "Data"
+	EntryTypeAddMember = "AddMember"
+	EntryTypeDelMember = "DelMember"
+	// "<id> <priority>", see Priority.go
+	EntryTypeSetPriority = "SetPriority"
+)
+
+// Indexes above are rejected by decoders, so that index arithmetic never overflows
+const MaxIndex int64 = 1 << 62
+
+type Entry struct{
+	Term int32
+	Index int64
+	Commit int64
+	Type EntryType
+	Data string
+}
+
+func DecodeEntry(buf string) *Entry{
+	m := new(Entry);
+	if m.Decode(buf) {
+		return m
+	} else {
+		return nil
+	}
+}
+
+func (e *Entry)Encode() string{
+	b := getEncodeBuf()
+	*b = e.AppendEncode(*b)
+	s := string(*b)
+	putEncodeBuf(b)
+	return s
+}
+
+func (e *Entry)Decode(buf string) bool{
+	return e.decode(buf) == nil
+}
+
+// ErrCorrupted if the checksum mismatches
+func (e *Entry)decode(buf string) error {
+	_, err := e.decodeVerified(buf)
+	return err
+}
+
+// verified is false if buf has no checksum, see Encode.go
+func (e *Entry)decodeVerified(buf string) (verified bool, err error) {
+	buf = strings.Trim(buf, "\r\n")
+	var ps [5]string
+	if !splitFields(buf, ps[:]) {
+		return false, errBadFormat
+	}
+
+	var ok1, ok2, ok3 bool
+	e.Term, ok1 = parseTerm(ps[0])
+	e.Index, ok2 = parseIndex(ps[1])
+	e.Commit, ok3 = parseIndex(ps[2])
+	if !ok1 || !ok2 || !ok3 {
+		return false, errBadFormat
+	}
+	start := len(ps[0]) + len(ps[1]) + len(ps[2]) + 3
+	type_, verified, err := stripChecksum(buf, start, start + len(ps[3]))
+	if err != nil {
+		return false, err
+	}
+	type_, data, ok := decodeData(type_, ps[4])
+	if !ok || type_ == "" {
+		return false, errBadFormat
+	}
+	e.Type = EntryType(type_)
+	e.Data = data
+	return verified, nil
+}
+
+// Unlike util.Atoi32, garbage, negative and out of range numbers are errors
+func parseTerm(s string) (int32, bool) {
+	n, err := strconv.ParseInt(s, 10, 32)
+	return int32(n), err == nil && n >= 0
+}
+
+func parseIndex(s string) (int64, bool) {
+	n, err := strconv.ParseInt(s, 10, 64)
+	return n, err == nil && n >= 0 && n <= MaxIndex
+}
+
+func NewPingEntry(commitIndex int64) *Entry{
+	ent := new(Entry)
+	ent.Type = EntryTypePing
+	ent.Term = 0
+	ent.Index = 0
+	ent.Commit = commitIndex
+	return ent
+}
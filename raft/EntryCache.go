@@ -0,0 +1,111 @@
+package raft
+
+import (
+	"container/list"
+)
+
+// Entries of Storage in memory. Those written to db are evicted in LRU
+// order once their bytes exceed the budget, and loaded again by GetEntry().
+// Pinned ones, cached in holes of a follower's log and not written yet, are
+// never evicted.
+
+const (
+	DefaultEntryCacheSize = 64 * 1024 * 1024
+	// counted for each entry besides Data
+	entryOverhead = 64
+)
+
+type entryCache struct{
+	// bytes of unpinned entries kept, <= 0 for no limit
+	budget int64
+	// of unpinned entries
+	bytes int64
+	items map[int64]*cacheItem
+	// front is the most recently used
+	lru list.List
+	misses int64
+	evictions int64
+}
+
+type cacheItem struct{
+	ent *Entry
+	// nil if pinned
+	elem *list.Element
+}
+
+func newEntryCache(budget int64) *entryCache {
+	c := new(entryCache)
+	c.budget = budget
+	c.items = make(map[int64]*cacheItem)
+	return c
+}
+
+func entrySize(ent *Entry) int64 {
+	return int64(len(ent.Data) + entryOverhead)
+}
+
+func (c *entryCache)get(index int64) *Entry {
+	item := c.items[index]
+	if item == nil {
+		return nil
+	}
+	if item.elem != nil {
+		c.lru.MoveToFront(item.elem)
+	}
+	return item.ent
+}
+
+// replaces the entry of the same index
+func (c *entryCache)put(ent *Entry, pinned bool) {
+	c.remove(ent.Index)
+	item := &cacheItem{ent: ent}
+	c.items[ent.Index] = item
+	if !pinned {
+		item.elem = c.lru.PushFront(item)
+		c.bytes += entrySize(ent)
+		c.evict()
+	}
+}
+
+// entry of index is written
+func (c *entryCache)unpin(index int64) {
+	if item := c.items[index]; item != nil && item.elem == nil {
+		item.elem = c.lru.PushFront(item)
+		c.bytes += entrySize(item.ent)
+		c.evict()
+	}
+}
+
+func (c *entryCache)remove(index int64) {
+	item := c.items[index]
+	if item == nil {
+		return
+	}
+	delete(c.items, index)
+	if item.elem != nil {
+		c.lru.Remove(item.elem)
+		c.bytes -= entrySize(item.ent)
+	}
+}
+
+// fn may remove the entry
+func (c *entryCache)each(fn func(ent *Entry)) {
+	for _, item := range c.items {
+		fn(item.ent)
+	}
+}
+
+func (c *entryCache)reset() {
+	c.items = make(map[int64]*cacheItem)
+	c.lru.Init()
+	c.bytes = 0
+}
+
+func (c *entryCache)evict() {
+	for c.budget > 0 && c.bytes > c.budget && c.lru.Len() > 1 {
+		item := c.lru.Remove(c.lru.Back()).(*cacheItem)
+		delete(c.items, item.ent.Index)
+		c.bytes -= entrySize(item.ent)
+		c.evictions ++
+	}
+}
@@ -0,0 +1,62 @@
+package raft
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/fallowu/big-ssdb/logger"
+)
+
+func TestEntryCache(t *testing.T){
+	c := newEntryCache(3 * (entryOverhead + 2))
+	for i := int64(1); i <= 5; i ++ {
+		c.put(&Entry{Index: i, Data: "d" + fmt.Sprint(i)}, false)
+	}
+	// pinned ones are kept beyond the budget
+	c.put(&Entry{Index: 9, Data: "d9"}, true)
+	if c.get(1) != nil || c.get(2) != nil || c.get(3) == nil || c.get(9) == nil {
+		t.Fatal("evict", c.items)
+	}
+	// 3 is used, 4 is the least recently used
+	c.put(&Entry{Index: 6, Data: "d6"}, false)
+	if c.get(4) != nil || c.get(3) == nil || c.bytes != 3 * (entryOverhead + 2) || c.evictions != 3 {
+		t.Fatal("lru", c.items, c.bytes, c.evictions)
+	}
+	c.unpin(9)
+	if c.get(9) == nil || c.get(5) != nil || c.lru.Len() != 3 {
+		t.Fatal("unpin", c.lru.Len())
+	}
+	c.put(&Entry{Index: 7, Data: "d7"}, false)
+	if len(c.items) != 3 || c.get(9) == nil || c.get(6) != nil {
+		t.Fatal("evict unpinned", c.items)
+	}
+}
+
+// entries are loaded again once evicted, those in holes are kept
+func TestEntryCacheStorage(t *testing.T){
+	logger.SetDefaultLevel(logger.LevelError)
+	for seed := int64(1); seed <= 100; seed ++ {
+		r := rand.New(rand.NewSource(seed))
+		log := randomLog(r, 1 + r.Intn(30))
+		node := NewNode("n1", "addr1", NewMemDb(), WithEntryCacheSize(1))
+		node.Term = 1000
+		st := node.store
+		deliveries := append([]Entry{}, log...)
+		r.Shuffle(len(deliveries), func(i, j int) {
+			deliveries[i], deliveries[j] = deliveries[j], deliveries[i]
+		})
+		for _, ent := range deliveries {
+			st.WriteEntry(ent)
+			checkStorage(t, st, seed)
+			if st.entries.lru.Len() > 1 {
+				t.Fatalf("seed %d: %d cached", seed, st.entries.lru.Len())
+			}
+		}
+		st.CommitEntry(int64(len(log)))
+		sameStorage(t, st, inOrder(log, int64(len(log))), seed)
+		if len(log) > 1 && st.entries.misses == 0 {
+			t.Fatalf("seed %d: no miss", seed)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package raft
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// Returned by Propose(), AddMember() and DelMember(), compare with
+// errors.Is(), see also ReadOnlyError
+var(
+	// with a leader hint, see NotLeaderError
+	ErrNotLeader = errors.New("not leader")
+	// leader hasn't heard from a majority within ReceiveTimeout
+	ErrNoQuorum = errors.New("no quorum")
+	// the last AddMember()/DelMember() is not committed yet
+	ErrConfigChangeInProgress = errors.New("config change in progress")
+	// SetPriority() of a witness, which is the lowest, see Witness.go
+	ErrWitness = errors.New("witness")
+	// Close() is called
+	ErrShuttingDown = errors.New("shutting down")
+	// another entry is committed at the index, the proposal never will be
+	ErrProposalDropped = errors.New("proposal dropped")
+	// see ProposeAndWait(), Service installed a snapshot, or is replaced,
+	// instead of applying the entry
+	ErrApplyResultLost = errors.New("apply result lost")
+	// see ProposeAndWait(), the entry may or may not be committed
+	ErrLeadershipLost = errors.New("leadership lost")
+	// see AdmissionConfig, with a reason, see BusyError
+	ErrBusy = errors.New("busy")
+	// StateMachine can't be saved to or restored from a snapshot
+	ErrSnapshotUnsupported = errors.New("snapshot not supported")
+	// the node is halted by a storage failure, see Err()
+	ErrStopped = errors.New("stopped")
+	// the entry is no longer in the log, replaced by a snapshot
+	ErrCompacted = errors.New("entry compacted")
+	// see ProposeWait(), also Is(context.DeadlineExceeded)
+	ErrTimeout = fmt.Errorf("timeout: %w", context.DeadlineExceeded)
+)
+
+// A message or entry fails its checksum, see Encode.go. Corrupted messages
+// are dropped by transports, counted by Metrics.MessagesCorrupted, a
+// corrupted committed entry in Db halts the node.
+var ErrCorrupted = errors.New("checksum mismatch")
+
+// Leader as known by the node, "" if unknown
+type NotLeaderError struct{
+	Leader string
+	LeaderAddr string
+}
+
+// same as ErrNotLeader, for clients matching the message
+func (e *NotLeaderError)Error() string {
+	return ErrNotLeader.Error()
+}
+
+func (e *NotLeaderError)Is(target error) bool {
+	return target == ErrNotLeader
+}
+
+// with node's lock held
+func (node *Node)notLeaderError() error {
+	e := &NotLeaderError{Leader: node.leaderId()}
+	if m := node.Members[e.Leader]; m != nil {
+		e.LeaderAddr = m.Addr
+	}
+	return e
+}
+
+// with node's lock held, nil if entries can be appended by the leader
+func (node *Node)checkWritable() error {
+	if node.closed {
+		return ErrShuttingDown
+	}
+	if err := node.haltedError(); err != nil {
+		return err
+	}
+	if node.Role != RoleLeader {
+		return node.notLeaderError()
+	}
+	if !node.quorumReachable() {
+		return ErrNoQuorum
+	}
+	return nil
+}
+
+// same as quorumReceiveTimeout() < node.timeouts.Receive, without allocation
+func (node *Node)quorumReachable() bool {
+	n := 1 // self
+	for _, m := range node.Members {
+		if m.ReceiveTimeout < node.timeouts.Receive {
+			n ++
+		}
+	}
+	return n > (len(node.Members) + 1) / 2
+}
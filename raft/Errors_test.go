@@ -0,0 +1,50 @@
+package raft
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/fallowu/big-ssdb/logger"
+)
+
+func TestTypedErrors(t *testing.T){
+	logger.SetDefaultLevel(logger.LevelError)
+	n1 := NewNode("n1", "addr1", NewMemDb())
+	n1.SetOutbox(func(msg *Message) {})
+	n1.AddMember("n1", "addr1")
+	n1.StepTick(0)
+	if _, err := n1.AddMember("n2", "addr2"); err != nil {
+		t.Fatal("err", err)
+	}
+	n1.StepTick(0)
+	// committed by n1 alone, n2 never acks the next
+	if _, err := n1.AddMember("n3", "addr3"); err != nil {
+		t.Fatal("err", err)
+	}
+	if _, err := n1.AddMember("n4", "addr4"); err != ErrConfigChangeInProgress {
+		t.Fatal("err", err)
+	}
+	for _, m := range n1.Members {
+		m.ReceiveTimeout = ReceiveTimeout
+	}
+	if _, _, err := n1.Propose("data"); err != ErrNoQuorum {
+		t.Fatal("err", err)
+	}
+
+	n2 := NewNode("n2", "addr2", NewMemDb())
+	n2.addMember("n1", "addr1")
+	n2.Members["n1"].Role = RoleLeader
+	_, _, err := n2.Propose("data")
+	var nle *NotLeaderError
+	if !errors.Is(err, ErrNotLeader) || !errors.As(err, &nle) || nle.Leader != "n1" || nle.LeaderAddr != "addr1" {
+		t.Fatal("err", err)
+	}
+	if _, err := n2.DelMember("n1"); !errors.Is(err, ErrNotLeader) {
+		t.Fatal("err", err)
+	}
+
+	n1.Close()
+	if _, _, err := n1.Propose("data"); err != ErrShuttingDown {
+		t.Fatal("err", err)
+	}
+}
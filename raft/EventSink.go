@@ -0,0 +1,89 @@
+package raft
+
+import (
+	"time"
+)
+
+const(
+	SinkElectionStarted   = "election_started"
+	SinkElectionWon       = "election_won"
+	SinkLeaderChanged     = "leader_changed"   // follower sees a new leader
+	SinkSnapshotSent      = "snapshot_sent"
+	SinkSnapshotInstalled = "snapshot_installed"
+	SinkMemberLagging     = "member_lagging"   // behind leader for MemberLagTimeout
+	SinkMemberCaughtUp    = "member_caught_up"
+	SinkConfigChange      = "config_change"    // member added or removed, applied
+	SinkReadOnly          = "read_only"        // entered or left read-only mode
+	SinkInvariantViolated = "invariant_violated"
+	SinkApplyDiverged     = "apply_diverged"   // follower applied entries differently, see SetApplyCheck()
+	SinkStorageFailed     = "storage_failed"   // node halted, see Fault.go
+)
+
+const(
+	// ms a member may be behind leader before SinkMemberLagging
+	MemberLagTimeout = ReceiveTimeout
+	// events queued for sinks, newer events are dropped when full
+	DefaultEventSinkQueue = 1024
+)
+
+type SinkEvent struct{
+	Time time.Time
+	Node string // node which emitted
+	Term int32
+	Type string
+	Peer string  // member concerned, if any
+	Index int64
+	Detail string
+}
+
+// Receives structured events for external monitoring, e.g. forwarding them
+// to an alerting system. Called from a single goroutine, never under raft's
+// lock, a slow sink only delays other sinks.
+type EventSink interface{
+	HandleEvent(ev *SinkEvent)
+}
+
+func (node *Node)AddEventSink(sink EventSink){
+	node.mux.Lock()
+	defer node.mux.Unlock()
+
+	node.sinks = append(node.sinks, sink)
+	if node.sinkC == nil {
+		node.sinkC = make(chan *SinkEvent, DefaultEventSinkQueue)
+		go node.dispatchSinkEvents(node.sinkC)
+	}
+}
+
+func (node *Node)dispatchSinkEvents(c chan *SinkEvent){
+	for ev := range c {
+		node.mux.Lock()
+		sinks := node.sinks
+		node.mux.Unlock()
+
+		for _, sink := range sinks {
+			sink.HandleEvent(ev)
+		}
+	}
+}
+
+func (node *Node)emitEvent(type_ string, peer string, index int64, detail string){
+	if node.sinkC == nil {
+		return
+	}
+	ev := &SinkEvent{node.clock.Now(), node.Id, node.Term, type_, peer, index, detail}
+	select {
+	case node.sinkC <- ev:
+	default:
+		node.log.Warn("event sink queue full, drop event", "type", type_)
+	}
+}
+
+func (node *Node)checkMemberLag(m *Member){
+	if !m.lagging && m.BehindTimer >= MemberLagTimeout {
+		m.lagging = true
+		node.emitEvent(SinkMemberLagging, m.Id, m.MatchIndex, "")
+	} else if m.lagging && m.BehindTimer == 0 {
+		m.lagging = false
+		node.emitEvent(SinkMemberCaughtUp, m.Id, m.MatchIndex, "")
+	}
+}
@@ -0,0 +1,43 @@
+package raft
+
+import (
+	"testing"
+	"time"
+)
+
+type chanSink chan *SinkEvent
+
+func (c chanSink)HandleEvent(ev *SinkEvent){
+	c <- ev
+}
+
+func TestEventSink(t *testing.T){
+	node := new(Node)
+	node.Id = "n1"
+	node.clock = SystemClock
+	c := make(chanSink, 10)
+	node.AddEventSink(c)
+
+	m := NewMember("n2", "addr2")
+	m.BehindTimer = MemberLagTimeout
+	node.checkMemberLag(m)
+	node.checkMemberLag(m)
+	m.BehindTimer = 0
+	node.checkMemberLag(m)
+
+	for _, type_ := range []string{SinkMemberLagging, SinkMemberCaughtUp} {
+		select {
+		case ev := <-c:
+			if ev.Type != type_ || ev.Peer != "n2" || ev.Node != "n1" {
+				t.Fatal("bad event", ev)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("event not received", type_)
+		}
+	}
+	select {
+	case ev := <-c:
+		t.Fatal("unexpected event", ev)
+	case <-time.After(10 * time.Millisecond):
+	}
+}
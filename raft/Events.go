@@ -0,0 +1,80 @@
+package raft
+
+import (
+	"sync"
+	"time"
+)
+
+type EventType string
+
+const(
+	EventTypeMessage  = "message"  // message handled
+	EventTypeRole     = "role"     // role changed
+	EventTypeCommit   = "commit"   // commit index advanced
+	EventTypeTruncate = "truncate" // conflicting entries deleted from index
+)
+
+const DefaultEventRingSize = 256
+
+type Event struct{
+	Time time.Time
+	Type EventType
+	Term int32
+	Peer string  // message src
+	Index int64  // message PrevIndex, new commit index, or truncated from
+	Detail string // message type, or new role
+}
+
+// Bounded buffer of the latest events, oldest events are overwritten. Thread safe.
+type EventRing struct{
+	events []Event
+	next int
+	full bool
+	mux sync.Mutex
+}
+
+func NewEventRing(size int) *EventRing {
+	r := new(EventRing)
+	r.events = make([]Event, size)
+	return r
+}
+
+func (r *EventRing)Add(ev Event) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	if len(r.events) == 0 {
+		return
+	}
+	r.events[r.next] = ev
+	r.next ++
+	if r.next == len(r.events) {
+		r.next = 0
+		r.full = true
+	}
+}
+
+// Latest n events in time order, n <= 0 means all
+func (r *EventRing)Last(n int) []Event {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	var ret []Event
+	if r.full {
+		ret = append(ret, r.events[r.next:]...)
+	}
+	ret = append(ret, r.events[:r.next]...)
+	if n > 0 && len(ret) > n {
+		ret = ret[len(ret) - n:]
+	}
+	return ret
+}
+
+func (node *Node)recordEvent(type_ EventType, peer string, index int64, detail string){
+	node.events.Add(Event{node.clock.Now(), type_, node.Term, peer, index, detail})
+}
+
+// Latest n raft events, for debugging
+func (node *Node)Events(n int) []Event {
+	return node.events.Last(n)
+}
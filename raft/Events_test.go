@@ -0,0 +1,23 @@
+package raft
+
+import (
+	"testing"
+)
+
+func TestEventRing(t *testing.T){
+	r := NewEventRing(3)
+	if len(r.Last(0)) != 0 {
+		t.Fatal("")
+	}
+	for i := 1; i <= 5; i ++ {
+		r.Add(Event{Index: int64(i)})
+	}
+	evs := r.Last(0)
+	if len(evs) != 3 || evs[0].Index != 3 || evs[2].Index != 5 {
+		t.Fatal(evs)
+	}
+	evs = r.Last(2)
+	if len(evs) != 2 || evs[0].Index != 4 {
+		t.Fatal(evs)
+	}
+}
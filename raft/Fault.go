@@ -0,0 +1,47 @@
+package raft
+
+import (
+	"fmt"
+)
+
+// Storage failures(fsync errors, entries lost or corrupted in Db) used to
+// kill the process. Now the node halts instead: it sends no messages, stops
+// ticking and handling messages, and proposals fail with ErrStopped. The
+// cause is returned by Err(), the embedding application decides whether to
+// exit, or to Close() the node and open the Db again.
+
+// with node's lock held, the first failure is kept
+func (st *Storage)fail(err error){
+	if st.fault != nil {
+		return
+	}
+	st.fault = err
+	st.log.Error("storage failure, node halted", "err", err)
+	st.node.emitEvent(SinkStorageFailed, "", st.LastIndex, err.Error())
+}
+
+// Storage failure which halted the node, nil if none
+func (node *Node)Err() error {
+	node.mux.Lock()
+	defer node.mux.Unlock()
+	return node.store.fault
+}
+
+// with node's lock held, nil if not halted
+func (node *Node)haltedError() error {
+	if node.store.fault == nil {
+		return nil
+	}
+	return fmt.Errorf("%w: %v", ErrStopped, node.store.fault)
+}
+
+// with node's lock held, steps down once, waiters of proposals fail
+func (node *Node)halt(){
+	if node.halted {
+		return
+	}
+	node.halted = true
+	node.becomeFollower()
+	node.failApplyWaiters(ErrStopped)
+	node.failForwards(ErrStopped)
+}
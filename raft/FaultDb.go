@@ -0,0 +1,201 @@
+package raft
+
+import (
+	"fmt"
+	"sync"
+	"strings"
+	"math/rand"
+)
+
+type DbFault string
+
+const(
+	DbFailGet    DbFault = "fail_get"    // Get returns "", as if key is absent
+	DbFailSet    DbFault = "fail_set"    // Set or Delete is lost
+	DbPartialSet DbFault = "partial_set" // only a prefix of the value is written
+	DbCorrupt    DbFault = "corrupt"     // Get and Iterate return the value with a byte flipped
+	DbFailFsync  DbFault = "fail_fsync"  // Fsync returns error
+)
+
+type DbFaultRule struct{
+	// key prefix, "" matches all. Ignored by DbFailFsync
+	Key string
+	Fault DbFault
+	// chance the rule applies to a matching operation, <= 0 means always
+	Probability float64
+	// rule is removed after applied Count times, 0 means unlimited
+	Count int
+}
+
+// Db wrapper applying DbFaultRules, for testing Storage's error handling
+// and recovery. The first matching rule wins. Thread safe.
+type FaultDb struct{
+	Db
+	rules []*dbFaultRule
+	nextId int
+	// fault => times applied
+	stats map[DbFault]int
+	rand *rand.Rand
+	mux sync.Mutex
+}
+
+type dbFaultRule struct{
+	DbFaultRule
+	id int
+	applied int
+}
+
+func NewFaultDb(inner Db, seed int64) *FaultDb {
+	db := new(FaultDb)
+	db.Db = inner
+	db.stats = make(map[DbFault]int)
+	db.rand = rand.New(rand.NewSource(seed))
+	return db
+}
+
+// Returns rule id for RemoveRule()
+func (db *FaultDb)AddRule(r DbFaultRule) int {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	db.nextId ++
+	db.rules = append(db.rules, &dbFaultRule{r, db.nextId, 0})
+	return db.nextId
+}
+
+func (db *FaultDb)RemoveRule(id int) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	for i, r := range db.rules {
+		if r.id == id {
+			db.rules = append(db.rules[:i], db.rules[i+1:]...)
+			return
+		}
+	}
+}
+
+func (db *FaultDb)ClearRules() {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	db.rules = nil
+}
+
+// Times each fault has been applied
+func (db *FaultDb)Stats() map[DbFault]int {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	ret := make(map[DbFault]int)
+	for k, v := range db.stats {
+		ret[k] = v
+	}
+	return ret
+}
+
+// fault of the first rule matching, "" if none
+func (db *FaultDb)match(key string, faults ...DbFault) DbFault {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	for i, r := range db.rules {
+		if !strings.HasPrefix(key, r.Key) {
+			continue
+		}
+		found := false
+		for _, f := range faults {
+			found = found || r.Fault == f
+		}
+		if !found {
+			continue
+		}
+		if r.Probability > 0 && db.rand.Float64() >= r.Probability {
+			continue
+		}
+		r.applied ++
+		if r.Count > 0 && r.applied >= r.Count {
+			db.rules = append(db.rules[:i], db.rules[i+1:]...)
+		}
+		db.stats[r.Fault] ++
+		return r.Fault
+	}
+	return ""
+}
+
+func (db *FaultDb)corrupt(val string) string {
+	if val == "" {
+		return val
+	}
+	db.mux.Lock()
+	i := db.rand.Intn(len(val))
+	db.mux.Unlock()
+	bs := []byte(val)
+	bs[i] ^= 0x20
+	return string(bs)
+}
+
+func (db *FaultDb)Fsync() error {
+	if db.match("", DbFailFsync) != "" {
+		return fmt.Errorf("injected fsync error")
+	}
+	return db.Db.Fsync()
+}
+
+func (db *FaultDb)Get(key string) string {
+	switch db.match(key, DbFailGet, DbCorrupt) {
+	case DbFailGet:
+		return ""
+	case DbCorrupt:
+		return db.corrupt(db.Db.Get(key))
+	}
+	return db.Db.Get(key)
+}
+
+func (db *FaultDb)Set(key string, val string) {
+	if val, ok := db.faultySet(key, val); ok {
+		db.Db.Set(key, val)
+	}
+}
+
+// value actually written, false if the write is lost
+func (db *FaultDb)faultySet(key string, val string) (string, bool) {
+	switch db.match(key, DbFailSet, DbPartialSet) {
+	case DbFailSet:
+		return "", false
+	case DbPartialSet:
+		if val != "" {
+			db.mux.Lock()
+			n := db.rand.Intn(len(val))
+			db.mux.Unlock()
+			val = val[:n]
+		}
+	}
+	return val, true
+}
+
+func (db *FaultDb)Delete(key string) {
+	if db.match(key, DbFailSet) != "" {
+		return
+	}
+	db.Db.Delete(key)
+}
+
+// faults are applied to each write of b
+func (db *FaultDb)WriteBatch(b *Batch) {
+	fb := NewBatch()
+	for _, op := range b.Ops {
+		if op.Delete {
+			if db.match(op.Key, DbFailSet) == "" {
+				fb.Delete(op.Key)
+			}
+		} else if val, ok := db.faultySet(op.Key, op.Val); ok {
+			fb.Set(op.Key, val)
+		}
+	}
+	db.Db.WriteBatch(fb)
+}
+
+func (db *FaultDb)Iterate(start string, end string, fn func(key string, val string) bool) {
+	db.Db.Iterate(start, end, func(k string, v string) bool {
+		if db.match(k, DbCorrupt) != "" {
+			v = db.corrupt(v)
+		}
+		return fn(k, v)
+	})
+}
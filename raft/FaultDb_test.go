@@ -0,0 +1,88 @@
+package raft
+
+import (
+	"testing"
+
+	"github.com/fallowu/big-ssdb/logger"
+)
+
+func TestFaultDb(t *testing.T){
+	db := NewFaultDb(NewMemDb(), 1)
+	db.Set("a", "hello")
+
+	id := db.AddRule(DbFaultRule{Key: "a", Fault: DbFailGet})
+	if db.Get("a") != "" || db.Get("b") != "" {
+		t.Fatal("get not failed")
+	}
+	db.RemoveRule(id)
+	if db.Get("a") != "hello" {
+		t.Fatal("rule not removed")
+	}
+
+	db.AddRule(DbFaultRule{Fault: DbFailSet, Count: 1})
+	db.Set("b", "x")
+	db.Set("c", "y")
+	if db.Get("b") != "" || db.Get("c") != "y" {
+		t.Fatal("set with Count")
+	}
+
+	db.AddRule(DbFaultRule{Fault: DbPartialSet, Count: 1})
+	db.Set("d", "world")
+	if v := db.Get("d"); len(v) >= 5 || v != "world"[:len(v)] {
+		t.Fatal("not partial", v)
+	}
+
+	db.AddRule(DbFaultRule{Key: "a", Fault: DbCorrupt})
+	if v := db.Get("a"); v == "hello" || len(v) != 5 {
+		t.Fatal("not corrupted", v)
+	}
+	if v := dbAll(db)["a"]; v == "hello" {
+		t.Fatal("Iterate() not corrupted", v)
+	}
+	db.ClearRules()
+
+	db.AddRule(DbFaultRule{Fault: DbFailFsync, Count: 1})
+	if db.Fsync() == nil || db.Fsync() != nil {
+		t.Fatal("fsync")
+	}
+	if st := db.Stats(); st[DbFailSet] != 1 || st[DbPartialSet] != 1 || st[DbFailFsync] != 1 {
+		t.Fatal("bad stats", st)
+	}
+}
+
+// an entry torn by a crash before it's committed is discarded on restart
+func TestStorageTornEntry(t *testing.T){
+	logger.SetDefaultLevel(logger.LevelError)
+	inner := NewMemDb()
+	db := NewFaultDb(inner, 1)
+	node := NewNode("n1", "addr1", db)
+	node.AddMember("n1", "addr1")
+	node.Propose("a")
+	node.StepTick(0)
+	// a member which never acks, so proposals are not committed
+	node.mux.Lock()
+	node.addMember("n2", "addr2")
+	node.store.SaveState()
+	node.mux.Unlock()
+	node.SetOutbox(func(msg *Message) {})
+	commitIndex := node.store.CommitIndex
+
+	node.Propose("b")
+	db.AddRule(DbFaultRule{Key: "log#", Fault: DbPartialSet, Count: 1})
+	node.Propose("c")
+	node.Propose("d")
+
+	restarted := NewNode("n1", "addr1", inner)
+	st := restarted.store
+	if st.CommitIndex != commitIndex || st.LastIndex != commitIndex + 1 {
+		t.Fatal("commitIndex", st.CommitIndex, "lastIndex", st.LastIndex)
+	}
+	if ent := st.GetEntry(commitIndex + 1); ent == nil || ent.Data != "b" {
+		t.Fatal("bad entry", ent)
+	}
+	// discarded entries are gone from db
+	restarted = NewNode("n1", "addr1", inner)
+	if restarted.store.LastIndex != commitIndex + 1 {
+		t.Fatal("lastIndex", restarted.store.LastIndex)
+	}
+}
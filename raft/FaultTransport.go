@@ -0,0 +1,229 @@
+package raft
+
+import (
+	"fmt"
+	"sync"
+	"time"
+	"strconv"
+	"strings"
+	"math/rand"
+)
+
+type FaultAction string
+
+const(
+	FaultDrop      FaultAction = "drop"
+	FaultDelay     FaultAction = "delay"     // send after Delay
+	FaultDuplicate FaultAction = "duplicate" // send twice
+	FaultReorder   FaultAction = "reorder"   // hold until the next message to the same peer is sent
+	FaultCorrupt   FaultAction = "corrupt"   // flip a random byte of Data
+)
+
+type FaultRule struct{
+	Peer string      // destination, "" matches all
+	Type MessageType // "" matches all
+	Action FaultAction
+	// chance the rule applies to a matching message, <= 0 means always
+	Probability float64
+	Delay time.Duration
+	// rule is removed after applied Count times, 0 means unlimited
+	Count int
+}
+
+// spec: "<action> [peer=id] [type=MessageType] [p=probability] [delay=duration] [count=n]",
+// e.g. "delay peer=n2 type=AppendEntry delay=200ms p=0.5"
+func ParseFaultRule(spec string) (FaultRule, error) {
+	var r FaultRule
+	ps := strings.Fields(spec)
+	if len(ps) == 0 {
+		return r, fmt.Errorf("empty fault rule")
+	}
+	r.Action = FaultAction(ps[0])
+	switch r.Action {
+	case FaultDrop, FaultDelay, FaultDuplicate, FaultReorder, FaultCorrupt:
+	default:
+		return r, fmt.Errorf("unknown fault action: %s", ps[0])
+	}
+	for _, p := range ps[1:] {
+		kv := strings.SplitN(p, "=", 2)
+		if len(kv) != 2 {
+			return r, fmt.Errorf("bad fault rule option: %s", p)
+		}
+		var err error
+		switch kv[0] {
+		case "peer":
+			r.Peer = kv[1]
+		case "type":
+			r.Type = MessageType(kv[1])
+		case "p":
+			r.Probability, err = strconv.ParseFloat(kv[1], 64)
+		case "delay":
+			r.Delay, err = time.ParseDuration(kv[1])
+		case "count":
+			r.Count, err = strconv.Atoi(kv[1])
+		default:
+			err = fmt.Errorf("unknown option")
+		}
+		if err != nil {
+			return r, fmt.Errorf("bad fault rule option %s: %s", p, err)
+		}
+	}
+	return r, nil
+}
+
+// Transport wrapper applying FaultRules to outgoing messages, for testing.
+// The first matching rule wins. Wrap every node's transport to cover both
+// directions. Thread safe.
+type FaultTransport struct{
+	Transport
+	rules []*faultRule
+	nextId int
+	// peer => message held by FaultReorder
+	held map[string]*Message
+	// action => times applied
+	stats map[FaultAction]int
+	rand *rand.Rand
+	clock Clock
+	mux sync.Mutex
+}
+
+type faultRule struct{
+	FaultRule
+	id int
+	applied int
+}
+
+func NewFaultTransport(inner Transport, seed int64) *FaultTransport {
+	tp := new(FaultTransport)
+	tp.Transport = inner
+	tp.held = make(map[string]*Message)
+	tp.stats = make(map[FaultAction]int)
+	tp.rand = rand.New(rand.NewSource(seed))
+	tp.clock = SystemClock
+	return tp
+}
+
+// Clock of FaultDelay, must be called before Send()
+func (tp *FaultTransport)SetClock(c Clock) {
+	tp.mux.Lock()
+	defer tp.mux.Unlock()
+	tp.clock = c
+}
+
+// Returns rule id for RemoveRule()
+func (tp *FaultTransport)AddRule(r FaultRule) int {
+	tp.mux.Lock()
+	defer tp.mux.Unlock()
+	tp.nextId ++
+	tp.rules = append(tp.rules, &faultRule{r, tp.nextId, 0})
+	return tp.nextId
+}
+
+func (tp *FaultTransport)RemoveRule(id int) {
+	tp.mux.Lock()
+	defer tp.mux.Unlock()
+	for i, r := range tp.rules {
+		if r.id == id {
+			tp.rules = append(tp.rules[:i], tp.rules[i+1:]...)
+			return
+		}
+	}
+}
+
+// Remove all rules and send held messages
+func (tp *FaultTransport)ClearRules() {
+	tp.mux.Lock()
+	tp.rules = nil
+	held := tp.held
+	tp.held = make(map[string]*Message)
+	tp.mux.Unlock()
+
+	for _, msg := range held {
+		tp.Transport.Send(msg)
+	}
+}
+
+// Times each action has been applied
+func (tp *FaultTransport)Stats() map[FaultAction]int {
+	tp.mux.Lock()
+	defer tp.mux.Unlock()
+	ret := make(map[FaultAction]int)
+	for k, v := range tp.stats {
+		ret[k] = v
+	}
+	return ret
+}
+
+func (tp *FaultTransport)match(msg *Message) *faultRule {
+	for i, r := range tp.rules {
+		if r.Peer != "" && r.Peer != msg.Dst {
+			continue
+		}
+		if r.Type != "" && r.Type != msg.Type {
+			continue
+		}
+		if r.Probability > 0 && tp.rand.Float64() >= r.Probability {
+			continue
+		}
+		r.applied ++
+		if r.Count > 0 && r.applied >= r.Count {
+			tp.rules = append(tp.rules[:i], tp.rules[i+1:]...)
+		}
+		tp.stats[r.Action] ++
+		return r
+	}
+	return nil
+}
+
+func (tp *FaultTransport)Send(msg *Message) bool {
+	tp.mux.Lock()
+	r := tp.match(msg)
+	// a message held by FaultReorder goes after this one
+	held := tp.held[msg.Dst]
+	delete(tp.held, msg.Dst)
+
+	var action FaultAction
+	if r != nil {
+		action = r.Action
+	}
+	if action == FaultReorder {
+		cp := *msg
+		tp.held[msg.Dst] = &cp
+	}
+	if action == FaultCorrupt {
+		msg = tp.corrupt(msg)
+	}
+	clock := tp.clock
+	tp.mux.Unlock()
+
+	ret := true
+	switch action {
+	case FaultDrop, FaultReorder:
+	case FaultDelay:
+		cp := *msg
+		clock.AfterFunc(r.Delay, func(){
+			tp.Transport.Send(&cp)
+		})
+	case FaultDuplicate:
+		tp.Transport.Send(msg)
+		ret = tp.Transport.Send(msg)
+	default:
+		ret = tp.Transport.Send(msg)
+	}
+	if held != nil {
+		tp.Transport.Send(held)
+	}
+	return ret
+}
+
+func (tp *FaultTransport)corrupt(msg *Message) *Message {
+	cp := *msg
+	if len(cp.Data) > 0 {
+		bs := []byte(cp.Data)
+		bs[tp.rand.Intn(len(bs))] ^= byte(1 + tp.rand.Intn(255))
+		cp.Data = string(bs)
+	} else {
+		cp.Data = "\x00"
+	}
+	return &cp
+}
@@ -0,0 +1,103 @@
+package raft
+
+import (
+	"testing"
+	"time"
+)
+
+type recordTransport struct{
+	sent chan *Message
+}
+
+func (t *recordTransport)Addr() string { return "rec" }
+func (t *recordTransport)Close() {}
+func (t *recordTransport)Connect(nodeId string, addr string) {}
+func (t *recordTransport)Disconnect(nodeId string) {}
+func (t *recordTransport)C() chan *Message { return nil }
+func (t *recordTransport)Send(msg *Message) bool {
+	t.sent <- msg
+	return true
+}
+
+func (t *recordTransport)next() *Message {
+	select {
+	case msg := <-t.sent:
+		return msg
+	case <-time.After(time.Second):
+		return nil
+	}
+}
+
+func TestFaultTransport(t *testing.T){
+	rec := &recordTransport{make(chan *Message, 10)}
+	tp := NewFaultTransport(rec, 1)
+
+	msg := func(dst string, data string) *Message {
+		m := NewAppendEntryAck(dst, true)
+		m.Data = data
+		return m
+	}
+
+	id := tp.AddRule(FaultRule{Peer: "n2", Action: FaultDrop})
+	tp.Send(msg("n2", "a"))
+	tp.Send(msg("n3", "b"))
+	if m := rec.next(); m.Data != "b" {
+		t.Fatal("n2 not dropped", m.Data)
+	}
+	tp.RemoveRule(id)
+
+	tp.AddRule(FaultRule{Type: MessageTypeAppendEntryAck, Action: FaultReorder, Count: 1})
+	tp.Send(msg("n2", "1"))
+	tp.Send(msg("n2", "2"))
+	if rec.next().Data != "2" || rec.next().Data != "1" {
+		t.Fatal("not reordered")
+	}
+
+	tp.AddRule(FaultRule{Action: FaultDuplicate, Count: 1})
+	tp.Send(msg("n2", "d"))
+	if rec.next().Data != "d" || rec.next().Data != "d" {
+		t.Fatal("not duplicated")
+	}
+
+	tp.AddRule(FaultRule{Action: FaultCorrupt, Count: 1})
+	tp.Send(msg("n2", "hello"))
+	if m := rec.next(); m.Data == "hello" || len(m.Data) != 5 {
+		t.Fatal("not corrupted", m.Data)
+	}
+
+	clock := NewManualClock()
+	tp.SetClock(clock)
+	tp.AddRule(FaultRule{Action: FaultDelay, Delay: 20 * time.Millisecond, Count: 1})
+	tp.Send(msg("n2", "late"))
+	clock.Advance(19 * time.Millisecond)
+	if len(rec.sent) != 0 {
+		t.Fatal("not delayed")
+	}
+	clock.Advance(time.Millisecond)
+	if m := rec.next(); m.Data != "late" {
+		t.Fatal("not delivered")
+	}
+
+	st := tp.Stats()
+	if st[FaultDrop] != 1 || st[FaultReorder] != 1 || st[FaultDelay] != 1 {
+		t.Fatal("bad stats", st)
+	}
+	if len(tp.rules) != 0 {
+		t.Fatal("rules with Count not removed")
+	}
+}
+
+func TestParseFaultRule(t *testing.T){
+	r, err := ParseFaultRule("delay peer=n2 type=AppendEntry delay=200ms p=0.5 count=3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r != (FaultRule{"n2", MessageTypeAppendEntry, FaultDelay, 0.5, 200 * time.Millisecond, 3}) {
+		t.Fatal("bad rule", r)
+	}
+	for _, spec := range []string{"", "explode", "drop p=x", "drop foo=1", "drop peer"} {
+		if _, err := ParseFaultRule(spec); err == nil {
+			t.Fatal("bad spec accepted:", spec)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package raft
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fallowu/big-ssdb/logger"
+)
+
+// fails Fsync() once broken
+type faultyDb struct{
+	*MemDb
+	broken bool
+}
+
+func (db *faultyDb)Fsync() error {
+	if db.broken {
+		return errors.New("disk broken")
+	}
+	return nil
+}
+
+func TestStorageFault(t *testing.T){
+	logger.SetDefaultLevel(logger.LevelError)
+	db := &faultyDb{MemDb: NewMemDb()}
+	n1 := NewNode("n1", "addr1", db)
+	sent := 0
+	n1.SetOutbox(func(msg *Message) { sent ++ })
+	n1.AddMember("n1", "addr1")
+	n1.StepTick(0)
+	if _, _, err := n1.Propose("data"); err != nil {
+		t.Fatal(err)
+	}
+	n1.StepTick(0)
+
+	db.broken = true
+	n1.Propose("data")
+	n1.StepTick(0)
+	if err := n1.Err(); err == nil {
+		t.Fatal("not halted")
+	}
+	n1.StepTick(0)
+	if _, _, err := n1.Propose("data"); !errors.Is(err, ErrStopped) {
+		t.Fatal(err)
+	}
+	if n1.Metrics().Role == RoleLeader {
+		t.Fatal("still leader")
+	}
+	if _, err := n1.ProposeWait("data", time.Millisecond); !errors.Is(err, ErrStopped) {
+		t.Fatal(err)
+	}
+}
+
+func TestCorruptedEntry(t *testing.T){
+	logger.SetDefaultLevel(logger.LevelError)
+	db := NewMemDb()
+	n1 := NewNode("n1", "addr1", db)
+	n1.AddMember("n1", "addr1")
+	n1.StepTick(0)
+	n1.Propose("set k v")
+	n1.StepTick(0)
+	n1.Close()
+
+	// a bit flipped on disk, still well formed
+	v := db.Get("log#003")
+	db.Set("log#003", strings.Replace(v, "set k v", "set k w", 1))
+	n1 = NewNode("n1", "addr1", db)
+	if err := n1.Err(); !errors.Is(err, ErrCorrupted) {
+		t.Fatal("not halted", err)
+	}
+}
@@ -0,0 +1,137 @@
+package raft
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/fallowu/big-ssdb/internal/util"
+	"github.com/fallowu/big-ssdb/trace"
+)
+
+// Proposals on a follower are forwarded to the leader it follows with a
+// Propose message, PrevIndex is the request id. The leader replies with
+// ProposeAck, Data "ok <term> <index>" or "error <message>". Lost messages
+// are not resent, the data might be appended twice, so ctx should have a
+// deadline.
+
+type forwardResult struct{
+	term int32
+	index int64
+	err error
+}
+
+// errors relayed from leader by message
+var forwardErrors = []error{
+	ErrNotLeader,
+	ErrNoQuorum,
+	ErrConfigChangeInProgress,
+	ErrShuttingDown,
+}
+
+// Like Propose(), but a follower forwards data to the leader and returns
+// the term and index of the entry the leader appended. NotLeaderError if
+// the leader is unknown, ErrLeadershipLost if the node's leader changes
+// before the leader replies, then the entry may or may not be appended.
+func (node *Node)ProposeForward(ctx context.Context, data string) (int32, int64, error) {
+	if err := ctx.Err(); err != nil {
+		return -1, -1, err
+	}
+	node.mux.Lock()
+	if node.Role == RoleLeader || node.closed {
+		ent, err := node.propose(data, trace.SpanContext{})
+		node.unlock()
+		if err != nil {
+			return -1, -1, err
+		}
+		return ent.Term, ent.Index, nil
+	}
+	leader := node.leaderId()
+	if leader == "" || node.Role != RoleFollower {
+		err := node.notLeaderError()
+		node.mux.Unlock()
+		return -1, -1, err
+	}
+	node.forwardSeq ++
+	seq := node.forwardSeq
+	c := make(chan forwardResult, 1)
+	if node.forwards == nil {
+		node.forwards = make(map[int64]chan forwardResult)
+	}
+	node.forwards[seq] = c
+	node.proposalsForwarded ++
+	msg := NewProposeMsg(leader, seq, data)
+	node.send(msg)
+	node.mux.Unlock()
+
+	select {
+	case res := <-c:
+		return res.term, res.index, res.err
+	case <-ctx.Done():
+		node.mux.Lock()
+		delete(node.forwards, seq)
+		node.mux.Unlock()
+		// replied just before ctx is done
+		select {
+		case res := <-c:
+			return res.term, res.index, res.err
+		default:
+		}
+		return -1, -1, ctx.Err()
+	}
+}
+
+// with node's lock held, as leader
+func (node *Node)handlePropose(msg *Message){
+	ack := NewProposeAck(msg.Src, msg.PrevIndex)
+	ent, err := node.propose(msg.Data, trace.SpanContext{})
+	if err != nil {
+		ack.Data = "error " + err.Error()
+	} else {
+		ack.Data = "ok " + util.Itoa32(ent.Term) + " " + util.I64toa(ent.Index)
+	}
+	node.send(ack)
+}
+
+// with node's lock held, as follower
+func (node *Node)handleProposeAck(msg *Message){
+	c := node.forwards[msg.PrevIndex]
+	if c == nil {
+		return
+	}
+	delete(node.forwards, msg.PrevIndex)
+	res := forwardResult{term: -1, index: -1}
+	ps := strings.SplitN(msg.Data, " ", 3)
+	if len(ps) == 3 && ps[0] == "ok" {
+		res.term = util.Atoi32(ps[1])
+		res.index = util.Atoi64(ps[2])
+	} else {
+		res.err = node.forwardError(strings.TrimPrefix(msg.Data, "error "))
+	}
+	c <- res
+}
+
+// leader's error by its message
+func (node *Node)forwardError(s string) error {
+	if s == ErrNotLeader.Error() {
+		// the leader lost leadership, hint of who this node follows now
+		return node.notLeaderError()
+	}
+	for _, err := range forwardErrors {
+		if s == err.Error() {
+			return err
+		}
+	}
+	if strings.HasPrefix(s, ErrBusy.Error() + ": ") {
+		return &BusyError{Reason: strings.TrimPrefix(s, ErrBusy.Error() + ": ")}
+	}
+	return errors.New(s)
+}
+
+// with node's lock held, when the node stops following the leader
+func (node *Node)failForwards(err error){
+	for seq, c := range node.forwards {
+		c <- forwardResult{term: -1, index: -1, err: err}
+		delete(node.forwards, seq)
+	}
+}
@@ -0,0 +1,88 @@
+package raft
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fallowu/big-ssdb/logger"
+)
+
+func TestProposeForward(t *testing.T){
+	logger.SetDefaultLevel(logger.LevelError)
+	var mux sync.Mutex
+	var queue []*Message
+	outbox := func(msg *Message) {
+		cp := *msg
+		mux.Lock()
+		queue = append(queue, &cp)
+		mux.Unlock()
+	}
+	n1 := NewNode("n1", "addr1", NewMemDb())
+	n2 := NewNode("n2", "addr2", NewMemDb())
+	nodes := map[string]*Node{"n1": n1, "n2": n2}
+	pump := func() {
+		for {
+			mux.Lock()
+			if len(queue) == 0 {
+				mux.Unlock()
+				return
+			}
+			msg := queue[0]
+			queue = queue[1:]
+			mux.Unlock()
+			nodes[msg.Dst].StepMessage(msg)
+		}
+	}
+	for _, n := range nodes {
+		n.SetOutbox(outbox)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5 * time.Second)
+	defer cancel()
+	// leader unknown
+	if _, _, err := n2.ProposeForward(ctx, "data"); !errors.Is(err, ErrNotLeader) {
+		t.Fatal(err)
+	}
+
+	n1.AddMember("n1", "addr1")
+	n1.StepTick(0)
+	n1.AddMember("n2", "addr2")
+	n2.JoinGroup("n1", "addr1")
+	for i := 0; i < 60; i ++ {
+		n1.StepTick(100)
+		n2.StepTick(100)
+		pump()
+	}
+
+	type result struct{
+		term int32
+		index int64
+		err error
+	}
+	c := make(chan result, 1)
+	go func() {
+		term, index, err := n2.ProposeForward(ctx, "data")
+		c <- result{term, index, err}
+	}()
+	var res result
+	for done := false; !done; {
+		select {
+		case res = <-c:
+			done = true
+		default:
+			pump()
+			time.Sleep(time.Millisecond)
+		}
+	}
+	if res.err != nil || res.term != n1.Term || res.index != n1.store.LastIndex {
+		t.Fatal(res.term, res.index, res.err)
+	}
+	if ent := n1.store.GetEntry(res.index); ent == nil || ent.Data != "data" {
+		t.Fatal(ent)
+	}
+	if n2.Metrics().ProposalsForwarded != 1 {
+		t.Fatal(n2.Metrics().ProposalsForwarded)
+	}
+}
@@ -0,0 +1,63 @@
+package raft
+
+import (
+	"fmt"
+	"time"
+)
+
+// When entries and commitIndex written by group commit are fsynced, as Redis
+// appendfsync. With everysec and never, written entries are acked and
+// counted by leader before they are durable, a crash of a majority may lose
+// committed ones. State(term, vote) is fsynced on every change regardless.
+type FsyncPolicy int
+
+const(
+	// at the end of every batch, see GroupCommit.go
+	FsyncAlways FsyncPolicy = iota
+	// by the first batch or tick a second after the last fsync
+	FsyncEverySec
+	// left to the OS
+	FsyncNever
+)
+
+const fsyncInterval = time.Second
+
+func ParseFsyncPolicy(s string) (FsyncPolicy, error) {
+	switch s {
+	case "", "always":
+		return FsyncAlways, nil
+	case "everysec":
+		return FsyncEverySec, nil
+	case "never":
+		return FsyncNever, nil
+	}
+	return FsyncAlways, fmt.Errorf("bad fsync policy: %s", s)
+}
+
+func (p FsyncPolicy)String() string {
+	switch p {
+	case FsyncEverySec:
+		return "everysec"
+	case FsyncNever:
+		return "never"
+	}
+	return "always"
+}
+
+// See FsyncPolicy, FsyncAlways by default
+func WithFsyncPolicy(p FsyncPolicy) Option {
+	return func(opts *options) {
+		opts.fsyncPolicy = p
+	}
+}
+
+// false if written entries are counted as durable without fsync
+func (st *Storage)fsyncDue() bool {
+	switch st.fsyncPolicy {
+	case FsyncEverySec:
+		return st.node.clock.Now().Sub(st.lastFsync) >= fsyncInterval
+	case FsyncNever:
+		return false
+	}
+	return true
+}
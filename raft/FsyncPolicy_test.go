@@ -0,0 +1,69 @@
+package raft
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fallowu/big-ssdb/logger"
+)
+
+type fsyncCountingDb struct{
+	Db
+	fsyncs int
+}
+
+func (db *fsyncCountingDb)Fsync() error {
+	db.fsyncs ++
+	return db.Db.Fsync()
+}
+
+func TestFsyncPolicy(t *testing.T){
+	logger.SetDefaultLevel(logger.LevelError)
+	for _, p := range []FsyncPolicy{FsyncAlways, FsyncEverySec, FsyncNever} {
+		if got, err := ParseFsyncPolicy(p.String()); err != nil || got != p {
+			t.Fatal("parse", p, got, err)
+		}
+		clock := NewManualClock()
+		db := &fsyncCountingDb{Db: NewMemDb()}
+		node := NewNode("n1", "addr1", db, WithClock(clock), WithFsyncPolicy(p))
+		node.AddMember("n1", "addr1")
+		node.StepTick(0)
+		clock.Advance(time.Second)
+		node.StepTick(0)
+		if !strings.Contains(node.Info(), "fsyncPolicy: " + p.String()) || node.InfoMap()["fsyncPolicy"] != p.String() {
+			t.Fatal("info", node.Info())
+		}
+
+		start := db.fsyncs
+		for i := 0; i < 10; i ++ {
+			node.Propose("set k v")
+			node.StepTick(0)
+		}
+		// the leader commits without waiting for fsync
+		if m := node.Metrics(); m.CommitIndex != m.LastIndex {
+			t.Fatal(p, "commit", m.CommitIndex, m.LastIndex)
+		}
+		n := db.fsyncs - start
+		clock.Advance(time.Second)
+		node.StepTick(0)
+		after := db.fsyncs - start
+		switch p {
+		case FsyncAlways:
+			if n < 10 {
+				t.Fatal(p, n, after)
+			}
+		case FsyncEverySec:
+			if n != 1 || after != 2 {
+				t.Fatal(p, n, after)
+			}
+		case FsyncNever:
+			if n != 0 || after != 0 {
+				t.Fatal(p, n, after)
+			}
+		}
+	}
+	if _, err := ParseFsyncPolicy("sometimes"); err == nil {
+		t.Fatal("parse bad policy")
+	}
+}
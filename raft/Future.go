@@ -0,0 +1,103 @@
+package raft
+
+import (
+	"context"
+	"time"
+
+	"github.com/fallowu/big-ssdb/trace"
+)
+
+// Outcome of an entry proposed by ProposeFuture(), resolved once when the
+// entry is committed and applied by Service(committed only, without
+// Service), or failed with ErrLeadershipLost/ErrProposalDropped. Thread
+// safe.
+type Future struct{
+	Term int32
+	Index int64
+	done chan struct{}
+	// without Service
+	commit *commitWaiter
+	// written once before done is closed
+	result interface{}
+	err error
+}
+
+func newFuture(term int32, index int64) *Future {
+	return &Future{Term: term, Index: index, done: make(chan struct{})}
+}
+
+// with node's lock held
+func (f *Future)resolve(result interface{}, err error){
+	f.result = result
+	f.err = err
+	close(f.done)
+}
+
+// Closed when resolved
+func (f *Future)Done() <-chan struct{} {
+	return f.done
+}
+
+// Blocks until resolved
+func (f *Future)Result() (interface{}, error) {
+	<-f.done
+	return f.result, f.err
+}
+
+// Result(), or ctx.Err() if ctx is done first, the future is still resolved
+// later
+func (f *Future)Wait(ctx context.Context) (interface{}, error) {
+	select {
+	case <-f.done:
+		return f.result, f.err
+	case <-ctx.Done():
+		// resolved just before ctx is done
+		select {
+		case <-f.done:
+			return f.result, f.err
+		default:
+		}
+		return nil, ctx.Err()
+	}
+}
+
+// Propose without waiting, returns a future of the entry. Admission waits
+// for ctx with OverflowBlock, see AdmissionConfig.
+func (node *Node)ProposeFuture(ctx context.Context, data string) (*Future, error) {
+	return node.ProposeFutureWithTrace(ctx, data, trace.SpanContext{})
+}
+
+func (node *Node)ProposeFutureWithTrace(ctx context.Context, data string, parent trace.SpanContext) (*Future, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	p := &proposal{parent: parent, wantFuture: true}
+	if err := node.submitProposal(ctx, p, data); err != nil {
+		return nil, err
+	}
+	return p.future, nil
+}
+
+// Propose and wait up to timeout for the entry to be applied, returns its
+// index. On timeout the entry may still be committed later, the future is
+// given up.
+func (node *Node)ProposeWait(data string, timeout time.Duration) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	f, err := node.ProposeFuture(ctx, data)
+	if err != nil {
+		return -1, err
+	}
+	if _, err := f.Wait(ctx); err != nil {
+		if err == ctx.Err() {
+			node.mux.Lock()
+			node.removeFuture(f)
+			node.mux.Unlock()
+		}
+		if err == context.DeadlineExceeded {
+			err = ErrTimeout
+		}
+		return f.Index, err
+	}
+	return f.Index, nil
+}
@@ -0,0 +1,73 @@
+package raft
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/fallowu/big-ssdb/logger"
+)
+
+func TestProposeFuture(t *testing.T){
+	logger.SetDefaultLevel(logger.LevelError)
+	n1 := NewNode("n1", "addr1", NewMemDb())
+	n1.SetOutbox(func(msg *Message) {})
+	n1.AddMember("n1", "addr1")
+	n1.StepTick(0)
+
+	// committed only, without Service
+	f, err := n1.ProposeFuture(context.Background(), "data")
+	if err != nil {
+		t.Fatal("err", err)
+	}
+	select {
+	case <-f.Done():
+		t.Fatal("resolved before commit")
+	default:
+	}
+	n1.StepTick(0)
+	if _, err := f.Result(); err != nil || f.Index != n1.Status().CommitIndex {
+		t.Fatal("err", err, "index", f.Index)
+	}
+
+	n1.SetService(&countingService{lastApplied: n1.LastApplied()})
+	n1.StartApplier()
+	f, _ = n1.ProposeFuture(context.Background(), "data")
+	n1.StepTick(0)
+	if res, err := f.Result(); err != nil || res != int64(1) {
+		t.Fatal("result", res, "err", err)
+	}
+
+	if _, err := n1.ProposeWait("data", 10 * time.Millisecond); err != ErrTimeout {
+		t.Fatal("err", err)
+	}
+	if len(n1.applyWaiters) != 0 {
+		t.Fatal("waiters", len(n1.applyWaiters))
+	}
+	f, _ = n1.ProposeFuture(context.Background(), "data")
+	n1.mux.Lock()
+	n1.becomeFollower()
+	n1.unlock()
+	if _, err := f.Result(); err != ErrLeadershipLost {
+		t.Fatal("err", err)
+	}
+}
+
+func TestProposeWaitTimeout(t *testing.T){
+	logger.SetDefaultLevel(logger.LevelError)
+	n1 := NewNode("n1", "addr1", NewMemDb())
+	n1.SetOutbox(func(msg *Message) {})
+	n1.AddMember("n1", "addr1")
+	n1.StepTick(0)
+	n1.AddMember("n2", "addr2")
+	n1.StepTick(0)
+	// n2 never acks, never committed
+	_, err := n1.ProposeWait("data", 10 * time.Millisecond)
+	if !errors.Is(err, ErrTimeout) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal(err)
+	}
+	if len(n1.commitWaiters) != 0 {
+		t.Fatal("waiters", len(n1.commitWaiters))
+	}
+}
@@ -0,0 +1,114 @@
+package raft
+
+import (
+	"testing"
+)
+
+// go test -fuzz=FuzzDecodeMessage raft
+func FuzzDecodeMessage(f *testing.F){
+	f.Add("AppendEntry n1 n2 3 2 10 3 11 10 Data set a 1")
+	f.Add("AppendEntryAck@00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01 n2 n1 3 3 11 true")
+	f.Add("RequestVote n1 n2 1 0 0 please vote me\r\n")
+	f.Add("AppendEntry n1 n2 -1 0 9223372036854775807 x")
+	f.Add("A b c 1 1 1 ")
+	f.Fuzz(func(t *testing.T, buf string){
+		m := DecodeMessage(buf)
+		if m == nil {
+			return
+		}
+		if m.Term < 0 || m.PrevTerm < 0 || m.PrevIndex < 0 || m.PrevIndex > MaxIndex {
+			t.Fatalf("bad numbers %q => %+v", buf, m)
+		}
+		m2 := DecodeMessage(m.Encode())
+		if m2 == nil || *m2 != *m {
+			t.Fatalf("round trip %q => %+v => %+v", buf, m, m2)
+		}
+	})
+}
+
+func FuzzDecodeEntry(f *testing.F){
+	f.Add("3 11 10 Data set a 1")
+	f.Add("0 0 5 Ping ")
+	f.Add("1 -2 0 Noop ")
+	f.Add("1 4611686018427387904 0 Data x")
+	f.Fuzz(func(t *testing.T, buf string){
+		e := DecodeEntry(buf)
+		if e == nil {
+			return
+		}
+		if e.Term < 0 || e.Index < 0 || e.Commit < 0 || e.Index > MaxIndex || e.Commit > MaxIndex {
+			t.Fatalf("bad numbers %q => %+v", buf, e)
+		}
+		e2 := DecodeEntry(e.Encode())
+		if e2 == nil || *e2 != *e {
+			t.Fatalf("round trip %q => %+v => %+v", buf, e, e2)
+		}
+	})
+}
+
+func FuzzDecodeState(f *testing.F){
+	f.Add(`{"Term":3,"VoteFor":"n1","Members":{"n1":"127.0.0.1:8001"}}`)
+	f.Add(`null`)
+	f.Add(`{"Term":-1}`)
+	f.Fuzz(func(t *testing.T, buf string){
+		s := NewState()
+		if !s.Decode(buf) {
+			return
+		}
+		if s.Term < 0 || s.Members == nil {
+			t.Fatalf("bad state %q => %+v", buf, s)
+		}
+		data := s.Encode()
+		s2 := NewState()
+		if !s2.Decode(data) || s2.Encode() != data {
+			t.Fatalf("round trip %q => %s", buf, data)
+		}
+	})
+}
+
+func FuzzDecodeSnapshot(f *testing.F){
+	f.Add(`["{\"Term\":1,\"VoteFor\":\"\",\"Members\":{\"n1\":\"a\"}}","1 4 4 Noop ","1 5 5 Data x"]`)
+	f.Add(`["{}","1 5 5 Noop ","1 4 4 Noop "]`)
+	f.Add(`[]`)
+	f.Add(`["null"]`)
+	f.Fuzz(func(t *testing.T, buf string){
+		sn := NewSnapshotFromString(buf)
+		if sn == nil {
+			return
+		}
+		ents := sn.Entries()
+		for i, ent := range ents {
+			if ent == nil || ent.Index <= 0 || (i > 0 && ent.Index != ents[i-1].Index + 1) {
+				t.Fatalf("bad entries %q", buf)
+			}
+		}
+		// safe to call on any decoded snapshot
+		sn.LastTerm()
+		sn.LastIndex()
+		data := sn.Encode()
+		sn2 := NewSnapshotFromString(data)
+		if sn2 == nil || sn2.Encode() != data {
+			t.Fatalf("round trip %q => %s", buf, data)
+		}
+	})
+}
+
+func FuzzDecodeWire(f *testing.F){
+	f.Add([]byte("AppendEntryAck n2 n1 3 3 11 true"))
+	f.Add(AppendWire(nil, &Message{Type: MessageTypeAppendEntry, Src: "n1", Dst: "n2", Term: 3, Data: "k\x00"}, WireVersionProto))
+	f.Add([]byte("\x00\x02"))
+	f.Add([]byte("\x02\x20\xff\xff\xff\xff\xff\xff\xff\xff\xff\x01"))
+	f.Fuzz(func(t *testing.T, buf []byte){
+		m, _, err := DecodeWire(buf)
+		if err != nil || m == nil {
+			return
+		}
+		if m.Term < 0 || m.PrevTerm < 0 || m.PrevIndex < 0 || m.PrevIndex > MaxIndex {
+			t.Fatalf("bad numbers %q => %+v", buf, m)
+		}
+		m2, _, err := DecodeWire(AppendWire(nil, m, WireVersionProto))
+		if err != nil || *m2 != *m {
+			t.Fatalf("round trip %q => %+v => %+v", buf, m, m2)
+		}
+	})
+}
@@ -0,0 +1,206 @@
+package raft
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/fallowu/big-ssdb/logger"
+)
+
+// Canonical encodings of the wire and storage formats. A failure means the
+// format changed: nodes of different versions won't talk, and databases
+// written before can't be loaded. If that is intended, keep the old vectors
+// decodable(by a compat shim) and add the new ones, don't just update them.
+
+var goldenMessages = []struct{
+	msg *Message
+	encoded string
+	// by versions before checksums, still decoded
+	legacy string
+}{
+	{&Message{Type: MessageTypeNone, Src: "n1", Dst: "n2", Term: 3}, "None#f8ea5114 n1 n2 3 0 0 ", "None n1 n2 3 0 0 "},
+	{&Message{Type: MessageTypePreVote, Src: "n1", Term: 4, PrevTerm: 3, PrevIndex: 10}, "PreVote#3e3ef999 n1  4 3 10 ",
+		"PreVote n1  4 3 10 "},
+	{&Message{Type: MessageTypePreVoteAck, Src: "n2", Dst: "n1", Term: 4}, "PreVoteAck#d6fadb45 n2 n1 4 0 0 ",
+		"PreVoteAck n2 n1 4 0 0 "},
+	{&Message{Type: MessageTypeRequestVote, Src: "n1", Term: 4, PrevTerm: 3, PrevIndex: 10, Data: "please vote me"},
+		"RequestVote#3b1b5fec n1  4 3 10 please vote me", "RequestVote n1  4 3 10 please vote me"},
+	{&Message{Type: MessageTypeRequestVoteAck, Src: "n2", Dst: "n1", Term: 4, Data: "grant"}, "RequestVoteAck#98e0b63b n2 n1 4 0 0 grant",
+		"RequestVoteAck n2 n1 4 0 0 grant"},
+	{&Message{Type: MessageTypeAppendEntry, Src: "n1", Dst: "n2", Term: 4, PrevTerm: 3, PrevIndex: 10, Data: "4 11 9 Data set k v"},
+		"AppendEntry#608486c9 n1 n2 4 3 10 4 11 9 Data set k v", "AppendEntry n1 n2 4 3 10 4 11 9 Data set k v"},
+	{&Message{Type: MessageTypeAppendEntryAck, Src: "n2", Dst: "n1", Term: 4, PrevTerm: 4, PrevIndex: 11, Data: "true"},
+		"AppendEntryAck#123f563d n2 n1 4 4 11 true", "AppendEntryAck n2 n1 4 4 11 true"},
+	// with hash of effects of entries 9~11, see SetApplyCheck()
+	{&Message{Type: MessageTypeAppendEntryAck, Src: "n2", Dst: "n1", Term: 4, PrevTerm: 4, PrevIndex: 11, Data: "true 9 11 af63bd4c8601b7df"},
+		"AppendEntryAck#114e4d68 n2 n1 4 4 11 true 9 11 af63bd4c8601b7df", "AppendEntryAck n2 n1 4 4 11 true 9 11 af63bd4c8601b7df"},
+	{&Message{Type: MessageTypeAppendEntryAck, Src: "n2", Dst: "n1", Term: 4, PrevTerm: 4, PrevIndex: 11, Data: "true",
+		TraceId: "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"},
+		"AppendEntryAck#a1d5b6c2@00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01 n2 n1 4 4 11 true",
+		"AppendEntryAck@00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01 n2 n1 4 4 11 true"},
+	{&Message{Type: MessageTypeInstallSnapshot, Src: "n1", Dst: "n2", Term: 4, Data: `["{}","4 11 11 Noop "]`},
+		`InstallSnapshot#e108e304 n1 n2 4 0 0 ["{}","4 11 11 Noop "]`, `InstallSnapshot n1 n2 4 0 0 ["{}","4 11 11 Noop "]`},
+	{&Message{Type: MessageTypeStateHash, Src: "n1", Dst: "n2", Term: 4, Data: "11"}, "StateHash#206fd6f7 n1 n2 4 0 0 11",
+		"StateHash n1 n2 4 0 0 11"},
+	{&Message{Type: MessageTypeStateHashAck, Src: "n2", Dst: "n1", Term: 4, Data: "11 5d41402abc4b2a76"},
+		"StateHashAck#6cdf385e n2 n1 4 0 0 11 5d41402abc4b2a76", "StateHashAck n2 n1 4 0 0 11 5d41402abc4b2a76"},
+	{&Message{Type: MessageTypeLogHash, Src: "n1", Dst: "n2", Term: 4, Data: "1 6 11"}, "LogHash#beefdc6e n1 n2 4 0 0 1 6 11",
+		"LogHash n1 n2 4 0 0 1 6 11"},
+	{&Message{Type: MessageTypeLogHashAck, Src: "n2", Dst: "n1", Term: 4, Data: "1 1 9cd1cfa5cb4e5d75,11 4 0af7651916cd43dd"},
+		"LogHashAck#efbb0881 n2 n1 4 0 0 1 1 9cd1cfa5cb4e5d75,11 4 0af7651916cd43dd",
+		"LogHashAck n2 n1 4 0 0 1 1 9cd1cfa5cb4e5d75,11 4 0af7651916cd43dd"},
+	// binary data is base64 encoded, marked by '!'
+	{&Message{Type: MessageTypePropose, Src: "n2", Dst: "n1", Term: 4, PrevIndex: 1, Data: "a\r\nb"},
+		"Propose!#a2903ab3 n2 n1 4 0 1 YQ0KYg==", "Propose! n2 n1 4 0 1 YQ0KYg=="},
+	{&Message{Type: MessageTypePropose, Src: "n2", Dst: "n1", Term: 4, PrevIndex: 1, Data: "k\x00\xff",
+		TraceId: "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"},
+		"Propose!#a678205f@00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01 n2 n1 4 0 1 awD/",
+		"Propose!@00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01 n2 n1 4 0 1 awD/"},
+}
+
+var goldenEntries = []struct{
+	ent *Entry
+	encoded string
+	// by versions before checksums, still decoded
+	legacy string
+}{
+	{&Entry{Term: 0, Index: 1, Commit: 0, Type: EntryTypeNoop}, "0 1 0 Noop#14c32803 ", "0 1 0 Noop "},
+	{&Entry{Term: 2, Index: 2, Commit: 1, Type: EntryTypeAddMember, Data: "n2 addr2"}, "2 2 1 AddMember#e3215973 n2 addr2",
+		"2 2 1 AddMember n2 addr2"},
+	{&Entry{Term: 2, Index: 3, Commit: 2, Type: EntryTypeDelMember, Data: "n2"}, "2 3 2 DelMember#70d69d3e n2",
+		"2 3 2 DelMember n2"},
+	{&Entry{Term: 3, Index: 4, Commit: 3, Type: EntryTypeData, Data: "set k a b\tc"}, "3 4 3 Data#bf9bae3b set k a b\tc",
+		"3 4 3 Data set k a b\tc"},
+	{&Entry{Term: 3, Index: 0, Commit: 4, Type: EntryTypePing}, "3 0 4 Ping#07ede64a ", "3 0 4 Ping "},
+	{&Entry{Term: 3, Index: 5, Commit: 4, Type: EntryTypeData, Data: "a\r\nb"}, "3 5 4 Data!#d7fce64b YQ0KYg==",
+		"3 5 4 Data! YQ0KYg=="},
+	{&Entry{Term: 3, Index: 6, Commit: 5, Type: EntryTypeData, Data: "k\x00\xff"}, "3 6 5 Data!#ba4dbab7 awD/", "3 6 5 Data! awD/"},
+}
+
+var goldenStates = []struct{
+	state *State
+	encoded string
+}{
+	{&State{Term: 0, VoteFor: "", Members: map[string]string{}}, `{"Term":0,"VoteFor":"","Members":{}}`},
+	{&State{Term: 7, VoteFor: "n2", Members: map[string]string{"n2": "addr2", "n1": "addr1"}},
+		`{"Term":7,"VoteFor":"n2","Members":{"n1":"addr1","n2":"addr2"}}`},
+}
+
+// json escapes <, > and &
+var goldenSnapshots = []struct{
+	sn *Snapshot
+	encoded string
+	// by versions before checksums, still decoded
+	legacy string
+}{
+	{&Snapshot{state: &State{Term: 3, Members: map[string]string{"n1": "addr1"}},
+		entries: []*Entry{{Term: 3, Index: 9, Commit: 9, Type: EntryTypeNoop}, {Term: 3, Index: 10, Commit: 10, Type: EntryTypeData, Data: "set <k> \"v\""}}},
+		`["{\"Term\":3,\"VoteFor\":\"\",\"Members\":{\"n1\":\"addr1\"}}","3 9 9 Noop#36e065bd ","3 10 10 Data#4d226770 set \u003ck\u003e \"v\""]`,
+		`["{\"Term\":3,\"VoteFor\":\"\",\"Members\":{\"n1\":\"addr1\"}}","3 9 9 Noop ","3 10 10 Data set \u003ck\u003e \"v\""]`},
+	{&Snapshot{state: &State{Term: 3, Members: map[string]string{"n1": "addr1"}},
+		entries: []*Entry{{Term: 3, Index: 10, Commit: 10, Type: EntryTypeNoop}},
+		service: "10 k=v", hasService: true},
+		`["{\"Term\":3,\"VoteFor\":\"\",\"Members\":{\"n1\":\"addr1\"}}","3 10 10 Noop#32eef48a ","@service MTAgaz12"]`,
+		`["{\"Term\":3,\"VoteFor\":\"\",\"Members\":{\"n1\":\"addr1\"}}","3 10 10 Noop ","@service MTAgaz12"]`},
+}
+
+func TestGoldenMessages(t *testing.T){
+	for _, g := range goldenMessages {
+		if s := g.msg.Encode(); s != g.encoded {
+			t.Errorf("encode %s\n got: %q\nwant: %q", g.msg.Type, s, g.encoded)
+		}
+		msg := DecodeMessage(g.encoded)
+		if !reflect.DeepEqual(msg, g.msg) {
+			t.Errorf("decode %q: %+v", g.encoded, msg)
+		}
+		if msg := DecodeMessage(g.legacy); !reflect.DeepEqual(msg, g.msg) {
+			t.Errorf("decode %q: %+v", g.legacy, msg)
+		}
+		if err := new(Message).decode(corrupt(g.encoded)); err != ErrCorrupted {
+			t.Errorf("decode corrupted %q: %v", g.encoded, err)
+		}
+	}
+}
+
+// data changed, still well formed
+func corrupt(s string) string {
+	return s + "x"
+}
+
+func TestGoldenEntries(t *testing.T){
+	for _, g := range goldenEntries {
+		if s := g.ent.Encode(); s != g.encoded {
+			t.Errorf("encode %s\n got: %q\nwant: %q", g.ent.Type, s, g.encoded)
+		}
+		ent := DecodeEntry(g.encoded)
+		if !reflect.DeepEqual(ent, g.ent) {
+			t.Errorf("decode %q: %+v", g.encoded, ent)
+		}
+		if ent := DecodeEntry(g.legacy); !reflect.DeepEqual(ent, g.ent) {
+			t.Errorf("decode %q: %+v", g.legacy, ent)
+		}
+		if err := new(Entry).decode(corrupt(g.encoded)); err != ErrCorrupted {
+			t.Errorf("decode corrupted %q: %v", g.encoded, err)
+		}
+	}
+}
+
+func TestGoldenStates(t *testing.T){
+	for _, g := range goldenStates {
+		if s := g.state.Encode(); s != g.encoded {
+			t.Errorf("encode\n got: %q\nwant: %q", s, g.encoded)
+		}
+		state := NewState()
+		if !state.Decode(g.encoded) || !reflect.DeepEqual(state, g.state) {
+			t.Errorf("decode %q: %+v", g.encoded, state)
+		}
+	}
+}
+
+func TestGoldenSnapshots(t *testing.T){
+	for _, g := range goldenSnapshots {
+		if s := g.sn.Encode(); s != g.encoded {
+			t.Errorf("encode\n got: %q\nwant: %q", s, g.encoded)
+		}
+		sn := NewSnapshotFromString(g.encoded)
+		if sn == nil || !reflect.DeepEqual(sn, g.sn) {
+			t.Errorf("decode %q: %+v", g.encoded, sn)
+		}
+		if sn := NewSnapshotFromString(g.legacy); sn == nil || !reflect.DeepEqual(sn, g.sn) {
+			t.Errorf("decode %q: %+v", g.legacy, sn)
+		}
+	}
+}
+
+// keys and values written to Db by a single node group
+func TestGoldenStorageLayout(t *testing.T){
+	logger.SetDefaultLevel(logger.LevelError)
+	db := NewMemDb()
+	node := NewNode("n1", "addr1", db)
+	node.AddMember("n1", "addr1")
+	node.StepTick(0)
+	node.Propose("set k v")
+	node.StepTick(0)
+
+	want := map[string]string{
+		// entries from it have checksums
+		"@ChecksumFrom": "1",
+		"@CommitIndex": "3",
+		"@LastApplied": "3",
+		// first, last index, last term, bytes of entries
+		"@LogMeta": "1 3 0 80",
+		"@State": `{"Term":0,"VoteFor":"","Members":{"n1":"addr1"}}`,
+		"log#001": "0 1 0 Noop#14c32803 ",
+		"log#002": "0 2 0 AddMember#0e44db8f n1 addr1",
+		"log#003": "0 3 2 Data#fe86b181 set k v",
+	}
+	if !reflect.DeepEqual(db.All(), want) {
+		t.Fatalf("got %q\nwant %q", db.All(), want)
+	}
+
+	// and loaded back
+	node = NewNode("n1", "addr1", db)
+	if m := node.Metrics(); m.CommitIndex != 3 || m.LastIndex != 3 || len(node.Members) != 0 {
+		t.Fatalf("bad load %+v", m)
+	}
+}
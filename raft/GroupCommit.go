@@ -0,0 +1,30 @@
+package raft
+
+// Group commit: entries and commitIndex written to db are not fsynced one by
+// one, but once for a batch - messages handled back to back, proposals
+// replicated in one round, or a tick. Proposals arriving while an fsync is
+// in progress are persisted by the next one together.
+//
+// Nothing is acked, or counted as durable by leader itself, before fsync:
+// followers fsync before sending AppendEntryAck, leader counts its own
+// entries up to durableIndex only. commitIndex may lag behind on disk, it is
+// only used to discard torn entries on startup, committed ones are durable.
+
+// fsync if anything is written since last fsync, and FsyncPolicy says so.
+// Otherwise written entries are counted as durable, and fsynced later.
+func (st *Storage)Sync(){
+	if !st.dirty {
+		return
+	}
+	if st.fsyncDue() {
+		st.Fsync()
+	} else {
+		st.durableIndex = st.LastIndex
+	}
+}
+
+// end of a batch of messages or a tick, see FsyncPolicy
+func (node *Node)flushBatch(){
+	node.flushAck()
+	node.store.Sync()
+}
@@ -0,0 +1,29 @@
+package raft
+
+import (
+	"testing"
+
+	"github.com/fallowu/big-ssdb/logger"
+)
+
+func TestGroupCommit(t *testing.T){
+	logger.SetDefaultLevel(logger.LevelError)
+	n1 := NewNode("n1", "addr1", NewMemDb())
+	n1.SetOutbox(func(msg *Message) {})
+	n1.AddMember("n1", "addr1")
+	n1.StepTick(0)
+
+	before := n1.Metrics()
+	// proposals arriving before the replication round
+	for i := 0; i < 10; i ++ {
+		n1.Propose("data")
+	}
+	n1.StepTick(0)
+	after := n1.Metrics()
+	if after.CommitIndex != before.CommitIndex + 10 {
+		t.Fatal("commit", before.CommitIndex, after.CommitIndex)
+	}
+	if n := after.Fsync.Count - before.Fsync.Count; n != 1 {
+		t.Fatal("fsyncs", n)
+	}
+}
@@ -0,0 +1,122 @@
+package raft
+
+import (
+	"testing"
+
+	"github.com/fallowu/big-ssdb/logger"
+)
+
+func TestHeartbeatPiggyback(t *testing.T){
+	logger.SetDefaultLevel(logger.LevelError)
+	var queue []*Message
+	outbox := func(msg *Message) {
+		cp := *msg
+		queue = append(queue, &cp)
+	}
+	n1 := NewNode("n1", "addr1", NewMemDb())
+	n2 := NewNode("n2", "addr2", NewMemDb())
+	nodes := map[string]*Node{"n1": n1, "n2": n2}
+	for _, n := range nodes {
+		n.SetOutbox(outbox)
+	}
+	n1.AddMember("n1", "addr1")
+	n1.StepTick(0)
+	n1.AddMember("n2", "addr2")
+	n2.JoinGroup("n1", "addr1")
+	for i := 0; i < 60; i ++ {
+		n1.StepTick(100)
+		n2.StepTick(100)
+		for len(queue) > 0 {
+			msg := queue[0]
+			queue = queue[1:]
+			nodes[msg.Dst].StepMessage(msg)
+		}
+	}
+
+	// entries sent are lost, to be resent
+	n1.Propose("data")
+	n1.StepTick(0)
+	queue = nil
+	n1.mux.Lock()
+	m := n1.Members["n2"]
+	m.NextIndex = m.MatchIndex + 1
+	m.HeartbeatTimer = HeartbeatTimeout - 100
+	m.ReplicateTimer = 0
+	n1.mux.Unlock()
+
+	n1.StepTick(100)
+	pings, entries := 0, 0
+	for _, msg := range queue {
+		if msg.Type != MessageTypeAppendEntry {
+			continue
+		}
+		if ent := DecodeEntry(msg.Data); ent.Type == EntryTypePing {
+			pings ++
+		} else {
+			entries ++
+		}
+	}
+	if pings != 0 || entries != 1 {
+		t.Fatal("pings", pings, "entries", entries)
+	}
+}
+
+func TestCommitNotify(t *testing.T){
+	logger.SetDefaultLevel(logger.LevelError)
+	var queue []*Message
+	outbox := func(msg *Message) {
+		cp := *msg
+		queue = append(queue, &cp)
+	}
+	nodes := make(map[string]*Node)
+	for _, id := range []string{"n1", "n2", "n3"} {
+		nodes[id] = NewNode(id, "addr" + id, NewMemDb())
+		nodes[id].SetOutbox(outbox)
+	}
+	run := func(ticks int) {
+		for i := 0; i < ticks; i ++ {
+			for _, n := range nodes {
+				n.StepTick(100)
+			}
+			for len(queue) > 0 {
+				msg := queue[0]
+				queue = queue[1:]
+				nodes[msg.Dst].StepMessage(msg)
+			}
+		}
+	}
+	n1 := nodes["n1"]
+	n1.AddMember("n1", "addrn1")
+	n1.StepTick(0)
+	n1.AddMember("n2", "addrn2")
+	nodes["n2"].JoinGroup("n1", "addrn1")
+	run(60)
+	n1.AddMember("n3", "addrn3")
+	nodes["n3"].JoinGroup("n1", "addrn1")
+	run(60)
+
+	_, idx, _ := n1.Propose("data")
+	n1.StepTick(0)
+	// deliver entries, then n2's ack only
+	for _, msg := range queue {
+		nodes[msg.Dst].StepMessage(msg)
+	}
+	acks := queue
+	queue = nil
+	for _, msg := range acks {
+		if msg.Src == "n2" {
+			n1.StepMessage(msg)
+		}
+	}
+	if n1.Metrics().CommitIndex != idx {
+		t.Fatal("not committed", n1.Metrics().CommitIndex, idx)
+	}
+	for _, msg := range queue {
+		if msg.Dst == "n3" {
+			if ent := DecodeEntry(msg.Data); ent != nil && ent.Type == EntryTypePing && ent.Commit == idx {
+				return
+			}
+		}
+	}
+	t.Fatal("commit not sent to n3")
+}
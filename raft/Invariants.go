@@ -0,0 +1,104 @@
+package raft
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/fallowu/big-ssdb/internal/util"
+)
+
+// What Node does when an invariant is violated
+type InvariantMode int
+
+const(
+	InvariantOff InvariantMode = iota
+	// panic, for tests and simulation
+	InvariantPanic
+	// log error and emit SinkInvariantViolated, keep running
+	InvariantAlert
+)
+
+func ParseInvariantMode(s string) (InvariantMode, error) {
+	switch s {
+	case "", "off":
+		return InvariantOff, nil
+	case "panic":
+		return InvariantPanic, nil
+	case "alert":
+		return InvariantAlert, nil
+	}
+	return InvariantOff, fmt.Errorf("bad invariant mode: %s", s)
+}
+
+// values seen at last check, protected by node.mux
+type invariantState struct{
+	term int32
+	commitIndex int64
+	lastApplied int64
+}
+
+// Check invariants after every state transition. Costs O(uncommitted entries)
+// per message and tick.
+func (node *Node)SetInvariantMode(mode InvariantMode){
+	node.mux.Lock()
+	defer node.mux.Unlock()
+	node.invariantMode = mode
+	node.resetInvariants()
+}
+
+// Raft database cleaned, previous values are no longer comparable
+func (node *Node)resetInvariants(){
+	node.invariants = invariantState{node.Term, node.store.CommitIndex, node.lastApplied}
+}
+
+// where is the transition checked, for the report
+func (node *Node)checkInvariants(where string){
+	if node.invariantMode == InvariantOff {
+		return
+	}
+	st := node.store
+	inv := &node.invariants
+	var errs []string
+	if node.Term < inv.term {
+		errs = append(errs, fmt.Sprintf("term decreased %d => %d", inv.term, node.Term))
+	}
+	if st.CommitIndex < inv.commitIndex {
+		errs = append(errs, fmt.Sprintf("commitIndex decreased %d => %d", inv.commitIndex, st.CommitIndex))
+	}
+	if node.lastApplied < inv.lastApplied {
+		errs = append(errs, fmt.Sprintf("lastApplied decreased %d => %d", inv.lastApplied, node.lastApplied))
+	}
+	if st.LastIndex < st.CommitIndex {
+		errs = append(errs, fmt.Sprintf("lastIndex %d < commitIndex %d", st.LastIndex, st.CommitIndex))
+	}
+	if node.lastApplied > st.CommitIndex {
+		errs = append(errs, fmt.Sprintf("lastApplied %d > commitIndex %d", node.lastApplied, st.CommitIndex))
+	}
+	// committed entries were checked when they were uncommitted
+	var prev *Entry
+	for idx := util.MaxInt64(st.FirstIndex, st.CommitIndex); idx <= st.LastIndex; idx ++ {
+		ent := st.GetEntry(idx)
+		if ent == nil {
+			continue
+		}
+		if prev != nil && ent.Term < prev.Term {
+			errs = append(errs, fmt.Sprintf("entry#%d term %d < entry#%d term %d", ent.Index, ent.Term, prev.Index, prev.Term))
+		}
+		if ent.Term > node.Term {
+			errs = append(errs, fmt.Sprintf("entry#%d term %d > term %d", ent.Index, ent.Term, node.Term))
+		}
+		prev = ent
+	}
+	node.invariants = invariantState{node.Term, st.CommitIndex, node.lastApplied}
+
+	if len(errs) == 0 {
+		return
+	}
+	detail := where + ": " + strings.Join(errs, "; ")
+	if node.invariantMode == InvariantPanic {
+		panic(fmt.Sprintf("raft node %s invariant violated, %s", node.Id, detail))
+	}
+	node.invariantViolations ++
+	node.log.Error("invariant violated", "at", where, "err", strings.Join(errs, "; "))
+	node.emitEvent(SinkInvariantViolated, "", st.CommitIndex, detail)
+}
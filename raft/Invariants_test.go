@@ -0,0 +1,41 @@
+package raft
+
+import (
+	"testing"
+
+	"github.com/fallowu/big-ssdb/logger"
+)
+
+func TestInvariants(t *testing.T){
+	logger.SetDefaultLevel(logger.LevelError)
+	node := NewNode("n1", "addr1", NewMemDb())
+	node.SetInvariantMode(InvariantPanic)
+	node.AddMember("n1", "addr1")
+	node.Propose("data")
+	node.StepTick(0)
+
+	// term goes backwards
+	node.Term -= 1
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatal("expected panic")
+			}
+		}()
+		node.StepTick(0)
+	}()
+
+	node.Term += 1
+
+	node.SetInvariantMode(InvariantAlert)
+	node.store.CommitIndex = node.store.LastIndex + 1
+	node.StepTick(0)
+	if n := node.Metrics().InvariantViolations; n != 1 {
+		t.Fatal("violations", n)
+	}
+	// counted once per check
+	node.StepTick(0)
+	if n := node.Metrics().InvariantViolations; n != 2 {
+		t.Fatal("violations", n)
+	}
+}
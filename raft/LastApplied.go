@@ -0,0 +1,36 @@
+package raft
+
+import (
+	"github.com/fallowu/big-ssdb/internal/util"
+)
+
+// "@LastApplied" is the last entry applied to Raft itself, written without
+// fsync and made durable by the next Fsync(). It may lag behind membership
+// saved in @State, entries after it are applied again on startup, which
+// addMember()/removeMember() make no change by.
+func (st *Storage)saveLastApplied(){
+	applied := st.node.LastApplied()
+	if applied == st.appliedSaved {
+		return
+	}
+	st.appliedSaved = applied
+	st.db.Set("@LastApplied", util.I64toa(applied))
+	st.dirty = true
+}
+
+// Data written by old versions has no @LastApplied, entries up to
+// CommitIndex were applied synchronously.
+func (st *Storage)loadLastApplied() int64 {
+	v := st.db.Get("@LastApplied")
+	if v == "" {
+		st.appliedSaved = st.CommitIndex
+		return st.CommitIndex
+	}
+	applied, ok := parseIndex(v)
+	if !ok {
+		st.log.Warn("bad @LastApplied, use commitIndex", "lastApplied", v, "commitIndex", st.CommitIndex)
+		applied = st.CommitIndex
+	}
+	st.appliedSaved = applied
+	return util.MinInt64(applied, st.CommitIndex)
+}
@@ -0,0 +1,43 @@
+package raft
+
+import (
+	"testing"
+
+	"github.com/fallowu/big-ssdb/logger"
+)
+
+// entries after @LastApplied are applied again on startup, without changes
+func TestLastAppliedRestart(t *testing.T){
+	logger.SetDefaultLevel(logger.LevelError)
+	db := NewMemDb()
+	n1 := NewNode("n1", "addr1", db)
+	n1.SetOutbox(func(msg *Message) {})
+	n1.AddMember("n1", "addr1")
+	n1.StepTick(0)
+	n1.Propose("data")
+	n1.StepTick(0)
+	if v := db.Get("@LastApplied"); v != "3" {
+		t.Fatal("@LastApplied", v)
+	}
+
+	// crashed before @LastApplied is synced
+	db.Set("@LastApplied", "1")
+	state := db.Get("@State")
+	n1 = NewNode("n1", "addr1", db)
+	if n1.LastApplied() != 1 {
+		t.Fatal("lastApplied", n1.LastApplied())
+	}
+	n1.StepStart()
+	if n1.LastApplied() != 3 || db.Get("@LastApplied") != "3" {
+		t.Fatal("lastApplied", n1.LastApplied(), db.Get("@LastApplied"))
+	}
+	if db.Get("@State") != state || len(n1.Members) != 0 {
+		t.Fatal("state", db.Get("@State"))
+	}
+
+	// old data
+	db.Delete("@LastApplied")
+	if n1 = NewNode("n1", "addr1", db); n1.LastApplied() != 3 {
+		t.Fatal("lastApplied", n1.LastApplied())
+	}
+}
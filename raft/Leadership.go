@@ -0,0 +1,48 @@
+package raft
+
+type leadershipChange struct{
+	obs LeadershipObserver
+	leader bool
+	term int32
+	commitIndex int64
+}
+
+// Queued with node's lock held, delivered in order by a goroutine which
+// exits when the queue is empty, so the Service may call into node from the
+// callbacks. The goroutine is not tied to the apply goroutine, a callback may
+// run before entries committed earlier are applied.
+func (node *Node)notifyLeadership(leader bool){
+	obs, ok := node.store.Service.(LeadershipObserver)
+	if !ok {
+		return
+	}
+	ch := leadershipChange{obs, leader, node.Term, node.store.CommitIndex}
+
+	node.leadershipMux.Lock()
+	defer node.leadershipMux.Unlock()
+	node.leadershipQ = append(node.leadershipQ, ch)
+	if !node.leadershipRunning {
+		node.leadershipRunning = true
+		go node.deliverLeadership()
+	}
+}
+
+func (node *Node)deliverLeadership(){
+	for {
+		node.leadershipMux.Lock()
+		if len(node.leadershipQ) == 0 {
+			node.leadershipRunning = false
+			node.leadershipMux.Unlock()
+			return
+		}
+		ch := node.leadershipQ[0]
+		node.leadershipQ = node.leadershipQ[1:]
+		node.leadershipMux.Unlock()
+
+		if ch.leader {
+			ch.obs.OnBecomeLeader(ch.term, ch.commitIndex)
+		} else {
+			ch.obs.OnLoseLeadership(ch.term, ch.commitIndex)
+		}
+	}
+}
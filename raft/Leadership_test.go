@@ -0,0 +1,46 @@
+package raft
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fallowu/big-ssdb/logger"
+)
+
+type leadershipService struct{
+	countingService
+	c chan string
+}
+
+func (svc *leadershipService)OnBecomeLeader(term int32, commitIndex int64) {
+	svc.c <- "leader"
+}
+
+func (svc *leadershipService)OnLoseLeadership(term int32, commitIndex int64) {
+	svc.c <- "lost"
+}
+
+func TestLeadershipObserver(t *testing.T){
+	logger.SetDefaultLevel(logger.LevelError)
+	n1 := NewNode("n1", "addr1", NewMemDb())
+	n1.SetOutbox(func(msg *Message) {})
+	svc := &leadershipService{c: make(chan string, 4)}
+	n1.SetService(svc)
+	n1.AddMember("n1", "addr1")
+	n1.StepTick(0)
+
+	n1.mux.Lock()
+	n1.becomeFollower()
+	n1.unlock()
+
+	for _, want := range []string{"leader", "lost"} {
+		select {
+		case got := <-svc.c:
+			if got != want {
+				t.Fatal("got", got, "want", want)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("no", want)
+		}
+	}
+}
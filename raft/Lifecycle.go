@@ -0,0 +1,74 @@
+package raft
+
+// Goroutines of Start()(ticker, communication, applier, replicators) exit
+// on Stop(), which waits for them. A stopped node keeps its state and
+// storage, and is started again by Start(). Close() stops the node for good.
+
+// with node's lock held, closed by Stop()
+func (node *Node)stopChan() chan struct{} {
+	if node.stopC == nil {
+		node.stopC = make(chan struct{})
+	}
+	return node.stopC
+}
+
+// registers a goroutine to be waited for by Stop()
+func (node *Node)startGoroutine() chan struct{} {
+	node.mux.Lock()
+	defer node.mux.Unlock()
+	node.goroutines.Add(1)
+	return node.stopChan()
+}
+
+// Cancel goroutines and wait for them to exit. Messages queued in RecvC(),
+// SendC() and replicators are dropped, raft resends what is not acked.
+func (node *Node)Stop(){
+	node.mux.Lock()
+	stop := node.stopC
+	node.stopC = nil
+	node.mux.Unlock()
+	if stop == nil {
+		return
+	}
+	close(stop)
+	// without lock, goroutines may be waiting for it
+	node.goroutines.Wait()
+
+	node.mux.Lock()
+	defer node.unlock()
+	for id := range node.replicators {
+		node.stopReplicator(id)
+	}
+	node.stopApplier()
+	for len(node.recv_c) > 0 {
+		<-node.recv_c
+	}
+	for len(node.send_c) > 0 {
+		<-node.send_c
+	}
+	for len(node.store.C) > 0 {
+		<-node.store.C
+	}
+	node.log.Info("stopped")
+}
+
+// with node's lock held, after the apply goroutine exits. Entries queued
+// are dispatched again when the applier is restarted
+func (node *Node)stopApplier(){
+	if node.applyC == nil {
+		return
+	}
+	for len(node.applyC) > 0 {
+		task := <-node.applyC
+		if task.span != nil {
+			task.span.End()
+		}
+		if task.restore && task.gen == node.applyGen {
+			// restored when the applier is restarted
+			node.applyRestore = task
+			node.applyRestoring = false
+		}
+	}
+	node.applyC = nil
+	node.applyQueued = node.serviceApplied
+}
@@ -0,0 +1,43 @@
+package raft
+
+import (
+	"context"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/fallowu/big-ssdb/logger"
+)
+
+func TestStopRestart(t *testing.T){
+	logger.SetDefaultLevel(logger.LevelError)
+	base := runtime.NumGoroutine()
+	n1 := NewNode("n1", "addr1", NewMemDb())
+	n1.SetOutbox(func(msg *Message) {})
+	n1.AddMember("n1", "addr1")
+
+	for i := 0; i < 3; i ++ {
+		n1.Start()
+		ctx, cancel := context.WithTimeout(context.Background(), 5 * time.Second)
+		if _, _, err := n1.ProposeContext(ctx, "data"); err != nil {
+			t.Fatal("round", i, "err", err)
+		}
+		cancel()
+		n1.Stop()
+
+		deadline := time.Now().Add(time.Second)
+		for runtime.NumGoroutine() > base {
+			if time.Now().After(deadline) {
+				t.Fatal("goroutines", runtime.NumGoroutine(), "base", base)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	// not committed while stopped
+	ctx, cancel := context.WithTimeout(context.Background(), 50 * time.Millisecond)
+	defer cancel()
+	if _, _, err := n1.ProposeContext(ctx, "data"); err != context.DeadlineExceeded {
+		t.Fatal("err", err)
+	}
+	n1.Close()
+}
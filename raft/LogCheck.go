@@ -0,0 +1,290 @@
+package raft
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"crypto/sha256"
+	"encoding/hex"
+
+	"github.com/fallowu/big-ssdb/internal/util"
+)
+
+const(
+	LogCheckTimeout = 3 * 1000
+	DefaultLogCheckSamples = 16
+	// at most this many positions per check, they are sent in one message
+	MaxLogCheckSamples = 256
+)
+
+// (term, hash) of the entry at a log position, as reported by a node.
+// Empty if the node does not have the entry.
+type LogPosition struct{
+	Term int32
+	Hash string
+}
+
+type LogCheckReport struct{
+	Indexes []int64
+	// leader's commitIndex when the check started
+	CommitIndex int64
+	Positions map[string]map[int64]LogPosition // nodeId => index => position
+	// nodeId => indexes where an entry differs from leader's. A divergent
+	// index <= CommitIndex is a safety violation, beyond it the member
+	// may be waiting for its uncommitted entries to be overwritten.
+	Divergent map[string][]int64
+	Missing []string // nodes not replying
+}
+
+func (r *LogCheckReport)Ok() bool {
+	return len(r.Divergent) == 0 && len(r.Missing) == 0
+}
+
+// Divergence at committed indexes
+func (r *LogCheckReport)Unsafe() bool {
+	for _, idxs := range r.Divergent {
+		if len(idxs) > 0 && idxs[0] <= r.CommitIndex {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *LogCheckReport)Encode() string {
+	ids := make([]string, 0, len(r.Positions))
+	for id, _ := range r.Positions {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	var ret string
+	ret += fmt.Sprintf("indexes: %d, commitIndex: %d\n", len(r.Indexes), r.CommitIndex)
+	for _, id := range ids {
+		idxs, ok := r.Divergent[id]
+		if !ok {
+			continue
+		}
+		ret += fmt.Sprintf("%s divergent:", id)
+		for _, idx := range idxs {
+			p := r.Positions[id][idx]
+			ret += fmt.Sprintf(" %d(term=%d)", idx, p.Term)
+		}
+		ret += "\n"
+	}
+	ret += fmt.Sprintf("divergent: %d\n", len(r.Divergent))
+	ret += fmt.Sprintf("missing: %s\n", strings.Join(r.Missing, " "))
+	if r.Unsafe() {
+		ret += "UNSAFE: committed entries differ\n"
+	}
+	return ret
+}
+
+type logCheck struct{
+	indexes []int64
+	commitIndex int64
+	timer int
+	positions map[string]map[int64]LogPosition
+	done chan *LogCheckReport
+}
+
+// Commit is leader's commitIndex when the entry was proposed, it is the
+// same on every node, but not part of what must agree.
+func entryHash(ent *Entry) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%d %d %s %s", ent.Term, ent.Index, ent.Type, ent.Data)
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+func (node *Node)logPositions(indexes []int64) map[int64]LogPosition {
+	ret := make(map[int64]LogPosition)
+	for _, idx := range indexes {
+		if ent := node.store.GetEntry(idx); ent != nil && node.witness {
+			// data stripped, only term is compared
+			ret[idx] = LogPosition{ent.Term, witnessHash}
+		} else if ent != nil {
+			ret[idx] = LogPosition{ent.Term, entryHash(ent)}
+		}
+	}
+	return ret
+}
+
+// Spread over the whole log, plus the tail, where conflicting entries are.
+func (node *Node)sampleLogIndexes(n int) []int64 {
+	st := node.store
+	first := st.FirstIndex
+	if st.logEntries() == 0 {
+		return []int64{}
+	}
+	seen := make(map[int64]bool)
+	ret := make([]int64, 0, n)
+	add := func(idx int64) {
+		if idx >= first && idx <= st.LastIndex && !seen[idx] && len(ret) < n {
+			seen[idx] = true
+			ret = append(ret, idx)
+		}
+	}
+	for i := 0; i < n/2; i ++ {
+		add(st.LastIndex - int64(i))
+	}
+	span := st.LastIndex - first + 1
+	rest := n - len(ret)
+	for i := 0; i < rest; i ++ {
+		add(first + int64(i) * span / int64(rest))
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i] < ret[j]
+	})
+	return ret
+}
+
+// Data: index term hash,...; absent entries are omitted
+func encodeLogPositions(ps map[int64]LogPosition) string {
+	idxs := make([]int64, 0, len(ps))
+	for idx, _ := range ps {
+		idxs = append(idxs, idx)
+	}
+	sort.Slice(idxs, func(i, j int) bool {
+		return idxs[i] < idxs[j]
+	})
+	arr := make([]string, 0, len(idxs))
+	for _, idx := range idxs {
+		p := ps[idx]
+		arr = append(arr, fmt.Sprintf("%d %d %s", idx, p.Term, p.Hash))
+	}
+	return strings.Join(arr, ",")
+}
+
+func decodeLogPositions(data string) map[int64]LogPosition {
+	ret := make(map[int64]LogPosition)
+	if data == "" {
+		return ret
+	}
+	for _, s := range strings.Split(data, ",") {
+		ps := strings.Split(s, " ")
+		if len(ps) != 3 {
+			return nil
+		}
+		idx, ok := parseIndex(ps[0])
+		if !ok {
+			return nil
+		}
+		term, ok := parseTerm(ps[1])
+		if !ok {
+			return nil
+		}
+		ret[idx] = LogPosition{term, ps[2]}
+	}
+	return ret
+}
+
+func (node *Node)handleLogHash(msg *Message){
+	indexes := make([]int64, 0)
+	for _, s := range strings.Fields(msg.Data) {
+		if idx, ok := parseIndex(s); ok && len(indexes) < MaxLogCheckSamples {
+			indexes = append(indexes, idx)
+		}
+	}
+	node.send(NewLogHashAck(msg.Src, encodeLogPositions(node.logPositions(indexes))))
+}
+
+func (node *Node)handleLogHashAck(msg *Message){
+	if node.logCheck == nil {
+		return
+	}
+	ps := decodeLogPositions(msg.Data)
+	if ps == nil {
+		node.log.Warn("bad LogHashAck", "peer", msg.Src)
+		return
+	}
+	node.logCheck.positions[msg.Src] = ps
+	node.checkLogCheckResult()
+}
+
+func (node *Node)checkLogCheckResult(){
+	if len(node.logCheck.positions) == len(node.Members) + 1 {
+		node.finishLogCheck()
+	}
+}
+
+func (node *Node)finishLogCheck(){
+	check := node.logCheck
+	node.logCheck = nil
+
+	report := new(LogCheckReport)
+	report.Indexes = check.indexes
+	report.CommitIndex = check.commitIndex
+	report.Positions = make(map[string]map[int64]LogPosition)
+	report.Divergent = make(map[string][]int64)
+	report.Missing = make([]string, 0)
+
+	ids := []string{node.Id}
+	for _, m := range node.Members {
+		ids = append(ids, m.Id)
+	}
+	sort.Strings(ids)
+
+	leader := check.positions[node.Id]
+	for _, id := range ids {
+		ps, ok := check.positions[id]
+		if !ok {
+			report.Missing = append(report.Missing, id)
+			continue
+		}
+		report.Positions[id] = ps
+		for _, idx := range check.indexes {
+			p, ok := ps[idx]
+			// a lagging member is not divergent
+			if ok && p != leader[idx] && !(p.Hash == witnessHash && p.Term == leader[idx].Term) {
+				report.Divergent[id] = append(report.Divergent[id], idx)
+			}
+		}
+	}
+	node.log.Info("log check finished", "indexes", len(report.Indexes),
+			"divergent", len(report.Divergent), "missing", report.Missing, "unsafe", report.Unsafe())
+
+	check.done <- report
+}
+
+/* ###################### Operations ####################### */
+
+// Leader samples n log positions, n <= 0 means DefaultLogCheckSamples, and
+// asks every member for the (term, hash) of its entries at them.
+// The report is delivered through the returned channel once all members
+// have replied, or on timeout, or when this node is no longer leader.
+func (node *Node)CheckLog(n int) <-chan *LogCheckReport {
+	node.mux.Lock()
+	defer node.mux.Unlock()
+
+	done := make(chan *LogCheckReport, 1)
+	if node.Role != RoleLeader {
+		node.log.Warn("not leader")
+		close(done)
+		return done
+	}
+	if node.logCheck != nil {
+		node.log.Warn("log check in progress")
+		close(done)
+		return done
+	}
+	if n <= 0 {
+		n = DefaultLogCheckSamples
+	}
+	n = util.MinInt(n, MaxLogCheckSamples)
+
+	node.logCheck = new(logCheck)
+	node.logCheck.indexes = node.sampleLogIndexes(n)
+	node.logCheck.commitIndex = node.store.CommitIndex
+	node.logCheck.positions = make(map[string]map[int64]LogPosition)
+	node.logCheck.done = done
+
+	node.logCheck.positions[node.Id] = node.logPositions(node.logCheck.indexes)
+	arr := make([]string, len(node.logCheck.indexes))
+	for i, idx := range node.logCheck.indexes {
+		arr[i] = util.I64toa(idx)
+	}
+	for _, m := range node.Members {
+		node.send(NewLogHashMsg(m.Id, strings.Join(arr, " ")))
+	}
+	node.checkLogCheckResult()
+	return done
+}
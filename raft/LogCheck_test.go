@@ -0,0 +1,75 @@
+package raft
+
+import (
+	"testing"
+
+	"github.com/fallowu/big-ssdb/logger"
+)
+
+func TestCheckLog(t *testing.T){
+	logger.SetDefaultLevel(logger.LevelError)
+	node := NewNode("n1", "addr1", NewMemDb())
+	node.AddMember("n1", "addr1")
+	for i := 0; i < 5; i ++ {
+		node.Propose("data")
+	}
+	node.StepTick(0)
+	var sent []*Message
+	node.SetOutbox(func(msg *Message) {
+		sent = append(sent, msg)
+	})
+	node.mux.Lock()
+	node.addMember("n2", "addr2")
+	node.mux.Unlock()
+
+	reply := func(change func(ps map[int64]LogPosition)) *LogCheckReport {
+		sent = nil
+		done := node.CheckLog(0)
+		var query *Message
+		for _, msg := range sent {
+			if msg.Type == MessageTypeLogHash {
+				query = msg
+			}
+		}
+		if query == nil {
+			t.Fatal("LogHash not sent")
+		}
+		// n2 answers with the same log as n1's
+		node.mux.Lock()
+		ps := node.logPositions(node.logCheck.indexes)
+		node.mux.Unlock()
+		change(ps)
+		ack := NewLogHashAck("n1", encodeLogPositions(ps))
+		ack.Src = "n2"
+		ack.Term = node.Term
+		node.StepMessage(ack)
+		return <-done
+	}
+
+	r := reply(func(ps map[int64]LogPosition) {})
+	if !r.Ok() || int64(len(r.Indexes)) != node.store.LastIndex {
+		t.Fatal(r.Encode())
+	}
+	// lagging member
+	r = reply(func(ps map[int64]LogPosition) {
+		delete(ps, node.store.LastIndex)
+	})
+	if !r.Ok() {
+		t.Fatal(r.Encode())
+	}
+	// conflicting committed entry
+	r = reply(func(ps map[int64]LogPosition) {
+		ps[3] = LogPosition{ps[3].Term + 1, "x"}
+	})
+	if r.Ok() || !r.Unsafe() || len(r.Divergent["n2"]) != 1 || r.Divergent["n2"][0] != 3 {
+		t.Fatal(r.Encode())
+	}
+
+	// no reply
+	done := node.CheckLog(0)
+	node.StepTick(LogCheckTimeout)
+	r = <-done
+	if len(r.Missing) != 1 || r.Missing[0] != "n2" {
+		t.Fatal(r.Encode())
+	}
+}
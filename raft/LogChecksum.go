@@ -0,0 +1,53 @@
+package raft
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/fallowu/big-ssdb/internal/util"
+)
+
+// "@ChecksumFrom" is the first index of entries written with checksums, the
+// LastIndex + 1 of the first startup of a version with them. Entries before
+// it, written by old versions, may have no checksum and are counted, one
+// from it without checksum is rejected like a torn or corrupted record.
+
+var errNoChecksum = fmt.Errorf("no checksum: %w", ErrCorrupted)
+
+// before entries are loaded, all of a db without it are of old versions
+func (st *Storage)loadChecksumFrom(){
+	st.checksumFrom = math.MaxInt64
+	if v := st.db.Get("@ChecksumFrom"); v != "" {
+		if idx, ok := parseIndex(v); ok {
+			st.checksumFrom = idx
+		} else {
+			st.log.Warn("bad checksum boundary, ignored", "value", v)
+		}
+	}
+}
+
+// after entries are loaded
+func (st *Storage)saveChecksumFrom(){
+	if st.checksumFrom != math.MaxInt64 {
+		return
+	}
+	st.checksumFrom = st.LastIndex + 1
+	st.db.Set("@ChecksumFrom", util.I64toa(st.checksumFrom))
+	st.Fsync()
+}
+
+// record v of the entry at index, read from db or LogDb
+func (st *Storage)decodeRecord(ent *Entry, index int64, v string) error {
+	verified, err := ent.decodeVerified(v)
+	if err != nil || verified {
+		return err
+	}
+	if index >= st.checksumFrom {
+		return errNoChecksum
+	}
+	st.unverified ++
+	if st.unverified == 1 {
+		st.log.Warn("entry without checksum, written by an old version", "index", index)
+	}
+	return nil
+}
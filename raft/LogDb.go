@@ -0,0 +1,161 @@
+package raft
+
+import (
+	"fmt"
+	"math"
+)
+
+// Where log entries are persisted instead of "log#" keys of Db, see
+// WithLogDb() and store.LogStore. Records are encoded entries of
+// continuous indexes. Db keeps state, @CommitIndex and @LastApplied, and
+// is fsynced after LogDb, so @CommitIndex never runs ahead of durable
+// entries.
+type LogDb interface{
+	Close()
+	// An error halts the node, see Fault.go
+	Fsync() error
+	// 0 if empty
+	FirstIndex() int64
+	// 0 if empty
+	LastIndex() int64
+	// "" if absent
+	Get(index int64) (string, error)
+	// records in [lo, hi] in order, fn returns false to stop
+	Iterate(lo int64, hi int64, fn func(index int64, record string) bool) error
+	// records of index, index+1..., index is LastIndex()+1, or any on an
+	// empty log
+	Append(index int64, records []string) error
+	// deletes records from index on
+	TruncateSuffix(index int64) error
+	// may delete records before index, by whole files
+	TruncatePrefix(index int64) error
+	CleanAll() error
+	// bytes on disk
+	Size() int64
+}
+
+// Entries are kept in ldb, those in db written by old versions are moved to
+// it on startup.
+func WithLogDb(ldb LogDb) Option {
+	return func(opts *options) {
+		opts.logDb = ldb
+	}
+}
+
+// Key of an entry in db without LogDb. Not in index order past 999, kept
+// for data written by old versions.
+func logKey(index int64) string {
+	return fmt.Sprintf("log#%03d", index)
+}
+
+// encoded entry in ldb or db
+func (st *Storage)logRecord(index int64) (string, error) {
+	if st.ldb != nil {
+		return st.ldb.Get(index)
+	}
+	return st.db.Get(logKey(index)), nil
+}
+
+// Writes records of entries from index on, to ldb, or to db with b
+func (st *Storage)putLog(b *Batch, index int64, records []string) {
+	if st.ldb != nil {
+		if err := st.ldb.Append(index, records); err != nil {
+			st.fail(fmt.Errorf("append entry#%d: %w", index, err))
+		}
+		st.logBytes = st.ldb.Size()
+		return
+	}
+	for i, data := range records {
+		b.Set(logKey(index + int64(i)), data)
+		st.logBytes += int64(len(data))
+	}
+}
+
+// Deletes entries from index to LastIndex, from ldb, or from db with b
+func (st *Storage)deleteLog(b *Batch, index int64) {
+	if st.ldb != nil {
+		if err := st.ldb.TruncateSuffix(index); err != nil {
+			st.fail(fmt.Errorf("truncate from entry#%d: %w", index, err))
+		}
+		st.logBytes = st.ldb.Size()
+		return
+	}
+	for idx := index; idx <= st.LastIndex; idx ++ {
+		k := logKey(idx)
+		st.logBytes -= int64(len(st.db.Get(k)))
+		b.Delete(k)
+	}
+}
+
+func (st *Storage)loadLogDb() {
+	if st.ldb.LastIndex() == 0 && st.hasDbLog() {
+		st.migrateLog()
+	}
+	st.recovery.Source = "log db"
+	st.FirstIndex = math.MaxInt64
+	st.LastIndex = 0
+	if last := st.ldb.LastIndex(); last > 0 {
+		st.FirstIndex = st.ldb.FirstIndex()
+		st.LastIndex = last
+	}
+	st.logBytes = st.ldb.Size()
+	st.loadTail()
+}
+
+func (st *Storage)hasDbLog() bool {
+	found := false
+	st.db.Iterate("log#", PrefixEnd("log#"), func(k string, v string) bool {
+		found = true
+		return false
+	})
+	return found
+}
+
+// Entries in db written by old versions are loaded as before, written to
+// ldb, then deleted from db.
+func (st *Storage)migrateLog() {
+	ldb := st.ldb
+	st.ldb = nil
+	if st.loadLogMeta() {
+		st.loadTail()
+	} else {
+		st.scanEntries()
+	}
+	st.ldb = ldb
+	st.log.Info("move entries to log db", "first", st.FirstIndex, "last", st.LastIndex)
+
+	var records []string
+	for idx := st.FirstIndex; idx <= st.LastIndex; idx ++ {
+		records = append(records, st.db.Get(logKey(idx)))
+	}
+	if len(records) > 0 {
+		if err := ldb.Append(st.FirstIndex, records); err != nil {
+			st.fail(fmt.Errorf("move entries: %w", err))
+			return
+		}
+		if err := ldb.Fsync(); err != nil {
+			st.fail(fmt.Errorf("move entries: %w", err))
+			return
+		}
+	}
+	b := NewBatch()
+	st.db.Iterate("log#", PrefixEnd("log#"), func(k string, v string) bool {
+		b.Delete(k)
+		return true
+	})
+	b.Delete("@LogMeta")
+	st.db.WriteBatch(b)
+	// fsyncs ldb too, entries are loaded from it again
+	st.Fsync()
+}
+
+// all entries deleted, with db.CleanAll()
+func (st *Storage)cleanLogDb() {
+	if st.ldb == nil {
+		return
+	}
+	if err := st.ldb.CleanAll(); err != nil {
+		st.fail(fmt.Errorf("clean log db: %w", err))
+	}
+	st.logBytes = 0
+}
@@ -0,0 +1,209 @@
+package raft
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/fallowu/big-ssdb/internal/util"
+)
+
+// "@LogMeta" is "<firstIndex> <lastIndex> <lastTerm> <logBytes>", written
+// with entries, so that startup does not scan the whole log. Not used with
+// LogDb.
+func (st *Storage)saveLogMeta(){
+	if st.ldb == nil {
+		st.db.Set("@LogMeta", st.logMeta())
+	}
+}
+
+func (st *Storage)putLogMeta(b *Batch){
+	if st.ldb == nil {
+		b.Set("@LogMeta", st.logMeta())
+	}
+}
+
+func (st *Storage)logMeta() string {
+	first := st.FirstIndex
+	if first == math.MaxInt64 {
+		first = 0
+	}
+	return fmt.Sprintf("%d %d %d %d", first, st.LastIndex, st.LastTerm, st.logBytes)
+}
+
+func (st *Storage)loadLogMeta() bool {
+	ps := strings.Split(st.db.Get("@LogMeta"), " ")
+	if len(ps) != 4 {
+		return false
+	}
+	first, ok1 := parseIndex(ps[0])
+	last, ok2 := parseIndex(ps[1])
+	lastTerm, ok3 := parseTerm(ps[2])
+	logBytes, ok4 := parseIndex(ps[3])
+	if !ok1 || !ok2 || !ok3 || !ok4 {
+		st.log.Warn("bad log meta, scan entries", "meta", st.db.Get("@LogMeta"))
+		return false
+	}
+	st.FirstIndex = first
+	if first == 0 {
+		st.FirstIndex = math.MaxInt64
+	}
+	st.LastIndex = last
+	st.LastTerm = lastTerm
+	st.logBytes = logBytes
+	return true
+}
+
+// Entries after commitIndex, may be torn by a crash, are read and checked the
+// same way as scanEntries() does. So are ones written after @LogMeta.
+func (st *Storage)loadTail(){
+	savedCommit := int64(-1)
+	if v := st.db.Get("@CommitIndex"); v != "" {
+		savedCommit = util.Atoi64(v)
+	}
+	// written before @LogMeta is
+	for st.ldb == nil && st.db.Get(logKey(st.LastIndex + 1)) != "" {
+		st.LastIndex ++
+		st.FirstIndex = util.MinInt64(st.FirstIndex, st.LastIndex)
+	}
+
+	start := util.MaxInt64(savedCommit + 1, st.FirstIndex)
+	torn := int64(math.MaxInt64)
+	st.recovery.CommitIndex = savedCommit
+	st.recovery.Checked = 0
+	for idx := start; idx <= st.LastIndex; idx ++ {
+		v, err := st.logRecord(idx)
+		ent := new(Entry)
+		if err == nil {
+			err = st.decodeRecord(ent, idx, v)
+		}
+		if err != nil || ent.Index != idx {
+			reason := tornReason(idx, v, ent, err)
+			if savedCommit < 0 {
+				st.fail(fmt.Errorf("bad entry#%d: %s", idx, reason))
+			}
+			st.log.Warn("discard torn uncommitted entry", "index", idx, "commitIndex", savedCommit, "reason", reason)
+			st.recordTorn(idx, reason)
+			torn = idx
+			break
+		}
+		st.recovery.Checked ++
+		st.entries.put(ent, false)
+	}
+	if torn != math.MaxInt64 {
+		st.recovery.Discarded += st.LastIndex - torn + 1
+		discard := NewBatch()
+		st.deleteLog(discard, torn)
+		for idx := torn; idx <= st.LastIndex; idx ++ {
+			st.entries.remove(idx)
+		}
+		if discard.Len() > 0 {
+			st.db.WriteBatch(discard)
+		}
+		st.LastIndex = torn - 1
+		if st.LastIndex < st.FirstIndex {
+			st.FirstIndex = math.MaxInt64
+		}
+		st.Fsync()
+	}
+
+	st.LastTerm = 0
+	if ent := st.GetEntry(st.LastIndex); ent != nil {
+		st.LastTerm = ent.Term
+	}
+	st.CommitIndex = st.LastIndex
+	if savedCommit >= 0 {
+		st.CommitIndex = util.MinInt64(savedCommit, st.LastIndex)
+	}
+}
+
+// entries in log, not all of them are in memory
+func (st *Storage)logEntries() int64 {
+	if st.FirstIndex > st.LastIndex {
+		return 0
+	}
+	return st.LastIndex - st.FirstIndex + 1
+}
+
+// committed entry not in memory, nil if index is out of log
+func (st *Storage)loadEntry(index int64) *Entry {
+	if index < st.FirstIndex || index > st.LastIndex {
+		return nil
+	}
+	v, err := st.logRecord(index)
+	ent := new(Entry)
+	if err == nil {
+		err = st.decodeRecord(ent, index, v)
+	}
+	if err == nil && ent.Index != index {
+		err = errBadFormat
+	}
+	if err != nil {
+		st.fail(fmt.Errorf("bad entry#%d in db: %w", index, err))
+		return nil
+	}
+	st.entries.misses ++
+	st.entries.put(ent, false)
+	return ent
+}
+
+// committed entries in [lo, hi] not in memory, read by a range scan
+func (st *Storage)scanLog(lo int64, hi int64) []*Entry {
+	var ret []*Entry
+	var err error
+	fn := func(index int64, v string) bool {
+		ent := new(Entry)
+		if err = st.decodeRecord(ent, index, v); err == nil && ent.Index != lo + int64(len(ret)) {
+			err = errBadFormat
+		}
+		if err != nil {
+			err = fmt.Errorf("bad entry#%d in db: %w", index, err)
+			return false
+		}
+		ret = append(ret, ent)
+		return true
+	}
+	if st.ldb != nil {
+		if e := st.ldb.Iterate(lo, hi, fn); e != nil && err == nil {
+			err = fmt.Errorf("scan entries [%d, %d]: %w", lo, hi, e)
+		}
+	} else {
+		st.iterateDbLog(lo, hi, fn)
+	}
+	if err != nil {
+		st.fail(err)
+		return nil
+	}
+	st.entries.misses += int64(len(ret))
+	for _, ent := range ret {
+		st.entries.put(ent, false)
+	}
+	return ret
+}
+
+// Keys of db are "log#%03d", in index order among those of the same length
+// only, so [lo, hi] is scanned by ranges of one length each.
+func (st *Storage)iterateDbLog(lo int64, hi int64, fn func(index int64, v string) bool) {
+	for lo <= hi {
+		n := len(logKey(lo))
+		// the last index of keys as long
+		end := int64(1)
+		for i := len("log#"); i < n; i ++ {
+			end *= 10
+		}
+		end = util.MinInt64(hi, end - 1)
+		ok := true
+		st.db.Iterate(logKey(lo), logKey(end) + "\x00", func(k string, v string) bool {
+			if len(k) != n {
+				return true
+			}
+			idx, _ := parseIndex(strings.TrimPrefix(k, "log#"))
+			ok = fn(idx, v)
+			return ok
+		})
+		if !ok {
+			return
+		}
+		lo = end + 1
+	}
+}
@@ -0,0 +1,77 @@
+package raft
+
+import (
+	"testing"
+
+	"github.com/fallowu/big-ssdb/logger"
+)
+
+// n1 leads n2, n3 and goes down, returns the new leader, its term and ticks
+// it took
+func runManualElection(t *testing.T, seed int64) (string, int32, int) {
+	var queue []*Message
+	outbox := func(msg *Message) {
+		cp := *msg
+		queue = append(queue, &cp)
+	}
+	nodes := make(map[string]*Node)
+	for i, id := range []string{"n1", "n2", "n3"} {
+		clock := NewManualClock()
+		n := NewNode(id, "addr-" + id, NewMemDb(), WithClock(clock), WithManualTick(), WithRandSeed(seed + int64(i)))
+		n.SetOutbox(outbox)
+		nodes[id] = n
+	}
+	down := ""
+	pump := func() {
+		for len(queue) > 0 {
+			msg := queue[0]
+			queue = queue[1:]
+			if msg.Src != down && msg.Dst != down {
+				nodes[msg.Dst].StepMessage(msg)
+			}
+		}
+	}
+	tick := func() {
+		for _, id := range []string{"n1", "n2", "n3"} {
+			nodes[id].ManualTick(100)
+		}
+		pump()
+	}
+	n1 := nodes["n1"]
+	n1.AddMember("n1", "addr-n1")
+	n1.ManualTick(0)
+	for _, id := range []string{"n2", "n3"} {
+		n1.AddMember(id, "addr-" + id)
+		nodes[id].JoinGroup("n1", "addr-n1")
+		for i := 0; i < 60; i ++ {
+			tick()
+		}
+	}
+	if n := len(n1.Metrics().Members); n != 2 {
+		t.Fatal("members", n)
+	}
+
+	down = "n1"
+	for ticks := 1; ticks < 1000; ticks ++ {
+		tick()
+		for _, id := range []string{"n2", "n3"} {
+			if m := nodes[id].Metrics(); m.Role == RoleLeader {
+				return id, m.Term, ticks
+			}
+		}
+	}
+	t.Fatal("no leader")
+	return "", 0, 0
+}
+
+func TestManualTick(t *testing.T){
+	logger.SetDefaultLevel(logger.LevelError)
+	leader, term, ticks := runManualElection(t, 1)
+	// the same seed, the same election
+	for i := 0; i < 3; i ++ {
+		l, tm, n := runManualElection(t, 1)
+		if l != leader || tm != term || n != ticks {
+			t.Fatal("run", i, l, tm, n, "first", leader, term, ticks)
+		}
+	}
+}
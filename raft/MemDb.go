@@ -0,0 +1,72 @@
+package raft
+
+import (
+	"github.com/fallowu/big-ssdb/internal/util"
+)
+
+// Db in memory, no disk I/O, for tests and replicated caches. Data lives as
+// long as the MemDb. A member restarting with a new one has lost its log
+// and its vote, it is removed by DelMember() and added again, then installs
+// a snapshot from the leader as a new member does.
+type MemDb struct{
+	mm map[string]string
+}
+
+func NewMemDb() *MemDb {
+	return &MemDb{make(map[string]string)}
+}
+
+func (db *MemDb)Close(){
+}
+
+func (db *MemDb)Fsync() error {
+	return nil
+}
+
+func (db *MemDb)Get(key string) string {
+	return db.mm[key]
+}
+
+func (db *MemDb)Set(key string, val string) {
+	db.mm[key] = val
+}
+
+func (db *MemDb)Delete(key string) {
+	delete(db.mm, key)
+}
+
+func (db *MemDb)WriteBatch(b *Batch) {
+	for _, op := range b.Ops {
+		if op.Delete {
+			delete(db.mm, op.Key)
+		} else {
+			db.mm[op.Key] = op.Val
+		}
+	}
+}
+
+// keys are sorted on every call
+func (db *MemDb)Iterate(start string, end string, fn func(key string, val string) bool) {
+	util.IterateMap(db.mm, start, end, fn)
+}
+
+func (db *MemDb)ApproximateSize(start string, end string) int64 {
+	return util.MapRangeSize(db.mm, start, end)
+}
+
+// a copy
+func (db *MemDb)All() map[string]string {
+	ret := make(map[string]string, len(db.mm))
+	for k, v := range db.mm {
+		ret[k] = v
+	}
+	return ret
+}
+
+func (db *MemDb)Len() int {
+	return len(db.mm)
+}
+
+func (db *MemDb)CleanAll(){
+	db.mm = make(map[string]string)
+}
@@ -0,0 +1,52 @@
+package raft
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/fallowu/big-ssdb/logger"
+)
+
+// a member restarting with a new MemDb rejoins by a snapshot
+func TestMemDbRestart(t *testing.T){
+	logger.SetDefaultLevel(logger.LevelError)
+	s := newMemSim(t, 1, "n1", "n2", "n3")
+	n1 := s.nodes["n1"]
+	n1.AddMember("n1", "addr-n1")
+	n1.StepTick(0)
+	for _, id := range []string{"n2", "n3"} {
+		index, _ := n1.AddMember(id, "addr-" + id)
+		node := s.nodes[id]
+		node.JoinGroup("n1", "addr-n1")
+		s.until(10 * time.Second, "join " + id, func() bool {
+			return node.Metrics().CommitIndex >= index
+		})
+	}
+	for i := 0; i < 10; i ++ {
+		n1.Propose(fmt.Sprintf("set k%d v", i))
+		s.step()
+	}
+	s.until(10 * time.Second, "replicate", func() bool {
+		return s.converged(n1.Metrics().LastIndex)
+	})
+
+	// removed and added again, as a new member
+	index, _ := n1.DelMember("n3")
+	s.until(10 * time.Second, "del n3", func() bool {
+		return n1.Metrics().CommitIndex >= index
+	})
+	s.nodes["n3"].Close()
+	db := NewMemDb()
+	n3 := NewNode("n3", "addr-n3", db, WithClock(s.clock), WithManualTick(), WithRandSeed(3))
+	n3.SetOutbox(func(msg *Message) { s.tps["n3"].Send(msg) })
+	s.nodes["n3"] = n3
+	index, _ = n1.AddMember("n3", "addr-n3")
+	n3.JoinGroup("n1", "addr-n1")
+	s.until(10 * time.Second, "catch up", func() bool {
+		return s.converged(index)
+	})
+	if n3.Err() != nil || db.Get("@CommitIndex") == "" {
+		t.Fatal(n3.Err(), db.Len())
+	}
+}
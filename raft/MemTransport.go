@@ -0,0 +1,311 @@
+package raft
+
+import (
+	"hash/fnv"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+// In-process network of MemTransports, for multi-node tests and simulation.
+// Links between nodes delay, drop, duplicate and reorder messages by a
+// rand seeded per link, so that the order nodes send to their peers in
+// doesn't matter. Messages with delay are delivered by timers of the clock,
+// with a ManualClock on its Advance(), in time and sending order, so runs
+// driven by StepTick()/StepMessage() in one goroutine are reproducible.
+// Messages to a full C() are dropped, as UDP would do.
+
+const DefaultMemQueueSize = 1024
+
+// Behavior of a link(src => dst)
+type MemLinkConfig struct{
+	Delay time.Duration
+	// extra delay, random in [0, Jitter)
+	Jitter time.Duration
+	// chances of each message, 0 ~ 1
+	DropRate float64
+	DuplicateRate float64
+	// held and delivered after the next message of the link
+	ReorderRate float64
+}
+
+type MemNetworkStats struct{
+	Sent int64
+	Dropped int64
+	Duplicated int64
+	Reordered int64
+}
+
+type MemNetwork struct{
+	clock Clock
+	seed int64
+	mux sync.Mutex
+	def MemLinkConfig
+	// [src, dst] => config, overrides def
+	links map[[2]string]MemLinkConfig
+	cut map[[2]string]bool
+	rands map[[2]string]*rand.Rand
+	// addr => transport
+	transports map[string]*MemTransport
+	// [src, dst] => message held by ReorderRate
+	held map[[2]string]*memPacket
+	pending []*memPacket
+	seq int64
+	stats MemNetworkStats
+}
+
+type memPacket struct{
+	at time.Time
+	seq int64
+	addr string
+	msg *Message
+}
+
+// nil clock for SystemClock
+func NewMemNetwork(seed int64, clock Clock) *MemNetwork {
+	if clock == nil {
+		clock = SystemClock
+	}
+	n := new(MemNetwork)
+	n.clock = clock
+	n.seed = seed
+	n.rands = make(map[[2]string]*rand.Rand)
+	n.links = make(map[[2]string]MemLinkConfig)
+	n.cut = make(map[[2]string]bool)
+	n.transports = make(map[string]*MemTransport)
+	n.held = make(map[[2]string]*memPacket)
+	return n
+}
+
+// Transport listening on addr, replacing the one on addr if any
+func (n *MemNetwork)Transport(addr string) *MemTransport {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+	tp := &MemTransport{
+		net: n,
+		addr: addr,
+		c: make(chan *Message, DefaultMemQueueSize),
+		peers: make(map[string]string),
+	}
+	if old := n.transports[addr]; old != nil {
+		old.close()
+	}
+	n.transports[addr] = tp
+	return tp
+}
+
+// Config of links without their own
+func (n *MemNetwork)SetDefault(conf MemLinkConfig) {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+	n.def = conf
+}
+
+// Config of link src => dst, by node ids
+func (n *MemNetwork)SetLink(src string, dst string, conf MemLinkConfig) {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+	n.links[[2]string{src, dst}] = conf
+}
+
+// Drop messages from src to dst, also those in flight
+func (n *MemNetwork)Cut(src string, dst string) {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+	n.cut[[2]string{src, dst}] = true
+}
+
+// Cut links of id to and from all of peers
+func (n *MemNetwork)Isolate(id string, peers ...string) {
+	for _, p := range peers {
+		n.Cut(id, p)
+		n.Cut(p, id)
+	}
+}
+
+// Restore all cut links
+func (n *MemNetwork)Heal() {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+	n.cut = make(map[[2]string]bool)
+}
+
+func (n *MemNetwork)Stats() MemNetworkStats {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+	return n.stats
+}
+
+func (n *MemNetwork)link(src string, dst string) MemLinkConfig {
+	if conf, ok := n.links[[2]string{src, dst}]; ok {
+		return conf
+	}
+	return n.def
+}
+
+func (n *MemNetwork)rand(pair [2]string) *rand.Rand {
+	r := n.rands[pair]
+	if r == nil {
+		h := fnv.New64a()
+		h.Write([]byte(pair[0] + "\x00" + pair[1]))
+		r = rand.New(rand.NewSource(n.seed ^ int64(h.Sum64())))
+		n.rands[pair] = r
+	}
+	return r
+}
+
+func chance(r *rand.Rand, rate float64) bool {
+	return rate > 0 && r.Float64() < rate
+}
+
+func (n *MemNetwork)send(addr string, msg *Message) {
+	n.mux.Lock()
+	n.stats.Sent ++
+	pair := [2]string{msg.Src, msg.Dst}
+	conf := n.link(msg.Src, msg.Dst)
+	r := n.rand(pair)
+	if n.cut[pair] || chance(r, conf.DropRate) {
+		n.stats.Dropped ++
+		n.mux.Unlock()
+		return
+	}
+	copies := 1
+	if chance(r, conf.DuplicateRate) {
+		n.stats.Duplicated ++
+		copies = 2
+	}
+	// a message held goes after this one
+	held := n.held[pair]
+	delete(n.held, pair)
+	var delays []time.Duration
+	for i := 0; i < copies; i ++ {
+		d := conf.Delay
+		if conf.Jitter > 0 {
+			d += time.Duration(r.Int63n(int64(conf.Jitter)))
+		}
+		// copied, the sender may reuse msg
+		cp := *msg
+		n.seq ++
+		p := &memPacket{at: n.clock.Now().Add(d), seq: n.seq, addr: addr, msg: &cp}
+		if i == 0 && chance(r, conf.ReorderRate) {
+			n.stats.Reordered ++
+			n.held[pair] = p
+			continue
+		}
+		n.pending = append(n.pending, p)
+		delays = append(delays, d)
+	}
+	if held != nil {
+		n.seq ++
+		held.seq = n.seq
+		held.at = n.clock.Now().Add(conf.Delay)
+		n.pending = append(n.pending, held)
+		delays = append(delays, conf.Delay)
+	}
+	n.mux.Unlock()
+
+	for _, d := range delays {
+		if d <= 0 {
+			n.Deliver()
+		} else {
+			n.clock.AfterFunc(d, func() { n.Deliver() })
+		}
+	}
+}
+
+// Delivers messages due by now, returns the number delivered. Called by
+// timers, or by tests with their own schedule.
+func (n *MemNetwork)Deliver() int {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+	now := n.clock.Now()
+	var due []*memPacket
+	rest := n.pending[:0]
+	for _, p := range n.pending {
+		if p.at.After(now) {
+			rest = append(rest, p)
+		} else {
+			due = append(due, p)
+		}
+	}
+	n.pending = rest
+	sort.Slice(due, func(i, j int) bool {
+		if !due[i].at.Equal(due[j].at) {
+			return due[i].at.Before(due[j].at)
+		}
+		return due[i].seq < due[j].seq
+	})
+
+	ret := 0
+	for _, p := range due {
+		tp := n.transports[p.addr]
+		if tp == nil || n.cut[[2]string{p.msg.Src, p.msg.Dst}] {
+			n.stats.Dropped ++
+			continue
+		}
+		select {
+		case tp.c <- p.msg:
+			ret ++
+		default:
+			n.stats.Dropped ++
+		}
+	}
+	return ret
+}
+
+// Transport of a MemNetwork. Thread safe.
+type MemTransport struct{
+	net *MemNetwork
+	addr string
+	c chan *Message
+	// node id => addr, guarded by net.mux
+	peers map[string]string
+}
+
+func (tp *MemTransport)Addr() string {
+	return tp.addr
+}
+
+func (tp *MemTransport)C() chan *Message {
+	return tp.c
+}
+
+// C() is closed, messages to addr are dropped
+func (tp *MemTransport)Close() {
+	tp.net.mux.Lock()
+	defer tp.net.mux.Unlock()
+	if tp.net.transports[tp.addr] == tp {
+		delete(tp.net.transports, tp.addr)
+		tp.close()
+	}
+}
+
+// with net.mux held
+func (tp *MemTransport)close() {
+	close(tp.c)
+}
+
+func (tp *MemTransport)Connect(nodeId string, addr string) {
+	tp.net.mux.Lock()
+	defer tp.net.mux.Unlock()
+	tp.peers[nodeId] = addr
+}
+
+func (tp *MemTransport)Disconnect(nodeId string) {
+	tp.net.mux.Lock()
+	defer tp.net.mux.Unlock()
+	delete(tp.peers, nodeId)
+}
+
+// false if dst is not connected, lost messages return true
+func (tp *MemTransport)Send(msg *Message) bool {
+	tp.net.mux.Lock()
+	addr, ok := tp.peers[msg.Dst]
+	tp.net.mux.Unlock()
+	if !ok {
+		return false
+	}
+	tp.net.send(addr, msg)
+	return true
+}
@@ -0,0 +1,200 @@
+package raft
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/fallowu/big-ssdb/logger"
+)
+
+// nodes on a MemNetwork driven in the test goroutine
+type memSim struct{
+	t *testing.T
+	ids []string
+	clock *ManualClock
+	net *MemNetwork
+	nodes map[string]*Node
+	tps map[string]*MemTransport
+}
+
+func newMemSim(t *testing.T, seed int64, ids ...string) *memSim {
+	s := &memSim{t: t, ids: ids, clock: NewManualClock(), nodes: map[string]*Node{}, tps: map[string]*MemTransport{}}
+	s.net = NewMemNetwork(seed, s.clock)
+	for i, id := range ids {
+		tp := s.net.Transport("addr-" + id)
+		for _, peer := range ids {
+			tp.Connect(peer, "addr-" + peer)
+		}
+		n := NewNode(id, tp.Addr(), NewMemDb(), WithClock(s.clock), WithManualTick(), WithRandSeed(seed + int64(i)))
+		n.SetOutbox(func(msg *Message) { tp.Send(msg) })
+		s.nodes[id] = n
+		s.tps[id] = tp
+	}
+	return s
+}
+
+// 10ms of time: ticks nodes, delivers messages due and handles them
+func (s *memSim)step() {
+	for _, id := range s.ids {
+		s.nodes[id].StepTick(10)
+	}
+	s.clock.Advance(10 * time.Millisecond)
+	for more := true; more; {
+		more = false
+		for _, id := range s.ids {
+			for len(s.tps[id].C()) > 0 {
+				s.nodes[id].StepMessage(<-s.tps[id].C())
+				more = true
+			}
+		}
+	}
+}
+
+// steps until cond, fails after timeout of simulated time
+func (s *memSim)until(timeout time.Duration, what string, cond func() bool) {
+	for i := 0; i < int(timeout / (10 * time.Millisecond)); i ++ {
+		if cond() {
+			return
+		}
+		s.step()
+	}
+	s.t.Fatalf("%s: timeout, %s", what, s.dump())
+}
+
+func (s *memSim)leader(ids ...string) string {
+	for _, id := range ids {
+		if s.nodes[id].Metrics().Role == RoleLeader {
+			return id
+		}
+	}
+	return ""
+}
+
+// LastIndex and CommitIndex of all equal, at least index
+func (s *memSim)converged(index int64) bool {
+	m0 := s.nodes[s.ids[0]].Metrics()
+	for _, id := range s.ids {
+		m := s.nodes[id].Metrics()
+		if m.CommitIndex < index || m.LastIndex != m0.LastIndex || m.CommitIndex != m0.CommitIndex {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *memSim)dump() string {
+	ret := ""
+	for _, id := range s.ids {
+		m := s.nodes[id].Metrics()
+		ret += fmt.Sprintf("%s: %s term=%d last=%d commit=%d; ", id, m.Role, m.Term, m.LastIndex, m.CommitIndex)
+	}
+	return ret
+}
+
+// elections, partitions and log repair on a lossy network, returns what
+// happened
+func runMemSim(t *testing.T, seed int64) string {
+	s := newMemSim(t, seed, "n1", "n2", "n3")
+	s.net.SetDefault(MemLinkConfig{Delay: time.Millisecond, Jitter: 5 * time.Millisecond})
+	n1 := s.nodes["n1"]
+	n1.AddMember("n1", "addr-n1")
+	n1.StepTick(0)
+	for _, id := range []string{"n2", "n3"} {
+		index, err := n1.AddMember(id, "addr-" + id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		node := s.nodes[id]
+		node.JoinGroup("n1", "addr-n1")
+		s.until(10 * time.Second, "join " + id, func() bool {
+			return node.Metrics().CommitIndex >= index
+		})
+	}
+
+	s.net.SetDefault(MemLinkConfig{Delay: time.Millisecond, Jitter: 20 * time.Millisecond,
+		DropRate: 0.1, DuplicateRate: 0.1, ReorderRate: 0.1})
+	for i := 0; i < 10; i ++ {
+		if _, _, err := n1.Propose(fmt.Sprintf("set k%d v", i)); err != nil {
+			t.Fatal(err)
+		}
+		s.step()
+	}
+	s.until(30 * time.Second, "replicate", func() bool {
+		return s.converged(n1.Metrics().LastIndex)
+	})
+	trace := s.dump()
+
+	// the leader is partitioned, its proposal never commits
+	s.net.Isolate("n1", "n2", "n3")
+	n1.Propose("set lost v")
+	var leader string
+	s.until(30 * time.Second, "elect", func() bool {
+		leader = s.leader("n2", "n3")
+		return leader != ""
+	})
+	if _, _, err := s.nodes[leader].Propose("set k v2"); err != nil {
+		t.Fatal(err)
+	}
+	committed := s.nodes[leader].Metrics().LastIndex
+	s.until(30 * time.Second, "commit in majority", func() bool {
+		return s.nodes[leader].Metrics().CommitIndex >= committed
+	})
+	trace += s.dump()
+
+	// the old leader steps down, its log is repaired
+	s.net.Heal()
+	s.until(30 * time.Second, "repair", func() bool {
+		return s.leader("n1") == "" && s.converged(committed)
+	})
+	ent := n1.store.GetEntry(committed)
+	if ent == nil || ent.Data != "set k v2" {
+		t.Fatal("not repaired", ent)
+	}
+	for _, id := range s.ids {
+		if err := s.nodes[id].Err(); err != nil {
+			t.Fatal(id, err)
+		}
+	}
+	st := s.net.Stats()
+	if st.Dropped == 0 || st.Duplicated == 0 || st.Reordered == 0 {
+		t.Fatal("no fault", st)
+	}
+	return trace + s.dump() + fmt.Sprintf("%+v", st)
+}
+
+func TestMemSimulation(t *testing.T){
+	logger.SetDefaultLevel(logger.LevelError)
+	trace := runMemSim(t, 1)
+	// the same seed, the same run
+	if again := runMemSim(t, 1); again != trace {
+		t.Fatalf("not reproducible\n%s\n%s", trace, again)
+	}
+	runMemSim(t, 2)
+}
+
+func TestMemTransport(t *testing.T){
+	net := NewMemNetwork(1, nil)
+	a := net.Transport("a")
+	b := net.Transport("b")
+	a.Connect("nb", "b")
+	msg := NewAppendEntryAck("nb", true)
+	msg.Src = "na"
+	if !a.Send(msg) {
+		t.Fatal("send")
+	}
+	if got := <-b.C(); got.Dst != "nb" || got == msg {
+		t.Fatal("receive", got)
+	}
+	if a.Send(NewAppendEntryAck("nc", true)) {
+		t.Fatal("sent to unknown")
+	}
+	b.Close()
+	a.Send(msg)
+	if _, ok := <-b.C(); ok {
+		t.Fatal("not closed")
+	}
+	if st := net.Stats(); st.Sent != 2 || st.Dropped != 1 {
+		t.Fatal("stats", st)
+	}
+}
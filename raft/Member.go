@@ -0,0 +1,54 @@
+package raft
+
+type Member struct{
+	Id string
+	Addr string
+	Role RoleType
+	// election priority, replicated, see Priority.go
+	Priority int
+
+	// sliding window
+	NextIndex int64   // next_send
+	MatchIndex int64  // last_ack
+	SendWindow int64  // max entries sent and not acked, see SendWindow.go
+
+	HeartbeatTimer int
+	ReplicateTimer int
+
+	ReceiveTimeout int // increase on tick(), reset on ApplyEntryAck
+	BehindTimer int    // increase on tick() while MatchIndex < leader's LastIndex
+
+	// InstallSnapshot sent, waiting for ack
+	InstallingSnapshot bool
+	// nil if no snapshot is being sent
+	snapshot *snapshotTransfer
+	// SinkMemberLagging emitted
+	lagging bool
+	// NextIndex reset to by the last reject, rejects of the same window are
+	// duplicates. 0 if none since last success or resend.
+	rejectNext int64
+	// entries acked since SendWindow last grew
+	windowAcked int64
+}
+
+func NewMember(id, addr string) *Member{
+	ret := new(Member)
+	ret.Role = RoleFollower
+	ret.Id = id
+	ret.Addr = addr
+	ret.SendWindow = DefaultSendWindow
+	return ret
+}
+
+func (m *Member)Reset() {
+	m.NextIndex = 0
+	m.MatchIndex = 0
+	m.HeartbeatTimer = 0
+	m.ReplicateTimer = 0
+	m.ReceiveTimeout = 0
+	m.BehindTimer = 0
+	m.InstallingSnapshot = false
+	m.snapshot = nil
+	m.rejectNext = 0
+	m.windowAcked = 0
+}
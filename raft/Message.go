@@ -0,0 +1,255 @@
+package raft
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/fallowu/big-ssdb/internal/util"
+)
+
+type MessageType string
+
+const(
+	MessageTypeNone            = "None"
+	MessageTypePreVote         = "PreVote"
+	MessageTypePreVoteAck      = "PreVoteAck"
+	MessageTypeRequestVote     = "RequestVote"
+	MessageTypeRequestVoteAck  = "RequestVoteAck"
+	MessageTypeAppendEntry     = "AppendEntry"
+	MessageTypeAppendEntryAck  = "AppendEntryAck"
+	MessageTypeInstallSnapshot = "InstallSnapshot" // install raft state, not service state
+	MessageTypeInstallSnapshotAck = "InstallSnapshotAck" // snapshot chunks received, see SnapshotChunk.go
+	MessageTypeStateHash       = "StateHash"       // ask for service state hash at index
+	MessageTypeStateHashAck    = "StateHashAck"
+	MessageTypeLogHash         = "LogHash"         // ask for term and hash of entries at indexes
+	MessageTypeLogHashAck      = "LogHashAck"
+	MessageTypePropose         = "Propose"         // follower forwards a proposal, see Forward.go
+	MessageTypeProposeAck      = "ProposeAck"
+)
+
+type Message struct{
+	Type MessageType
+	Src string
+	Dst string
+	Term int32
+	PrevTerm  int32 // LastTerm for RequestVote
+	PrevIndex int64 // LastIndex for RequestVote
+	Data string
+	// W3C traceparent of the sending span, optional. Encoded as Type@TraceId
+	TraceId string
+}
+
+func DecodeMessage(buf string) *Message{
+	m := new(Message);
+	if m.Decode(buf) {
+		return m
+	} else {
+		return nil
+	}
+}
+
+func (m *Message)encodeType() string{
+	if m.TraceId == "" {
+		return string(m.Type)
+	}
+	return string(m.Type) + "@" + m.TraceId
+}
+
+func (m *Message)Encode() string{
+	b := getEncodeBuf()
+	*b = m.AppendEncode(*b)
+	s := string(*b)
+	putEncodeBuf(b)
+	return s
+}
+
+// Encode() with Data replaced by its size, for logging
+func (m *Message)Redacted() string{
+	data := m.Data
+	if data != "" {
+		data = fmt.Sprintf("<%d bytes>", len(data))
+	}
+	ps := []string{m.encodeType(), m.Src, m.Dst, util.Itoa32(m.Term),
+		util.Itoa32(m.PrevTerm), util.I64toa(m.PrevIndex), data}
+	return strings.Join(ps, " ")
+}
+
+func (m *Message)Decode(buf string) bool{
+	return m.decode(buf) == nil
+}
+
+// ErrCorrupted if the checksum mismatches
+func (m *Message)decode(buf string) error {
+	_, err := m.decodeVerified(buf)
+	return err
+}
+
+// verified is false if buf has no checksum, see Encode.go
+func (m *Message)decodeVerified(buf string) (verified bool, err error) {
+	buf = strings.Trim(buf, "\r\n")
+	var ps [7]string
+	if !splitFields(buf, ps[:]) {
+		return false, errBadFormat
+	}
+	end := len(ps[0])
+	m.TraceId = ""
+	if i := strings.IndexByte(ps[0], '@'); i >= 0 {
+		end = i
+		m.TraceId = ps[0][i+1:]
+	}
+	type_, verified, err := stripChecksum(buf, 0, end)
+	if err != nil {
+		return false, err
+	}
+	type_, data, ok := decodeData(type_, ps[6])
+	if !ok {
+		return false, errBadFormat
+	}
+	m.Type = MessageType(type_)
+	m.Src = ps[1]
+	m.Dst = ps[2]
+	var ok1, ok2, ok3 bool
+	m.Term, ok1 = parseTerm(ps[3])
+	m.PrevTerm, ok2 = parseTerm(ps[4])
+	m.PrevIndex, ok3 = parseIndex(ps[5])
+	m.Data = data
+	if !ok1 || !ok2 || !ok3 || m.Type == "" {
+		return false, errBadFormat
+	}
+	return verified, nil
+}
+
+func NewNoneMsg(dst string) *Message{
+	msg := new(Message)
+	msg.Type = MessageTypeNone
+	msg.Dst = dst
+	return msg
+}
+
+func NewPreVoteMsg() *Message{
+	msg := new(Message)
+	msg.Type = MessageTypePreVote
+	return msg
+}
+
+func NewPreVoteAck(dst string) *Message{
+	msg := new(Message)
+	msg.Type = MessageTypePreVoteAck
+	msg.Dst = dst
+	return msg
+}
+
+func NewRequestVoteMsg() *Message{
+	msg := new(Message)
+	msg.Type = MessageTypeRequestVote
+	msg.Data = "please vote me"
+	return msg
+}
+
+func NewRequestVoteAck(dst string, grant bool) *Message{
+	msg := new(Message)
+	msg.Type = MessageTypeRequestVoteAck
+	msg.Dst = dst
+	if grant {
+		msg.Data = "grant"
+	} else {
+		msg.Data = "reject"
+	}
+	return msg
+}
+
+func NewAppendEntryMsg(dst string, ent *Entry, prev *Entry) *Message{
+	msg := new(Message)
+	msg.Type = MessageTypeAppendEntry
+	msg.Dst = dst
+	if prev != nil {
+		msg.PrevIndex = prev.Index
+		msg.PrevTerm = prev.Term
+	}
+	msg.Data = ent.Encode()
+	return msg
+}
+
+func NewAppendEntryAck(dst string, success bool) *Message{
+	msg := new(Message)
+	msg.Type = MessageTypeAppendEntryAck
+	msg.Dst = dst
+	if success {
+		msg.Data = "true"
+	}else{
+		msg.Data = "false"
+	}
+	return msg
+}
+
+func NewInstallSnapshotMsg(dst string, data string) *Message{
+	msg := new(Message)
+	msg.Type = MessageTypeInstallSnapshot
+	msg.Dst = dst
+	msg.Data = data
+	return msg
+}
+
+// Data: bytes received
+func NewInstallSnapshotAck(dst string, received int) *Message{
+	msg := new(Message)
+	msg.Type = MessageTypeInstallSnapshotAck
+	msg.Dst = dst
+	msg.Data = util.Itoa(received)
+	return msg
+}
+
+// Data: index
+func NewStateHashMsg(dst string, index int64) *Message{
+	msg := new(Message)
+	msg.Type = MessageTypeStateHash
+	msg.Dst = dst
+	msg.Data = util.I64toa(index)
+	return msg
+}
+
+// Data: index hash
+func NewStateHashAck(dst string, index int64, hash string) *Message{
+	msg := new(Message)
+	msg.Type = MessageTypeStateHashAck
+	msg.Dst = dst
+	msg.Data = util.I64toa(index) + " " + hash
+	return msg
+}
+
+// Data: index index ...
+func NewLogHashMsg(dst string, indexes string) *Message{
+	msg := new(Message)
+	msg.Type = MessageTypeLogHash
+	msg.Dst = dst
+	msg.Data = indexes
+	return msg
+}
+
+// Data: index term hash,index term hash,...
+func NewLogHashAck(dst string, positions string) *Message{
+	msg := new(Message)
+	msg.Type = MessageTypeLogHashAck
+	msg.Dst = dst
+	msg.Data = positions
+	return msg
+}
+
+// PrevIndex: request id, Data: data proposed
+func NewProposeMsg(dst string, seq int64, data string) *Message{
+	msg := new(Message)
+	msg.Type = MessageTypePropose
+	msg.Dst = dst
+	msg.PrevIndex = seq
+	msg.Data = data
+	return msg
+}
+
+// PrevIndex: request id, Data: the result
+func NewProposeAck(dst string, seq int64) *Message{
+	msg := new(Message)
+	msg.Type = MessageTypeProposeAck
+	msg.Dst = dst
+	msg.PrevIndex = seq
+	return msg
+}
@@ -0,0 +1,68 @@
+package raft
+
+import (
+	"testing"
+
+	"github.com/fallowu/big-ssdb/logger"
+)
+
+func TestMessageTraceId(t *testing.T){
+	msg := NewAppendEntryAck("n2", true)
+	msg.Src = "n1"
+	msg.TraceId = "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"
+	m2 := DecodeMessage(msg.Encode())
+	if m2 == nil || m2.Type != MessageTypeAppendEntryAck || m2.TraceId != msg.TraceId || m2.Data != "true" {
+		t.Fatal("decode failed", msg.Encode())
+	}
+
+	msg.TraceId = ""
+	m2 = DecodeMessage(msg.Encode())
+	if m2.TraceId != "" || m2.Type != MessageTypeAppendEntryAck {
+		t.Fatal("decode failed", msg.Encode())
+	}
+}
+
+func TestAppendEncodeAllocs(t *testing.T){
+	ent := &Entry{Term: 3, Index: 100, Commit: 99, Type: EntryTypeData, Data: "set k v"}
+	msg := NewAppendEntryMsg("n2", ent, ent)
+	msg.Src = "n1"
+	buf := make([]byte, 0, 1024)
+	allocs := testing.AllocsPerRun(100, func() {
+		buf = msg.AppendEncode(buf[:0])
+		buf = ent.AppendEncode(buf[:0])
+	})
+	if allocs != 0 {
+		t.Fatal("allocs", allocs)
+	}
+	if string(msg.AppendEncode(nil)) != msg.Encode() || string(ent.AppendEncode(nil)) != ent.Encode() {
+		t.Fatal("encode mismatch", msg.Encode())
+	}
+}
+
+func TestDecodeAllocs(t *testing.T){
+	ent := &Entry{Term: 3, Index: 100, Commit: 99, Type: EntryTypeData, Data: "set k v"}
+	data := ent.Encode()
+	allocs := testing.AllocsPerRun(100, func() {
+		e := decodePooledEntry(data)
+		if e == nil || *e != *ent {
+			t.Fatal("decode failed", data)
+		}
+		putEntry(e)
+	})
+	if allocs != 0 {
+		t.Fatal("allocs", allocs)
+	}
+}
+
+func TestDebugLogAllocs(t *testing.T){
+	logger.SetDefaultLevel(logger.LevelInfo)
+	node := NewNode("n1", "addr1", NewMemDb())
+	msg := NewAppendEntryAck("n2", true)
+	// nothing is formatted unless debug is on
+	allocs := testing.AllocsPerRun(100, func() {
+		node.dropMessage(msg)
+	})
+	if allocs != 0 {
+		t.Fatal("allocs", allocs)
+	}
+}
@@ -0,0 +1,231 @@
+package raft
+
+import (
+	"sort"
+	"sync/atomic"
+
+	"github.com/fallowu/big-ssdb/internal/util"
+	"github.com/fallowu/big-ssdb/metrics"
+)
+
+type MemberMetrics struct{
+	Id string
+	Role RoleType
+	NextIndex int64
+	MatchIndex int64
+	// entries behind leader's LastIndex, only meaningful on leader
+	Lag int64
+	// ms since last message received from member, only meaningful on leader
+	ReceiveTimeout int
+	// ms the member has been behind leader's LastIndex, only meaningful on leader
+	LagTime int
+	InstallingSnapshot bool
+	// bytes of the snapshot being sent acked by member, and its size
+	SnapshotAcked int
+	SnapshotSize int
+	// see SendWindow.go, only meaningful on leader
+	SendWindow int64
+	// messages waiting in and dropped by member's replicator
+	SendQueue int
+	SendDropped int64
+}
+
+// of the log, see Storage.Metrics()
+type StorageMetrics struct{
+	// 0 if the log is empty
+	FirstIndex int64
+	LastIndex int64
+	CommitIndex int64
+	LastApplied int64
+	// committed entries not applied yet, by Service if set
+	ApplyLag int64
+	Entries int64
+	// of entries on disk, in LogDb or db
+	Bytes int64
+	// see EntryCache.go
+	EntryCacheBytes int64
+	EntryCacheMisses int64
+	EntryCacheEvictions int64
+	// read without checksum, written by old versions, see LogChecksum.go
+	EntriesUnverified int64
+	Fsync *metrics.HistogramSnapshot
+}
+
+// with node's lock held
+func (st *Storage)Metrics() StorageMetrics {
+	node := st.node
+	m := StorageMetrics{
+		FirstIndex: st.FirstIndex,
+		LastIndex: st.LastIndex,
+		CommitIndex: st.CommitIndex,
+		LastApplied: node.LastApplied(),
+		Entries: st.logEntries(),
+		Bytes: st.logBytes,
+		EntryCacheBytes: st.entries.bytes,
+		EntryCacheMisses: st.entries.misses,
+		EntryCacheEvictions: st.entries.evictions,
+		EntriesUnverified: st.unverified,
+		Fsync: st.fsyncLatency.Snapshot(),
+	}
+	if m.Entries == 0 {
+		m.FirstIndex = 0
+	}
+	applied := m.LastApplied
+	if st.Service != nil {
+		applied = node.serviceApplied
+	}
+	m.ApplyLag = util.MaxInt64(0, st.CommitIndex - applied)
+	return m
+}
+
+func (node *Node)StorageMetrics() StorageMetrics {
+	node.mux.Lock()
+	defer node.mux.Unlock()
+	return node.store.Metrics()
+}
+
+// point-in-time copy of node's state, safe to be read without lock
+type Metrics struct{
+	Id string
+	Role RoleType
+	Term int32
+	// id of current leader as known by this node, "" if unknown
+	Leader string
+	// ms since last heard from leader or PreVote started, only meaningful
+	// on follower
+	ElectionTimer int
+	// leader heard from within the election timeout, a leader hears from a
+	// majority, see CheckLeader.go
+	LeaderActive bool
+
+	FirstIndex int64
+	LastIndex int64
+	CommitIndex int64
+	LastApplied int64
+	// Service's lastApplied
+	ServiceLastApplied int64
+
+	// number of elections started by this node
+	Elections int64
+	// PreVote and RequestVote denied while leader is active
+	VotesDenied int64
+	SnapshotsSent int64
+	SnapshotsInstalled int64
+
+	LogEntries int
+	LogBytes int64
+	// bytes of entries cached and written to db, and entries loaded from db
+	// on misses, see
+	// EntryCache.go
+	EntryCacheBytes int64
+	EntryCacheMisses int64
+	Fsync *metrics.HistogramSnapshot
+	// latency of Service.ApplyEntry
+	Apply *metrics.HistogramSnapshot
+	SlowApplies int64
+	// entries queued to the apply goroutine, not yet applied
+	ApplyQueue int64
+	// only counted in InvariantAlert mode
+	InvariantViolations int64
+	// followers' apply results differing from leader's, in apply check mode
+	ApplyDivergences int64
+	// proposals rejected by admission control
+	ProposalsBusy int64
+	// proposals forwarded to leader by ProposeForward()
+	ProposalsForwarded int64
+	// received by transports of the process, dropped for checksum mismatch
+	MessagesCorrupted int64
+	// received without checksum, sent by old versions, not verified
+	MessagesUnverified int64
+
+	// length and capacity of recv_c, send_c, and messages dropped when full
+	RecvQueue int
+	RecvQueueSize int
+	RecvDropped int64
+	SendQueue int
+	SendQueueSize int
+	SendDropped int64
+
+	DiskTotal uint64
+	DiskFree uint64
+	ReadOnly bool
+
+	Members []MemberMetrics
+}
+
+func (node *Node)Metrics() *Metrics {
+	node.mux.Lock()
+	defer node.mux.Unlock()
+
+	st := node.store
+	sm := st.Metrics()
+	ret := new(Metrics)
+	ret.Id = node.Id
+	ret.Role = node.Role
+	ret.Term = node.Term
+	ret.Leader = node.leaderId()
+	ret.ElectionTimer = node.electionTimer
+	ret.LeaderActive = node.activeLeader() != ""
+	ret.FirstIndex = sm.FirstIndex
+	ret.LastIndex = sm.LastIndex
+	ret.CommitIndex = sm.CommitIndex
+	ret.LastApplied = sm.LastApplied
+	ret.ServiceLastApplied = node.serviceLastApplied()
+	ret.Elections = node.elections
+	ret.VotesDenied = node.votesDenied
+	ret.SnapshotsSent = node.snapshotsSent
+	ret.SnapshotsInstalled = node.snapshotsInstalled
+	ret.LogEntries = int(sm.Entries)
+	ret.LogBytes = sm.Bytes
+	ret.EntryCacheBytes = sm.EntryCacheBytes
+	ret.EntryCacheMisses = sm.EntryCacheMisses
+	ret.Fsync = sm.Fsync
+	ret.Apply = st.applyLatency.Snapshot()
+	ret.SlowApplies = st.slowApplies
+	ret.ApplyQueue = node.applyQueueLen()
+	ret.InvariantViolations = node.invariantViolations
+	ret.ApplyDivergences = node.applyDivergences
+	node.proposeMux.Lock()
+	ret.ProposalsBusy = node.proposalsBusy
+	node.proposeMux.Unlock()
+	ret.ProposalsForwarded = node.proposalsForwarded
+	ret.MessagesCorrupted = atomic.LoadInt64(&messagesCorrupted)
+	ret.MessagesUnverified = atomic.LoadInt64(&messagesUnverified)
+	ret.RecvQueue = len(node.recv_c)
+	ret.RecvQueueSize = cap(node.recv_c)
+	ret.RecvDropped = atomic.LoadInt64(&node.recvDropped)
+	ret.SendQueue = len(node.send_c)
+	ret.SendQueueSize = cap(node.send_c)
+	ret.SendDropped = node.sendDropped
+	ret.DiskTotal = node.diskUsage.Total
+	ret.DiskFree = node.diskUsage.Free
+	ret.ReadOnly = node.readOnly != nil
+
+	ret.Members = node.memberMetrics()
+	return ret
+}
+
+// sorted by member id
+func (node *Node)memberMetrics() []MemberMetrics {
+	ret := make([]MemberMetrics, 0, len(node.Members))
+	for _, m := range node.Members {
+		mm := MemberMetrics{Id: m.Id, Role: m.Role, NextIndex: m.NextIndex, MatchIndex: m.MatchIndex}
+		if node.Role == RoleLeader {
+			mm.Lag = util.MaxInt64(0, node.store.LastIndex - m.MatchIndex)
+			mm.ReceiveTimeout = m.ReceiveTimeout
+			mm.LagTime = m.BehindTimer
+			mm.InstallingSnapshot = m.InstallingSnapshot
+			if m.snapshot != nil {
+				mm.SnapshotAcked = m.snapshot.acked
+				mm.SnapshotSize = int(m.snapshot.size)
+			}
+			mm.SendWindow = m.SendWindow
+		}
+		mm.SendQueue, mm.SendDropped = node.replicatorQueue(m.Id)
+		ret = append(ret, mm)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Id < ret[j].Id
+	})
+	return ret
+}
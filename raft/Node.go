@@ -0,0 +1,1484 @@
+package raft
+
+import (
+	"fmt"
+	"sort"
+	"math/rand"
+	"time"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"encoding/json"
+	"crypto/tls"
+
+	"github.com/fallowu/big-ssdb/internal/util"
+	"github.com/fallowu/big-ssdb/logger"
+	"github.com/fallowu/big-ssdb/trace"
+)
+
+type RoleType string
+
+const(
+	RoleLeader      = "leader"
+	RoleFollower    = "follower"
+	RoleCandidate   = "candidate"
+)
+
+const(
+	ElectionTimeout    = 5 * 1000
+	HeartbeatTimeout   = 4 * 1000 // TODO: ElectionTimeout/3
+	ReplicationTimeout = 1 * 1000
+	ReceiveTimeout     = HeartbeatTimeout * 3
+)
+
+type Node struct{
+	Id string
+	Addr string
+	Role RoleType
+
+	// Raft persisted state
+	Term int32
+	VoteFor string
+	Members map[string]*Member
+	// election priority of this node, see Priority.go
+	Priority int
+	// votes and acks without data, see Witness.go
+	witness bool
+
+	// not valotile, persisted in Raft's database as CommitIndex.
+	// Written with lock held and atomically, see LastApplied()
+	lastApplied int64
+	
+	votesReceived map[string]string
+
+	electionTimer int
+	// number of elections started
+	elections int64
+	// PreVote and RequestVote denied with a live leader, see CheckLeader.go
+	votesDenied int64
+	snapshotsSent int64
+	snapshotsInstalled int64
+
+	// service state hash of recent applied indexes, for consistency check
+	stateHashes map[int64]string
+	stateHashIndexes []int64
+	// nodeId => index, StateHash queries waiting for index to be applied
+	hashQueries map[string]int64
+	// leader's ongoing consistency check
+	check *consistencyCheck
+	// leader's ongoing log check
+	logCheck *logCheck
+	// latest events, for debugging
+	events *EventRing
+	audit AuditLog
+	tracer trace.Tracer
+	// index => spans, entries proposed with trace context
+	traces map[int64]*entryTrace
+	sinks []EventSink
+	sinkC chan *SinkEvent
+	invariantMode InvariantMode
+	invariants invariantState
+	invariantViolations int64
+	// see SetApplyCheck()
+	applyCheck bool
+	// index => hash of Service's effect of applying the entry
+	effects map[int64]uint64
+	effectIndexes []int64
+	// follower, last index whose effect is sent to leader
+	effectReported int64
+	// leader, found in followers' acks
+	applyDivergences int64
+	// see Applier.go, nil if entries are applied synchronously
+	applyC chan *applyTask
+	applyGen int64
+	// last index queued to, and applied by Service
+	applyQueued int64
+	serviceApplied int64
+	applyLost bool
+	// see ServiceSnapshot.go, service state to be restored by the applier
+	applyRestore *applyTask
+	applyRestoring bool
+	// see ProposeContext.go, index => waiters
+	commitWaiters map[int64][]*commitWaiter
+	// see ProposeAndWait.go, entries proposed as leader => waiters
+	applyWaiters map[entryKey]*Future
+	// leader, index of the last AddMember/DelMember entry
+	pendingConfIndex int64
+	// see Proposals.go, Admission.go, with proposeMux held
+	proposeMux sync.Mutex
+	gate proposeGate
+	proposals []*proposal
+	admission AdmissionConfig
+	admitTokens float64
+	admitAt time.Time
+	admitWaiters []chan struct{}
+	proposalsBusy int64
+	// see Forward.go, follower, request id => waiter
+	forwardSeq int64
+	forwards map[int64]chan forwardResult
+	proposalsForwarded int64
+	// see Fault.go
+	halted bool
+	// see WithManualTick()
+	manualTick bool
+	// see Close()
+	closed bool
+	// see Leadership.go, taken after mux
+	leadershipMux sync.Mutex
+	leadershipQ []leadershipChange
+	leadershipRunning bool
+	// see Observer.go, taken after mux
+	observerMux sync.Mutex
+	observers []*observerSub
+	observerQ []observerEvent
+	observerRunning bool
+
+	clock Clock
+	rand *rand.Rand
+	// if set, outgoing messages are passed to outbox instead of send_c
+	outbox func(msg *Message)
+
+	// reject proposals when free space of dataDir is below MinFreeBytes
+	MinFreeBytes uint64
+	// see SetProposeWindow()
+	proposeWindowNs int64
+	// see AckBatch.go, 1 to ack every entry
+	AckBatchSize int
+	ackLeader string
+	ackPending int
+	ackIndex int64
+	dataDir string
+	diskTimer int
+	diskUsage DiskUsage
+	getDiskUsage func(dir string) (DiskUsage, error)
+	readOnly *ReadOnlyError
+
+	store *Storage
+	// messages to be processed by raft
+	recv_c chan *Message
+	// messages to be sent to other node
+	send_c chan *Message
+	// see Queues.go
+	queues QueueConfig
+	recvDropped int64
+	sendDropped int64
+	// see Replicator.go, id => replicator
+	replicators map[string]*replicator
+	replicaSend func(msg *Message) bool
+	
+	log *logger.Logger
+	// raft state, members and storage. Mutators release it with unlock(),
+	// which publishes Status
+	mux sync.Mutex
+	statusMux sync.RWMutex
+	status *Status
+	// see Options.go
+	timeouts Timeouts
+	sendWindow int64
+	maxSendWindow int64
+	// see SnapshotChunk.go
+	snapshotRecv *snapshotReceive
+	// see SnapshotStream.go
+	snapshotServer *SnapshotServer
+	snapshotPull *snapshotPull
+	pullDir string
+	pullTLS *tls.Config
+	// see Lifecycle.go, closed by Stop()
+	stopC chan struct{}
+	goroutines sync.WaitGroup
+}
+
+// See Options.go for opts
+func NewNode(nodeId string, addr string, db Db, opts ...Option) *Node{
+	o := defaultOptions(nodeId)
+	for _, opt := range opts {
+		opt(o)
+	}
+	node := new(Node)
+	node.Id = nodeId
+	node.Addr = addr
+	node.Role = RoleFollower
+	node.Members = make(map[string]*Member)
+	node.electionTimer = 2 * 1000
+	node.stateHashes = make(map[int64]string)
+	node.stateHashIndexes = make([]int64, 0)
+	node.effects = make(map[int64]uint64)
+	node.AckBatchSize = DefaultAckBatchSize
+	node.hashQueries = make(map[string]int64)
+	node.log = o.log
+	node.timeouts = o.timeouts
+	node.sendWindow = o.sendWindow
+	node.maxSendWindow = util.MaxInt64(o.maxSendWindow, o.sendWindow)
+	node.events = NewEventRing(DefaultEventRingSize)
+	node.tracer = trace.NoopTracer{}
+	node.traces = make(map[int64]*entryTrace)
+	node.clock = o.clock
+	node.setAdmission(o.admission)
+	seed := o.randSeed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	node.rand = rand.New(rand.NewSource(seed))
+	node.manualTick = o.manualTick
+	node.witness = o.witness
+	node.getDiskUsage = o.diskUsage
+	node.pullDir = o.pullDir
+	node.pullTLS = o.pullTLS
+
+	node.store = NewStorage(node, db, opts...)
+
+	node.queues = DefaultQueueConfig()
+	node.recv_c = make(chan *Message, node.queues.RecvSize)
+	node.send_c = make(chan *Message, node.queues.SendSize)
+
+	// init Raft state from persistent storage
+	st := node.store
+	atomic.StoreInt64(&node.lastApplied, st.loadLastApplied())
+	node.Term = st.State().Term
+	node.VoteFor = st.State().VoteFor
+	for nodeId, nodeAddr := range st.State().Members {
+		node.addMember(nodeId, nodeAddr)
+	}
+	node.loadPriorities(st.State())
+	if len(st.State().Members) == 0 && o.priority != 0 && node.witness {
+		node.log.Warn("witness has no priority, ignored", "priority", o.priority)
+	} else if len(st.State().Members) == 0 {
+		node.Priority = o.priority
+	}
+
+	node.appendProposals()
+	node.publishStatus()
+
+	node.log.Info("init raft node", "commitIndex", st.CommitIndex, "lastTerm", st.LastTerm,
+		"lastIndex", st.LastIndex, "state", st.State().Encode())
+
+	return node
+}
+
+func (node *Node)RecvC() chan<- *Message {
+	return node.recv_c
+}
+
+func (node *Node)SendC() <-chan *Message {
+	return node.send_c
+}
+
+// Storage logs through subsystem "storage" with the same fields
+func (node *Node)SetLogger(l *logger.Logger){
+	node.mux.Lock()
+	defer node.mux.Unlock()
+
+	node.log = l
+	node.store.log = l.Sub("storage")
+}
+
+// Must be called before Start()
+func (node *Node)SetClock(c Clock){
+	node.mux.Lock()
+	defer node.mux.Unlock()
+	node.clock = c
+}
+
+// For deterministic elections in simulation
+func (node *Node)SetRandSeed(seed int64){
+	node.mux.Lock()
+	defer node.mux.Unlock()
+	node.rand = rand.New(rand.NewSource(seed))
+}
+
+// Outgoing messages are passed to f instead of SendC(). f is called with
+// node's lock held, it must not block or call into node.
+func (node *Node)SetOutbox(f func(msg *Message)){
+	node.mux.Lock()
+	defer node.mux.Unlock()
+	node.outbox = f
+}
+
+// Ignored by a witness, which has no data to apply
+func (node *Node)SetService(svc Service){
+	node.mux.Lock()
+	defer node.unlock()
+	if node.witness {
+		node.log.Info("witness, service not set")
+		return
+	}
+	node.store.Service = svc
+	node.resetApplier(svc)
+}
+
+// Goroutines started here are cancelled by Stop(), see Lifecycle.go
+func (node *Node)Start(){
+	node.StartApplier()
+	node.mux.Lock()
+	node.goroutines.Add(1)
+	node.mux.Unlock()
+	go func() {
+		defer node.goroutines.Done()
+		node.StepStart()
+	}()
+	if !node.manualTick {
+		node.StartTicker()
+	}
+	node.StartCommunication()
+}
+
+// For simulation, apply logs on startup as Start() would do
+func (node *Node)StepStart(){
+	node.log.Info("apply logs on startup")
+	node.mux.Lock()
+	defer node.unlock()
+	node.store.ApplyEntries()
+	node.checkInvariants("start")
+}
+
+func (node *Node)StartTicker(){
+	stop := node.startGoroutine()
+	go func() {
+		defer node.goroutines.Done()
+		const TimerInterval = 100
+		ticker := node.clock.NewTicker(TimerInterval * time.Millisecond)
+		defer ticker.Stop()
+
+		node.log.Info("setup ticker", "interval", TimerInterval)
+		for {
+			select {
+			case <- ticker.C():
+			case <-stop:
+				return
+			}
+			node.mux.Lock()
+			node.Tick(TimerInterval)
+			node.unlock()
+		}
+	}()
+}
+
+func (node *Node)StartCommunication(){
+	stop := node.startGoroutine()
+	go func() {
+		defer node.goroutines.Done()
+		node.log.Info("setup communication")
+		// see SetProposeWindow()
+		batchC := make(chan struct{}, 1)
+		batching := false
+		for{
+			select{
+			case <-node.store.C:
+				if batching {
+					break
+				}
+				if window := node.proposeWindow(); window > 0 {
+					batching = true
+					node.clock.AfterFunc(window, func() {
+						batchC <- struct{}{}
+					})
+					break
+				}
+				node.mux.Lock()
+				node.replicateAllMembers()
+				node.unlock()
+			case <-batchC:
+				batching = false
+				node.mux.Lock()
+				node.replicateAllMembers()
+				node.unlock()
+			case msg := <-node.recv_c:
+				node.mux.Lock()
+				node.handleRaftMessage(msg)
+				if len(node.recv_c) == 0 {
+					node.flushBatch()
+				}
+				node.unlock()
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// For simulation, Tick and replicate new entries in caller's goroutine,
+// as the goroutine of StartTicker() would do. timeElapse may be 0.
+func (node *Node)StepTick(timeElapse int){
+	node.mux.Lock()
+	defer node.unlock()
+
+	node.Tick(timeElapse)
+	node.flushReplication()
+}
+
+// Time of a node started WithManualTick(): advances the ManualClock, if any,
+// by ms, then ticks as the ticker would
+func (node *Node)ManualTick(ms int){
+	if c, ok := node.clock.(*ManualClock); ok {
+		c.Advance(time.Duration(ms) * time.Millisecond)
+	}
+	node.StepTick(ms)
+}
+
+// For simulation, handle msg in caller's goroutine, as the goroutine of
+// StartCommunication() would do.
+func (node *Node)StepMessage(msg *Message){
+	node.mux.Lock()
+	defer node.unlock()
+
+	node.handleRaftMessage(msg)
+	node.flushBatch()
+	node.flushReplication()
+}
+
+func (node *Node)flushReplication(){
+	if len(node.store.C) > 0 {
+		for len(node.store.C) > 0 {
+			<-node.store.C
+		}
+		node.replicateAllMembers()
+	}
+}
+
+// Stops goroutines, proposals are rejected with ErrShuttingDown once called
+func (node *Node)Close(){
+	node.Stop()
+	node.mux.Lock()
+	defer node.unlock()
+	node.closed = true
+	node.store.Close()
+}
+
+func (node *Node)Tick(timeElapse int){
+	if node.store.fault != nil {
+		node.halt()
+		return
+	}
+	defer node.checkInvariants("tick")
+	node.flushBatch()
+	node.diskTimer += timeElapse
+	if node.diskTimer >= DiskCheckInterval {
+		node.checkDisk()
+	}
+
+	if node.Role == RoleFollower || node.Role == RoleCandidate {
+		// so that handlePreVote() sees a dead leader as inactive
+		for _, m := range node.Members {
+			m.ReceiveTimeout += timeElapse
+		}
+		// a witness never campaigns
+		if len(node.Members) > 0 && !node.witness {
+			node.electionTimer += timeElapse
+			if node.electionTimer >= node.timeouts.Election + node.electionDelay() {
+				node.log.Info("start PreVote", "term", node.Term)
+				node.startPreVote()
+			}
+		}
+	} else if node.Role == RoleLeader {
+		if node.check != nil {
+			node.check.timer += timeElapse
+			if node.check.timer >= ConsistencyCheckTimeout {
+				node.finishConsistencyCheck()
+			}
+		}
+		if node.logCheck != nil {
+			node.logCheck.timer += timeElapse
+			if node.logCheck.timer >= LogCheckTimeout {
+				node.finishLogCheck()
+			}
+		}
+		for _, m := range node.Members {
+			m.ReceiveTimeout += timeElapse
+			m.ReplicateTimer += timeElapse
+			m.HeartbeatTimer += timeElapse
+			if m.MatchIndex < node.store.LastIndex {
+				m.BehindTimer += timeElapse
+			} else {
+				m.BehindTimer = 0
+			}
+			node.checkMemberLag(m)
+
+			if m.ReceiveTimeout < node.timeouts.Receive {
+				if m.ReplicateTimer >= node.timeouts.Replication && m.snapshot != nil {
+					node.resendSnapshotChunks(m)
+				} else if m.ReplicateTimer >= node.timeouts.Replication {
+					if m.MatchIndex != 0 && m.NextIndex != m.MatchIndex + 1 {
+						node.log.Info("resend member", "peer", m.Id, "next", m.NextIndex, "match", m.MatchIndex)
+						m.NextIndex = m.MatchIndex + 1
+						node.shrinkSendWindow(m, "timeout")
+					}
+					m.rejectNext = 0
+					node.replicateMember(m)
+				}
+			}
+			if m.HeartbeatTimer >= node.timeouts.Heartbeat {
+				// node.log.Debug("heartbeat timeout", "peer", m.Id)
+				// unsent entries serve as heartbeat, ping if none is sent
+				if m.ReceiveTimeout < node.timeouts.Receive && m.NextIndex <= node.store.LastIndex {
+					node.replicateMember(m)
+				}
+				if m.HeartbeatTimer >= node.timeouts.Heartbeat {
+					node.pingMember(m)
+				}
+			}
+		}
+		// a leader in minority partition can not commit, step down so that
+		// clients look for the leader of the majority
+		if len(node.Members) > 0 && node.quorumReceiveTimeout() >= node.timeouts.Receive {
+			node.log.Info("majority unreachable, step down", "term", node.Term)
+			node.becomeFollower()
+		}
+	}
+}
+
+func (node *Node)startPreVote(){
+	// randomized, so that nodes timed out together won't split votes again
+	node.electionTimer = node.rand.Intn(node.timeouts.Election/2)
+	node.Role = RoleFollower
+	node.votesReceived = make(map[string]string)
+	node.broadcast(NewPreVoteMsg())
+	
+	// 单节点运行
+	if len(node.Members) == 0 {
+		node.startElection()
+	}
+}
+
+func (node *Node)startElection(){
+	node.electionTimer = node.rand.Intn(node.timeouts.Election/2)
+	node.votesReceived = make(map[string]string)
+
+	node.Role = RoleCandidate
+	node.elections ++
+	node.failForwards(ErrLeadershipLost)
+	node.cancelSnapshotPull("election")
+	node.Term += 1
+	node.VoteFor = node.Id
+	node.store.SaveState()
+	node.recordEvent(EventTypeRole, "", 0, RoleCandidate)
+	node.recordAudit(AuditElection, fmt.Sprintf("lastTerm=%d lastIndex=%d", node.store.LastTerm, node.store.LastIndex))
+	node.emitEvent(SinkElectionStarted, "", node.store.LastIndex, "")
+
+	node.resetAllMember()
+	node.broadcast(NewRequestVoteMsg())
+	
+	// 单节点运行
+	if len(node.Members) == 0 {
+		node.checkVoteResult()
+	}
+}
+
+func (node *Node)checkVoteResult(){
+	grant := 1
+	reject := 0
+	for _, res := range node.votesReceived {
+		if res == "grant" {
+			grant ++
+		} else {
+			reject ++
+		}
+	}
+	if grant > (len(node.Members) + 1)/2 {
+		node.becomeLeader()
+	} else if reject > len(node.Members)/2 {
+		node.log.Info("election rejected", "term", node.Term, "grant", grant, "reject", reject, "total", len(node.Members)+1)
+		node.becomeFollower()
+	}
+}
+
+func (node *Node)becomeFollower(){
+	if node.Role == RoleFollower {
+		return
+	}
+	if node.Role == RoleLeader {
+		node.notifyLeadership(false)
+	}
+	node.notifyObservers(observerEvent{type_: observeFollower, term: node.Term})
+	node.Role = RoleFollower
+	node.electionTimer = 0	
+	node.resetAllMember()
+	node.abortTraces()
+	node.failApplyWaiters(ErrLeadershipLost)
+	node.recordEvent(EventTypeRole, "", 0, RoleFollower)
+	if node.check != nil {
+		node.finishConsistencyCheck()
+	}
+	if node.logCheck != nil {
+		node.finishLogCheck()
+	}
+}
+
+func (node *Node)becomeLeader(){
+	node.log.Info("became leader", "term", node.Term)
+
+	node.Role = RoleLeader
+	node.electionTimer = 0
+	node.cancelSnapshotPull("became leader")
+	node.resetAllMember()
+	node.recordEvent(EventTypeRole, "", 0, RoleLeader)
+	node.recordAudit(AuditBecomeLeader, fmt.Sprintf("votes=%d lastIndex=%d", len(node.votesReceived), node.store.LastIndex))
+	node.emitEvent(SinkElectionWon, "", node.store.LastIndex, "")
+	node.notifyLeadership(true)
+	node.notifyObservers(observerEvent{type_: observeLeader, term: node.Term, index: node.store.CommitIndex})
+	for _, m := range node.Members {
+		m.NextIndex = node.store.LastIndex
+	}
+	// config changes of old leaders not committed yet
+	node.pendingConfIndex = 0
+	for idx := node.store.CommitIndex + 1; idx <= node.store.LastIndex; idx ++ {
+		if ent := node.store.GetEntry(idx); ent != nil && (ent.Type == EntryTypeAddMember || ent.Type == EntryTypeDelMember || ent.Type == EntryTypeSetPriority) {
+			node.pendingConfIndex = idx
+		}
+	}
+	// write noop entry with currentTerm to implictly commit previous term's log
+	if node.store.LastIndex == 0 || node.store.LastIndex != node.store.CommitIndex {
+		node.appendOwnEntry(EntryTypeNoop, "")
+	} else {
+		node.pingAllMember()
+	}
+}
+
+/* ############################################# */
+
+// "" if leader unknown
+func (node *Node)leaderId() string {
+	if node.Role == RoleLeader {
+		return node.Id
+	}
+	if node.Role == RoleCandidate {
+		return ""
+	}
+	for _, m := range node.Members {
+		if m.Role == RoleLeader {
+			return m.Id
+		}
+	}
+	return ""
+}
+
+func (node *Node)resetAllMember(){
+	for _, m := range node.Members {
+		node.resetMember(m)
+	}
+}
+
+func (node *Node)resetMember(m *Member){
+	node.dropSnapshotTransfer(m)
+	m.Reset()
+	m.Role = RoleFollower
+	m.SendWindow = node.sendWindow
+}
+
+func (node *Node)pingAllMember(){
+	for _, m := range node.Members {
+		node.pingMember(m)
+	}
+}
+
+func (node *Node)pingMember(m *Member){
+	m.HeartbeatTimer = 0
+	
+	ent := NewPingEntry(node.store.CommitIndex)
+	prev := node.store.GetEntry(node.store.LastIndex)
+	node.send(NewAppendEntryMsg(m.Id, ent, prev))
+}
+
+// Tell members the new commitIndex now, instead of on next heartbeat.
+// Entries carry it, members with all entries sent are pinged.
+func (node *Node)notifyCommit(){
+	for _, m := range node.Members {
+		if m.NextIndex > node.store.LastIndex && m.ReceiveTimeout < node.timeouts.Receive {
+			node.pingMember(m)
+		}
+	}
+}
+
+func (node *Node)replicateAllMembers(){
+	for _, m := range node.Members {
+		node.replicateMember(m)
+	}
+	// entries proposed since last round, while followers are receiving them
+	node.store.Sync()
+	// 单节点运行
+	if len(node.Members) == 0 {
+		node.store.CommitEntry(node.store.LastIndex)
+		node.checkInvariants("commit")
+	}
+}
+
+func (node *Node)replicateMember(m *Member){
+	if m.snapshot != nil {
+		// entries after the snapshot are sent once it is installed
+		return
+	}
+	if m.MatchIndex != 0 && m.NextIndex - m.MatchIndex > m.SendWindow {
+		if node.log.DebugEnabled() {
+			node.log.Debug("stop and wait", "peer", m.Id, "next", m.NextIndex, "match", m.MatchIndex)
+		}
+		return
+	}
+
+	m.ReplicateTimer = 0
+	maxIndex := util.MaxInt64(m.NextIndex, m.MatchIndex + m.SendWindow)
+	prev := node.store.GetEntry(m.NextIndex - 1)
+	for _, ent := range node.store.GetEntries(m.NextIndex, maxIndex) {
+		ent.Commit = node.store.CommitIndex
+		
+		msg := NewAppendEntryMsg(m.Id, ent, prev)
+		node.traceAppendEntry(msg, ent)
+		node.send(msg)
+		
+		prev = ent
+		m.NextIndex ++
+		m.HeartbeatTimer = 0
+	}
+}
+
+// false if nodeId is a member already
+func (node *Node)addMember(nodeId string, nodeAddr string) bool {
+	if nodeId == node.Id {
+		return false
+	}
+	if node.Members[nodeId] != nil {
+		return false
+	}
+	m := NewMember(nodeId, nodeAddr)
+	node.resetMember(m)
+	node.Members[m.Id] = m
+	node.log.Info("add member", "peer", m.Id, "addr", m.Addr)
+	return true
+}
+
+func (node *Node)disconnectAllMember(){
+	for _, m := range node.Members {
+		// it's ok to delete item while iterating
+		node.removeMember(m.Id)
+	}
+}
+
+// false if nodeId is not a member
+func (node *Node)removeMember(nodeId string) bool {
+	if nodeId == node.Id {
+		return false
+	}
+	if node.Members[nodeId] == nil {
+		return false
+	}
+	m := node.Members[nodeId]
+	node.dropSnapshotTransfer(m)
+	delete(node.Members, nodeId)
+	node.stopReplicator(nodeId)
+	node.log.Info("disconnect member", "peer", m.Id, "addr", m.Addr)
+	return true
+}
+
+/* ############################################# */
+
+func (node *Node)handleRaftMessage(msg *Message){
+	if node.store.fault != nil {
+		return
+	}
+	defer node.checkInvariants(string(msg.Type))
+	node.recordEvent(EventTypeMessage, msg.Src, msg.PrevIndex, string(msg.Type))
+	if msg.Dst != node.Id || node.Members[msg.Src] == nil {
+		node.log.Warn("drop message from unknown src", "peer", msg.Src, "dst", msg.Dst)
+		return
+	}
+
+	// MUST: smaller msg.Term is rejected or ignored
+	if msg.Term < node.Term {
+		node.log.Info("reject message of smaller term", "peer", msg.Src, "type", msg.Type, "msgTerm", msg.Term, "term", node.Term)
+		node.send(NewNoneMsg(msg.Src))
+		// finish processing msg
+		return
+	}
+	// not with a live leader, see CheckLeader.go
+	if (msg.Type == MessageTypePreVote || msg.Type == MessageTypeRequestVote) && node.checkLeader(msg) {
+		return
+	}
+	// MUST: node.Term is set to be larger msg.Term, not by PreVote, which
+	// is not a campaign
+	if msg.Term > node.Term && msg.Type != MessageTypePreVote {
+		node.log.Info("receive greater term", "peer", msg.Src, "msgTerm", msg.Term, "term", node.Term)
+		// acked in the term entries are received
+		node.flushAck()
+		node.cancelSnapshotPull("term changed")
+		node.Term = msg.Term
+		node.VoteFor = ""
+		if node.Role != RoleFollower {
+			node.log.Info("became follower", "term", node.Term)
+			node.becomeFollower()
+		}
+		node.store.SaveState()
+		// continue processing msg
+	}
+	if msg.Type == MessageTypeNone {
+		return
+	}
+
+	if node.Role == RoleLeader {
+		if msg.Type == MessageTypeAppendEntryAck {
+			node.handleAppendEntryAck(msg)
+		} else if msg.Type == MessageTypeInstallSnapshotAck {
+			node.handleInstallSnapshotAck(msg)
+		} else if msg.Type == MessageTypePreVote {
+			node.handlePreVote(msg)
+		} else if msg.Type == MessageTypeStateHashAck {
+			node.handleStateHashAck(msg)
+		} else if msg.Type == MessageTypeLogHashAck {
+			node.handleLogHashAck(msg)
+		} else if msg.Type == MessageTypePropose {
+			node.handlePropose(msg)
+		} else {
+			node.dropMessage(msg)
+		}
+		return
+	}
+	if node.Role == RoleCandidate {
+		if msg.Type == MessageTypeRequestVoteAck {
+			node.handleRequestVoteAck(msg)
+		} else {
+			node.dropMessage(msg)
+		}
+		return
+	}
+	if node.Role == RoleFollower {
+		if msg.Type == MessageTypeRequestVote {
+			node.handleRequestVote(msg)
+		} else if msg.Type == MessageTypeAppendEntry {
+			node.handleAppendEntry(msg)
+		} else if msg.Type == MessageTypeInstallSnapshot {
+			node.handleInstallSnapshot(msg)
+		} else if msg.Type == MessageTypePreVote {
+			node.handlePreVote(msg)
+		} else if msg.Type == MessageTypePreVoteAck {
+			node.handlePreVoteAck(msg)
+		} else if msg.Type == MessageTypeStateHash {
+			node.handleStateHash(msg)
+		} else if msg.Type == MessageTypeLogHash {
+			node.handleLogHash(msg)
+		} else if msg.Type == MessageTypeProposeAck {
+			node.handleProposeAck(msg)
+		} else {
+			node.dropMessage(msg)
+		}
+		return
+	}
+}
+
+// msg.Encode() only when debug is on, called for every unexpected message
+func (node *Node)dropMessage(msg *Message){
+	if node.log.DebugEnabled() {
+		node.log.Debugf("drop message %s", msg.Encode())
+	}
+}
+
+// denied by checkLeader() while leader is active, and if RequestVote would
+// be denied for a shorter log
+func (node *Node)handlePreVote(msg *Message){
+	if msg.PrevTerm < node.store.LastTerm ||
+		(msg.PrevTerm == node.store.LastTerm && msg.PrevIndex < node.store.LastIndex) {
+		node.log.Info("candidate log is behind, deny PreVote", "peer", msg.Src)
+		return
+	}
+	if node.outranks(msg) {
+		return
+	}
+	node.send(NewPreVoteAck(msg.Src))
+}
+
+// ms since a majority(self included) was last heard from
+func (node *Node)quorumReceiveTimeout() int {
+	arr := make([]int, 0, len(node.Members) + 1)
+	arr = append(arr, 0) // self
+	for _, m := range node.Members {
+		arr = append(arr, m.ReceiveTimeout)
+	}
+	sort.Ints(arr)
+	if node.log.DebugEnabled() {
+		node.log.Debug("receive timeouts", "timeouts", arr)
+	}
+	return arr[len(arr)/2]
+}
+
+func (node *Node)handlePreVoteAck(msg *Message){
+	node.log.Info("receive PreVoteAck", "peer", msg.Src)
+	node.votesReceived[msg.Src] = msg.Data
+	if len(node.votesReceived) + 1 > (len(node.Members) + 1)/2 {
+		node.startElection()
+	}
+}
+
+func (node *Node)handleRequestVote(msg *Message){
+	// node.VoteFor == msg.Src: retransimitted/duplicated RequestVote
+	if node.VoteFor != "" && node.VoteFor != msg.Src {
+		// just ignore
+		node.log.Info("already voted, ignore RequestVote", "voteFor", node.VoteFor, "peer", msg.Src)
+		return
+	}
+	
+	granted := false
+	if msg.PrevTerm > node.store.LastTerm {
+		granted = true
+	} else if msg.PrevTerm == node.store.LastTerm && msg.PrevIndex >= node.store.LastIndex {
+		granted = true
+	} else {
+		// we've got newer log, reject
+	}
+
+	if granted {
+		node.electionTimer = 0
+		node.log.Info("vote for", "peer", msg.Src, "term", node.Term)
+		node.VoteFor = msg.Src
+		node.store.SaveState()
+		node.send(NewRequestVoteAck(msg.Src, true))
+	} else {
+		node.send(NewRequestVoteAck(msg.Src, false))
+	}
+}
+
+func (node *Node)handleRequestVoteAck(msg *Message){
+	node.log.Info("receive vote", "peer", msg.Src, "vote", msg.Data)
+	node.votesReceived[msg.Src] = msg.Data
+	node.checkVoteResult()
+}
+
+func (node *Node)sendDuplicatedAckToMessage(msg *Message){
+	node.flushAck()
+	var prev *Entry
+	// prev not matching, leader retries from the entry before it
+	if msg.PrevIndex <= node.store.LastIndex {
+		prev = node.store.GetEntry(msg.PrevIndex - 1)
+	} else {
+		prev = node.store.GetEntry(node.store.LastIndex)
+	}
+	
+	ack := NewAppendEntryAck(msg.Src, false)
+	if prev != nil {
+		ack.PrevTerm = prev.Term
+		ack.PrevIndex = prev.Index
+	}
+
+	node.send(ack)
+}
+
+func (node *Node)handleAppendEntry(msg *Message){
+	node.electionTimer = 0
+	m := node.Members[msg.Src]
+	if m.Role != RoleLeader {
+		node.cancelSnapshotPull("leader changed")
+		node.recordAudit(AuditLeaderChange, "leader=" + m.Id)
+		node.emitEvent(SinkLeaderChanged, m.Id, msg.PrevIndex, "")
+		node.notifyObservers(observerEvent{type_: observeFollower, term: node.Term, leader: m.Id})
+	}
+	m.Role = RoleLeader
+	m.ReceiveTimeout = 0
+	for _, m2 := range node.Members {
+		if m != m2 {
+			m2.Role = RoleFollower
+		}
+	}
+
+	if msg.PrevIndex > node.store.CommitIndex {
+		// with PrevIndex < LastIndex, entries after a matching prev are
+		// replaced if they conflict
+		if msg.PrevIndex > node.store.LastIndex {
+			node.log.Info("non-continuous entry", "peer", msg.Src, "prevIndex", msg.PrevIndex, "lastIndex", node.store.LastIndex)
+			node.sendDuplicatedAckToMessage(msg)
+			return
+		}
+		prev := node.store.GetEntry(msg.PrevIndex)
+		if prev == nil {
+			node.log.Info("prev entry not found", "peer", msg.Src, "prevTerm", msg.PrevTerm, "prevIndex", msg.PrevIndex)
+			node.sendDuplicatedAckToMessage(msg)
+			return
+		}
+		if prev.Term != msg.PrevTerm {
+			node.log.Info("prev entry term mismatch", "peer", msg.Src, "index", msg.PrevIndex, "entryTerm", prev.Term, "prevTerm", msg.PrevTerm)
+			node.sendDuplicatedAckToMessage(msg)
+			return
+		}
+	}
+
+	ent := decodePooledEntry(msg.Data)
+	if ent != nil {
+		// WriteEntry() keeps a copy
+		defer putEntry(ent)
+	}
+	if ent == nil || (ent.Type != EntryTypePing && ent.Index == 0) {
+		node.log.Warn("bad entry", "peer", msg.Src, "data", msg.Data)
+		return
+	}
+
+	if ent.Type == EntryTypePing {
+		index := util.MaxInt64(node.clearAck(), node.matchedIndex(msg.PrevIndex))
+		node.store.Sync()
+		node.send(node.withEffectsHash(node.newMatchAck(msg.Src, index)))
+	} else {
+		if ent.Index < node.store.CommitIndex {
+			node.log.Info("entry before committed", "peer", msg.Src, "index", ent.Index, "commitIndex", node.store.CommitIndex)
+			node.sendDuplicatedAckToMessage(msg)
+			return
+		}
+
+		// a conflicting entry and those that follow are deleted by WriteEntry()
+		if node.log.DebugEnabled() {
+			if old := node.store.GetEntry(ent.Index); old != nil && old.Term == ent.Term {
+				node.log.Debug("duplicated entry", "entryTerm", ent.Term, "index", ent.Index)
+			}
+		}
+		span := node.startAppendSpan(msg)
+		// leader counts acked entries as durable, MatchIndex never goes back,
+		// fsynced once for the batch before ack is sent
+		node.store.WriteEntry(*ent)
+		node.delayAck(msg.Src, ent.Index)
+		if span != nil {
+			span.End()
+		}
+	}
+
+	node.store.CommitEntry(ent.Commit)
+}
+
+func (node *Node)handleAppendEntryAck(msg *Message){
+	m := node.Members[msg.Src]
+	m.ReceiveTimeout = 0
+
+	if msg.Data == "false" {
+		if msg.PrevIndex < m.MatchIndex {
+			// stale, entries up to MatchIndex are known to match, rewinding
+			// would resend them and trigger more stale rejections
+			if node.log.DebugEnabled() {
+				node.log.Debug("ignore stale reject", "peer", m.Id, "prevIndex", msg.PrevIndex, "match", m.MatchIndex)
+			}
+			return
+		}
+		if msg.PrevIndex + 1 == m.rejectNext {
+			// every entry of the resent window is rejected the same way,
+			// resending on each would multiply messages. Tick() resends.
+			return
+		}
+		m.rejectNext = msg.PrevIndex + 1
+		node.log.Info("reset nextIndex", "peer", m.Id, "next", m.NextIndex, "newNext", msg.PrevIndex + 1)
+		m.NextIndex = msg.PrevIndex + 1
+		node.shrinkSendWindow(m, "reject")
+	} else {
+		node.checkEffectsHash(m, msg.Data)
+		// pings are acked while snapshot chunks are being received
+		if m.snapshot != nil && msg.PrevIndex < m.snapshot.lastIndex {
+			return
+		}
+		node.dropSnapshotTransfer(m)
+		m.snapshot = nil
+		m.InstallingSnapshot = false
+		m.rejectNext = 0
+		node.growSendWindow(m, msg.PrevIndex - m.MatchIndex)
+		m.MatchIndex = util.MaxInt64(m.MatchIndex, msg.PrevIndex)
+		m.NextIndex  = util.MaxInt64(m.NextIndex, m.MatchIndex + 1)
+		if m.MatchIndex >= node.store.LastIndex {
+			m.BehindTimer = 0
+		}
+		if m.MatchIndex > node.store.CommitIndex {
+			commitIndex := node.checkCommitIndex()
+			if commitIndex > node.store.CommitIndex {
+				// only commit currentTerm's log
+				ent := node.store.GetEntry(commitIndex)
+				if ent.Term == node.Term {
+					node.store.CommitEntry(commitIndex)
+					node.notifyCommit()
+					if m.MatchIndex == node.store.LastIndex {
+						return
+					}
+				}
+			}
+		}
+	}
+
+	// force new node added to group to install snapshot, avoid replaying too many logs.
+	if msg.PrevIndex == 0 && node.store.snapshot.NewMemberSnapshot {
+		node.log.Info("new node, notify it to install snapshot", "peer", m.Id)
+		node.sendInstallSnapshot(m)
+		return
+	}
+	if m.NextIndex < node.store.FirstIndex {
+		node.log.Info("follower out-of-sync, notify it to install snapshot", "peer", m.Id)
+		node.sendInstallSnapshot(m)
+		return
+	}
+	node.replicateMember(m)
+}
+
+func (node *Node)checkCommitIndex() int64 {
+	// sort matchIndex[] in descend order
+	matchIndex := make([]int64, 0, len(node.Members) + 1)
+	matchIndex = append(matchIndex, node.store.durableIndex) // self
+	for _, m := range node.Members {
+		matchIndex = append(matchIndex, m.MatchIndex)
+	}
+	sort.Slice(matchIndex, func(i, j int) bool{
+		return matchIndex[i] > matchIndex[j]
+	})
+	commitIndex := matchIndex[len(matchIndex)/2]
+	if node.log.DebugEnabled() {
+		node.log.Debug("check commit index", "match", matchIndex, "commit", commitIndex)
+	}
+	return commitIndex
+}
+
+// in chunks, see SnapshotChunk.go
+func (node *Node)sendInstallSnapshot(m *Member){
+	if m.snapshot != nil {
+		// being sent, resent on replication timeout
+		return
+	}
+	sn := node.store.CreateSnapshot()
+	if sn == nil {
+		node.log.Error("CreateSnapshot() error!", "peer", m.Id)
+		return
+	}
+	m.snapshot = &snapshotTransfer{lastTerm: sn.LastTerm(), lastIndex: sn.LastIndex()}
+	node.snapshotsSent ++
+	m.InstallingSnapshot = true
+	node.emitEvent(SinkSnapshotSent, m.Id, sn.LastIndex(), "")
+	if sn.pendingService == nil || node.savesServiceLocked() {
+		data, file, err := node.encodeSnapshotTransfer(sn, node.snapshotServer, node.snapshotChunkSize())
+		if err != nil {
+			node.failSnapshotTransfer(m, err)
+			return
+		}
+		node.startSnapshotTransfer(m, data, file)
+		node.sendSnapshotChunks(m)
+		return
+	}
+	node.buildSnapshotTransfer(m, sn)
+}
+
+func (node *Node)handleInstallSnapshot(msg *Message){
+	node.electionTimer = 0
+	node.Members[msg.Src].ReceiveTimeout = 0
+	if strings.HasPrefix(msg.Data, snapshotStreamPrefix) {
+		node.pullSnapshot(msg)
+		return
+	}
+	data := node.receiveSnapshotChunk(msg)
+	if data == "" {
+		return
+	}
+	node.installSnapshotData(msg.Src, data)
+}
+
+// with node's lock held, data received from leader
+func (node *Node)installSnapshotData(leader string, data string){
+	sn := NewSnapshotFromString(data)
+	if sn == nil {
+		node.log.Error("NewSnapshotFromString() error!", "peer", leader)
+		return
+	}
+	node.installLeaderSnapshot(leader, sn)
+}
+
+// with node's lock held
+func (node *Node)installLeaderSnapshot(leader string, sn *Snapshot){
+	node._installSnapshot(sn)
+	// members are re-added by the snapshot, still following leader
+	if m := node.Members[leader]; m != nil {
+		m.Role = RoleLeader
+	}
+	node.send(NewAppendEntryAck(leader, true))
+}
+
+func (node *Node)_installSnapshot(sn *Snapshot) bool {
+	node.log.Info("install Raft snapshot", "index", sn.LastIndex())
+	node.disconnectAllMember()
+	for nodeId, nodeAddr := range sn.State().Members {
+		node.addMember(nodeId, nodeAddr)
+	}
+	node.loadPriorities(sn.State())
+	atomic.StoreInt64(&node.lastApplied, sn.LastIndex())
+	node.snapshotsInstalled ++
+
+	ok := node.store.InstallSnapshot(sn)
+	node.restoreService(sn)
+	node.notifyCommitWaiters()
+	node.recordAudit(AuditInstallSnapshot, fmt.Sprintf("lastTerm=%d lastIndex=%d ok=%v", sn.LastTerm(), sn.LastIndex(), ok))
+	node.emitEvent(SinkSnapshotInstalled, "", sn.LastIndex(), fmt.Sprintf("ok=%v", ok))
+	node.notifyMembership(sn.LastIndex())
+	node.notifyObservers(observerEvent{type_: observeSnapshot, term: sn.LastTerm(), index: sn.LastIndex()})
+	return ok
+}
+
+/* ###################### Service interface ####################### */
+
+// Safe to be called without lock
+func (node *Node)LastApplied() int64{
+	return atomic.LoadInt64(&node.lastApplied)
+}
+
+func (node *Node)ApplyEntry(ent *Entry){
+	atomic.StoreInt64(&node.lastApplied, ent.Index)
+
+	// 注意, 不能在 ApplyEntry 里修改 CommitIndex
+	// 重启后可能再次 apply @LastApplied 之后的 entry, 成员没有变化时跳过
+	if ent.Type == EntryTypeAddMember {
+		node.log.Info("apply", "index", ent.Index, "entry", ent.Encode())
+		ps := strings.Split(ent.Data, " ")
+		added := len(ps) == 2 && (node.addMember(ps[0], ps[1]) || ps[0] == node.Id)
+		if added {
+			// 0 until a SetPriority entry, replaces the configured one of
+			// this node
+			node.setPriority(ps[0], 0)
+			node.store.saveLastApplied()
+			node.store.SaveState()
+			node.recordAudit(AuditAddMember, fmt.Sprintf("index=%d id=%s addr=%s", ent.Index, ps[0], ps[1]))
+			node.emitEvent(SinkConfigChange, ps[0], ent.Index, "add " + ps[1])
+			node.notifyMembership(ent.Index)
+		}
+	}else if ent.Type == EntryTypeDelMember {
+		node.log.Info("apply", "index", ent.Index, "entry", ent.Encode())
+		nodeId := ent.Data
+		// the deleted node would not receive a commit msg that it had been deleted
+		if node.removeMember(nodeId) {
+			node.store.saveLastApplied()
+			node.store.SaveState()
+			node.recordAudit(AuditDelMember, fmt.Sprintf("index=%d id=%s", ent.Index, nodeId))
+			node.emitEvent(SinkConfigChange, nodeId, ent.Index, "del")
+			node.notifyMembership(ent.Index)
+		}
+	}else if ent.Type == EntryTypeSetPriority {
+		node.applySetPriority(ent)
+	}
+}
+
+/* ###################### Quorum Methods ####################### */
+
+// Index of the entry, -1 on error, see Errors.go
+func (node *Node)AddMember(nodeId string, nodeAddr string) (int64, error) {
+	node.mux.Lock()
+	defer node.unlock()
+	defer node.checkInvariants("add member")
+
+	if node.Role != RoleLeader && len(node.Members) == 0 && !node.closed && !node.witness {
+		// TODO: init state from storage
+		node.becomeLeader();
+	}
+	data := fmt.Sprintf("%s %s", nodeId, nodeAddr)
+	return node.proposeConfChange(EntryTypeAddMember, data)
+}
+
+func (node *Node)DelMember(nodeId string) (int64, error) {
+	node.mux.Lock()
+	defer node.unlock()
+	defer node.checkInvariants("del member")
+
+	return node.proposeConfChange(EntryTypeDelMember, nodeId)
+}
+
+// one membership change at a time
+func (node *Node)proposeConfChange(type_ EntryType, data string) (int64, error) {
+	if err := node.checkWritable(); err != nil {
+		node.log.Warn("reject config change", "type", type_, "err", err)
+		return -1, err
+	}
+	if node.pendingConfIndex > node.store.CommitIndex {
+		node.log.Warn("reject config change", "type", type_, "pending", node.pendingConfIndex)
+		return -1, ErrConfigChangeInProgress
+	}
+	ent := node.appendOwnEntry(type_, data)
+	node.pendingConfIndex = ent.Index
+	return ent.Index, nil
+}
+
+// Term and index of the entry, -1 on error, see Errors.go
+func (node *Node)Propose(data string) (int32, int64, error) {
+	return node.ProposeWithTrace(data, trace.SpanContext{})
+}
+
+// Same as Propose, traces replication and apply of the entry as children of parent
+// Without node's lock, see Proposals.go
+func (node *Node)ProposeWithTrace(data string, parent trace.SpanContext) (int32, int64, error) {
+	p := &proposal{parent: parent}
+	if err := node.submitProposal(nil, p, data); err != nil {
+		return -1, -1, err
+	}
+	return p.ent.Term, p.ent.Index, nil
+}
+
+// with node's lock held, appended before returning
+func (node *Node)propose(data string, parent trace.SpanContext) (*Entry, error) {
+	node.proposeMux.Lock()
+	defer node.proposeMux.Unlock()
+	node.flushProposals()
+	p := &proposal{parent: parent}
+	if err := node.acceptProposal(p, data); err != nil {
+		return nil, err
+	}
+	node.flushProposals()
+	return p.ent, nil
+}
+
+/* ###################### Operations ####################### */
+
+func (node *Node)InfoMap() map[string]string {
+	s := node.Status()
+	
+	m := make(map[string]string)
+	m["id"] = s.Id
+	m["addr"] = s.Addr
+	m["role"] = string(s.Role)
+	m["term"] = fmt.Sprintf("%d", s.Term)
+	m["voteFor"] = s.VoteFor
+	m["priority"] = fmt.Sprintf("%d", s.Priority)
+	m["witness"] = fmt.Sprintf("%v", s.Witness)
+	m["lastApplied"] = fmt.Sprintf("%d", s.LastApplied)
+	m["commitIndex"] = fmt.Sprintf("%d", s.CommitIndex)
+	m["lastTerm"] = fmt.Sprintf("%d", s.LastTerm)
+	m["lastIndex"] = fmt.Sprintf("%d", s.LastIndex)
+	m["fsyncPolicy"] = s.FsyncPolicy.String()
+	m["recovery"] = s.Recovery.String()
+	sm := node.StorageMetrics()
+	m["firstIndex"] = fmt.Sprintf("%d", sm.FirstIndex)
+	m["logEntries"] = fmt.Sprintf("%d", sm.Entries)
+	m["logBytes"] = fmt.Sprintf("%d", sm.Bytes)
+	m["applyLag"] = fmt.Sprintf("%d", sm.ApplyLag)
+	m["entryCacheBytes"] = fmt.Sprintf("%d", sm.EntryCacheBytes)
+	m["fsyncs"] = fmt.Sprintf("%d", sm.Fsync.Count)
+	if sm.Fsync.Count > 0 {
+		m["fsyncAvgMs"] = fmt.Sprintf("%.3f", sm.Fsync.Sum * 1000 / float64(sm.Fsync.Count))
+	}
+	b, _ := json.Marshal(s.Members)
+	m["members"] = string(b)
+	b, _ = json.Marshal(node.Metrics().Members)
+	m["replication"] = string(b)
+	return m
+}
+
+func (node *Node)Info() string {
+	s := node.Status()
+	
+	var ret string
+	ret += fmt.Sprintf("id: %s\n", s.Id)
+	ret += fmt.Sprintf("addr: %s\n", s.Addr)
+	ret += fmt.Sprintf("role: %s\n", s.Role)
+	ret += fmt.Sprintf("term: %d\n", s.Term)
+	ret += fmt.Sprintf("voteFor: %s\n", s.VoteFor)
+	ret += fmt.Sprintf("priority: %d\n", s.Priority)
+	ret += fmt.Sprintf("witness: %v\n", s.Witness)
+	ret += fmt.Sprintf("lastApplied: %d\n", s.LastApplied)
+	ret += fmt.Sprintf("commitIndex: %d\n", s.CommitIndex)
+	ret += fmt.Sprintf("lastTerm: %d\n", s.LastTerm)
+	ret += fmt.Sprintf("lastIndex: %d\n", s.LastIndex)
+	ret += fmt.Sprintf("electionTimer: %d\n", s.ElectionTimer)
+	ret += fmt.Sprintf("fsyncPolicy: %s\n", s.FsyncPolicy)
+	ret += fmt.Sprintf("recovery: %s\n", s.Recovery)
+	b, _ := json.Marshal(s.Members)
+	ret += fmt.Sprintf("members: %s\n", string(b))
+
+	return ret
+}
+
+// Copies of entries in [lo, hi], see Storage.GetEntries()
+func (node *Node)GetEntries(lo int64, hi int64) []Entry {
+	node.mux.Lock()
+	defer node.mux.Unlock()
+
+	ents := node.store.GetEntries(lo, hi)
+	ret := make([]Entry, len(ents))
+	for i, ent := range ents {
+		ret[i] = *ent
+	}
+	return ret
+}
+
+// State and the latest committed entries are copied with lock held,
+// Service's state is saved without it once the apply goroutine is started,
+// see ServiceSnapshot.go
+func (node *Node)CreateSnapshot() *Snapshot {
+	node.mux.Lock()
+	sn := node.store.CreateSnapshot()
+	if sn != nil && node.savesServiceLocked() {
+		sn.saveService(node.log)
+	}
+	node.mux.Unlock()
+
+	if sn != nil {
+		sn.saveService(node.log)
+	}
+	return sn
+}
+
+func (node *Node)InstallSnapshot(sn *Snapshot) bool {
+	node.mux.Lock()
+	defer node.unlock()
+	
+	defer node.checkInvariants("install snapshot")
+	return node._installSnapshot(sn)
+}
+
+func (node *Node)JoinGroup(leaderId string, leaderAddr string) {
+	node.mux.Lock()
+	defer node.unlock()
+	
+	if leaderId == node.Id {
+		node.log.Warn("could not join self", "leader", leaderId)
+		return
+	}
+	if len(node.Members) > 0 {
+		node.log.Warn("already in group")
+		return
+	}
+	node.log.Info("JoinGroup", "leader", leaderId, "addr", leaderAddr)
+
+	node.cancelSnapshotPull("join group")
+	node.Term = 0
+	node.VoteFor = ""
+	atomic.StoreInt64(&node.lastApplied, 0)
+	node.addMember(leaderId, leaderAddr)
+	node.becomeFollower()
+	
+	node.log.Info("clean Raft database")
+	node.store.CleanAll()
+	node.resetInvariants()
+	node.effects = make(map[int64]uint64)
+	node.effectIndexes = nil
+	node.effectReported = 0
+	node.recordAudit(AuditJoinGroup, fmt.Sprintf("leader=%s addr=%s", leaderId, leaderAddr))
+}
+
+func (node *Node)QuitGroup() {
+	node.mux.Lock()
+	defer node.unlock()
+	
+	node.log.Info("QuitGroup")
+	node.disconnectAllMember()
+	node.store.SaveState()
+	node.recordAudit(AuditQuitGroup, "")
+}
+
+
+/* ############################################# */
+
+func (node *Node)send(msg *Message){
+	// acks of entries which may not be durable
+	if node.store.fault != nil {
+		return
+	}
+	msg.Src = node.Id
+	msg.Term = node.Term
+	// not set by caller, entries of term 0 have PrevTerm 0 too
+	if msg.PrevTerm == 0 && msg.PrevIndex == 0 {
+		msg.PrevTerm = node.store.LastTerm
+		msg.PrevIndex = node.store.LastIndex
+	}
+	if node.outbox != nil {
+		node.outbox(msg)
+		return
+	}
+	if node.sendToReplicator(msg) {
+		return
+	}
+	node.enqueueSend(msg)
+}
+
+func (node *Node)broadcast(msg *Message){
+	for _, m := range node.Members {
+		// msg is sent asynchronously, don't share it between members
+		cp := *msg
+		cp.Dst = m.Id
+		node.send(&cp)
+	}
+}
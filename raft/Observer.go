@@ -0,0 +1,132 @@
+package raft
+
+// Callbacks of Node.Subscribe(). Like LeadershipObserver, events are queued
+// with node's lock held and delivered in order by a goroutine which exits
+// when the queue is empty, so an observer may call into node. A slow
+// observer delays the others, not raft. Embed NoopObserver to implement a
+// part of them.
+type RaftObserver interface{
+	// commitIndex when the node became leader
+	BecameLeader(term int32, commitIndex int64)
+	// stepped down, or follows a new leader, leader is "" if unknown
+	BecameFollower(term int32, leader string)
+	// AddMember/DelMember applied, or a snapshot installed. id => addr of
+	// every member, this node included
+	MembershipChanged(index int64, members map[string]string)
+	// CommitIndex advanced to index, advances queued and not delivered yet
+	// are merged into one
+	EntryCommitted(index int64)
+	SnapshotInstalled(lastTerm int32, lastIndex int64)
+}
+
+type NoopObserver struct{}
+
+func (NoopObserver)BecameLeader(term int32, commitIndex int64) {}
+func (NoopObserver)BecameFollower(term int32, leader string) {}
+func (NoopObserver)MembershipChanged(index int64, members map[string]string) {}
+func (NoopObserver)EntryCommitted(index int64) {}
+func (NoopObserver)SnapshotInstalled(lastTerm int32, lastIndex int64) {}
+
+const(
+	observeLeader = iota
+	observeFollower
+	observeMembers
+	observeCommit
+	observeSnapshot
+)
+
+// observers need not be comparable, subscriptions are
+type observerSub struct{
+	RaftObserver
+}
+
+type observerEvent struct{
+	type_ int
+	// subscribers when queued, see Subscribe()
+	obs []*observerSub
+	term int32
+	index int64
+	leader string
+	members map[string]string
+}
+
+// Events after Subscribe() returns are delivered to observer until cancel
+// is called, those queued before cancel may still be.
+func (node *Node)Subscribe(observer RaftObserver) (cancel func()) {
+	node.observerMux.Lock()
+	defer node.observerMux.Unlock()
+	sub := &observerSub{observer}
+	// copied, so that queued events keep theirs
+	node.observers = append(append([]*observerSub{}, node.observers...), sub)
+	return func() {
+		node.observerMux.Lock()
+		defer node.observerMux.Unlock()
+		obs := make([]*observerSub, 0, len(node.observers))
+		for _, o := range node.observers {
+			if o != sub {
+				obs = append(obs, o)
+			}
+		}
+		node.observers = obs
+	}
+}
+
+// with node's lock held
+func (node *Node)notifyObservers(ev observerEvent){
+	node.observerMux.Lock()
+	defer node.observerMux.Unlock()
+	if len(node.observers) == 0 {
+		return
+	}
+	ev.obs = node.observers
+	if n := len(node.observerQ); ev.type_ == observeCommit && n > 0 {
+		last := &node.observerQ[n - 1]
+		if last.type_ == observeCommit && len(last.obs) == len(ev.obs) && &last.obs[0] == &ev.obs[0] {
+			last.index = ev.index
+			return
+		}
+	}
+	node.observerQ = append(node.observerQ, ev)
+	if !node.observerRunning {
+		node.observerRunning = true
+		go node.deliverObserverEvents()
+	}
+}
+
+func (node *Node)deliverObserverEvents(){
+	for {
+		node.observerMux.Lock()
+		if len(node.observerQ) == 0 {
+			node.observerRunning = false
+			node.observerMux.Unlock()
+			return
+		}
+		ev := node.observerQ[0]
+		node.observerQ = node.observerQ[1:]
+		node.observerMux.Unlock()
+
+		for _, o := range ev.obs {
+			switch ev.type_ {
+			case observeLeader:
+				o.BecameLeader(ev.term, ev.index)
+			case observeFollower:
+				o.BecameFollower(ev.term, ev.leader)
+			case observeMembers:
+				o.MembershipChanged(ev.index, ev.members)
+			case observeCommit:
+				o.EntryCommitted(ev.index)
+			case observeSnapshot:
+				o.SnapshotInstalled(ev.term, ev.index)
+			}
+		}
+	}
+}
+
+// with node's lock held
+func (node *Node)notifyMembership(index int64){
+	members := map[string]string{node.Id: node.Addr}
+	for _, m := range node.Members {
+		members[m.Id] = m.Addr
+	}
+	node.notifyObservers(observerEvent{type_: observeMembers, index: index, members: members})
+}
@@ -0,0 +1,98 @@
+package raft
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/fallowu/big-ssdb/logger"
+)
+
+// events as strings, commits only when index is reached
+type testObserver struct{
+	NoopObserver
+	c chan string
+}
+
+func newTestObserver() *testObserver {
+	return &testObserver{c: make(chan string, 1000)}
+}
+
+func (o *testObserver)BecameLeader(term int32, commitIndex int64) {
+	o.c <- "leader"
+}
+
+func (o *testObserver)BecameFollower(term int32, leader string) {
+	o.c <- "follower " + leader
+}
+
+func (o *testObserver)MembershipChanged(index int64, members map[string]string) {
+	o.c <- fmt.Sprintf("members %d", len(members))
+}
+
+func (o *testObserver)EntryCommitted(index int64) {
+	o.c <- fmt.Sprintf("commit %d", index)
+}
+
+func (o *testObserver)SnapshotInstalled(lastTerm int32, lastIndex int64) {
+	o.c <- fmt.Sprintf("snapshot %d", lastIndex)
+}
+
+// waits for want in order, other events in between are skipped
+func (o *testObserver)expect(t *testing.T, wants ...string) {
+	t.Helper()
+	for _, want := range wants {
+		for got := ""; got != want; {
+			select {
+			case got = <-o.c:
+			case <-time.After(3 * time.Second):
+				t.Fatal("no event", want)
+			}
+		}
+	}
+}
+
+func TestObserver(t *testing.T){
+	logger.SetDefaultLevel(logger.LevelError)
+	s := newMemSim(t, 1, "n1", "n2")
+	s.net.SetDefault(MemLinkConfig{Delay: time.Millisecond})
+	n1, n2 := s.nodes["n1"], s.nodes["n2"]
+	o1, o2 := newTestObserver(), newTestObserver()
+	n1.Subscribe(o1)
+	cancel := n2.Subscribe(o2)
+
+	n1.AddMember("n1", "addr-n1")
+	n1.StepTick(0)
+	// committed, then applied
+	o1.expect(t, "leader", "commit 2", "members 1")
+	for i := 0; i < 10; i ++ {
+		n1.Propose(fmt.Sprintf("set k%d v", i))
+		n1.StepTick(0)
+	}
+	o1.expect(t, "commit 12")
+
+	index, _ := n1.AddMember("n2", "addr-n2")
+	n2.JoinGroup("n1", "addr-n1")
+	s.until(10 * time.Second, "join", func() bool {
+		return n2.Metrics().CommitIndex >= index
+	})
+	o1.expect(t, "members 2")
+	o2.expect(t, "follower n1", "members 2")
+
+	n1.mux.Lock()
+	n1.becomeFollower()
+	n1.unlock()
+	o1.expect(t, "follower ")
+
+	// none after cancel
+	cancel()
+	s.until(10 * time.Second, "elect", func() bool {
+		return s.leader("n1", "n2") != ""
+	})
+	time.Sleep(10 * time.Millisecond)
+	for len(o2.c) > 0 {
+		if ev := <-o2.c; ev == "leader" {
+			t.Fatal("after cancel", ev)
+		}
+	}
+}
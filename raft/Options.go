@@ -0,0 +1,179 @@
+package raft
+
+import (
+	"crypto/tls"
+
+	"github.com/fallowu/big-ssdb/logger"
+)
+
+// ms, as Tick(timeElapse)
+type Timeouts struct{
+	Election int
+	Heartbeat int
+	Replication int
+	// member not heard from within it is unreachable
+	Receive int
+}
+
+func DefaultTimeouts() Timeouts {
+	return Timeouts{
+		Election: ElectionTimeout,
+		Heartbeat: HeartbeatTimeout,
+		Replication: ReplicationTimeout,
+		Receive: ReceiveTimeout,
+	}
+}
+
+const(
+	// committed entries in a raft snapshot, a follower checks prevEntry
+	// against them
+	DefaultSnapshotEntries = 2
+	DefaultSendWindow = 3
+	DefaultMaxSendWindow = 64
+)
+
+type SnapshotPolicy struct{
+	// latest committed entries included, at least 2
+	Entries int64
+	// members joining with an empty log install a snapshot instead of
+	// replaying the whole log
+	NewMemberSnapshot bool
+	// bytes per InstallSnapshot message, 0 for DefaultSnapshotChunkSize
+	ChunkSize int
+}
+
+func DefaultSnapshotPolicy() SnapshotPolicy {
+	return SnapshotPolicy{Entries: DefaultSnapshotEntries, NewMemberSnapshot: true}
+}
+
+// Applied by NewNode() in order
+type Option func(opts *options)
+
+type options struct{
+	timeouts Timeouts
+	log *logger.Logger
+	clock Clock
+	snapshot SnapshotPolicy
+	sendWindow int64
+	maxSendWindow int64
+	admission AdmissionConfig
+	manualTick bool
+	// 0 seeds by time
+	randSeed int64
+	// see LogDb.go
+	logDb LogDb
+	entryCacheSize int64
+	fsyncPolicy FsyncPolicy
+	// see Priority.go
+	priority int
+	// see Witness.go
+	witness bool
+	// see DiskMonitor.go
+	diskUsage func(dir string) (DiskUsage, error)
+	// see SnapshotStream.go
+	pullDir string
+	pullTLS *tls.Config
+}
+
+func defaultOptions(nodeId string) *options {
+	return &options{
+		timeouts: DefaultTimeouts(),
+		log: logger.New("raft").With("node", nodeId),
+		clock: SystemClock,
+		snapshot: DefaultSnapshotPolicy(),
+		sendWindow: DefaultSendWindow,
+		maxSendWindow: DefaultMaxSendWindow,
+		entryCacheSize: DefaultEntryCacheSize,
+		diskUsage: GetDiskUsage,
+	}
+}
+
+// Zero fields keep defaults
+func WithTimeouts(t Timeouts) Option {
+	return func(opts *options) {
+		def := DefaultTimeouts()
+		if t.Election <= 0 {
+			t.Election = def.Election
+		}
+		if t.Heartbeat <= 0 {
+			t.Heartbeat = def.Heartbeat
+		}
+		if t.Replication <= 0 {
+			t.Replication = def.Replication
+		}
+		if t.Receive <= 0 {
+			t.Receive = def.Receive
+		}
+		opts.timeouts = t
+	}
+}
+
+// Storage logs through subsystem "storage" with the same fields
+func WithLogger(l *logger.Logger) Option {
+	return func(opts *options) {
+		opts.log = l
+	}
+}
+
+func WithClock(c Clock) Option {
+	return func(opts *options) {
+		opts.clock = c
+	}
+}
+
+func WithSnapshotPolicy(p SnapshotPolicy) Option {
+	return func(opts *options) {
+		if p.Entries < DefaultSnapshotEntries {
+			p.Entries = DefaultSnapshotEntries
+		}
+		opts.snapshot = p
+	}
+}
+
+// initial max entries sent to a member and not acked yet, see SendWindow.go
+func WithSendWindow(n int64) Option {
+	return func(opts *options) {
+		if n > 0 {
+			opts.sendWindow = n
+		}
+	}
+}
+
+// upper bound of an adaptive send window, n = WithSendWindow() fixes it
+func WithMaxSendWindow(n int64) Option {
+	return func(opts *options) {
+		if n > 0 {
+			opts.maxSendWindow = n
+		}
+	}
+}
+
+// See AdmissionConfig, unlimited by default
+func WithAdmission(conf AdmissionConfig) Option {
+	return func(opts *options) {
+		opts.admission = conf
+	}
+}
+
+// Start() runs no ticker, time is driven by ManualTick(). With a ManualClock
+// from WithClock() timers advance with it
+func WithManualTick() Option {
+	return func(opts *options) {
+		opts.manualTick = true
+	}
+}
+
+// seed of election timeouts, so that tests are repeatable
+func WithRandSeed(seed int64) Option {
+	return func(opts *options) {
+		opts.randSeed = seed
+	}
+}
+
+// bytes of entries written to db and kept in memory, the least recently used
+// ones are evicted beyond it, <= 0 for no limit. See EntryCache.go
+func WithEntryCacheSize(bytes int64) Option {
+	return func(opts *options) {
+		opts.entryCacheSize = bytes
+	}
+}
@@ -0,0 +1,41 @@
+package raft
+
+import (
+	"testing"
+
+	"github.com/fallowu/big-ssdb/logger"
+)
+
+func TestOptions(t *testing.T){
+	logger.SetDefaultLevel(logger.LevelError)
+	clock := NewManualClock()
+	l := logger.New("embedded")
+	n1 := NewNode("n1", "addr1", NewMemDb(),
+		WithTimeouts(Timeouts{Election: 500}),
+		WithLogger(l),
+		WithClock(clock),
+		WithSnapshotPolicy(SnapshotPolicy{Entries: 5}),
+		WithSendWindow(8))
+	if n1.timeouts.Election != 500 || n1.timeouts.Heartbeat != HeartbeatTimeout {
+		t.Fatal("timeouts", n1.timeouts)
+	}
+	if n1.log != l || n1.clock != clock || n1.store.snapshot.NewMemberSnapshot {
+		t.Fatal("options not applied")
+	}
+
+	n1.SetOutbox(func(msg *Message) {})
+	n1.AddMember("n1", "addr1")
+	n1.StepTick(0)
+	for i := 0; i < 10; i ++ {
+		n1.Propose("data")
+	}
+	n1.StepTick(0)
+	if sn := n1.CreateSnapshot(); len(sn.Entries()) != 5 {
+		t.Fatal("snapshot entries", len(sn.Entries()))
+	}
+	n1.AddMember("n2", "addr2")
+	n1.StepTick(0)
+	if m := n1.Members["n2"]; m == nil || m.SendWindow != 8 {
+		t.Fatal("send window", m)
+	}
+}
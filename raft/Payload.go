@@ -0,0 +1,77 @@
+package raft
+
+import (
+	"errors"
+	"strings"
+	"encoding/base64"
+)
+
+// Current version of the Payload encoding
+const PayloadVersion = 1
+
+const payloadMagic = "P1 "
+
+var ErrBadPayload = errors.New("bad payload")
+
+// Versioned envelope for Data of EntryTypeData: an op code and binary safe
+// args, so that args containing spaces are never split. Encoded on one line,
+// as entries are stored:
+//
+//	P1 <op> <base64 arg>...
+//
+// Data written by old versions("set key val" strings) is still decoded.
+type Payload struct{
+	Op string
+	Args []string
+}
+
+func NewPayload(op string, args ...string) *Payload {
+	return &Payload{op, args}
+}
+
+func (p *Payload)Encode() string {
+	var b strings.Builder
+	b.WriteString(payloadMagic)
+	b.WriteString(p.Op)
+	for _, arg := range p.Args {
+		b.WriteString(" ")
+		b.WriteString(base64.RawStdEncoding.EncodeToString([]byte(arg)))
+	}
+	return b.String()
+}
+
+// "" if idx is out of range
+func (p *Payload)Arg(idx int) string {
+	if idx >= len(p.Args) {
+		return ""
+	}
+	return p.Args[idx]
+}
+
+func DecodePayload(data string) (*Payload, error) {
+	if !strings.HasPrefix(data, payloadMagic) {
+		return decodeLegacyPayload(data)
+	}
+	ps := strings.Split(data[len(payloadMagic) : ], " ")
+	if ps[0] == "" {
+		return nil, ErrBadPayload
+	}
+	p := &Payload{Op: ps[0], Args: make([]string, 0, len(ps) - 1)}
+	for _, s := range ps[1 : ] {
+		arg, err := base64.RawStdEncoding.DecodeString(s)
+		if err != nil {
+			return nil, ErrBadPayload
+		}
+		p.Args = append(p.Args, string(arg))
+	}
+	return p, nil
+}
+
+// "<op> <arg> <rest of line>"
+func decodeLegacyPayload(data string) (*Payload, error) {
+	ps := strings.SplitN(data, " ", 3)
+	if ps[0] == "" {
+		return nil, ErrBadPayload
+	}
+	return &Payload{Op: ps[0], Args: ps[1 : ]}, nil
+}
@@ -0,0 +1,34 @@
+package raft
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPayload(t *testing.T){
+	for _, p := range []*Payload{
+		NewPayload("set", "a key", "line1\nline2"),
+		NewPayload("del", ""),
+		NewPayload("noop"),
+	} {
+		data := p.Encode()
+		for _, c := range data {
+			if c == '\n' || c == '\r' {
+				t.Fatalf("not on one line: %q", data)
+			}
+		}
+		got, err := DecodePayload(data)
+		if err != nil || got.Op != p.Op || len(got.Args) != len(p.Args) || (len(p.Args) > 0 && !reflect.DeepEqual(got.Args, p.Args)) {
+			t.Fatalf("%q decoded to %+v, %v", data, got, err)
+		}
+	}
+
+	// written by old versions
+	p, err := DecodePayload("set k hello world")
+	if err != nil || p.Op != "set" || p.Arg(0) != "k" || p.Arg(1) != "hello world" || p.Arg(2) != "" {
+		t.Fatalf("legacy %+v, %v", p, err)
+	}
+	if _, err := DecodePayload("P1 set !!"); err != ErrBadPayload {
+		t.Fatal("bad base64 accepted")
+	}
+}
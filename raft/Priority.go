@@ -0,0 +1,142 @@
+package raft
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// Election priority of members, replicated by SetPriority entries, a member
+// is 0 when added, and kept in State. AddMember is not changed, so that
+// versions before priority still apply it, they ignore SetPriority. A member waits one more election timeout for
+// each distinct priority above its own among members before it campaigns,
+// and denies PreVote to a candidate of lower priority while its own log is
+// as up to date, so that a low priority member(e.g. in a remote DC) becomes
+// leader only when no preferred member can. Leadership is not moved back
+// when a preferred member recovers. All 0 by default, as without priority.
+
+// Priority of this node before it is in a group, reported by Info() so that
+// it is passed to SetPriority() after AddMember(). The replicated one is
+// used after. Ignored by a witness.
+func WithPriority(priority int) Option {
+	return func(opts *options) {
+		opts.priority = priority
+	}
+}
+
+// with node's lock held
+func (node *Node)priorityOf(id string) int {
+	if id == node.Id {
+		return node.Priority
+	}
+	if m := node.Members[id]; m != nil {
+		return m.Priority
+	}
+	return 0
+}
+
+// false if nodeId is not a member or priority is not changed
+func (node *Node)setPriority(nodeId string, priority int) bool {
+	if nodeId == node.Id {
+		if node.Priority == priority {
+			return false
+		}
+		node.Priority = priority
+		return true
+	}
+	m := node.Members[nodeId]
+	if m == nil || m.Priority == priority {
+		return false
+	}
+	m.Priority = priority
+	return true
+}
+
+// non-zero priorities for State, nil if none
+func (node *Node)priorities() map[string]int {
+	var ret map[string]int
+	for _, id := range append(node.memberIds(), node.Id) {
+		if p := node.priorityOf(id); p != 0 {
+			if ret == nil {
+				ret = make(map[string]int)
+			}
+			ret[id] = p
+		}
+	}
+	return ret
+}
+
+func (node *Node)memberIds() []string {
+	ret := make([]string, 0, len(node.Members))
+	for id := range node.Members {
+		ret = append(ret, id)
+	}
+	return ret
+}
+
+// after members are added from s
+func (node *Node)loadPriorities(s *State) {
+	node.Priority = 0
+	for _, m := range node.Members {
+		m.Priority = 0
+	}
+	for id, p := range s.Priorities {
+		node.setPriority(id, p)
+	}
+}
+
+// ms added to the election timeout, one timeout for each distinct priority
+// above this node's
+func (node *Node)electionDelay() int {
+	above := make(map[int]bool)
+	for _, m := range node.Members {
+		if m.Priority > node.Priority {
+			above[m.Priority] = true
+		}
+	}
+	return len(above) * node.timeouts.Election
+}
+
+// with node's lock held, true if PreVote msg is denied: this node is preferred
+// and could win the election itself. A witness never campaigns, it is the
+// lowest whatever its priority is
+func (node *Node)outranks(msg *Message) bool {
+	if node.witness || node.priorityOf(msg.Src) >= node.Priority {
+		return false
+	}
+	if msg.PrevTerm > node.store.LastTerm ||
+		(msg.PrevTerm == node.store.LastTerm && msg.PrevIndex > node.store.LastIndex) {
+		return false
+	}
+	node.log.Info("candidate of lower priority, deny PreVote", "peer", msg.Src,
+		"peerPriority", node.priorityOf(msg.Src), "priority", node.Priority)
+	return true
+}
+
+// Index of the entry, -1 on error, see Errors.go
+func (node *Node)SetPriority(nodeId string, priority int) (int64, error) {
+	node.mux.Lock()
+	defer node.unlock()
+	defer node.checkInvariants("set priority")
+
+	if node.witness && nodeId == node.Id {
+		return -1, ErrWitness
+	}
+	data := fmt.Sprintf("%s %d", nodeId, priority)
+	return node.proposeConfChange(EntryTypeSetPriority, data)
+}
+
+func (node *Node)applySetPriority(ent *Entry) {
+	var id string
+	var priority int
+	if _, err := fmt.Sscanf(ent.Data, "%s %d", &id, &priority); err != nil {
+		node.log.Warn("bad entry", "index", ent.Index, "entry", ent.Encode())
+		return
+	}
+	node.log.Info("apply", "index", ent.Index, "entry", ent.Encode())
+	if node.setPriority(id, priority) {
+		node.store.saveLastApplied()
+		node.store.SaveState()
+		node.recordAudit(AuditSetPriority, fmt.Sprintf("index=%d id=%s priority=%d", ent.Index, id, priority))
+		node.emitEvent(SinkConfigChange, id, ent.Index, "priority " + strconv.Itoa(priority))
+	}
+}
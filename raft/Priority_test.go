@@ -0,0 +1,128 @@
+package raft
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fallowu/big-ssdb/logger"
+)
+
+// the member of the highest priority among those alive is elected
+func TestPriority(t *testing.T){
+	logger.SetDefaultLevel(logger.LevelError)
+	for seed := int64(1); seed <= 10; seed ++ {
+		s := newMemSim(t, seed, "n1", "n2", "n3", "n4")
+		s.net.SetDefault(MemLinkConfig{Delay: time.Millisecond, Jitter: 5 * time.Millisecond})
+		n1 := s.nodes["n1"]
+		n1.AddMember("n1", "addr-n1")
+		n1.StepTick(0)
+		for id, p := range map[string]int{"n1": 3, "n2": 2, "n3": 0, "n4": -1} {
+			if id != "n1" {
+				index, err := n1.AddMember(id, "addr-" + id)
+				if err != nil {
+					t.Fatal(err)
+				}
+				node := s.nodes[id]
+				node.JoinGroup("n1", "addr-n1")
+				s.until(10 * time.Second, "join " + id, func() bool {
+					return node.Metrics().CommitIndex >= index
+				})
+			}
+			if p == 0 {
+				continue
+			}
+			index, err := n1.SetPriority(id, p)
+			if err != nil {
+				t.Fatal(err)
+			}
+			s.until(10 * time.Second, "priority " + id, func() bool {
+				return n1.Metrics().CommitIndex >= index
+			})
+		}
+		// the format of versions before priority
+		for idx := int64(1); idx <= n1.Metrics().LastIndex; idx ++ {
+			if ent := n1.store.GetEntry(idx); ent != nil && ent.Type == EntryTypeAddMember && strings.Count(ent.Data, " ") != 1 {
+				t.Fatal("AddMember", ent.Encode())
+			}
+		}
+		s.until(10 * time.Second, "replicate", func() bool {
+			return s.converged(n1.Metrics().LastIndex)
+		})
+		if s.nodes["n4"].Status().Members["n2"].Priority != 2 || s.nodes["n2"].Status().Priority != 2 {
+			t.Fatal("not replicated", s.nodes["n4"].Info())
+		}
+
+		// n2, then n3 once n2 is gone
+		s.net.Isolate("n1", "n2", "n3", "n4")
+		s.until(30 * time.Second, "elect n2", func() bool {
+			return s.leader("n2", "n3", "n4") != ""
+		})
+		if leader := s.leader("n2", "n3", "n4"); leader != "n2" {
+			t.Fatalf("seed %d: %s elected, %s", seed, leader, s.dump())
+		}
+		s.net.Heal()
+		index, err := s.nodes["n2"].SetPriority("n4", 5)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.until(10 * time.Second, "set priority", func() bool {
+			return s.converged(index)
+		})
+		if s.nodes["n3"].Status().Members["n4"].Priority != 5 || s.nodes["n4"].Status().Priority != 5 {
+			t.Fatal("not set", s.nodes["n3"].Info())
+		}
+		s.net.Isolate("n2", "n1", "n3", "n4")
+		s.until(30 * time.Second, "elect n4", func() bool {
+			return s.leader("n1", "n3", "n4") != ""
+		})
+		if leader := s.leader("n1", "n3", "n4"); leader != "n4" {
+			t.Fatalf("seed %d: %s elected, %s", seed, leader, s.dump())
+		}
+	}
+}
+
+func TestPriorityState(t *testing.T){
+	s := NewState()
+	s.Term = 3
+	s.Members["n1"] = "addr1"
+	s.Members["n2"] = "addr2"
+	t2 := NewState()
+	if !t2.DecodeProto(s.AppendProto(nil)) || t2.Priorities != nil {
+		t.Fatal("no priority", t2)
+	}
+	s.Priorities = map[string]int{"n1": 2, "n2": -1}
+	for _, ok := range []bool{t2.DecodeProto(s.AppendProto(nil)), t2.Decode(s.Encode())} {
+		if !ok || len(t2.Priorities) != 2 || t2.Priorities["n1"] != 2 || t2.Priorities["n2"] != -1 {
+			t.Fatal("priority", t2)
+		}
+	}
+}
+
+// SetPriority of an old leader not committed yet blocks config changes
+func TestPriorityPending(t *testing.T){
+	logger.SetDefaultLevel(logger.LevelError)
+	s := newMemSim(t, 1, "n1", "n2", "n3")
+	s.net.SetDefault(MemLinkConfig{Delay: time.Millisecond})
+	n1 := s.nodes["n1"]
+	n1.AddMember("n1", "addr-n1")
+	n1.StepTick(0)
+	for _, id := range []string{"n2", "n3"} {
+		index, _ := n1.AddMember(id, "addr-" + id)
+		s.nodes[id].JoinGroup("n1", "addr-n1")
+		s.until(10 * time.Second, "join " + id, func() bool {
+			return s.nodes[id].Metrics().CommitIndex >= index
+		})
+	}
+	n1.mux.Lock()
+	index := n1.store.AppendEntry(EntryTypeSetPriority, "n2 1").Index
+	n1.becomeLeader()
+	pending := n1.pendingConfIndex
+	n1.unlock()
+	if pending != index {
+		t.Fatal("pending", pending, index)
+	}
+	if _, err := n1.DelMember("n3"); err != ErrConfigChangeInProgress {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,253 @@
+package raft
+
+import (
+	"context"
+	"errors"
+
+	"github.com/fallowu/big-ssdb/trace"
+)
+
+// Proposals are accepted under proposeMux, and node's lock only if it is
+// free, so that clients are not blocked behind message handling, ticks or
+// fsyncs. proposeMux guards admission state(see Admission.go), proposals
+// accepted but not appended yet, and proposeGate, raft state proposals are
+// accepted against when node's lock is taken, refreshed by unlock() before
+// it is released. An accepted proposal is given the next index of the
+// leader's log, and appended by the holder of node's lock on unlock(). One
+// whose term or index no longer fits when appended(the node stepped down
+// meanwhile) is dropped, its waiters fail with ErrProposalDropped.
+//
+// Lock order: node.mux, then proposeMux, then statusMux.
+
+// Raft state as of the last release of node's lock, with proposeMux held
+type proposeGate struct{
+	// closed or halted
+	stopped error
+	leader bool
+	quorum bool
+	readOnly *ReadOnlyError
+	// of a follower, for NotLeaderError
+	leaderId string
+	leaderAddr string
+	term int32
+	// of the next proposal
+	nextIndex int64
+	commitIndex int64
+	serviceApplied int64
+	hasService bool
+}
+
+// same as checkWritable() then readOnly, as of the last refresh
+func (g *proposeGate)check() error {
+	if g.stopped != nil {
+		return g.stopped
+	}
+	if !g.leader {
+		return &NotLeaderError{Leader: g.leaderId, LeaderAddr: g.leaderAddr}
+	}
+	if !g.quorum {
+		return ErrNoQuorum
+	}
+	if g.readOnly != nil {
+		return g.readOnly
+	}
+	return nil
+}
+
+// A data entry accepted, to be appended under node's lock
+type proposal struct{
+	ent *Entry
+	parent trace.SpanContext
+	// created when accepted, registered when appended, see ProposeFuture()
+	// and ProposeContext()
+	wantFuture bool
+	wantCommit bool
+	future *Future
+	waiter *commitWaiter
+}
+
+// with proposeMux held, p.ent is given term and index if accepted
+func (node *Node)acceptProposal(p *proposal, data string) error {
+	g := &node.gate
+	if err := g.check(); err != nil {
+		node.log.Warn("reject proposal", "err", err)
+		return err
+	}
+	if err := node.admit(len(data)); err != nil {
+		if node.log.DebugEnabled() {
+			node.log.Debug("reject proposal", "err", err)
+		}
+		return err
+	}
+	p.ent = &Entry{Type: EntryTypeData, Term: g.term, Index: g.nextIndex, Data: data}
+	g.nextIndex ++
+	if p.wantFuture {
+		p.future = newFuture(p.ent.Term, p.ent.Index)
+	}
+	if p.wantCommit {
+		p.waiter = &commitWaiter{term: p.ent.Term, c: make(chan error, 1)}
+	}
+	node.proposals = append(node.proposals, p)
+	return nil
+}
+
+// Accepts p, under node's lock against fresh state if it is free, else
+// without it against the gate, waiting for admission with OverflowBlock
+// unless ctx is nil. Appended before returning if node's lock was taken.
+func (node *Node)submitProposal(ctx context.Context, p *proposal, data string) error {
+	for {
+		locked := node.mux.TryLock()
+		node.proposeMux.Lock()
+		if locked {
+			node.flushProposals()
+		}
+		err := node.acceptProposal(p, data)
+		var busy *BusyError
+		wait := err != nil && ctx != nil && node.admission.Overflow == OverflowBlock && errors.As(err, &busy)
+		var c chan struct{}
+		if wait {
+			c = make(chan struct{})
+			node.admitWaiters = append(node.admitWaiters, c)
+		}
+		node.proposeMux.Unlock()
+		if locked {
+			node.unlock()
+		} else if err == nil {
+			node.kickProposals()
+		}
+		if !wait {
+			return err
+		}
+
+		var timer Timer
+		if busy.RetryAfter > 0 {
+			timer = node.clock.AfterFunc(busy.RetryAfter, func() {
+				node.proposeMux.Lock()
+				node.notifyAdmitWaiters()
+				node.proposeMux.Unlock()
+			})
+		}
+		select {
+		case <-c:
+		case <-ctx.Done():
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+	}
+}
+
+// Appends proposals accepted if node's lock is free. Otherwise its holder
+// does on unlock(), the communication goroutine is notified in case the
+// holder has passed it.
+func (node *Node)kickProposals(){
+	if node.mux.TryLock() {
+		node.unlock()
+		return
+	}
+	select {
+	case node.store.C <- 0:
+	default:
+	}
+}
+
+// with node's lock held
+func (node *Node)appendProposals(){
+	node.proposeMux.Lock()
+	defer node.proposeMux.Unlock()
+	node.flushProposals()
+}
+
+// With node's lock and proposeMux held, appends proposals accepted and
+// refreshes the gate
+func (node *Node)flushProposals(){
+	st := node.store
+	appended := false
+	for _, p := range node.proposals {
+		ent := p.ent
+		if node.Role != RoleLeader || node.Term != ent.Term || ent.Index != st.LastIndex + 1 || node.closed || st.fault != nil {
+			node.log.Info("drop proposal", "term", ent.Term, "index", ent.Index, "currentTerm", node.Term, "lastIndex", st.LastIndex)
+			if p.future != nil {
+				p.future.resolve(nil, ErrProposalDropped)
+			}
+			if p.waiter != nil {
+				p.waiter.resolve(ErrProposalDropped)
+			}
+			continue
+		}
+		st.appendEntry(ent)
+		node.traceProposal(ent, p.parent)
+		if f := p.future; f != nil && st.Service == nil {
+			// no result, resolved on commit
+			f.commit = node.addCommitWaiterFuture(ent.Term, ent.Index, f)
+		} else if f != nil {
+			if node.applyWaiters == nil {
+				node.applyWaiters = make(map[entryKey]*Future)
+			}
+			node.applyWaiters[entryKey{ent.Term, ent.Index}] = f
+		}
+		if p.waiter != nil {
+			node.addWaiter(ent.Index, p.waiter)
+		}
+		appended = true
+	}
+	node.proposals = nil
+	if appended {
+		node.checkInvariants("propose")
+	}
+	node.refreshGate()
+}
+
+// with node's lock and proposeMux held
+func (node *Node)refreshGate(){
+	g := &node.gate
+	old := *g
+	g.stopped = nil
+	if node.closed {
+		g.stopped = ErrShuttingDown
+	} else if node.store.fault != nil {
+		g.stopped = old.stopped
+		if g.stopped == nil || g.stopped == ErrShuttingDown {
+			g.stopped = node.haltedError()
+		}
+	}
+	g.leader = node.Role == RoleLeader
+	g.quorum = g.leader && node.quorumReachable()
+	g.leaderId = node.leaderId()
+	g.leaderAddr = ""
+	if m := node.Members[g.leaderId]; m != nil {
+		g.leaderAddr = m.Addr
+	}
+	g.readOnly = nil
+	if r := node.readOnly; r != nil {
+		// a copy, it is updated with node's lock held
+		g.readOnly = old.readOnly
+		if g.readOnly == nil || *g.readOnly != *r {
+			cp := *r
+			g.readOnly = &cp
+		}
+	}
+	g.term = node.Term
+	g.nextIndex = node.store.LastIndex + 1
+	g.commitIndex = node.store.CommitIndex
+	g.serviceApplied = node.serviceApplied
+	g.hasService = node.store.Service != nil
+	// commits, applies and role changes may admit waiting proposals
+	if len(node.admitWaiters) > 0 && *g != old {
+		node.notifyAdmitWaiters()
+	}
+}
+
+// with node's lock held, entries of the leader itself are appended after
+// proposals accepted before them
+func (node *Node)appendOwnEntry(type_ EntryType, data string) *Entry {
+	node.proposeMux.Lock()
+	defer node.proposeMux.Unlock()
+	node.flushProposals()
+	ent := node.store.AppendEntry(type_, data)
+	node.refreshGate()
+	return ent
+}
@@ -0,0 +1,58 @@
+package raft
+
+import (
+	"context"
+	"testing"
+
+	"github.com/fallowu/big-ssdb/logger"
+)
+
+func TestProposeUnlocked(t *testing.T){
+	logger.SetDefaultLevel(logger.LevelError)
+	n1 := NewNode("n1", "addr1", NewMemDb())
+	n1.SetOutbox(func(msg *Message) {})
+	n1.AddMember("n1", "addr1")
+	n1.StepTick(0)
+
+	// status is not republished when nothing changes
+	s := n1.Status()
+	n1.mux.Lock()
+	n1.unlock()
+	if n1.Status() != s {
+		t.Fatal("status republished")
+	}
+
+	// accepted while node's lock is held, appended on its release
+	n1.mux.Lock()
+	last := n1.store.LastIndex
+	term, idx, err := n1.Propose("data")
+	if err != nil || term != n1.Term || idx != last + 1 {
+		t.Fatal("err", err, "term", term, "index", idx)
+	}
+	if _, idx2, _ := n1.Propose("data"); idx2 != idx + 1 || n1.store.LastIndex != last {
+		t.Fatal("index", idx2, "lastIndex", n1.store.LastIndex)
+	}
+	n1.unlock()
+	if s := n1.Status(); s.LastIndex != idx + 1 {
+		t.Fatal("lastIndex", s.LastIndex)
+	}
+	if ent := n1.store.GetEntry(idx); ent == nil || ent.Term != term || ent.Data != "data" {
+		t.Fatal("entry", ent)
+	}
+
+	// dropped if the node steps down before it is appended
+	n1.mux.Lock()
+	f, err := n1.ProposeFuture(context.Background(), "data")
+	if err != nil {
+		t.Fatal("err", err)
+	}
+	n1.Term ++
+	n1.becomeFollower()
+	n1.unlock()
+	if _, err := f.Result(); err != ErrProposalDropped {
+		t.Fatal("err", err)
+	}
+	if _, _, err := n1.Propose("data"); err == nil {
+		t.Fatal("accepted by follower")
+	}
+}
@@ -0,0 +1,72 @@
+package raft
+
+import (
+	"context"
+
+	"github.com/fallowu/big-ssdb/trace"
+)
+
+// An entry proposed by this node as leader
+type entryKey struct{
+	term int32
+	index int64
+}
+
+// Propose and wait until the entry is committed and applied by Service, or
+// ctx is done. The result is what StateMachine.Apply() returns for the
+// entry. Without Service, returns once the entry is committed.
+//
+// ErrLeadershipLost if the node steps down before the entry is applied, the
+// entry may still be committed by the next leader.
+func (node *Node)ProposeAndWait(ctx context.Context, data string) (interface{}, error) {
+	return node.ProposeAndWaitWithTrace(ctx, data, trace.SpanContext{})
+}
+
+func (node *Node)ProposeAndWaitWithTrace(ctx context.Context, data string, parent trace.SpanContext) (interface{}, error) {
+	f, err := node.ProposeFutureWithTrace(ctx, data, parent)
+	if err != nil {
+		return nil, err
+	}
+	result, err := f.Wait(ctx)
+	if err != nil && err == ctx.Err() {
+		node.mux.Lock()
+		node.removeFuture(f)
+		node.mux.Unlock()
+	}
+	return result, err
+}
+
+// with node's lock held, ent is applied by Service
+func (node *Node)notifyApplyWaiter(ent *Entry, result interface{}){
+	if len(node.applyWaiters) == 0 {
+		return
+	}
+	key := entryKey{ent.Term, ent.Index}
+	if f := node.applyWaiters[key]; f != nil {
+		delete(node.applyWaiters, key)
+		f.resolve(result, nil)
+	}
+}
+
+// with node's lock held, the future is given up
+func (node *Node)removeFuture(f *Future){
+	// f of a proposal is registered once appended
+	node.appendProposals()
+	if f.commit != nil {
+		node.removeCommitWaiter(f.Index, f.commit)
+		return
+	}
+	key := entryKey{f.Term, f.Index}
+	if node.applyWaiters[key] == f {
+		delete(node.applyWaiters, key)
+	}
+}
+
+// with node's lock held, on stepping down, or when entries won't be applied
+// one by one
+func (node *Node)failApplyWaiters(err error){
+	for key, f := range node.applyWaiters {
+		f.resolve(nil, err)
+		delete(node.applyWaiters, key)
+	}
+}
@@ -0,0 +1,84 @@
+package raft
+
+import (
+	"io"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/fallowu/big-ssdb/logger"
+)
+
+// returns the number of data entries applied
+type countingService struct{
+	lastApplied int64
+	count int64
+}
+
+func (svc *countingService)LastApplied() int64 { return svc.lastApplied }
+func (svc *countingService)SaveSnapshot(w io.Writer) error { return ErrSnapshotUnsupported }
+func (svc *countingService)RestoreSnapshot(r io.Reader) error { return ErrSnapshotUnsupported }
+
+func (svc *countingService)Apply(ent *Entry) Result {
+	svc.lastApplied = ent.Index
+	if ent.Type != EntryTypeData {
+		return nil
+	}
+	svc.count ++
+	return svc.count
+}
+
+func TestProposeAndWait(t *testing.T){
+	logger.SetDefaultLevel(logger.LevelError)
+	n1 := NewNode("n1", "addr1", NewMemDb())
+	n1.SetOutbox(func(msg *Message) {})
+	n1.AddMember("n1", "addr1")
+	n1.StepTick(0)
+	n1.SetService(&countingService{lastApplied: n1.LastApplied()})
+	n1.StartApplier()
+
+	for i := 1; i <= 3; i ++ {
+		done := make(chan interface{}, 1)
+		go func() {
+			res, err := n1.ProposeAndWait(context.Background(), "data")
+			if err != nil {
+				t.Error("err", err)
+			}
+			done <- res
+		}()
+		for n1.Status().LastIndex == n1.Status().CommitIndex {
+			time.Sleep(time.Millisecond)
+		}
+		n1.StepTick(0)
+		if res := <-done; res != int64(i) {
+			t.Fatal("result", res)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10 * time.Millisecond)
+	defer cancel()
+	if _, err := n1.ProposeAndWait(ctx, "data"); err != context.DeadlineExceeded {
+		t.Fatal("err", err)
+	}
+	n1.mux.Lock()
+	if len(n1.applyWaiters) != 0 {
+		t.Fatal("waiters", len(n1.applyWaiters))
+	}
+	n1.mux.Unlock()
+
+	// waiters are failed on stepping down
+	done := make(chan error, 1)
+	go func() {
+		_, err := n1.ProposeAndWait(context.Background(), "data")
+		done <- err
+	}()
+	for n1.Status().LastIndex == n1.Status().CommitIndex + 1 {
+		time.Sleep(time.Millisecond)
+	}
+	n1.mux.Lock()
+	n1.becomeFollower()
+	n1.unlock()
+	if err := <-done; err != ErrLeadershipLost {
+		t.Fatal("err", err)
+	}
+}
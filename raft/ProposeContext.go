@@ -0,0 +1,135 @@
+package raft
+
+import (
+	"context"
+
+	"github.com/fallowu/big-ssdb/trace"
+)
+
+// Waits for the entry of term at an index to be committed, see WaitCommit()
+type commitWaiter struct{
+	term int32
+	// buffered, written once with node's lock held
+	c chan error
+	// resolved instead of writing c, see ProposeFuture()
+	future *Future
+}
+
+// with node's lock held
+func (w *commitWaiter)resolve(err error){
+	if w.future != nil {
+		w.future.resolve(nil, err)
+	} else {
+		w.c <- err
+	}
+}
+
+// Propose and wait until the entry is committed, or ctx is done. On ctx
+// done the entry may still be committed later, as entries of Propose().
+func (node *Node)ProposeContext(ctx context.Context, data string) (int32, int64, error) {
+	return node.ProposeContextWithTrace(ctx, data, trace.SpanContext{})
+}
+
+func (node *Node)ProposeContextWithTrace(ctx context.Context, data string, parent trace.SpanContext) (int32, int64, error) {
+	if err := ctx.Err(); err != nil {
+		return -1, -1, err
+	}
+	p := &proposal{parent: parent, wantCommit: true}
+	if err := node.submitProposal(ctx, p, data); err != nil {
+		return -1, -1, err
+	}
+	return p.ent.Term, p.ent.Index, node.waitCommit(ctx, p.ent.Index, p.waiter)
+}
+
+// Wait until the entry of term at index, returned by Propose(), is
+// committed. ErrProposalDropped if another entry is committed instead.
+func (node *Node)WaitCommit(ctx context.Context, term int32, index int64) error {
+	node.mux.Lock()
+	w := node.addCommitWaiter(term, index)
+	node.mux.Unlock()
+	return node.waitCommit(ctx, index, w)
+}
+
+func (node *Node)waitCommit(ctx context.Context, index int64, w *commitWaiter) error {
+	select {
+	case err := <-w.c:
+		return err
+	case <-ctx.Done():
+		node.mux.Lock()
+		// w of a proposal is added once appended
+		node.appendProposals()
+		node.removeCommitWaiter(index, w)
+		node.mux.Unlock()
+		// committed just before ctx is done
+		select {
+		case err := <-w.c:
+			return err
+		default:
+		}
+		return ctx.Err()
+	}
+}
+
+// with node's lock held
+func (node *Node)addCommitWaiter(term int32, index int64) *commitWaiter {
+	return node.addCommitWaiterFuture(term, index, nil)
+}
+
+func (node *Node)addCommitWaiterFuture(term int32, index int64, f *Future) *commitWaiter {
+	w := &commitWaiter{term: term, c: make(chan error, 1), future: f}
+	node.addWaiter(index, w)
+	return w
+}
+
+// with node's lock held
+func (node *Node)addWaiter(index int64, w *commitWaiter){
+	if index <= node.store.CommitIndex {
+		w.resolve(node.commitResult(w.term, index))
+		return
+	}
+	if node.commitWaiters == nil {
+		node.commitWaiters = make(map[int64][]*commitWaiter)
+	}
+	node.commitWaiters[index] = append(node.commitWaiters[index], w)
+}
+
+func (node *Node)removeCommitWaiter(index int64, w *commitWaiter){
+	ws := node.commitWaiters[index]
+	for i, x := range ws {
+		if x == w {
+			ws = append(ws[:i], ws[i+1:]...)
+			break
+		}
+	}
+	if len(ws) == 0 {
+		delete(node.commitWaiters, index)
+	} else {
+		node.commitWaiters[index] = ws
+	}
+}
+
+// called when commit index advances, with node's lock held
+func (node *Node)notifyCommitWaiters(){
+	if len(node.commitWaiters) == 0 {
+		return
+	}
+	for index, ws := range node.commitWaiters {
+		if index > node.store.CommitIndex {
+			continue
+		}
+		for _, w := range ws {
+			w.resolve(node.commitResult(w.term, index))
+		}
+		delete(node.commitWaiters, index)
+	}
+}
+
+// an entry not in the log any more(replaced by a snapshot) can't be told,
+// reported as dropped
+func (node *Node)commitResult(term int32, index int64) error {
+	ent := node.store.GetEntry(index)
+	if ent == nil || ent.Term != term {
+		return ErrProposalDropped
+	}
+	return nil
+}
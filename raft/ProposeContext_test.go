@@ -0,0 +1,54 @@
+package raft
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/fallowu/big-ssdb/logger"
+)
+
+func TestProposeContext(t *testing.T){
+	logger.SetDefaultLevel(logger.LevelError)
+	n1 := NewNode("n1", "addr1", NewMemDb())
+	n1.SetOutbox(func(msg *Message) {})
+	n1.AddMember("n1", "addr1")
+	n1.StepTick(0)
+
+	if _, _, err := NewNode("n2", "addr2", NewMemDb()).ProposeContext(context.Background(), "data"); !errors.Is(err, ErrNotLeader) {
+		t.Fatal("err", err)
+	}
+
+	// committed by the tick
+	done := make(chan error, 1)
+	go func() {
+		_, _, err := n1.ProposeContext(context.Background(), "data")
+		done <- err
+	}()
+	for n1.Status().LastIndex == n1.Status().CommitIndex {
+		time.Sleep(time.Millisecond)
+	}
+	n1.StepTick(0)
+	if err := <-done; err != nil {
+		t.Fatal("err", err)
+	}
+
+	// not committed without ticks
+	ctx, cancel := context.WithTimeout(context.Background(), 10 * time.Millisecond)
+	defer cancel()
+	term, idx, err := n1.ProposeContext(ctx, "data")
+	if err != context.DeadlineExceeded || idx != n1.Status().LastIndex {
+		t.Fatal("err", err, "index", idx)
+	}
+	if len(n1.commitWaiters) != 0 {
+		t.Fatal("waiters", len(n1.commitWaiters))
+	}
+	n1.StepTick(0)
+	if err := n1.WaitCommit(context.Background(), term, idx); err != nil {
+		t.Fatal("err", err)
+	}
+	if err := n1.WaitCommit(context.Background(), term + 1, idx); err != ErrProposalDropped {
+		t.Fatal("err", err)
+	}
+}
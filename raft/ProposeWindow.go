@@ -0,0 +1,18 @@
+package raft
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Proposals within window after the first one are replicated, and fsynced,
+// in one round, instead of a round each. Trades up to window of latency for
+// throughput under concurrent proposals, 0(default) replicates at once.
+// Does not apply to StepTick/StepMessage, which replicate at the end.
+func (node *Node)SetProposeWindow(window time.Duration){
+	atomic.StoreInt64(&node.proposeWindowNs, int64(window))
+}
+
+func (node *Node)proposeWindow() time.Duration {
+	return time.Duration(atomic.LoadInt64(&node.proposeWindowNs))
+}
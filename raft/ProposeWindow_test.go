@@ -0,0 +1,33 @@
+package raft
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fallowu/big-ssdb/logger"
+)
+
+func TestProposeWindow(t *testing.T){
+	logger.SetDefaultLevel(logger.LevelError)
+	node := NewNode("n1", "addr1", NewMemDb())
+	node.SetOutbox(func(msg *Message) {})
+	node.AddMember("n1", "addr1")
+	node.StepTick(0)
+	node.SetProposeWindow(200 * time.Millisecond)
+	node.StartCommunication()
+
+	before := node.Metrics()
+	for i := 0; i < 5; i ++ {
+		node.Propose("data")
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for node.Metrics().CommitIndex != before.CommitIndex + 5 {
+		if time.Now().After(deadline) {
+			t.Fatal("not committed", node.Metrics().CommitIndex)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := node.Metrics().Fsync.Count - before.Fsync.Count; n != 1 {
+		t.Fatal("fsyncs", n)
+	}
+}
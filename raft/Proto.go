@@ -0,0 +1,435 @@
+package raft
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"sort"
+)
+
+// Protobuf encoding of the types in raft.proto, written by hand to stay
+// free of dependencies. Zero values are omitted as proto3 does, unknown
+// fields are skipped when decoding. Message and Entry end with the CRC-32C of
+// the fields before it, verified if present.
+
+const (
+	protoVarint = 0
+	protoFixed64 = 1
+	protoBytes = 2
+	protoFixed32 = 5
+
+	protoChecksumField = 15
+)
+
+func appendProtoTag(buf []byte, field int, wire int) []byte {
+	return binary.AppendUvarint(buf, uint64(field << 3 | wire))
+}
+
+// int32 and int64, negative ones take 10 bytes as protobuf does
+func appendProtoInt(buf []byte, field int, v int64) []byte {
+	if v == 0 {
+		return buf
+	}
+	buf = appendProtoTag(buf, field, protoVarint)
+	return binary.AppendUvarint(buf, uint64(v))
+}
+
+func appendProtoBool(buf []byte, field int, v bool) []byte {
+	if !v {
+		return buf
+	}
+	return appendProtoInt(buf, field, 1)
+}
+
+func appendProtoString(buf []byte, field int, s string) []byte {
+	if s == "" {
+		return buf
+	}
+	buf = appendProtoTag(buf, field, protoBytes)
+	buf = binary.AppendUvarint(buf, uint64(len(s)))
+	return append(buf, s...)
+}
+
+// embedded message, appended by fn
+func appendProtoMessage(buf []byte, field int, fn func([]byte) []byte) []byte {
+	buf = appendProtoTag(buf, field, protoBytes)
+	// one byte of size reserved, moved when the message is larger
+	n := len(buf)
+	buf = append(buf, 0)
+	buf = fn(buf)
+	size := len(buf) - n - 1
+	if size < 0x80 {
+		buf[n] = byte(size)
+		return buf
+	}
+	var head [binary.MaxVarintLen64]byte
+	l := binary.PutUvarint(head[:], uint64(size))
+	buf = append(buf, head[1:l]...)
+	copy(buf[n+l:], buf[n+1:n+1+size])
+	copy(buf[n:], head[:l])
+	return buf
+}
+
+// the checksum of buf[start:]
+func appendProtoChecksum(buf []byte, start int) []byte {
+	crc := crc32.Update(0, crcTable, buf[start:])
+	buf = appendProtoTag(buf, protoChecksumField, protoFixed32)
+	return binary.LittleEndian.AppendUint32(buf, crc)
+}
+
+type protoReader struct{
+	buf []byte
+	bad bool
+}
+
+// field number and wire type of the next field, false at the end or on error
+func (r *protoReader)next() (int, int, bool) {
+	if r.bad || len(r.buf) == 0 {
+		return 0, 0, false
+	}
+	tag := r.uvarint()
+	if r.bad || tag >> 3 == 0 {
+		r.bad = true
+		return 0, 0, false
+	}
+	return int(tag >> 3), int(tag & 7), true
+}
+
+func (r *protoReader)uvarint() uint64 {
+	v, n := binary.Uvarint(r.buf)
+	if n <= 0 {
+		r.bad = true
+		return 0
+	}
+	r.buf = r.buf[n:]
+	return v
+}
+
+func (r *protoReader)int32(wire int) int32 {
+	v := r.int64(wire)
+	if int64(int32(v)) != v {
+		r.bad = true
+	}
+	return int32(v)
+}
+
+func (r *protoReader)int64(wire int) int64 {
+	if wire != protoVarint {
+		r.bad = true
+		return 0
+	}
+	return int64(r.uvarint())
+}
+
+func (r *protoReader)bytes(wire int) []byte {
+	if wire != protoBytes {
+		r.bad = true
+		return nil
+	}
+	n := r.uvarint()
+	if r.bad || n > uint64(len(r.buf)) {
+		r.bad = true
+		return nil
+	}
+	b := r.buf[:n]
+	r.buf = r.buf[n:]
+	return b
+}
+
+// verifies the checksum field just read, fields before it are buf[:end]
+func (r *protoReader)checksum(wire int, buf []byte, end int) error {
+	if wire != protoFixed32 || len(r.buf) < 4 {
+		r.bad = true
+		return errBadFormat
+	}
+	want := binary.LittleEndian.Uint32(r.buf)
+	r.buf = r.buf[4:]
+	if crc32.Update(0, crcTable, buf[:end]) != want {
+		return ErrCorrupted
+	}
+	return nil
+}
+
+func (r *protoReader)skip(wire int) {
+	switch wire {
+	case protoVarint:
+		r.uvarint()
+	case protoBytes:
+		r.bytes(wire)
+	case protoFixed64, protoFixed32:
+		n := 8
+		if wire == protoFixed32 {
+			n = 4
+		}
+		if len(r.buf) < n {
+			r.bad = true
+			return
+		}
+		r.buf = r.buf[n:]
+	default:
+		r.bad = true
+	}
+}
+
+func (m *Message)AppendProto(buf []byte) []byte {
+	start := len(buf)
+	buf = appendProtoString(buf, 1, string(m.Type))
+	buf = appendProtoString(buf, 2, m.Src)
+	buf = appendProtoString(buf, 3, m.Dst)
+	buf = appendProtoInt(buf, 4, int64(m.Term))
+	buf = appendProtoInt(buf, 5, int64(m.PrevTerm))
+	buf = appendProtoInt(buf, 6, m.PrevIndex)
+	buf = appendProtoString(buf, 7, m.Data)
+	buf = appendProtoString(buf, 8, m.TraceId)
+	return appendProtoChecksum(buf, start)
+}
+
+// validated as Decode() does
+func (m *Message)DecodeProto(buf []byte) bool {
+	return m.decodeProto(buf) == nil
+}
+
+func (m *Message)decodeProto(buf []byte) error {
+	*m = Message{}
+	r := protoReader{buf: buf}
+	for {
+		end := len(buf) - len(r.buf)
+		field, wire, ok := r.next()
+		if !ok {
+			break
+		}
+		switch field {
+		case protoChecksumField:
+			if err := r.checksum(wire, buf, end); err != nil {
+				return err
+			}
+		case 1:
+			m.Type = MessageType(r.bytes(wire))
+		case 2:
+			m.Src = string(r.bytes(wire))
+		case 3:
+			m.Dst = string(r.bytes(wire))
+		case 4:
+			m.Term = r.int32(wire)
+		case 5:
+			m.PrevTerm = r.int32(wire)
+		case 6:
+			m.PrevIndex = r.int64(wire)
+		case 7:
+			m.Data = string(r.bytes(wire))
+		case 8:
+			m.TraceId = string(r.bytes(wire))
+		default:
+			r.skip(wire)
+		}
+	}
+	if r.bad || m.Type == "" || m.Term < 0 || m.PrevTerm < 0 || m.PrevIndex < 0 || m.PrevIndex > MaxIndex {
+		return errBadFormat
+	}
+	return nil
+}
+
+func (e *Entry)AppendProto(buf []byte) []byte {
+	start := len(buf)
+	buf = appendProtoInt(buf, 1, int64(e.Term))
+	buf = appendProtoInt(buf, 2, e.Index)
+	buf = appendProtoInt(buf, 3, e.Commit)
+	buf = appendProtoString(buf, 4, string(e.Type))
+	buf = appendProtoString(buf, 5, e.Data)
+	return appendProtoChecksum(buf, start)
+}
+
+func (e *Entry)DecodeProto(buf []byte) bool {
+	return e.decodeProto(buf) == nil
+}
+
+func (e *Entry)decodeProto(buf []byte) error {
+	*e = Entry{}
+	r := protoReader{buf: buf}
+	for {
+		end := len(buf) - len(r.buf)
+		field, wire, ok := r.next()
+		if !ok {
+			break
+		}
+		switch field {
+		case protoChecksumField:
+			if err := r.checksum(wire, buf, end); err != nil {
+				return err
+			}
+		case 1:
+			e.Term = r.int32(wire)
+		case 2:
+			e.Index = r.int64(wire)
+		case 3:
+			e.Commit = r.int64(wire)
+		case 4:
+			e.Type = EntryType(r.bytes(wire))
+		case 5:
+			e.Data = string(r.bytes(wire))
+		default:
+			r.skip(wire)
+		}
+	}
+	if r.bad || e.Type == "" || e.Term < 0 ||
+		e.Index < 0 || e.Index > MaxIndex || e.Commit < 0 || e.Commit > MaxIndex {
+		return errBadFormat
+	}
+	return nil
+}
+
+// members sorted by id, the same state encodes to the same bytes
+func (s *State)AppendProto(buf []byte) []byte {
+	buf = appendProtoInt(buf, 1, int64(s.Term))
+	buf = appendProtoString(buf, 2, s.VoteFor)
+	ids := make([]string, 0, len(s.Members))
+	for id := range s.Members {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		buf = appendProtoMessage(buf, 3, func(b []byte) []byte {
+			b = appendProtoString(b, 1, id)
+			return appendProtoString(b, 2, s.Members[id])
+		})
+	}
+	ids = ids[:0]
+	for id := range s.Priorities {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		buf = appendProtoMessage(buf, 4, func(b []byte) []byte {
+			b = appendProtoString(b, 1, id)
+			return appendProtoInt(b, 2, int64(s.Priorities[id]))
+		})
+	}
+	return buf
+}
+
+func (s *State)DecodeProto(buf []byte) bool {
+	t := NewState()
+	r := protoReader{buf: buf}
+	for {
+		field, wire, ok := r.next()
+		if !ok {
+			break
+		}
+		switch field {
+		case 1:
+			t.Term = r.int32(wire)
+		case 2:
+			t.VoteFor = string(r.bytes(wire))
+		case 3:
+			mr := protoReader{buf: r.bytes(wire)}
+			var id, addr string
+			for {
+				f, w, ok := mr.next()
+				if !ok {
+					break
+				}
+				switch f {
+				case 1:
+					id = string(mr.bytes(w))
+				case 2:
+					addr = string(mr.bytes(w))
+				default:
+					mr.skip(w)
+				}
+			}
+			r.bad = r.bad || mr.bad
+			t.Members[id] = addr
+		case 4:
+			mr := protoReader{buf: r.bytes(wire)}
+			var id string
+			var priority int32
+			for {
+				f, w, ok := mr.next()
+				if !ok {
+					break
+				}
+				switch f {
+				case 1:
+					id = string(mr.bytes(w))
+				case 2:
+					priority = mr.int32(w)
+				default:
+					mr.skip(w)
+				}
+			}
+			r.bad = r.bad || mr.bad
+			if t.Priorities == nil {
+				t.Priorities = make(map[string]int)
+			}
+			t.Priorities[id] = int(priority)
+		default:
+			r.skip(wire)
+		}
+	}
+	if r.bad || t.Term < 0 {
+		return false
+	}
+	*s = *t
+	return true
+}
+
+func (sn *Snapshot)AppendProto(buf []byte) []byte {
+	buf = appendProtoMessage(buf, 1, sn.state.AppendProto)
+	for _, ent := range sn.entries {
+		buf = appendProtoMessage(buf, 2, ent.AppendProto)
+	}
+	buf = appendProtoString(buf, 3, sn.service)
+	buf = appendProtoBool(buf, 4, sn.hasService)
+	return buf
+}
+
+// entries are validated as Decode() does
+func (sn *Snapshot)DecodeProto(buf []byte) bool {
+	state := NewState()
+	var entries []*Entry
+	var service string
+	var hasService bool
+	r := protoReader{buf: buf}
+	for {
+		field, wire, ok := r.next()
+		if !ok {
+			break
+		}
+		switch field {
+		case 1:
+			if !state.DecodeProto(r.bytes(wire)) {
+				return false
+			}
+		case 2:
+			ent := new(Entry)
+			if !ent.DecodeProto(r.bytes(wire)) {
+				return false
+			}
+			if len(entries) > 0 {
+				last := entries[len(entries) - 1]
+				if ent.Index != last.Index + 1 || ent.Term < last.Term {
+					return false
+				}
+			} else if ent.Index == 0 {
+				return false
+			}
+			entries = append(entries, ent)
+		case 3:
+			service = string(r.bytes(wire))
+		case 4:
+			hasService = r.int64(wire) != 0
+		default:
+			r.skip(wire)
+		}
+	}
+	if r.bad {
+		return false
+	}
+	sn.state = state
+	sn.entries = entries
+	if sn.entries == nil {
+		sn.entries = make([]*Entry, 0)
+	}
+	sn.service = service
+	sn.hasService = hasService
+	return true
+}
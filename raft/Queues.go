@@ -0,0 +1,106 @@
+package raft
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+const(
+	DefaultRecvQueueSize = 64
+	DefaultSendQueueSize = 64
+	// store.C, notifications of new entries, one pending is enough
+	DefaultNotifyQueueSize = 1
+)
+
+// What to do when recv_c or send_c is full
+type OverflowPolicy int
+
+const(
+	// drop the message and count it, raft retransmits on its own
+	OverflowDrop OverflowPolicy = iota
+	// wait, as old versions did. send_c is written with node's lock held,
+	// blocks raft until SendC() is drained
+	OverflowBlock
+)
+
+func ParseOverflowPolicy(s string) (OverflowPolicy, error) {
+	switch s {
+	case "", "drop":
+		return OverflowDrop, nil
+	case "block":
+		return OverflowBlock, nil
+	}
+	return OverflowDrop, fmt.Errorf("bad overflow policy: %s", s)
+}
+
+type QueueConfig struct{
+	RecvSize int
+	SendSize int
+	NotifySize int
+	Overflow OverflowPolicy
+}
+
+func DefaultQueueConfig() QueueConfig {
+	return QueueConfig{
+		RecvSize: DefaultRecvQueueSize,
+		SendSize: DefaultSendQueueSize,
+		NotifySize: DefaultNotifyQueueSize,
+		Overflow: OverflowDrop,
+	}
+}
+
+// Recreates the channels, must be called before Start(), RecvC() and SendC()
+func (node *Node)SetQueueConfig(conf QueueConfig){
+	node.mux.Lock()
+	defer node.mux.Unlock()
+	def := DefaultQueueConfig()
+	if conf.RecvSize <= 0 {
+		conf.RecvSize = def.RecvSize
+	}
+	if conf.SendSize <= 0 {
+		conf.SendSize = def.SendSize
+	}
+	if conf.NotifySize <= 0 {
+		conf.NotifySize = def.NotifySize
+	}
+	node.queues = conf
+	node.recv_c = make(chan *Message, conf.RecvSize)
+	node.send_c = make(chan *Message, conf.SendSize)
+	node.store.C = make(chan int, conf.NotifySize)
+}
+
+// Pass a message received by transport to raft, without waiting unless
+// Overflow is OverflowBlock. Returns false if msg is dropped.
+// Safe to be called without lock.
+func (node *Node)Deliver(msg *Message) bool {
+	if node.queues.Overflow == OverflowBlock {
+		node.recv_c <- msg
+		return true
+	}
+	select {
+	case node.recv_c <- msg:
+		return true
+	default:
+		n := atomic.AddInt64(&node.recvDropped, 1)
+		if node.log.DebugEnabled() {
+			node.log.Debug("recv queue full, drop message", "peer", msg.Src, "type", msg.Type, "dropped", n)
+		}
+		return false
+	}
+}
+
+// with node's lock held
+func (node *Node)enqueueSend(msg *Message){
+	if node.queues.Overflow == OverflowBlock {
+		node.send_c <- msg
+		return
+	}
+	select {
+	case node.send_c <- msg:
+	default:
+		node.sendDropped ++
+		if node.log.DebugEnabled() {
+			node.log.Debug("send queue full, drop message", "peer", msg.Dst, "type", msg.Type, "dropped", node.sendDropped)
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package raft
+
+import (
+	"testing"
+
+	"github.com/fallowu/big-ssdb/logger"
+)
+
+func TestQueueOverflow(t *testing.T){
+	logger.SetDefaultLevel(logger.LevelError)
+	node := NewNode("n1", "addr1", NewMemDb())
+	node.SetQueueConfig(QueueConfig{RecvSize: 2, SendSize: 1})
+	for i := 0; i < 3; i ++ {
+		node.Deliver(NewNoneMsg("n1"))
+	}
+	node.mux.Lock()
+	node.send(NewNoneMsg("n2"))
+	node.send(NewNoneMsg("n2"))
+	node.mux.Unlock()
+
+	m := node.Metrics()
+	if m.RecvQueue != 2 || m.RecvQueueSize != 2 || m.RecvDropped != 1 {
+		t.Fatal("recv", m.RecvQueue, m.RecvQueueSize, m.RecvDropped)
+	}
+	if m.SendQueue != 1 || m.SendQueueSize != 1 || m.SendDropped != 1 {
+		t.Fatal("send", m.SendQueue, m.SendQueueSize, m.SendDropped)
+	}
+	if _, err := ParseOverflowPolicy("wait"); err == nil {
+		t.Fatal("bad policy accepted")
+	}
+}
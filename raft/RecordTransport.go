@@ -0,0 +1,218 @@
+package raft
+
+import (
+	"os"
+	"fmt"
+	"sync"
+	"time"
+	"bufio"
+	"strconv"
+	"strings"
+)
+
+type RecordDir string
+
+const(
+	RecordSend RecordDir = "send"
+	RecordRecv RecordDir = "recv"
+)
+
+// A message sent or received by the recorded node
+type MessageRecord struct{
+	Time time.Time
+	Dir RecordDir
+	Msg *Message
+}
+
+// line: <unix nanos> <dir> <quoted message>
+func (r *MessageRecord)Encode() string {
+	return fmt.Sprintf("%d %s %s", r.Time.UnixNano(), r.Dir, strconv.Quote(r.Msg.Encode()))
+}
+
+func DecodeMessageRecord(line string) (*MessageRecord, error) {
+	ps := strings.SplitN(line, " ", 3)
+	if len(ps) != 3 {
+		return nil, fmt.Errorf("bad record: %q", line)
+	}
+	ns, err := strconv.ParseInt(ps[0], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("bad record time: %q", ps[0])
+	}
+	dir := RecordDir(ps[1])
+	if dir != RecordSend && dir != RecordRecv {
+		return nil, fmt.Errorf("bad record direction: %q", ps[1])
+	}
+	s, err := strconv.Unquote(ps[2])
+	if err != nil {
+		return nil, fmt.Errorf("bad record message: %s", err)
+	}
+	msg := DecodeMessage(s)
+	if msg == nil {
+		return nil, fmt.Errorf("bad message: %q", s)
+	}
+	return &MessageRecord{time.Unix(0, ns), dir, msg}, nil
+}
+
+// Transport wrapper appending every message sent and received to a file,
+// for ReplayRecords(). Records are buffered, they are flushed every
+// RecordFlushInterval and on Close(). Thread safe.
+type RecordTransport struct{
+	Transport
+	c chan *Message
+	fp *os.File
+	w *bufio.Writer
+	clock Clock
+	ticker Ticker
+	done chan struct{}
+	mux sync.Mutex
+}
+
+const RecordFlushInterval = time.Second
+
+func NewRecordTransport(inner Transport, path string) (*RecordTransport, error) {
+	fp, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	tp := new(RecordTransport)
+	tp.Transport = inner
+	tp.c = make(chan *Message)
+	tp.fp = fp
+	tp.w = bufio.NewWriter(fp)
+	tp.clock = SystemClock
+	tp.ticker = tp.clock.NewTicker(RecordFlushInterval)
+	tp.done = make(chan struct{})
+
+	go func() {
+		for msg := range inner.C() {
+			tp.record(RecordRecv, msg)
+			tp.c <- msg
+		}
+	}()
+	go func() {
+		for {
+			select {
+			case <-tp.ticker.C():
+				tp.Flush()
+			case <-tp.done:
+				return
+			}
+		}
+	}()
+	return tp, nil
+}
+
+func (tp *RecordTransport)C() chan *Message {
+	return tp.c
+}
+
+func (tp *RecordTransport)Send(msg *Message) bool {
+	tp.record(RecordSend, msg)
+	return tp.Transport.Send(msg)
+}
+
+func (tp *RecordTransport)record(dir RecordDir, msg *Message) {
+	r := &MessageRecord{tp.clock.Now(), dir, msg}
+	tp.mux.Lock()
+	defer tp.mux.Unlock()
+	if tp.fp == nil {
+		return
+	}
+	tp.w.WriteString(r.Encode())
+	tp.w.WriteByte('\n')
+}
+
+func (tp *RecordTransport)Flush() error {
+	tp.mux.Lock()
+	defer tp.mux.Unlock()
+	if tp.fp == nil {
+		return nil
+	}
+	return tp.w.Flush()
+}
+
+func (tp *RecordTransport)Close() {
+	tp.mux.Lock()
+	if tp.fp != nil {
+		tp.ticker.Stop()
+		close(tp.done)
+		tp.w.Flush()
+		tp.fp.Close()
+		tp.fp = nil
+	}
+	tp.mux.Unlock()
+	tp.Transport.Close()
+}
+
+// Records in file order, a torn last line is ignored
+func ReadRecords(path string) ([]*MessageRecord, error) {
+	fp, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fp.Close()
+
+	ret := make([]*MessageRecord, 0)
+	scanner := bufio.NewScanner(fp)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	var bad error
+	for scanner.Scan() {
+		if bad != nil {
+			return nil, bad
+		}
+		r, err := DecodeMessageRecord(scanner.Text())
+		if err != nil {
+			bad = err
+			continue
+		}
+		ret = append(ret, r)
+	}
+	return ret, scanner.Err()
+}
+
+/* ############################################# */
+
+// Feed received messages of records into node, ticking it every 100ms from
+// the first record as StartTicker() would, with node's clock if it's a
+// ManualClock. node must not be started, it should be created from a copy
+// of the recorded node's database taken when recording started. Election
+// timers are randomized, SetRandSeed() of both nodes to reproduce them.
+// Returns messages sent by node, compare them with the recorded ones to
+// find where behavior differs.
+func ReplayRecords(node *Node, records []*MessageRecord) []*MessageRecord {
+	const TimerInterval = 100 * time.Millisecond
+
+	clock, _ := node.clock.(*ManualClock)
+	var now time.Time
+	ret := make([]*MessageRecord, 0)
+	node.SetOutbox(func(msg *Message) {
+		cp := *msg
+		ret = append(ret, &MessageRecord{now, RecordSend, &cp})
+	})
+	if len(records) == 0 {
+		return ret
+	}
+
+	now = records[0].Time
+	nextTick := now.Add(TimerInterval)
+	for _, r := range records {
+		if r.Dir != RecordRecv {
+			continue
+		}
+		for !nextTick.After(r.Time) {
+			if clock != nil {
+				clock.Advance(nextTick.Sub(now))
+			}
+			now = nextTick
+			node.StepTick(int(TimerInterval / time.Millisecond))
+			nextTick = nextTick.Add(TimerInterval)
+		}
+		if clock != nil {
+			clock.Advance(r.Time.Sub(now))
+		}
+		now = r.Time
+		cp := *r.Msg
+		node.StepMessage(&cp)
+	}
+	return ret
+}
@@ -0,0 +1,118 @@
+package raft
+
+import (
+	"time"
+	"testing"
+	"path/filepath"
+
+	"github.com/fallowu/big-ssdb/logger"
+)
+
+type chanTransport struct{
+	recordTransport
+	c chan *Message
+}
+
+func (t *chanTransport)C() chan *Message { return t.c }
+
+func TestRecordTransport(t *testing.T){
+	path := filepath.Join(t.TempDir(), "records")
+	inner := &chanTransport{recordTransport{make(chan *Message, 10)}, make(chan *Message)}
+	tp, err := NewRecordTransport(inner, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	snap := NewInstallSnapshotMsg("n2", "line1\nline2")
+	snap.Src = "n1"
+	tp.Send(snap)
+	inner.c <- NewAppendEntryAck("n1", true)
+	if msg := <-tp.C(); msg.Type != MessageTypeAppendEntryAck {
+		t.Fatal("not received", msg)
+	}
+	tp.Close()
+
+	rs, err := ReadRecords(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rs) != 2 || rs[0].Dir != RecordSend || rs[1].Dir != RecordRecv {
+		t.Fatal("bad records", rs)
+	}
+	if rs[0].Msg.Encode() != snap.Encode() {
+		t.Fatal("message changed", rs[0].Msg.Encode())
+	}
+}
+
+// follower n2 replays what it received, and sends the same messages
+func TestReplayRecords(t *testing.T){
+	logger.SetDefaultLevel(logger.LevelError)
+	clock := NewManualClock()
+	var queue []*Message
+	outbox := func(msg *Message) {
+		cp := *msg
+		queue = append(queue, &cp)
+	}
+	n1 := NewNode("n1", "addr1", NewMemDb())
+	n1.SetClock(clock)
+	n1.SetOutbox(outbox)
+	n1.AddMember("n1", "addr1")
+	n1.StepTick(0)
+	n1.AddMember("n2", "addr2")
+
+	newFollower := func() *Node {
+		n2 := NewNode("n2", "addr2", NewMemDb())
+		n2.SetClock(clock)
+		n2.SetRandSeed(1)
+		n2.JoinGroup("n1", "addr1")
+		return n2
+	}
+	n2 := newFollower()
+	n2.SetOutbox(outbox)
+
+	var records []*MessageRecord
+	for i := 0; i < 200; i ++ {
+		if i % 20 == 0 {
+			n1.Propose("data")
+		}
+		// as ReplayRecords() does, n2 ticks every 100ms after the first record
+		clock.Advance(100 * time.Millisecond)
+		n1.StepTick(100)
+		if len(records) > 0 {
+			n2.StepTick(100)
+		}
+		for len(queue) > 0 {
+			msg := queue[0]
+			queue = queue[1:]
+			if msg.Dst == "n2" {
+				records = append(records, &MessageRecord{clock.Now(), RecordRecv, msg})
+				n2.StepMessage(msg)
+			} else {
+				records = append(records, &MessageRecord{clock.Now(), RecordSend, msg})
+				n1.StepMessage(msg)
+			}
+		}
+	}
+	if n2.store.CommitIndex < 5 {
+		t.Fatal("not replicated", n2.store.CommitIndex)
+	}
+
+	replayed := newFollower()
+	sent := ReplayRecords(replayed, records)
+	var want []string
+	for _, r := range records {
+		if r.Dir == RecordSend {
+			want = append(want, r.Msg.Encode())
+		}
+	}
+	if len(sent) != len(want) {
+		t.Fatal("sent", len(sent), "recorded", len(want))
+	}
+	for i, r := range sent {
+		if r.Msg.Encode() != want[i] {
+			t.Fatalf("#%d replayed %s, recorded %s", i, r.Msg.Encode(), want[i])
+		}
+	}
+	if replayed.store.CommitIndex != n2.store.CommitIndex || replayed.store.LastIndex != n2.store.LastIndex {
+		t.Fatal("state differs")
+	}
+}
@@ -0,0 +1,80 @@
+package raft
+
+import (
+	"fmt"
+	"math"
+)
+
+// What loadEntries() found on startup, logged as "recovery report". Entries
+// after the saved commitIndex may be torn by a crash, they are read and
+// checked for gaps, duplicate indexes and bad checksums, and the log is
+// truncated at the first bad one. Committed entries are not read, a bad one
+// halts the node when it is loaded, see Fault.go.
+type RecoveryReport struct{
+	// "log db", "log meta", or "scan" of data written by old versions
+	Source string
+	// 0 if the log is empty
+	FirstIndex int64
+	LastIndex int64
+	// saved one, -1 if absent
+	CommitIndex int64
+	// entries read and checked
+	Checked int64
+	// first bad entry, the log is truncated at it, 0 if none
+	TornIndex int64
+	// why TornIndex is bad, e.g. "missing", "bad checksum"
+	Reason string
+	// entries deleted from TornIndex on
+	Discarded int64
+}
+
+func (r RecoveryReport)Ok() bool {
+	return r.TornIndex == 0
+}
+
+func (r RecoveryReport)String() string {
+	ret := fmt.Sprintf("source: %s, first: %d, last: %d, commit: %d, checked: %d",
+		r.Source, r.FirstIndex, r.LastIndex, r.CommitIndex, r.Checked)
+	if !r.Ok() {
+		ret += fmt.Sprintf(", torn: %d (%s), discarded: %d", r.TornIndex, r.Reason, r.Discarded)
+	}
+	return ret
+}
+
+// Result of the last startup
+func (st *Storage)Recovery() RecoveryReport {
+	return st.recovery
+}
+
+// index is the first bad entry, the smallest one is kept
+func (st *Storage)recordTorn(index int64, reason string) {
+	if st.recovery.TornIndex == 0 || index < st.recovery.TornIndex {
+		st.recovery.TornIndex = index
+		st.recovery.Reason = reason
+	}
+}
+
+// why a record at index is rejected by decode()
+func tornReason(index int64, record string, ent *Entry, err error) string {
+	if record == "" {
+		return "missing"
+	}
+	if err != nil {
+		return err.Error()
+	}
+	return fmt.Sprintf("entry#%d at %d", ent.Index, index)
+}
+
+func (st *Storage)reportRecovery() {
+	r := &st.recovery
+	r.FirstIndex = st.FirstIndex
+	if r.FirstIndex == math.MaxInt64 {
+		r.FirstIndex = 0
+	}
+	r.LastIndex = st.LastIndex
+	if r.Ok() {
+		st.log.Info("recovery report", "report", r.String())
+	} else {
+		st.log.Warn("recovery report", "report", r.String())
+	}
+}
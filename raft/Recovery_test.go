@@ -0,0 +1,101 @@
+package raft
+
+import (
+	"math"
+	"math/rand"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/fallowu/big-ssdb/logger"
+)
+
+// the log is truncated at the first bad uncommitted entry, with or without
+// @LogMeta
+func TestRecoveryReport(t *testing.T){
+	logger.SetDefaultLevel(logger.LevelError)
+	damages := map[string]func(db Db){
+		"missing": func(db Db) {
+			db.Delete(logKey(13))
+		},
+		"checksum": func(db Db) {
+			db.Set(logKey(13), strings.Replace(db.Get(logKey(13)), "d13", "x13", 1))
+		},
+		"entry#14 at 13": func(db Db) {
+			db.Set(logKey(13), db.Get(logKey(14)))
+		},
+		"no checksum": func(db Db) {
+			db.Set(logKey(13), stripTestChecksum(db.Get(logKey(13))))
+		},
+	}
+	for reason, damage := range damages {
+		for _, scan := range []bool{false, true} {
+			st := newTestStorage()
+			log := randomLog(rand.New(rand.NewSource(1)), 20)
+			for _, ent := range log {
+				st.WriteEntry(ent)
+			}
+			st.CommitEntry(10)
+			if r := NewNode("n1", "addr1", st.db).store.Recovery(); !r.Ok() || r.Checked != 10 || r.Source != "log meta" {
+				t.Fatal("clean", r)
+			}
+			damage(st.db)
+			if scan {
+				st.db.Delete("@LogMeta")
+			}
+			restarted := NewNode("n1", "addr1", st.db)
+			r := restarted.store.Recovery()
+			if restarted.store.LastIndex != 12 || r.TornIndex != 13 || r.LastIndex != 12 || !strings.Contains(r.Reason, reason[:5]) {
+				t.Fatal(reason, scan, r)
+			}
+			if r.Discarded < 7 || restarted.store.GetEntry(13) != nil || restarted.Err() != nil {
+				t.Fatal(reason, scan, r, restarted.Err())
+			}
+			if !strings.Contains(restarted.Info(), "torn: 13") {
+				t.Fatal(restarted.Info())
+			}
+		}
+	}
+}
+
+var testChecksum = regexp.MustCompile("#[0-9a-f]{8}")
+
+// as encoded by versions before checksums
+func stripTestChecksum(record string) string {
+	return testChecksum.ReplaceAllLiteralString(record, "")
+}
+
+// entries of old versions have no checksum, accepted and counted before
+// @ChecksumFrom only
+func TestRecoveryLegacyEntries(t *testing.T){
+	logger.SetDefaultLevel(logger.LevelError)
+	st := newTestStorage()
+	for _, ent := range randomLog(rand.New(rand.NewSource(1)), 20) {
+		st.WriteEntry(ent)
+	}
+	st.CommitEntry(10)
+	for idx := int64(1); idx <= 20; idx ++ {
+		st.db.Set(logKey(idx), stripTestChecksum(st.db.Get(logKey(idx))))
+	}
+	st.db.Delete("@ChecksumFrom")
+
+	for i := 0; i < 2; i ++ {
+		restarted := NewNode("n1", "addr1", st.db)
+		if r := restarted.store.Recovery(); !r.Ok() || restarted.store.LastIndex != 20 {
+			t.Fatal(i, r)
+		}
+		if restarted.store.checksumFrom != 21 || restarted.store.GetEntry(5) == nil {
+			t.Fatal(i, restarted.store.checksumFrom)
+		}
+		if m := restarted.StorageMetrics(); m.EntriesUnverified < 10 {
+			t.Fatal(i, "unverified", m.EntriesUnverified)
+		}
+	}
+
+	// an entry from the boundary on must have one
+	st.db.Set("@ChecksumFrom", "15")
+	restarted := NewNode("n1", "addr1", st.db)
+	if r := restarted.store.Recovery(); r.TornIndex != 15 || r.Reason != errNoChecksum.Error() || restarted.store.checksumFrom == math.MaxInt64 {
+		t.Fatal(r)
+	}
+}
@@ -0,0 +1,87 @@
+package raft
+
+import (
+	"sync/atomic"
+)
+
+// Once StartReplicators() is called, messages to each member are sent by a
+// goroutine of the member, from a queue of its own, instead of through
+// SendC(). Entries in queued messages are immutable copies of the log, a
+// member with a saturated link, or receiving a large snapshot, only fills
+// its own queue and never delays messages to other members. A full queue
+// drops messages, raft resends entries not acked.
+type replicator struct{
+	id string
+	c chan *Message
+	dropped int64
+}
+
+// send is called without lock, by one goroutine per member. Must be called
+// before Start()
+func (node *Node)StartReplicators(send func(msg *Message) bool){
+	node.mux.Lock()
+	defer node.mux.Unlock()
+	node.replicaSend = send
+	node.replicators = make(map[string]*replicator)
+}
+
+// with node's lock held, false if replicators are not started
+func (node *Node)sendToReplicator(msg *Message) bool {
+	if node.replicaSend == nil {
+		return false
+	}
+	r := node.replicators[msg.Dst]
+	if r == nil {
+		r = node.startReplicator(msg.Dst)
+	}
+	select {
+	case r.c <- msg:
+	default:
+		n := atomic.AddInt64(&r.dropped, 1)
+		if node.log.DebugEnabled() {
+			node.log.Debug("member queue full, drop message", "peer", msg.Dst, "type", msg.Type, "dropped", n)
+		}
+	}
+	return true
+}
+
+func (node *Node)startReplicator(id string) *replicator {
+	r := &replicator{id: id, c: make(chan *Message, node.queues.SendSize)}
+	node.replicators[id] = r
+	send := node.replicaSend
+	stop := node.stopChan()
+	node.goroutines.Add(1)
+	go func() {
+		defer node.goroutines.Done()
+		node.log.Info("setup replicator", "peer", id)
+		for {
+			select {
+			case msg, ok := <-r.c:
+				if !ok {
+					return
+				}
+				send(msg)
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return r
+}
+
+// member removed, messages already queued are still sent
+func (node *Node)stopReplicator(id string){
+	if r := node.replicators[id]; r != nil {
+		delete(node.replicators, id)
+		close(r.c)
+	}
+}
+
+// messages queued and dropped for member, 0 if replicators are not started
+func (node *Node)replicatorQueue(id string) (int, int64) {
+	r := node.replicators[id]
+	if r == nil {
+		return 0, 0
+	}
+	return len(r.c), atomic.LoadInt64(&r.dropped)
+}
@@ -0,0 +1,45 @@
+package raft
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fallowu/big-ssdb/logger"
+)
+
+func TestReplicators(t *testing.T){
+	logger.SetDefaultLevel(logger.LevelError)
+	node := NewNode("n1", "addr1", NewMemDb())
+	block := make(chan struct{})
+	defer close(block)
+	received := make(chan *Message, 10)
+	node.StartReplicators(func(msg *Message) bool {
+		if msg.Dst == "n3" {
+			<-block
+		}
+		received <- msg
+		return true
+	})
+
+	// n3's link is saturated
+	node.mux.Lock()
+	for i := 0; i < 3; i ++ {
+		node.send(NewNoneMsg("n3"))
+	}
+	node.send(NewNoneMsg("n2"))
+	node.mux.Unlock()
+	select {
+	case msg := <-received:
+		if msg.Dst != "n2" {
+			t.Fatal("dst", msg.Dst)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("blocked by n3")
+	}
+	node.mux.Lock()
+	queued, _ := node.replicatorQueue("n3")
+	node.mux.Unlock()
+	if queued < 2 {
+		t.Fatal("n3 queued", queued)
+	}
+}
@@ -0,0 +1,30 @@
+package raft
+
+// Member.SendWindow adapts to the member by AIMD: it grows by 1 after a
+// window of entries is acked, and halves on a reject or a resend timeout,
+// within [1, maxSendWindow]. It starts from WithSendWindow() on becoming
+// leader.
+
+// with node's lock held, acked entries newly matched by the member
+func (node *Node)growSendWindow(m *Member, acked int64){
+	if acked <= 0 || m.SendWindow >= node.maxSendWindow {
+		return
+	}
+	m.windowAcked += acked
+	if m.windowAcked >= m.SendWindow {
+		m.windowAcked = 0
+		m.SendWindow ++
+	}
+}
+
+// with node's lock held
+func (node *Node)shrinkSendWindow(m *Member, reason string){
+	m.windowAcked = 0
+	if m.SendWindow <= 1 {
+		return
+	}
+	m.SendWindow = m.SendWindow / 2
+	if node.log.DebugEnabled() {
+		node.log.Debug("shrink send window", "peer", m.Id, "window", m.SendWindow, "reason", reason)
+	}
+}
@@ -0,0 +1,39 @@
+package raft
+
+import (
+	"testing"
+
+	"github.com/fallowu/big-ssdb/logger"
+)
+
+func TestSendWindow(t *testing.T){
+	logger.SetDefaultLevel(logger.LevelError)
+	n1 := NewNode("n1", "addr1", NewMemDb(), WithSendWindow(2), WithMaxSendWindow(4))
+	n1.addMember("n2", "addr2")
+	m := n1.Members["n2"]
+	if m.SendWindow != 2 {
+		t.Fatal("window", m.SendWindow)
+	}
+	// a window of acks grows it by 1
+	n1.growSendWindow(m, 1)
+	if m.SendWindow != 2 {
+		t.Fatal("window", m.SendWindow)
+	}
+	n1.growSendWindow(m, 1)
+	n1.growSendWindow(m, 3)
+	n1.growSendWindow(m, 10)
+	if m.SendWindow != 4 {
+		t.Fatal("window", m.SendWindow)
+	}
+	n1.shrinkSendWindow(m, "reject")
+	n1.shrinkSendWindow(m, "timeout")
+	n1.shrinkSendWindow(m, "timeout")
+	if m.SendWindow != 1 {
+		t.Fatal("window", m.SendWindow)
+	}
+
+	n1.resetMember(m)
+	if m.SendWindow != 2 {
+		t.Fatal("window", m.SendWindow)
+	}
+}
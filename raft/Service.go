@@ -0,0 +1,66 @@
+package raft
+
+import (
+	"io"
+)
+
+// Replicated state machine fed with committed entries, replaces the former
+// Service interface: ApplyEntry() is now Apply(), and the state is saved to
+// and restored from a stream, so that it can be shipped with snapshots
+// instead of rebuilt from the whole log. Plugged in by Node.SetService().
+type StateMachine interface{
+	// Last checkpoint of applied entries within the state machine
+	LastApplied() int64
+	// If entry is not idempotent, state machine must apply entry
+	// and update lastApplied in a transaction for atomicity. The result(nil
+	// if none) is returned by ProposeAndWait() of the entry on the leader
+	Apply(ent *Entry) Result
+	// State as of LastApplied(). The format is the state machine's own, and
+	// should carry a version of it, raft treats it as opaque bytes.
+	// ErrSnapshotUnsupported if not supported. Called without raft's lock,
+	// concurrently with Apply(), once the apply goroutine is started
+	SaveSnapshot(w io.Writer) error
+	// Replaces the whole state with one written by SaveSnapshot(),
+	// LastApplied() is the snapshot's afterwards
+	RestoreSnapshot(r io.Reader) error
+}
+
+// Returned by Apply(), nil if none
+type Result = interface{}
+
+// Deprecated: implement StateMachine
+type Service = StateMachine
+
+// Optional, implemented by StateMachine which wants to know that entries it
+// has not applied are no longer in raft's log(replaced by a raft snapshot).
+// Applying stops until a StateMachine is set again.
+type ApplyBrokenHandler interface{
+	RaftApplyBroken()
+	// RaftIsUp()
+	// RaftIsDown()
+}
+
+// Optional, implemented by Service which runs leader-only jobs(e.g. TTL
+// expiry), see Leadership.go
+type LeadershipObserver interface{
+	// commitIndex when the node became leader, entries up to it may not be
+	// applied to the Service yet
+	OnBecomeLeader(term int32, commitIndex int64)
+	// proposals of term are failing from now on
+	OnLoseLeadership(term int32, commitIndex int64)
+}
+
+// Optional, implemented by Service which supports cluster-wide consistency check
+type StateHasher interface{
+	// Hash of the service state right after LastApplied() was applied,
+	// MUST be identical on every node with the same applied state
+	StateHash() string
+}
+
+// Optional, implemented by Service whose apply results are compared, see
+// Node.SetApplyCheck()
+type EffectReporter interface{
+	// Result of the last Apply(), e.g. the value written or read. MUST
+	// be identical on every node applying the same entry
+	LastEffect() string
+}
@@ -0,0 +1,114 @@
+package raft
+
+import (
+	"errors"
+	"io"
+	"strings"
+
+	"github.com/fallowu/big-ssdb/logger"
+)
+
+// Service's state, written by StateMachine.SaveSnapshot(), is shipped with
+// raft snapshots, so that a member installing one has the data, not only
+// raft's metadata. Entries after the state's LastApplied() are included in
+// the snapshot, the member's applier restores the state and applies them.
+// Without it(ErrSnapshotUnsupported), Service is notified by
+// ApplyBrokenHandler of the lost entries, as before.
+//
+// Saving the state may take long, it is done without node's lock once the
+// apply goroutine is started(Apply() runs without the lock then), with it
+// otherwise, see saveService().
+
+// encoded after entries, base64 of the state
+const serviceStatePrefix = "@service "
+
+// with node's lock held, LastApplied() of the state to be saved by
+// saveService(), false if Service has none or entries after it are no
+// longer in the log
+func (sn *Snapshot)prepareService(st *Storage) (int64, bool) {
+	svc := st.Service
+	if svc == nil {
+		return 0, false
+	}
+	// the state saved is as of LastApplied() or later
+	applied := svc.LastApplied()
+	if applied < st.CommitIndex && applied + 1 < st.FirstIndex {
+		st.log.Warn("service state too old for snapshot", "serviceLastApplied", applied, "firstIndex", st.FirstIndex)
+		return 0, false
+	}
+	sn.pendingService = svc
+	return applied, true
+}
+
+// Saves the state prepared, if not saved yet. Without node's lock unless
+// Apply() is called with it, see savesServiceLocked()
+func (sn *Snapshot)saveService(log *logger.Logger){
+	svc := sn.pendingService
+	if svc == nil {
+		return
+	}
+	sn.pendingService = nil
+	var buf strings.Builder
+	if err := svc.SaveSnapshot(&buf); err != nil {
+		if !errors.Is(err, ErrSnapshotUnsupported) {
+			log.Error("save service snapshot", "err", err)
+		}
+		return
+	}
+	sn.service = buf.String()
+	sn.hasService = true
+}
+
+// Writes sn as WriteTo() does, the state prepared is saved into w, not held
+// in memory as saveService() does. Without node's lock as saveService()
+func (sn *Snapshot)writeService(w io.Writer) (int64, error) {
+	svc := sn.pendingService
+	if svc == nil {
+		return sn.WriteTo(w)
+	}
+	sn.pendingService = nil
+	return sn.writeTo(w, func(service io.Writer) error {
+		// nothing is written then
+		if err := svc.SaveSnapshot(service); !errors.Is(err, ErrSnapshotUnsupported) {
+			return err
+		}
+		return nil
+	})
+}
+
+// with node's lock held, true if Service's state must be saved with it, as
+// Apply() is called with it before StartApplier()
+func (node *Node)savesServiceLocked() bool {
+	return node.applyC == nil
+}
+
+// Service state carried by the snapshot, false if none
+func (sn *Snapshot)ServiceState() (string, bool) {
+	return sn.service, sn.hasService
+}
+
+// with node's lock held, after the raft snapshot is installed. Entries
+// queued before are of the old state, waiters of them fail
+func (node *Node)restoreService(sn *Snapshot){
+	svc := node.store.Service
+	if svc == nil || !sn.hasService {
+		return
+	}
+	node.log.Info("restore Service snapshot", "index", sn.LastIndex(), "bytes", len(sn.service))
+	node.applyGen ++
+	node.applyLost = false
+	node.applyRestoring = false
+	node.failApplyWaiters(ErrApplyResultLost)
+	node.applyRestore = &applyTask{svc: svc, gen: node.applyGen, restore: true, state: sn.service}
+	node.dispatchApply()
+}
+
+// with node's lock held, applying resumes after the restored state
+func (node *Node)onServiceRestored(res *applyResult){
+	node.applyRestoring = false
+	if res.err != nil {
+		node.log.Error("restore Service snapshot", "err", res.err)
+	}
+	node.serviceApplied = res.applied
+	node.applyQueued = res.applied
+}
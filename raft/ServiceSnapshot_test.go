@@ -0,0 +1,244 @@
+package raft
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fallowu/big-ssdb/logger"
+)
+
+// counts data entries, the count is its state
+type snapshotService struct{
+	countingService
+	restored int
+}
+
+func (svc *snapshotService)SaveSnapshot(w io.Writer) error {
+	_, err := fmt.Fprintf(w, "%d %d", svc.lastApplied, svc.count)
+	return err
+}
+
+func (svc *snapshotService)RestoreSnapshot(r io.Reader) error {
+	svc.restored ++
+	_, err := fmt.Fscanf(r, "%d %d", &svc.lastApplied, &svc.count)
+	return err
+}
+
+func TestServiceSnapshot(t *testing.T){
+	logger.SetDefaultLevel(logger.LevelError)
+	var queue []*Message
+	outbox := func(msg *Message) {
+		cp := *msg
+		queue = append(queue, &cp)
+	}
+	policy := DefaultSnapshotPolicy()
+	policy.Entries = 2
+	n1 := NewNode("n1", "addr1", NewMemDb(), WithSnapshotPolicy(policy))
+	n2 := NewNode("n2", "addr2", NewMemDb())
+	svc1 := new(snapshotService)
+	svc2 := new(snapshotService)
+	n1.SetService(svc1)
+	n2.SetService(svc2)
+	nodes := map[string]*Node{"n1": n1, "n2": n2}
+	pump := func() {
+		for len(queue) > 0 {
+			msg := queue[0]
+			queue = queue[1:]
+			nodes[msg.Dst].StepMessage(msg)
+		}
+	}
+	for _, n := range nodes {
+		n.SetOutbox(outbox)
+	}
+	n1.AddMember("n1", "addr1")
+	n1.StepTick(0)
+	for i := 0; i < 5; i ++ {
+		n1.Propose("data")
+	}
+	n1.StepTick(0)
+	n1.AddMember("n2", "addr2")
+	n2.JoinGroup("n1", "addr1")
+	for i := 0; i < 50; i ++ {
+		n1.StepTick(100)
+		n2.StepTick(100)
+		pump()
+	}
+
+	sn := n1.CreateSnapshot()
+	if state, ok := sn.ServiceState(); !ok || state != fmt.Sprintf("%d 5", sn.LastIndex()) {
+		t.Fatal("service state", state, ok)
+	}
+	if n2.Metrics().SnapshotsInstalled != 1 || svc2.restored != 1 {
+		t.Fatal("installed", n2.Metrics().SnapshotsInstalled, "restored", svc2.restored)
+	}
+	// entries before the snapshot's are not in n2's log, but in the state
+	if svc2.count != 5 || svc2.lastApplied != n1.store.CommitIndex {
+		t.Fatal("count", svc2.count, "lastApplied", svc2.lastApplied, "commit", n1.store.CommitIndex)
+	}
+	// and applying goes on after the restored state
+	n1.Propose("data")
+	for i := 0; i < 5; i ++ {
+		n1.StepTick(100)
+		n2.StepTick(100)
+		pump()
+	}
+	if svc2.count != 6 {
+		t.Fatal("count", svc2.count)
+	}
+}
+
+// snapshotService applied by the apply goroutine, SaveSnapshot() waits for
+// release once blocked
+type savingService struct{
+	mux sync.Mutex
+	snapshotService
+	saving chan struct{}
+	release chan struct{}
+}
+
+func (svc *savingService)LastApplied() int64 {
+	svc.mux.Lock()
+	defer svc.mux.Unlock()
+	return svc.snapshotService.LastApplied()
+}
+
+func (svc *savingService)Apply(ent *Entry) Result {
+	svc.mux.Lock()
+	defer svc.mux.Unlock()
+	return svc.snapshotService.Apply(ent)
+}
+
+func (svc *savingService)SaveSnapshot(w io.Writer) error {
+	svc.mux.Lock()
+	saving, release := svc.saving, svc.release
+	svc.mux.Unlock()
+	if saving != nil {
+		saving <- struct{}{}
+		<-release
+	}
+	svc.mux.Lock()
+	defer svc.mux.Unlock()
+	return svc.snapshotService.SaveSnapshot(w)
+}
+
+func (svc *savingService)RestoreSnapshot(r io.Reader) error {
+	svc.mux.Lock()
+	defer svc.mux.Unlock()
+	return svc.snapshotService.RestoreSnapshot(r)
+}
+
+func (svc *savingService)block() {
+	svc.mux.Lock()
+	defer svc.mux.Unlock()
+	svc.saving = make(chan struct{}, 1)
+	svc.release = make(chan struct{})
+}
+
+// fails unless fn returns soon
+func notBlocked(t *testing.T, what string, fn func()) {
+	t.Helper()
+	done := make(chan struct{})
+	go func() {
+		fn()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(3 * time.Second):
+		t.Fatal(what, "blocked")
+	}
+}
+
+// with the apply goroutine, Service's state is saved without node's lock
+func TestServiceSnapshotUnlocked(t *testing.T){
+	logger.SetDefaultLevel(logger.LevelError)
+	var mux sync.Mutex
+	var queue []*Message
+	outbox := func(msg *Message) {
+		cp := *msg
+		mux.Lock()
+		queue = append(queue, &cp)
+		mux.Unlock()
+	}
+	policy := DefaultSnapshotPolicy()
+	policy.Entries = 2
+	n1 := NewNode("n1", "addr1", NewMemDb(), WithSnapshotPolicy(policy))
+	n2 := NewNode("n2", "addr2", NewMemDb())
+	svc1, svc2 := new(savingService), new(savingService)
+	n1.SetService(svc1)
+	n2.SetService(svc2)
+	n1.StartApplier()
+	n2.StartApplier()
+	defer n1.Close()
+	defer n2.Close()
+	nodes := map[string]*Node{"n1": n1, "n2": n2}
+	step := func() {
+		n1.StepTick(100)
+		n2.StepTick(100)
+		mux.Lock()
+		msgs := queue
+		queue = nil
+		mux.Unlock()
+		for _, msg := range msgs {
+			nodes[msg.Dst].StepMessage(msg)
+		}
+	}
+	for _, n := range nodes {
+		n.SetOutbox(outbox)
+	}
+	n1.AddMember("n1", "addr1")
+	n1.StepTick(0)
+	for i := 0; i < 5; i ++ {
+		n1.Propose("data")
+	}
+	n1.StepTick(0)
+	for i := 0; i < 100 && svc1.LastApplied() < n1.Status().CommitIndex; i ++ {
+		time.Sleep(time.Millisecond)
+	}
+
+	svc1.block()
+	done := make(chan *Snapshot, 1)
+	go func() {
+		done <- n1.CreateSnapshot()
+	}()
+	<-svc1.saving
+	notBlocked(t, "tick", func() {
+		n1.StepTick(0)
+		n1.Metrics()
+	})
+	close(svc1.release)
+	if state, ok := (<-done).ServiceState(); !ok || state != fmt.Sprintf("%d 5", n1.Status().CommitIndex) {
+		t.Fatal("service state", state, ok)
+	}
+
+	// sent once saved, raft goes on meanwhile
+	svc1.block()
+	n1.AddMember("n2", "addr2")
+	n2.JoinGroup("n1", "addr1")
+	for i := 0; len(svc1.saving) == 0; i ++ {
+		if i == 100 {
+			t.Fatal("snapshot not sent")
+		}
+		notBlocked(t, "step", step)
+		// the goroutine saving it runs
+		time.Sleep(time.Millisecond)
+	}
+	<-svc1.saving
+	for i := 0; i < 10; i ++ {
+		notBlocked(t, "step", step)
+	}
+	if n2.Metrics().SnapshotsInstalled != 0 {
+		t.Fatal("installed before saved")
+	}
+	close(svc1.release)
+	for i := 0; i < 100 && n2.Metrics().SnapshotsInstalled == 0; i ++ {
+		step()
+		time.Sleep(time.Millisecond)
+	}
+	if n2.Metrics().SnapshotsInstalled != 1 {
+		t.Fatal("not installed")
+	}
+}
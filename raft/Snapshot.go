@@ -0,0 +1,167 @@
+package raft
+
+import (
+	"encoding/base64"
+	"fmt"
+	"encoding/json"
+	"strings"
+
+	"github.com/fallowu/big-ssdb/internal/util"
+	"github.com/fallowu/big-ssdb/logger"
+)
+
+// for code without a node
+var defaultLog = logger.New("raft")
+
+// Raft's snapshot, with service's state if Service supports SaveSnapshot()
+type Snapshot struct {
+	state *State
+	// 新节点需要至少存储两条日志(如果 commitIndex > 2), 否则收到 Heartbeat 时校验 prevEntry 会失败
+	entries []*Entry
+	// see ServiceSnapshot.go
+	service string
+	hasService bool
+	// state of it to be saved by saveService()
+	pendingService StateMachine
+}
+
+func newSnapshot() *Snapshot {
+	sn := new(Snapshot)
+	sn.state = NewState()
+	sn.entries = make([]*Entry, 0)
+	return sn
+}
+
+// With node's lock held, Service's state is saved by saveService() later
+func NewSnapshotFromStorage(store *Storage) *Snapshot {
+	sn := newSnapshot()
+	sn.state.CopyFrom(store.State())
+	sn.entries = make([]*Entry, 0)
+	
+	// see SnapshotPolicy
+	start := util.MaxInt64(1, store.CommitIndex - store.snapshot.Entries + 1)
+	// entries the service state has not applied are included
+	if applied, ok := sn.prepareService(store); ok {
+		start = util.MinInt64(start, applied + 1)
+	}
+	for idx := start; idx <= store.CommitIndex; idx ++ {
+		ent := store.GetEntry(idx)
+		if ent == nil {
+			store.fail(fmt.Errorf("lost entry#%d: %w", idx, ErrCompacted))
+			return nil
+		}
+		// a copy, the log's entry is not touched
+		cp := *ent
+		cp.Commit = cp.Index
+		sn.entries = append(sn.entries, &cp)
+	}
+
+	return sn
+}
+
+func NewSnapshotFromString(data string) *Snapshot {
+	sn := newSnapshot()
+	if !sn.Decode(data) {
+		return nil
+	}
+	return sn
+}
+
+func (sn *Snapshot)LastTerm() int32 {
+	if len(sn.entries) == 0 {
+		return 0
+	}
+	return sn.lastEntry().Term
+}
+
+func (sn *Snapshot)LastIndex() int64 {
+	if len(sn.entries) == 0 {
+		return 0
+	}
+	return sn.lastEntry().Index
+}
+
+func (sn *Snapshot)lastEntry() *Entry {
+	return sn.entries[len(sn.entries)-1]
+}
+
+func (sn *Snapshot)State() *State {
+	return sn.state
+}
+
+// Lastest committed entries
+func (sn *Snapshot)Entries() []*Entry {
+	return sn.entries
+}
+
+func (sn *Snapshot)Encode() string {
+	var arr []string
+
+	arr = append(arr, sn.state.Encode())
+	for _, ent := range sn.entries {
+		arr = append(arr, ent.Encode())
+	}
+	if sn.hasService {
+		arr = append(arr, serviceStatePrefix + base64.StdEncoding.EncodeToString([]byte(sn.service)))
+	}
+	
+	bs, _ := json.Marshal(arr)
+	data := string(bs)
+	return data
+}
+
+func (sn *Snapshot)Decode(data string) bool {
+	var arr []string
+	err := json.Unmarshal([]byte(data), &arr)
+	if err != nil {
+		defaultLog.Warn("json_decode error", "err", err, "data", data)
+		return false
+	}
+	if len(arr) == 0 {
+		defaultLog.Warn("bad snapshot data", "data", data)
+		return false
+	}
+	if sn.state.Decode(arr[0]) != true {
+		defaultLog.Warn("decode state error", "data", data)
+		return false
+	}
+
+	sn.entries = make([]*Entry, 0, len(arr) - 1)
+	for _, s := range arr[1:] {
+		if strings.HasPrefix(s, serviceStatePrefix) {
+			bs, err := base64.StdEncoding.DecodeString(s[len(serviceStatePrefix):])
+			if err != nil {
+				defaultLog.Warn("decode service state error", "err", err)
+				return false
+			}
+			sn.service = string(bs)
+			sn.hasService = true
+			continue
+		}
+		var ent Entry
+		if ent.Decode(s) == false {
+			defaultLog.Warn("decode entry error", "data", data)
+			return false
+		}
+		if !sn.appendEntry(&ent) {
+			defaultLog.Warn("bad snapshot entries", "data", data)
+			return false
+		}
+	}
+
+	return true
+}
+
+// false unless continuous, as NewSnapshotFromStorage() makes
+func (sn *Snapshot)appendEntry(ent *Entry) bool {
+	if len(sn.entries) > 0 {
+		last := sn.lastEntry()
+		if ent.Index != last.Index + 1 || ent.Term < last.Term {
+			return false
+		}
+	} else if ent.Index == 0 {
+		return false
+	}
+	sn.entries = append(sn.entries, ent)
+	return true
+}
@@ -0,0 +1,244 @@
+package raft
+
+import (
+	"io"
+	"os"
+	"strings"
+
+	"github.com/fallowu/big-ssdb/internal/util"
+)
+
+// bytes of encoded snapshot in one InstallSnapshot message, well below the
+// max UDP datagram
+const DefaultSnapshotChunkSize = 16 * 1024
+
+// A snapshot being sent to a member, see sendInstallSnapshot(). Chunks are
+// InstallSnapshot messages with PrevTerm, PrevIndex of the snapshot's last
+// entry, and Data "<offset> <done> <bytes>", done is 1 for the last chunk.
+// The member acks each chunk with InstallSnapshotAck, Data is the bytes it
+// has received in order. Up to SendWindow chunks are sent ahead of acks,
+// unacked ones are resent on replication timeout.
+type snapshotTransfer struct{
+	lastTerm int32
+	lastIndex int64
+	data string
+	// bytes sent and acked
+	sent int
+	acked int
+	// pulled by the member from SnapshotServer instead of data, see
+	// SnapshotStream.go
+	file *os.File
+	// of data or file
+	size int64
+	// sent in the descriptor, required to pull
+	token string
+	// data is being built, see buildSnapshotTransfer()
+	building bool
+}
+
+// A snapshot being received from leader
+type snapshotReceive struct{
+	leader string
+	lastTerm int32
+	lastIndex int64
+	buf strings.Builder
+}
+
+func (node *Node)snapshotChunkSize() int {
+	if n := node.store.snapshot.ChunkSize; n > 0 {
+		return n
+	}
+	return DefaultSnapshotChunkSize
+}
+
+// Encodes sn for a transfer, into a file of srv if it is larger than a
+// chunk. Without node's lock unless savesServiceLocked(), as saveService()
+func (node *Node)encodeSnapshotTransfer(sn *Snapshot, srv *SnapshotServer, chunkSize int) (string, *os.File, error) {
+	if srv == nil {
+		sn.saveService(node.log)
+		return sn.Encode(), nil, nil
+	}
+	f, size, err := srv.writeSnapshot(sn)
+	if err != nil {
+		return "", nil, err
+	}
+	if size > int64(chunkSize) {
+		return "", f, nil
+	}
+	// sent in chunks, Service's state is no longer prepared
+	defer removeStreamFile(f)
+	if _, err = f.Seek(0, io.SeekStart); err == nil {
+		sn, err = ReadSnapshot(f)
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	return sn.Encode(), nil, nil
+}
+
+// with node's lock held, data is the encoded snapshot, or file of
+// SnapshotServer, see encodeSnapshotTransfer()
+func (node *Node)startSnapshotTransfer(m *Member, data string, file *os.File){
+	tr := m.snapshot
+	tr.data = data
+	tr.size = int64(len(data))
+	if file != nil {
+		tr.file = file
+		tr.size, _ = file.Seek(0, io.SeekEnd)
+		tr.token = newSnapshotToken()
+		node.snapshotServer.put(m.Id, tr)
+	}
+}
+
+// with node's lock held, on an error of encodeSnapshotTransfer(), the next
+// replication starts again
+func (node *Node)failSnapshotTransfer(m *Member, err error){
+	node.log.Error("encode snapshot", "peer", m.Id, "err", err)
+	m.snapshot = nil
+	m.InstallingSnapshot = false
+}
+
+// With node's lock held, Service's state is saved and the snapshot encoded
+// by a goroutine without it, chunks are sent by the next tick after that. The
+// transfer is dropped if m.snapshot is reset meanwhile.
+func (node *Node)buildSnapshotTransfer(m *Member, sn *Snapshot){
+	tr := m.snapshot
+	tr.building = true
+	srv := node.snapshotServer
+	chunkSize := node.snapshotChunkSize()
+	stop := node.stopChan()
+	node.goroutines.Add(1)
+	go func() {
+		defer node.goroutines.Done()
+		data, file, err := node.encodeSnapshotTransfer(sn, srv, chunkSize)
+		select {
+		case <-stop:
+			removeStreamFile(file)
+			return
+		default:
+		}
+		node.mux.Lock()
+		defer node.unlock()
+		if m.snapshot != tr || node.Members[m.Id] != m || node.Role != RoleLeader || node.snapshotServer != srv {
+			removeStreamFile(file)
+			return
+		}
+		if err != nil {
+			node.failSnapshotTransfer(m, err)
+			return
+		}
+		tr.building = false
+		node.startSnapshotTransfer(m, data, file)
+		m.ReplicateTimer = node.timeouts.Replication
+	}()
+}
+
+// sends chunks within the window, with node's lock held
+func (node *Node)sendSnapshotChunks(m *Member){
+	tr := m.snapshot
+	if tr.building {
+		return
+	}
+	if tr.file != nil {
+		node.sendSnapshotDescriptor(m)
+		return
+	}
+	size := node.snapshotChunkSize()
+	m.ReplicateTimer = 0
+	for tr.sent < len(tr.data) && int64(tr.sent - tr.acked) < m.SendWindow * int64(size) {
+		end := util.MinInt(tr.sent + size, len(tr.data))
+		done := "0"
+		if end == len(tr.data) {
+			done = "1"
+		}
+		msg := NewInstallSnapshotMsg(m.Id, util.Itoa(tr.sent) + " " + done + " " + tr.data[tr.sent:end])
+		msg.PrevTerm = tr.lastTerm
+		msg.PrevIndex = tr.lastIndex
+		node.send(msg)
+		tr.sent = end
+		m.HeartbeatTimer = 0
+	}
+}
+
+// with node's lock held, on replication timeout
+func (node *Node)resendSnapshotChunks(m *Member){
+	tr := m.snapshot
+	if tr.sent != tr.acked {
+		node.log.Info("resend snapshot chunks", "peer", m.Id, "sent", tr.sent, "acked", tr.acked, "size", len(tr.data))
+		tr.sent = tr.acked
+	}
+	node.sendSnapshotChunks(m)
+}
+
+func (node *Node)handleInstallSnapshotAck(msg *Message){
+	m := node.Members[msg.Src]
+	m.ReceiveTimeout = 0
+	tr := m.snapshot
+	if tr == nil || msg.PrevIndex != tr.lastIndex || msg.PrevTerm != tr.lastTerm {
+		return
+	}
+	acked := util.Atoi(msg.Data)
+	if acked == 0 && tr.acked > 0 {
+		// member restarted, or received chunks of another snapshot
+		node.log.Info("restart sending snapshot", "peer", m.Id, "acked", tr.acked)
+		tr.acked = 0
+		tr.sent = 0
+	} else if acked == tr.acked {
+		return
+	} else if acked < tr.acked {
+		// member has less than acked before, resend from there. A stale
+		// ack costs resending chunks, which are acked with what is received
+		node.log.Info("rewind snapshot chunks", "peer", m.Id, "acked", acked, "was", tr.acked)
+		tr.acked = acked
+		tr.sent = acked
+		node.sendSnapshotChunks(m)
+		return
+	}
+	tr.acked = util.MinInt(acked, len(tr.data))
+	tr.sent = util.MaxInt(tr.sent, tr.acked)
+	node.sendSnapshotChunks(m)
+}
+
+// complete snapshot data, or "" if more chunks are expected, with node's lock
+// held
+func (node *Node)receiveSnapshotChunk(msg *Message) string {
+	// sent by old versions in one message
+	if strings.HasPrefix(msg.Data, "[") {
+		return msg.Data
+	}
+	ps := strings.SplitN(msg.Data, " ", 3)
+	if len(ps) != 3 {
+		node.log.Warn("bad snapshot chunk", "peer", msg.Src, "data", msg.Data)
+		return ""
+	}
+	offset := util.Atoi(ps[0])
+	rc := node.snapshotRecv
+	// a duplicated first chunk of the same snapshot keeps what is received
+	if offset == 0 && (rc == nil || rc.leader != msg.Src || rc.lastTerm != msg.PrevTerm || rc.lastIndex != msg.PrevIndex) {
+		rc = &snapshotReceive{leader: msg.Src, lastTerm: msg.PrevTerm, lastIndex: msg.PrevIndex}
+		node.snapshotRecv = rc
+	}
+	if rc == nil || rc.leader != msg.Src || rc.lastTerm != msg.PrevTerm || rc.lastIndex != msg.PrevIndex {
+		// leader restarts from the first chunk
+		ack := NewInstallSnapshotAck(msg.Src, 0)
+		ack.PrevTerm = msg.PrevTerm
+		ack.PrevIndex = msg.PrevIndex
+		node.send(ack)
+		return ""
+	}
+	// a duplicated or out of order chunk is acked with bytes received so far
+	if offset == rc.buf.Len() {
+		rc.buf.WriteString(ps[2])
+	} else if node.log.DebugEnabled() {
+		node.log.Debug("out of order snapshot chunk", "peer", msg.Src, "offset", offset, "received", rc.buf.Len())
+	}
+	if ps[1] == "1" && offset + len(ps[2]) == rc.buf.Len() {
+		node.snapshotRecv = nil
+		return rc.buf.String()
+	}
+	ack := NewInstallSnapshotAck(msg.Src, rc.buf.Len())
+	ack.PrevTerm = rc.lastTerm
+	ack.PrevIndex = rc.lastIndex
+	node.send(ack)
+	return ""
+}
@@ -0,0 +1,161 @@
+package raft
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/fallowu/big-ssdb/internal/util"
+	"github.com/fallowu/big-ssdb/logger"
+)
+
+func TestSnapshotChunks(t *testing.T){
+	logger.SetDefaultLevel(logger.LevelError)
+	var queue []*Message
+	outbox := func(msg *Message) {
+		cp := *msg
+		queue = append(queue, &cp)
+	}
+	policy := DefaultSnapshotPolicy()
+	policy.ChunkSize = 16
+	n1 := NewNode("n1", "addr1", NewMemDb(), WithSnapshotPolicy(policy))
+	n2 := NewNode("n2", "addr2", NewMemDb())
+	nodes := map[string]*Node{"n1": n1, "n2": n2}
+	chunks := 0
+	pump := func() {
+		for len(queue) > 0 {
+			msg := queue[0]
+			queue = queue[1:]
+			if msg.Type == MessageTypeInstallSnapshot {
+				chunks ++
+				// lost, resent on replication timeout
+				if chunks % 3 == 0 {
+					continue
+				}
+			}
+			nodes[msg.Dst].StepMessage(msg)
+		}
+	}
+	for _, n := range nodes {
+		n.SetOutbox(outbox)
+	}
+	n1.AddMember("n1", "addr1")
+	n1.StepTick(0)
+	for i := 0; i < 5; i ++ {
+		n1.Propose("data")
+	}
+	n1.StepTick(0)
+	n1.AddMember("n2", "addr2")
+	n2.JoinGroup("n1", "addr1")
+	// a lost chunk costs a replication timeout
+	for i := 0; i < 200; i ++ {
+		n1.StepTick(100)
+		n2.StepTick(100)
+		pump()
+	}
+
+	size := len(n1.CreateSnapshot().Encode())
+	if chunks < size / 16 {
+		t.Fatal("chunks", chunks, "size", size)
+	}
+	if n1.Metrics().SnapshotsSent != 1 || n2.Metrics().SnapshotsInstalled != 1 {
+		t.Fatal("sent", n1.Metrics().SnapshotsSent, "installed", n2.Metrics().SnapshotsInstalled)
+	}
+	if m := n1.Metrics(); m.CommitIndex != n2.Metrics().LastIndex || m.Members[0].InstallingSnapshot {
+		t.Fatal("commit", m.CommitIndex, "follower", n2.Metrics().LastIndex, m.Members)
+	}
+}
+
+// a duplicated first chunk keeps the chunks received
+func TestSnapshotChunkDuplicated(t *testing.T){
+	logger.SetDefaultLevel(logger.LevelError)
+	n1 := NewNode("n1", "addr1", NewMemDb())
+	n1.SetOutbox(func(msg *Message) {})
+	n1.AddMember("n1", "addr1")
+	n1.StepTick(0)
+	n1.Propose("data")
+	n1.StepTick(0)
+	sn := n1.CreateSnapshot()
+	data := sn.Encode()
+
+	var acks []string
+	n2 := NewNode("n2", "addr2", NewMemDb())
+	n2.SetOutbox(func(msg *Message) {
+		if msg.Type == MessageTypeInstallSnapshotAck {
+			acks = append(acks, msg.Data)
+		}
+	})
+	n2.JoinGroup("n1", "addr1")
+	chunk := func(offset int) {
+		end := offset + 4
+		done := "0"
+		if end >= len(data) {
+			end = len(data)
+			done = "1"
+		}
+		msg := NewInstallSnapshotMsg("n2", fmt.Sprintf("%d %s %s", offset, done, data[offset:end]))
+		msg.Src = "n1"
+		msg.Term = n1.Term
+		msg.PrevTerm = sn.LastTerm()
+		msg.PrevIndex = sn.LastIndex()
+		n2.StepMessage(msg)
+	}
+	for _, offset := range []int{0, 4, 8, 0, 12, 4} {
+		chunk(offset)
+	}
+	if fmt.Sprint(acks) != "[4 8 12 12 16 16]" {
+		t.Fatal("acks", acks)
+	}
+	for offset := 16; offset < len(data); offset += 4 {
+		chunk(offset)
+	}
+	if n2.Metrics().SnapshotsInstalled != 1 || n2.Metrics().LastIndex != sn.LastIndex() {
+		t.Fatal("not installed", n2.Metrics().SnapshotsInstalled, acks)
+	}
+}
+
+// chunks and acks duplicated and reordered
+func TestSnapshotChunksReordered(t *testing.T){
+	logger.SetDefaultLevel(logger.LevelError)
+	for seed := int64(1); seed <= 20; seed ++ {
+		r := rand.New(rand.NewSource(seed))
+		var queue []*Message
+		outbox := func(msg *Message) {
+			cp := *msg
+			queue = append(queue, &cp)
+			if r.Intn(4) == 0 {
+				dup := cp
+				queue = append(queue, &dup)
+			}
+		}
+		policy := DefaultSnapshotPolicy()
+		policy.ChunkSize = 16
+		n1 := NewNode("n1", "addr1", NewMemDb(), WithSnapshotPolicy(policy))
+		n2 := NewNode("n2", "addr2", NewMemDb())
+		nodes := map[string]*Node{"n1": n1, "n2": n2}
+		for _, n := range nodes {
+			n.SetOutbox(outbox)
+		}
+		n1.AddMember("n1", "addr1")
+		n1.StepTick(0)
+		for i := 0; i < 5; i ++ {
+			n1.Propose("data")
+		}
+		n1.StepTick(0)
+		n1.AddMember("n2", "addr2")
+		n2.JoinGroup("n1", "addr1")
+		for i := 0; i < 200 && n2.Metrics().SnapshotsInstalled == 0; i ++ {
+			n1.StepTick(100)
+			n2.StepTick(100)
+			for len(queue) > 0 {
+				i := r.Intn(util.MinInt(len(queue), 4))
+				msg := queue[i]
+				queue = append(queue[:i], queue[i + 1:]...)
+				nodes[msg.Dst].StepMessage(msg)
+			}
+		}
+		if n2.Metrics().SnapshotsInstalled != 1 {
+			t.Fatalf("seed %d: not installed", seed)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package raft
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/fallowu/big-ssdb/logger"
+)
+
+// Snapshot files "snap-<term>-<index>.snap" in a directory, of LastTerm()
+// and LastIndex(). Each is written to a ".tmp" file, fsynced and renamed, so
+// a crash leaves no partial .snap file, only a .tmp one removed on open. The
+// latest retain files are kept. Thread safe.
+type SnapshotDir struct{
+	dir string
+	retain int
+	log *logger.Logger
+	mux sync.Mutex
+}
+
+const DefaultSnapshotRetain = 3
+
+type SnapshotFileInfo struct{
+	Path string
+	Term int32
+	Index int64
+	Size int64
+}
+
+// retain <= 0 for DefaultSnapshotRetain
+func OpenSnapshotDir(dir string, retain int) (*SnapshotDir, error) {
+	if retain <= 0 {
+		retain = DefaultSnapshotRetain
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	d := &SnapshotDir{dir: dir, retain: retain, log: logger.New("raft.snapshot").With("dir", dir)}
+	tmps, _ := filepath.Glob(filepath.Join(dir, "snap-*.tmp"))
+	for _, path := range tmps {
+		d.log.Info("remove unfinished snapshot", "file", path)
+		os.Remove(path)
+	}
+	return d, nil
+}
+
+func snapshotFileName(term int32, index int64) string {
+	return fmt.Sprintf("snap-%d-%d.snap", term, index)
+}
+
+func parseSnapshotFileName(name string) (int32, int64, bool) {
+	if !strings.HasPrefix(name, "snap-") || !strings.HasSuffix(name, ".snap") {
+		return 0, 0, false
+	}
+	ps := strings.Split(strings.TrimSuffix(strings.TrimPrefix(name, "snap-"), ".snap"), "-")
+	if len(ps) != 2 {
+		return 0, 0, false
+	}
+	term, ok1 := parseTerm(ps[0])
+	index, ok2 := parseIndex(ps[1])
+	return term, index, ok1 && ok2
+}
+
+// oldest first
+func (d *SnapshotDir)List() ([]SnapshotFileInfo, error) {
+	des, err := os.ReadDir(d.dir)
+	if err != nil {
+		return nil, err
+	}
+	var ret []SnapshotFileInfo
+	for _, de := range des {
+		term, index, ok := parseSnapshotFileName(de.Name())
+		if !ok || de.IsDir() {
+			continue
+		}
+		info := SnapshotFileInfo{Path: filepath.Join(d.dir, de.Name()), Term: term, Index: index}
+		if fi, err := de.Info(); err == nil {
+			info.Size = fi.Size()
+		}
+		ret = append(ret, info)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].Index != ret[j].Index {
+			return ret[i].Index < ret[j].Index
+		}
+		return ret[i].Term < ret[j].Term
+	})
+	return ret, nil
+}
+
+// Writes sn and removes files beyond retain
+func (d *SnapshotDir)Save(sn *Snapshot) (SnapshotFileInfo, error) {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	info := SnapshotFileInfo{Path: filepath.Join(d.dir, snapshotFileName(sn.LastTerm(), sn.LastIndex())),
+		Term: sn.LastTerm(), Index: sn.LastIndex()}
+	tmp := info.Path + ".tmp"
+	fp, err := os.Create(tmp)
+	if err != nil {
+		return info, err
+	}
+	info.Size, err = sn.WriteTo(fp)
+	if err == nil {
+		err = fp.Sync()
+	}
+	if cerr := fp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, info.Path)
+	}
+	if err == nil {
+		err = syncDir(d.dir)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return info, fmt.Errorf("save snapshot %s: %w", info.Path, err)
+	}
+	d.log.Info("save snapshot", "file", info.Path, "bytes", info.Size)
+	d.prune()
+	return info, nil
+}
+
+func (d *SnapshotDir)prune() {
+	files, err := d.List()
+	if err != nil {
+		return
+	}
+	for len(files) > d.retain {
+		d.log.Info("remove old snapshot", "file", files[0].Path)
+		os.Remove(files[0].Path)
+		files = files[1:]
+	}
+}
+
+// The newest readable snapshot, corrupt files are skipped. nil if none.
+func (d *SnapshotDir)Latest() (*Snapshot, SnapshotFileInfo, error) {
+	files, err := d.List()
+	if err != nil {
+		return nil, SnapshotFileInfo{}, err
+	}
+	for i := len(files) - 1; i >= 0; i -- {
+		sn, err := ReadSnapshotFile(files[i].Path)
+		if err != nil {
+			d.log.Warn("skip bad snapshot", "file", files[i].Path, "err", err)
+			continue
+		}
+		return sn, files[i], nil
+	}
+	return nil, SnapshotFileInfo{}, nil
+}
+
+func ReadSnapshotFile(path string) (*Snapshot, error) {
+	fp, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fp.Close()
+	return ReadSnapshot(fp)
+}
+
+// so that a rename survives a crash
+func syncDir(dir string) error {
+	fp, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer fp.Close()
+	return fp.Sync()
+}
+
+// Saves a snapshot to d, taken with lock held as CreateSnapshot() does,
+// written without it
+func (node *Node)SaveSnapshot(d *SnapshotDir) (SnapshotFileInfo, error) {
+	sn := node.CreateSnapshot()
+	if sn == nil {
+		return SnapshotFileInfo{}, fmt.Errorf("create snapshot: %w", node.Err())
+	}
+	return d.Save(sn)
+}
@@ -0,0 +1,241 @@
+package raft
+
+import (
+	"bufio"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Snapshot written and read record by record, not built as one string as
+// Encode() does, see SnapshotDir.go:
+//
+//	big-ssdb snapshot 1\n
+//	<kind> <size>\n<bytes>\n        kind: state, entry or service
+//	...
+//	end <records> <crc>\n
+//
+// crc is CRC-32C in hex of every byte before "end". Service state may span
+// several records, written as StateMachine.SaveSnapshot() produces it.
+
+const (
+	snapshotFileHeader = "big-ssdb snapshot 1\n"
+	// bytes of a service record
+	snapshotServiceChunk = 64 * 1024
+	// of a record, larger ones are not trusted
+	maxSnapshotRecord = 64 * 1024 * 1024
+)
+
+type SnapshotWriter struct{
+	w *bufio.Writer
+	crc uint32
+	records int
+	err error
+}
+
+func NewSnapshotWriter(w io.Writer) *SnapshotWriter {
+	sw := &SnapshotWriter{w: bufio.NewWriter(w)}
+	sw.write(snapshotFileHeader)
+	return sw
+}
+
+func (sw *SnapshotWriter)write(s string) {
+	if sw.err != nil {
+		return
+	}
+	sw.crc = crc32.Update(sw.crc, crcTable, []byte(s))
+	_, sw.err = sw.w.WriteString(s)
+}
+
+func (sw *SnapshotWriter)record(kind string, data string) error {
+	sw.write(kind + " " + strconv.Itoa(len(data)) + "\n")
+	sw.write(data)
+	sw.write("\n")
+	sw.records ++
+	return sw.err
+}
+
+func (sw *SnapshotWriter)WriteState(state *State) error {
+	return sw.record("state", state.Encode())
+}
+
+// in index order, after the state
+func (sw *SnapshotWriter)WriteEntry(ent *Entry) error {
+	return sw.record("entry", ent.Encode())
+}
+
+// Service's state, after entries, written in records of
+// snapshotServiceChunk bytes
+func (sw *SnapshotWriter)ServiceWriter() io.Writer {
+	return serviceWriter{sw}
+}
+
+type serviceWriter struct{
+	sw *SnapshotWriter
+}
+
+func (w serviceWriter)Write(p []byte) (int, error) {
+	for i := 0; i < len(p); i += snapshotServiceChunk {
+		end := min(len(p), i + snapshotServiceChunk)
+		if err := w.sw.record("service", string(p[i:end])); err != nil {
+			return i, err
+		}
+	}
+	return len(p), nil
+}
+
+// Writes the trailer and flushes, the underlying writer is not closed
+func (sw *SnapshotWriter)Close() error {
+	if sw.err != nil {
+		return sw.err
+	}
+	if _, err := fmt.Fprintf(sw.w, "end %d %08x\n", sw.records, sw.crc); err != nil {
+		return err
+	}
+	return sw.w.Flush()
+}
+
+// Records of what NewSnapshotWriter() wrote, Next() returns io.EOF after the
+// trailer is verified.
+type SnapshotReader struct{
+	r *bufio.Reader
+	crc uint32
+	records int
+	started bool
+}
+
+func NewSnapshotReader(r io.Reader) *SnapshotReader {
+	return &SnapshotReader{r: bufio.NewReader(r)}
+}
+
+func (sr *SnapshotReader)line() (string, error) {
+	line, err := sr.r.ReadString('\n')
+	if err == io.EOF {
+		// truncated
+		err = io.ErrUnexpectedEOF
+	}
+	return line, err
+}
+
+// kind and bytes of the next record
+func (sr *SnapshotReader)Next() (string, string, error) {
+	if !sr.started {
+		sr.started = true
+		line, err := sr.line()
+		if err != nil {
+			return "", "", err
+		}
+		if line != snapshotFileHeader {
+			return "", "", fmt.Errorf("bad snapshot header %q: %w", line, errBadFormat)
+		}
+		sr.crc = crc32.Update(0, crcTable, []byte(line))
+	}
+	line, err := sr.line()
+	if err != nil {
+		return "", "", err
+	}
+	ps := strings.Fields(line)
+	if len(ps) == 3 && ps[0] == "end" {
+		if ps[1] != strconv.Itoa(sr.records) || ps[2] != fmt.Sprintf("%08x", sr.crc) {
+			return "", "", fmt.Errorf("snapshot trailer %q: %w", strings.TrimSpace(line), ErrCorrupted)
+		}
+		return "", "", io.EOF
+	}
+	if len(ps) != 2 {
+		return "", "", fmt.Errorf("bad snapshot record %q: %w", line, errBadFormat)
+	}
+	size, err := strconv.Atoi(ps[1])
+	if err != nil || size < 0 || size > maxSnapshotRecord {
+		return "", "", fmt.Errorf("bad snapshot record %q: %w", line, errBadFormat)
+	}
+	buf := make([]byte, size + 1)
+	if _, err := io.ReadFull(sr.r, buf); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return "", "", err
+	}
+	if buf[size] != '\n' {
+		return "", "", fmt.Errorf("bad snapshot record %q: %w", line, errBadFormat)
+	}
+	sr.crc = crc32.Update(sr.crc, crcTable, []byte(line))
+	sr.crc = crc32.Update(sr.crc, crcTable, buf)
+	sr.records ++
+	return ps[0], string(buf[:size]), nil
+}
+
+// Writes sn as NewSnapshotWriter() does
+func (sn *Snapshot)WriteTo(w io.Writer) (int64, error) {
+	return sn.writeTo(w, func(service io.Writer) error {
+		if sn.hasService {
+			io.WriteString(service, sn.service)
+		}
+		return nil
+	})
+}
+
+// writes sn with Service's state written by save
+func (sn *Snapshot)writeTo(w io.Writer, save func(service io.Writer) error) (int64, error) {
+	cw := &countingWriter{w: w}
+	sw := NewSnapshotWriter(cw)
+	sw.WriteState(sn.state)
+	for _, ent := range sn.entries {
+		sw.WriteEntry(ent)
+	}
+	if err := save(sw.ServiceWriter()); err != nil {
+		return cw.n, err
+	}
+	err := sw.Close()
+	return cw.n, err
+}
+
+type countingWriter struct{
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter)Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+// Reads what Snapshot.WriteTo() wrote, checked as Decode() does
+func ReadSnapshot(r io.Reader) (*Snapshot, error) {
+	sn := newSnapshot()
+	sr := NewSnapshotReader(r)
+	var service strings.Builder
+	n := 0
+	for ; ; n ++ {
+		kind, data, err := sr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case n == 0:
+			if kind != "state" || !sn.state.Decode(data) {
+				return nil, fmt.Errorf("bad snapshot state: %w", errBadFormat)
+			}
+		case kind == "entry" && !sn.hasService:
+			var ent Entry
+			if !ent.Decode(data) || !sn.appendEntry(&ent) {
+				return nil, fmt.Errorf("bad snapshot entry#%d: %w", n, errBadFormat)
+			}
+		case kind == "service":
+			service.WriteString(data)
+			sn.hasService = true
+		default:
+			return nil, fmt.Errorf("bad snapshot record %s: %w", kind, errBadFormat)
+		}
+	}
+	if n == 0 {
+		return nil, fmt.Errorf("snapshot without state: %w", errBadFormat)
+	}
+	sn.service = service.String()
+	return sn, nil
+}
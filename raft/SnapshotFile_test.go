@@ -0,0 +1,86 @@
+package raft
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/fallowu/big-ssdb/logger"
+)
+
+func TestSnapshotFile(t *testing.T){
+	logger.SetDefaultLevel(logger.LevelError)
+	node := NewNode("n1", "addr1", NewMemDb(), WithSnapshotPolicy(SnapshotPolicy{Entries: 10}))
+	node.AddMember("n1", "addr1")
+	node.StepTick(0)
+	for i := 0; i < 20; i ++ {
+		node.Propose(fmt.Sprintf("d%d\nline", i))
+		node.StepTick(0)
+	}
+	sn := node.CreateSnapshot()
+	// spans several records
+	sn.service = strings.Repeat("s\n", snapshotServiceChunk)
+	sn.hasService = true
+
+	var buf bytes.Buffer
+	n, err := sn.WriteTo(&buf)
+	if err != nil || n != int64(buf.Len()) {
+		t.Fatal(n, err)
+	}
+	got, err := ReadSnapshot(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Hash() != sn.Hash() || got.service != sn.service || len(got.Entries()) != 10 || got.LastIndex() != 22 {
+		t.Fatal("read", got.LastIndex(), len(got.Entries()))
+	}
+
+	data := buf.Bytes()
+	if _, err := ReadSnapshot(bytes.NewReader(data[:len(data) - 5])); err == nil {
+		t.Fatal("truncated")
+	}
+	bad := append([]byte{}, data...)
+	bad[len(bad) / 2] ^= 1
+	if _, err := ReadSnapshot(bytes.NewReader(bad)); !errors.Is(err, ErrCorrupted) {
+		t.Fatal("corrupted", err)
+	}
+}
+
+// the latest 3 files are kept, a bad one is skipped
+func TestSnapshotDir(t *testing.T){
+	logger.SetDefaultLevel(logger.LevelError)
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "snap-1-1.snap.tmp"), []byte("x"), 0644)
+	d, err := OpenSnapshotDir(dir, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	node := NewNode("n1", "addr1", NewMemDb())
+	node.AddMember("n1", "addr1")
+	node.StepTick(0)
+	for i := 0; i < 5; i ++ {
+		node.Propose(fmt.Sprintf("d%d", i))
+		node.StepTick(0)
+		if _, err := node.SaveSnapshot(d); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files, _ := d.List()
+	names, _ := filepath.Glob(filepath.Join(dir, "*"))
+	if len(files) != 3 || len(names) != 3 || files[2].Index != 7 || files[0].Index != 5 {
+		t.Fatal("files", names)
+	}
+	if filepath.Base(files[2].Path) != "snap-0-7.snap" || files[2].Size == 0 {
+		t.Fatal("name", files[2])
+	}
+
+	os.WriteFile(files[2].Path, []byte("big-ssdb snapshot 1\nstate 3\n"), 0644)
+	sn, info, err := d.Latest()
+	if err != nil || sn == nil || info.Index != 6 || sn.LastIndex() != 6 {
+		t.Fatal("latest", info, err)
+	}
+}
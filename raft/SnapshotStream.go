@@ -0,0 +1,420 @@
+package raft
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fallowu/big-ssdb/internal/util"
+	"github.com/fallowu/big-ssdb/logger"
+)
+
+// Large snapshots are not sent through the raft message path when leader
+// has a SnapshotServer. Leader writes the snapshot to a file of the server,
+// as Snapshot.WriteTo() does, with Service's state written as it is saved,
+// and sends an InstallSnapshot descriptor "stream <addr> <size> <token>" with
+// PrevTerm, PrevIndex of the snapshot's last entry, resent on replication
+// timeout. The member pulls the data into a file and reads it with
+// ReadSnapshot():
+//
+//	GET <member> <lastIndex> <offset> <token>\n
+//	<size>\n<bytes from offset>
+//
+// size is -1 if the snapshot is no longer served, or token, random for each
+// transfer and known only through the raft transport, does not match. With a
+// tls.Config, the server requires members' certificates, and members pull
+// with the one of WithSnapshotPull(). A broken pull is resumed from the bytes
+// received. The member acks with AppendEntryAck when the snapshot is
+// installed, as with chunks. A pull is cancelled when term or leader changes.
+
+const (
+	snapshotStreamPrefix = "stream "
+	// idle time of a pull connection
+	snapshotStreamTimeout = 10 * time.Second
+	snapshotStreamRetries = 10
+	// larger descriptors are ignored
+	MaxSnapshotStreamSize int64 = 1 << 36
+)
+
+// Serves snapshots being sent to members. Thread safe.
+type SnapshotServer struct{
+	addr string
+	dir string
+	conn net.Listener
+	log *logger.Logger
+	mux sync.Mutex
+	// member id => snapshot being sent to it
+	snapshots map[string]*snapshotTransfer
+}
+
+// addr is "host:port" of the listener, ":0" for a random port. Snapshots are
+// written to files in dir, "" for os.TempDir(). With conf, connections are
+// TLS and members must have a certificate conf verifies.
+func NewSnapshotServer(addr string, dir string, conf *tls.Config) (*SnapshotServer, error) {
+	s := &SnapshotServer{
+		dir: dir,
+		log: logger.New("raft.snapshot"),
+		snapshots: make(map[string]*snapshotTransfer),
+	}
+	if dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+		tmps, _ := filepath.Glob(filepath.Join(dir, "stream-*.tmp"))
+		for _, path := range tmps {
+			os.Remove(path)
+		}
+	}
+	conn, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	s.addr = conn.Addr().String()
+	if conf != nil {
+		conf = conf.Clone()
+		conf.ClientAuth = tls.RequireAndVerifyClientCert
+		conn = tls.NewListener(conn, conf)
+	}
+	s.conn = conn
+	go s.serve()
+	return s, nil
+}
+
+// address members connect to
+func (s *SnapshotServer)Addr() string {
+	return s.addr
+}
+
+// files of snapshots being served are removed
+func (s *SnapshotServer)Close(){
+	s.conn.Close()
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	for member, tr := range s.snapshots {
+		removeStreamFile(tr.file)
+		delete(s.snapshots, member)
+	}
+}
+
+// tr replaces the snapshot being sent to member, if any
+func (s *SnapshotServer)put(member string, tr *snapshotTransfer){
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if old := s.snapshots[member]; old != nil && old != tr {
+		removeStreamFile(old.file)
+	}
+	s.snapshots[member] = tr
+}
+
+func (s *SnapshotServer)remove(member string){
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if tr := s.snapshots[member]; tr != nil {
+		removeStreamFile(tr.file)
+		delete(s.snapshots, member)
+	}
+}
+
+func (s *SnapshotServer)get(member string, lastIndex int64) *snapshotTransfer {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	tr := s.snapshots[member]
+	if tr == nil || tr.lastIndex != lastIndex {
+		return nil
+	}
+	return tr
+}
+
+// Writes sn to a new file, Service's state prepared by prepareService() is
+// saved into it. Without node's lock unless savesServiceLocked()
+func (s *SnapshotServer)writeSnapshot(sn *Snapshot) (*os.File, int64, error) {
+	f, err := os.CreateTemp(s.dir, "stream-*.tmp")
+	if err != nil {
+		return nil, 0, err
+	}
+	size, err := sn.writeService(f)
+	if err != nil {
+		removeStreamFile(f)
+		return nil, 0, err
+	}
+	return f, size, nil
+}
+
+func removeStreamFile(f *os.File){
+	if f != nil {
+		f.Close()
+		os.Remove(f.Name())
+	}
+}
+
+func (s *SnapshotServer)serve(){
+	for {
+		conn, err := s.conn.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *SnapshotServer)handle(conn net.Conn){
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(snapshotStreamTimeout))
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return
+	}
+	var member, token string
+	var lastIndex, offset int64
+	if _, err := fmt.Sscanf(line, "GET %s %d %d %s\n", &member, &lastIndex, &offset, &token); err != nil {
+		s.log.Warn("bad snapshot request", "addr", conn.RemoteAddr())
+		return
+	}
+	tr := s.get(member, lastIndex)
+	if tr != nil && subtle.ConstantTimeCompare([]byte(tr.token), []byte(token)) != 1 {
+		s.log.Warn("bad snapshot token", "peer", member, "addr", conn.RemoteAddr())
+		tr = nil
+	}
+	if tr == nil || offset < 0 || offset > tr.size {
+		io.WriteString(conn, "-1\n")
+		return
+	}
+	s.log.Info("send snapshot", "peer", member, "index", lastIndex, "offset", offset, "size", tr.size)
+	io.WriteString(conn, strconv.FormatInt(tr.size, 10) + "\n")
+	// no deadline for the data, but for each write. The file is closed if
+	// the snapshot is no longer served, which breaks the pull
+	buf := make([]byte, DefaultSnapshotChunkSize)
+	for offset < tr.size {
+		n, err := tr.file.ReadAt(buf[:util.MinInt64(int64(len(buf)), tr.size - offset)], offset)
+		if n == 0 {
+			s.log.Info("read snapshot error", "peer", member, "err", err)
+			return
+		}
+		conn.SetWriteDeadline(time.Now().Add(snapshotStreamTimeout))
+		if _, err := conn.Write(buf[:n]); err != nil {
+			s.log.Info("send snapshot error", "peer", member, "err", err)
+			return
+		}
+		offset += int64(n)
+	}
+}
+
+// Large snapshots are pulled by members from s, s should be closed by the
+// caller after the node.
+func (node *Node)SetSnapshotServer(s *SnapshotServer){
+	node.mux.Lock()
+	defer node.mux.Unlock()
+	node.snapshotServer = s
+}
+
+// Members write snapshots pulled to files in dir, "" for os.TempDir(), and
+// pull over TLS with conf if not nil
+func WithSnapshotPull(dir string, conf *tls.Config) Option {
+	return func(opts *options) {
+		opts.pullDir = dir
+		opts.pullTLS = conf
+	}
+}
+
+// with node's lock held, the file of m's snapshot being sent is removed
+func (node *Node)dropSnapshotTransfer(m *Member){
+	if m.snapshot != nil && m.snapshot.file != nil {
+		node.snapshotServer.remove(m.Id)
+	}
+}
+
+// with node's lock held
+func (node *Node)sendSnapshotDescriptor(m *Member){
+	tr := m.snapshot
+	m.ReplicateTimer = 0
+	m.HeartbeatTimer = 0
+	msg := NewInstallSnapshotMsg(m.Id, snapshotStreamPrefix + node.snapshotServer.Addr() + " " + strconv.FormatInt(tr.size, 10) + " " + tr.token)
+	msg.PrevTerm = tr.lastTerm
+	msg.PrevIndex = tr.lastIndex
+	node.send(msg)
+}
+
+// A snapshot being pulled from leader
+type snapshotPull struct{
+	// node's term when the pull started
+	term int32
+	leader string
+	lastTerm int32
+	lastIndex int64
+	token string
+	cancel chan struct{}
+}
+
+func newSnapshotToken() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// with node's lock held
+func (node *Node)cancelSnapshotPull(reason string){
+	p := node.snapshotPull
+	if p == nil {
+		return
+	}
+	node.log.Info("cancel snapshot pull", "peer", p.leader, "index", p.lastIndex, "reason", reason)
+	close(p.cancel)
+	node.snapshotPull = nil
+}
+
+// with node's lock held, on a descriptor message
+func (node *Node)pullSnapshot(msg *Message){
+	ps := strings.Split(strings.TrimPrefix(msg.Data, snapshotStreamPrefix), " ")
+	if len(ps) != 3 {
+		node.log.Warn("bad snapshot descriptor", "peer", msg.Src)
+		return
+	}
+	size, err := strconv.ParseInt(ps[1], 10, 64)
+	if err != nil || size <= 0 || size > MaxSnapshotStreamSize {
+		node.log.Warn("bad snapshot size", "peer", msg.Src, "size", ps[1])
+		return
+	}
+	p := node.snapshotPull
+	if p != nil && p.leader == msg.Src && p.lastTerm == msg.PrevTerm && p.lastIndex == msg.PrevIndex {
+		// being pulled
+		return
+	}
+	node.cancelSnapshotPull("new snapshot")
+	p = &snapshotPull{
+		term: node.Term,
+		leader: msg.Src,
+		lastTerm: msg.PrevTerm,
+		lastIndex: msg.PrevIndex,
+		token: ps[2],
+		cancel: make(chan struct{}),
+	}
+	node.snapshotPull = p
+	node.log.Info("pull snapshot", "peer", msg.Src, "addr", ps[0], "index", p.lastIndex, "size", ps[1])
+	node.goroutines.Add(1)
+	stop := node.stopChan()
+	go node.runSnapshotPull(p, ps[0], size, stop)
+}
+
+func (node *Node)runSnapshotPull(p *snapshotPull, addr string, size int64, stop chan struct{}){
+	defer node.goroutines.Done()
+	if node.pullDir != "" {
+		os.MkdirAll(node.pullDir, 0755)
+	}
+	f, err := os.CreateTemp(node.pullDir, "stream-*.tmp")
+	if err != nil {
+		node.log.Error("pull snapshot", "peer", p.leader, "err", err)
+		node.finishSnapshotPull(p, nil)
+		return
+	}
+	defer removeStreamFile(f)
+	var received int64
+	for retry := 0; received < size; retry ++ {
+		select {
+		case <-stop:
+			return
+		case <-p.cancel:
+			return
+		default:
+		}
+		if retry >= snapshotStreamRetries {
+			node.log.Warn("pull snapshot failed", "peer", p.leader, "received", received, "size", size)
+			node.finishSnapshotPull(p, nil)
+			return
+		}
+		if retry > 0 {
+			node.clock.Sleep(time.Second)
+		}
+		var err error
+		received, err = node.fetchSnapshot(p, addr, size, f, received)
+		if err == io.EOF {
+			// not served
+			node.finishSnapshotPull(p, nil)
+			return
+		}
+		if err != nil {
+			node.log.Info("pull snapshot error", "peer", p.leader, "received", received, "err", err)
+		}
+	}
+	var sn *Snapshot
+	if _, err = f.Seek(0, io.SeekStart); err == nil {
+		sn, err = ReadSnapshot(f)
+	}
+	if err != nil {
+		node.log.Error("ReadSnapshot() error!", "peer", p.leader, "err", err)
+	}
+	node.finishSnapshotPull(p, sn)
+}
+
+// Appends bytes received after the first received ones to f, returns
+// received with them; io.EOF if leader does not serve the snapshot
+func (node *Node)fetchSnapshot(p *snapshotPull, addr string, size int64, f *os.File, received int64) (int64, error) {
+	conn, err := dialTLS(&net.Dialer{Timeout: snapshotStreamTimeout}, addr, node.pullTLS)
+	if err != nil {
+		return received, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(snapshotStreamTimeout))
+	if _, err := fmt.Fprintf(conn, "GET %s %d %d %s\n", node.Id, p.lastIndex, received, p.token); err != nil {
+		return received, err
+	}
+	r := bufio.NewReader(conn)
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return received, err
+	}
+	if n, _ := strconv.ParseInt(strings.TrimSpace(line), 10, 64); n != size {
+		return received, io.EOF
+	}
+	buf := make([]byte, DefaultSnapshotChunkSize)
+	for received < size {
+		conn.SetDeadline(time.Now().Add(snapshotStreamTimeout))
+		c, err := r.Read(buf[:util.MinInt64(int64(len(buf)), size - received)])
+		if c > 0 {
+			w, werr := f.Write(buf[:c])
+			received += int64(w)
+			if werr != nil {
+				return received, werr
+			}
+		}
+		if err != nil {
+			return received, err
+		}
+	}
+	return received, nil
+}
+
+// installs sn if p is still being pulled, in the same term and from the
+// same leader, nil on failure, the next descriptor starts again
+func (node *Node)finishSnapshotPull(p *snapshotPull, sn *Snapshot){
+	node.mux.Lock()
+	defer node.unlock()
+	if node.snapshotPull != p {
+		return
+	}
+	node.snapshotPull = nil
+	if sn == nil {
+		return
+	}
+	// p.leader sent the descriptor in p.term, the leader of it
+	leader := node.leaderId()
+	if node.Term != p.term || (leader != p.leader && leader != "") || sn.LastTerm() != p.lastTerm ||
+		sn.LastIndex() != p.lastIndex || sn.LastIndex() <= node.store.CommitIndex {
+		node.log.Info("drop pulled snapshot", "peer", p.leader, "index", sn.LastIndex(), "term", node.Term,
+			"pullTerm", p.term, "leader", leader, "commitIndex", node.store.CommitIndex)
+		return
+	}
+	node.installLeaderSnapshot(p.leader, sn)
+}
@@ -0,0 +1,250 @@
+package raft
+
+import (
+	"crypto/tls"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fallowu/big-ssdb/logger"
+)
+
+func TestSnapshotStream(t *testing.T){
+	testSnapshotStream(t, nil, nil)
+}
+
+// members pull with certificates of the CA
+func TestSnapshotStreamTLS(t *testing.T){
+	ca := newTestCA(t)
+	testSnapshotStream(t, ca.config(t, "n1"), ca.config(t, "n2"))
+}
+
+func testSnapshotStream(t *testing.T, srvTLS *tls.Config, pullTLS *tls.Config){
+	logger.SetDefaultLevel(logger.LevelError)
+	var mux sync.Mutex
+	var queue []*Message
+	outbox := func(msg *Message) {
+		cp := *msg
+		mux.Lock()
+		queue = append(queue, &cp)
+		mux.Unlock()
+	}
+	dir := t.TempDir()
+	srv, err := NewSnapshotServer("127.0.0.1:0", dir + "/n1", srvTLS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	policy := DefaultSnapshotPolicy()
+	policy.ChunkSize = 16
+	n1 := NewNode("n1", "addr1", NewMemDb(), WithSnapshotPolicy(policy))
+	n2 := NewNode("n2", "addr2", NewMemDb(), WithSnapshotPull(dir + "/n2", pullTLS))
+	defer n2.Stop()
+	n1.SetSnapshotServer(srv)
+	// Service's state is written to the file as it is saved
+	svc2 := new(snapshotService)
+	n1.SetService(new(snapshotService))
+	n2.SetService(svc2)
+	nodes := map[string]*Node{"n1": n1, "n2": n2}
+	descriptors := 0
+	pump := func() {
+		for {
+			mux.Lock()
+			if len(queue) == 0 {
+				mux.Unlock()
+				return
+			}
+			msg := queue[0]
+			queue = queue[1:]
+			mux.Unlock()
+			if msg.Type == MessageTypeInstallSnapshot {
+				descriptors ++
+			}
+			nodes[msg.Dst].StepMessage(msg)
+		}
+	}
+	for _, n := range nodes {
+		n.SetOutbox(outbox)
+	}
+	n1.AddMember("n1", "addr1")
+	n1.StepTick(0)
+	for i := 0; i < 5; i ++ {
+		n1.Propose("data")
+	}
+	n1.StepTick(0)
+	n1.AddMember("n2", "addr2")
+	n2.JoinGroup("n1", "addr1")
+	for i := 0; i < 100 && n2.Metrics().SnapshotsInstalled == 0; i ++ {
+		n1.StepTick(100)
+		n2.StepTick(100)
+		pump()
+		time.Sleep(10 * time.Millisecond)
+	}
+	for i := 0; i < 20; i ++ {
+		n1.StepTick(100)
+		n2.StepTick(100)
+		pump()
+	}
+
+	if n2.Metrics().SnapshotsInstalled != 1 || svc2.restored != 1 || svc2.count != 5 {
+		t.Fatal("installed", n2.Metrics().SnapshotsInstalled, "restored", svc2.restored, "count", svc2.count)
+	}
+	// the data is not sent in raft messages
+	size := len(n1.CreateSnapshot().Encode())
+	if descriptors == 0 || descriptors >= size / 16 {
+		t.Fatal("descriptors", descriptors, "size", size)
+	}
+	if m := n1.Metrics(); m.CommitIndex != n2.Metrics().LastIndex || m.Members[0].InstallingSnapshot {
+		t.Fatal("commit", m.CommitIndex, "follower", n2.Metrics().LastIndex, m.Members)
+	}
+	if len(srv.snapshots) != 0 {
+		t.Fatal("snapshot still served")
+	}
+	// files are removed once installed
+	for _, d := range []string{"/n1", "/n2"} {
+		if files, _ := os.ReadDir(dir + d); len(files) != 0 {
+			t.Fatal("files left", d, len(files))
+		}
+	}
+}
+
+// a snapshot served from a file of srv
+func putTestSnapshot(t *testing.T, srv *SnapshotServer, member string, data string) *snapshotTransfer {
+	f, err := os.CreateTemp(t.TempDir(), "stream-*.tmp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(data)
+	tr := &snapshotTransfer{lastTerm: 1, lastIndex: 5, file: f, size: int64(len(data)), token: "abc"}
+	srv.put(member, tr)
+	return tr
+}
+
+// pulled by the member only, with the token of the descriptor
+func TestSnapshotStreamToken(t *testing.T){
+	logger.SetDefaultLevel(logger.LevelError)
+	srv, err := NewSnapshotServer("127.0.0.1:0", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	putTestSnapshot(t, srv, "n2", "snapshot data")
+	get := func(line string) string {
+		conn, err := net.Dial("tcp", srv.Addr())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		io.WriteString(conn, line)
+		b, _ := io.ReadAll(conn)
+		return string(b)
+	}
+	if got := get("GET n2 5 0 abc\n"); got != "13\nsnapshot data" {
+		t.Fatal("with token", got)
+	}
+	for _, line := range []string{"GET n2 5 0 abd\n", "GET n2 5 0\n", "GET n3 5 0 abc\n"} {
+		if got := get(line); strings.HasPrefix(got, "13") {
+			t.Fatal(line, got)
+		}
+	}
+	// the file of a snapshot no longer served is removed
+	tr := putTestSnapshot(t, srv, "n2", "other snapshot")
+	srv.remove("n2")
+	if _, err := os.Stat(tr.file.Name()); !os.IsNotExist(err) {
+		t.Fatal("file not removed", err)
+	}
+}
+
+// not served to members without a certificate of the CA
+func TestSnapshotStreamTLSRequired(t *testing.T){
+	logger.SetDefaultLevel(logger.LevelError)
+	ca := newTestCA(t)
+	srv, err := NewSnapshotServer("127.0.0.1:0", "", ca.config(t, "n1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	putTestSnapshot(t, srv, "n2", "snapshot data")
+	get := func(conf *tls.Config) string {
+		conn, err := dialTLS(&net.Dialer{Timeout: time.Second}, srv.Addr(), conf)
+		if err != nil {
+			return ""
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(time.Second))
+		io.WriteString(conn, "GET n2 5 0 abc\n")
+		b, _ := io.ReadAll(conn)
+		return string(b)
+	}
+	if got := get(ca.config(t, "n2")); got != "13\nsnapshot data" {
+		t.Fatal("with certificate", got)
+	}
+	noCert := ca.config(t, "n2")
+	noCert.Certificates = nil
+	other := newTestCA(t).config(t, "n2")
+	other.RootCAs = ca.pool
+	for name, conf := range map[string]*tls.Config{"plain": nil, "no certificate": noCert, "other CA": other} {
+		if got := get(conf); strings.Contains(got, "snapshot data") {
+			t.Fatal(name, got)
+		}
+	}
+}
+
+// a pull finished after term or leader changed is not installed
+func TestSnapshotStreamCancel(t *testing.T){
+	logger.SetDefaultLevel(logger.LevelError)
+	n1 := NewNode("n1", "addr1", NewMemDb())
+	n1.SetOutbox(func(msg *Message) {})
+	n1.AddMember("n1", "addr1")
+	n1.StepTick(0)
+	n1.Propose("data")
+	n1.StepTick(0)
+	sn := n1.CreateSnapshot()
+
+	n2 := NewNode("n2", "addr2", NewMemDb())
+	n2.SetOutbox(func(msg *Message) {})
+	n2.JoinGroup("n1", "addr1")
+	// not served, so that it is finished only by the test
+	descriptor := func(term int32) *snapshotPull {
+		msg := NewInstallSnapshotMsg("n2", snapshotStreamPrefix + "127.0.0.1:1 100 abc")
+		msg.Src = "n1"
+		msg.Term = term
+		msg.PrevTerm = sn.LastTerm()
+		msg.PrevIndex = sn.LastIndex()
+		n2.StepMessage(msg)
+		n2.mux.Lock()
+		defer n2.mux.Unlock()
+		return n2.snapshotPull
+	}
+	p := descriptor(1)
+	if p == nil {
+		t.Fatal("not pulled")
+	}
+	// a greater term cancels it
+	vote := NewRequestVoteMsg()
+	vote.Src = "n1"
+	vote.Dst = "n2"
+	vote.Term = 3
+	n2.StepMessage(vote)
+	select {
+	case <-p.cancel:
+	default:
+		t.Fatal("not cancelled")
+	}
+	n2.finishSnapshotPull(p, sn)
+	if m := n2.Metrics(); m.SnapshotsInstalled != 0 || m.Term != 3 || n2.Status().VoteFor != "n1" {
+		t.Fatalf("installed %+v", m)
+	}
+
+	// installed in the same term
+	p = descriptor(3)
+	n2.finishSnapshotPull(p, sn)
+	if m := n2.Metrics(); m.SnapshotsInstalled != 1 || m.Term != 3 || m.LastIndex != sn.LastIndex() || n2.Status().VoteFor != "n1" {
+		t.Fatalf("not installed %+v", m)
+	}
+	n2.Stop()
+}
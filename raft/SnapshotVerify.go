@@ -0,0 +1,76 @@
+package raft
+
+import (
+	"fmt"
+	"sort"
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// Hash of what installing the snapshot restores: term, members and entries.
+// VoteFor is not restored, so not hashed.
+func (sn *Snapshot)Hash() string {
+	h := sha256.New()
+	st := sn.State()
+	fmt.Fprintf(h, "term %d\n", st.Term)
+	ids := make([]string, 0, len(st.Members))
+	for id, _ := range st.Members {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		fmt.Fprintf(h, "member %s %s\n", id, st.Members[id])
+	}
+	for _, id := range ids {
+		if p := st.Priorities[id]; p != 0 {
+			fmt.Fprintf(h, "priority %s %d\n", id, p)
+		}
+	}
+	for _, ent := range sn.Entries() {
+		fmt.Fprintf(h, "entry %s\n", ent.Encode())
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Encode the snapshot, decode and install it into a fresh Storage as a
+// follower would, then snapshot the fresh Storage and compare hashes.
+// Service state is restored by the Service, so it is not verified.
+// Returns the hash of the snapshot.
+func VerifySnapshot(nodeId string, nodeAddr string, sn *Snapshot) (string, error) {
+	hash := sn.Hash()
+	data := sn.Encode()
+	decoded := NewSnapshotFromString(data)
+	if decoded == nil {
+		return "", fmt.Errorf("decode snapshot failed")
+	}
+	if h := decoded.Hash(); h != hash {
+		return "", fmt.Errorf("hash changed by encode/decode: %s => %s", hash, h)
+	}
+
+	// same id, so that members of the fresh node are the same
+	fresh := NewNode(nodeId, nodeAddr, NewMemDb())
+	if !fresh.InstallSnapshot(decoded) {
+		return "", fmt.Errorf("install snapshot failed")
+	}
+	st := fresh.store
+	if st.LastIndex != sn.LastIndex() || st.LastTerm != sn.LastTerm() || st.CommitIndex != sn.LastIndex() {
+		return "", fmt.Errorf("installed lastIndex=%d lastTerm=%d commitIndex=%d, snapshot lastIndex=%d lastTerm=%d",
+				st.LastIndex, st.LastTerm, st.CommitIndex, sn.LastIndex(), sn.LastTerm())
+	}
+	if h := fresh.CreateSnapshot().Hash(); h != hash {
+		return "", fmt.Errorf("hash of installed state differs: %s => %s", hash, h)
+	}
+
+	// and survives restart
+	restarted := NewNode(nodeId, nodeAddr, fresh.store.db)
+	if h := restarted.CreateSnapshot().Hash(); h != hash {
+		return "", fmt.Errorf("hash of installed state differs after restart: %s => %s", hash, h)
+	}
+	return hash, nil
+}
+
+// Self-check of the snapshot subsystem on this node's current state
+func (node *Node)VerifySnapshot() (string, error) {
+	sn := node.CreateSnapshot()
+	return VerifySnapshot(node.Id, node.Addr, sn)
+}
@@ -0,0 +1,41 @@
+package raft
+
+import (
+	"testing"
+
+	"github.com/fallowu/big-ssdb/logger"
+)
+
+func TestVerifySnapshot(t *testing.T){
+	logger.SetDefaultLevel(logger.LevelError)
+	node := NewNode("n1", "addr1", NewMemDb())
+	node.AddMember("n1", "addr1")
+	for i := 0; i < 5; i ++ {
+		node.Propose("data")
+	}
+	node.StepTick(0)
+	if node.store.CommitIndex < 6 {
+		t.Fatal("not committed", node.store.CommitIndex)
+	}
+
+	hash, err := node.VerifySnapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hash != node.CreateSnapshot().Hash() {
+		t.Fatal("hash mismatch")
+	}
+
+	// a node always adds itself to members on install
+	sn := node.CreateSnapshot()
+	delete(sn.State().Members, "n1")
+	if _, err := VerifySnapshot("n1", "addr1", sn); err == nil {
+		t.Fatal("expected error")
+	}
+	// not decodable
+	sn = node.CreateSnapshot()
+	sn.entries[0].Type = ""
+	if _, err := VerifySnapshot("n1", "addr1", sn); err == nil {
+		t.Fatal("expected error")
+	}
+}
@@ -0,0 +1,59 @@
+package raft
+
+import (
+	"encoding/json"
+)
+
+type State struct{
+	Term int32
+	VoteFor string
+	Members map[string]string
+	// id => election priority, members of priority 0 are absent, see Priority.go
+	Priorities map[string]int `json:",omitempty"`
+}
+
+func NewState() *State {
+	s := new(State)
+	s.Members = make(map[string]string)
+	return s
+}
+
+func (s *State)CopyFrom(f *State) {
+	s.Term = f.Term
+	s.VoteFor = f.VoteFor
+	s.Members = make(map[string]string)
+	for k,v := range f.Members {
+		s.Members[k] = v
+	}
+	s.Priorities = nil
+	for k, v := range f.Priorities {
+		if s.Priorities == nil {
+			s.Priorities = make(map[string]int)
+		}
+		s.Priorities[k] = v
+	}
+}
+
+func (s *State)Encode() string{
+	b, err := json.Marshal(s)
+	if err != nil {
+		return ""
+	}
+	return string(b)
+}
+
+func (s *State)Decode(buf string) bool{
+	// decode to a new one, or fields absent in buf would be kept
+	t := NewState()
+	if err := json.Unmarshal([]byte(buf), t); err != nil {
+		return false
+	}
+	if t.Term < 0 {
+		return false
+	}
+	if t.Members == nil {
+		t.Members = make(map[string]string)
+	}
+	*s = *t
+	return true
+}
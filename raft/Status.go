@@ -0,0 +1,149 @@
+package raft
+
+// Raft's volatile state and member bookkeeping as of the last release of
+// node.mux, published for readers(Info, admin pages, Service checking
+// leadership...), so that they never wait behind message handling, ticks
+// or fsyncs. Read only, a new one is published when any field changes, the
+// Members map only when a member is added or removed, or changes its address,
+// role or priority. Replication progress is in Metrics(), which takes the
+// lock.
+//
+// Lock order: node.mux, then statusMux. statusMux is never held while
+// calling into anything else.
+type Status struct{
+	StatusInfo
+	// this node excluded, with Id, Addr, Role and Priority only
+	Members map[string]Member
+}
+
+// Fields of Status but Members, comparable
+type StatusInfo struct{
+	Id string
+	Addr string
+	Role RoleType
+	Term int32
+	VoteFor string
+	Priority int
+	// see Witness.go
+	Witness bool
+	// "" if unknown
+	Leader string
+	LeaderAddr string
+	LastApplied int64
+	CommitIndex int64
+	LastTerm int32
+	LastIndex int64
+	ElectionTimer int
+	FsyncPolicy FsyncPolicy
+	// of startup, see Recovery.go
+	Recovery RecoveryReport
+}
+
+// Safe to be called without lock, the returned value must not be modified
+func (node *Node)Status() *Status {
+	node.statusMux.RLock()
+	defer node.statusMux.RUnlock()
+	return node.status
+}
+
+// release node.mux after state may have been changed, proposals accepted
+// meanwhile are appended, see Proposals.go
+func (node *Node)unlock(){
+	node.appendProposals()
+	node.publishStatus()
+	node.mux.Unlock()
+}
+
+// with node.mux held, node.status is only read by its writer under node.mux
+func (node *Node)publishStatus(){
+	st := node.store
+	info := StatusInfo{
+		Id: node.Id,
+		Addr: node.Addr,
+		Role: node.Role,
+		Term: node.Term,
+		VoteFor: node.VoteFor,
+		Priority: node.Priority,
+		Witness: node.witness,
+		Leader: node.leaderId(),
+		LastApplied: node.lastApplied,
+		CommitIndex: st.CommitIndex,
+		LastTerm: st.LastTerm,
+		LastIndex: st.LastIndex,
+		ElectionTimer: node.electionTimer,
+		FsyncPolicy: st.fsyncPolicy,
+		Recovery: st.recovery,
+	}
+	if info.Leader == node.Id {
+		info.LeaderAddr = node.Addr
+	} else if m := node.Members[info.Leader]; m != nil {
+		info.LeaderAddr = m.Addr
+	}
+	old := node.status
+	var members map[string]Member
+	if old != nil && node.sameMembers(old.Members) {
+		if old.StatusInfo == info {
+			return
+		}
+		members = old.Members
+	} else {
+		members = make(map[string]Member, len(node.Members))
+		for id, m := range node.Members {
+			members[id] = memberStatus(m)
+		}
+	}
+	s := &Status{StatusInfo: info, Members: members}
+	node.statusMux.Lock()
+	node.status = s
+	node.statusMux.Unlock()
+}
+
+func memberStatus(m *Member) Member {
+	return Member{Id: m.Id, Addr: m.Addr, Role: m.Role, Priority: m.Priority}
+}
+
+// with node.mux held, false if members differ in what Status has of them
+func (node *Node)sameMembers(members map[string]Member) bool {
+	if len(members) != len(node.Members) {
+		return false
+	}
+	for id, m := range node.Members {
+		if old, ok := members[id]; !ok || old != memberStatus(m) {
+			return false
+		}
+	}
+	return true
+}
+
+/* ############################################# */
+
+// Accessors below read the published Status, they are consistent with each
+// other only within one Status(), call it once when several are needed.
+
+func (node *Node)IsLeader() bool {
+	return node.Status().Role == RoleLeader
+}
+
+// "" if unknown
+func (node *Node)LeaderId() string {
+	return node.Status().Leader
+}
+
+// raft addr of the leader, "" if unknown
+func (node *Node)LeaderAddr() string {
+	return node.Status().LeaderAddr
+}
+
+// id => raft addr of every member, this node included
+func (node *Node)MemberAddrs() map[string]string {
+	return node.Status().MemberAddrs()
+}
+
+func (s *Status)MemberAddrs() map[string]string {
+	ret := make(map[string]string, len(s.Members) + 1)
+	ret[s.Id] = s.Addr
+	for id, m := range s.Members {
+		ret[id] = m.Addr
+	}
+	return ret
+}
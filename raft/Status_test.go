@@ -0,0 +1,36 @@
+package raft
+
+import (
+	"testing"
+
+	"github.com/fallowu/big-ssdb/logger"
+)
+
+func TestStatus(t *testing.T){
+	logger.SetDefaultLevel(logger.LevelError)
+	n1 := NewNode("n1", "addr1", NewMemDb())
+	n1.SetOutbox(func(msg *Message) {})
+	if s := n1.Status(); s.Role != RoleFollower || s.Leader != "" {
+		t.Fatal("initial", s.Role, s.Leader)
+	}
+	n1.AddMember("n1", "addr1")
+	n1.StepTick(0)
+	n1.AddMember("n2", "addr2")
+
+	// readers do not wait for node's lock
+	n1.mux.Lock()
+	s := n1.Status()
+	n1.mux.Unlock()
+	if s.Role != RoleLeader || s.Leader != "n1" || s.LeaderAddr != "addr1" {
+		t.Fatal("role", s.Role, "leader", s.Leader, s.LeaderAddr)
+	}
+	// AddMember n2 is proposed, not yet committed
+	if s.LastIndex != s.CommitIndex + 1 || s.LastIndex != n1.Metrics().LastIndex {
+		t.Fatal("lastIndex", s.LastIndex, "commitIndex", s.CommitIndex)
+	}
+	// n2 is a member once AddMember is applied
+	addrs := n1.MemberAddrs()
+	if !n1.IsLeader() || n1.LeaderAddr() != "addr1" || len(addrs) != 1 || addrs["n1"] != "addr1" {
+		t.Fatal("accessors", n1.IsLeader(), n1.LeaderAddr(), addrs)
+	}
+}
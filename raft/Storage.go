@@ -0,0 +1,619 @@
+package raft
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/fallowu/big-ssdb/internal/util"
+	"github.com/fallowu/big-ssdb/metrics"
+	"github.com/fallowu/big-ssdb/logger"
+)
+
+const(
+	// Service.ApplyEntry slower than this is logged and counted
+	DefaultSlowApplyThreshold = 100 * time.Millisecond
+	// warn when commitIndex - index applied by Service exceeds this
+	DefaultApplyBacklogLimit = 1000
+)
+
+type Storage struct{
+	// Discovered from log entries
+	FirstIndex int64
+	LastTerm int32
+	LastIndex int64
+	// All committed entries are immediately applied to Raft it self,
+	// but may asynchronously be applied to Service
+	CommitIndex int64
+	state *State
+
+	node *Node
+	// notify Raft there is new entry to be replicated
+	C chan int
+
+	// entries may not be continuous(for follower), see EntryCache.go
+	entries *entryCache
+	Service Service
+	
+	db Db
+	// nil if entries are in db, see LogDb.go
+	ldb LogDb
+
+	// bytes of encoded entries written to db
+	logBytes int64
+	// see GroupCommit.go
+	dirty bool
+	durableIndex int64
+	// see FsyncPolicy.go
+	fsyncPolicy FsyncPolicy
+	// see LogChecksum.go
+	checksumFrom int64
+	unverified int64
+	lastFsync time.Time
+	// of startup
+	recovery RecoveryReport
+	// @LastApplied in db, see LastApplied.go
+	appliedSaved int64
+	fsyncLatency *metrics.Histogram
+
+	// see Options.go
+	snapshot SnapshotPolicy
+
+	SlowApplyThreshold time.Duration
+	ApplyBacklogLimit int64
+	applyLatency *metrics.Histogram
+	slowApplies int64
+	backlogWarned bool
+
+	log *logger.Logger
+	// see Fault.go, the node is halted if not nil
+	fault error
+}
+
+// Only WithSnapshotPolicy(), WithLogDb(), WithEntryCacheSize() and
+// WithFsyncPolicy() of opts apply to Storage
+func NewStorage(node *Node, db Db, opts ...Option) *Storage {
+	o := defaultOptions(node.Id)
+	for _, opt := range opts {
+		opt(o)
+	}
+	st := new(Storage)
+	st.snapshot = o.snapshot
+	st.fsyncPolicy = o.fsyncPolicy
+	st.state = NewState()
+	st.entries = newEntryCache(o.entryCacheSize)
+	
+	st.db = db
+	st.ldb = o.logDb
+	st.node = node
+	st.log = node.log.Sub("storage")
+	st.C = make(chan int, DefaultNotifyQueueSize)
+	st.fsyncLatency = metrics.NewLatencyHistogram()
+	st.applyLatency = metrics.NewLatencyHistogram()
+	st.SlowApplyThreshold = DefaultSlowApplyThreshold
+	st.ApplyBacklogLimit = DefaultApplyBacklogLimit
+
+	st.FirstIndex = math.MaxInt64
+
+	st.loadState()
+	st.loadChecksumFrom()
+	st.loadEntries()
+	st.saveChecksumFrom()
+	st.reportRecovery()
+	st.durableIndex = st.LastIndex
+
+	return st
+}
+
+func (st *Storage)Close(){
+	st.SaveState()
+	if st.db != nil {
+		st.db.Close()
+	}
+	if st.ldb != nil {
+		st.ldb.Close()
+	}
+}
+
+/* #################### State ###################### */
+
+func (st *Storage)State() *State{
+	return st.state
+}
+
+func (st *Storage)loadState() {
+	data := st.db.Get("@State")
+	st.state.Decode(data)
+	if st.state.Members == nil {
+		st.state.Members = make(map[string]string)
+	}
+}
+
+func (st *Storage)SaveState(){
+	st.state.Term = st.node.Term
+	st.state.VoteFor = st.node.VoteFor
+	st.state.Members = make(map[string]string)
+	
+	st.state.Members[st.node.Id] = st.node.Addr
+	for _, m := range st.node.Members {
+		st.state.Members[m.Id] = m.Addr
+	}
+	st.state.Priorities = st.node.priorities()
+	
+	st.log.Info("save raft state", "state", st.state.Encode())
+
+	st.db.Set("@State", st.state.Encode())
+	st.Fsync()
+}
+
+/* #################### Entry ###################### */
+
+// On startup, only uncommitted entries are read, committed ones are loaded
+// by GetEntry() when needed. Data written by old versions has no @LogMeta,
+// and is scanned once.
+func (st *Storage)loadEntries(){
+	if st.ldb != nil {
+		st.loadLogDb()
+		return
+	}
+	if st.loadLogMeta() {
+		st.recovery.Source = "log meta"
+		st.loadTail()
+	} else {
+		st.recovery.Source = "scan"
+		st.scanEntries()
+	}
+	st.saveLogMeta()
+}
+
+func (st *Storage)scanEntries(){
+	// persisted entries beyond it may be uncommitted, and must not be applied.
+	// Absent in data written by old versions.
+	savedCommit := int64(-1)
+	if v := st.db.Get("@CommitIndex"); v != "" {
+		savedCommit = util.Atoi64(v)
+	}
+	st.recovery.CommitIndex = savedCommit
+
+	// index of the first entry torn by a crash, entries from it are discarded
+	torn := int64(math.MaxInt64)
+	entries := make(map[int64]*Entry)
+	sizes := make(map[int64]int)
+	discard := NewBatch()
+	st.db.Iterate("log#", PrefixEnd("log#"), func(k string, v string) bool {
+		if v == "" {
+			return true
+		}
+		ent := new(Entry)
+		idx, ok := parseIndex(strings.TrimPrefix(k, "log#"))
+		err := st.decodeRecord(ent, idx, v)
+		// a duplicate index is written under the key of another entry
+		if err != nil || (ok && ent.Index != idx) {
+			reason := tornReason(idx, v, ent, err)
+			if !ok || savedCommit < 0 || idx <= savedCommit {
+				st.fail(fmt.Errorf("bad entry %s: %s", k, reason))
+				return false
+			}
+			st.log.Warn("discard torn uncommitted entry", "key", k, "commitIndex", savedCommit, "reason", reason)
+			st.recordTorn(idx, reason)
+			torn = util.MinInt64(torn, idx)
+			discard.Delete(k)
+			st.recovery.Discarded ++
+			return true
+		}
+		st.recovery.Checked ++
+		entries[ent.Index] = ent
+		sizes[ent.Index] = len(v)
+		return true
+	})
+	// entries in db are continuous, a hole after commitIndex is a lost write
+	hole := int64(math.MaxInt64)
+	if savedCommit >= 0 {
+		hole = savedCommit + 1
+		for entries[hole] != nil {
+			hole ++
+		}
+		torn = util.MinInt64(torn, hole)
+	}
+	for idx, _ := range entries {
+		if idx >= torn {
+			if torn == hole {
+				st.recordTorn(hole, "missing")
+			}
+			st.log.Warn("discard entry after torn entry", "index", idx, "torn", torn)
+			delete(entries, idx)
+			discard.Delete(logKey(idx))
+			st.recovery.Discarded ++
+		}
+	}
+	if discard.Len() > 0 {
+		st.db.WriteBatch(discard)
+		st.Fsync()
+	}
+
+	for _, ent := range entries {
+		st.entries.put(ent, false)
+		st.logBytes += int64(sizes[ent.Index])
+		st.CommitIndex = util.MaxInt64(st.LastIndex, ent.Index)
+		st.FirstIndex  = util.MinInt64(st.FirstIndex, ent.Index)
+		st.LastTerm    = util.MaxInt32(st.LastTerm, ent.Term)
+		st.LastIndex   = util.MaxInt64(st.LastIndex, ent.Index)
+	}
+	if savedCommit >= 0 {
+		st.CommitIndex = util.MinInt64(savedCommit, st.LastIndex)
+	}
+}
+
+func (st *Storage)GetEntry(index int64) *Entry{
+	if ent := st.entries.get(index); ent != nil {
+		return ent
+	}
+	return st.loadEntry(index)
+}
+
+// Entries in [lo, hi], up to the first one absent, nil if lo is before
+// FirstIndex. Runs of entries not in memory are read by one range scan of db
+// each.
+func (st *Storage)GetEntries(lo int64, hi int64) []*Entry {
+	if lo < st.FirstIndex {
+		return nil
+	}
+	hi = util.MinInt64(hi, st.LastIndex)
+	var ret []*Entry
+	for idx := lo; idx <= hi; {
+		if ent := st.entries.get(idx); ent != nil {
+			ret = append(ret, ent)
+			idx ++
+			continue
+		}
+		end := idx
+		for end < hi && st.entries.items[end + 1] == nil {
+			end ++
+		}
+		ents := st.scanLog(idx, end)
+		ret = append(ret, ents...)
+		if int64(len(ents)) != end - idx + 1 {
+			break
+		}
+		idx = end + 1
+	}
+	return ret
+}
+
+func (st *Storage)AppendEntry(type_ EntryType, data string) *Entry{
+	ent := new(Entry)
+	ent.Type = type_
+	ent.Term = st.node.Term
+	ent.Index = st.LastIndex + 1
+	ent.Data = data
+	st.appendEntry(ent)
+	return ent
+}
+
+// ent of the leader, at LastIndex + 1
+func (st *Storage)appendEntry(ent *Entry){
+	ent.Commit = st.CommitIndex
+	st.WriteEntry(*ent)
+	// notify xport to send, one pending notification is enough
+	select {
+	case st.C <- 0:
+	default:
+	}
+}
+
+// 如果存在空洞, 仅仅先缓存 entry, 不更新 lastTerm 和 lastIndex
+// 参数值拷贝. 与已有 entry 的 term 不同时, 删除它及之后的全部 entry.
+func (st *Storage)WriteEntry(ent Entry){
+	ent = st.witnessEntry(ent)
+	if ent.Index <= st.CommitIndex {
+		if st.log.DebugEnabled() {
+			st.log.Debug("entry before commitIndex", "index", ent.Index, "commitIndex", st.CommitIndex)
+		}
+		return
+	}
+	if old := st.GetEntry(ent.Index); old != nil && old.Term != ent.Term {
+		st.truncateFrom(ent.Index, ent.Term)
+	}
+
+	// not written yet if in a hole
+	st.entries.put(&ent, ent.Index > st.LastIndex + 1)
+	st.FirstIndex = util.MinInt64(st.FirstIndex, ent.Index)
+
+	// 找出连续的 entries, 更新 LastTerm 和 LastIndex,
+	b := NewBatch()
+	var records []string
+	for{
+		ent := st.GetEntry(st.LastIndex + 1)
+		if ent == nil {
+			break;
+		}
+		st.LastTerm = ent.Term
+		st.LastIndex = ent.Index
+		st.entries.unpin(ent.Index)
+
+		data := ent.Encode()
+		records = append(records, data)
+		st.dirty = true
+		if st.log.DebugEnabled() {
+			st.log.Debugf("write log %s", data)
+		}
+	}
+	if len(records) > 0 {
+		st.putLog(b, st.LastIndex - int64(len(records)) + 1, records)
+		st.putLogMeta(b)
+		if b.Len() > 0 {
+			st.db.WriteBatch(b)
+		}
+	}
+}
+
+// Deletes uncommitted entries from index on, they conflict with the log of
+// the leader of term. Cached ones in holes are deleted only if their terms
+// are smaller, terms of the leader's entries after index are not.
+func (st *Storage)truncateFrom(index int64, term int32){
+	if index <= st.CommitIndex {
+		st.fail(fmt.Errorf("truncate committed entry#%d, commitIndex: %d", index, st.CommitIndex))
+		return
+	}
+	st.log.Info("truncate conflicting entries", "from", index, "lastIndex", st.LastIndex)
+	st.truncateSuffix(index, func(ent *Entry) bool {
+		return ent.Index == index || ent.Term < term
+	})
+}
+
+// Deletes uncommitted entries from index on, cached ones in holes included,
+// for conflict resolution. Returns the failure halting the node, if any.
+func (st *Storage)TruncateSuffix(index int64) error {
+	if index <= st.CommitIndex {
+		return fmt.Errorf("truncate committed entry#%d, commitIndex: %d", index, st.CommitIndex)
+	}
+	st.log.Info("truncate entries", "from", index, "lastIndex", st.LastIndex)
+	st.truncateSuffix(index, func(ent *Entry) bool {
+		return true
+	})
+	return st.fault
+}
+
+// Deletes entries from index to LastIndex, and cached ones after LastIndex
+// if drop says so. Deletions and @LogMeta are written by one batch before
+// memory is updated, a crash leaves a prefix of it, entries after a hole
+// are discarded on startup.
+func (st *Storage)truncateSuffix(index int64, drop func(ent *Entry) bool){
+	b := NewBatch()
+	if index <= st.LastIndex {
+		st.deleteLog(b, index)
+	}
+	first := int64(math.MaxInt64)
+	st.entries.each(func(ent *Entry) {
+		idx := ent.Index
+		if idx >= index && (idx <= st.LastIndex || drop(ent)) {
+			st.entries.remove(idx)
+		} else {
+			first = util.MinInt64(first, idx)
+		}
+	})
+	if st.FirstIndex >= index {
+		st.FirstIndex = first
+	}
+	if index <= st.LastIndex {
+		st.LastIndex = index - 1
+		st.LastTerm = 0
+		if ent := st.GetEntry(st.LastIndex); ent != nil {
+			st.LastTerm = ent.Term
+		}
+		st.durableIndex = util.MinInt64(st.durableIndex, st.LastIndex)
+		st.putLogMeta(b)
+		if b.Len() > 0 {
+			st.db.WriteBatch(b)
+		}
+		st.dirty = true
+	}
+	st.node.recordEvent(EventTypeTruncate, "", index, "")
+}
+
+func (st *Storage)Fsync() {
+	clock := st.node.clock
+	start := clock.Now()
+	// entries before @CommitIndex
+	var err error
+	if st.ldb != nil {
+		err = st.ldb.Fsync()
+	}
+	if e := st.db.Fsync(); err == nil {
+		err = e
+	}
+	st.fsyncLatency.ObserveDuration(clock.Now().Sub(start))
+	st.lastFsync = start
+	st.dirty = false
+	st.durableIndex = st.LastIndex
+	if err != nil {
+		st.fail(fmt.Errorf("fsync error: %w", err))
+	}
+}
+
+// Applied to Service by the apply goroutine once it is started, or
+// synchronously
+func (st *Storage)CommitEntry(commitIndex int64){
+	if !st.advanceCommit(commitIndex) {
+		return
+	}
+	st.ApplyEntries()
+}
+
+func (st *Storage)advanceCommit(commitIndex int64) bool {
+	// 如果存在空洞, 不会跳过空洞 commit
+	commitIndex = util.MinInt64(commitIndex, st.LastIndex)
+	if commitIndex <= st.CommitIndex {
+		// st.log.Debug("commit index not advanced", "commit", commitIndex, "commitIndex", st.CommitIndex)
+		return false
+	}
+	st.CommitIndex = commitIndex
+	st.node.recordEvent(EventTypeCommit, "", commitIndex, "")
+	st.node.traceCommit(commitIndex)
+	st.node.notifyCommitWaiters()
+	st.node.notifyObservers(observerEvent{type_: observeCommit, index: commitIndex})
+	// fsynced with the batch, entries to apply are durable already
+	st.db.Set("@CommitIndex", util.I64toa(commitIndex))
+	st.dirty = true
+	return true
+}
+
+func (st *Storage)ApplyEntries(){
+	st.applyRaftEntries()
+
+	// see Applier.go
+	if st.Service != nil {
+		st.checkApplyBacklog()
+		st.node.dispatchApply()
+	}
+}
+
+// membership entries, by node
+func (st *Storage)applyRaftEntries(){
+	for idx := st.node.LastApplied() + 1; idx <= st.CommitIndex; idx ++ {
+		ent := st.GetEntry(idx)
+		if ent == nil {
+			st.fail(fmt.Errorf("entry#%d: %w", idx, ErrCompacted))
+			break
+		}
+		st.node.ApplyEntry(ent)
+	}
+	st.saveLastApplied()
+}
+
+func (st *Storage)observeApply(ent *Entry, elapsed time.Duration){
+	st.applyLatency.ObserveDuration(elapsed)
+	if elapsed >= st.SlowApplyThreshold {
+		st.slowApplies ++
+		st.log.Warn("slow apply", "index", ent.Index, "type", ent.Type, "elapsed", elapsed)
+	}
+}
+
+// warn once when backlog exceeds limit, and once when it recovers
+func (st *Storage)checkApplyBacklog(){
+	backlog := st.CommitIndex - st.node.serviceApplied
+	if backlog > st.ApplyBacklogLimit {
+		if !st.backlogWarned {
+			st.backlogWarned = true
+			st.log.Warn("apply backlog too large", "backlog", backlog, "limit", st.ApplyBacklogLimit,
+				"commitIndex", st.CommitIndex, "serviceLastApplied", st.node.serviceApplied)
+		}
+	} else if st.backlogWarned {
+		st.backlogWarned = false
+		st.log.Info("apply backlog recovered", "backlog", backlog)
+	}
+}
+
+// Entries from it are kept by TruncatePrefix(): included in a snapshot, see
+// NewSnapshotFromStorage(), or not applied yet. So is the last one, for
+// LastTerm on restart.
+func (st *Storage)compactLimit() int64 {
+	limit := util.MaxInt64(1, st.CommitIndex - st.snapshot.Entries + 1)
+	limit = util.MinInt64(limit, st.LastIndex)
+	limit = util.MinInt64(limit, st.node.LastApplied() + 1)
+	if st.Service != nil {
+		limit = util.MinInt64(limit, st.node.serviceApplied + 1)
+	}
+	return limit
+}
+
+// Deletes entries before index, for compaction, members behind FirstIndex
+// install a snapshot. With LogDb, entries are deleted by whole files, and
+// FirstIndex may stay before index. Without it, @LogMeta and the deletions
+// are written by one batch.
+func (st *Storage)TruncatePrefix(index int64) error {
+	if limit := st.compactLimit(); index > limit {
+		return fmt.Errorf("truncate before entry#%d, entries from #%d are needed", index, limit)
+	}
+	if st.logEntries() == 0 || index <= st.FirstIndex {
+		return nil
+	}
+	old := st.FirstIndex
+	if st.ldb != nil {
+		if err := st.ldb.TruncatePrefix(index); err != nil {
+			st.fail(fmt.Errorf("truncate before entry#%d: %w", index, err))
+			return st.fault
+		}
+		st.FirstIndex = st.ldb.FirstIndex()
+		st.logBytes = st.ldb.Size()
+	} else {
+		b := NewBatch()
+		for idx := old; idx < index; idx ++ {
+			k := logKey(idx)
+			st.logBytes -= int64(len(st.db.Get(k)))
+			b.Delete(k)
+		}
+		st.FirstIndex = index
+		st.putLogMeta(b)
+		st.db.WriteBatch(b)
+	}
+	for idx := old; idx < st.FirstIndex; idx ++ {
+		st.entries.remove(idx)
+	}
+	st.dirty = true
+	st.log.Info("truncate prefix", "before", index, "firstIndex", st.FirstIndex, "lastIndex", st.LastIndex)
+	return nil
+}
+
+/* #################### Snapshot ###################### */
+
+func (st *Storage)CreateSnapshot() *Snapshot {
+	return NewSnapshotFromStorage(st)
+}
+
+// install 之前, Node 需要配置好 Members, 因为 SaveState() 会从 node.Members 获取
+func (st *Storage)InstallSnapshot(sn *Snapshot) bool {
+	st.db.CleanAll()
+	st.cleanLogDb()
+
+	// never lowered, nor the vote of the term cleared
+	if sn.State().Term > st.node.Term {
+		st.node.Term    = sn.State().Term
+		st.node.VoteFor = ""
+	}
+	st.LastTerm     = sn.LastTerm()
+	st.LastIndex    = sn.LastIndex()
+	st.CommitIndex  = sn.LastIndex()
+
+	st.logBytes = 0
+	st.entries.reset()
+	st.FirstIndex = math.MaxInt64
+	b := NewBatch()
+	var records []string
+	for _, ent := range sn.Entries() {
+		if st.node.witness {
+			cp := st.witnessEntry(*ent)
+			ent = &cp
+		}
+		st.entries.put(ent, false)
+		st.FirstIndex = util.MinInt64(st.FirstIndex, ent.Index)
+		records = append(records, ent.Encode())
+	}
+	if len(records) > 0 {
+		st.putLog(b, st.FirstIndex, records)
+	}
+	b.Set("@CommitIndex", util.I64toa(st.CommitIndex))
+	b.Set("@LastApplied", util.I64toa(st.CommitIndex))
+	st.appliedSaved = st.CommitIndex
+	st.putLogMeta(b)
+	st.db.WriteBatch(b)
+	st.SaveState()
+
+	return true
+}
+
+func (st *Storage)CleanAll() bool {
+	st.CommitIndex = 0
+	st.LastTerm = 0
+	st.LastIndex = 0
+	st.logBytes = 0
+	st.appliedSaved = 0
+	st.entries.reset()
+	st.FirstIndex = math.MaxInt64
+	st.db.CleanAll()
+	st.cleanLogDb()
+	st.saveLogMeta()
+	st.SaveState()
+	return true
+}
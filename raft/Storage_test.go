@@ -0,0 +1,369 @@
+package raft
+
+import (
+	"fmt"
+	"testing"
+	"math/rand"
+
+	"github.com/fallowu/big-ssdb/internal/util"
+	"github.com/fallowu/big-ssdb/logger"
+)
+
+// old leader's entry at the index of ent, term is smaller than ent's
+func staleEntry(ent Entry, term int32) Entry {
+	ent.Term = term
+	ent.Data = "stale"
+	return ent
+}
+
+// entries from start are of a term greater than term
+func bumpTerms(log []Entry, start int, term int32) {
+	for i := start; i < len(log); i ++ {
+		if log[i].Term <= term {
+			log[i].Term = term + 1
+		}
+	}
+}
+
+// leader's log of n entries, terms non-decreasing
+func randomLog(r *rand.Rand, n int) []Entry {
+	ret := make([]Entry, 0, n)
+	term := int32(1)
+	for i := 1; i <= n; i ++ {
+		if r.Intn(4) == 0 {
+			term += int32(1 + r.Intn(2))
+		}
+		ret = append(ret, Entry{Term: term, Index: int64(i), Type: EntryTypeData, Data: fmt.Sprintf("d%d", i)})
+	}
+	return ret
+}
+
+func newTestStorage() *Storage {
+	node := NewNode("n1", "addr1", NewMemDb())
+	// greater than terms of entries
+	node.Term = 1000
+	node.SetInvariantMode(InvariantPanic)
+	return node.store
+}
+
+// holds after every WriteEntry and CommitEntry
+func checkStorage(t *testing.T, st *Storage, seed int64) {
+	for idx := int64(1); idx <= st.LastIndex; idx ++ {
+		if st.GetEntry(idx) == nil {
+			t.Fatalf("seed %d: hole at %d, lastIndex %d", seed, idx, st.LastIndex)
+		}
+	}
+	if st.LastIndex > 0 && st.LastTerm != st.GetEntry(st.LastIndex).Term {
+		t.Fatalf("seed %d: lastTerm %d, entry#%d term %d", seed, st.LastTerm, st.LastIndex, st.GetEntry(st.LastIndex).Term)
+	}
+	if st.CommitIndex > st.LastIndex {
+		t.Fatalf("seed %d: commitIndex %d > lastIndex %d", seed, st.CommitIndex, st.LastIndex)
+	}
+	st.node.checkInvariants("test")
+}
+
+func sameStorage(t *testing.T, a *Storage, b *Storage, seed int64) {
+	if a.LastIndex != b.LastIndex || a.LastTerm != b.LastTerm || a.CommitIndex != b.CommitIndex {
+		t.Fatalf("seed %d: lastIndex %d/%d lastTerm %d/%d commitIndex %d/%d", seed,
+				a.LastIndex, b.LastIndex, a.LastTerm, b.LastTerm, a.CommitIndex, b.CommitIndex)
+	}
+	for idx := int64(1); idx <= a.LastIndex; idx ++ {
+		if *a.GetEntry(idx) != *b.GetEntry(idx) {
+			t.Fatalf("seed %d: entry#%d %s != %s", seed, idx, a.GetEntry(idx).Encode(), b.GetEntry(idx).Encode())
+		}
+	}
+	da, db := dbAll(a.db), dbAll(b.db)
+	for k, v := range da {
+		if db[k] != v {
+			t.Fatalf("seed %d: db %s %q != %q", seed, k, v, db[k])
+		}
+	}
+	if len(da) != len(db) {
+		t.Fatalf("seed %d: db size %d != %d", seed, len(da), len(db))
+	}
+}
+
+func dbAll(db Db) map[string]string {
+	ret := make(map[string]string)
+	db.Iterate("", "", func(k string, v string) bool {
+		ret[k] = v
+		return true
+	})
+	return ret
+}
+
+// deliver log in order, commit at the end
+func inOrder(log []Entry, commit int64) *Storage {
+	st := newTestStorage()
+	for _, ent := range log {
+		st.WriteEntry(ent)
+	}
+	st.CommitEntry(commit)
+	return st
+}
+
+// Entries arrive in random order with duplicates, commits are piggybacked
+// at random points and gated by contiguity. The result is the same as
+// in-order delivery.
+func TestWriteEntryPermutations(t *testing.T){
+	logger.SetDefaultLevel(logger.LevelError)
+	for seed := int64(1); seed <= 300; seed ++ {
+		r := rand.New(rand.NewSource(seed))
+		log := randomLog(r, 1 + r.Intn(30))
+		commit := int64(r.Intn(len(log) + 1))
+
+		deliveries := make([]Entry, 0)
+		for _, ent := range log {
+			for n := 1 + r.Intn(3); n > 0; n -- {
+				deliveries = append(deliveries, ent)
+			}
+		}
+		r.Shuffle(len(deliveries), func(i, j int) {
+			deliveries[i], deliveries[j] = deliveries[j], deliveries[i]
+		})
+
+		st := newTestStorage()
+		for _, ent := range deliveries {
+			lastIndex := st.LastIndex
+			st.WriteEntry(ent)
+			if st.LastIndex < lastIndex {
+				t.Fatalf("seed %d: lastIndex decreased %d => %d", seed, lastIndex, st.LastIndex)
+			}
+			checkStorage(t, st, seed)
+
+			if r.Intn(3) == 0 {
+				c := int64(r.Intn(int(commit) + 1))
+				prev := st.CommitIndex
+				st.CommitEntry(c)
+				want := prev
+				if c > prev {
+					want = util.MinInt64(c, st.LastIndex)
+				}
+				if st.CommitIndex != want {
+					t.Fatalf("seed %d: commit %d => %d, want %d, lastIndex %d", seed, c, st.CommitIndex, want, st.LastIndex)
+				}
+				checkStorage(t, st, seed)
+			}
+		}
+		st.CommitEntry(commit)
+		checkStorage(t, st, seed)
+		sameStorage(t, st, inOrder(log, commit), seed)
+	}
+}
+
+// Stale entries of an old leader beyond a hole are replaced by the new
+// leader's before they become contiguous.
+func TestWriteEntryStaleCached(t *testing.T){
+	logger.SetDefaultLevel(logger.LevelError)
+	for seed := int64(1); seed <= 300; seed ++ {
+		r := rand.New(rand.NewSource(seed))
+		log := randomLog(r, 2 + r.Intn(30))
+		hole := 1 + r.Intn(len(log) - 1)
+		staleTerm := log[hole - 1].Term
+		bumpTerms(log, hole, staleTerm)
+		st := newTestStorage()
+		for _, ent := range log[:hole - 1] {
+			st.WriteEntry(ent)
+		}
+		// old leader's entries after the hole, with smaller terms
+		for _, ent := range log[hole:] {
+			if r.Intn(2) == 0 {
+				st.WriteEntry(staleEntry(ent, staleTerm))
+				checkStorage(t, st, seed)
+			}
+		}
+		rest := append([]Entry{}, log[hole:]...)
+		r.Shuffle(len(rest), func(i, j int) {
+			rest[i], rest[j] = rest[j], rest[i]
+		})
+		for _, ent := range rest {
+			st.WriteEntry(ent)
+			checkStorage(t, st, seed)
+		}
+		st.WriteEntry(log[hole - 1])
+		st.CommitEntry(int64(len(log)))
+		checkStorage(t, st, seed)
+		sameStorage(t, st, inOrder(log, int64(len(log))), seed)
+	}
+}
+
+// A conflicting entry at an index <= LastIndex truncates the log from it.
+func TestWriteEntryConflicts(t *testing.T){
+	logger.SetDefaultLevel(logger.LevelError)
+	for seed := int64(1); seed <= 300; seed ++ {
+		r := rand.New(rand.NewSource(seed))
+		log := randomLog(r, 2 + r.Intn(30))
+		commit := int64(r.Intn(len(log)))
+		staleTerm := int32(1)
+		if commit > 0 {
+			staleTerm = log[commit - 1].Term
+		}
+		bumpTerms(log, int(commit), staleTerm)
+		st := newTestStorage()
+		for _, ent := range log[:commit] {
+			st.WriteEntry(ent)
+		}
+		st.CommitEntry(commit)
+		// old leader's uncommitted entries, contiguous
+		for _, ent := range log[commit:] {
+			st.WriteEntry(staleEntry(ent, staleTerm))
+		}
+		for _, ent := range log[commit:] {
+			st.WriteEntry(ent)
+			checkStorage(t, st, seed)
+		}
+		st.CommitEntry(int64(len(log)))
+		sameStorage(t, st, inOrder(log, int64(len(log))), seed)
+	}
+}
+
+type countingDb struct{
+	Db
+	gets int
+	iterates int
+}
+
+func (db *countingDb)Get(key string) string {
+	db.gets ++
+	return db.Db.Get(key)
+}
+
+func (db *countingDb)Iterate(start string, end string, fn func(key string, val string) bool) {
+	db.iterates ++
+	db.Db.Iterate(start, end, fn)
+}
+
+// committed entries are not read on startup
+func TestStorageStartup(t *testing.T){
+	logger.SetDefaultLevel(logger.LevelError)
+	inner := NewMemDb()
+	node := NewNode("n1", "addr1", inner)
+	node.AddMember("n1", "addr1")
+	node.StepTick(0)
+	for i := 0; i < 1000; i ++ {
+		node.Propose(fmt.Sprintf("d%d", i))
+		node.StepTick(0)
+	}
+	st := node.store
+
+	db := &countingDb{Db: inner}
+	restarted := NewNode("n1", "addr1", db).store
+	if db.iterates != 0 || db.gets > 10 {
+		t.Fatal("iterates", db.iterates, "gets", db.gets)
+	}
+	if restarted.FirstIndex != st.FirstIndex || restarted.LastIndex != st.LastIndex ||
+		restarted.LastTerm != st.LastTerm || restarted.CommitIndex != st.CommitIndex ||
+		restarted.logBytes != st.logBytes {
+		t.Fatal("first", restarted.FirstIndex, "last", restarted.LastIndex, "commit", restarted.CommitIndex)
+	}
+	if ent := restarted.GetEntry(500); ent == nil || *ent != *st.GetEntry(500) {
+		t.Fatal("entry#500", ent)
+	}
+}
+
+// ranges past 999 are read by a scan of each key length, not by gets
+func TestGetEntries(t *testing.T){
+	logger.SetDefaultLevel(logger.LevelError)
+	inner := NewMemDb()
+	node := NewNode("n1", "addr1", inner)
+	node.AddMember("n1", "addr1")
+	node.StepTick(0)
+	for i := 0; i < 1100; i ++ {
+		node.Propose(fmt.Sprintf("d%d", i))
+		node.StepTick(0)
+	}
+
+	db := &countingDb{Db: inner}
+	st := NewNode("n1", "addr1", db).store
+	st.GetEntry(995)
+	db.gets, db.iterates = 0, 0
+	ents := st.GetEntries(990, 1010)
+	if len(ents) != 21 || db.gets != 0 || db.iterates != 3 {
+		t.Fatal("entries", len(ents), "gets", db.gets, "iterates", db.iterates)
+	}
+	for i, ent := range ents {
+		if ent.Index != int64(990 + i) || *ent != *node.store.GetEntry(ent.Index) {
+			t.Fatal("entry", i, ent)
+		}
+	}
+	// cached now
+	if ents := st.GetEntries(1000, 2000); len(ents) != 103 || db.iterates != 4 {
+		t.Fatal("to last", len(ents), db.iterates)
+	}
+	if ents := st.GetEntries(0, 10); ents != nil {
+		t.Fatal("before first", ents)
+	}
+}
+
+// the log after TruncateSuffix and TruncatePrefix is the same on restart
+func TestTruncate(t *testing.T){
+	logger.SetDefaultLevel(logger.LevelError)
+	st := newTestStorage()
+	log := randomLog(rand.New(rand.NewSource(1)), 20)
+	for _, ent := range log {
+		st.WriteEntry(ent)
+	}
+	st.CommitEntry(10)
+	// cached in a hole
+	st.WriteEntry(Entry{Term: 1000, Index: 25, Type: EntryTypeData, Data: "d25"})
+	if err := st.TruncateSuffix(10); err == nil {
+		t.Fatal("truncate committed entry")
+	}
+	if err := st.TruncateSuffix(15); err != nil {
+		t.Fatal(err)
+	}
+	checkStorage(t, st, 1)
+	if st.LastIndex != 14 || st.entries.get(25) != nil || st.GetEntry(15) != nil {
+		t.Fatal("truncate suffix", st.LastIndex)
+	}
+	restarted := NewNode("n1", "addr1", st.db).store
+	sameStorage(t, restarted, inOrder(log[:14], 10), 1)
+
+	inner := NewMemDb()
+	node := NewNode("n1", "addr1", inner, WithSnapshotPolicy(SnapshotPolicy{Entries: 100}))
+	node.AddMember("n1", "addr1")
+	node.StepTick(0)
+	for i := 0; i < 300; i ++ {
+		node.Propose(fmt.Sprintf("d%d", i))
+		node.StepTick(0)
+	}
+	st = node.store
+	if err := st.TruncatePrefix(st.CommitIndex - 50); err == nil {
+		t.Fatal("truncate entries of snapshot")
+	}
+	if err := st.TruncatePrefix(150); err != nil {
+		t.Fatal(err)
+	}
+	if st.FirstIndex != 150 || st.GetEntry(149) != nil || st.GetEntry(150) == nil {
+		t.Fatal("truncate prefix", st.FirstIndex)
+	}
+	if inner.Get(logKey(149)) != "" || inner.Get(logKey(150)) == "" {
+		t.Fatal("keys left")
+	}
+	restarted = NewNode("n1", "addr1", inner).store
+	if restarted.FirstIndex != 150 || restarted.LastIndex != st.LastIndex || restarted.logBytes != st.logBytes {
+		t.Fatal("restart", restarted.FirstIndex, restarted.LastIndex, restarted.logBytes, st.logBytes)
+	}
+	if ents := restarted.GetEntries(150, 160); len(ents) != 11 || restarted.GetEntries(149, 160) != nil {
+		t.Fatal("entries", len(ents))
+	}
+}
+
+func TestStorageMetrics(t *testing.T){
+	logger.SetDefaultLevel(logger.LevelError)
+	node := NewNode("n1", "addr1", NewMemDb())
+	node.AddMember("n1", "addr1")
+	node.StepTick(0)
+	for i := 0; i < 10; i ++ {
+		node.Propose(fmt.Sprintf("d%d", i))
+		node.StepTick(0)
+	}
+	m := node.StorageMetrics()
+	if m.FirstIndex != 1 || m.LastIndex != 12 || m.Entries != 12 || m.Bytes == 0 || m.ApplyLag != 0 || m.Fsync.Count == 0 {
+		t.Fatalf("%+v", m)
+	}
+	info := node.InfoMap()
+	if info["logEntries"] != "12" || info["logBytes"] != fmt.Sprint(m.Bytes) || info["applyLag"] != "0" {
+		t.Fatal(info)
+	}
+}
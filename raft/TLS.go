@@ -0,0 +1,66 @@
+package raft
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"os"
+)
+
+// TLS of TcpTransport from PEM files. caFile verifies peers' certificates,
+// "" to use the system's roots. With mutual, a node accepts connections
+// only from peers with a certificate signed by the CA, so that hosts outside
+// the cluster can't inject messages.
+func LoadTLSConfig(certFile string, keyFile string, caFile string, mutual bool) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	conf := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion: tls.VersionTLS12,
+	}
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("no certificate in " + caFile)
+		}
+		conf.RootCAs = pool
+		conf.ClientCAs = pool
+	}
+	if mutual {
+		conf.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return conf, nil
+}
+
+// names a verified peer certificate authenticates, nil if none
+func certNames(st tls.ConnectionState) map[string]bool {
+	if len(st.VerifiedChains) == 0 || len(st.PeerCertificates) == 0 {
+		return nil
+	}
+	cert := st.PeerCertificates[0]
+	names := map[string]bool{cert.Subject.CommonName: true}
+	for _, name := range cert.DNSNames {
+		names[name] = true
+	}
+	return names
+}
+
+// plain TCP if conf is nil, the peer's certificate is verified against addr's
+// host unless conf has ServerName
+func dialTLS(d *net.Dialer, addr string, conf *tls.Config) (net.Conn, error) {
+	if conf == nil {
+		return d.Dial("tcp", addr)
+	}
+	if conf.ServerName == "" {
+		conf = conf.Clone()
+		conf.ServerName, _, _ = net.SplitHostPort(addr)
+	}
+	return tls.DialWithDialer(d, "tcp", addr, conf)
+}
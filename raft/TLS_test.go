@@ -0,0 +1,109 @@
+package raft
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/fallowu/big-ssdb/logger"
+)
+
+type testCA struct{
+	cert *x509.Certificate
+	key *ecdsa.PrivateKey
+	pool *x509.CertPool
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject: pkix.Name{CommonName: "test ca"},
+		NotBefore: time.Now().Add(-time.Hour),
+		NotAfter: time.Now().Add(time.Hour),
+		IsCA: true,
+		KeyUsage: x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return &testCA{cert, key, pool}
+}
+
+// mutual TLS config of node id, signed by ca
+func (ca *testCA)config(t *testing.T, id string) *tls.Config {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject: pkix.Name{CommonName: id},
+		NotBefore: time.Now().Add(-time.Hour),
+		NotAfter: time.Now().Add(time.Hour),
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+		RootCAs: ca.pool,
+		ClientCAs: ca.pool,
+		ClientAuth: tls.RequireAndVerifyClientCert,
+	}
+}
+
+func TestMutualTLSTransport(t *testing.T){
+	logger.SetDefaultLevel(logger.LevelError)
+	ca := newTestCA(t)
+	conf := DefaultTcpConfig()
+	conf.TLS = ca.config(t, "n2")
+	conf.CheckSrc = true
+	rx, err := NewTcpTransportWithConfig("127.0.0.1", 0, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rx.Close()
+	conf.TLS = ca.config(t, "n1")
+	tx, _ := NewTcpTransportWithConfig("127.0.0.1", 0, conf)
+	defer tx.Close()
+	tx.Connect("n2", rx.Addr())
+	// not a member, no certificate
+	plain, _ := NewTcpTransport("127.0.0.1", 0)
+	defer plain.Close()
+	plain.Connect("n2", rx.Addr())
+
+	spoofed := NewAppendEntryAck("n2", true)
+	spoofed.Src = "n3"
+	spoofed.PrevIndex = 1
+	tx.Send(spoofed)
+	injected := NewAppendEntryAck("n2", true)
+	injected.Src = "n1"
+	injected.PrevIndex = 2
+	plain.Send(injected)
+	msg := NewAppendEntryAck("n2", true)
+	msg.Src = "n1"
+	msg.PrevIndex = 3
+	tx.Send(msg)
+
+	if m := recvTcp(t, rx); m.Src != "n1" || m.PrevIndex != 3 {
+		t.Fatal(m.Encode())
+	}
+	select {
+	case m := <-rx.C():
+		t.Fatal("unexpected", m.Encode())
+	case <-time.After(200 * time.Millisecond):
+	}
+}
@@ -0,0 +1,427 @@
+package raft
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/fallowu/big-ssdb/logger"
+)
+
+// Messages are framed by a 4 bytes big endian length of the encoded message.
+// Each node dials a persistent connection to every peer for what it sends,
+// and reads what peers send from the connections they dial. A broken
+// connection is dialed again with backoff, messages queued meanwhile are
+// sent then, those beyond the queue are dropped as UDP would do. The
+// encoding of messages is negotiated per peer, see Wire.go.
+
+const(
+	DefaultTcpQueueSize = 256
+	// per peer
+	DefaultTcpSendQueueSize = 1024
+	DefaultTcpMaxFrame = 64 * 1024 * 1024
+	DefaultTcpDialTimeout = 3 * time.Second
+	tcpMinBackoff = 100 * time.Millisecond
+	tcpMaxBackoff = 5 * time.Second
+)
+
+type TcpConfig struct{
+	// capacity of C()
+	QueueSize int
+	// messages queued for a peer, Send() fails when full
+	SendQueueSize int
+	// bytes of a message, larger ones break the connection
+	MaxFrame int
+	DialTimeout time.Duration
+	// nil for plain TCP, see TLS.go. Used to listen and to dial
+	TLS *tls.Config
+	// with mutual TLS, drop messages whose Src is not the CommonName(or a
+	// DNS name) of the sender's certificate
+	CheckSrc bool
+	// frames queued within BatchWindow after the first unflushed one are
+	// written together, 0 to flush whenever the queue is empty
+	BatchWindow time.Duration
+	// highest wire version to negotiate, 0 for WireVersion,
+	// WireVersionText to talk as nodes before the negotiation
+	WireVersion int
+}
+
+func DefaultTcpConfig() TcpConfig {
+	return TcpConfig{
+		QueueSize: DefaultTcpQueueSize,
+		SendQueueSize: DefaultTcpSendQueueSize,
+		MaxFrame: DefaultTcpMaxFrame,
+		DialTimeout: DefaultTcpDialTimeout,
+	}
+}
+
+type TcpTransport struct{
+	addr string
+	ln net.Listener
+	c chan *Message
+	conf TcpConfig
+	peers map[string]*tcpPeer
+	// accepted, closed by Close()
+	conns map[net.Conn]bool
+	log *logger.Logger
+	plog *logger.PacketLog
+	versions *wireVersions
+	mux sync.Mutex
+	// closed by Close(), goroutines exit
+	done chan struct{}
+	wg sync.WaitGroup
+}
+
+// connection dialed to a peer, written by its own goroutine
+type tcpPeer struct{
+	id string
+	addr string
+	q chan []byte
+	// closed by Disconnect()
+	done chan struct{}
+}
+
+func NewTcpTransport(ip string, port int) (*TcpTransport, error) {
+	return NewTcpTransportWithConfig(ip, port, DefaultTcpConfig())
+}
+
+// port 0 listens on a random port, see Addr()
+func NewTcpTransportWithConfig(ip string, port int, conf TcpConfig) (*TcpTransport, error) {
+	def := DefaultTcpConfig()
+	if conf.QueueSize <= 0 {
+		conf.QueueSize = def.QueueSize
+	}
+	if conf.SendQueueSize <= 0 {
+		conf.SendQueueSize = def.SendQueueSize
+	}
+	if conf.MaxFrame <= 0 {
+		conf.MaxFrame = def.MaxFrame
+	}
+	if conf.DialTimeout <= 0 {
+		conf.DialTimeout = def.DialTimeout
+	}
+	ln, err := net.Listen("tcp", fmt.Sprintf("%s:%d", ip, port))
+	if err != nil {
+		return nil, err
+	}
+	if conf.TLS != nil {
+		ln = tls.NewListener(ln, conf.TLS)
+	}
+
+	tp := new(TcpTransport)
+	tp.addr = fmt.Sprintf("%s:%d", ip, port)
+	if port == 0 {
+		tp.addr = ln.Addr().String()
+	}
+	tp.ln = ln
+	tp.conf = conf
+	tp.c = make(chan *Message, conf.QueueSize)
+	tp.done = make(chan struct{})
+	tp.peers = make(map[string]*tcpPeer)
+	tp.conns = make(map[net.Conn]bool)
+	tp.versions = newWireVersions(conf.WireVersion)
+	tp.log = logger.New("transport").With("addr", tp.addr)
+	tp.plog = logger.NewPacketLog(tp.log)
+
+	tp.wg.Add(1)
+	go tp.accept()
+	return tp, nil
+}
+
+func (tp *TcpTransport)SetLogger(l *logger.Logger) {
+	tp.log = l
+	tp.plog = logger.NewPacketLog(l)
+}
+
+func (tp *TcpTransport)C() chan *Message {
+	return tp.c
+}
+
+func (tp *TcpTransport)Addr() string {
+	return tp.addr
+}
+
+// C() is closed after goroutines exit
+func (tp *TcpTransport)Close(){
+	tp.mux.Lock()
+	close(tp.done)
+	tp.ln.Close()
+	for conn := range tp.conns {
+		conn.Close()
+	}
+	tp.mux.Unlock()
+	tp.wg.Wait()
+	close(tp.c)
+}
+
+func (tp *TcpTransport)Connect(nodeId, addr string){
+	tp.mux.Lock()
+	defer tp.mux.Unlock()
+
+	if p := tp.peers[nodeId]; p != nil {
+		if p.addr == addr {
+			return
+		}
+		close(p.done)
+	}
+	p := &tcpPeer{
+		id: nodeId,
+		addr: addr,
+		q: make(chan []byte, tp.conf.SendQueueSize),
+		done: make(chan struct{}),
+	}
+	tp.peers[nodeId] = p
+	tp.wg.Add(1)
+	go tp.runPeer(p)
+}
+
+func (tp *TcpTransport)Disconnect(nodeId string){
+	tp.mux.Lock()
+	defer tp.mux.Unlock()
+
+	if p := tp.peers[nodeId]; p != nil {
+		close(p.done)
+		delete(tp.peers, nodeId)
+	}
+}
+
+// thread safe, false if dst is not connected or its queue is full
+func (tp *TcpTransport)Send(msg *Message) bool{
+	tp.mux.Lock()
+	p := tp.peers[msg.Dst]
+	tp.mux.Unlock()
+
+	if p == nil {
+		tp.log.Warn("dst not connected", "peer", msg.Dst)
+		return false
+	}
+	buf := getEncodeBuf()
+	*buf = AppendWire(*buf, msg, tp.versions.get(msg.Dst))
+	frame := tcpFrame(*buf)
+	putEncodeBuf(buf)
+	select {
+	case p.q <- frame:
+		tp.logPacket("    send >", msg)
+		return true
+	default:
+		tp.log.Warn("send queue full, drop message", "peer", msg.Dst)
+		return false
+	}
+}
+
+func tcpFrame(data []byte) []byte {
+	frame := make([]byte, 4 + len(data))
+	binary.BigEndian.PutUint32(frame, uint32(len(data)))
+	copy(frame[4:], data)
+	return frame
+}
+
+// dials p, writes queued frames, dials again when the connection breaks
+func (tp *TcpTransport)runPeer(p *tcpPeer){
+	defer tp.wg.Done()
+	backoff := tcpMinBackoff
+	// written when the connection breaks, sent on the next one
+	var pending []byte
+	for {
+		conn, err := tp.dial(p.addr)
+		if err != nil {
+			tp.log.Debug("dial error", "peer", p.id, "addr", p.addr, "err", err)
+			select {
+			case <-time.After(backoff):
+			case <-p.done:
+				return
+			case <-tp.done:
+				return
+			}
+			backoff = backoffDuration(backoff * 2)
+			continue
+		}
+		tp.log.Info("connected", "peer", p.id, "addr", p.addr)
+		backoff = tcpMinBackoff
+		pending, err = tp.writePeer(p, conn, pending)
+		conn.Close()
+		if err == nil {
+			return
+		}
+		tp.log.Info("connection broken", "peer", p.id, "addr", p.addr, "err", err)
+	}
+}
+
+func (tp *TcpTransport)dial(addr string) (net.Conn, error) {
+	return dialTLS(&net.Dialer{Timeout: tp.conf.DialTimeout}, addr, tp.conf.TLS)
+}
+
+func backoffDuration(d time.Duration) time.Duration {
+	if d > tcpMaxBackoff {
+		return tcpMaxBackoff
+	}
+	return d
+}
+
+// nil error when p is disconnected or tp closed, otherwise the frame not
+// written
+func (tp *TcpTransport)writePeer(p *tcpPeer, conn net.Conn, pending []byte) ([]byte, error) {
+	w := bufio.NewWriter(conn)
+	if tp.versions.max > WireVersionText {
+		w.Write(tcpFrame(appendWireHello(nil, tp.versions.max)))
+	}
+	// returns when the peer closes the connection, so that it is not
+	// detected by the next write only
+	broken := make(chan struct{})
+	go func() {
+		io.Copy(io.Discard, conn)
+		close(broken)
+	}()
+	// see BatchWindow
+	var flushAt time.Time
+	for {
+		frame := pending
+		if frame == nil {
+			// flush when nothing more is queued
+			select {
+			case frame = <-p.q:
+			default:
+				if wait := time.Until(flushAt); w.Buffered() > 0 && wait > 0 {
+					t := time.NewTimer(wait)
+					select {
+					case frame = <-p.q:
+					case <-t.C:
+					case <-broken:
+					case <-p.done:
+					case <-tp.done:
+					}
+					t.Stop()
+				}
+				if frame != nil {
+					break
+				}
+				if err := w.Flush(); err != nil {
+					return nil, err
+				}
+				select {
+				case frame = <-p.q:
+				case <-broken:
+					return nil, errors.New("closed by peer")
+				case <-p.done:
+					return nil, nil
+				case <-tp.done:
+					return nil, nil
+				}
+			}
+		}
+		if w.Buffered() == 0 {
+			flushAt = time.Now().Add(tp.conf.BatchWindow)
+		}
+		conn.SetWriteDeadline(time.Now().Add(tp.conf.DialTimeout))
+		if _, err := w.Write(frame); err != nil {
+			return frame, err
+		}
+		pending = nil
+	}
+}
+
+func (tp *TcpTransport)accept(){
+	defer tp.wg.Done()
+	for {
+		conn, err := tp.ln.Accept()
+		if err != nil {
+			select {
+			case <-tp.done:
+				return
+			default:
+			}
+			tp.log.Warn("accept error", "err", err)
+			time.Sleep(tcpMinBackoff)
+			continue
+		}
+		tp.mux.Lock()
+		select {
+		case <-tp.done:
+			tp.mux.Unlock()
+			conn.Close()
+			return
+		default:
+		}
+		tp.conns[conn] = true
+		tp.wg.Add(1)
+		tp.mux.Unlock()
+		go tp.read(conn)
+	}
+}
+
+// reads frames until the connection breaks
+func (tp *TcpTransport)read(conn net.Conn){
+	defer tp.wg.Done()
+	defer func() {
+		tp.mux.Lock()
+		delete(tp.conns, conn)
+		tp.mux.Unlock()
+		conn.Close()
+	}()
+	var names map[string]bool
+	if tc, ok := conn.(*tls.Conn); ok && tp.conf.CheckSrc {
+		conn.SetDeadline(time.Now().Add(tp.conf.DialTimeout))
+		if err := tc.Handshake(); err != nil {
+			tp.log.Warn("tls handshake error", "remote", conn.RemoteAddr(), "err", err)
+			return
+		}
+		conn.SetDeadline(time.Time{})
+		names = certNames(tc.ConnectionState())
+	}
+	r := bufio.NewReader(conn)
+	var head [4]byte
+	// of the hello, WireVersionText if the peer sends none
+	version := WireVersionText
+	negotiated := false
+	for {
+		if _, err := io.ReadFull(r, head[:]); err != nil {
+			return
+		}
+		n := int(binary.BigEndian.Uint32(head[:]))
+		if n > tp.conf.MaxFrame {
+			tp.log.Warn("frame too large", "remote", conn.RemoteAddr(), "size", n, "max", tp.conf.MaxFrame)
+			return
+		}
+		buf := make([]byte, n)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return
+		}
+		msg, hello, err := DecodeWire(buf)
+		if err != nil {
+			tp.log.Warn("decode error", "remote", conn.RemoteAddr(), "size", n, "err", err)
+			continue
+		}
+		if msg == nil {
+			version = hello
+			negotiated = false
+			continue
+		}
+		if names != nil && !names[msg.Src] {
+			tp.log.Warn("drop message of unauthenticated src", "remote", conn.RemoteAddr(), "peer", msg.Src)
+			continue
+		}
+		if !negotiated {
+			negotiated = true
+			tp.versions.set(msg.Src, version)
+			tp.log.Debug("wire version", "peer", msg.Src, "version", tp.versions.get(msg.Src))
+		}
+		tp.logPacket(" receive <", msg)
+		select {
+		case tp.c <- msg:
+		case <-tp.done:
+			return
+		}
+	}
+}
+
+func (tp *TcpTransport)logPacket(prefix string, msg *Message) {
+	if tp.plog.Sample() {
+		tp.plog.Log(prefix, msg.Encode(), msg.Redacted)
+	}
+}
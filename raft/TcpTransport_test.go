@@ -0,0 +1,107 @@
+package raft
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fallowu/big-ssdb/logger"
+)
+
+func recvTcp(t *testing.T, tp *TcpTransport) *Message {
+	select {
+	case msg := <-tp.C():
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatal("receive timeout")
+		return nil
+	}
+}
+
+func TestReconnectTcpTransport(t *testing.T){
+	logger.SetDefaultLevel(logger.LevelError)
+	rx, err := NewTcpTransport("127.0.0.1", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx, err := NewTcpTransport("127.0.0.1", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Close()
+	tx.Connect("n2", rx.Addr())
+
+	// in order, and larger than a datagram
+	big := strings.Repeat("x", 200 * 1024)
+	for i := 0; i < 100; i ++ {
+		msg := NewAppendEntryAck("n2", true)
+		msg.Src = "n1"
+		msg.PrevIndex = int64(i)
+		if i == 50 {
+			msg.Data = big
+		}
+		if !tx.Send(msg) {
+			t.Fatal("send", i)
+		}
+	}
+	for i := 0; i < 100; i ++ {
+		msg := recvTcp(t, rx)
+		if msg.PrevIndex != int64(i) || (i == 50 && msg.Data != big) {
+			t.Fatal("receive", i, msg.PrevIndex, len(msg.Data))
+		}
+	}
+
+	// restarted on the same address, messages queued meanwhile are sent
+	addr := rx.Addr()
+	rx.Close()
+	time.Sleep(50 * time.Millisecond)
+	msg := NewAppendEntryAck("n2", true)
+	msg.Src = "n1"
+	msg.PrevIndex = 1000
+	tx.Send(msg)
+	port, _ := strconv.Atoi(addr[strings.LastIndexByte(addr, ':') + 1:])
+	rx, err = NewTcpTransport("127.0.0.1", port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rx.Close()
+	for {
+		msg := recvTcp(t, rx)
+		if msg.PrevIndex == 1000 {
+			break
+		}
+	}
+}
+
+func TestBatchedTcpTransport(t *testing.T){
+	logger.SetDefaultLevel(logger.LevelError)
+	conf := DefaultTcpConfig()
+	conf.BatchWindow = 2 * time.Millisecond
+	rx, err := NewTcpTransportWithConfig("127.0.0.1", 0, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rx.Close()
+	tx, err := NewTcpTransportWithConfig("127.0.0.1", 0, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Close()
+	tx.Connect("n2", rx.Addr())
+
+	for i := 0; i < 50; i ++ {
+		msg := NewAppendEntryAck("n2", true)
+		msg.Src = "n1"
+		msg.PrevIndex = int64(i)
+		tx.Send(msg)
+		if i % 10 == 0 {
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+	for i := 0; i < 50; i ++ {
+		if msg := recvTcp(t, rx); msg.PrevIndex != int64(i) {
+			t.Fatal("receive", i, msg.PrevIndex)
+		}
+	}
+}
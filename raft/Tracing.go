@@ -0,0 +1,87 @@
+package raft
+
+import (
+	"github.com/fallowu/big-ssdb/trace"
+)
+
+// spans of a proposed entry, only on leader
+type entryTrace struct{
+	parent trace.SpanContext
+	// from proposal to commit
+	replicate trace.Span
+}
+
+func (node *Node)SetTracer(t trace.Tracer){
+	node.mux.Lock()
+	defer node.mux.Unlock()
+	node.tracer = t
+}
+
+func (node *Node)traceProposal(ent *Entry, parent trace.SpanContext){
+	if !parent.IsValid() {
+		return
+	}
+	span := node.tracer.Start(parent, "raft.replicate")
+	if !span.Context().IsValid() {
+		return
+	}
+	span.SetAttr("raft.node", node.Id)
+	span.SetAttr("raft.term", ent.Term)
+	span.SetAttr("raft.index", ent.Index)
+	node.traces[ent.Index] = &entryTrace{parent, span}
+}
+
+// propagate replicate span of entry to follower
+func (node *Node)traceAppendEntry(msg *Message, ent *Entry){
+	if t := node.traces[ent.Index]; t != nil {
+		msg.TraceId = t.replicate.Context().Traceparent()
+	}
+}
+
+// follower side span of AppendEntry, nil if msg is not traced
+func (node *Node)startAppendSpan(msg *Message) trace.Span {
+	parent, ok := trace.ParseTraceparent(msg.TraceId)
+	if !ok {
+		return nil
+	}
+	span := node.tracer.Start(parent, "raft.append")
+	span.SetAttr("raft.node", node.Id)
+	span.SetAttr("raft.index", msg.PrevIndex + 1)
+	return span
+}
+
+func (node *Node)traceCommit(commitIndex int64){
+	for idx, t := range node.traces {
+		if idx <= commitIndex && t.replicate != nil {
+			t.replicate.End()
+			t.replicate = nil
+		}
+	}
+}
+
+// span of Service.ApplyEntry, nil if entry is not traced
+func (node *Node)startApplySpan(ent *Entry) trace.Span {
+	t := node.traces[ent.Index]
+	if t == nil {
+		return nil
+	}
+	delete(node.traces, ent.Index)
+	if t.replicate != nil {
+		t.replicate.End()
+	}
+	span := node.tracer.Start(t.parent, "raft.apply")
+	span.SetAttr("raft.node", node.Id)
+	span.SetAttr("raft.index", ent.Index)
+	return span
+}
+
+// leadership lost, entries may never be committed
+func (node *Node)abortTraces(){
+	for idx, t := range node.traces {
+		if t.replicate != nil {
+			t.replicate.SetAttr("error", "leadership lost")
+			t.replicate.End()
+		}
+		delete(node.traces, idx)
+	}
+}
@@ -0,0 +1,137 @@
+package raft
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// 各节点之间的通信是全异步的, 而不是请求响应模式
+type Transport interface{
+	Addr() string
+
+	Close()
+	Connect(nodeId string, addr string)
+	Disconnect(nodeId string)
+
+	// messages received
+	C() chan *Message
+	// thread safe
+	Send(msg *Message) bool
+}
+
+var (
+	_ Transport = (*UdpTransport)(nil)
+	_ Transport = (*TcpTransport)(nil)
+	_ Transport = (*MemTransport)(nil)
+	_ Transport = (*FaultTransport)(nil)
+	_ Transport = (*RecordTransport)(nil)
+)
+
+// Options of a transport created by NewTransport()
+type TransportOptions struct{
+	Host string
+	Port int
+	// transport specific parameters, e.g. os.Getenv, "" if absent
+	Param func(key string) string
+}
+
+func (o TransportOptions)param(key string) string {
+	if o.Param == nil {
+		return ""
+	}
+	return o.Param(key)
+}
+
+func (o TransportOptions)intParam(key string) int {
+	n, _ := strconv.Atoi(o.param(key))
+	return n
+}
+
+func (o TransportOptions)durationParam(key string) (time.Duration, error) {
+	s := o.param(key)
+	if s == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("bad %s: %s", key, s)
+	}
+	return d, nil
+}
+
+type TransportFactory func(opts TransportOptions) (Transport, error)
+
+var transports = struct{
+	mux sync.Mutex
+	m map[string]TransportFactory
+}{m: make(map[string]TransportFactory)}
+
+// Makes a transport available to NewTransport() by name, usually called in
+// init() of the package implementing it. "udp" and "tcp" are built in.
+func RegisterTransport(name string, f TransportFactory) {
+	transports.mux.Lock()
+	defer transports.mux.Unlock()
+	transports.m[name] = f
+}
+
+func NewTransport(name string, opts TransportOptions) (Transport, error) {
+	transports.mux.Lock()
+	f := transports.m[name]
+	transports.mux.Unlock()
+	if f == nil {
+		return nil, fmt.Errorf("unknown transport: %s, registered: %v", name, TransportNames())
+	}
+	return f(opts)
+}
+
+// sorted
+func TransportNames() []string {
+	transports.mux.Lock()
+	defer transports.mux.Unlock()
+	var ret []string
+	for name := range transports.m {
+		ret = append(ret, name)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// Parameters: UDP_READERS, UDP_QUEUE, BATCH_WINDOW
+func newUdpTransportFromOptions(opts TransportOptions) (Transport, error) {
+	conf := DefaultUdpConfig()
+	conf.Readers = opts.intParam("UDP_READERS")
+	conf.QueueSize = opts.intParam("UDP_QUEUE")
+	var err error
+	if conf.BatchWindow, err = opts.durationParam("BATCH_WINDOW"); err != nil {
+		return nil, err
+	}
+	return NewUdpTransportWithConfig(opts.Host, opts.Port, conf), nil
+}
+
+// Parameters: BATCH_WINDOW, TLS_CERT, TLS_KEY, TLS_CA, TLS_MUTUAL. With
+// TLS_MUTUAL, only peers with a certificate signed by TLS_CA, as their node
+// id, are accepted
+func newTcpTransportFromOptions(opts TransportOptions) (Transport, error) {
+	conf := DefaultTcpConfig()
+	var err error
+	if conf.BatchWindow, err = opts.durationParam("BATCH_WINDOW"); err != nil {
+		return nil, err
+	}
+	if cert := opts.param("TLS_CERT"); cert != "" {
+		mutual := opts.param("TLS_MUTUAL") != ""
+		conf.TLS, err = LoadTLSConfig(cert, opts.param("TLS_KEY"), opts.param("TLS_CA"), mutual)
+		if err != nil {
+			return nil, err
+		}
+		conf.CheckSrc = mutual
+	}
+	return NewTcpTransportWithConfig(opts.Host, opts.Port, conf)
+}
+
+func init() {
+	RegisterTransport("udp", newUdpTransportFromOptions)
+	RegisterTransport("tcp", newTcpTransportFromOptions)
+}
@@ -0,0 +1,30 @@
+package raft
+
+import (
+	"testing"
+)
+
+func TestRegisterTransport(t *testing.T){
+	net := NewMemNetwork(1, nil)
+	RegisterTransport("test-mem", func(opts TransportOptions) (Transport, error) {
+		return net.Transport(opts.Host + ":" + opts.param("SUFFIX")), nil
+	})
+	params := map[string]string{"SUFFIX": "x", "BATCH_WINDOW": "1ms"}
+	opts := TransportOptions{Host: "127.0.0.1", Param: func(k string) string { return params[k] }}
+	tp, err := NewTransport("test-mem", opts)
+	if err != nil || tp.Addr() != "127.0.0.1:x" {
+		t.Fatal(tp, err)
+	}
+	tp, err = NewTransport("tcp", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tp.Close()
+	if _, err := NewTransport("nope", opts); err == nil {
+		t.Fatal("unknown transport")
+	}
+	params["BATCH_WINDOW"] = "1"
+	if _, err := NewTransport("tcp", opts); err == nil {
+		t.Fatal("bad param")
+	}
+}
@@ -0,0 +1,351 @@
+package raft
+
+import (
+	"fmt"
+	"net"
+	"time"
+	"math/rand"
+	"sync"
+
+	"github.com/fallowu/big-ssdb/internal/util"
+	"github.com/fallowu/big-ssdb/logger"
+)
+
+const(
+	DefaultUdpReaders = 2
+	DefaultUdpQueueSize = 256
+	// SO_RCVBUF, absorbs bursts while readers are busy
+	DefaultUdpReadBuffer = 4 * 1024 * 1024
+	// max datagram
+	udpBufSize = 64 * 1024
+	// of a batch, fits in an ethernet MTU
+	DefaultUdpBatchBytes = 1400
+	DefaultUdpBatchSize = 64
+)
+
+type UdpConfig struct{
+	// goroutines reading the socket, datagrams read by different readers
+	// may be reordered, as UDP may do anyway
+	Readers int
+	// capacity of C()
+	QueueSize int
+	ReadBuffer int
+	// Messages to a peer within BatchWindow are sent in one datagram, up to
+	// BatchSize messages of BatchBytes. 0 to send each alone, batches are
+	// not understood by nodes before batching.
+	BatchWindow time.Duration
+	BatchSize int
+	BatchBytes int
+}
+
+func DefaultUdpConfig() UdpConfig {
+	return UdpConfig{
+		Readers: DefaultUdpReaders,
+		QueueSize: DefaultUdpQueueSize,
+		ReadBuffer: DefaultUdpReadBuffer,
+		BatchSize: DefaultUdpBatchSize,
+		BatchBytes: DefaultUdpBatchBytes,
+	}
+}
+
+type UdpTransport struct{
+	addr string
+	c chan *Message
+	conn *net.UDPConn
+	conf UdpConfig
+	dns map[string]string
+	uaddrs map[string]*net.UDPAddr
+	// addr => messages being batched
+	batches map[string]*udpBatch
+	log *logger.Logger
+	plog *logger.PacketLog
+	mux sync.Mutex
+	// closed by Close(), readers exit
+	done chan struct{}
+	readers sync.WaitGroup
+}
+
+var udpBufs = sync.Pool{
+	New: func() interface{} {
+		buf := make([]byte, udpBufSize)
+		return &buf
+	},
+}
+
+func NewUdpTransport(ip string, port int) (*UdpTransport){
+	return NewUdpTransportWithConfig(ip, port, DefaultUdpConfig())
+}
+
+func NewUdpTransportWithConfig(ip string, port int, conf UdpConfig) (*UdpTransport){
+	def := DefaultUdpConfig()
+	if conf.Readers <= 0 {
+		conf.Readers = def.Readers
+	}
+	if conf.QueueSize <= 0 {
+		conf.QueueSize = def.QueueSize
+	}
+	if conf.ReadBuffer <= 0 {
+		conf.ReadBuffer = def.ReadBuffer
+	}
+	if conf.BatchSize <= 0 {
+		conf.BatchSize = def.BatchSize
+	}
+	if conf.BatchBytes <= 0 {
+		conf.BatchBytes = def.BatchBytes
+	}
+
+	s := fmt.Sprintf("%s:%d", ip, port)
+	addr, _ := net.ResolveUDPAddr("udp", s)
+	conn, _ := net.ListenUDP("udp", addr)
+
+	tp := new(UdpTransport)
+	tp.addr = fmt.Sprintf("%s:%d", ip, port)
+	tp.conn = conn
+	tp.conf = conf
+	tp.c = make(chan *Message, conf.QueueSize)
+	tp.done = make(chan struct{})
+	tp.dns = make(map[string]string)
+	tp.uaddrs = make(map[string]*net.UDPAddr)
+	tp.batches = make(map[string]*udpBatch)
+	tp.log = logger.New("transport").With("addr", tp.addr)
+	tp.plog = logger.NewPacketLog(tp.log)
+
+	tp.start()
+	return tp
+}
+
+func (tp *UdpTransport)SetLogger(l *logger.Logger) {
+	tp.log = l
+	tp.plog = logger.NewPacketLog(l)
+}
+
+func (tp *UdpTransport)C() chan *Message {
+	return tp.c
+}
+
+func (tp *UdpTransport)Addr() string {
+	return tp.addr
+}
+
+func (tp *UdpTransport)simulate_bad_network(delayC chan interface{}){
+	go func(){
+		const MaxDelay int = 200
+		const Interval int = 5
+		timer := time.NewTicker(time.Duration(Interval) * time.Millisecond)
+		heap := util.NewIntPriorityQueue()
+		
+		g_time := 0
+		for {
+			select {
+			case <- timer.C:
+				g_time += Interval
+				for heap.Size() > 0 {
+					w, msg := heap.Top()
+					if w > g_time {
+						break;
+					}
+					heap.Pop()
+					
+					tp.logPacket("    receive <", msg.(*Message))
+					tp.c <- msg.(*Message)
+				}
+			case msg := <- delayC:
+				delay := rand.Intn(MaxDelay) // 模拟延迟和乱序
+				heap.Push(g_time + delay, msg)
+				tp.log.Debug("delay", "ms", delay)
+			}
+		}
+	}()
+}
+
+func (tp *UdpTransport)start(){
+	// TODO: for testing
+	const SIMULATE_BAD_NETWORK bool = false
+	var delayC chan interface{}
+
+	if SIMULATE_BAD_NETWORK {
+		delayC = make(chan interface{})
+		tp.simulate_bad_network(delayC)
+	}
+	if err := tp.conn.SetReadBuffer(tp.conf.ReadBuffer); err != nil {
+		tp.log.Warn("set read buffer error", "size", tp.conf.ReadBuffer, "err", err)
+	}
+	
+	for i := 0; i < tp.conf.Readers; i ++ {
+		tp.readers.Add(1)
+		go func(){
+			defer tp.readers.Done()
+			for{
+				msgs, ok := tp.read()
+				if !ok {
+					return
+				}
+				for _, msg := range msgs {
+					if SIMULATE_BAD_NETWORK {
+						delayC <- msg
+						continue
+					}
+					tp.logPacket(" receive <", msg)
+					select {
+					case tp.c <- msg:
+					case <-tp.done:
+						return
+					}
+				}
+			}
+		}()
+	}
+}
+
+// false if conn is closed, messages of a batch, or of a single datagram
+func (tp *UdpTransport)read() ([]*Message, bool) {
+	buf := udpBufs.Get().(*[]byte)
+	defer udpBufs.Put(buf)
+
+	n, _, err := tp.conn.ReadFromUDP(*buf)
+	if err != nil {
+		select {
+		case <-tp.done:
+			return nil, false
+		default:
+		}
+		tp.log.Warn("read error", "err", err)
+		return nil, true
+	}
+	// copied by decoding, msg outlives buf
+	// sent as text, but binary ones are accepted, see Wire.go
+	msgs, err := DecodeWireBatch((*buf)[:n])
+	if err != nil {
+		tp.log.Warn("decode error", "data", string((*buf)[:n]), "err", err)
+	}
+	return msgs, true
+}
+
+// C() is closed after readers exit
+func (tp *UdpTransport)Close(){
+	close(tp.done)
+	tp.conn.Close()
+	tp.readers.Wait()
+	close(tp.c)
+}
+
+func (tp *UdpTransport)Connect(nodeId, addr string){
+	tp.mux.Lock()
+	defer tp.mux.Unlock()
+
+	tp.dns[nodeId] = addr
+}
+
+func (tp *UdpTransport)Disconnect(nodeId string){
+	tp.mux.Lock()
+	defer tp.mux.Unlock()
+
+	delete(tp.dns, nodeId)
+}
+
+// thread safe
+func (tp *UdpTransport)Send(msg *Message) bool{
+	tp.mux.Lock()
+	addr := tp.dns[msg.Dst]
+	tp.mux.Unlock()
+
+	if addr == "" {
+		tp.log.Warn("dst not connected", "peer", msg.Dst)
+		return false
+	}
+
+	uaddr := tp.resolve(addr)
+	if uaddr == nil {
+		return false
+	}
+	buf := getEncodeBuf()
+	*buf = msg.AppendEncode(*buf)
+	ret := true
+	if tp.conf.BatchWindow > 0 {
+		tp.batch(addr, uaddr, *buf)
+	} else {
+		n, _ := tp.conn.WriteToUDP(*buf, uaddr)
+		ret = n > 0
+	}
+	putEncodeBuf(buf)
+	tp.logPacket("    send >", msg)
+	return ret
+}
+
+// Messages being batched to a peer
+type udpBatch struct{
+	uaddr *net.UDPAddr
+	buf []byte
+	// the first frame, sent without batch header if alone
+	first []byte
+	n int
+}
+
+// frame is copied
+func (tp *UdpTransport)batch(addr string, uaddr *net.UDPAddr, frame []byte){
+	tp.mux.Lock()
+	defer tp.mux.Unlock()
+	b := tp.batches[addr]
+	size := wireBatchSize(frame)
+	if b != nil && len(b.buf) + size > tp.conf.BatchBytes {
+		tp.flushBatch(addr)
+		b = nil
+	}
+	if 1 + size > tp.conf.BatchBytes {
+		// too large to be batched
+		tp.conn.WriteToUDP(frame, uaddr)
+		return
+	}
+	if b == nil {
+		b = &udpBatch{uaddr: uaddr}
+		tp.batches[addr] = b
+		time.AfterFunc(tp.conf.BatchWindow, func() {
+			tp.mux.Lock()
+			defer tp.mux.Unlock()
+			if tp.batches[addr] == b {
+				tp.flushBatch(addr)
+			}
+		})
+	}
+	b.buf = appendWireBatch(b.buf, frame)
+	if b.n == 0 {
+		b.first = b.buf[len(b.buf) - len(frame):]
+	}
+	b.n ++
+	if b.n >= tp.conf.BatchSize {
+		tp.flushBatch(addr)
+	}
+}
+
+// with tp.mux held
+func (tp *UdpTransport)flushBatch(addr string){
+	b := tp.batches[addr]
+	delete(tp.batches, addr)
+	if b.n == 1 {
+		tp.conn.WriteToUDP(b.first, b.uaddr)
+	} else {
+		tp.conn.WriteToUDP(b.buf, b.uaddr)
+	}
+}
+
+// resolved once per address
+func (tp *UdpTransport)resolve(addr string) *net.UDPAddr {
+	tp.mux.Lock()
+	defer tp.mux.Unlock()
+	if uaddr := tp.uaddrs[addr]; uaddr != nil {
+		return uaddr
+	}
+	uaddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		tp.log.Warn("resolve error", "addr", addr, "err", err)
+		return nil
+	}
+	tp.uaddrs[addr] = uaddr
+	return uaddr
+}
+
+func (tp *UdpTransport)logPacket(prefix string, msg *Message) {
+	if tp.plog.Sample() {
+		tp.plog.Log(prefix, msg.Encode(), msg.Redacted)
+	}
+}
@@ -0,0 +1,102 @@
+package raft
+
+import (
+	"testing"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/fallowu/big-ssdb/logger"
+)
+
+func TestUdpTransport(t *testing.T){
+	log.SetFlags(log.LstdFlags | log.Lshortfile | log.Lmicroseconds)
+	xport := NewUdpTransport("127.0.0.1", 9000)
+
+	for {
+		msg := <-xport.C()
+		fmt.Println(msg)
+	}
+}
+
+func TestConcurrentUdpRead(t *testing.T){
+	logger.SetDefaultLevel(logger.LevelError)
+	conf := DefaultUdpConfig()
+	conf.Readers = 4
+	rx := NewUdpTransportWithConfig("127.0.0.1", 19102, conf)
+	tx := NewUdpTransport("127.0.0.1", 19101)
+	defer tx.Close()
+	tx.Connect("n2", rx.Addr())
+
+	// a burst nobody reads yet is held by the queue
+	const N = DefaultUdpQueueSize
+	for i := 0; i < N; i ++ {
+		msg := NewAppendEntryAck("n2", true)
+		msg.Src = "n1"
+		msg.PrevIndex = int64(i)
+		tx.Send(msg)
+	}
+	seen := make(map[int64]bool)
+	timeout := time.After(5 * time.Second)
+	for len(seen) < N {
+		select {
+		case msg := <-rx.C():
+			seen[msg.PrevIndex] = true
+		case <-timeout:
+			t.Fatal("received", len(seen))
+		}
+	}
+
+	rx.Close()
+	if _, ok := <-rx.C(); ok {
+		t.Fatal("C() not closed")
+	}
+}
+
+func TestBatchedUdpTransport(t *testing.T){
+	logger.SetDefaultLevel(logger.LevelError)
+	conf := DefaultUdpConfig()
+	conf.Readers = 1
+	conf.BatchWindow = 5 * time.Millisecond
+	conf.BatchSize = 8
+	rx := NewUdpTransportWithConfig("127.0.0.1", 19104, conf)
+	tx := NewUdpTransportWithConfig("127.0.0.1", 19103, conf)
+	defer rx.Close()
+	defer tx.Close()
+	tx.Connect("n2", rx.Addr())
+
+	// in order, large ones are not batched
+	const N = 100
+	for i := 0; i < N; i ++ {
+		msg := NewAppendEntryAck("n2", true)
+		msg.Src = "n1"
+		msg.PrevIndex = int64(i)
+		if i % 30 == 0 {
+			msg.Data = fmt.Sprintf("%02000d", i)
+		}
+		tx.Send(msg)
+	}
+	for i := 0; i < N; i ++ {
+		select {
+		case msg := <-rx.C():
+			if msg.PrevIndex != int64(i) {
+				t.Fatal("receive", i, msg.PrevIndex)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("received", i)
+		}
+	}
+
+	// a batch is split on receive
+	var buf []byte
+	for i := 0; i < 3; i ++ {
+		msg := NewAppendEntryAck("n2", true)
+		msg.PrevIndex = int64(i)
+		buf = appendWireBatch(buf, msg.AppendEncode(nil))
+	}
+	buf = appendWireBatch(buf, []byte("bad"))
+	msgs, err := DecodeWireBatch(buf)
+	if len(msgs) != 3 || msgs[2].PrevIndex != 2 || err == nil {
+		t.Fatal("decode batch", msgs, err)
+	}
+}
@@ -0,0 +1,186 @@
+package raft
+
+import (
+	"encoding/binary"
+	"errors"
+	"sync"
+	"sync/atomic"
+)
+
+// Messages on the wire are framed by transports. A frame of the text format
+// (Message.Encode()) is version 1 and has no header, it starts with a
+// letter of the message type. Frames of later versions start with a byte
+// of the version:
+//
+//	0x02 <protobuf Message, see raft.proto>
+//
+// Small frames to a peer may be batched into one datagram, split on receive:
+//
+//	0x10 (<uvarint size><frame>)...
+//
+// Transports negotiate the version per peer: a frame of 0x00 and the
+// highest version the sender supports(hello) is sent first on a
+// connection, text is sent to a peer until its hello is received, so nodes
+// which know text only, ignoring the hello as a bad frame, interoperate
+// during rolling upgrades.
+
+const (
+	WireVersionText = 1
+	WireVersionProto = 2
+	// highest version supported
+	WireVersion = WireVersionProto
+
+	wireHello = 0
+	wireBatch = 0x10
+)
+
+var errBadFrame = errors.New("bad frame")
+
+// frames of this process failing their checksum, see Metrics.MessagesCorrupted
+var messagesCorrupted int64
+// text frames without checksum, of old versions, see Metrics.MessagesUnverified
+var messagesUnverified int64
+
+// message encoded in version, appended to buf
+func AppendWire(buf []byte, msg *Message, version int) []byte {
+	if version < WireVersionProto {
+		return msg.AppendEncode(buf)
+	}
+	buf = append(buf, WireVersionProto)
+	return msg.AppendProto(buf)
+}
+
+func appendWireHello(buf []byte, version int) []byte {
+	return append(buf, wireHello, byte(version))
+}
+
+// Decodes a frame of any version, the hello returns version > 0 and nil msg.
+// ErrCorrupted if the checksum mismatches.
+func DecodeWire(buf []byte) (msg *Message, version int, err error) {
+	msg, version, err = decodeWire(buf)
+	if err == ErrCorrupted {
+		atomic.AddInt64(&messagesCorrupted, 1)
+	}
+	return msg, version, err
+}
+
+func decodeWire(buf []byte) (msg *Message, version int, err error) {
+	if len(buf) == 0 {
+		return nil, 0, errBadFrame
+	}
+	switch buf[0] {
+	case wireHello:
+		if len(buf) != 2 || buf[1] == 0 {
+			return nil, 0, errBadFrame
+		}
+		return nil, int(buf[1]), nil
+	case WireVersionProto:
+		msg = new(Message)
+		if err := msg.decodeProto(buf[1:]); err != nil {
+			return nil, 0, err
+		}
+		return msg, 0, nil
+	}
+	if buf[0] < ' ' {
+		// of a later version
+		return nil, 0, errBadFrame
+	}
+	msg = new(Message)
+	verified, err := msg.decodeVerified(string(buf))
+	if err != nil {
+		return nil, 0, err
+	}
+	if !verified {
+		atomic.AddInt64(&messagesUnverified, 1)
+	}
+	return msg, 0, nil
+}
+
+// frame appended to the batch in buf, the one frame batch is the frame itself
+func appendWireBatch(buf []byte, frame []byte) []byte {
+	if len(buf) == 0 {
+		buf = append(buf, wireBatch)
+	}
+	buf = binary.AppendUvarint(buf, uint64(len(frame)))
+	return append(buf, frame...)
+}
+
+// bytes frame takes in a batch
+func wireBatchSize(frame []byte) int {
+	var head [binary.MaxVarintLen64]byte
+	return binary.PutUvarint(head[:], uint64(len(frame))) + len(frame)
+}
+
+// Messages of a batch, or of a single frame. Bad frames in a batch are
+// skipped, the error of the first one is returned.
+func DecodeWireBatch(buf []byte) ([]*Message, error) {
+	if len(buf) == 0 || buf[0] != wireBatch {
+		msg, _, err := DecodeWire(buf)
+		if msg == nil {
+			return nil, err
+		}
+		return []*Message{msg}, nil
+	}
+	var ret []*Message
+	var first error
+	buf = buf[1:]
+	for len(buf) > 0 {
+		size, n := binary.Uvarint(buf)
+		if n <= 0 || size > uint64(len(buf) - n) {
+			if first == nil {
+				first = errBadFrame
+			}
+			break
+		}
+		frame := buf[n : n + int(size)]
+		buf = buf[n + int(size):]
+		msg, _, err := DecodeWire(frame)
+		if msg == nil {
+			if first == nil {
+				first = err
+				if err == nil {
+					// the hello is not batched
+					first = errBadFrame
+				}
+			}
+			continue
+		}
+		ret = append(ret, msg)
+	}
+	return ret, first
+}
+
+// Versions negotiated with peers. Thread safe.
+type wireVersions struct{
+	max int
+	mux sync.Mutex
+	// peer id => highest version both support
+	peers map[string]int
+}
+
+func newWireVersions(max int) *wireVersions {
+	if max <= 0 || max > WireVersion {
+		max = WireVersion
+	}
+	return &wireVersions{max: max, peers: make(map[string]int)}
+}
+
+// version to encode messages to peer with
+func (v *wireVersions)get(peer string) int {
+	v.mux.Lock()
+	defer v.mux.Unlock()
+	if n := v.peers[peer]; n > 0 {
+		return n
+	}
+	return WireVersionText
+}
+
+// peer supports up to version, WireVersionText if it sent no hello
+func (v *wireVersions)set(peer string, version int) {
+	if version > v.max {
+		version = v.max
+	}
+	v.mux.Lock()
+	defer v.mux.Unlock()
+	v.peers[peer] = version
+}
@@ -0,0 +1,134 @@
+package raft
+
+import (
+	"encoding/hex"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/fallowu/big-ssdb/logger"
+)
+
+func TestProtoEncoding(t *testing.T){
+	msg := &Message{Type: MessageTypePropose, Src: "n2", Dst: "n1", Term: 4, PrevIndex: 1, Data: "a\r\nb"}
+	if s := hex.EncodeToString(msg.AppendProto(nil)); s != "0a0750726f706f736512026e321a026e31200430013a04610d0a627dfdcde39a" {
+		t.Fatal("encode", s)
+	}
+
+	for _, g := range goldenMessages {
+		buf := AppendWire(nil, g.msg, WireVersionProto)
+		msg, _, err := DecodeWire(buf)
+		if err != nil || !reflect.DeepEqual(msg, g.msg) {
+			t.Errorf("decode %s: %+v %v", g.msg.Type, msg, err)
+		}
+		// text is understood by both
+		msg, _, err = DecodeWire(AppendWire(nil, g.msg, WireVersionText))
+		if err != nil || !reflect.DeepEqual(msg, g.msg) {
+			t.Errorf("decode text %s: %+v %v", g.msg.Type, msg, err)
+		}
+	}
+	for _, g := range goldenEntries {
+		var ent Entry
+		if !ent.DecodeProto(g.ent.AppendProto(nil)) || !reflect.DeepEqual(&ent, g.ent) {
+			t.Errorf("decode %s: %+v", g.ent.Type, ent)
+		}
+	}
+	for _, g := range goldenSnapshots {
+		sn := newSnapshot()
+		if !sn.DecodeProto(g.sn.AppendProto(nil)) || !reflect.DeepEqual(sn, g.sn) {
+			t.Errorf("decode snapshot: %+v", sn)
+		}
+	}
+
+	// members larger than a byte of size
+	state := NewState()
+	state.Members["n1"] = strings.Repeat("a", 300)
+	state.Members["n2"] = "addr2"
+	got := NewState()
+	if !got.DecodeProto(state.AppendProto(nil)) || !reflect.DeepEqual(got, state) {
+		t.Fatal("decode state", got)
+	}
+
+	// checksum mismatch
+	buf := AppendWire(nil, msg, WireVersionProto)
+	buf[len(buf) - 6] ^= 1
+	if _, _, err := DecodeWire(buf); err != ErrCorrupted {
+		t.Fatal("decode corrupted", err)
+	}
+	// text of old versions, not verified
+	unverified := atomic.LoadInt64(&messagesUnverified)
+	if m, _, err := DecodeWire([]byte("None n1 n2 3 0 0 ")); err != nil || m.Type != MessageTypeNone {
+		t.Fatal("decode legacy", err)
+	}
+	if n := atomic.LoadInt64(&messagesUnverified); n <= unverified {
+		t.Fatal("unverified", n)
+	}
+
+	for _, bad := range []string{"", "\x02", "\x02\x0a\x07Pro", "\x03abc", "\x00", "\x02\x20\x04"} {
+		if _, _, err := DecodeWire([]byte(bad)); err == nil {
+			t.Errorf("decode %q", bad)
+		}
+	}
+}
+
+func TestWireNegotiation(t *testing.T){
+	logger.SetDefaultLevel(logger.LevelError)
+	tps := map[string]*TcpTransport{}
+	for _, id := range []string{"n1", "n2", "n3"} {
+		conf := DefaultTcpConfig()
+		if id == "n2" {
+			// as a node before the negotiation, sends no hello
+			conf.WireVersion = WireVersionText
+		}
+		tp, err := NewTcpTransportWithConfig("127.0.0.1", 0, conf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer tp.Close()
+		tps[id] = tp
+	}
+	for id, tp := range tps {
+		for peer, p := range tps {
+			if peer != id {
+				tp.Connect(peer, p.Addr())
+			}
+		}
+	}
+	send := func(src, dst string) {
+		msg := NewAppendEntryAck(dst, true)
+		msg.Src = src
+		msg.Data = "k\x00\xff"
+		if !tps[src].Send(msg) {
+			t.Fatal("send", src, dst)
+		}
+		got := recvTcp(t, tps[dst])
+		if !reflect.DeepEqual(got, msg) {
+			t.Fatal("receive", src, dst, got)
+		}
+	}
+	for i := 0; i < 2; i ++ {
+		for src := range tps {
+			for dst := range tps {
+				if src != dst {
+					send(src, dst)
+				}
+			}
+		}
+	}
+
+	if v := tps["n1"].versions.get("n3"); v != WireVersionProto {
+		t.Fatal("n1 => n3", v)
+	}
+	if v := tps["n3"].versions.get("n1"); v != WireVersionProto {
+		t.Fatal("n3 => n1", v)
+	}
+	for _, id := range []string{"n1", "n3"} {
+		if v := tps[id].versions.get("n2"); v != WireVersionText {
+			t.Fatal(id, "=> n2", v)
+		}
+		if v := tps["n2"].versions.get(id); v != WireVersionText {
+			t.Fatal("n2 =>", id, v)
+		}
+	}
+}
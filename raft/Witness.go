@@ -0,0 +1,32 @@
+package raft
+
+// A witness votes and acks entries, counted in election and commit quorums,
+// but keeps no data: Data entries are written with Data stripped, only term,
+// index and type are kept, so that it still compares logs when voting. It
+// never campaigns, has no priority, has no Service, and replies witnessHash to consistency
+// and log checks, which leader does not count as divergent. For 2+1 groups
+// where the third member is a small tie-breaker. An entry committed by
+// leader and witness only is lost to the other member, which then can not be
+// elected until leader is back: the witness rejects its shorter log.
+
+const witnessHash = "witness"
+
+// The node runs as a witness, e.g. big-ssdb server -witness. Not replicated,
+// a full member restarted as witness drops data of entries written after.
+func WithWitness() Option {
+	return func(opts *options) {
+		opts.witness = true
+	}
+}
+
+func (node *Node)IsWitness() bool {
+	return node.witness
+}
+
+// entry as written by a witness
+func (st *Storage)witnessEntry(ent Entry) Entry {
+	if st.node.witness && ent.Type == EntryTypeData {
+		ent.Data = ""
+	}
+	return ent
+}
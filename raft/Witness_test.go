@@ -0,0 +1,133 @@
+package raft
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/fallowu/big-ssdb/logger"
+)
+
+// 2+1: entries commit with the witness, which keeps no data and is never
+// elected
+func TestWitness(t *testing.T){
+	logger.SetDefaultLevel(logger.LevelError)
+	s := newMemSim(t, 1, "n1", "n2", "n3")
+	tp := s.tps["n3"]
+	n3 := NewNode("n3", tp.Addr(), NewMemDb(), WithClock(s.clock), WithManualTick(), WithRandSeed(3), WithWitness())
+	n3.SetOutbox(func(msg *Message) { tp.Send(msg) })
+	s.nodes["n3"] = n3
+	s.net.SetDefault(MemLinkConfig{Delay: time.Millisecond})
+
+	if _, err := n3.AddMember("n3", "addr-n3"); err == nil {
+		t.Fatal("witness bootstraps")
+	}
+	n1, n2 := s.nodes["n1"], s.nodes["n2"]
+	n1.AddMember("n1", "addr-n1")
+	n1.StepTick(0)
+	for _, id := range []string{"n2", "n3"} {
+		index, _ := n1.AddMember(id, "addr-" + id)
+		s.nodes[id].JoinGroup("n1", "addr-n1")
+		s.until(10 * time.Second, "join " + id, func() bool {
+			return s.nodes[id].Metrics().CommitIndex >= index
+		})
+	}
+
+	// committed by n1 and n3
+	s.net.Isolate("n2", "n1", "n3")
+	_, index, err := n1.Propose("set k v")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.until(10 * time.Second, "commit", func() bool {
+		return n1.Metrics().CommitIndex >= index && n3.Metrics().CommitIndex >= index
+	})
+	if ent := n3.store.GetEntry(index); ent == nil || ent.Data != "" || ent.Type != EntryTypeData {
+		t.Fatal("witness entry", ent)
+	}
+	if !n3.Status().Witness || n3.InfoMap()["witness"] != "true" {
+		t.Fatal(n3.Info())
+	}
+	s.net.Heal()
+	s.until(10 * time.Second, "replicate", func() bool {
+		return s.converged(index)
+	})
+	var report *LogCheckReport
+	done := n1.CheckLog(0)
+	s.until(10 * time.Second, "log check", func() bool {
+		select {
+		case report = <-done:
+			return true
+		default:
+			return false
+		}
+	})
+	if !report.Ok() {
+		t.Fatal("log check", report.Encode())
+	}
+
+	// n2 is elected with the witness' vote, the witness never
+	s.net.Isolate("n1", "n2", "n3")
+	s.until(30 * time.Second, "elect", func() bool {
+		return s.leader("n2") != ""
+	})
+	for i := 0; i < 5; i ++ {
+		if _, _, err := n2.Propose(fmt.Sprintf("set k%d v", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	index = n2.Metrics().LastIndex
+	s.until(10 * time.Second, "commit by n2", func() bool {
+		return n2.Metrics().CommitIndex >= index
+	})
+	s.net.Isolate("n2", "n3")
+	for i := 0; i < 500; i ++ {
+		s.step()
+		if n3.Metrics().Role != RoleFollower {
+			t.Fatal("witness campaigns", s.dump())
+		}
+	}
+}
+
+// a witness of the highest priority does not deny PreVote, the other member
+// is elected
+func TestWitnessPriority(t *testing.T){
+	logger.SetDefaultLevel(logger.LevelError)
+	s := newMemSim(t, 1, "n1", "n2", "n3")
+	tp := s.tps["n3"]
+	n3 := NewNode("n3", tp.Addr(), NewMemDb(), WithClock(s.clock), WithManualTick(), WithRandSeed(3),
+		WithWitness(), WithPriority(10))
+	n3.SetOutbox(func(msg *Message) { tp.Send(msg) })
+	s.nodes["n3"] = n3
+	s.net.SetDefault(MemLinkConfig{Delay: time.Millisecond})
+	if n3.Status().Priority != 0 {
+		t.Fatal("witness priority", n3.Info())
+	}
+
+	n1 := s.nodes["n1"]
+	n1.AddMember("n1", "addr-n1")
+	n1.StepTick(0)
+	for _, id := range []string{"n2", "n3"} {
+		index, _ := n1.AddMember(id, "addr-" + id)
+		s.nodes[id].JoinGroup("n1", "addr-n1")
+		s.until(10 * time.Second, "join " + id, func() bool {
+			return s.nodes[id].Metrics().CommitIndex >= index
+		})
+	}
+	// replicated by leader, which does not know it is a witness
+	index, err := n1.SetPriority("n3", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.until(10 * time.Second, "set priority", func() bool {
+		return s.converged(index)
+	})
+	if _, err := n3.SetPriority("n3", 5); err != ErrWitness {
+		t.Fatal(err)
+	}
+
+	s.net.Isolate("n1", "n2", "n3")
+	s.until(30 * time.Second, "elect", func() bool {
+		return s.leader("n2") != ""
+	})
+}
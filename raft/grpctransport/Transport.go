@@ -0,0 +1,516 @@
+//go:build grpc
+
+// Raft transport over gRPC, built with -tags grpc. Registered as "grpc",
+// see raft.RegisterTransport().
+//
+// Each node streams messages to a peer by a long lived AppendEntries client
+// stream, InstallSnapshot messages are sent by their own InstallSnapshot
+// stream in chunks, with a deadline propagated to the receiver, so that
+// large snapshots don't hold heartbeats back. Messages are raft wire frames
+// (see raft/Wire.go), carried by a codec of raw bytes instead of generated
+// code. Connections are checked by keepalive and the standard health
+// service before a broken stream is opened again.
+package grpctransport
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/keepalive"
+
+	"github.com/fallowu/big-ssdb/logger"
+	"github.com/fallowu/big-ssdb/raft"
+)
+
+const (
+	DefaultQueueSize = 256
+	// per peer
+	DefaultSendQueueSize = 1024
+	DefaultDialTimeout = 3 * time.Second
+	// of an InstallSnapshot stream, the receiver gives up after it too
+	DefaultSnapshotTimeout = 60 * time.Second
+	DefaultSnapshotChunkSize = 1024 * 1024
+	DefaultKeepalive = 10 * time.Second
+	minBackoff = 100 * time.Millisecond
+	maxBackoff = 5 * time.Second
+
+	serviceName = "raft.Transport"
+	codecName = "raft-frame"
+)
+
+type Config struct{
+	// capacity of C()
+	QueueSize int
+	// messages queued for a peer, Send() fails when full
+	SendQueueSize int
+	DialTimeout time.Duration
+	SnapshotTimeout time.Duration
+	SnapshotChunkSize int
+	// interval of keepalive pings
+	Keepalive time.Duration
+	// nil for plaintext, used to listen and to dial
+	TLS *tls.Config
+}
+
+func DefaultConfig() Config {
+	return Config{
+		QueueSize: DefaultQueueSize,
+		SendQueueSize: DefaultSendQueueSize,
+		DialTimeout: DefaultDialTimeout,
+		SnapshotTimeout: DefaultSnapshotTimeout,
+		SnapshotChunkSize: DefaultSnapshotChunkSize,
+		Keepalive: DefaultKeepalive,
+	}
+}
+
+// an encoded message, or a chunk of one
+type frame struct{
+	data []byte
+}
+
+// passes frames as they are
+type frameCodec struct{}
+
+func (frameCodec)Marshal(v interface{}) ([]byte, error) {
+	f, ok := v.(*frame)
+	if !ok {
+		return nil, fmt.Errorf("unexpected message %T", v)
+	}
+	return f.data, nil
+}
+
+func (frameCodec)Unmarshal(data []byte, v interface{}) error {
+	f, ok := v.(*frame)
+	if !ok {
+		return fmt.Errorf("unexpected message %T", v)
+	}
+	f.data = append(f.data[:0], data...)
+	return nil
+}
+
+func (frameCodec)Name() string {
+	return codecName
+}
+
+type transportServer interface{
+	serveAppendEntries(stream grpc.ServerStream) error
+	serveInstallSnapshot(stream grpc.ServerStream) error
+}
+
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: serviceName,
+	HandlerType: (*transportServer)(nil),
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName: "AppendEntries",
+			Handler: func(srv interface{}, stream grpc.ServerStream) error {
+				return srv.(transportServer).serveAppendEntries(stream)
+			},
+			ClientStreams: true,
+		},
+		{
+			StreamName: "InstallSnapshot",
+			Handler: func(srv interface{}, stream grpc.ServerStream) error {
+				return srv.(transportServer).serveInstallSnapshot(stream)
+			},
+			ClientStreams: true,
+		},
+	},
+}
+
+type Transport struct{
+	addr string
+	conf Config
+	ln net.Listener
+	server *grpc.Server
+	c chan *raft.Message
+	log *logger.Logger
+	mux sync.Mutex
+	peers map[string]*peer
+	// closed by Close(), goroutines exit
+	done chan struct{}
+	wg sync.WaitGroup
+}
+
+type peer struct{
+	id string
+	addr string
+	conn *grpc.ClientConn
+	q chan []byte
+	snapshots chan []byte
+	// closed by Disconnect()
+	done chan struct{}
+}
+
+var _ raft.Transport = (*Transport)(nil)
+
+func init() {
+	encoding.RegisterCodec(frameCodec{})
+	raft.RegisterTransport("grpc", func(opts raft.TransportOptions) (raft.Transport, error) {
+		conf := DefaultConfig()
+		if opts.Param != nil {
+			if cert := opts.Param("TLS_CERT"); cert != "" {
+				mutual := opts.Param("TLS_MUTUAL") != ""
+				var err error
+				conf.TLS, err = raft.LoadTLSConfig(cert, opts.Param("TLS_KEY"), opts.Param("TLS_CA"), mutual)
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+		return NewTransport(opts.Host, opts.Port, conf)
+	})
+}
+
+// port 0 listens on a random port, see Addr()
+func NewTransport(ip string, port int, conf Config) (*Transport, error) {
+	def := DefaultConfig()
+	if conf.QueueSize <= 0 {
+		conf.QueueSize = def.QueueSize
+	}
+	if conf.SendQueueSize <= 0 {
+		conf.SendQueueSize = def.SendQueueSize
+	}
+	if conf.DialTimeout <= 0 {
+		conf.DialTimeout = def.DialTimeout
+	}
+	if conf.SnapshotTimeout <= 0 {
+		conf.SnapshotTimeout = def.SnapshotTimeout
+	}
+	if conf.SnapshotChunkSize <= 0 {
+		conf.SnapshotChunkSize = def.SnapshotChunkSize
+	}
+	if conf.Keepalive <= 0 {
+		conf.Keepalive = def.Keepalive
+	}
+	ln, err := net.Listen("tcp", net.JoinHostPort(ip, fmt.Sprint(port)))
+	if err != nil {
+		return nil, err
+	}
+
+	tp := new(Transport)
+	tp.addr = ln.Addr().String()
+	tp.conf = conf
+	tp.ln = ln
+	tp.c = make(chan *raft.Message, conf.QueueSize)
+	tp.peers = make(map[string]*peer)
+	tp.done = make(chan struct{})
+	tp.log = logger.New("transport").With("addr", tp.addr)
+
+	var opts []grpc.ServerOption
+	if conf.TLS != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(conf.TLS)))
+	}
+	opts = append(opts, grpc.KeepaliveParams(keepalive.ServerParameters{
+		Time: conf.Keepalive,
+		Timeout: conf.DialTimeout,
+	}), grpc.KeepaliveEnforcementPolicy(keepalive.EnforcementPolicy{
+		MinTime: conf.Keepalive / 2,
+		PermitWithoutStream: true,
+	}))
+	tp.server = grpc.NewServer(opts...)
+	tp.server.RegisterService(&serviceDesc, tp)
+	hs := health.NewServer()
+	hs.SetServingStatus(serviceName, healthpb.HealthCheckResponse_SERVING)
+	healthpb.RegisterHealthServer(tp.server, hs)
+
+	go tp.server.Serve(ln)
+	return tp, nil
+}
+
+func (tp *Transport)Addr() string {
+	return tp.addr
+}
+
+func (tp *Transport)C() chan *raft.Message {
+	return tp.c
+}
+
+// C() is closed after goroutines exit
+func (tp *Transport)Close() {
+	tp.mux.Lock()
+	close(tp.done)
+	for _, p := range tp.peers {
+		p.conn.Close()
+	}
+	tp.mux.Unlock()
+	tp.server.Stop()
+	tp.wg.Wait()
+	close(tp.c)
+}
+
+func (tp *Transport)Connect(nodeId string, addr string) {
+	tp.mux.Lock()
+	defer tp.mux.Unlock()
+
+	if p := tp.peers[nodeId]; p != nil {
+		if p.addr == addr {
+			return
+		}
+		close(p.done)
+		p.conn.Close()
+	}
+	creds := insecure.NewCredentials()
+	if tp.conf.TLS != nil {
+		creds = credentials.NewTLS(tp.conf.TLS)
+	}
+	// connects lazily, and again when broken. frameCodec is set by the
+	// streams, not as default, the health check needs proto
+	conn, err := grpc.Dial(addr,
+		grpc.WithTransportCredentials(creds),
+		grpc.WithKeepaliveParams(keepalive.ClientParameters{
+			Time: tp.conf.Keepalive,
+			Timeout: tp.conf.DialTimeout,
+			PermitWithoutStream: true,
+		}),
+	)
+	if err != nil {
+		tp.log.Warn("dial error", "peer", nodeId, "addr", addr, "err", err)
+		delete(tp.peers, nodeId)
+		return
+	}
+	p := &peer{
+		id: nodeId,
+		addr: addr,
+		conn: conn,
+		q: make(chan []byte, tp.conf.SendQueueSize),
+		snapshots: make(chan []byte, 1),
+		done: make(chan struct{}),
+	}
+	tp.peers[nodeId] = p
+	tp.wg.Add(2)
+	go tp.runPeer(p)
+	go tp.runSnapshots(p)
+}
+
+func (tp *Transport)Disconnect(nodeId string) {
+	tp.mux.Lock()
+	defer tp.mux.Unlock()
+
+	if p := tp.peers[nodeId]; p != nil {
+		close(p.done)
+		p.conn.Close()
+		delete(tp.peers, nodeId)
+	}
+}
+
+// thread safe, false if dst is not connected or its queue is full
+func (tp *Transport)Send(msg *raft.Message) bool {
+	tp.mux.Lock()
+	p := tp.peers[msg.Dst]
+	tp.mux.Unlock()
+
+	if p == nil {
+		tp.log.Warn("dst not connected", "peer", msg.Dst)
+		return false
+	}
+	data := raft.AppendWire(nil, msg, raft.WireVersionProto)
+	q := p.q
+	if msg.Type == raft.MessageTypeInstallSnapshot {
+		q = p.snapshots
+	}
+	select {
+	case q <- data:
+		return true
+	default:
+		tp.log.Warn("send queue full, drop message", "peer", msg.Dst, "type", msg.Type)
+		return false
+	}
+}
+
+// true if p is being closed
+func (tp *Transport)closed(p *peer) bool {
+	select {
+	case <-p.done:
+		return true
+	case <-tp.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// false if p is closed meanwhile
+func (tp *Transport)sleep(p *peer, d time.Duration) bool {
+	select {
+	case <-time.After(d):
+		return true
+	case <-p.done:
+		return false
+	case <-tp.done:
+		return false
+	}
+}
+
+// health of p's server, checked before a stream is opened again
+func (tp *Transport)healthy(p *peer) error {
+	ctx, cancel := context.WithTimeout(context.Background(), tp.conf.DialTimeout)
+	defer cancel()
+	res, err := healthpb.NewHealthClient(p.conn).Check(ctx, &healthpb.HealthCheckRequest{Service: serviceName})
+	if err != nil {
+		return err
+	}
+	if res.Status != healthpb.HealthCheckResponse_SERVING {
+		return fmt.Errorf("peer %s", res.Status)
+	}
+	return nil
+}
+
+// streams queued frames to p, opens the stream again when broken
+func (tp *Transport)runPeer(p *peer) {
+	defer tp.wg.Done()
+	backoff := minBackoff
+	// sent when the stream breaks, sent on the next one
+	var pending []byte
+	for !tp.closed(p) {
+		err := tp.healthy(p)
+		if err == nil {
+			pending, err = tp.stream(p, pending)
+			if err == nil {
+				return
+			}
+		}
+		tp.log.Debug("stream error", "peer", p.id, "addr", p.addr, "err", err)
+		if !tp.sleep(p, backoff) {
+			return
+		}
+		backoff = min(backoff * 2, maxBackoff)
+	}
+}
+
+// nil error when p is closed, otherwise the frame not sent
+func (tp *Transport)stream(p *peer, pending []byte) ([]byte, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream, err := p.conn.NewStream(ctx, &serviceDesc.Streams[0], "/" + serviceName + "/AppendEntries",
+		grpc.CallContentSubtype(codecName))
+	if err != nil {
+		return pending, err
+	}
+	tp.log.Info("stream opened", "peer", p.id, "addr", p.addr)
+	for {
+		data := pending
+		if data == nil {
+			select {
+			case data = <-p.q:
+			case <-stream.Context().Done():
+				return nil, stream.Context().Err()
+			case <-p.done:
+				stream.CloseSend()
+				return nil, nil
+			case <-tp.done:
+				stream.CloseSend()
+				return nil, nil
+			}
+		}
+		if err := stream.SendMsg(&frame{data}); err != nil {
+			return data, err
+		}
+		pending = nil
+	}
+}
+
+// sends InstallSnapshot messages of p one after another, each by its own
+// stream, given up after SnapshotTimeout. Lost ones are resent by the leader.
+func (tp *Transport)runSnapshots(p *peer) {
+	defer tp.wg.Done()
+	for {
+		var data []byte
+		select {
+		case data = <-p.snapshots:
+		case <-p.done:
+			return
+		case <-tp.done:
+			return
+		}
+		if err := tp.sendSnapshot(p, data); err != nil {
+			tp.log.Info("send snapshot error", "peer", p.id, "size", len(data), "err", err)
+		}
+	}
+}
+
+func (tp *Transport)sendSnapshot(p *peer, data []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), tp.conf.SnapshotTimeout)
+	defer cancel()
+	stream, err := p.conn.NewStream(ctx, &serviceDesc.Streams[1], "/" + serviceName + "/InstallSnapshot",
+		grpc.CallContentSubtype(codecName))
+	if err != nil {
+		return err
+	}
+	for len(data) > 0 {
+		n := min(len(data), tp.conf.SnapshotChunkSize)
+		if err := stream.SendMsg(&frame{data[:n]}); err != nil {
+			return err
+		}
+		data = data[n:]
+	}
+	if err := stream.CloseSend(); err != nil {
+		return err
+	}
+	// the receiver's reply, once it has the whole message
+	return stream.RecvMsg(&frame{})
+}
+
+func (tp *Transport)deliver(ctx context.Context, data []byte) error {
+	msg, _, err := raft.DecodeWire(data)
+	if err != nil || msg == nil {
+		tp.log.Warn("decode error", "size", len(data), "err", err)
+		return nil
+	}
+	select {
+	case tp.c <- msg:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-tp.done:
+		return errors.New("transport closed")
+	}
+}
+
+func (tp *Transport)serveAppendEntries(stream grpc.ServerStream) error {
+	for {
+		var f frame
+		err := stream.RecvMsg(&f)
+		if err == io.EOF {
+			return stream.SendMsg(&frame{})
+		}
+		if err != nil {
+			return err
+		}
+		if err := tp.deliver(stream.Context(), f.data); err != nil {
+			return err
+		}
+	}
+}
+
+// chunks until the sender closes, within the sender's deadline
+func (tp *Transport)serveInstallSnapshot(stream grpc.ServerStream) error {
+	var data []byte
+	for {
+		var f frame
+		err := stream.RecvMsg(&f)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		data = append(data, f.data...)
+	}
+	if err := tp.deliver(stream.Context(), data); err != nil {
+		return err
+	}
+	return stream.SendMsg(&frame{})
+}
@@ -0,0 +1,70 @@
+//go:build grpc
+
+package grpctransport
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fallowu/big-ssdb/logger"
+	"github.com/fallowu/big-ssdb/raft"
+)
+
+func recv(t *testing.T, tp *Transport) *raft.Message {
+	t.Helper()
+	select {
+	case msg := <-tp.C():
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatal("receive timeout")
+		return nil
+	}
+}
+
+// messages in order on the stream, a snapshot of several chunks by its own
+func TestLoopback(t *testing.T){
+	logger.SetDefaultLevel(logger.LevelError)
+	conf := DefaultConfig()
+	conf.SnapshotChunkSize = 1000
+	rx, err := NewTransport("127.0.0.1", 0, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rx.Close()
+	tx, err := NewTransport("127.0.0.1", 0, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Close()
+	tx.Connect("n2", rx.Addr())
+
+	for i := 0; i < 100; i ++ {
+		msg := raft.NewAppendEntryAck("n2", true)
+		msg.Src = "n1"
+		msg.PrevIndex = int64(i)
+		if !tx.Send(msg) {
+			t.Fatal("send", i)
+		}
+	}
+	for i := 0; i < 100; i ++ {
+		if msg := recv(t, rx); msg.Src != "n1" || msg.PrevIndex != int64(i) {
+			t.Fatal("receive", i, msg.Encode())
+		}
+	}
+
+	data := strings.Repeat("snapshot", 1000)
+	msg := raft.NewInstallSnapshotMsg("n2", data)
+	msg.Src = "n1"
+	if !tx.Send(msg) {
+		t.Fatal("send snapshot")
+	}
+	if got := recv(t, rx); got.Type != raft.MessageTypeInstallSnapshot || got.Data != data {
+		t.Fatal("snapshot", got.Type, len(got.Data))
+	}
+
+	tx.Disconnect("n2")
+	if tx.Send(raft.NewAppendEntryAck("n2", true)) {
+		t.Fatal("sent after disconnect")
+	}
+}
@@ -0,0 +1,62 @@
+package store
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/fallowu/big-ssdb/raft"
+)
+
+// Opens a raft.Db in dir
+type DbOpener func(dir string) (raft.Db, error)
+
+var backends = struct{
+	mux sync.Mutex
+	m map[string]DbOpener
+}{m: make(map[string]DbOpener)}
+
+// Makes a Db backend available to OpenDb() by name, usually called in init()
+// of the package implementing it. "kv", KVStore, and "mem", raft.MemDb, are
+// built in.
+func RegisterDb(name string, f DbOpener) {
+	backends.mux.Lock()
+	defer backends.mux.Unlock()
+	backends.m[name] = f
+}
+
+func OpenDb(name string, dir string) (raft.Db, error) {
+	backends.mux.Lock()
+	f := backends.m[name]
+	backends.mux.Unlock()
+	if f == nil {
+		return nil, fmt.Errorf("unknown db: %s, registered: %v", name, DbNames())
+	}
+	return f(dir)
+}
+
+// sorted
+func DbNames() []string {
+	backends.mux.Lock()
+	defer backends.mux.Unlock()
+	var ret []string
+	for name := range backends.m {
+		ret = append(ret, name)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+func init() {
+	RegisterDb("kv", func(dir string) (raft.Db, error) {
+		db := OpenKVStore(dir)
+		if db == nil {
+			return nil, fmt.Errorf("open KVStore: %s", dir)
+		}
+		return db, nil
+	})
+	// nothing in dir, lost on restart
+	RegisterDb("mem", func(dir string) (raft.Db, error) {
+		return raft.NewMemDb(), nil
+	})
+}
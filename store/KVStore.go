@@ -2,22 +2,28 @@ package store
 
 import (
 	"os"
-	"log"
 	"fmt"
 	"sort"
 	"path/filepath"
-	"util"
+	"github.com/fallowu/big-ssdb/raft"
+	"github.com/fallowu/big-ssdb/logger"
+	"github.com/fallowu/big-ssdb/internal/util"
 )
 
+var defaultLog = logger.New("store")
+
 // kvdb
 type KVStore struct{
 	dir string
 	mm map[string]string
 	wal *WalFile
+	log *logger.Logger
 
 	wal_cur string
 	wal_old string
 	wal_tmp string
+	// of CleanAll(), returned by the next Fsync()
+	err error
 }
 
 func OpenKVStore(dir string) *KVStore{
@@ -28,10 +34,11 @@ func OpenKVStore(dir string) *KVStore{
 	if !util.IsDir(dir) {
 		return nil
 	}
-	log.Println("Open KVStore", dir)
+	defaultLog.Info("open KVStore", "dir", dir)
 
 	db := new(KVStore)
 	db.dir = dir
+	db.log = defaultLog.With("dir", dir)
 	db.mm = make(map[string]string)
 	
 	if !db.recover() {
@@ -41,6 +48,10 @@ func OpenKVStore(dir string) *KVStore{
 	return db
 }
 
+func (db *KVStore)SetLogger(l *logger.Logger){
+	db.log = l
+}
+
 func (db *KVStore)Close(){
 	if db.wal != nil {
 		db.wal.Close()
@@ -92,7 +103,7 @@ func (db *KVStore)compactWalToOldFile(){
 }
 
 func (db *KVStore)loadWalFile(fn string){
-	log.Println("    load", fn)
+	db.log.Info("load", "file", fn)
 	wal := OpenWalFile(fn)
 	defer wal.Close()
 
@@ -102,7 +113,7 @@ func (db *KVStore)loadWalFile(fn string){
 	for wal.Next() {
 		r := wal.Item()
 		if !ent.Decode(r) {
-			log.Println("bad record:", r)
+			db.log.Warn("bad record", "record", r)
 			continue
 		}
 		switch ent.Cmd {
@@ -115,6 +126,10 @@ func (db *KVStore)loadWalFile(fn string){
 }
 
 func (db *KVStore)Fsync() error {
+	if err := db.err; err != nil {
+		db.err = nil
+		return err
+	}
 	return db.wal.Fsync()
 }
 
@@ -123,6 +138,15 @@ func (db *KVStore)All() map[string]string {
 	return db.mm
 }
 
+// keys are sorted on every call, the data set is in memory
+func (db *KVStore)Iterate(start string, end string, fn func(key string, val string) bool) {
+	util.IterateMap(db.mm, start, end, fn)
+}
+
+func (db *KVStore)ApproximateSize(start string, end string) int64 {
+	return util.MapRangeSize(db.mm, start, end)
+}
+
 func (db *KVStore)Get(key string) string{
 	v, _ := db.mm[key]
 	return v
@@ -134,37 +158,40 @@ func (db *KVStore)Set(key string, val string){
 	db.mm[key] = val
 }
 
-func (db *KVStore)Del(key string){
+func (db *KVStore)Delete(key string){
 	r := fmt.Sprintf("del %s", key);
 	db.wal.Append(r)
 	delete(db.mm, key)
 }
 
+// records are appended one by one, a crash may leave a prefix of b, made
+// durable by Fsync() as single writes are
+func (db *KVStore)WriteBatch(b *raft.Batch){
+	for _, op := range b.Ops {
+		if op.Delete {
+			db.Delete(op.Key)
+		} else {
+			db.Set(op.Key, op.Val)
+		}
+	}
+}
+
 /* ################################################ */
 
 func (db *KVStore)CleanAll() {
-	log.Println("Clean KVStore", db.dir)
+	db.log.Info("clean KVStore")
 
 	db.mm = make(map[string]string)
 	db.wal.Close()
 	
 	// TODO: atomic
-	if util.FileExists(db.wal_old) {
-		err := os.Remove(db.wal_old)
-		if err != nil {
-			log.Fatal(err)
-		}
-	}
-	if util.FileExists(db.wal_cur) {
-		err := os.Remove(db.wal_cur)
-		if err != nil {
-			log.Fatal(err)
+	for _, fn := range []string{db.wal_old, db.wal_cur, db.wal_tmp} {
+		if !util.FileExists(fn) {
+			continue
 		}
-	}
-	if util.FileExists(db.wal_tmp) {
-		err := os.Remove(db.wal_tmp)
-		if err != nil {
-			log.Fatal(err)
+		if err := os.Remove(fn); err != nil && db.err == nil {
+			db.log.Error("clean KVStore", "err", err)
+			db.err = err
 		}
 	}
 	db.wal = OpenWalFile(db.wal_cur)
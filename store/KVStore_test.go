@@ -0,0 +1,81 @@
+package store
+
+import (
+	"log"
+	// "fmt"
+	"testing"
+	// "os"
+
+	"github.com/fallowu/big-ssdb/raft"
+)
+
+func TestKVStore(t *testing.T){
+	log.SetFlags(log.LstdFlags | log.Lshortfile | log.Lmicroseconds)
+
+	db := OpenKVStore("./tmp/kvdb")
+	defer db.Close()
+
+	db.Set("a", "1")
+	db.Set("b", "2")
+	db.Set("c", "3")
+	db.Set("d", "4")
+
+	log.Println(db.Get("b"))
+	db.Delete("b")
+	log.Println(db.Get("b"))
+	db.Delete("x")
+
+	// for i:=0; i<100; i++ {
+	// 	k := fmt.Sprintf("k%d", i)
+	// 	v := fmt.Sprintf("%d", i)
+	// 	db.Set(k, v)
+	// }
+}
+
+func TestKVStoreIterate(t *testing.T){
+	db := OpenKVStore(t.TempDir())
+	defer db.Close()
+
+	b := raft.NewBatch()
+	b.Set("log#001", "a")
+	b.Set("log#002", "b")
+	b.Set("log#003", "c")
+	b.Set("@State", "s")
+	b.Delete("log#002")
+	db.WriteBatch(b)
+
+	var keys []string
+	db.Iterate("log#", raft.PrefixEnd("log#"), func(k string, v string) bool {
+		keys = append(keys, k)
+		return true
+	})
+	if len(keys) != 2 || keys[0] != "log#001" || keys[1] != "log#003" {
+		t.Fatal("bad iterate", keys)
+	}
+	if n := db.ApproximateSize("log#", raft.PrefixEnd("log#")); n != 16 {
+		t.Fatal("bad size", n)
+	}
+}
+
+// "kv" is built in, KVStore is iterated by Iterate()
+func TestOpenDb(t *testing.T){
+	db, err := OpenDb("kv", t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.Set("a", "1")
+	db.Set("b", "2")
+	db.Set("c", "3")
+	it := raft.NewIterator(db, "b", "")
+	var keys []string
+	for it.Next() {
+		keys = append(keys, it.Key() + "=" + it.Val())
+	}
+	if it.Close() != nil || len(keys) != 2 || keys[0] != "b=2" || keys[1] != "c=3" {
+		t.Fatal("bad iterator", keys)
+	}
+	if _, err := OpenDb("none", t.TempDir()); err == nil {
+		t.Fatal("unknown db")
+	}
+}
@@ -0,0 +1,440 @@
+package store
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/fallowu/big-ssdb/raft"
+	"github.com/fallowu/big-ssdb/logger"
+	"github.com/fallowu/big-ssdb/internal/util"
+)
+
+// LogStore 是一种特殊的数据库, 数据以滑动窗口的方式保存和淘汰.
+// 日志保存在多个文件中, 淘汰时以文件为单位.
+//
+// Records of continuous indexes are appended to segment files, named by the
+// index of their first record, a new segment is started once one reaches
+// SegmentSize. A record is "<size:4><crc32c:4><data>", offsets are indexed
+// in memory when opened. A torn record at the end of the last segment, left
+// by a crash, is cut off. A segment is fsynced before the next one is
+// started, a torn one before it, left by old versions, is cut off with the
+// segments after it if they hold no record before the tear. Implements
+// raft.LogDb.
+
+const (
+	DefaultSegmentSize = 64 * 1024 * 1024
+	logHeaderSize = 8
+	logSegmentExt = ".log"
+)
+
+var logCrcTable = crc32.MakeTable(crc32.Castagnoli)
+
+type LogStore struct{
+	dir string
+	segmentSize int64
+	// ascending, the last one is appended to
+	segments []*logSegment
+	// files created or removed since the last Fsync()
+	dirDirty bool
+	log *logger.Logger
+}
+
+type logSegment struct{
+	first int64
+	path string
+	fp *os.File
+	// of record first+i, and the end of the last record
+	offsets []int64
+	// written since the last Fsync()
+	dirty bool
+}
+
+var _ raft.LogDb = (*LogStore)(nil)
+
+// records in segment
+func (seg *logSegment)count() int64 {
+	return int64(len(seg.offsets) - 1)
+}
+
+func (seg *logSegment)last() int64 {
+	return seg.first + seg.count() - 1
+}
+
+func (seg *logSegment)size() int64 {
+	return seg.offsets[len(seg.offsets) - 1]
+}
+
+// segmentSize <= 0 for DefaultSegmentSize
+func OpenLogStore(dir string, segmentSize int64) (*LogStore, error) {
+	dir, _ = filepath.Abs(dir)
+	if !util.IsDir(dir) {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+	}
+	if segmentSize <= 0 {
+		segmentSize = DefaultSegmentSize
+	}
+	defaultLog.Info("open LogStore", "dir", dir)
+
+	ls := new(LogStore)
+	ls.dir = dir
+	ls.segmentSize = segmentSize
+	ls.log = defaultLog.With("dir", dir)
+	if err := ls.recover(); err != nil {
+		ls.Close()
+		return nil, err
+	}
+	return ls, nil
+}
+
+func (ls *LogStore)SetLogger(l *logger.Logger){
+	ls.log = l
+}
+
+func (ls *LogStore)Close() {
+	for _, seg := range ls.segments {
+		seg.fp.Close()
+	}
+	ls.segments = nil
+}
+
+func (ls *LogStore)segmentPath(first int64) string {
+	return filepath.Join(ls.dir, fmt.Sprintf("%020d%s", first, logSegmentExt))
+}
+
+func (ls *LogStore)recover() error {
+	names, err := filepath.Glob(filepath.Join(ls.dir, "*" + logSegmentExt))
+	if err != nil {
+		return err
+	}
+	// zero padded, in order of first index
+	sort.Strings(names)
+	firsts := make([]int64, len(names))
+	for i, name := range names {
+		if _, err := fmt.Sscanf(filepath.Base(name), "%d" + logSegmentExt, &firsts[i]); err != nil || firsts[i] <= 0 {
+			return fmt.Errorf("bad segment name: %s", name)
+		}
+	}
+	for i, name := range names {
+		first := firsts[i]
+		// segments after it are empty or of records after index
+		cuttable := func(index int64) bool {
+			for j := i + 1; j < len(names); j ++ {
+				if st, err := os.Stat(names[j]); err != nil || (st.Size() > 0 && firsts[j] <= index) {
+					return false
+				}
+			}
+			return true
+		}
+		seg, err := ls.loadSegment(name, first, i == len(names) - 1, cuttable)
+		if err != nil {
+			return err
+		}
+		if n := len(ls.segments); n > 0 && ls.segments[n - 1].last() + 1 != first {
+			seg.fp.Close()
+			return fmt.Errorf("segment %s does not follow record#%d", name, ls.segments[n - 1].last())
+		}
+		ls.segments = append(ls.segments, seg)
+		if seg.dirty && i < len(names) - 1 {
+			// cut at a tear, no record follows it
+			for _, later := range names[i+1:] {
+				ls.log.Warn("remove segment after torn record", "segment", later, "torn", seg.last() + 1)
+				if err := os.Remove(later); err != nil {
+					return err
+				}
+			}
+			ls.dirDirty = true
+			break
+		}
+	}
+	// left by a crash before the first record is written
+	if n := len(ls.segments); n > 0 && ls.segments[n - 1].count() == 0 {
+		seg := ls.segments[n - 1]
+		ls.segments = ls.segments[:n - 1]
+		seg.fp.Close()
+		os.Remove(seg.path)
+	}
+	ls.log.Info("recovered", "segments", len(ls.segments), "first", ls.FirstIndex(), "last", ls.LastIndex())
+	return nil
+}
+
+// Records of the last segment are checked, sealed ones are only indexed. A
+// torn record is cut off if cuttable(its index).
+func (ls *LogStore)loadSegment(path string, first int64, last bool, cuttable func(index int64) bool) (*logSegment, error) {
+	fp, err := os.OpenFile(path, os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	st, err := fp.Stat()
+	if err != nil {
+		fp.Close()
+		return nil, err
+	}
+	seg := &logSegment{first: first, path: path, fp: fp, offsets: []int64{0}}
+	end := st.Size()
+	var header [logHeaderSize]byte
+	for off := int64(0); off < end; {
+		var data []byte
+		_, err := fp.ReadAt(header[:], off)
+		next := off + logHeaderSize + int64(binary.BigEndian.Uint32(header[0:4]))
+		if err == nil && next > end {
+			err = io.ErrUnexpectedEOF
+		}
+		if err == nil && last {
+			data = make([]byte, next - off - logHeaderSize)
+			_, err = fp.ReadAt(data, off + logHeaderSize)
+			if err == nil && crc32.Checksum(data, logCrcTable) != binary.BigEndian.Uint32(header[4:8]) {
+				err = raft.ErrCorrupted
+			}
+		}
+		if err != nil {
+			if !cuttable(first + seg.count()) {
+				fp.Close()
+				return nil, fmt.Errorf("bad record#%d in %s: %w", first + seg.count(), path, err)
+			}
+			ls.log.Warn("cut torn record", "segment", path, "index", first + seg.count(), "offset", off, "err", err)
+			if err := fp.Truncate(off); err != nil {
+				fp.Close()
+				return nil, err
+			}
+			seg.dirty = true
+			break
+		}
+		off = next
+		seg.offsets = append(seg.offsets, off)
+	}
+	return seg, nil
+}
+
+// 0 if empty
+func (ls *LogStore)FirstIndex() int64 {
+	if len(ls.segments) == 0 {
+		return 0
+	}
+	return ls.segments[0].first
+}
+
+// 0 if empty
+func (ls *LogStore)LastIndex() int64 {
+	if len(ls.segments) == 0 {
+		return 0
+	}
+	return ls.segments[len(ls.segments) - 1].last()
+}
+
+// bytes of segment files
+func (ls *LogStore)Size() int64 {
+	var ret int64
+	for _, seg := range ls.segments {
+		ret += seg.size()
+	}
+	return ret
+}
+
+func (ls *LogStore)segment(index int64) *logSegment {
+	i := sort.Search(len(ls.segments), func(i int) bool {
+		return ls.segments[i].last() >= index
+	})
+	if i == len(ls.segments) || ls.segments[i].first > index {
+		return nil
+	}
+	return ls.segments[i]
+}
+
+// "" if absent
+func (ls *LogStore)Get(index int64) (string, error) {
+	seg := ls.segment(index)
+	if seg == nil {
+		return "", nil
+	}
+	off := seg.offsets[index - seg.first]
+	buf := make([]byte, seg.offsets[index - seg.first + 1] - off)
+	if _, err := seg.fp.ReadAt(buf, off); err != nil {
+		return "", fmt.Errorf("read record#%d: %w", index, err)
+	}
+	data := buf[logHeaderSize:]
+	if crc32.Checksum(data, logCrcTable) != binary.BigEndian.Uint32(buf[4:8]) {
+		return "", fmt.Errorf("record#%d: %w", index, raft.ErrCorrupted)
+	}
+	return string(data), nil
+}
+
+func (ls *LogStore)newSegment(first int64) (*logSegment, error) {
+	path := ls.segmentPath(first)
+	fp, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, err
+	}
+	seg := &logSegment{first: first, path: path, fp: fp, offsets: []int64{0}}
+	ls.segments = append(ls.segments, seg)
+	ls.dirDirty = true
+	return seg, nil
+}
+
+// Appends records of index, index+1..., index must be LastIndex()+1, or any
+// on an empty log.
+func (ls *LogStore)Append(index int64, records []string) error {
+	if len(ls.segments) == 0 {
+		if index <= 0 {
+			return fmt.Errorf("append record#%d", index)
+		}
+		if _, err := ls.newSegment(index); err != nil {
+			return err
+		}
+	} else if last := ls.LastIndex(); index != last + 1 {
+		return fmt.Errorf("append record#%d, last: %d", index, last)
+	}
+
+	seg := ls.segments[len(ls.segments) - 1]
+	var buf []byte
+	var offsets []int64
+	flush := func() error {
+		if len(buf) == 0 {
+			return nil
+		}
+		if _, err := seg.fp.WriteAt(buf, seg.size()); err != nil {
+			// a partial record is cut off on recovery
+			return err
+		}
+		seg.offsets = append(seg.offsets, offsets...)
+		seg.dirty = true
+		buf = buf[:0]
+		offsets = offsets[:0]
+		return nil
+	}
+	for i, rec := range records {
+		end := seg.size() + int64(len(buf))
+		if end > 0 && end + logHeaderSize + int64(len(rec)) > ls.segmentSize {
+			if err := flush(); err != nil {
+				return err
+			}
+			// sealed, never torn once the next one exists
+			if err := ls.Fsync(); err != nil {
+				return err
+			}
+			var err error
+			if seg, err = ls.newSegment(index + int64(i)); err != nil {
+				return err
+			}
+			end = 0
+		}
+		buf = binary.BigEndian.AppendUint32(buf, uint32(len(rec)))
+		buf = binary.BigEndian.AppendUint32(buf, crc32.Checksum([]byte(rec), logCrcTable))
+		buf = append(buf, rec...)
+		offsets = append(offsets, end + logHeaderSize + int64(len(rec)))
+	}
+	return flush()
+}
+
+func (ls *LogStore)removeSegment(seg *logSegment) error {
+	seg.fp.Close()
+	ls.dirDirty = true
+	return os.Remove(seg.path)
+}
+
+// Deletes records from index on.
+func (ls *LogStore)TruncateSuffix(index int64) error {
+	for len(ls.segments) > 0 {
+		seg := ls.segments[len(ls.segments) - 1]
+		if index > seg.last() {
+			return nil
+		}
+		if index > seg.first {
+			if err := seg.fp.Truncate(seg.offsets[index - seg.first]); err != nil {
+				return err
+			}
+			seg.offsets = seg.offsets[:index - seg.first + 1]
+			seg.dirty = true
+			return nil
+		}
+		ls.segments = ls.segments[:len(ls.segments) - 1]
+		if err := ls.removeSegment(seg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Deletes segments all records of which are before index, the last one is
+// kept. Records before index may remain, see FirstIndex().
+func (ls *LogStore)TruncatePrefix(index int64) error {
+	for len(ls.segments) > 1 && ls.segments[0].last() < index {
+		seg := ls.segments[0]
+		ls.segments = ls.segments[1:]
+		if err := ls.removeSegment(seg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ls *LogStore)CleanAll() error {
+	ls.log.Info("clean LogStore")
+	var err error
+	for _, seg := range ls.segments {
+		if e := ls.removeSegment(seg); e != nil && err == nil {
+			err = e
+		}
+	}
+	ls.segments = nil
+	return err
+}
+
+// segments written, then dir if files are created or removed
+func (ls *LogStore)Fsync() error {
+	for _, seg := range ls.segments {
+		if !seg.dirty {
+			continue
+		}
+		if err := seg.fp.Sync(); err != nil {
+			return err
+		}
+		seg.dirty = false
+	}
+	if ls.dirDirty {
+		dir, err := os.Open(ls.dir)
+		if err != nil {
+			return err
+		}
+		err = dir.Sync()
+		dir.Close()
+		if err != nil {
+			return err
+		}
+		ls.dirDirty = false
+	}
+	return nil
+}
+
+// Records in [lo, hi] in order, those of a segment are read by one call.
+func (ls *LogStore)Iterate(lo int64, hi int64, fn func(index int64, record string) bool) error {
+	for _, seg := range ls.segments {
+		if seg.last() < lo || seg.first > hi {
+			continue
+		}
+		start := util.MaxInt64(lo, seg.first)
+		end := util.MinInt64(hi, seg.last())
+		base := seg.offsets[start - seg.first]
+		buf := make([]byte, seg.offsets[end - seg.first + 1] - base)
+		if _, err := seg.fp.ReadAt(buf, base); err != nil {
+			return fmt.Errorf("read records [%d, %d]: %w", start, end, err)
+		}
+		for idx := start; idx <= end; idx ++ {
+			rec := buf[seg.offsets[idx - seg.first] - base : seg.offsets[idx - seg.first + 1] - base]
+			data := rec[logHeaderSize:]
+			if crc32.Checksum(data, logCrcTable) != binary.BigEndian.Uint32(rec[4:8]) {
+				return fmt.Errorf("record#%d: %w", idx, raft.ErrCorrupted)
+			}
+			if !fn(idx, string(data)) {
+				return nil
+			}
+		}
+	}
+	return nil
+}
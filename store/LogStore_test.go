@@ -0,0 +1,246 @@
+package store
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/fallowu/big-ssdb/raft"
+	"github.com/fallowu/big-ssdb/logger"
+)
+
+func logRecords(lo, hi int64) []string {
+	var ret []string
+	for i := lo; i <= hi; i ++ {
+		ret = append(ret, fmt.Sprintf("record %d", i))
+	}
+	return ret
+}
+
+func checkLogStore(t *testing.T, ls *LogStore, first, last int64) {
+	t.Helper()
+	if ls.FirstIndex() != first || ls.LastIndex() != last {
+		t.Fatalf("range [%d, %d], want [%d, %d]", ls.FirstIndex(), ls.LastIndex(), first, last)
+	}
+	for _, idx := range []int64{first, last, (first + last) / 2} {
+		if v, err := ls.Get(idx); err != nil || v != fmt.Sprintf("record %d", idx) {
+			t.Fatal("get", idx, v, err)
+		}
+	}
+	if v, _ := ls.Get(last + 1); v != "" {
+		t.Fatal("get after last", v)
+	}
+}
+
+func TestLogStore(t *testing.T){
+	logger.SetDefaultLevel(logger.LevelError)
+	dir := t.TempDir()
+	ls, err := OpenLogStore(dir, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := int64(1); i <= 1200; i += 100 {
+		if err := ls.Append(i, logRecords(i, i + 99)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ls.Append(1300, logRecords(1300, 1300)); err == nil {
+		t.Fatal("append with a gap")
+	}
+	// past 999 as before it
+	checkLogStore(t, ls, 1, 1200)
+	if v, _ := ls.Get(1000); v != "record 1000" {
+		t.Fatal(v)
+	}
+	var got []string
+	err = ls.Iterate(995, 1005, func(index int64, rec string) bool {
+		got = append(got, rec)
+		return index < 1003
+	})
+	if err != nil || fmt.Sprint(got) != fmt.Sprint(logRecords(995, 1003)) {
+		t.Fatal("iterate", got, err)
+	}
+	if len(ls.segments) < 10 {
+		t.Fatal("segments", len(ls.segments))
+	}
+	for _, seg := range ls.segments[:len(ls.segments) - 1] {
+		if seg.size() > 1000 {
+			t.Fatal("segment size", seg.path, seg.size())
+		}
+	}
+	ls.Fsync()
+	ls.Close()
+
+	ls, err = OpenLogStore(dir, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkLogStore(t, ls, 1, 1200)
+
+	// into a segment, then across segments
+	if err := ls.TruncateSuffix(1150); err != nil {
+		t.Fatal(err)
+	}
+	checkLogStore(t, ls, 1, 1149)
+	if err := ls.TruncateSuffix(900); err != nil {
+		t.Fatal(err)
+	}
+	checkLogStore(t, ls, 1, 899)
+	if err := ls.Append(900, logRecords(900, 1000)); err != nil {
+		t.Fatal(err)
+	}
+	checkLogStore(t, ls, 1, 1000)
+
+	// files of records before 500 are deleted
+	n := len(ls.segments)
+	if err := ls.TruncatePrefix(500); err != nil {
+		t.Fatal(err)
+	}
+	first := ls.FirstIndex()
+	if first <= 1 || first > 500 || len(ls.segments) >= n {
+		t.Fatal("truncate prefix", first, len(ls.segments))
+	}
+	names, _ := filepath.Glob(filepath.Join(dir, "*.log"))
+	if len(names) != len(ls.segments) {
+		t.Fatal("files", names)
+	}
+	checkLogStore(t, ls, first, 1000)
+	tail := ls.segments[len(ls.segments) - 1].path
+	ls.Close()
+
+	// a torn record at the end is cut off
+	fp, _ := os.OpenFile(tail, os.O_WRONLY|os.O_APPEND, 0644)
+	fp.Write([]byte{0, 0, 0, 9, 1, 2, 3, 4, 'r', 'e'})
+	fp.Close()
+	ls, err = OpenLogStore(dir, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ls.Close()
+	checkLogStore(t, ls, first, 1000)
+	if err := ls.Append(1001, logRecords(1001, 1001)); err != nil {
+		t.Fatal(err)
+	}
+	checkLogStore(t, ls, first, 1001)
+
+	if err := ls.CleanAll(); err != nil {
+		t.Fatal(err)
+	}
+	if ls.LastIndex() != 0 || ls.Size() != 0 {
+		t.Fatal("clean", ls.LastIndex(), ls.Size())
+	}
+	// starts anywhere when empty, as after a snapshot
+	if err := ls.Append(5000, logRecords(5000, 5001)); err != nil {
+		t.Fatal(err)
+	}
+	checkLogStore(t, ls, 5000, 5001)
+}
+
+// a segment torn before the next one is started, by old versions, is cut
+// off with the segments after it
+func TestLogStoreTornSealed(t *testing.T){
+	logger.SetDefaultLevel(logger.LevelError)
+	dir := t.TempDir()
+	ls, err := OpenLogStore(dir, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ls.Append(1, logRecords(1, 300)); err != nil {
+		t.Fatal(err)
+	}
+	for _, seg := range ls.segments[:len(ls.segments) - 1] {
+		if seg.dirty {
+			t.Fatal("not fsynced before the next segment", seg.path)
+		}
+	}
+	seg := ls.segments[2]
+	torn, path, size := seg.last(), seg.path, seg.size()
+	ls.Close()
+
+	if err := os.Truncate(path, size - 3); err != nil {
+		t.Fatal(err)
+	}
+	ls, err = OpenLogStore(dir, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ls.Close()
+	checkLogStore(t, ls, 1, torn - 1)
+	if names, _ := filepath.Glob(filepath.Join(dir, "*.log")); len(names) != 3 {
+		t.Fatal("files", names)
+	}
+	if err := ls.Append(torn, logRecords(torn, 300)); err != nil {
+		t.Fatal(err)
+	}
+	ls.Fsync()
+	ls.Close()
+	ls, err = OpenLogStore(dir, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkLogStore(t, ls, 1, 300)
+	ls.Close()
+}
+
+func openLogNode(t *testing.T, dir string, withLog bool) *raft.Node {
+	db := OpenKVStore(dir + "/raft")
+	var opts []raft.Option
+	if withLog {
+		ls, err := OpenLogStore(dir + "/log", 4096)
+		if err != nil {
+			t.Fatal(err)
+		}
+		opts = append(opts, raft.WithLogDb(ls))
+	}
+	return raft.NewNode("n1", "addr1", db, opts...)
+}
+
+func TestLogStoreNode(t *testing.T){
+	logger.SetDefaultLevel(logger.LevelError)
+	for _, old := range []bool{false, true} {
+		dir := t.TempDir()
+		// entries in db, written by an old version
+		node := openLogNode(t, dir, !old)
+		node.AddMember("n1", "addr1")
+		node.StepTick(0)
+		for i := 0; i < 1200; i ++ {
+			if _, _, err := node.Propose(fmt.Sprintf("set k%d v", i)); err != nil {
+				t.Fatal(err)
+			}
+			node.StepTick(0)
+		}
+		m := node.Metrics()
+		node.Close()
+
+		node = openLogNode(t, dir, true)
+		got := node.Metrics()
+		node.Close()
+		if err := node.Err(); err != nil {
+			t.Fatal(err)
+		}
+		if got.LastIndex != m.LastIndex || got.CommitIndex != m.CommitIndex || got.LastIndex != 1202 {
+			t.Fatalf("restart %+v, want %+v", got, m)
+		}
+		db := OpenKVStore(dir + "/raft")
+		for k := range db.All() {
+			if k == "@LogMeta" || k[0] != '@' {
+				t.Fatal("left in db", k)
+			}
+		}
+		db.Close()
+
+		ls, err := OpenLogStore(dir + "/log", 4096)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for idx, want := range map[int64]string{3: "set k0 v", 1001: "set k998 v", 1202: "set k1199 v"} {
+			v, _ := ls.Get(idx)
+			var ent raft.Entry
+			if !ent.Decode(v) || ent.Index != idx || ent.Data != want {
+				t.Fatal("entry", idx, v)
+			}
+		}
+		ls.Close()
+	}
+}
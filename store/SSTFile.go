@@ -2,10 +2,9 @@ package store
 
 import (
 	// "os"
-	"log"
 	"sort"
 	"strings"
-	"util"
+	"github.com/fallowu/big-ssdb/internal/util"
 )
 
 type SSTFile struct{
@@ -78,7 +77,7 @@ func (sst *SSTFile)Seek(key string){
 		r := wal.Item()
 		ps := strings.SplitN(r, " ", 2)
 		if len(ps) != 2 {
-			log.Println("bad record:", r)
+			defaultLog.Warn("bad record", "record", r)
 			continue
 		}
 		if key <= ps[0] {
@@ -102,7 +101,7 @@ func (sst *SSTFile)Read() (string, string){
 	if r := sst.wal.Read(); r != "" {
 		ps := strings.SplitN(r, " ", 2)
 		if len(ps) != 2 {
-			log.Println("bad record:", r)
+			defaultLog.Warn("bad record", "record", r)
 		} else {
 			sst.valid = true
 			sst.key = ps[0]
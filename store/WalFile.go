@@ -6,7 +6,7 @@ import (
 	"path"
 	"bufio"
 
-	"util"
+	"github.com/fallowu/big-ssdb/internal/util"
 )
 
 type WalFile struct{
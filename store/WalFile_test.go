@@ -4,7 +4,7 @@ import (
 	"testing"
 	"os"
 	"path"
-	"util"
+	"github.com/fallowu/big-ssdb/internal/util"
 )
 
 func TestWalFile(t *testing.T){
@@ -0,0 +1,271 @@
+//go:build bolt
+
+// Package boltdb is a raft.Db on bbolt, a B-tree in one file, registered as
+// "bolt" by store.RegisterDb(). Built with -tags bolt, requires
+// go.etcd.io/bbolt.
+package boltdb
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+	"github.com/fallowu/big-ssdb/logger"
+	"github.com/fallowu/big-ssdb/raft"
+	"github.com/fallowu/big-ssdb/store"
+)
+
+var defaultLog = logger.New("bolt")
+
+var (
+	// keys of entries, "log#..."
+	logBucket = []byte("log")
+	// all other keys, @State, @CommitIndex...
+	stateBucket = []byte("state")
+)
+
+const (
+	fileName = "raft.db"
+	// keys read by one transaction of Iterate(), fn is called outside it and
+	// may write
+	iterateChunk = 1000
+)
+
+type Options struct{
+	// commits are not fsynced, Fsync() syncs the file. A power loss before
+	// it may leave the file unreadable, see bolt.DB.NoSync
+	NoSync bool
+}
+
+// Every write is a transaction, so is a batch, state and entries of it are
+// written together.
+type Db struct{
+	path string
+	bdb *bolt.DB
+	log *logger.Logger
+	// of writes, returned by the next Fsync()
+	err error
+}
+
+// opts may be nil
+func Open(dir string, opts *Options) (*Db, error) {
+	if opts == nil {
+		opts = new(Options)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	path := filepath.Join(dir, fileName)
+	bdb, err := bolt.Open(path, 0644, &bolt.Options{Timeout: time.Second, NoSync: opts.NoSync})
+	if err != nil {
+		return nil, fmt.Errorf("open bolt %s: %w", path, err)
+	}
+	err = bdb.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{logBucket, stateBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		bdb.Close()
+		return nil, fmt.Errorf("open bolt %s: %w", path, err)
+	}
+	defaultLog.Info("open bolt", "path", path, "noSync", opts.NoSync)
+	db := new(Db)
+	db.path = path
+	db.bdb = bdb
+	db.log = defaultLog.With("path", path)
+	return db, nil
+}
+
+func (db *Db)SetLogger(l *logger.Logger){
+	db.log = l
+}
+
+func bucketOf(key string) []byte {
+	if strings.HasPrefix(key, "log#") {
+		return logBucket
+	}
+	return stateBucket
+}
+
+func (db *Db)setErr(err error) {
+	if err != nil && db.err == nil {
+		db.log.Error("bolt", "err", err)
+		db.err = err
+	}
+}
+
+func (db *Db)Close(){
+	if err := db.bdb.Close(); err != nil {
+		db.log.Error("close bolt", "err", err)
+	}
+}
+
+func (db *Db)Fsync() error {
+	if err := db.err; err != nil {
+		db.err = nil
+		return err
+	}
+	if db.bdb.NoSync {
+		return db.bdb.Sync()
+	}
+	return nil
+}
+
+func (db *Db)Get(key string) string {
+	var val string
+	db.bdb.View(func(tx *bolt.Tx) error {
+		val = string(tx.Bucket(bucketOf(key)).Get([]byte(key)))
+		return nil
+	})
+	return val
+}
+
+func (db *Db)Set(key string, val string) {
+	b := raft.NewBatch()
+	b.Set(key, val)
+	db.WriteBatch(b)
+}
+
+func (db *Db)Delete(key string) {
+	b := raft.NewBatch()
+	b.Delete(key)
+	db.WriteBatch(b)
+}
+
+func (db *Db)WriteBatch(b *raft.Batch) {
+	err := db.bdb.Update(func(tx *bolt.Tx) error {
+		for _, op := range b.Ops {
+			bk := tx.Bucket(bucketOf(op.Key))
+			var err error
+			if op.Delete {
+				err = bk.Delete([]byte(op.Key))
+			} else {
+				err = bk.Put([]byte(op.Key), []byte(op.Val))
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	db.setErr(err)
+}
+
+// up to n keys from start in [start, end), of both buckets in order
+func (db *Db)scan(start string, end string, n int) [][2]string {
+	var ret [][2]string
+	db.bdb.View(func(tx *bolt.Tx) error {
+		var cs [2]*bolt.Cursor
+		var ks, vs [2][]byte
+		for i, name := range [][]byte{logBucket, stateBucket} {
+			cs[i] = tx.Bucket(name).Cursor()
+			ks[i], vs[i] = cs[i].Seek([]byte(start))
+		}
+		for len(ret) < n {
+			i := -1
+			for j := range cs {
+				if ks[j] != nil && (end == "" || string(ks[j]) < end) && (i < 0 || string(ks[j]) < string(ks[i])) {
+					i = j
+				}
+			}
+			if i < 0 {
+				break
+			}
+			ret = append(ret, [2]string{string(ks[i]), string(vs[i])})
+			ks[i], vs[i] = cs[i].Next()
+		}
+		return nil
+	})
+	return ret
+}
+
+func (db *Db)Iterate(start string, end string, fn func(key string, val string) bool) {
+	for {
+		kvs := db.scan(start, end, iterateChunk)
+		for _, kv := range kvs {
+			if !fn(kv[0], kv[1]) {
+				return
+			}
+		}
+		if len(kvs) < iterateChunk {
+			return
+		}
+		start = kvs[len(kvs) - 1][0] + "\x00"
+	}
+}
+
+func (db *Db)ApproximateSize(start string, end string) int64 {
+	var size int64
+	db.Iterate(start, end, func(key string, val string) bool {
+		size += int64(len(key) + len(val))
+		return true
+	})
+	return size
+}
+
+func (db *Db)CleanAll() {
+	db.log.Info("clean bolt")
+	err := db.bdb.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{logBucket, stateBucket} {
+			if err := tx.DeleteBucket(name); err != nil {
+				return err
+			}
+			if _, err := tx.CreateBucket(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	db.setErr(err)
+}
+
+// Writes a consistent copy of the file to w, a valid bolt file, while writes
+// go on.
+func (db *Db)Backup(w io.Writer) (int64, error) {
+	var n int64
+	err := db.bdb.View(func(tx *bolt.Tx) error {
+		var err error
+		n, err = tx.WriteTo(w)
+		return err
+	})
+	return n, err
+}
+
+// Backup() to path, replaced once the copy is fsynced
+func (db *Db)BackupFile(path string) error {
+	tmp := path + ".TMP"
+	fp, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	n, err := db.Backup(fp)
+	if err == nil {
+		err = fp.Sync()
+	}
+	if cerr := fp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("backup bolt to %s: %w", path, err)
+	}
+	db.log.Info("backup bolt", "file", path, "bytes", n)
+	return nil
+}
+
+func init() {
+	store.RegisterDb("bolt", func(dir string) (raft.Db, error) {
+		return Open(dir, nil)
+	})
+}
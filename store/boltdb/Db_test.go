@@ -0,0 +1,88 @@
+//go:build bolt
+
+package boltdb
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/fallowu/big-ssdb/logger"
+	"github.com/fallowu/big-ssdb/raft"
+)
+
+func TestDb(t *testing.T){
+	logger.SetDefaultLevel(logger.LevelError)
+	dir := t.TempDir()
+	db, err := Open(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := raft.NewBatch()
+	for i := 0; i < 2500; i ++ {
+		b.Set(fmt.Sprintf("log#%04d", i), "e")
+	}
+	b.Set("@State", "s")
+	b.Set("z", "1")
+	b.Delete("log#0005")
+	db.WriteBatch(b)
+	if err := db.Fsync(); err != nil {
+		t.Fatal(err)
+	}
+	// across chunks and buckets, in order
+	var keys []string
+	db.Iterate("", "", func(key string, val string) bool {
+		keys = append(keys, key)
+		return true
+	})
+	if len(keys) != 2501 || keys[0] != "@State" || keys[6] != "log#0006" || keys[2500] != "z" {
+		t.Fatal("iterate", len(keys))
+	}
+	if n := db.ApproximateSize("log#", raft.PrefixEnd("log#")); n != 2499 * 9 {
+		t.Fatal("size", n)
+	}
+
+	backup := filepath.Join(t.TempDir(), fileName)
+	if err := db.BackupFile(backup); err != nil {
+		t.Fatal(err)
+	}
+	db.CleanAll()
+	if err := db.Fsync(); err != nil || db.Get("@State") != "" || db.Get("log#0001") != "" {
+		t.Fatal("clean", err)
+	}
+	db.Close()
+
+	db, err = Open(filepath.Dir(backup), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if db.Get("@State") != "s" || db.Get("log#2499") != "e" || db.Get("log#0005") != "" {
+		t.Fatal("backup")
+	}
+}
+
+// a node restarts from its log and state in bolt
+func TestDbNode(t *testing.T){
+	logger.SetDefaultLevel(logger.LevelError)
+	dir := t.TempDir()
+	db, _ := Open(dir, &Options{NoSync: true})
+	node := raft.NewNode("n1", "addr1", db)
+	node.AddMember("n1", "addr1")
+	node.StepTick(0)
+	for i := 0; i < 100; i ++ {
+		node.Propose(fmt.Sprintf("set k%d v", i))
+		node.StepTick(0)
+	}
+	m := node.Metrics()
+	// closes db
+	node.Close()
+
+	db, _ = Open(dir, nil)
+	node = raft.NewNode("n1", "addr1", db)
+	got := node.Metrics()
+	node.Close()
+	if got.LastIndex != m.LastIndex || got.CommitIndex != m.CommitIndex || got.LastIndex != 102 {
+		t.Fatalf("restart %+v, want %+v", got, m)
+	}
+}
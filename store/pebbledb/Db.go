@@ -0,0 +1,215 @@
+//go:build pebble
+
+// Package pebbledb is a raft.Db on Pebble, for data sets not fitting in
+// memory, registered as "pebble" by store.RegisterDb(). Built with
+// -tags pebble, requires github.com/cockroachdb/pebble.
+package pebbledb
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/cockroachdb/pebble"
+	"github.com/fallowu/big-ssdb/logger"
+	"github.com/fallowu/big-ssdb/raft"
+	"github.com/fallowu/big-ssdb/store"
+)
+
+var defaultLog = logger.New("pebble")
+
+// Writes are not synced, Fsync() syncs the WAL of all of them. Errors of
+// writes are returned by the next Fsync(), as KVStore does.
+type Db struct{
+	dir string
+	pdb *pebble.DB
+	log *logger.Logger
+
+	mux sync.Mutex
+	err error
+}
+
+func Open(dir string) (*Db, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	pdb, err := pebble.Open(dir, &pebble.Options{})
+	if err != nil {
+		return nil, fmt.Errorf("open pebble %s: %w", dir, err)
+	}
+	defaultLog.Info("open pebble", "dir", dir)
+	db := new(Db)
+	db.dir = dir
+	db.pdb = pdb
+	db.log = defaultLog.With("dir", dir)
+	return db, nil
+}
+
+func (db *Db)SetLogger(l *logger.Logger){
+	db.log = l
+}
+
+func (db *Db)setErr(err error) {
+	if err == nil {
+		return
+	}
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	if db.err == nil {
+		db.log.Error("pebble", "err", err)
+		db.err = err
+	}
+}
+
+func (db *Db)Close(){
+	if err := db.pdb.Close(); err != nil {
+		db.log.Error("close pebble", "err", err)
+	}
+}
+
+func (db *Db)Fsync() error {
+	db.mux.Lock()
+	err := db.err
+	db.err = nil
+	db.mux.Unlock()
+	if err != nil {
+		return err
+	}
+	// an empty record, synced with all before it
+	return db.pdb.LogData(nil, pebble.Sync)
+}
+
+func (db *Db)Get(key string) string {
+	v, closer, err := db.pdb.Get([]byte(key))
+	if err != nil {
+		if !errors.Is(err, pebble.ErrNotFound) {
+			db.setErr(err)
+		}
+		return ""
+	}
+	defer closer.Close()
+	return string(v)
+}
+
+func (db *Db)Set(key string, val string) {
+	db.setErr(db.pdb.Set([]byte(key), []byte(val), pebble.NoSync))
+}
+
+func (db *Db)Delete(key string) {
+	db.setErr(db.pdb.Delete([]byte(key), pebble.NoSync))
+}
+
+// atomic, unlike KVStore
+func (db *Db)WriteBatch(b *raft.Batch) {
+	pb := db.pdb.NewBatch()
+	defer pb.Close()
+	for _, op := range b.Ops {
+		if op.Delete {
+			pb.Delete([]byte(op.Key), nil)
+		} else {
+			pb.Set([]byte(op.Key), []byte(op.Val), nil)
+		}
+	}
+	db.setErr(pb.Commit(pebble.NoSync))
+}
+
+func (db *Db)Iterate(start string, end string, fn func(key string, val string) bool) {
+	it := db.NewIterator(start, end)
+	for it.Next() {
+		if !fn(it.Key(), it.Val()) {
+			break
+		}
+	}
+	db.setErr(it.Close())
+}
+
+func (db *Db)NewIterator(start string, end string) raft.Iterator {
+	opts := &pebble.IterOptions{LowerBound: []byte(start)}
+	if end != "" {
+		opts.UpperBound = []byte(end)
+	}
+	pit, err := db.pdb.NewIter(opts)
+	return &iterator{it: pit, err: err}
+}
+
+func (db *Db)ApproximateSize(start string, end string) int64 {
+	var upper []byte
+	if end != "" {
+		upper = []byte(end)
+	} else if last := db.lastKey(); last != nil {
+		upper = append(last, 0)
+	} else {
+		return 0
+	}
+	n, err := db.pdb.EstimateDiskUsage([]byte(start), upper)
+	if err != nil {
+		return 0
+	}
+	return int64(n)
+}
+
+func (db *Db)lastKey() []byte {
+	it, err := db.pdb.NewIter(nil)
+	if err != nil {
+		return nil
+	}
+	defer it.Close()
+	if !it.Last() {
+		return nil
+	}
+	return append([]byte{}, it.Key()...)
+}
+
+// keys are range deleted, files are dropped by compaction
+func (db *Db)CleanAll() {
+	db.log.Info("clean pebble")
+	last := db.lastKey()
+	if last == nil {
+		return
+	}
+	end := append(last, 0)
+	if err := db.pdb.DeleteRange(nil, end, pebble.Sync); err != nil {
+		db.setErr(err)
+		return
+	}
+	db.setErr(db.pdb.Compact(nil, end, true))
+}
+
+type iterator struct{
+	it *pebble.Iterator
+	started bool
+	err error
+}
+
+func (it *iterator)Next() bool {
+	if it.it == nil {
+		return false
+	}
+	if !it.started {
+		it.started = true
+		return it.it.First()
+	}
+	return it.it.Next()
+}
+
+func (it *iterator)Key() string {
+	return string(it.it.Key())
+}
+
+func (it *iterator)Val() string {
+	return string(it.it.Value())
+}
+
+func (it *iterator)Close() error {
+	if it.it == nil {
+		return it.err
+	}
+	return it.it.Close()
+}
+
+func init() {
+	store.RegisterDb("pebble", func(dir string) (raft.Db, error) {
+		return Open(dir)
+	})
+}
@@ -0,0 +1,76 @@
+//go:build pebble
+
+package pebbledb
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/fallowu/big-ssdb/logger"
+	"github.com/fallowu/big-ssdb/raft"
+)
+
+func TestDb(t *testing.T){
+	logger.SetDefaultLevel(logger.LevelError)
+	dir := t.TempDir()
+	db, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := raft.NewBatch()
+	for i := 0; i < 10; i ++ {
+		b.Set(fmt.Sprintf("k%d", i), fmt.Sprintf("v%d", i))
+	}
+	b.Delete("k5")
+	db.WriteBatch(b)
+	if err := db.Fsync(); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	db, err = Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if db.Get("k3") != "v3" || db.Get("k5") != "" {
+		t.Fatal("get", db.Get("k3"), db.Get("k5"))
+	}
+	var keys []string
+	it := raft.NewIterator(db, "k2", "k7")
+	for it.Next() {
+		keys = append(keys, it.Key())
+	}
+	if err := it.Close(); err != nil || fmt.Sprint(keys) != "[k2 k3 k4 k6]" {
+		t.Fatal("iterate", keys, err)
+	}
+	db.CleanAll()
+	if err := db.Fsync(); err != nil || db.Get("k3") != "" {
+		t.Fatal("clean", err)
+	}
+}
+
+// a node restarts from its log and state in pebble
+func TestDbNode(t *testing.T){
+	logger.SetDefaultLevel(logger.LevelError)
+	dir := t.TempDir()
+	db, _ := Open(dir)
+	node := raft.NewNode("n1", "addr1", db)
+	node.AddMember("n1", "addr1")
+	node.StepTick(0)
+	for i := 0; i < 100; i ++ {
+		node.Propose(fmt.Sprintf("set k%d v", i))
+		node.StepTick(0)
+	}
+	m := node.Metrics()
+	// closes db
+	node.Close()
+
+	db, _ = Open(dir)
+	node = raft.NewNode("n1", "addr1", db)
+	got := node.Metrics()
+	node.Close()
+	if got.LastIndex != m.LastIndex || got.CommitIndex != m.CommitIndex || got.LastIndex != 102 {
+		t.Fatalf("restart %+v, want %+v", got, m)
+	}
+}
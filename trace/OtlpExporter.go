@@ -0,0 +1,274 @@
+package trace
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"time"
+	"math/rand"
+	"net/http"
+	"encoding/hex"
+	"encoding/binary"
+	"encoding/json"
+
+	"github.com/fallowu/big-ssdb/logger"
+)
+
+const(
+	DefaultFlushInterval = 1 * time.Second
+	// spans buffered in memory, the newest spans are dropped when full
+	DefaultMaxQueueSpans = 4096
+	// spans per request
+	DefaultMaxBatchSpans = 512
+)
+
+// Tracer recording spans and exporting them to an OTLP/HTTP collector
+// in JSON encoding, e.g. endpoint "http://127.0.0.1:4318/v1/traces".
+type OtlpExporter struct{
+	// fraction of new traces recorded, 1 records all
+	SampleRatio float64
+
+	endpoint string
+	serviceName string
+	client *http.Client
+
+	queue []*recordingSpan
+	dropped int64
+	rand *rand.Rand
+	done chan bool
+	wg sync.WaitGroup
+	log *logger.Logger
+	mux sync.Mutex
+}
+
+func NewOtlpExporter(endpoint string, serviceName string) *OtlpExporter {
+	e := new(OtlpExporter)
+	e.SampleRatio = 1
+	e.endpoint = endpoint
+	e.serviceName = serviceName
+	e.client = &http.Client{Timeout: 5 * time.Second}
+	e.rand = rand.New(rand.NewSource(time.Now().UnixNano()))
+	e.done = make(chan bool)
+	e.log = logger.New("trace")
+
+	e.wg.Add(1)
+	go e.run()
+	return e
+}
+
+// Flush buffered spans and stop exporting
+func (e *OtlpExporter)Close() {
+	close(e.done)
+	e.wg.Wait()
+}
+
+func (e *OtlpExporter)Start(parent SpanContext, name string) Span {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+
+	s := new(recordingSpan)
+	if parent.IsValid() {
+		s.TraceId = parent.TraceId
+		s.ParentSpanId = parent.SpanId
+	} else {
+		if e.rand.Float64() >= e.SampleRatio {
+			return NoopSpan{}
+		}
+		s.TraceId = e.randomId(16)
+	}
+	s.SpanId = e.randomId(8)
+	s.Name = name
+	s.start = time.Now()
+	s.exporter = e
+	return s
+}
+
+func (e *OtlpExporter)randomId(n int) string {
+	b := make([]byte, n)
+	for i := 0; i < n; i += 8 {
+		binary.BigEndian.PutUint64(b[i:], e.rand.Uint64())
+	}
+	return hex.EncodeToString(b)
+}
+
+func (e *OtlpExporter)enqueue(s *recordingSpan) {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	if len(e.queue) >= DefaultMaxQueueSpans {
+		e.dropped ++
+		return
+	}
+	e.queue = append(e.queue, s)
+}
+
+func (e *OtlpExporter)run() {
+	defer e.wg.Done()
+	ticker := time.NewTicker(DefaultFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			e.flush()
+		case <-e.done:
+			e.flush()
+			return
+		}
+	}
+}
+
+func (e *OtlpExporter)flush() {
+	for {
+		e.mux.Lock()
+		n := len(e.queue)
+		if n > DefaultMaxBatchSpans {
+			n = DefaultMaxBatchSpans
+		}
+		batch := e.queue[:n]
+		e.queue = e.queue[n:]
+		dropped := e.dropped
+		e.dropped = 0
+		e.mux.Unlock()
+
+		if dropped > 0 {
+			e.log.Warn("queue full, spans dropped", "count", dropped)
+		}
+		if len(batch) == 0 {
+			return
+		}
+		if err := e.export(batch); err != nil {
+			e.log.Warn("export spans error", "endpoint", e.endpoint, "err", err)
+			return
+		}
+	}
+}
+
+func (e *OtlpExporter)export(spans []*recordingSpan) error {
+	bs, err := json.Marshal(e.encode(spans))
+	if err != nil {
+		return err
+	}
+	resp, err := e.client.Post(e.endpoint, "application/json", bytes.NewReader(bs))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode / 100 != 2 {
+		return fmt.Errorf("http status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+/* ################ OTLP JSON ################ */
+
+type otlpValue struct{
+	StringValue *string `json:"stringValue,omitempty"`
+	IntValue *string `json:"intValue,omitempty"`
+	BoolValue *bool `json:"boolValue,omitempty"`
+}
+
+type otlpAttr struct{
+	Key string `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpSpan struct{
+	TraceId string `json:"traceId"`
+	SpanId string `json:"spanId"`
+	ParentSpanId string `json:"parentSpanId,omitempty"`
+	Name string `json:"name"`
+	Kind int `json:"kind"`
+	StartTimeUnixNano string `json:"startTimeUnixNano"`
+	EndTimeUnixNano string `json:"endTimeUnixNano"`
+	Attributes []otlpAttr `json:"attributes,omitempty"`
+}
+
+func newOtlpAttr(key string, val interface{}) otlpAttr {
+	a := otlpAttr{Key: key}
+	switch v := val.(type) {
+	case int, int32, int64, uint, uint32, uint64:
+		s := fmt.Sprintf("%d", v)
+		a.Value.IntValue = &s
+	case bool:
+		a.Value.BoolValue = &v
+	default:
+		s := fmt.Sprint(v)
+		a.Value.StringValue = &s
+	}
+	return a
+}
+
+func (e *OtlpExporter)encode(spans []*recordingSpan) interface{} {
+	ss := make([]otlpSpan, 0, len(spans))
+	for _, s := range spans {
+		o := otlpSpan{
+			TraceId: s.TraceId,
+			SpanId: s.SpanId,
+			ParentSpanId: s.ParentSpanId,
+			Name: s.Name,
+			Kind: 1, // SPAN_KIND_INTERNAL
+			StartTimeUnixNano: fmt.Sprintf("%d", s.start.UnixNano()),
+			EndTimeUnixNano: fmt.Sprintf("%d", s.end.UnixNano()),
+		}
+		for _, kv := range s.attrs {
+			o.Attributes = append(o.Attributes, newOtlpAttr(kv.key, kv.val))
+		}
+		ss = append(ss, o)
+	}
+	return map[string]interface{}{
+		"resourceSpans": []interface{}{
+			map[string]interface{}{
+				"resource": map[string]interface{}{
+					"attributes": []otlpAttr{newOtlpAttr("service.name", e.serviceName)},
+				},
+				"scopeSpans": []interface{}{
+					map[string]interface{}{
+						"scope": map[string]string{"name": "big-ssdb"},
+						"spans": ss,
+					},
+				},
+			},
+		},
+	}
+}
+
+/* ################ recordingSpan ################ */
+
+type attr struct{
+	key string
+	val interface{}
+}
+
+type recordingSpan struct{
+	TraceId string
+	SpanId string
+	ParentSpanId string
+	Name string
+	start time.Time
+	end time.Time
+	attrs []attr
+	ended bool
+	exporter *OtlpExporter
+	mux sync.Mutex
+}
+
+func (s *recordingSpan)Context() SpanContext {
+	return SpanContext{s.TraceId, s.SpanId}
+}
+
+func (s *recordingSpan)SetAttr(key string, val interface{}) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.attrs = append(s.attrs, attr{key, val})
+}
+
+func (s *recordingSpan)End() {
+	s.mux.Lock()
+	if s.ended {
+		s.mux.Unlock()
+		return
+	}
+	s.ended = true
+	s.end = time.Now()
+	s.mux.Unlock()
+	s.exporter.enqueue(s)
+}
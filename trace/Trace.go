@@ -0,0 +1,74 @@
+package trace
+
+import (
+	"fmt"
+	"strings"
+	"encoding/hex"
+)
+
+// W3C trace context, ids are lower case hex
+type SpanContext struct{
+	TraceId string // 32 hex digits
+	SpanId string  // 16 hex digits
+}
+
+func (sc SpanContext)IsValid() bool {
+	return len(sc.TraceId) == 32 && len(sc.SpanId) == 16
+}
+
+// "00-<trace id>-<span id>-01", "" if invalid
+func (sc SpanContext)Traceparent() string {
+	if !sc.IsValid() {
+		return ""
+	}
+	return fmt.Sprintf("00-%s-%s-01", sc.TraceId, sc.SpanId)
+}
+
+func ParseTraceparent(s string) (SpanContext, bool) {
+	ps := strings.Split(s, "-")
+	if len(ps) != 4 || ps[0] != "00" || len(ps[3]) != 2 {
+		return SpanContext{}, false
+	}
+	sc := SpanContext{ps[1], ps[2]}
+	if !sc.IsValid() || !isHex(sc.TraceId) || !isHex(sc.SpanId) {
+		return SpanContext{}, false
+	}
+	return sc, true
+}
+
+func isHex(s string) bool {
+	_, err := hex.DecodeString(s)
+	return err == nil && strings.ToLower(s) == s
+}
+
+type Span interface{
+	// invalid if span is not recorded(e.g. not sampled)
+	Context() SpanContext
+	SetAttr(key string, val interface{})
+	End()
+}
+
+type Tracer interface{
+	// Start a child span of parent, or a new trace if parent is invalid
+	Start(parent SpanContext, name string) Span
+}
+
+/* ################ Noop ################ */
+
+type NoopTracer struct{}
+
+func (NoopTracer)Start(parent SpanContext, name string) Span {
+	return NoopSpan{}
+}
+
+type NoopSpan struct{}
+
+func (NoopSpan)Context() SpanContext {
+	return SpanContext{}
+}
+
+func (NoopSpan)SetAttr(key string, val interface{}) {
+}
+
+func (NoopSpan)End() {
+}
@@ -0,0 +1,61 @@
+package trace
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"net/http"
+	"net/http/httptest"
+)
+
+func TestTraceparent(t *testing.T){
+	sc := SpanContext{"0af7651916cd43dd8448eb211c80319c", "b7ad6b7169203331"}
+	s := sc.Traceparent()
+	if s != "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01" {
+		t.Fatal(s)
+	}
+	sc2, ok := ParseTraceparent(s)
+	if !ok || sc2 != sc {
+		t.Fatal("parse failed", sc2)
+	}
+	if _, ok := ParseTraceparent("00-xyz-b7ad6b7169203331-01"); ok {
+		t.Fatal("bad traceparent accepted")
+	}
+	if (SpanContext{}).Traceparent() != "" {
+		t.Fatal("invalid context encoded")
+	}
+}
+
+func TestOtlpExporter(t *testing.T){
+	bodies := make(chan string, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request){
+		bs, _ := io.ReadAll(r.Body)
+		bodies <- string(bs)
+	}))
+	defer srv.Close()
+
+	e := NewOtlpExporter(srv.URL, "test")
+	root := e.Start(SpanContext{}, "command set")
+	child := e.Start(root.Context(), "raft.replicate")
+	child.SetAttr("index", int64(3))
+	if child.Context().TraceId != root.Context().TraceId {
+		t.Fatal("trace id not inherited")
+	}
+	child.End()
+	root.End()
+	e.Close()
+
+	body := <-bodies
+	for _, s := range []string{`"name":"command set"`, `"parentSpanId":"` + root.Context().SpanId, `"intValue":"3"`} {
+		if !strings.Contains(body, s) {
+			t.Fatal(s, "not found in", body)
+		}
+	}
+
+	e = NewOtlpExporter(srv.URL, "test")
+	e.SampleRatio = 0
+	if e.Start(SpanContext{}, "x").Context().IsValid() {
+		t.Fatal("unsampled span recorded")
+	}
+	e.Close()
+}